@@ -68,6 +68,14 @@ CREATE TABLE IF NOT EXISTS manifests (
     repair_digest TEXT,
     runtime_url  TEXT,
     pack_urls    TEXT[],
+    sbom_url     TEXT,
+    provenance_url TEXT,
+    plan_id      BIGINT,
+    run_id       TEXT,
+    builder_id   TEXT,
+    wheel_source_digest TEXT,
+    repair_tool_version TEXT,
+    repair_policy_hash  TEXT,
     python_tag   TEXT,
     platform_tag TEXT,
     status       TEXT,
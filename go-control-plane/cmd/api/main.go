@@ -9,7 +9,10 @@ import (
 
 func main() {
 	cfg := config.FromEnv()
-	svc := server.New(cfg)
+	svc, err := server.New(cfg)
+	if err != nil {
+		log.Fatalf("server init failed: %v", err)
+	}
 	if err := svc.Start(); err != nil {
 		log.Fatalf("server exited: %v", err)
 	}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/k8ika0s/s390x-wheel-refinery/go-control-plane/internal/compat"
+	"github.com/k8ika0s/s390x-wheel-refinery/go-control-plane/internal/wheelname"
+)
+
+// wheelVerdict is one wheel's result in a compatibility audit.
+type wheelVerdict struct {
+	Filename   string `json:"filename"`
+	Compatible bool   `json:"compatible"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// auditCompatibility reports, per wheel, whether it installs on the current
+// target (settings python version and platform tag) using the same rules the
+// planner applies for reuse. Wheels come from the request body or from a
+// wheel-upload pending input.
+func (h *Handler) auditCompatibility(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	var body struct {
+		Wheels         []string `json:"wheels"`
+		PendingInputID int64    `json:"pending_input_id,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid json")
+		return
+	}
+	files := body.Wheels
+	if body.PendingInputID != 0 {
+		if h.Store == nil {
+			writeError(w, http.StatusInternalServerError, codeBackendUnavailable, "store not configured")
+			return
+		}
+		inputs, err := h.Store.ListPendingInputs(r.Context(), "")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		found := false
+		for _, pi := range inputs {
+			if pi.ID != body.PendingInputID {
+				continue
+			}
+			if pi.SourceType != "wheel" {
+				writeError(w, http.StatusBadRequest, codeInvalidInput, fmt.Sprintf("pending input %d is not a wheel upload", pi.ID))
+				return
+			}
+			files = append(files, pi.Filename)
+			found = true
+		}
+		if !found {
+			writeError(w, http.StatusNotFound, codeNotFound, "pending input not found")
+			return
+		}
+	}
+	if len(files) == 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "wheels or pending_input_id required")
+		return
+	}
+	s, err := h.loadSettings(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	pyTag := pythonTag(s.PythonVersion)
+	verdicts := make([]wheelVerdict, 0, len(files))
+	for _, file := range files {
+		v := wheelVerdict{Filename: file}
+		if wheel, err := wheelname.Parse(file); err != nil {
+			v.Reason = err.Error()
+		} else {
+			v.Compatible, v.Reason = compat.Check(wheel, pyTag, s.PlatformTag)
+		}
+		verdicts = append(verdicts, v)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"python_tag":   pyTag,
+		"platform_tag": s.PlatformTag,
+		"wheels":       verdicts,
+	})
+}
+
+// pythonTag turns a settings python version (3.11) into its CPython tag
+// (cp311), matching how the planner targets builds.
+func pythonTag(version string) string {
+	if strings.HasPrefix(version, "cp") {
+		return version
+	}
+	return "cp" + strings.ReplaceAll(version, ".", "")
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/k8ika0s/s390x-wheel-refinery/go-control-plane/internal/config"
+	"github.com/k8ika0s/s390x-wheel-refinery/go-control-plane/internal/settings"
+)
+
+// Sources reported for each effective config value.
+const (
+	sourceEnv     = "env"
+	sourceDB      = "db"
+	sourceDefault = "default"
+)
+
+const redactedValue = "***"
+
+// effectiveValue is one tunable's resolved value and where it came from.
+type effectiveValue struct {
+	Value  any    `json:"value"`
+	Source string `json:"source"`
+}
+
+// effectiveConfig resolves every tunable the control plane reads. Env-backed
+// values report env when their variable is set; settings-backed values report
+// db when the stored value differs from the settings default. Stored settings
+// win over AUTO_PLAN/AUTO_BUILD at startup, so those report the stored value.
+func effectiveConfig(cfg config.Config, stored settings.Settings) map[string]effectiveValue {
+	fromEnv := func(key string, value any) effectiveValue {
+		if os.Getenv(key) != "" {
+			return effectiveValue{Value: value, Source: sourceEnv}
+		}
+		return effectiveValue{Value: value, Source: sourceDefault}
+	}
+	secret := func(key, value string) effectiveValue {
+		ev := fromEnv(key, "")
+		if value != "" {
+			ev.Value = redactedValue
+		}
+		return ev
+	}
+	out := map[string]effectiveValue{
+		"http_addr":                   fromEnv("HTTP_ADDR", cfg.HTTPAddr),
+		"postgres_dsn":                fromEnv("POSTGRES_DSN", redactSecrets(cfg.PostgresDSN)),
+		"queue_backend":               fromEnv("QUEUE_BACKEND", cfg.QueueBackend),
+		"queue_file":                  fromEnv("QUEUE_FILE", cfg.QueueFile),
+		"redis_url":                   fromEnv("REDIS_URL", redactSecrets(cfg.RedisURL)),
+		"redis_key":                   fromEnv("REDIS_KEY", cfg.RedisKey),
+		"plan_redis_key":              fromEnv("PLAN_REDIS_KEY", cfg.PlanRedisKey),
+		"kafka_brokers":               fromEnv("KAFKA_BROKERS", cfg.KafkaBrokers),
+		"kafka_topic":                 fromEnv("KAFKA_TOPIC", cfg.KafkaTopic),
+		"worker_token":                secret("WORKER_TOKEN", cfg.WorkerToken),
+		"build_lease_timeout_sec":     fromEnv("BUILD_LEASE_TIMEOUT_SEC", cfg.BuildLeaseTimeout),
+		"lease_single_flight":         fromEnv("LEASE_SINGLE_FLIGHT", cfg.LeaseSingleFlight),
+		"lease_fair_runs":             fromEnv("LEASE_FAIR_RUNS", cfg.LeaseFairRuns),
+		"log_chunk_max":               fromEnv("LOG_CHUNK_MAX", cfg.LogChunkMax),
+		"object_store_endpoint":       fromEnv("OBJECT_STORE_ENDPOINT", cfg.ObjectStoreEndpoint),
+		"object_store_bucket":         fromEnv("OBJECT_STORE_BUCKET", cfg.ObjectStoreBucket),
+		"object_store_access_key":     secret("OBJECT_STORE_ACCESS_KEY", cfg.ObjectStoreAccess),
+		"object_store_secret_key":     secret("OBJECT_STORE_SECRET_KEY", cfg.ObjectStoreSecret),
+		"input_retention_sec":         fromEnv("INPUT_RETENTION_SEC", cfg.InputRetentionSec),
+		"input_purge_interval_sec":    fromEnv("INPUT_PURGE_INTERVAL_SEC", cfg.InputPurgeInterval),
+		"event_retention_sec":         fromEnv("EVENT_RETENTION_SEC", cfg.EventRetentionSec),
+		"event_rollup_interval_sec":   fromEnv("EVENT_ROLLUP_INTERVAL_SEC", cfg.EventRollupInterval),
+		"upload_scanner_url":          fromEnv("UPLOAD_SCANNER_URL", redactSecrets(cfg.ScannerURL)),
+		"report_schedule":             fromEnv("REPORT_SCHEDULE", cfg.ReportSchedule),
+		"plan_reconcile_interval_sec": fromEnv("PLAN_RECONCILE_INTERVAL_SEC", cfg.ReconcileInterval),
+		"max_requirements_bytes":      fromEnv("MAX_REQUIREMENTS_BYTES", cfg.MaxRequirementsBytes),
+		"max_wheel_bytes":             fromEnv("MAX_WHEEL_BYTES", cfg.MaxWheelBytes),
+	}
+
+	defaults := settings.ApplyDefaults(settings.Settings{})
+	stored = settings.ApplyDefaults(stored)
+	fromDB := func(value, def any) effectiveValue {
+		if value != def {
+			return effectiveValue{Value: value, Source: sourceDB}
+		}
+		return effectiveValue{Value: value, Source: sourceDefault}
+	}
+	out["auto_plan"] = fromDB(settings.BoolValue(stored.AutoPlan), settings.BoolValue(defaults.AutoPlan))
+	out["auto_build"] = fromDB(settings.BoolValue(stored.AutoBuild), settings.BoolValue(defaults.AutoBuild))
+	out["plan_pool_size"] = fromDB(stored.PlanPoolSize, defaults.PlanPoolSize)
+	out["build_pool_size"] = fromDB(stored.BuildPoolSize, defaults.BuildPoolSize)
+	out["max_plan_nodes"] = fromDB(stored.MaxPlanNodes, defaults.MaxPlanNodes)
+	out["python_version"] = fromDB(stored.PythonVersion, defaults.PythonVersion)
+	out["platform_tag"] = fromDB(stored.PlatformTag, defaults.PlatformTag)
+	out["poll_ms"] = fromDB(stored.PollMs, defaults.PollMs)
+	out["recent_limit"] = fromDB(stored.RecentLimit, defaults.RecentLimit)
+	out["webhook_min_attempts"] = fromDB(stored.WebhookMinAttempts, defaults.WebhookMinAttempts)
+	out["smtp_digest_sec"] = fromDB(stored.SMTPDigestSec, defaults.SMTPDigestSec)
+	out["smtp_password"] = storedSecret(stored.SMTPPassword)
+	out["webhook_secret"] = storedSecret(stored.WebhookSecret)
+	out["index_password"] = storedSecret(stored.IndexPassword)
+	return out
+}
+
+// storedSecret reports whether a write-only setting is stored without
+// exposing it.
+func storedSecret(value string) effectiveValue {
+	if value == "" {
+		return effectiveValue{Value: "", Source: sourceDefault}
+	}
+	return effectiveValue{Value: redactedValue, Source: sourceDB}
+}
+
+// redactSecrets masks the password embedded in a URL-shaped value such as a
+// Redis URL or DSN, keeping the rest readable (redis://:***@host:6379/0).
+// Values without a password are returned unchanged.
+func redactSecrets(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	if _, ok := u.User.Password(); !ok {
+		return raw
+	}
+	// url.URL escapes "*" in userinfo, so swap Redacted's placeholder instead.
+	return strings.Replace(u.Redacted(), ":xxxxx@", ":"+redactedValue+"@", 1)
+}
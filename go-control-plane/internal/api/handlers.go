@@ -1,19 +1,32 @@
 package api
 
 import (
+	"archive/tar"
 	"archive/zip"
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/sha512"
 	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"os/exec"
+	"path"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -24,6 +37,7 @@ import (
 	"github.com/k8ika0s/s390x-wheel-refinery/go-control-plane/internal/queue"
 	"github.com/k8ika0s/s390x-wheel-refinery/go-control-plane/internal/settings"
 	"github.com/k8ika0s/s390x-wheel-refinery/go-control-plane/internal/store"
+	"github.com/k8ika0s/s390x-wheel-refinery/go-control-plane/internal/wheelname"
 	"golang.org/x/net/websocket"
 	"gopkg.in/yaml.v3"
 )
@@ -34,62 +48,102 @@ type Handler struct {
 	Queue      queue.Backend
 	PlanQ      queue.PlanQueueBackend
 	InputStore objectstore.Store
+	Scanner    InputScanner
 	Config     config.Config
 	logHubOnce sync.Once
 	logHub     *logHub
+	mailMu     sync.Mutex
+	mailQueue  []buildOutcome
+	mailSender sendMailFunc
+}
+
+// route pairs a ServeMux pattern with its handler so the same table can
+// drive registration and the OpenAPI document.
+type route struct {
+	pattern string
+	handler http.HandlerFunc
+}
+
+func (h *Handler) routeTable() []route {
+	return []route{
+		{"/api/health", h.health},
+		{"/api/ready", h.ready},
+		{"/api/metrics", h.metrics},
+		{"/metrics", h.promMetrics},
+		{"/api/config", h.config},
+		{"/api/settings", h.settings},
+		{"/api/report/summary", h.reportSummary},
+		{"/api/audit/compatibility", h.auditCompatibility},
+		{"/api/settings/index-credentials", h.indexCredentials},
+		{"/api/pending-inputs", h.pendingInputs},
+		{"/api/pending-inputs/clear", h.pendingInputsClear},
+		{"/api/pending-inputs/purge", h.pendingInputsPurge},
+		{"/api/pending-inputs/", h.pendingInputAction},
+		{"/api/pending-inputs/pop", h.pendingInputPop},
+		{"/api/pending-inputs/status/", h.pendingInputStatus},
+		{"/api/plan-queue", h.planQueueList},
+		{"/api/plan-queue/clear", h.planQueueClear},
+		{"/api/requirements/upload", h.requirementsUpload},
+		{"/api/constraints/upload", h.constraintsUpload},
+		{"/api/wheels/upload", h.wheelsUpload},
+		{"/api/sdists/upload", h.sdistUpload},
+		{"/api/builds", h.builds},
+		{"/api/builds/status", h.buildStatusUpdate},
+		{"/api/builds/transition", h.buildsTransition},
+		{"/api/builds/approve-fix", h.buildsApproveFix},
+		{"/api/builds/cancel", h.buildsCancel},
+		{"/api/builds/apply-recipes", h.buildsApplyRecipes},
+		{"/api/build-queue/pop", h.buildQueuePop},
+		{"/api/session/token", h.sessionToken},
+		{"/api/summary", h.summary},
+		{"/api/recent", h.recent},
+		{"/api/history", h.history},
+		{"/api/history/bulk", h.historyBulk},
+		{"/api/package/", h.packageSummary},
+		{"/api/packages/search", h.packageSearch},
+		{"/api/event/", h.eventByVersion},
+		{"/api/failures", h.failures},
+		{"/api/failures/categories", h.failureCategories},
+		{"/api/variants/", h.variants},
+		{"/api/top-failures", h.topFailures},
+		{"/api/top-slowest", h.topSlowest},
+		{"/api/plan", h.plan},
+		{"/api/plan/latest", h.planLatest},
+		{"/api/plan/", h.planByID},
+		{"/api/plans", h.plans},
+		{"/api/plan/compute", h.planCompute},
+		{"/api/plan/preview", h.planPreview},
+		{"/api/plan/import", h.planImport},
+		{"/api/manifest", h.manifest},
+		{"/api/manifest/", h.manifestRebuild},
+		{"/api/manifest/lookup", h.manifestLookup},
+		{"/api/artifacts", h.artifacts},
+		{"/api/artifacts/referenced", h.artifactsReferenced},
+		{"/api/queue", h.queueList},
+		{"/api/queue/stats", h.queueStats},
+		{"/api/queue/enqueue", h.queueEnqueue},
+		{"/api/queue/clear", h.queueClear},
+		{"/api/hints", h.hints},
+		{"/api/hints/bulk", h.hintsBulk},
+		{"/api/hints/effectiveness", h.hintsEffectiveness},
+		{"/api/hints/", h.hintByID},
+		{"/api/logs/", h.logsByNameVersion},
+		{"/api/logs/search", h.logsSearch},
+		{"/api/logs", h.logsIngest},
+		{"/api/logs/stream/", h.logsStream},
+		{"/api/logs/chunks/", h.logsChunks},
+		{"/api/workers", h.workers},
+		{"/api/worker/heartbeat", h.workerHeartbeat},
+		{"/api/worker/trigger", h.workerTrigger},
+		{"/api/worker/smoke", h.workerSmoke},
+		{"/api/openapi.json", h.openAPI},
+	}
 }
 
 func (h *Handler) Routes(mux *http.ServeMux) {
-	mux.HandleFunc("/api/health", h.health)
-	mux.HandleFunc("/api/ready", h.ready)
-	mux.HandleFunc("/api/metrics", h.metrics)
-	mux.HandleFunc("/metrics", h.promMetrics)
-	mux.HandleFunc("/api/config", h.config)
-	mux.HandleFunc("/api/settings", h.settings)
-	mux.HandleFunc("/api/pending-inputs", h.pendingInputs)
-	mux.HandleFunc("/api/pending-inputs/clear", h.pendingInputsClear)
-	mux.HandleFunc("/api/pending-inputs/", h.pendingInputAction)
-	mux.HandleFunc("/api/pending-inputs/pop", h.pendingInputPop)
-	mux.HandleFunc("/api/pending-inputs/status/", h.pendingInputStatus)
-	mux.HandleFunc("/api/plan-queue/clear", h.planQueueClear)
-	mux.HandleFunc("/api/requirements/upload", h.requirementsUpload)
-	mux.HandleFunc("/api/wheels/upload", h.wheelsUpload)
-	mux.HandleFunc("/api/builds", h.builds)
-	mux.HandleFunc("/api/builds/status", h.buildStatusUpdate)
-	mux.HandleFunc("/api/build-queue/pop", h.buildQueuePop)
-	mux.HandleFunc("/api/session/token", h.sessionToken)
-	mux.HandleFunc("/api/summary", h.summary)
-	mux.HandleFunc("/api/recent", h.recent)
-	mux.HandleFunc("/api/history", h.history)
-	mux.HandleFunc("/api/package/", h.packageSummary)
-	mux.HandleFunc("/api/event/", h.eventByVersion)
-	mux.HandleFunc("/api/failures", h.failures)
-	mux.HandleFunc("/api/variants/", h.variants)
-	mux.HandleFunc("/api/top-failures", h.topFailures)
-	mux.HandleFunc("/api/top-slowest", h.topSlowest)
-	mux.HandleFunc("/api/plan", h.plan)
-	mux.HandleFunc("/api/plan/latest", h.planLatest)
-	mux.HandleFunc("/api/plan/", h.planByID)
-	mux.HandleFunc("/api/plans", h.plans)
-	mux.HandleFunc("/api/plan/compute", h.planCompute)
-	mux.HandleFunc("/api/manifest", h.manifest)
-	mux.HandleFunc("/api/artifacts", h.artifacts)
-	mux.HandleFunc("/api/queue", h.queueList)
-	mux.HandleFunc("/api/queue/stats", h.queueStats)
-	mux.HandleFunc("/api/queue/enqueue", h.queueEnqueue)
-	mux.HandleFunc("/api/queue/clear", h.queueClear)
-	mux.HandleFunc("/api/hints", h.hints)
-	mux.HandleFunc("/api/hints/bulk", h.hintsBulk)
-	mux.HandleFunc("/api/hints/", h.hintByID)
-	mux.HandleFunc("/api/logs/", h.logsByNameVersion)
-	mux.HandleFunc("/api/logs/search", h.logsSearch)
-	mux.HandleFunc("/api/logs", h.logsIngest)
-	mux.HandleFunc("/api/logs/stream/", h.logsStream)
-	mux.HandleFunc("/api/logs/chunks/", h.logsChunks)
-	mux.HandleFunc("/api/workers", h.workers)
-	mux.HandleFunc("/api/worker/heartbeat", h.workerHeartbeat)
-	mux.HandleFunc("/api/worker/trigger", h.workerTrigger)
-	mux.HandleFunc("/api/worker/smoke", h.workerSmoke)
+	for _, rt := range h.routeTable() {
+		mux.HandleFunc(rt.pattern, rt.handler)
+	}
 }
 
 func (h *Handler) health(w http.ResponseWriter, r *http.Request) {
@@ -107,7 +161,7 @@ func (h *Handler) ready(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
 	defer cancel()
 	if err := h.ping(ctx); err != nil {
-		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unready", "error": err.Error()})
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unready", "error": err.Error(), "code": codeBackendUnavailable})
 		return
 	}
 	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
@@ -115,7 +169,7 @@ func (h *Handler) ready(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) metrics(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	type queueMetrics struct {
@@ -129,14 +183,16 @@ func (h *Handler) metrics(w http.ResponseWriter, r *http.Request) {
 		Error  string `json:"error,omitempty"`
 	}
 	type pendingMetrics struct {
-		Count     int `json:"count"`
-		PlanQueue int `json:"plan_queue"`
+		Count              int   `json:"count"`
+		PlanQueue          int   `json:"plan_queue"`
+		PlanQueueOldestSec int64 `json:"plan_queue_oldest_seconds"`
 	}
 	type buildMetrics struct {
 		Length       int   `json:"length"`
 		OldestAgeSec int64 `json:"oldest_age_seconds,omitempty"`
 		Pending      int   `json:"pending"`
 		Retry        int   `json:"retry"`
+		Cancelled    int   `json:"cancelled"`
 	}
 	type hintMetrics struct {
 		Count int `json:"count"`
@@ -152,7 +208,7 @@ func (h *Handler) metrics(w http.ResponseWriter, r *http.Request) {
 
 	sum := store.Summary{StatusCounts: map[string]int{}, Failures: []store.Event{}}
 	if h.Store != nil {
-		if s, err := h.Store.Summary(ctx, 10); err == nil {
+		if s, err := h.Store.Summary(ctx, 10, 0, 0); err == nil {
 			sum = s
 		}
 	}
@@ -182,6 +238,13 @@ func (h *Handler) metrics(w http.ResponseWriter, r *http.Request) {
 			pm.PlanQueue = int(n)
 		}
 	}
+	if pq, ok := h.PlanQ.(interface {
+		OldestAge(context.Context) (int64, error)
+	}); ok && pq != nil {
+		if age, err := pq.OldestAge(ctx); err == nil {
+			pm.PlanQueueOldestSec = age
+		}
+	}
 
 	// DB metrics
 	dbm := dbMetrics{Status: "unknown"}
@@ -203,6 +266,7 @@ func (h *Handler) metrics(w http.ResponseWriter, r *http.Request) {
 			buildStats.OldestAgeSec = stats.OldestAgeSec
 			buildStats.Pending = stats.Pending
 			buildStats.Retry = stats.Retry
+			buildStats.Cancelled = stats.Cancelled
 		}
 	}
 	hm := hintMetrics{}
@@ -251,12 +315,12 @@ func (h *Handler) metrics(w http.ResponseWriter, r *http.Request) {
 		poolPlan = s.PlanPoolSize
 		poolBuild = s.BuildPoolSize
 	}
-	writeJSON(w, http.StatusOK, map[string]any{
-		"summary": map[string]any{
-			"title":       "Control-plane metrics",
-			"description": "Queue depth, DB health, and recent failure snapshot for quick triage.",
-			"updated_at":  time.Now().Unix(),
-		},
+	summary := map[string]any{
+		"title":       "Control-plane metrics",
+		"description": "Queue depth, DB health, and recent failure snapshot for quick triage.",
+	}
+	body := map[string]any{
+		"summary":         summary,
 		"queue":           qm,
 		"pending":         pm,
 		"build":           buildStats,
@@ -269,18 +333,34 @@ func (h *Handler) metrics(w http.ResponseWriter, r *http.Request) {
 		"auto_build":      h.Config.AutoBuild,
 		"plan_pool_size":  poolPlan,
 		"build_pool_size": poolBuild,
-	})
+	}
+	// Queue ages and the last heartbeat move on every poll even when nothing
+	// changed, so the ETag hashes the body without them; updated_at is
+	// stamped after hashing for the same reason.
+	stable := make(map[string]any, len(body))
+	for k, v := range body {
+		stable[k] = v
+	}
+	stableQueue, stablePending, stableBuild, stableWorkers := qm, pm, buildStats, wm
+	stableQueue.OldestAgeSec = 0
+	stablePending.PlanQueueOldestSec = 0
+	stableBuild.OldestAgeSec = 0
+	stableWorkers.LatestSeenSec = 0
+	stable["queue"], stable["pending"], stable["build"], stable["workers"] = stableQueue, stablePending, stableBuild, stableWorkers
+	tag := weakETag(stable)
+	summary["updated_at"] = time.Now().Unix()
+	writeJSONWithETag(w, r, tag, body)
 }
 
 // promMetrics exposes a simple Prometheus text exposition for quick scrapes.
 func (h *Handler) promMetrics(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
 	defer cancel()
-	sum, _ := h.Store.Summary(ctx, 10)
+	sum, _ := h.Store.Summary(ctx, 10, 0, 0)
 	qstats, _ := h.Queue.Stats(ctx)
 	buildStats, _ := h.Queue.Stats(ctx)
 	poolPlan := 0
@@ -329,6 +409,15 @@ func (h *Handler) promMetrics(w http.ResponseWriter, r *http.Request) {
 			fmt.Fprintf(&buf, "refinery_plan_queue_length %d\n", n)
 		}
 	}
+	if pq, ok := h.PlanQ.(interface {
+		OldestAge(context.Context) (int64, error)
+	}); ok && pq != nil {
+		if age, err := pq.OldestAge(ctx); err == nil {
+			fmt.Fprintf(&buf, "# HELP refinery_plan_queue_oldest_seconds Age in seconds of the oldest item waiting for planning.\n")
+			fmt.Fprintf(&buf, "# TYPE refinery_plan_queue_oldest_seconds gauge\n")
+			fmt.Fprintf(&buf, "refinery_plan_queue_oldest_seconds %d\n", age)
+		}
+	}
 	if list, err := h.Store.ListPendingInputs(ctx, ""); err == nil {
 		fmt.Fprintf(&buf, "# HELP refinery_pending_inputs_total Pending uploads awaiting planning.\n")
 		fmt.Fprintf(&buf, "# TYPE refinery_pending_inputs_total gauge\n")
@@ -374,12 +463,12 @@ func (h *Handler) promMetrics(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) sessionToken(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	token := r.URL.Query().Get("token")
 	if token == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "token required"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "token required")
 		return
 	}
 	http.SetCookie(w, &http.Cookie{
@@ -394,17 +483,22 @@ func (h *Handler) sessionToken(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) config(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	currentSettings := settings.Load(h.Config.SettingsPath)
 	autoPlan := settings.BoolValue(currentSettings.AutoPlan)
 	autoBuild := settings.BoolValue(currentSettings.AutoBuild)
+	stored, err := h.loadSettings(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
 	writeJSON(w, http.StatusOK, map[string]any{
 		"http_addr":        h.Config.HTTPAddr,
 		"queue_backend":    h.Config.QueueBackend,
 		"queue_file":       h.Config.QueueFile,
-		"redis_url":        h.Config.RedisURL,
+		"redis_url":        redactSecrets(h.Config.RedisURL),
 		"redis_key":        h.Config.RedisKey,
 		"plan_redis_key":   h.Config.PlanRedisKey,
 		"kafka_brokers":    h.Config.KafkaBrokers,
@@ -413,16 +507,17 @@ func (h *Handler) config(w http.ResponseWriter, r *http.Request) {
 		"worker_webhook":   h.Config.WorkerWebhookURL != "",
 		"worker_local_cmd": h.Config.WorkerLocalCmd != "",
 		"input_object": map[string]any{
-			"endpoint": h.Config.ObjectStoreEndpoint,
+			"endpoint": redactSecrets(h.Config.ObjectStoreEndpoint),
 			"bucket":   h.Config.ObjectStoreBucket,
 			"prefix":   h.Config.InputObjectPrefix,
 		},
 		"settings_path": h.Config.SettingsPath,
 		"hints_dir":     h.Config.HintsDir,
 		"hints_seed":    h.Config.SeedHints,
-		"settings":      currentSettings,
+		"settings":      settings.Redact(currentSettings),
 		"auto_plan":     autoPlan,
 		"auto_build":    autoBuild,
+		"effective":     effectiveConfig(h.Config, stored),
 	})
 }
 
@@ -430,9 +525,6 @@ func lintRequirements(data []byte) error {
 	if len(data) == 0 {
 		return fmt.Errorf("empty file")
 	}
-	if len(data) > 128*1024 {
-		return fmt.Errorf("file too large (>128KB)")
-	}
 	if bytes.IndexByte(data, 0) >= 0 {
 		return fmt.Errorf("file contains null bytes")
 	}
@@ -455,16 +547,9 @@ func lintRequirements(data []byte) error {
 }
 
 type requirementSpec struct {
-	Name    string `json:"name"`
-	Version string `json:"version,omitempty"`
-}
-
-type wheelMeta struct {
-	Name        string `json:"name"`
-	Version     string `json:"version"`
-	PythonTag   string `json:"python_tag"`
-	AbiTag      string `json:"abi_tag"`
-	PlatformTag string `json:"platform_tag"`
+	Name    string   `json:"name"`
+	Version string   `json:"version,omitempty"`
+	Hashes  []string `json:"hashes,omitempty"`
 }
 
 func normalizeName(name string) string {
@@ -488,8 +573,26 @@ func looksLikeHTMLOrScript(data []byte) bool {
 }
 
 func parseRequirements(data []byte) []requirementSpec {
-	lines := strings.Split(string(data), "\n")
-	out := make([]requirementSpec, 0, len(lines))
+	out, _ := parseRequirementsBundle(data, nil)
+	return out
+}
+
+// parseRequirementsBundle parses a requirements file, following -r /
+// --requirement includes through files, which maps base filenames to their
+// contents. Includes that are missing from files (or would loop) are
+// returned as unresolved instead of being parsed as package names.
+func parseRequirementsBundle(data []byte, files map[string][]byte) ([]requirementSpec, []string) {
+	var out []requirementSpec
+	var unresolved []string
+	parseRequirementLines(data, files, map[string]bool{}, &out, &unresolved)
+	if out == nil {
+		out = []requirementSpec{}
+	}
+	return out, unresolved
+}
+
+func parseRequirementLines(data []byte, files map[string][]byte, seen map[string]bool, out *[]requirementSpec, unresolved *[]string) {
+	lines := joinRequirementLines(string(data))
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
 		if line == "" || strings.HasPrefix(line, "#") {
@@ -498,9 +601,21 @@ func parseRequirements(data []byte) []requirementSpec {
 		if strings.HasPrefix(line, "-c ") || strings.HasPrefix(line, "--constraint") {
 			continue
 		}
+		if include, ok := requirementInclude(line); ok {
+			name := path.Base(include)
+			body, found := files[name]
+			if !found || seen[name] {
+				*unresolved = append(*unresolved, include)
+				continue
+			}
+			seen[name] = true
+			parseRequirementLines(body, files, seen, out, unresolved)
+			continue
+		}
 		if idx := strings.Index(line, " #"); idx != -1 {
 			line = strings.TrimSpace(line[:idx])
 		}
+		line, hashes := splitRequirementHashes(line)
 		name := line
 		version := ""
 		for _, op := range []string{"==", ">=", "~="} {
@@ -517,30 +632,73 @@ func parseRequirements(data []byte) []requirementSpec {
 		if name == "" {
 			continue
 		}
-		out = append(out, requirementSpec{Name: name, Version: version})
+		*out = append(*out, requirementSpec{Name: name, Version: version, Hashes: hashes})
+	}
+}
+
+// joinRequirementLines splits a requirements file into logical lines,
+// joining backslash continuations the way pip does.
+func joinRequirementLines(text string) []string {
+	var out []string
+	var cur strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if strings.HasSuffix(line, "\\") {
+			cur.WriteString(strings.TrimSuffix(line, "\\"))
+			cur.WriteString(" ")
+			continue
+		}
+		cur.WriteString(line)
+		out = append(out, cur.String())
+		cur.Reset()
+	}
+	if cur.Len() > 0 {
+		out = append(out, cur.String())
 	}
 	return out
 }
 
-func parseWheelFilename(name string) (wheelMeta, error) {
-	base := strings.TrimSuffix(name, ".whl")
-	parts := strings.Split(base, "-")
-	if len(parts) < 5 {
-		return wheelMeta{}, fmt.Errorf("invalid wheel filename: %s", name)
-	}
-	platform := parts[len(parts)-1]
-	abi := parts[len(parts)-2]
-	py := parts[len(parts)-3]
-	version := parts[len(parts)-4]
-	pkgParts := parts[:len(parts)-4]
-	pkg := strings.ReplaceAll(strings.Join(pkgParts, "-"), "_", "-")
-	return wheelMeta{
-		Name:        pkg,
-		Version:     version,
-		PythonTag:   py,
-		AbiTag:      abi,
-		PlatformTag: platform,
-	}, nil
+// splitRequirementHashes removes --hash options from a requirement line and
+// returns them as "algo:hex" values.
+func splitRequirementHashes(line string) (string, []string) {
+	if !strings.Contains(line, "--hash") {
+		return line, nil
+	}
+	fields := strings.Fields(line)
+	kept := make([]string, 0, len(fields))
+	var hashes []string
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		switch {
+		case strings.HasPrefix(f, "--hash="):
+			hashes = append(hashes, strings.TrimPrefix(f, "--hash="))
+		case f == "--hash" && i+1 < len(fields):
+			i++
+			hashes = append(hashes, fields[i])
+		default:
+			kept = append(kept, f)
+		}
+	}
+	return strings.Join(kept, " "), hashes
+}
+
+// requirementInclude returns the target of a -r / --requirement line.
+func requirementInclude(line string) (string, bool) {
+	if idx := strings.Index(line, " #"); idx != -1 {
+		line = strings.TrimSpace(line[:idx])
+	}
+	var rest string
+	switch {
+	case strings.HasPrefix(line, "--requirement"):
+		rest = strings.TrimPrefix(line, "--requirement")
+		rest = strings.TrimPrefix(strings.TrimSpace(rest), "=")
+	case strings.HasPrefix(line, "-r"):
+		rest = strings.TrimPrefix(line, "-r")
+	default:
+		return "", false
+	}
+	rest = strings.TrimSpace(rest)
+	return rest, rest != ""
 }
 
 func parseRequiresDist(meta string) []requirementSpec {
@@ -635,6 +793,387 @@ func validateWheelArchive(data []byte) error {
 	return nil
 }
 
+// verifyWheelRecord checks every archive member against the hashes and sizes
+// listed in the wheel's .dist-info/RECORD, rejecting tampered or padded
+// wheels. RECORD itself and its signature files are exempt.
+func verifyWheelRecord(data []byte) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("invalid wheel archive: %w", err)
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	var recordFile *zip.File
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		files[f.Name] = f
+		dir, base := path.Split(f.Name)
+		if base == "RECORD" && strings.HasSuffix(dir, ".dist-info/") && strings.Count(dir, "/") == 1 {
+			if recordFile != nil {
+				return fmt.Errorf("wheel has more than one RECORD")
+			}
+			recordFile = f
+		}
+	}
+	if recordFile == nil {
+		return fmt.Errorf("wheel RECORD missing")
+	}
+	rc, err := recordFile.Open()
+	if err != nil {
+		return fmt.Errorf("read RECORD: %w", err)
+	}
+	rows, err := csv.NewReader(rc).ReadAll()
+	rc.Close()
+	if err != nil {
+		return fmt.Errorf("parse RECORD: %w", err)
+	}
+	recordDir := path.Dir(recordFile.Name) + "/"
+	listed := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		if len(row) == 0 || row[0] == "" {
+			continue
+		}
+		name := row[0]
+		listed[name] = true
+		digest, size := "", ""
+		if len(row) > 1 {
+			digest = row[1]
+		}
+		if len(row) > 2 {
+			size = row[2]
+		}
+		if digest == "" {
+			if name == recordFile.Name || strings.HasPrefix(name, recordDir+"RECORD.") {
+				continue
+			}
+			return fmt.Errorf("RECORD has no hash for %s", name)
+		}
+		f, ok := files[name]
+		if !ok {
+			return fmt.Errorf("RECORD lists missing file %s", name)
+		}
+		if err := checkRecordEntry(f, digest, size); err != nil {
+			return err
+		}
+	}
+	for name := range files {
+		if listed[name] || name == recordFile.Name || strings.HasPrefix(name, recordDir+"RECORD.") {
+			continue
+		}
+		return fmt.Errorf("file %s not listed in RECORD", name)
+	}
+	return nil
+}
+
+func checkRecordEntry(f *zip.File, digest, size string) error {
+	alg, want, ok := strings.Cut(digest, "=")
+	if !ok {
+		return fmt.Errorf("RECORD hash for %s is malformed", f.Name)
+	}
+	var h hash.Hash
+	switch alg {
+	case "sha256":
+		h = sha256.New()
+	case "sha384":
+		h = sha512.New384()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return fmt.Errorf("RECORD hash for %s uses unsupported algorithm %s", f.Name, alg)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("read %s: %w", f.Name, err)
+	}
+	n, err := io.Copy(h, rc)
+	rc.Close()
+	if err != nil {
+		return fmt.Errorf("read %s: %w", f.Name, err)
+	}
+	if base64.RawURLEncoding.EncodeToString(h.Sum(nil)) != strings.TrimRight(want, "=") {
+		return fmt.Errorf("hash mismatch for %s", f.Name)
+	}
+	if size != "" && size != strconv.FormatInt(n, 10) {
+		return fmt.Errorf("size mismatch for %s", f.Name)
+	}
+	return nil
+}
+
+type sdistMeta struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// sdistFiles are the metadata files read from the top-level directory of an sdist.
+var sdistFiles = map[string]bool{"PKG-INFO": true, "setup.cfg": true, "pyproject.toml": true}
+
+func isSdistFilename(name string) bool {
+	lower := strings.ToLower(name)
+	return strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".zip")
+}
+
+// readSdistFiles returns PKG-INFO, setup.cfg, and pyproject.toml from the
+// top-level directory of a .tar.gz or .zip sdist.
+func readSdistFiles(filename string, data []byte) (map[string][]byte, error) {
+	out := make(map[string][]byte)
+	take := func(name string, r io.Reader) error {
+		if strings.HasPrefix(name, "/") || strings.Contains(name, "..") {
+			return fmt.Errorf("sdist contains unsafe path: %s", name)
+		}
+		parts := strings.Split(strings.TrimPrefix(name, "./"), "/")
+		if len(parts) != 2 || !sdistFiles[parts[1]] {
+			return nil
+		}
+		if _, ok := out[parts[1]]; ok {
+			return nil
+		}
+		buf, err := io.ReadAll(io.LimitReader(r, 1<<20))
+		if err != nil {
+			return fmt.Errorf("read %s: %w", name, err)
+		}
+		out[parts[1]] = buf
+		return nil
+	}
+	if strings.HasSuffix(strings.ToLower(filename), ".zip") {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid sdist archive: %w", err)
+		}
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				return nil, fmt.Errorf("read %s: %w", f.Name, err)
+			}
+			err = take(f.Name, rc)
+			rc.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid sdist archive: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid sdist archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := take(hdr.Name, tr); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// parseSdist extracts name, version, and dependencies from an sdist. PKG-INFO
+// wins; pyproject.toml [project] and setup.cfg [metadata]/[options] fill any
+// gaps, and the filename is the last resort for name and version.
+func parseSdist(filename string, data []byte) (sdistMeta, []requirementSpec, error) {
+	files, err := readSdistFiles(filename, data)
+	if err != nil {
+		return sdistMeta{}, nil, err
+	}
+	var meta sdistMeta
+	var reqs []requirementSpec
+	if pkgInfo, ok := files["PKG-INFO"]; ok {
+		header := string(pkgInfo)
+		if idx := strings.Index(header, "\n\n"); idx != -1 {
+			header = header[:idx]
+		}
+		for _, line := range strings.Split(header, "\n") {
+			key, val, ok := strings.Cut(line, ":")
+			if !ok {
+				continue
+			}
+			switch strings.ToLower(strings.TrimSpace(key)) {
+			case "name":
+				meta.Name = strings.TrimSpace(val)
+			case "version":
+				meta.Version = strings.TrimSpace(val)
+			}
+		}
+		reqs = parseRequiresDist(header)
+	}
+	if pyproject, ok := files["pyproject.toml"]; ok {
+		name, version, deps := parsePyprojectProject(string(pyproject))
+		meta = fillSdistMeta(meta, name, version)
+		if len(reqs) == 0 {
+			reqs = parseDependencyList(deps)
+		}
+	}
+	if setupCfg, ok := files["setup.cfg"]; ok {
+		name, version, deps := parseSetupCfg(string(setupCfg))
+		meta = fillSdistMeta(meta, name, version)
+		if len(reqs) == 0 {
+			reqs = parseDependencyList(deps)
+		}
+	}
+	if meta.Name == "" || meta.Version == "" {
+		base := filename
+		for _, ext := range []string{".tar.gz", ".zip"} {
+			if strings.HasSuffix(strings.ToLower(base), ext) {
+				base = base[:len(base)-len(ext)]
+			}
+		}
+		if idx := strings.LastIndex(base, "-"); idx > 0 {
+			meta = fillSdistMeta(meta, base[:idx], base[idx+1:])
+		}
+	}
+	if meta.Name == "" || meta.Version == "" {
+		return sdistMeta{}, nil, fmt.Errorf("sdist name/version not found in %s", filename)
+	}
+	meta.Name = normalizeName(meta.Name)
+	return meta, reqs, nil
+}
+
+func fillSdistMeta(meta sdistMeta, name, version string) sdistMeta {
+	if meta.Name == "" {
+		meta.Name = name
+	}
+	if meta.Version == "" {
+		meta.Version = version
+	}
+	return meta
+}
+
+// parseDependencyList parses PEP 508 strings, dropping environment markers.
+func parseDependencyList(deps []string) []requirementSpec {
+	lines := make([]string, 0, len(deps))
+	for _, d := range deps {
+		if semi := strings.Index(d, ";"); semi != -1 {
+			d = d[:semi]
+		}
+		lines = append(lines, d)
+	}
+	return parseRequirements([]byte(strings.Join(lines, "\n")))
+}
+
+// parsePyprojectProject reads name, version, and dependencies from the
+// [project] table. Only the string and string-array forms PEP 621 uses are
+// understood; a dynamic version comes back empty.
+func parsePyprojectProject(doc string) (name, version string, deps []string) {
+	inProject := false
+	inDeps := false
+	for _, raw := range strings.Split(doc, "\n") {
+		line := strings.TrimSpace(raw)
+		if inDeps {
+			deps = append(deps, tomlStrings(line)...)
+			if strings.HasSuffix(line, "]") {
+				inDeps = false
+			}
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			inProject = line == "[project]"
+			continue
+		}
+		if !inProject {
+			continue
+		}
+		key, val, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		val = strings.TrimSpace(val)
+		switch strings.TrimSpace(key) {
+		case "name":
+			name = tomlString(val)
+		case "version":
+			version = tomlString(val)
+		case "dependencies":
+			if !strings.HasPrefix(val, "[") {
+				continue
+			}
+			deps = append(deps, tomlStrings(val)...)
+			inDeps = !strings.HasSuffix(val, "]")
+		}
+	}
+	return name, version, deps
+}
+
+func tomlString(v string) string {
+	if vals := tomlStrings(v); len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+// tomlStrings returns the quoted strings on a line, so commas inside a
+// specifier such as "foo>=1,<2" stay intact.
+func tomlStrings(line string) []string {
+	var out []string
+	for {
+		start := strings.IndexAny(line, "\"'")
+		if start == -1 {
+			return out
+		}
+		quote := line[start]
+		end := strings.IndexByte(line[start+1:], quote)
+		if end == -1 {
+			return out
+		}
+		out = append(out, line[start+1:start+1+end])
+		line = line[start+end+2:]
+	}
+}
+
+// parseSetupCfg reads [metadata] name/version and [options] install_requires,
+// including indented continuation lines.
+func parseSetupCfg(doc string) (name, version string, deps []string) {
+	section := ""
+	inRequires := false
+	for _, raw := range strings.Split(doc, "\n") {
+		line := strings.TrimSpace(raw)
+		if inRequires && line != "" && (raw[0] == ' ' || raw[0] == '\t') {
+			if !strings.HasPrefix(line, "#") {
+				deps = append(deps, line)
+			}
+			continue
+		}
+		inRequires = false
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.Trim(line, "[]")
+			continue
+		}
+		key, val, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		val = strings.TrimSpace(val)
+		switch {
+		case section == "metadata" && key == "name":
+			name = val
+		case section == "metadata" && key == "version" && !strings.HasPrefix(val, "attr:") && !strings.HasPrefix(val, "file:"):
+			version = val
+		case section == "options" && key == "install_requires":
+			if val != "" {
+				deps = append(deps, val)
+			}
+			inRequires = true
+		}
+	}
+	return name, version, deps
+}
+
 func inputObjectKey(prefix, digestHex, filename string) string {
 	clean := strings.TrimSpace(filename)
 	if clean == "" {
@@ -649,143 +1188,421 @@ func inputObjectKey(prefix, digestHex, filename string) string {
 	return fmt.Sprintf("%s/%s/%s", base, digestHex, clean)
 }
 
-func (h *Handler) requirementsUpload(w http.ResponseWriter, r *http.Request) {
+// uploadIndexURL returns the optional per-input package index from the
+// index_url form field. The planner resolves the input against it instead of
+// the worker's INDEX_URL.
+func uploadIndexURL(r *http.Request) (string, error) {
+	raw := strings.TrimSpace(r.FormValue("index_url"))
+	if raw == "" {
+		return "", nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("index_url must be an absolute http(s) URL")
+	}
+	if u.User != nil {
+		return "", fmt.Errorf("index_url must not embed credentials")
+	}
+	return raw, nil
+}
+
+var pythonVersionPattern = regexp.MustCompile(`^[0-9]+\.[0-9]+$`)
+
+// uploadPythonVersion returns the optional python_version form field: one
+// version such as 3.11, or a comma list such as 3.10,3.11 to plan a matrix.
+// The planner targets it instead of the worker's PYTHON_VERSION.
+func uploadPythonVersion(r *http.Request) (string, error) {
+	raw := strings.TrimSpace(r.FormValue("python_version"))
+	if raw == "" {
+		return "", nil
+	}
+	versions := strings.Split(raw, ",")
+	for i, v := range versions {
+		versions[i] = strings.TrimSpace(v)
+		if !pythonVersionPattern.MatchString(versions[i]) {
+			return "", fmt.Errorf("python_version must look like 3.11 or 3.10,3.11")
+		}
+	}
+	return strings.Join(versions, ","), nil
+}
+
+// constraintsPrefix is where uploaded constraints files live in the input
+// object store.
+func (h *Handler) constraintsPrefix() string {
+	base := strings.Trim(h.Config.InputObjectPrefix, "/")
+	if base == "" {
+		return "constraints"
+	}
+	return base + "/constraints"
+}
+
+// uploadConstraintsKey returns the optional constraints_key form field, which
+// must name a file stored by /api/constraints/upload.
+func (h *Handler) uploadConstraintsKey(r *http.Request) (string, error) {
+	key := strings.TrimSpace(r.FormValue("constraints_key"))
+	if key == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(key, h.constraintsPrefix()+"/") || strings.Contains(key, "..") {
+		return "", fmt.Errorf("constraints_key must come from /api/constraints/upload")
+	}
+	return key, nil
+}
+
+// constraintsUpload stores a pip constraints file in the input object store.
+// The returned constraints_key is passed to a later input upload so the
+// planner pins transitive dependencies for that input.
+func (h *Handler) constraintsUpload(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	if h.Config.ObjectStoreEndpoint == "" || h.Config.ObjectStoreBucket == "" {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "input store not configured"})
+		writeError(w, http.StatusInternalServerError, codeBackendUnavailable, "input store not configured")
 		return
 	}
 	if h.InputStore == nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "input store unavailable"})
+		writeError(w, http.StatusInternalServerError, codeBackendUnavailable, "input store unavailable")
 		return
 	}
 	if _, ok := h.InputStore.(objectstore.NullStore); ok {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "input store unavailable"})
+		writeError(w, http.StatusInternalServerError, codeBackendUnavailable, "input store unavailable")
 		return
 	}
 	if err := r.ParseMultipartForm(256 << 10); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid form"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid form")
 		return
 	}
 	file, header, err := r.FormFile("file")
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "file required"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "file required")
 		return
 	}
 	defer file.Close()
-	data, err := io.ReadAll(io.LimitReader(file, 256<<10))
+	data, err := readUpload(file, h.maxRequirementsBytes())
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "failed to read file"})
+		writeUploadReadError(w, err)
 		return
 	}
 	if err := lintRequirements(data); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, err.Error())
 		return
 	}
 	if looksLikeHTMLOrScript(data) {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "file appears to contain HTML/script content"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "file appears to contain HTML/script content")
+		return
+	}
+	if !h.scanUpload(w, r, data) {
 		return
 	}
 	sum := sha256.Sum256(data)
 	digestHex := hex.EncodeToString(sum[:])
-	key := inputObjectKey(h.Config.InputObjectPrefix, digestHex, header.Filename)
+	key := inputObjectKey(h.constraintsPrefix(), digestHex, header.Filename)
 	if err := h.InputStore.Put(r.Context(), key, data, "text/plain"); err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
-	meta := map[string]any{
-		"type":         "requirements",
-		"requirements": parseRequirements(data),
-	}
-	metaJSON, _ := json.Marshal(meta)
-	pi := store.PendingInput{
-		Filename:     header.Filename,
-		Digest:       "sha256:" + digestHex,
-		SizeBytes:    int64(len(data)),
-		Status:       "pending",
-		SourceType:   "requirements",
-		ObjectBucket: h.Config.ObjectStoreBucket,
-		ObjectKey:    key,
-		ContentType:  "text/plain",
-		Metadata:     metaJSON,
-	}
-	var pendingID int64
-	if h.Store != nil {
-		if id, err := h.Store.AddPendingInput(r.Context(), pi); err == nil {
-			pendingID = id
-			if h.Config.AutoPlan && h.PlanQ != nil {
-				_ = h.PlanQ.Enqueue(r.Context(), fmt.Sprintf("%d", pendingID))
-				_ = h.Store.UpdatePendingInputStatus(r.Context(), pendingID, "planning", "")
-			}
-		}
-	}
 	writeJSON(w, http.StatusOK, map[string]any{
-		"detail":     "requirements uploaded",
-		"bytes":      len(data),
-		"filename":   header.Filename,
-		"object_key": key,
-		"pending_id": pendingID,
+		"detail":          "constraints uploaded",
+		"bytes":           len(data),
+		"filename":        header.Filename,
+		"digest":          "sha256:" + digestHex,
+		"constraints_key": key,
+		"constraints":     len(parseRequirements(data)),
 	})
 }
 
-func (h *Handler) wheelsUpload(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) requirementsUpload(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	if h.Config.ObjectStoreEndpoint == "" || h.Config.ObjectStoreBucket == "" {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "input store not configured"})
+		writeError(w, http.StatusInternalServerError, codeBackendUnavailable, "input store not configured")
 		return
 	}
 	if h.InputStore == nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "input store unavailable"})
+		writeError(w, http.StatusInternalServerError, codeBackendUnavailable, "input store unavailable")
 		return
 	}
 	if _, ok := h.InputStore.(objectstore.NullStore); ok {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "input store unavailable"})
+		writeError(w, http.StatusInternalServerError, codeBackendUnavailable, "input store unavailable")
 		return
 	}
 	if err := r.ParseMultipartForm(256 << 10); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid form"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid form")
 		return
 	}
-	file, header, err := r.FormFile("file")
+	indexURL, err := uploadIndexURL(r)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "file required"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, err.Error())
 		return
 	}
-	defer file.Close()
-	if !strings.HasSuffix(strings.ToLower(header.Filename), ".whl") {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "wheel file (.whl) required"})
+	pythonVersion, err := uploadPythonVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, err.Error())
 		return
 	}
-	data, err := io.ReadAll(io.LimitReader(file, 256<<20))
+	constraintsKey, err := h.uploadConstraintsKey(r)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "failed to read file"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, err.Error())
 		return
 	}
-	if err := validateWheelArchive(data); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "file required")
 		return
 	}
-	sum := sha256.Sum256(data)
-	digestHex := hex.EncodeToString(sum[:])
-	key := inputObjectKey(h.Config.InputObjectPrefix, digestHex, header.Filename)
+	defer file.Close()
+	data, err := readUpload(file, h.maxRequirementsBytes())
+	if err != nil {
+		writeUploadReadError(w, err)
+		return
+	}
+	if err := lintRequirements(data); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, err.Error())
+		return
+	}
+	if looksLikeHTMLOrScript(data) {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "file appears to contain HTML/script content")
+		return
+	}
+	includes, err := readRequirementIncludes(r, h.maxRequirementsBytes())
+	if errors.Is(err, errUploadTooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, codeTooLarge, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, err.Error())
+		return
+	}
+	reqs, unresolved := parseRequirementsBundle(data, includes)
+	if !h.scanUpload(w, r, data) {
+		return
+	}
+	// Includes feed the planner through the parsed requirements, so they
+	// pass the same scan as the file that names them.
+	names := make([]string, 0, len(includes))
+	for name := range includes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !h.scanUpload(w, r, includes[name]) {
+			return
+		}
+	}
+	sum := sha256.Sum256(data)
+	digestHex := hex.EncodeToString(sum[:])
+	key := inputObjectKey(h.Config.InputObjectPrefix, digestHex, header.Filename)
+	if err := h.InputStore.Put(r.Context(), key, data, "text/plain"); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	meta := map[string]any{
+		"type":         "requirements",
+		"requirements": reqs,
+	}
+	if len(unresolved) > 0 {
+		meta["unresolved_includes"] = unresolved
+	}
+	if indexURL != "" {
+		meta["index_url"] = indexURL
+	}
+	if pythonVersion != "" {
+		meta["python_version"] = pythonVersion
+	}
+	if constraintsKey != "" {
+		meta["constraints_key"] = constraintsKey
+	}
+	metaJSON, _ := json.Marshal(meta)
+	pi := store.PendingInput{
+		Filename:     header.Filename,
+		Digest:       "sha256:" + digestHex,
+		SizeBytes:    int64(len(data)),
+		Status:       "pending",
+		SourceType:   "requirements",
+		ObjectBucket: h.Config.ObjectStoreBucket,
+		ObjectKey:    key,
+		ContentType:  "text/plain",
+		Metadata:     metaJSON,
+	}
+	var pendingID int64
+	if h.Store != nil {
+		if id, err := h.Store.AddPendingInput(r.Context(), pi); err == nil {
+			pendingID = id
+			if h.Config.AutoPlan && h.PlanQ != nil {
+				_ = h.PlanQ.Enqueue(r.Context(), fmt.Sprintf("%d", pendingID))
+				_ = h.Store.UpdatePendingInputStatus(r.Context(), pendingID, "planning", "")
+			}
+		}
+	}
+	resp := map[string]any{
+		"detail":     "requirements uploaded",
+		"bytes":      len(data),
+		"filename":   header.Filename,
+		"object_key": key,
+		"pending_id": pendingID,
+	}
+	if len(unresolved) > 0 {
+		resp["unresolved_includes"] = unresolved
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+var errUploadTooLarge = errors.New("upload too large")
+
+// readUpload reads an uploaded file, failing with errUploadTooLarge rather
+// than silently truncating when it is longer than limit bytes.
+func readUpload(f io.Reader, limit int) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(f, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > limit {
+		return nil, fmt.Errorf("%w: file exceeds the %d byte limit", errUploadTooLarge, limit)
+	}
+	return data, nil
+}
+
+func writeUploadReadError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errUploadTooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, codeTooLarge, err.Error())
+		return
+	}
+	writeError(w, http.StatusBadRequest, codeInvalidInput, "failed to read file")
+}
+
+func (h *Handler) maxRequirementsBytes() int {
+	if h.Config.MaxRequirementsBytes > 0 {
+		return h.Config.MaxRequirementsBytes
+	}
+	return config.DefaultMaxRequirementsBytes
+}
+
+func (h *Handler) maxWheelBytes() int {
+	if h.Config.MaxWheelBytes > 0 {
+		return h.Config.MaxWheelBytes
+	}
+	return config.DefaultMaxWheelBytes
+}
+
+// readRequirementIncludes reads the optional "include" parts of a
+// requirements upload, keyed by base filename, so -r lines in the main file
+// can be resolved against files uploaded alongside it.
+func readRequirementIncludes(r *http.Request, limit int) (map[string][]byte, error) {
+	if r.MultipartForm == nil {
+		return nil, nil
+	}
+	headers := r.MultipartForm.File["include"]
+	if len(headers) > 20 {
+		return nil, fmt.Errorf("too many include files (>20)")
+	}
+	files := make(map[string][]byte, len(headers))
+	for _, fh := range headers {
+		f, err := fh.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read include %s", fh.Filename)
+		}
+		data, err := readUpload(f, limit)
+		f.Close()
+		if errors.Is(err, errUploadTooLarge) {
+			return nil, fmt.Errorf("include %s: %w", fh.Filename, err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read include %s", fh.Filename)
+		}
+		if err := lintRequirements(data); err != nil {
+			return nil, fmt.Errorf("include %s: %v", fh.Filename, err)
+		}
+		if looksLikeHTMLOrScript(data) {
+			return nil, fmt.Errorf("include %s appears to contain HTML/script content", fh.Filename)
+		}
+		files[path.Base(fh.Filename)] = data
+	}
+	return files, nil
+}
+
+func (h *Handler) wheelsUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if h.Config.ObjectStoreEndpoint == "" || h.Config.ObjectStoreBucket == "" {
+		writeError(w, http.StatusInternalServerError, codeBackendUnavailable, "input store not configured")
+		return
+	}
+	if h.InputStore == nil {
+		writeError(w, http.StatusInternalServerError, codeBackendUnavailable, "input store unavailable")
+		return
+	}
+	if _, ok := h.InputStore.(objectstore.NullStore); ok {
+		writeError(w, http.StatusInternalServerError, codeBackendUnavailable, "input store unavailable")
+		return
+	}
+	if err := r.ParseMultipartForm(256 << 10); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid form")
+		return
+	}
+	indexURL, err := uploadIndexURL(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, err.Error())
+		return
+	}
+	pythonVersion, err := uploadPythonVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, err.Error())
+		return
+	}
+	constraintsKey, err := h.uploadConstraintsKey(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, err.Error())
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "file required")
+		return
+	}
+	defer file.Close()
+	if !strings.HasSuffix(strings.ToLower(header.Filename), ".whl") {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "wheel file (.whl) required")
+		return
+	}
+	data, err := readUpload(file, h.maxWheelBytes())
+	if err != nil {
+		writeUploadReadError(w, err)
+		return
+	}
+	if err := validateWheelArchive(data); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, err.Error())
+		return
+	}
+	// RECORD verification rehashes every member, so it is opt-in.
+	if r.URL.Query().Get("verify") == "true" {
+		if err := verifyWheelRecord(data); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidInput, err.Error())
+			return
+		}
+	}
+	if !h.scanUpload(w, r, data) {
+		return
+	}
+	sum := sha256.Sum256(data)
+	digestHex := hex.EncodeToString(sum[:])
+	key := inputObjectKey(h.Config.InputObjectPrefix, digestHex, header.Filename)
 	if err := h.InputStore.Put(r.Context(), key, data, "application/octet-stream"); err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
-	wmeta, err := parseWheelFilename(header.Filename)
+	wmeta, err := wheelname.Parse(header.Filename)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, err.Error())
 		return
 	}
 	reqs, err := readWheelMetadata(data)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, err.Error())
 		return
 	}
 	meta := map[string]any{
@@ -793,6 +1610,15 @@ func (h *Handler) wheelsUpload(w http.ResponseWriter, r *http.Request) {
 		"wheel":    wmeta,
 		"requires": reqs,
 	}
+	if indexURL != "" {
+		meta["index_url"] = indexURL
+	}
+	if pythonVersion != "" {
+		meta["python_version"] = pythonVersion
+	}
+	if constraintsKey != "" {
+		meta["constraints_key"] = constraintsKey
+	}
 	metaJSON, _ := json.Marshal(meta)
 	pi := store.PendingInput{
 		Filename:     header.Filename,
@@ -824,6 +1650,123 @@ func (h *Handler) wheelsUpload(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+func (h *Handler) sdistUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if h.Config.ObjectStoreEndpoint == "" || h.Config.ObjectStoreBucket == "" {
+		writeError(w, http.StatusInternalServerError, codeBackendUnavailable, "input store not configured")
+		return
+	}
+	if h.InputStore == nil {
+		writeError(w, http.StatusInternalServerError, codeBackendUnavailable, "input store unavailable")
+		return
+	}
+	if _, ok := h.InputStore.(objectstore.NullStore); ok {
+		writeError(w, http.StatusInternalServerError, codeBackendUnavailable, "input store unavailable")
+		return
+	}
+	if err := r.ParseMultipartForm(256 << 10); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid form")
+		return
+	}
+	indexURL, err := uploadIndexURL(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, err.Error())
+		return
+	}
+	pythonVersion, err := uploadPythonVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, err.Error())
+		return
+	}
+	constraintsKey, err := h.uploadConstraintsKey(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, err.Error())
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "file required")
+		return
+	}
+	defer file.Close()
+	if !isSdistFilename(header.Filename) {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "source distribution (.tar.gz or .zip) required")
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(file, 256<<20))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "failed to read file")
+		return
+	}
+	smeta, reqs, err := parseSdist(header.Filename, data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, err.Error())
+		return
+	}
+	if !h.scanUpload(w, r, data) {
+		return
+	}
+	sum := sha256.Sum256(data)
+	digestHex := hex.EncodeToString(sum[:])
+	key := inputObjectKey(h.Config.InputObjectPrefix, digestHex, header.Filename)
+	contentType := "application/gzip"
+	if strings.HasSuffix(strings.ToLower(header.Filename), ".zip") {
+		contentType = "application/zip"
+	}
+	if err := h.InputStore.Put(r.Context(), key, data, contentType); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	meta := map[string]any{
+		"type":     "sdist",
+		"sdist":    smeta,
+		"requires": reqs,
+	}
+	if indexURL != "" {
+		meta["index_url"] = indexURL
+	}
+	if pythonVersion != "" {
+		meta["python_version"] = pythonVersion
+	}
+	if constraintsKey != "" {
+		meta["constraints_key"] = constraintsKey
+	}
+	metaJSON, _ := json.Marshal(meta)
+	pi := store.PendingInput{
+		Filename:     header.Filename,
+		Digest:       "sha256:" + digestHex,
+		SizeBytes:    int64(len(data)),
+		Status:       "pending",
+		SourceType:   "sdist",
+		ObjectBucket: h.Config.ObjectStoreBucket,
+		ObjectKey:    key,
+		ContentType:  contentType,
+		Metadata:     metaJSON,
+	}
+	var pendingID int64
+	if h.Store != nil {
+		if id, err := h.Store.AddPendingInput(r.Context(), pi); err == nil {
+			pendingID = id
+			if h.Config.AutoPlan && h.PlanQ != nil {
+				_ = h.PlanQ.Enqueue(r.Context(), fmt.Sprintf("%d", pendingID))
+				_ = h.Store.UpdatePendingInputStatus(r.Context(), pendingID, "planning", "")
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"detail":     "sdist uploaded",
+		"bytes":      len(data),
+		"filename":   header.Filename,
+		"name":       smeta.Name,
+		"version":    smeta.Version,
+		"object_key": key,
+		"pending_id": pendingID,
+	})
+}
+
 func (h *Handler) settings(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
@@ -831,17 +1774,17 @@ func (h *Handler) settings(w http.ResponseWriter, r *http.Request) {
 		if h.Store != nil {
 			s, err := h.Store.GetSettings(r.Context())
 			if err != nil {
-				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 				return
 			}
-			writeJSON(w, http.StatusOK, s)
+			writeJSON(w, http.StatusOK, settings.Redact(s))
 			return
 		}
-		writeJSON(w, http.StatusOK, settings.Load(h.Config.SettingsPath))
+		writeJSON(w, http.StatusOK, settings.Redact(settings.Load(h.Config.SettingsPath)))
 	case http.MethodPost:
 		var s settings.Settings
 		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+			writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid json")
 			return
 		}
 		// basic normalization
@@ -853,42 +1796,82 @@ func (h *Handler) settings(w http.ResponseWriter, r *http.Request) {
 		}
 		s = settings.ApplyDefaults(s)
 		if err := settings.Validate(s); err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			writeError(w, http.StatusBadRequest, codeInvalidInput, err.Error())
+			return
+		}
+		stored, err := h.loadSettings(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 			return
 		}
+		s = settings.KeepSecrets(s, stored)
 		if h.Store != nil {
 			if err := h.Store.SaveSettings(r.Context(), s); err != nil {
-				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 				return
 			}
 		} else {
 			// fallback to file persistence if no store is configured
 			if err := settings.Save(h.Config.SettingsPath, s); err != nil {
-				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 				return
 			}
 		}
 		// reflect auto flags into config defaults for this process lifetime
 		h.Config.AutoPlan = settings.BoolValue(s.AutoPlan)
 		h.Config.AutoBuild = settings.BoolValue(s.AutoBuild)
-		writeJSON(w, http.StatusOK, s)
+		writeJSON(w, http.StatusOK, settings.Redact(s))
 	default:
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 	}
 }
 
+func (h *Handler) loadSettings(ctx context.Context) (settings.Settings, error) {
+	if h.Store != nil {
+		return h.Store.GetSettings(ctx)
+	}
+	return settings.Load(h.Config.SettingsPath), nil
+}
+
+// indexCredentials hands the stored index credentials to workers at plan time.
+// Unlike other worker endpoints it stays closed when no worker token is
+// configured, since it returns the plaintext password.
+func (h *Handler) indexCredentials(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if h.Config.WorkerToken == "" {
+		writeError(w, http.StatusForbidden, codeForbidden, "index credentials require WORKER_TOKEN")
+		return
+	}
+	if err := h.requireWorkerToken(r); err != nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, err.Error())
+		return
+	}
+	s, err := h.loadSettings(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"index_username": s.IndexUsername,
+		"index_password": s.IndexPassword,
+	})
+}
+
 func (h *Handler) notImplemented(w http.ResponseWriter, r *http.Request) {
-	writeJSON(w, http.StatusNotImplemented, map[string]string{"error": "not implemented"})
+	writeError(w, http.StatusNotImplemented, codeNotImplemented, "not implemented")
 }
 
 func (h *Handler) pendingInputs(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	list, err := h.Store.ListPendingInputs(r.Context(), "")
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 	writeJSON(w, http.StatusOK, list)
@@ -896,15 +1879,15 @@ func (h *Handler) pendingInputs(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) pendingInputsClear(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	if err := h.requireWorkerToken(r); err != nil {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, err.Error())
 		return
 	}
 	if h.Store == nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "store not configured"})
+		writeError(w, http.StatusInternalServerError, codeBackendUnavailable, "store not configured")
 		return
 	}
 	status := r.URL.Query().Get("status")
@@ -913,13 +1896,13 @@ func (h *Handler) pendingInputsClear(w http.ResponseWriter, r *http.Request) {
 	}
 	list, err := h.Store.ListPendingInputs(r.Context(), status)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 	cleared := 0
 	for _, pi := range list {
 		if _, err := h.Store.DeletePendingInput(r.Context(), pi.ID); err != nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 			return
 		}
 		cleared++
@@ -927,11 +1910,161 @@ func (h *Handler) pendingInputsClear(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]any{"detail": "cleared pending inputs", "count": cleared})
 }
 
+// pendingInputsPurge hard-deletes soft-deleted pending inputs older than
+// ?older_than= seconds (default: the configured retention) and their blobs.
+func (h *Handler) pendingInputsPurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err := h.requireWorkerToken(r); err != nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, err.Error())
+		return
+	}
+	if h.Store == nil {
+		writeError(w, http.StatusInternalServerError, codeBackendUnavailable, "store not configured")
+		return
+	}
+	olderThan := h.Config.InputRetentionSec
+	if v := r.URL.Query().Get("older_than"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, codeInvalidInput, "older_than must be a non-negative number of seconds")
+			return
+		}
+		olderThan = n
+	}
+	keys, blobErrs, err := h.purgePendingInputs(r.Context(), olderThan)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"detail":      "purged pending inputs",
+		"older_than":  olderThan,
+		"count":       len(keys),
+		"object_keys": keys,
+		"blob_errors": blobErrs,
+	})
+}
+
+// purgePendingInputs removes expired rows, then the input blobs no remaining
+// row uses. Blob failures are counted rather than fatal since the rows are
+// already gone.
+func (h *Handler) purgePendingInputs(ctx context.Context, olderThanSec int) ([]string, int, error) {
+	keys, err := h.Store.PurgePendingInputs(ctx, olderThanSec)
+	if err != nil {
+		return nil, 0, err
+	}
+	blobErrs := 0
+	if h.InputStore != nil {
+		for _, key := range keys {
+			if err := h.InputStore.Delete(ctx, key); err != nil {
+				log.Printf("input purge: delete %s: %v", key, err)
+				blobErrs++
+			}
+		}
+	}
+	return keys, blobErrs, nil
+}
+
+// RunInputJanitor purges expired pending inputs every InputPurgeInterval
+// seconds until ctx is done. It is a no-op unless InputRetentionSec is set.
+func (h *Handler) RunInputJanitor(ctx context.Context) {
+	if h.Store == nil || h.Config.InputRetentionSec <= 0 {
+		return
+	}
+	interval := time.Duration(h.Config.InputPurgeInterval) * time.Second
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if keys, blobErrs, err := h.purgePendingInputs(ctx, h.Config.InputRetentionSec); err != nil {
+			log.Printf("input janitor: %v", err)
+		} else if len(keys) > 0 {
+			log.Printf("input janitor: purged inputs, removed %d blobs (%d errors)", len(keys), blobErrs)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunEventRollup folds events older than EventRetentionSec into daily
+// per-package rollups every EventRollupInterval seconds until ctx is done. It
+// is a no-op unless EventRetentionSec is set.
+func (h *Handler) RunEventRollup(ctx context.Context) {
+	if h.Store == nil || h.Config.EventRetentionSec <= 0 {
+		return
+	}
+	interval := time.Duration(h.Config.EventRollupInterval) * time.Second
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := h.Store.RollupEvents(ctx, h.Config.EventRetentionSec); err != nil {
+			log.Printf("event rollup: %v", err)
+		} else if n > 0 {
+			log.Printf("event rollup: rolled up %d events", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunPlanReconciler re-creates missing build_status rows for the latest plan
+// every ReconcileInterval seconds until ctx is done. Only plans that were
+// queued (or any plan under AutoBuild) are reconciled, so a plan nobody
+// enqueued is never started behind the operator's back.
+func (h *Handler) RunPlanReconciler(ctx context.Context) {
+	if h.Store == nil || h.Config.ReconcileInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(h.Config.ReconcileInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		snap, err := h.Store.LatestPlanSnapshot(ctx)
+		if err != nil {
+			if !errors.Is(err, store.ErrNotFound) {
+				log.Printf("plan reconciler: %v", err)
+			}
+			continue
+		}
+		if !snap.Queued && !h.Config.AutoBuild {
+			continue
+		}
+		if snap.Tampered {
+			log.Printf("plan reconciler: plan %d failed integrity check, skipping", snap.ID)
+			continue
+		}
+		created, err := h.Store.ReconcilePlanBuilds(ctx, snap.RunID, snap.ID, snap.Plan)
+		if err != nil {
+			log.Printf("plan reconciler: plan %d: %v", snap.ID, err)
+		} else if len(created) > 0 {
+			log.Printf("plan reconciler: plan %d: queued %d missing builds", snap.ID, len(created))
+		}
+	}
+}
+
 func (h *Handler) pendingInputAction(w http.ResponseWriter, r *http.Request) {
-	// URL: /api/pending-inputs/{id}/enqueue-plan
+	// URL: /api/pending-inputs/{id}/{enqueue-plan|cancel|restore|plan}
 	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/pending-inputs/"), "/")
 	if len(parts) < 1 || parts[0] == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid path"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid path")
 		return
 	}
 	idStr := parts[0]
@@ -941,116 +2074,175 @@ func (h *Handler) pendingInputAction(w http.ResponseWriter, r *http.Request) {
 	}
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid id"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid id")
 		return
 	}
 	if action == "" && r.Method == http.MethodDelete {
 		if err := h.requireWorkerToken(r); err != nil {
-			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, err.Error())
 			return
 		}
 		if h.Store == nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "store not configured"})
+			writeError(w, http.StatusInternalServerError, codeBackendUnavailable, "store not configured")
 			return
 		}
 		if _, err := h.Store.DeletePendingInput(r.Context(), id); err != nil {
 			if errors.Is(err, store.ErrNotFound) {
-				writeJSON(w, http.StatusNotFound, map[string]string{"error": "pending input not found"})
+				writeError(w, http.StatusNotFound, codeNotFound, "pending input not found")
 				return
 			}
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 			return
 		}
 		writeJSON(w, http.StatusOK, map[string]any{"detail": "deleted pending input", "id": id})
 		return
 	}
 	switch action {
+	case "plan":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+			return
+		}
+		if h.Store == nil {
+			writeError(w, http.StatusInternalServerError, codeBackendUnavailable, "store not configured")
+			return
+		}
+		snap, err := h.Store.LatestPlanForPendingInput(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				writeError(w, http.StatusNotFound, codeNotFound, "no plan for pending input")
+				return
+			}
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, snap)
 	case "enqueue-plan":
 		if r.Method != http.MethodPost {
-			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+			return
+		}
+		if h.PlanQ == nil {
+			writeError(w, http.StatusInternalServerError, codeBackendUnavailable, "plan queue not configured")
+			return
+		}
+		item := fmt.Sprintf("%d", id)
+		opts := url.Values{}
+		if r.URL.Query().Get("reuse_only") == "true" {
+			opts.Set("reuse_only", "true")
+		}
+		if force := strings.TrimSpace(r.URL.Query().Get("force_rebuild")); force != "" {
+			opts.Set("force_rebuild", force)
+		}
+		if len(opts) > 0 {
+			item += "?" + opts.Encode()
+		}
+		if err := h.PlanQ.Enqueue(r.Context(), item); err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		_ = h.Store.UpdatePendingInputStatus(r.Context(), id, "planning", "")
+		writeJSON(w, http.StatusOK, map[string]string{"detail": "enqueued for planning"})
+	case "cancel":
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 			return
 		}
 		if h.PlanQ == nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "plan queue not configured"})
+			writeError(w, http.StatusInternalServerError, codeBackendUnavailable, "plan queue not configured")
 			return
 		}
-		if err := h.PlanQ.Enqueue(r.Context(), fmt.Sprintf("%d", id)); err != nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		removed, err := h.PlanQ.Remove(r.Context(), idStr)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 			return
 		}
-		_ = h.Store.UpdatePendingInputStatus(r.Context(), id, "planning", "")
-		writeJSON(w, http.StatusOK, map[string]string{"detail": "enqueued for planning"})
+		if removed == 0 {
+			writeError(w, http.StatusConflict, codeConflict, "pending input is not in the plan queue")
+			return
+		}
+		if h.Store != nil {
+			_ = h.Store.UpdatePendingInputStatus(r.Context(), id, "pending", "")
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"detail": "planning cancelled", "id": id, "removed": removed})
 	case "restore":
 		if r.Method != http.MethodPost {
-			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 			return
 		}
 		if err := h.requireWorkerToken(r); err != nil {
-			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, err.Error())
 			return
 		}
 		if h.Store == nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "store not configured"})
+			writeError(w, http.StatusInternalServerError, codeBackendUnavailable, "store not configured")
 			return
 		}
 		if _, err := h.Store.RestorePendingInput(r.Context(), id); err != nil {
 			if errors.Is(err, store.ErrNotFound) {
-				writeJSON(w, http.StatusNotFound, map[string]string{"error": "pending input not found"})
+				writeError(w, http.StatusNotFound, codeNotFound, "pending input not found")
 				return
 			}
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 			return
 		}
 		writeJSON(w, http.StatusOK, map[string]any{"detail": "pending input restored", "id": id})
 	default:
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unknown action"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "unknown action")
 	}
 }
 
 func (h *Handler) pendingInputPop(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	if err := h.requireWorkerToken(r); err != nil {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, err.Error())
 		return
 	}
 	if h.PlanQ == nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "plan queue not configured"})
+		writeError(w, http.StatusInternalServerError, codeBackendUnavailable, "plan queue not configured")
 		return
 	}
 	max := parseIntDefault(r.URL.Query().Get("max"), 1, 100)
 	ids, err := h.PlanQ.Pop(r.Context(), max)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
-	for _, idStr := range ids {
-		if id, err := strconv.ParseInt(idStr, 10, 64); err == nil && h.Store != nil {
+	for _, item := range ids {
+		if id, err := planQueueItemID(item); err == nil && h.Store != nil {
 			_ = h.Store.UpdatePendingInputStatus(r.Context(), id, "planning", "")
 		}
 	}
 	writeJSON(w, http.StatusOK, map[string]any{"ids": ids})
 }
 
+// planQueueItemID returns the pending input ID of a plan-queue item. Items
+// may carry planning options after the ID, e.g. "42?reuse_only=true".
+func planQueueItemID(item string) (int64, error) {
+	id, _, _ := strings.Cut(item, "?")
+	return strconv.ParseInt(id, 10, 64)
+}
+
 func (h *Handler) pendingInputStatus(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	if err := h.requireWorkerToken(r); err != nil {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, err.Error())
 		return
 	}
 	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/pending-inputs/status/"), "/")
 	if len(parts) < 1 || parts[0] == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid path"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid path")
 		return
 	}
 	id, err := strconv.ParseInt(parts[0], 10, 64)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid id"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid id")
 		return
 	}
 	var body struct {
@@ -1058,42 +2250,92 @@ func (h *Handler) pendingInputStatus(w http.ResponseWriter, r *http.Request) {
 		Error  string `json:"error,omitempty"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid json")
 		return
 	}
 	if body.Status == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "status required"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "status required")
 		return
 	}
 	if err := h.Store.UpdatePendingInputStatus(r.Context(), id, body.Status, body.Error); err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 	writeJSON(w, http.StatusOK, map[string]string{"detail": "status updated"})
 }
 
+// planQueueEntry is one item waiting in the plan queue, joined with its
+// pending input when the store still has it.
+type planQueueEntry struct {
+	ID       int64  `json:"id"`
+	Item     string `json:"item"`
+	Filename string `json:"filename,omitempty"`
+	Status   string `json:"status,omitempty"`
+}
+
+// planQueueList shows the planning backlog in pop order without consuming it.
+func (h *Handler) planQueueList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if h.PlanQ == nil {
+		writeError(w, http.StatusInternalServerError, codeBackendUnavailable, "plan queue not configured")
+		return
+	}
+	items, err := h.PlanQ.List(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	inputs := map[int64]store.PendingInput{}
+	if h.Store != nil && len(items) > 0 {
+		list, err := h.Store.ListPendingInputs(r.Context(), "")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		for _, pi := range list {
+			inputs[pi.ID] = pi
+		}
+	}
+	out := make([]planQueueEntry, 0, len(items))
+	for _, item := range items {
+		entry := planQueueEntry{Item: item}
+		if id, err := planQueueItemID(item); err == nil {
+			entry.ID = id
+			if pi, ok := inputs[id]; ok {
+				entry.Filename = pi.Filename
+				entry.Status = pi.Status
+			}
+		}
+		out = append(out, entry)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
 func (h *Handler) planQueueClear(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	if err := h.requireWorkerToken(r); err != nil {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, err.Error())
 		return
 	}
 	if h.PlanQ == nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "plan queue not configured"})
+		writeError(w, http.StatusInternalServerError, codeBackendUnavailable, "plan queue not configured")
 		return
 	}
 	ids, err := h.PlanQ.Clear(r.Context())
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 	reset := 0
 	if h.Store != nil {
-		for _, idStr := range ids {
-			id, err := strconv.ParseInt(idStr, 10, 64)
+		for _, item := range ids {
+			id, err := planQueueItemID(item)
 			if err != nil {
 				continue
 			}
@@ -1120,66 +2362,309 @@ func (h *Handler) builds(w http.ResponseWriter, r *http.Request) {
 		if planStr := r.URL.Query().Get("plan_id"); planStr != "" {
 			id, err := strconv.ParseInt(planStr, 10, 64)
 			if err != nil || id <= 0 {
-				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid plan_id"})
+				writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid plan_id")
 				return
 			}
 			planID = id
 		}
 		list, err := h.Store.ListBuilds(r.Context(), status, limit, planID, pkg, version)
 		if err != nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 			return
 		}
 		writeJSON(w, http.StatusOK, list)
 	case http.MethodDelete:
 		if err := h.requireWorkerToken(r); err != nil {
-			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, err.Error())
 			return
 		}
 		status := r.URL.Query().Get("status")
 		count, err := h.Store.DeleteBuilds(r.Context(), status)
 		if err != nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 			return
 		}
 		writeJSON(w, http.StatusOK, map[string]any{"detail": "builds cleared", "count": count})
 	default:
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+	}
+}
+
+// buildStatuses are the states a build row can be moved between in bulk.
+var buildStatuses = map[string]bool{
+	"pending": true, "retry": true, "leased": true, "building": true, "built": true, "failed": true, "cancelled": true,
+}
+
+// buildsTransition moves every build in from_status to to_status, e.g. all
+// stranded "building" rows back to "retry" after a worker crash.
+func (h *Handler) buildsTransition(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if h.Store == nil {
+		writeError(w, http.StatusServiceUnavailable, codeBackendUnavailable, "store not configured")
+		return
+	}
+	if err := h.requireWorkerToken(r); err != nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, err.Error())
+		return
+	}
+	var body struct {
+		FromStatus    string `json:"from_status"`
+		ToStatus      string `json:"to_status"`
+		ResetAttempts bool   `json:"reset_attempts"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid json")
+		return
+	}
+	from := strings.ToLower(strings.TrimSpace(body.FromStatus))
+	to := strings.ToLower(strings.TrimSpace(body.ToStatus))
+	if !buildStatuses[from] || !buildStatuses[to] {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "from_status and to_status must be one of pending, retry, leased, building, built, failed, cancelled")
+		return
+	}
+	if from == to {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "from_status and to_status must differ")
+		return
+	}
+	count, err := h.Store.TransitionBuilds(r.Context(), from, to, body.ResetAttempts)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"detail":      "builds transitioned",
+		"from_status": from,
+		"to_status":   to,
+		"count":       count,
+	})
+}
+
+// buildsApproveFix releases an auto-fix a worker held back for exceeding
+// AUTO_FIX_MAX_IMPACT: the held recipes become the build's recipes and the
+// build is requeued.
+func (h *Handler) buildsApproveFix(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err := h.requireWorkerToken(r); err != nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, err.Error())
+		return
+	}
+	var body struct {
+		Package   string `json:"package"`
+		Version   string `json:"version"`
+		PythonTag string `json:"python_tag,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid json")
+		return
+	}
+	if body.Package == "" || body.Version == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "package and version required")
+		return
+	}
+	recipes, err := h.Store.ApproveBuildFix(r.Context(), body.Package, body.Version, body.PythonTag)
+	if errors.Is(err, store.ErrNotFound) {
+		writeError(w, http.StatusNotFound, codeNotFound, "no held fix for "+body.Package+" "+body.Version)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	_, _ = h.Store.RecordEvent(r.Context(), store.Event{
+		Name:      body.Package,
+		Version:   body.Version,
+		Status:    "pending",
+		Detail:    "held fix approved",
+		Metadata:  map[string]any{"recipes": recipes},
+		Timestamp: time.Now().Unix(),
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"detail": "fix approved", "recipes": recipes})
+}
+
+// buildsCancel cancels a build (POST, worker token) or reports whether a
+// cancel was requested (GET ?package=&version=). Queued builds are cancelled
+// outright; running ones are flagged for the worker executing them, which
+// polls this endpoint and reports cancelled once the build is stopped. An
+// optional python_tag narrows either call to one build of a multi-Python
+// plan; without it every python tag of the version is affected.
+func (h *Handler) buildsCancel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		pkg := r.URL.Query().Get("package")
+		version := r.URL.Query().Get("version")
+		if pkg == "" || version == "" {
+			writeError(w, http.StatusBadRequest, codeInvalidInput, "package and version required")
+			return
+		}
+		requested, err := h.Store.BuildCancelRequested(r.Context(), pkg, version, r.URL.Query().Get("python_tag"))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"package": pkg, "version": version, "cancel_requested": requested})
+	case http.MethodPost:
+		if err := h.requireWorkerToken(r); err != nil {
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, err.Error())
+			return
+		}
+		var body struct {
+			Package   string `json:"package"`
+			Version   string `json:"version"`
+			PythonTag string `json:"python_tag,omitempty"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid json")
+			return
+		}
+		if body.Package == "" || body.Version == "" {
+			writeError(w, http.StatusBadRequest, codeInvalidInput, "package and version required")
+			return
+		}
+		status, err := h.Store.RequestBuildCancel(r.Context(), body.Package, body.Version, body.PythonTag)
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusNotFound, codeNotFound, "no queued or running build for "+body.Package+" "+body.Version)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		detail := "cancel requested"
+		if status == "cancelled" {
+			detail = "build cancelled"
+		}
+		_, _ = h.Store.RecordEvent(r.Context(), store.Event{
+			Name:      body.Package,
+			Version:   body.Version,
+			Status:    status,
+			Detail:    detail,
+			Timestamp: time.Now().Unix(),
+		})
+		writeJSON(w, http.StatusOK, map[string]any{"detail": detail, "status": status})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+	}
+}
+
+// buildsApplyRecipes attaches operator-chosen recipes and hints to an
+// existing build and requeues it as pending with attempts reset. The next
+// worker to lease it runs the build with those recipes.
+func (h *Handler) buildsApplyRecipes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err := h.requireWorkerToken(r); err != nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, err.Error())
+		return
+	}
+	var body struct {
+		Package   string   `json:"package"`
+		Version   string   `json:"version"`
+		PythonTag string   `json:"python_tag,omitempty"`
+		Recipes   []string `json:"recipes"`
+		HintIDs   []string `json:"hint_ids,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid json")
+		return
+	}
+	if body.Package == "" || body.Version == "" || len(body.Recipes) == 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "package, version, and recipes required")
+		return
+	}
+	for _, recipe := range body.Recipes {
+		if mgr, step, ok := strings.Cut(recipe, ":"); !ok || strings.TrimSpace(mgr) == "" || strings.TrimSpace(step) == "" {
+			writeError(w, http.StatusBadRequest, codeInvalidInput, fmt.Sprintf("recipe %q must be manager:step", recipe))
+			return
+		}
+	}
+	existing, err := h.Store.ListBuilds(r.Context(), "", 1, 0, body.Package, body.Version)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	if len(existing) == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "build not found")
+		return
+	}
+	if err := h.Store.UpdateBuildStatus(r.Context(), body.Package, body.Version, body.PythonTag, "pending", "", "", "", 0, 0, body.Recipes, body.HintIDs); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	meta := map[string]any{"recipes": body.Recipes}
+	if len(body.HintIDs) > 0 {
+		meta["hint_ids"] = body.HintIDs
 	}
+	_, _ = h.Store.RecordEvent(r.Context(), store.Event{
+		Name:      body.Package,
+		Version:   body.Version,
+		Status:    "pending",
+		Detail:    "recipes applied for rebuild",
+		Metadata:  meta,
+		Timestamp: time.Now().Unix(),
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"detail": "build requeued", "recipes": body.Recipes})
 }
 
 func (h *Handler) buildStatusUpdate(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	if err := h.requireWorkerToken(r); err != nil {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, err.Error())
 		return
 	}
 	var body struct {
-		Package        string   `json:"package"`
-		Version        string   `json:"version"`
-		Status         string   `json:"status"`
-		Error          string   `json:"error,omitempty"`
-		FailureSummary string   `json:"failure_summary,omitempty"`
-		Attempts       int      `json:"attempts,omitempty"`
-		BackoffUntil   int64    `json:"backoff_until,omitempty"`
-		Recipes        []string `json:"recipes,omitempty"`
-		HintIDs        []string `json:"hint_ids,omitempty"`
+		Package         string   `json:"package"`
+		Version         string   `json:"version"`
+		PythonTag       string   `json:"python_tag,omitempty"`
+		Status          string   `json:"status"`
+		Error           string   `json:"error,omitempty"`
+		FailureSummary  string   `json:"failure_summary,omitempty"`
+		FailureCategory string   `json:"failure_category,omitempty"`
+		Attempts        int      `json:"attempts,omitempty"`
+		BackoffUntil    int64    `json:"backoff_until,omitempty"`
+		Recipes         []string `json:"recipes,omitempty"`
+		HintIDs         []string `json:"hint_ids,omitempty"`
+		HeldRecipes     []string `json:"held_recipes,omitempty"`
+		RunID           string   `json:"run_id,omitempty"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid json")
 		return
 	}
 	if body.Package == "" || body.Version == "" || body.Status == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "package, version, and status required"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "package, version, and status required")
 		return
 	}
-	if err := h.Store.UpdateBuildStatus(r.Context(), body.Package, body.Version, body.Status, body.Error, body.FailureSummary, body.Attempts, body.BackoffUntil, body.Recipes, body.HintIDs); err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	if err := h.Store.UpdateBuildStatus(r.Context(), body.Package, body.Version, body.PythonTag, body.Status, body.Error, body.FailureSummary, body.FailureCategory, body.Attempts, body.BackoffUntil, body.Recipes, body.HintIDs); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
+	if len(body.HeldRecipes) > 0 {
+		if err := h.Store.HoldBuildFix(r.Context(), body.Package, body.Version, body.PythonTag, body.HeldRecipes); err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+	}
+	if terminalBuildStatuses[body.Status] {
+		h.notifyBuildOutcome(r.Context(), buildOutcome{
+			Package:  body.Package,
+			Version:  body.Version,
+			Status:   body.Status,
+			Error:    body.Error,
+			RunID:    body.RunID,
+			Attempts: body.Attempts,
+		})
+	}
 	if body.Status == "building" || body.Status == "pending" || body.Status == "retry" {
 		detail := "build status updated"
 		switch body.Status {
@@ -1200,7 +2685,7 @@ func (h *Handler) buildStatusUpdate(w http.ResponseWriter, r *http.Request) {
 		if len(body.HintIDs) > 0 {
 			meta["hint_ids"] = body.HintIDs
 		}
-		_ = h.Store.RecordEvent(r.Context(), store.Event{
+		_, _ = h.Store.RecordEvent(r.Context(), store.Event{
 			Name:           body.Package,
 			Version:        body.Version,
 			Status:         body.Status,
@@ -1223,11 +2708,11 @@ func (h *Handler) buildStatusUpdate(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) buildQueuePop(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	if err := h.requireWorkerToken(r); err != nil {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, err.Error())
 		return
 	}
 	max := parseIntDefault(r.URL.Query().Get("max"), 5, 100)
@@ -1236,7 +2721,7 @@ func (h *Handler) buildQueuePop(w http.ResponseWriter, r *http.Request) {
 	}
 	builds, err := h.Store.LeaseBuilds(r.Context(), max)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 	type job struct {
@@ -1264,116 +2749,344 @@ func (h *Handler) buildQueuePop(w http.ResponseWriter, r *http.Request) {
 			HintIDs:     b.HintIDs,
 		})
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"builds": out})
+	writeJSON(w, http.StatusOK, map[string]any{"builds": out})
+}
+
+func (h *Handler) summary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	limit := parseIntDefault(q.Get("failure_limit"), 20, 200)
+	from, to := parseWindow(q)
+	sum, err := h.Store.Summary(r.Context(), limit, from, to)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	writeJSONCached(w, r, sum)
+}
+
+func (h *Handler) recent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	limit := parseIntDefault(q.Get("limit"), 50, 500)
+	offset := parseIntDefault(q.Get("offset"), 0, 10_000)
+	pkg := q.Get("package")
+	status := q.Get("status")
+	events, err := h.Store.Recent(r.Context(), limit, offset, pkg, q.Get("name_like"), status)
+	if err != nil {
+		if errors.Is(err, store.ErrPatternTooBroad) {
+			writeError(w, http.StatusBadRequest, codeInvalidInput, err.Error())
+			return
+		}
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+func (h *Handler) history(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		from, to := parseWindow(q)
+		filter := store.HistoryFilter{
+			Package:  q.Get("package"),
+			NameLike: q.Get("name_like"),
+			Status:   q.Get("status"),
+			RunID:    q.Get("run_id"),
+			FromTs:   from,
+			ToTs:     to,
+			Limit:    parseIntDefault(q.Get("limit"), 50, 500),
+			Offset:   parseIntDefault(q.Get("offset"), 0, 10_000),
+		}
+		res, err := h.Store.History(r.Context(), filter)
+		if err != nil {
+			if errors.Is(err, store.ErrPatternTooBroad) {
+				writeError(w, http.StatusBadRequest, codeInvalidInput, err.Error())
+				return
+			}
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	case http.MethodPost:
+		var evt store.Event
+		if err := json.NewDecoder(r.Body).Decode(&evt); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid json")
+			return
+		}
+		if err := prepareEvent(&evt); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidInput, err.Error())
+			return
+		}
+		created, err := h.Store.RecordEvent(r.Context(), evt)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		detail := "event recorded"
+		if !created {
+			detail = "duplicate event ignored"
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"detail": detail, "created": created})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+	}
+}
+
+// maxBulkEvents caps a single /api/history/bulk request.
+const maxBulkEvents = 5000
+
+type bulkEventError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// historyBulk records a JSON array or newline-delimited stream of events.
+// Invalid records are reported by index; valid ones are still recorded.
+func (h *Handler) historyBulk(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	raws, err := decodeEventBatch(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, err.Error())
+		return
+	}
+	if len(raws) > maxBulkEvents {
+		writeError(w, http.StatusRequestEntityTooLarge, codeTooLarge, fmt.Sprintf("too many events (max %d)", maxBulkEvents))
+		return
+	}
+	events := make([]store.Event, 0, len(raws))
+	errs := []bulkEventError{}
+	for i, raw := range raws {
+		var evt store.Event
+		if err := json.Unmarshal(raw, &evt); err != nil {
+			errs = append(errs, bulkEventError{Index: i, Error: "invalid json"})
+			continue
+		}
+		if err := prepareEvent(&evt); err != nil {
+			errs = append(errs, bulkEventError{Index: i, Error: err.Error()})
+			continue
+		}
+		events = append(events, evt)
+	}
+	var recorded int64
+	if len(events) > 0 {
+		recorded, err = h.Store.RecordEvents(r.Context(), events)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"recorded":   recorded,
+		"duplicates": int64(len(events)) - recorded,
+		"errors":     errs,
+	})
+}
+
+// decodeEventBatch splits a request body into raw event records. A body that
+// starts with '[' is a JSON array; anything else is read as NDJSON.
+func decodeEventBatch(body io.Reader) ([]json.RawMessage, error) {
+	br := bufio.NewReader(body)
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("empty payload")
+			}
+			return nil, err
+		}
+		if b[0] == ' ' || b[0] == '\t' || b[0] == '\r' || b[0] == '\n' {
+			_, _ = br.ReadByte()
+			continue
+		}
+		if b[0] == '[' {
+			var raws []json.RawMessage
+			if err := json.NewDecoder(br).Decode(&raws); err != nil {
+				return nil, fmt.Errorf("invalid json array")
+			}
+			return raws, nil
+		}
+		break
+	}
+	var raws []json.RawMessage
+	sc := bufio.NewScanner(br)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		raws = append(raws, json.RawMessage(append([]byte(nil), line...)))
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read ndjson: %w", err)
+	}
+	return raws, nil
+}
+
+// prepareEvent validates an incoming event and fills server-side defaults.
+// Retries are deduplicated on the event_id or the client's timestamp. An
+// event with neither gets a unique event_id: keying it on the server clock
+// would merge distinct events posted in the same second yet still miss a
+// retry that lands a second later.
+func prepareEvent(evt *store.Event) error {
+	if evt.Name == "" || evt.Version == "" || evt.Status == "" {
+		return fmt.Errorf("name, version, and status are required")
+	}
+	if errs := store.ValidateEventMetadata(evt.Metadata); len(errs) > 0 {
+		return fmt.Errorf("invalid metadata: %s", strings.Join(errs, "; "))
+	}
+	if evt.Timestamp == 0 {
+		if evt.EventID == "" {
+			var b [16]byte
+			if _, err := rand.Read(b[:]); err != nil {
+				return fmt.Errorf("event id: %w", err)
+			}
+			evt.EventID = "evt-" + hex.EncodeToString(b[:])
+		}
+		evt.Timestamp = time.Now().Unix()
+	}
+	return nil
+}
+
+func (h *Handler) packageSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	parts := splitPath(r.URL.Path)
+	if len(parts) < 3 {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "name required")
+		return
+	}
+	name := parts[2]
+	if len(parts) > 3 {
+		if parts[3] != "detail" {
+			writeError(w, http.StatusNotFound, codeNotFound, "unknown package action")
+			return
+		}
+		h.packageDetail(w, r, name)
+		return
+	}
+	ps, err := h.Store.PackageSummary(r.Context(), name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, ps)
 }
 
-func (h *Handler) summary(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+// packageVersion groups everything known about one version of a package.
+type packageVersion struct {
+	Version    string              `json:"version"`
+	Latest     *store.Event        `json:"latest,omitempty"`
+	Builds     []store.BuildStatus `json:"builds"`
+	InManifest bool                `json:"in_manifest"`
+}
+
+// packageDetail combines the package summary, recent versions, their build
+// rows, and manifest presence so the package page needs one request.
+// Versions are ordered by most recent event; versions only known from
+// build rows follow.
+func (h *Handler) packageDetail(w http.ResponseWriter, r *http.Request, name string) {
+	ctx := r.Context()
+	ps, err := h.Store.PackageSummary(ctx, name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
-	limit := parseIntDefault(r.URL.Query().Get("failure_limit"), 20, 200)
-	sum, err := h.Store.Summary(r.Context(), limit)
+	variants, err := h.Store.Variants(ctx, name, parseIntDefault(r.URL.Query().Get("limit"), 100, 500))
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
-	writeJSON(w, http.StatusOK, sum)
-}
-
-func (h *Handler) recent(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	builds, err := h.Store.ListBuilds(ctx, "", 0, 0, name, "")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
-	q := r.URL.Query()
-	limit := parseIntDefault(q.Get("limit"), 50, 500)
-	offset := parseIntDefault(q.Get("offset"), 0, 10_000)
-	pkg := q.Get("package")
-	status := q.Get("status")
-	events, err := h.Store.Recent(r.Context(), limit, offset, pkg, status)
+	manifest, err := h.Store.Manifest(ctx, 1000)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
-	writeJSON(w, http.StatusOK, events)
-}
-
-func (h *Handler) history(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		q := r.URL.Query()
-		filter := store.HistoryFilter{
-			Package: q.Get("package"),
-			Status:  q.Get("status"),
-			RunID:   q.Get("run_id"),
-			FromTs:  int64(parseIntDefault(q.Get("from"), 0, 0)),
-			ToTs:    int64(parseIntDefault(q.Get("to"), 0, 0)),
-			Limit:   parseIntDefault(q.Get("limit"), 50, 500),
-			Offset:  parseIntDefault(q.Get("offset"), 0, 10_000),
-		}
-		res, err := h.Store.History(r.Context(), filter)
-		if err != nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
-			return
-		}
-		writeJSON(w, http.StatusOK, res)
-	case http.MethodPost:
-		var evt store.Event
-		if err := json.NewDecoder(r.Body).Decode(&evt); err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
-			return
-		}
-		if evt.Name == "" || evt.Version == "" || evt.Status == "" {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name, version, and status are required"})
-			return
+	versions := []*packageVersion{}
+	byVersion := map[string]*packageVersion{}
+	version := func(v string) *packageVersion {
+		if pv, ok := byVersion[v]; ok {
+			return pv
 		}
-		if evt.Timestamp == 0 {
-			evt.Timestamp = time.Now().Unix()
+		pv := &packageVersion{Version: v, Builds: []store.BuildStatus{}}
+		byVersion[v] = pv
+		versions = append(versions, pv)
+		return pv
+	}
+	for i := range variants {
+		if pv := version(variants[i].Version); pv.Latest == nil {
+			pv.Latest = &variants[i]
 		}
-		if err := h.Store.RecordEvent(r.Context(), evt); err != nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
-			return
+	}
+	for _, b := range builds {
+		pv := version(b.Version)
+		pv.Builds = append(pv.Builds, b)
+	}
+	for _, m := range manifest {
+		if strings.EqualFold(m.Name, name) {
+			if pv, ok := byVersion[m.Version]; ok {
+				pv.InManifest = true
+			}
 		}
-		writeJSON(w, http.StatusOK, map[string]string{"detail": "event recorded"})
-	default:
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
 	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"summary":  ps,
+		"versions": versions,
+	})
 }
 
-func (h *Handler) packageSummary(w http.ResponseWriter, r *http.Request) {
+// packageSearch returns package names starting with q for autocomplete.
+func (h *Handler) packageSearch(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
-	parts := splitPath(r.URL.Path)
-	if len(parts) < 3 {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name required"})
+	if h.Store == nil {
+		writeError(w, http.StatusServiceUnavailable, codeBackendUnavailable, "store not configured")
 		return
 	}
-	name := parts[2]
-	ps, err := h.Store.PackageSummary(r.Context(), name)
+	q := r.URL.Query()
+	limit := parseIntDefault(q.Get("limit"), 20, 200)
+	names, err := h.Store.SearchPackageNames(r.Context(), strings.TrimSpace(q.Get("q")), limit)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
-	writeJSON(w, http.StatusOK, ps)
+	writeJSON(w, http.StatusOK, names)
 }
 
 func (h *Handler) eventByVersion(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	parts := splitPath(r.URL.Path)
 	if len(parts) < 4 {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name/version required"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "name/version required")
 		return
 	}
 	name, version := parts[2], parts[3]
 	evt, err := h.Store.LatestEvent(r.Context(), name, version)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 	writeJSON(w, http.StatusOK, evt)
@@ -1381,34 +3094,57 @@ func (h *Handler) eventByVersion(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) failures(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	name := r.URL.Query().Get("name")
 	limit := parseIntDefault(r.URL.Query().Get("limit"), 50, 500)
 	res, err := h.Store.Failures(r.Context(), name, limit)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 	writeJSON(w, http.StatusOK, res)
 }
 
+// failureCategories breaks failed and retrying builds down by the category
+// the worker classified from their logs.
+func (h *Handler) failureCategories(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	res, err := h.Store.FailureCategories(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	type category struct {
+		Category string `json:"category"`
+		Builds   int64  `json:"builds"`
+	}
+	out := make([]category, 0, len(res))
+	for _, st := range res {
+		out = append(out, category{Category: st.Name, Builds: int64(st.Value)})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
 func (h *Handler) variants(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	parts := splitPath(r.URL.Path)
 	if len(parts) < 3 {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name required"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "name required")
 		return
 	}
 	name := parts[2]
 	limit := parseIntDefault(r.URL.Query().Get("limit"), 100, 500)
 	res, err := h.Store.Variants(r.Context(), name, limit)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 	writeJSON(w, http.StatusOK, res)
@@ -1416,13 +3152,15 @@ func (h *Handler) variants(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) topFailures(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
-	limit := parseIntDefault(r.URL.Query().Get("limit"), 10, 200)
-	res, err := h.Store.TopFailures(r.Context(), limit)
+	q := r.URL.Query()
+	limit := parseIntDefault(q.Get("limit"), 10, 200)
+	from, to := parseWindow(q)
+	res, err := h.Store.TopFailures(r.Context(), limit, from, to)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 	type topFailure struct {
@@ -1438,13 +3176,15 @@ func (h *Handler) topFailures(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) topSlowest(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
-	limit := parseIntDefault(r.URL.Query().Get("limit"), 10, 200)
-	res, err := h.Store.TopSlowest(r.Context(), limit)
+	q := r.URL.Query()
+	limit := parseIntDefault(q.Get("limit"), 10, 200)
+	from, to := parseWindow(q)
+	res, err := h.Store.TopSlowest(r.Context(), limit, from, to)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 	type topSlow struct {
@@ -1463,7 +3203,7 @@ func (h *Handler) plan(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		res, err := h.Store.Plan(r.Context())
 		if err != nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 			return
 		}
 		writeJSON(w, http.StatusOK, res)
@@ -1475,21 +3215,25 @@ func (h *Handler) plan(w http.ResponseWriter, r *http.Request) {
 			PendingInputID int64            `json:"pending_input_id,omitempty"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+			writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid json")
 			return
 		}
 		if len(body.Plan) == 0 {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "plan required"})
+			writeError(w, http.StatusBadRequest, codeInvalidInput, "plan required")
+			return
+		}
+		if nodeErrs := planNodeErrors(body.Plan); len(nodeErrs) > 0 {
+			writePlanValidationError(w, nodeErrs)
 			return
 		}
 		planID, err := h.Store.SavePlan(r.Context(), body.RunID, body.Plan, body.DAG)
 		if err != nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 			return
 		}
 		if body.PendingInputID > 0 && h.Store != nil {
 			if err := h.Store.LinkPlanToPendingInput(r.Context(), body.PendingInputID, planID); err != nil {
-				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 				return
 			}
 			_ = h.Store.UpdatePendingInputStatus(r.Context(), body.PendingInputID, "planned", "")
@@ -1502,7 +3246,7 @@ func (h *Handler) plan(w http.ResponseWriter, r *http.Request) {
 		}
 		writeJSON(w, http.StatusOK, map[string]string{"detail": "plan saved"})
 	default:
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 	}
 }
 
@@ -1512,24 +3256,24 @@ func (h *Handler) plans(w http.ResponseWriter, r *http.Request) {
 		limit := parseIntDefault(r.URL.Query().Get("limit"), 20, 200)
 		list, err := h.Store.ListPlans(r.Context(), limit)
 		if err != nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 			return
 		}
 		writeJSON(w, http.StatusOK, list)
 	case http.MethodDelete:
 		if err := h.requireWorkerToken(r); err != nil {
-			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, err.Error())
 			return
 		}
 		if h.Store == nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "store not configured"})
+			writeError(w, http.StatusInternalServerError, codeBackendUnavailable, "store not configured")
 			return
 		}
 		var planID int64
 		if idStr := r.URL.Query().Get("id"); idStr != "" {
 			id, err := strconv.ParseInt(idStr, 10, 64)
 			if err != nil || id <= 0 {
-				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid id"})
+				writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid id")
 				return
 			}
 			planID = id
@@ -1539,41 +3283,153 @@ func (h *Handler) plans(w http.ResponseWriter, r *http.Request) {
 		}
 		count, err := h.Store.DeletePlans(r.Context(), planID)
 		if err != nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 			return
 		}
 		writeJSON(w, http.StatusOK, map[string]any{"detail": "plans cleared", "count": count})
 	default:
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 	}
 }
 
 func (h *Handler) planLatest(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	snap, err := h.Store.LatestPlanSnapshot(r.Context())
 	if err != nil {
 		if errors.Is(err, store.ErrNotFound) {
-			writeJSON(w, http.StatusNotFound, map[string]string{"error": "plan not found"})
+			writeError(w, http.StatusNotFound, codeNotFound, "plan not found")
 			return
 		}
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
-	writeJSON(w, http.StatusOK, snap)
+	writeJSONCached(w, r, snap)
+}
+
+// planExport is the portable form of a stored plan served by
+// /api/plan/{id}/export and accepted by /api/plan/import. It omits the
+// instance-local queued flag; the source plan id is informational only.
+type planExport struct {
+	RunID        string           `json:"run_id,omitempty"`
+	Plan         []store.PlanNode `json:"plan"`
+	DAG          json.RawMessage  `json:"dag,omitempty"`
+	SourcePlanID int64            `json:"source_plan_id,omitempty"`
+	ExportedAt   int64            `json:"exported_at,omitempty"`
+}
+
+// planNodeErrors validates every node of a plan, prefixing each problem with
+// the node's position so callers can point at the offending entry.
+func planNodeErrors(nodes []store.PlanNode) []string {
+	var errs []string
+	for i, node := range nodes {
+		for _, e := range store.ValidatePlanNode(node) {
+			errs = append(errs, fmt.Sprintf("plan[%d] %s %s: %s", i, node.Name, node.Version, e))
+		}
+	}
+	return errs
+}
+
+func writePlanValidationError(w http.ResponseWriter, details []string) {
+	writeJSON(w, http.StatusBadRequest, map[string]any{
+		"error":   "validation failed",
+		"code":    codeInvalidInput,
+		"details": details,
+	})
+}
+
+// planImport saves an exported plan as a new plan on this instance. Builds
+// are not queued automatically, even with AUTO_BUILD; promote the imported
+// plan with /api/plan/{id}/enqueue-builds once it has been reviewed.
+func (h *Handler) planImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	var body planExport
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid json")
+		return
+	}
+	if len(body.Plan) == 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "plan required")
+		return
+	}
+	if nodeErrs := planNodeErrors(body.Plan); len(nodeErrs) > 0 {
+		writePlanValidationError(w, nodeErrs)
+		return
+	}
+	if dag := bytes.TrimSpace(body.DAG); len(dag) > 0 && !bytes.Equal(dag, []byte("null")) && dag[0] != '{' && dag[0] != '[' {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "dag must be a JSON object or array")
+		return
+	}
+	planID, err := h.Store.SavePlan(r.Context(), body.RunID, body.Plan, body.DAG)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"detail":  "plan imported",
+		"plan_id": planID,
+		"run_id":  body.RunID,
+	})
+}
+
+// planEstimate sums the historical average build duration of every build
+// node in the plan. Packages without recorded durations are counted in
+// unknown_count and contribute nothing to the estimate.
+func (h *Handler) planEstimate(w http.ResponseWriter, r *http.Request, snap store.PlanSnapshot) {
+	var names []string
+	seen := map[string]bool{}
+	buildCount := 0
+	for _, node := range snap.Plan {
+		if node.Action != "build" {
+			continue
+		}
+		buildCount++
+		key := strings.ToLower(node.Name)
+		if !seen[key] {
+			seen[key] = true
+			names = append(names, key)
+		}
+	}
+	avgs, err := h.Store.AvgDurations(r.Context(), names)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	var totalMs float64
+	unknown := 0
+	for _, node := range snap.Plan {
+		if node.Action != "build" {
+			continue
+		}
+		avg, ok := avgs[strings.ToLower(node.Name)]
+		if !ok {
+			unknown++
+			continue
+		}
+		totalMs += avg
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"plan_id":           snap.ID,
+		"build_count":       buildCount,
+		"estimated_seconds": math.Round(totalMs / 1000),
+		"unknown_count":     unknown,
+	})
 }
 
 func (h *Handler) planByID(w http.ResponseWriter, r *http.Request) {
 	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/plan/"), "/")
 	if len(parts) == 0 || parts[0] == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "plan id required"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "plan id required")
 		return
 	}
 	planID, err := strconv.ParseInt(parts[0], 10, 64)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid plan id"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid plan id")
 		return
 	}
 	action := ""
@@ -1582,41 +3438,72 @@ func (h *Handler) planByID(w http.ResponseWriter, r *http.Request) {
 	}
 	switch r.Method {
 	case http.MethodGet:
-		if action != "" {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unknown action"})
+		if action != "" && action != "estimate" && action != "export" {
+			writeError(w, http.StatusBadRequest, codeInvalidInput, "unknown action")
 			return
 		}
 		snap, err := h.Store.PlanSnapshot(r.Context(), planID)
 		if err != nil {
 			if errors.Is(err, store.ErrNotFound) {
-				writeJSON(w, http.StatusNotFound, map[string]string{"error": "plan not found"})
+				writeError(w, http.StatusNotFound, codeNotFound, "plan not found")
 				return
 			}
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		if action == "estimate" {
+			h.planEstimate(w, r, snap)
+			return
+		}
+		if action == "export" {
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"plan-%d.json\"", snap.ID))
+			writeJSON(w, http.StatusOK, planExport{RunID: snap.RunID, Plan: snap.Plan, DAG: snap.DAG, SourcePlanID: snap.ID, ExportedAt: time.Now().Unix()})
 			return
 		}
 		writeJSON(w, http.StatusOK, snap)
 	case http.MethodPost:
-		if action != "enqueue-builds" && action != "enqueue-build" {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unknown action"})
+		if action != "enqueue-builds" && action != "enqueue-build" && action != "reconcile" {
+			writeError(w, http.StatusBadRequest, codeInvalidInput, "unknown action")
 			return
 		}
 		snap, err := h.Store.PlanSnapshot(r.Context(), planID)
 		if err != nil {
 			if errors.Is(err, store.ErrNotFound) {
-				writeJSON(w, http.StatusNotFound, map[string]string{"error": "plan not found"})
+				writeError(w, http.StatusNotFound, codeNotFound, "plan not found")
+				return
+			}
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		if snap.Tampered {
+			writeError(w, http.StatusConflict, codeConflict, "plan failed integrity check: stored nodes do not match its hash")
+			return
+		}
+		if action == "reconcile" {
+			created, err := h.Store.ReconcilePlanBuilds(r.Context(), snap.RunID, snap.ID, snap.Plan)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 				return
 			}
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			names := make([]string, 0, len(created))
+			for _, node := range created {
+				names = append(names, node.Name+"=="+node.Version)
+			}
+			writeJSON(w, http.StatusOK, map[string]any{
+				"detail":  "plan reconciled",
+				"plan_id": snap.ID,
+				"run_id":  snap.RunID,
+				"created": names,
+			})
 			return
 		}
 		if action == "enqueue-builds" {
 			if snap.Queued {
-				writeJSON(w, http.StatusConflict, map[string]string{"error": "plan already enqueued"})
+				writeError(w, http.StatusConflict, codeConflict, "plan already enqueued")
 				return
 			}
 			if err := h.Store.QueueBuildsFromPlan(r.Context(), snap.RunID, snap.ID, snap.Plan); err != nil {
-				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 				return
 			}
 			if h.Store != nil {
@@ -1645,13 +3532,13 @@ func (h *Handler) planByID(w http.ResponseWriter, r *http.Request) {
 			Version string `json:"version"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+			writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid json")
 			return
 		}
 		pkg := strings.TrimSpace(body.Package)
 		ver := strings.TrimSpace(body.Version)
 		if pkg == "" {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "package required"})
+			writeError(w, http.StatusBadRequest, codeInvalidInput, "package required")
 			return
 		}
 		var target *store.PlanNode
@@ -1673,15 +3560,15 @@ func (h *Handler) planByID(w http.ResponseWriter, r *http.Request) {
 			target = &snap.Plan[i]
 		}
 		if ambiguous {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "multiple versions found; include version"})
+			writeError(w, http.StatusBadRequest, codeInvalidInput, "multiple versions found; include version")
 			return
 		}
 		if target == nil {
-			writeJSON(w, http.StatusNotFound, map[string]string{"error": "build node not found"})
+			writeError(w, http.StatusNotFound, codeNotFound, "build node not found")
 			return
 		}
 		if err := h.Store.QueueBuildsFromPlan(r.Context(), snap.RunID, snap.ID, []store.PlanNode{*target}); err != nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 			return
 		}
 		if h.Store != nil {
@@ -1696,7 +3583,7 @@ func (h *Handler) planByID(w http.ResponseWriter, r *http.Request) {
 			"enqueued": 1,
 		})
 	default:
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 	}
 }
 
@@ -1706,45 +3593,199 @@ func (h *Handler) manifest(w http.ResponseWriter, r *http.Request) {
 		limit := parseIntDefault(r.URL.Query().Get("limit"), 200, 1000)
 		res, err := h.Store.Manifest(r.Context(), limit)
 		if err != nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 			return
 		}
 		writeJSON(w, http.StatusOK, res)
 	case http.MethodPost:
 		var entries []store.ManifestEntry
 		if err := json.NewDecoder(r.Body).Decode(&entries); err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+			writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid json")
 			return
 		}
 		if len(entries) == 0 {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "manifest entries required"})
+			writeError(w, http.StatusBadRequest, codeInvalidInput, "manifest entries required")
 			return
 		}
 		if err := h.Store.SaveManifest(r.Context(), entries); err != nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 			return
 		}
 		writeJSON(w, http.StatusOK, map[string]string{"detail": "manifest saved"})
 	default:
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+	}
+}
+
+// manifestLookup answers which {name, version, python_tag, platform_tag}
+// wheels already have a successful manifest entry, so the planner can reuse
+// them instead of queueing builds.
+func (h *Handler) manifestLookup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	var body struct {
+		Wheels []store.ManifestKey `json:"wheels"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid json")
+		return
+	}
+	res, err := h.Store.BuiltManifestEntries(r.Context(), body.Wheels)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	if res == nil {
+		res = []store.ManifestEntry{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"entries": res})
+}
+
+// manifestRebuild re-queues the build that produced a manifest entry so its
+// wheel is built again. Optional python_tag and platform_tag query params
+// pick one build of a matrix. The entry's plan may have reused the wheel, so
+// the build runs against a copy of that plan with the wheel forced to build.
+func (h *Handler) manifestRebuild(w http.ResponseWriter, r *http.Request) {
+	// URL: /api/manifest/{name}/{version}/rebuild
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/manifest/"), "/"), "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] != "rebuild" {
+		writeError(w, http.StatusNotFound, codeNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if h.Store == nil {
+		writeError(w, http.StatusInternalServerError, codeBackendUnavailable, "store not configured")
+		return
+	}
+	key := store.ManifestKey{
+		Name:        parts[0],
+		Version:     parts[1],
+		PythonTag:   strings.TrimSpace(r.URL.Query().Get("python_tag")),
+		PlatformTag: strings.TrimSpace(r.URL.Query().Get("platform_tag")),
+	}
+	target, err := h.Store.ManifestEntryByKey(r.Context(), key)
+	if errors.Is(err, store.ErrNotFound) {
+		writeError(w, http.StatusNotFound, codeNotFound, "manifest entry not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	// Workers resolve build queue items through their plan, so an entry
+	// recorded without one cannot be replayed.
+	if target.PlanID == 0 {
+		writeError(w, http.StatusConflict, codeConflict, "manifest entry has no plan_id")
+		return
+	}
+	snap, err := h.Store.PlanSnapshot(r.Context(), target.PlanID)
+	if errors.Is(err, store.ErrNotFound) {
+		writeError(w, http.StatusConflict, codeConflict, "manifest entry plan no longer exists")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	node := store.PlanNode{
+		Name:        target.Name,
+		Version:     target.Version,
+		PythonTag:   target.PythonTag,
+		PlatformTag: target.PlatformTag,
+		Action:      "build",
+	}
+	dag, err := forceWheelBuild(snap.DAG, node)
+	if err != nil {
+		writeError(w, http.StatusConflict, codeConflict, err.Error())
+		return
+	}
+	runID := fmt.Sprintf("rebuild-%d", time.Now().Unix())
+	planID, err := h.Store.SavePlan(r.Context(), runID, []store.PlanNode{node}, dag)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	if err := h.Store.QueueBuildsFromPlan(r.Context(), runID, planID, []store.PlanNode{node}); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"detail":         "rebuild enqueued",
+		"plan_id":        planID,
+		"source_plan_id": target.PlanID,
+		"run_id":         runID,
+		"package":        node.Name,
+		"version":        node.Version,
+		"python_tag":     node.PythonTag,
+		"platform_tag":   node.PlatformTag,
+	})
+}
+
+// forceWheelBuild returns a copy of a plan DAG whose wheel node for node is
+// set to build, so a worker does not reuse the wheel being rebuilt.
+func forceWheelBuild(dag json.RawMessage, node store.PlanNode) (json.RawMessage, error) {
+	if len(bytes.TrimSpace(dag)) == 0 {
+		return dag, nil
+	}
+	var nodes []map[string]any
+	if err := json.Unmarshal(dag, &nodes); err != nil {
+		return nil, fmt.Errorf("plan dag is not a node list: %w", err)
+	}
+	for _, n := range nodes {
+		if n["type"] != "wheel" {
+			continue
+		}
+		meta, _ := n["metadata"].(map[string]any)
+		name, _ := meta["name"].(string)
+		version, _ := meta["version"].(string)
+		pyTag, _ := meta["python_tag"].(string)
+		platTag, _ := meta["platform_tag"].(string)
+		if !strings.EqualFold(name, node.Name) || version != node.Version {
+			continue
+		}
+		if (pyTag != "" && pyTag != node.PythonTag) || (platTag != "" && platTag != node.PlatformTag) {
+			continue
+		}
+		n["action"] = "build"
 	}
+	return json.Marshal(nodes)
 }
 
 // planCompute proxies a plan computation to the worker (if configured).
 func (h *Handler) planCompute(w http.ResponseWriter, r *http.Request) {
+	h.computePlan(w, r, true)
+}
+
+// planPreview runs the same worker plan computation as planCompute but
+// returns the snapshot without saving it or queueing builds, so operators can
+// inspect what a run would do.
+func (h *Handler) planPreview(w http.ResponseWriter, r *http.Request) {
+	h.computePlan(w, r, false)
+}
+
+func (h *Handler) computePlan(w http.ResponseWriter, r *http.Request, persist bool) {
 	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	if err := h.requireWorkerToken(r); err != nil {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, err.Error())
 		return
 	}
 	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
 	snap, err := h.callWorkerPlan(ctx)
 	if err != nil {
-		writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusBadGateway, codeBackendUnavailable, err.Error())
+		return
+	}
+	if !persist {
+		writeJSON(w, http.StatusOK, snap)
 		return
 	}
 	// Persist plan snapshot if provided
@@ -1786,58 +3827,65 @@ func (h *Handler) planCompute(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, snap)
 }
 
-func pyVersionFromTag(tag, fallback string) string {
-	if fallback != "" {
-		return fallback
-	}
-	tag = strings.TrimPrefix(tag, "cp")
-	if len(tag) == 0 {
-		return ""
-	}
-	if len(tag) == 3 {
-		return fmt.Sprintf("%s.%s", tag[:1], tag[1:])
-	}
-	if len(tag) == 4 {
-		return fmt.Sprintf("%s.%s", tag[:2], tag[2:])
-	}
-	return ""
-}
-
 func (h *Handler) artifacts(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	limit := parseIntDefault(r.URL.Query().Get("limit"), 200, 1000)
 	res, err := h.Store.Artifacts(r.Context(), limit)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 	writeJSON(w, http.StatusOK, res)
 }
 
+// artifactsReferenced lists digests still referenced by plans, builds, and
+// manifests so workers can find orphaned artifacts.
+func (h *Handler) artifactsReferenced(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err := h.requireWorkerToken(r); err != nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, err.Error())
+		return
+	}
+	digests, err := h.Store.ReferencedDigests(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	builds, err := h.Store.ReferencedBuilds(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"digests": digests, "builds": builds})
+}
+
 func (h *Handler) logsIngest(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	if r.ContentLength > 1_000_000 {
-		writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": "log too large"})
+		writeError(w, http.StatusRequestEntityTooLarge, codeTooLarge, "log too large")
 		return
 	}
 	var le store.LogEntry
 	if err := json.NewDecoder(r.Body).Decode(&le); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid json")
 		return
 	}
 	if le.Name == "" || le.Version == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name and version required"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "name and version required")
 		return
 	}
 	le.Timestamp = time.Now().Unix()
 	if err := h.Store.PutLog(r.Context(), le); err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 	writeJSON(w, http.StatusOK, map[string]string{"detail": "log saved"})
@@ -1845,13 +3893,13 @@ func (h *Handler) logsIngest(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) queueList(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	ctx := r.Context()
 	items, err := h.Queue.List(ctx)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 	stats, _ := h.Queue.Stats(ctx)
@@ -1867,12 +3915,12 @@ func (h *Handler) queueList(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) queueStats(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	stats, err := h.Queue.Stats(r.Context())
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 	writeJSON(w, http.StatusOK, stats)
@@ -1880,20 +3928,20 @@ func (h *Handler) queueStats(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) queueEnqueue(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	var req queue.Request
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid json")
 		return
 	}
 	if req.Package == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "package required"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "package required")
 		return
 	}
 	if err := h.Queue.Enqueue(r.Context(), req); err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 	writeJSON(w, http.StatusOK, map[string]string{"detail": "enqueued"})
@@ -1901,11 +3949,11 @@ func (h *Handler) queueEnqueue(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) queueClear(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	if err := h.Queue.Clear(r.Context()); err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 	writeJSON(w, http.StatusOK, map[string]string{"detail": "cleared"})
@@ -1913,11 +3961,11 @@ func (h *Handler) queueClear(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) workerTrigger(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	if err := h.requireWorkerToken(r); err != nil {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, err.Error())
 		return
 	}
 	ctx := r.Context()
@@ -1938,7 +3986,7 @@ func (h *Handler) workerTrigger(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) workers(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	if h.Store == nil {
@@ -1947,7 +3995,7 @@ func (h *Handler) workers(w http.ResponseWriter, r *http.Request) {
 	}
 	list, err := h.Store.ListWorkers(r.Context())
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 	writeJSON(w, http.StatusOK, list)
@@ -1955,11 +4003,11 @@ func (h *Handler) workers(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) workerHeartbeat(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	if err := h.requireWorkerToken(r); err != nil {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, err.Error())
 		return
 	}
 	var body struct {
@@ -1969,13 +4017,15 @@ func (h *Handler) workerHeartbeat(w http.ResponseWriter, r *http.Request) {
 		BuildPoolSize        int    `json:"build_pool_size,omitempty"`
 		PlanPoolSize         int    `json:"plan_pool_size,omitempty"`
 		HeartbeatIntervalSec int    `json:"heartbeat_interval_sec,omitempty"`
+		// Smoke is set by `worker smoke` runs.
+		Smoke json.RawMessage `json:"smoke,omitempty"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid JSON")
 		return
 	}
 	if body.WorkerID == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "worker_id required"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "worker_id required")
 		return
 	}
 	if h.Store == nil {
@@ -1989,9 +4039,10 @@ func (h *Handler) workerHeartbeat(w http.ResponseWriter, r *http.Request) {
 		BuildPoolSize:        body.BuildPoolSize,
 		PlanPoolSize:         body.PlanPoolSize,
 		HeartbeatIntervalSec: body.HeartbeatIntervalSec,
+		Smoke:                body.Smoke,
 	}
 	if err := h.Store.UpsertWorkerStatus(r.Context(), status); err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 	writeJSON(w, http.StatusOK, map[string]string{"detail": "ok"})
@@ -1999,11 +4050,11 @@ func (h *Handler) workerHeartbeat(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) workerSmoke(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	if err := h.requireWorkerToken(r); err != nil {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, err.Error())
 		return
 	}
 	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
@@ -2012,6 +4063,22 @@ func (h *Handler) workerSmoke(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]any{"detail": "smoke-ok", "queue_length": stats.Length})
 }
 
+// hintsEffectiveness reports, per hint, how often its matches ended in a
+// successful build versus a failure. Hints that match but never lead to a
+// build are candidates for removal.
+func (h *Handler) hintsEffectiveness(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	res, err := h.Store.HintEffectiveness(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
 func (h *Handler) hints(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
@@ -2048,76 +4115,77 @@ func (h *Handler) hints(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 		if err != nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 			return
 		}
 		writeJSON(w, http.StatusOK, hints)
 	case http.MethodPost:
 		var hint store.Hint
 		if err := json.NewDecoder(r.Body).Decode(&hint); err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+			writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid json")
 			return
 		}
 		hint = store.NormalizeHint(hint)
 		if errs := store.ValidateHint(hint); len(errs) > 0 {
 			writeJSON(w, http.StatusBadRequest, map[string]any{
 				"error":   "validation failed",
+				"code":    codeInvalidInput,
 				"details": errs,
 			})
 			return
 		}
 		if err := h.Store.PutHint(r.Context(), hint); err != nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 			return
 		}
 		writeJSON(w, http.StatusOK, map[string]string{"detail": "created"})
 	default:
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 	}
 }
 
 func (h *Handler) hintsBulk(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	if h.Store == nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "store not configured"})
+		writeError(w, http.StatusInternalServerError, codeBackendUnavailable, "store not configured")
 		return
 	}
 	var data []byte
 	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
 		if err := r.ParseMultipartForm(10 << 20); err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid form"})
+			writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid form")
 			return
 		}
 		file, _, err := r.FormFile("file")
 		if err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "file required"})
+			writeError(w, http.StatusBadRequest, codeInvalidInput, "file required")
 			return
 		}
 		defer file.Close()
 		data, err = io.ReadAll(file)
 		if err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "failed to read file"})
+			writeError(w, http.StatusBadRequest, codeInvalidInput, "failed to read file")
 			return
 		}
 	} else {
 		var err error
 		data, err = io.ReadAll(r.Body)
 		if err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "failed to read body"})
+			writeError(w, http.StatusBadRequest, codeInvalidInput, "failed to read body")
 			return
 		}
 	}
 	if len(data) == 0 {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "empty payload"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "empty payload")
 		return
 	}
 	var hints []store.Hint
 	if err := yaml.Unmarshal(data, &hints); err != nil {
 		if err2 := json.Unmarshal(data, &hints); err2 != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid yaml/json"})
+			writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid yaml/json")
 			return
 		}
 	}
@@ -2151,21 +4219,21 @@ func (h *Handler) hintsBulk(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) hintByID(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Path[len("/api/hints/"):]
 	if id == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "id required"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "id required")
 		return
 	}
 	switch r.Method {
 	case http.MethodGet:
 		hint, err := h.Store.GetHint(r.Context(), id)
 		if err != nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 			return
 		}
 		writeJSON(w, http.StatusOK, hint)
 	case http.MethodPut:
 		var hint store.Hint
 		if err := json.NewDecoder(r.Body).Decode(&hint); err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+			writeError(w, http.StatusBadRequest, codeInvalidInput, "invalid json")
 			return
 		}
 		hint.ID = id
@@ -2173,45 +4241,46 @@ func (h *Handler) hintByID(w http.ResponseWriter, r *http.Request) {
 		if errs := store.ValidateHint(hint); len(errs) > 0 {
 			writeJSON(w, http.StatusBadRequest, map[string]any{
 				"error":   "validation failed",
+				"code":    codeInvalidInput,
 				"details": errs,
 			})
 			return
 		}
 		if err := h.Store.PutHint(r.Context(), hint); err != nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 			return
 		}
 		writeJSON(w, http.StatusOK, map[string]string{"detail": "updated"})
 	case http.MethodDelete:
 		if err := h.Store.DeleteHint(r.Context(), id); err != nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 			return
 		}
 		writeJSON(w, http.StatusOK, map[string]string{"detail": "deleted"})
 	default:
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 	}
 }
 
 func (h *Handler) logsByNameVersion(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	// Path: /api/logs/{name}/{version}
 	parts := splitPath(r.URL.Path)
 	if len(parts) < 4 {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name/version required"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "name/version required")
 		return
 	}
 	name, version := parts[2], parts[3]
 	logEntry, err := h.Store.GetLog(r.Context(), name, version)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			writeJSON(w, http.StatusNotFound, map[string]string{"error": "log not found"})
+			writeError(w, http.StatusNotFound, codeNotFound, "log not found")
 			return
 		}
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 	raw := r.URL.Query().Get("raw")
@@ -2225,14 +4294,14 @@ func (h *Handler) logsByNameVersion(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) logsSearch(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	q := r.URL.Query().Get("q")
 	limit := parseIntDefault(r.URL.Query().Get("limit"), 50, 200)
 	results, err := h.Store.SearchLogs(r.Context(), q, limit)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 	writeJSON(w, http.StatusOK, results)
@@ -2240,12 +4309,12 @@ func (h *Handler) logsSearch(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) logsChunks(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	parts := splitPath(r.URL.Path)
 	if len(parts) < 5 {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name/version required"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "name/version required")
 		return
 	}
 	name, version := parts[3], parts[4]
@@ -2261,7 +4330,7 @@ func (h *Handler) logsChunks(w http.ResponseWriter, r *http.Request) {
 		chunks, err = h.Store.ListLogChunks(r.Context(), name, version, after, limit)
 	}
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 	writeJSON(w, http.StatusOK, chunks)
@@ -2270,18 +4339,18 @@ func (h *Handler) logsChunks(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) logsStream(w http.ResponseWriter, r *http.Request) {
 	parts := splitPath(r.URL.Path)
 	if len(parts) < 5 {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name/version required"})
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "name/version required")
 		return
 	}
 	name, version := parts[3], parts[4]
 	switch r.Method {
 	case http.MethodPost:
 		if err := h.requireWorkerToken(r); err != nil {
-			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, err.Error())
 			return
 		}
 		if h.Store == nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "store not configured"})
+			writeError(w, http.StatusInternalServerError, codeBackendUnavailable, "store not configured")
 			return
 		}
 		runID := r.URL.Query().Get("run_id")
@@ -2326,7 +4395,7 @@ func (h *Handler) logsStream(w http.ResponseWriter, r *http.Request) {
 			}
 			id, err := h.Store.PutLogChunk(r.Context(), chunk)
 			if err != nil {
-				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 				return
 			}
 			if h.Config.LogChunkMax > 0 {
@@ -2340,7 +4409,7 @@ func (h *Handler) logsStream(w http.ResponseWriter, r *http.Request) {
 			h.getLogHub().publish(key, chunk)
 		}
 		if err := scanner.Err(); err != nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 			return
 		}
 		if h.Config.LogChunkMax > 0 && trimCounter > 0 {
@@ -2382,10 +4451,15 @@ func (h *Handler) logsStream(w http.ResponseWriter, r *http.Request) {
 			}
 		}).ServeHTTP(w, r)
 	default:
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 	}
 }
 
+// parseWindow reads optional from/to epoch-second bounds; zero means open.
+func parseWindow(q url.Values) (int64, int64) {
+	return parseInt64Default(q.Get("from"), 0), parseInt64Default(q.Get("to"), 0)
+}
+
 func parseIntDefault(val string, def int, max int) int {
 	if val == "" {
 		return def
@@ -2507,6 +4581,67 @@ func writeJSON(w http.ResponseWriter, code int, v any) {
 	_ = json.NewEncoder(w).Encode(v)
 }
 
+// weakETag returns a weak validator derived from the JSON encoding of v.
+func weakETag(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether the request's If-None-Match lists tag.
+func etagMatches(r *http.Request, tag string) bool {
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(tag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeJSONWithETag writes v with the given ETag, or an empty 304 when the
+// client already holds that version.
+func writeJSONWithETag(w http.ResponseWriter, r *http.Request, tag string, v any) {
+	if tag != "" {
+		w.Header().Set("ETag", tag)
+		w.Header().Set("Cache-Control", "no-cache")
+		if etagMatches(r, tag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+// writeJSONCached is writeJSON for polled GET endpoints: the ETag is a hash
+// of the body, so unchanged responses cost the client a 304.
+func writeJSONCached(w http.ResponseWriter, r *http.Request, v any) {
+	writeJSONWithETag(w, r, weakETag(v), v)
+}
+
+// Machine-readable error codes carried in the "code" field of error responses.
+const (
+	codeInvalidInput       = "invalid_input"
+	codeUnauthorized       = "unauthorized"
+	codeForbidden          = "forbidden"
+	codeNotFound           = "not_found"
+	codeMethodNotAllowed   = "method_not_allowed"
+	codeConflict           = "conflict"
+	codeTooLarge           = "payload_too_large"
+	codeNotImplemented     = "not_implemented"
+	codeBackendUnavailable = "backend_unavailable"
+	codeInternal           = "internal"
+)
+
+// writeError writes {"error": msg, "code": code}. The error field is kept for
+// clients that predate the code field.
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg, "code": code})
+}
+
 func toString(v any) string {
 	if v == nil {
 		return ""
@@ -1,13 +1,30 @@
 package api
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"io"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/smtp"
+	"net/url"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/k8ika0s/s390x-wheel-refinery/go-control-plane/internal/config"
 	"github.com/k8ika0s/s390x-wheel-refinery/go-control-plane/internal/queue"
@@ -29,20 +46,42 @@ type fakeStore struct {
 	}
 	restoredPendingID int64
 	queuedBuilds      []store.PlanNode
+	queuedPlanID      int64
+	recordedEvents    []store.Event
+	savedSettings     *settings.Settings
+	manifest          []store.ManifestEntry
+	purgeKeys         []string
+	purgedOlderThan   int
+	avgDurations      map[string]float64
+	builds            []store.BuildStatus
+	queueStats        store.BuildQueueStats
+	topFailures       []store.Stat
+	variants          []store.Event
+	heldFixes         map[string][]string
+	statusUpdates     []string
+	savePlanCalls     int
+	lastRunID         string
+	lastDAG           json.RawMessage
+	queuePlanCalls    int
+	cancelRequested   map[string]bool
+	planTampered      bool
 }
 
-func (f *fakeStore) Recent(ctx context.Context, limit, offset int, pkg, status string) ([]store.Event, error) {
+func (f *fakeStore) Recent(ctx context.Context, limit, offset int, pkg, nameLike, status string) ([]store.Event, error) {
 	return nil, nil
 }
 func (f *fakeStore) History(ctx context.Context, filter store.HistoryFilter) ([]store.Event, error) {
 	return nil, nil
 }
-func (f *fakeStore) Summary(ctx context.Context, failureLimit int) (store.Summary, error) {
+func (f *fakeStore) Summary(ctx context.Context, failureLimit int, fromTs, toTs int64) (store.Summary, error) {
 	return store.Summary{}, nil
 }
 func (f *fakeStore) PackageSummary(ctx context.Context, name string) (store.PackageSummary, error) {
 	return store.PackageSummary{}, nil
 }
+func (f *fakeStore) SearchPackageNames(ctx context.Context, prefix string, limit int) ([]string, error) {
+	return nil, nil
+}
 func (f *fakeStore) LatestEvent(ctx context.Context, name, version string) (store.Event, error) {
 	return store.Event{}, nil
 }
@@ -50,17 +89,27 @@ func (f *fakeStore) Failures(ctx context.Context, name string, limit int) ([]sto
 	return nil, nil
 }
 func (f *fakeStore) Variants(ctx context.Context, name string, limit int) ([]store.Event, error) {
-	return nil, nil
+	return f.variants, nil
 }
-func (f *fakeStore) TopFailures(ctx context.Context, limit int) ([]store.Stat, error) {
-	return nil, nil
+func (f *fakeStore) TopFailures(ctx context.Context, limit int, fromTs, toTs int64) ([]store.Stat, error) {
+	return f.topFailures, nil
 }
-func (f *fakeStore) TopSlowest(ctx context.Context, limit int) ([]store.Stat, error) {
+func (f *fakeStore) TopSlowest(ctx context.Context, limit int, fromTs, toTs int64) ([]store.Stat, error) {
 	return nil, nil
 }
-func (f *fakeStore) RecordEvent(ctx context.Context, evt store.Event) error {
+func (f *fakeStore) AvgDurations(ctx context.Context, names []string) (map[string]float64, error) {
+	return f.avgDurations, nil
+}
+func (f *fakeStore) RecordEvent(ctx context.Context, evt store.Event) (bool, error) {
 	f.lastEvent = evt
-	return nil
+	return true, nil
+}
+func (f *fakeStore) RecordEvents(ctx context.Context, events []store.Event) (int64, error) {
+	f.recordedEvents = append(f.recordedEvents, events...)
+	return int64(len(events)), nil
+}
+func (f *fakeStore) HintEffectiveness(ctx context.Context) ([]store.HintEffectiveness, error) {
+	return nil, nil
 }
 func (f *fakeStore) ListHints(ctx context.Context) ([]store.Hint, error) {
 	return nil, nil
@@ -99,7 +148,11 @@ func (f *fakeStore) Plan(ctx context.Context) ([]store.PlanNode, error) {
 	return f.lastPlan, nil
 }
 func (f *fakeStore) PlanSnapshot(ctx context.Context, planID int64) (store.PlanSnapshot, error) {
-	return store.PlanSnapshot{ID: planID, RunID: "test", Plan: f.lastPlan}, nil
+	runID := f.lastRunID
+	if runID == "" {
+		runID = "test"
+	}
+	return store.PlanSnapshot{ID: planID, RunID: runID, Plan: f.lastPlan, DAG: f.lastDAG, Tampered: f.planTampered}, nil
 }
 func (f *fakeStore) LatestPlanSnapshot(ctx context.Context) (store.PlanSnapshot, error) {
 	return store.PlanSnapshot{ID: 1, RunID: "latest", Plan: f.lastPlan}, nil
@@ -108,25 +161,68 @@ func (f *fakeStore) ListPlans(ctx context.Context, limit int) ([]store.PlanSumma
 	return nil, nil
 }
 func (f *fakeStore) SavePlan(ctx context.Context, runID string, nodes []store.PlanNode, dag json.RawMessage) (int64, error) {
+	f.savePlanCalls++
 	f.lastPlan = nodes
+	f.lastRunID = runID
+	f.lastDAG = dag
 	return 1, nil
 }
 func (f *fakeStore) DeletePlans(ctx context.Context, planID int64) (int64, error) {
 	return 0, nil
 }
 func (f *fakeStore) QueueBuildsFromPlan(ctx context.Context, runID string, planID int64, nodes []store.PlanNode) error {
+	f.queuePlanCalls++
 	f.queuedBuilds = append(f.queuedBuilds, nodes...)
+	f.queuedPlanID = planID
 	return nil
 }
+func (f *fakeStore) ReconcilePlanBuilds(ctx context.Context, runID string, planID int64, nodes []store.PlanNode) ([]store.PlanNode, error) {
+	var created []store.PlanNode
+	for _, n := range nodes {
+		if n.Action != "build" {
+			continue
+		}
+		exists := false
+		for _, b := range f.builds {
+			if b.Package == n.Name && b.Version == n.Version {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			f.builds = append(f.builds, store.BuildStatus{Package: n.Name, Version: n.Version, Status: "pending", PlanID: planID})
+			created = append(created, n)
+		}
+	}
+	return created, nil
+}
 func (f *fakeStore) Manifest(ctx context.Context, limit int) ([]store.ManifestEntry, error) {
-	return nil, nil
+	return f.manifest, nil
 }
 func (f *fakeStore) SaveManifest(ctx context.Context, entries []store.ManifestEntry) error {
 	return nil
 }
+func (f *fakeStore) BuiltManifestEntries(ctx context.Context, keys []store.ManifestKey) ([]store.ManifestEntry, error) {
+	return nil, nil
+}
+func (f *fakeStore) ManifestEntryByKey(ctx context.Context, key store.ManifestKey) (store.ManifestEntry, error) {
+	for _, m := range f.manifest {
+		if strings.EqualFold(m.Name, key.Name) && m.Version == key.Version &&
+			(key.PythonTag == "" || m.PythonTag == key.PythonTag) && (key.PlatformTag == "" || m.PlatformTag == key.PlatformTag) {
+			return m, nil
+		}
+	}
+	return store.ManifestEntry{}, store.ErrNotFound
+}
 func (f *fakeStore) Artifacts(ctx context.Context, limit int) ([]store.Artifact, error) {
 	return nil, nil
 }
+func (f *fakeStore) ReferencedDigests(ctx context.Context) ([]string, error) {
+	return nil, nil
+}
+func (f *fakeStore) ReferencedBuilds(ctx context.Context) ([]store.ManifestKey, error) {
+	return nil, nil
+}
 func (f *fakeStore) AddPendingInput(ctx context.Context, pi store.PendingInput) (int64, error) {
 	if f.nextPendingID == 0 {
 		f.nextPendingID = 1
@@ -157,19 +253,33 @@ func (f *fakeStore) RestorePendingInput(ctx context.Context, id int64) (store.Pe
 	f.restoredPendingID = id
 	return store.PendingInput{ID: id, Status: "pending"}, nil
 }
+func (f *fakeStore) RollupEvents(ctx context.Context, olderThanSec int) (int, error) {
+	return 0, nil
+}
+func (f *fakeStore) PurgePendingInputs(ctx context.Context, olderThanSec int) ([]string, error) {
+	f.purgedOlderThan = olderThanSec
+	return f.purgeKeys, nil
+}
 func (f *fakeStore) LinkPlanToPendingInput(ctx context.Context, pendingID, planID int64) error {
 	return nil
 }
+func (f *fakeStore) LatestPlanForPendingInput(ctx context.Context, pendingID int64) (store.PlanSnapshot, error) {
+	return store.PlanSnapshot{}, store.ErrNotFound
+}
 func (f *fakeStore) UpdatePendingInputsForPlan(ctx context.Context, planID int64, status string) (int64, error) {
 	return 0, nil
 }
 func (f *fakeStore) ListBuilds(ctx context.Context, status string, limit int, planID int64, pkg string, version string) ([]store.BuildStatus, error) {
-	return nil, nil
+	return f.builds, nil
 }
 func (f *fakeStore) BuildQueueStats(ctx context.Context) (store.BuildQueueStats, error) {
-	return store.BuildQueueStats{}, nil
+	return f.queueStats, nil
+}
+func (f *fakeStore) FailureCategories(ctx context.Context) ([]store.Stat, error) {
+	return nil, nil
 }
-func (f *fakeStore) UpdateBuildStatus(ctx context.Context, pkg, version, status, errMsg, summary string, attempts int, backoffUntil int64, recipes []string, hintIDs []string) error {
+func (f *fakeStore) UpdateBuildStatus(ctx context.Context, pkg, version, pythonTag, status, errMsg, summary, category string, attempts int, backoffUntil int64, recipes []string, hintIDs []string) error {
+	f.statusUpdates = append(f.statusUpdates, pkg+"@"+version+"/"+pythonTag+"="+status)
 	return nil
 }
 func (f *fakeStore) LeaseBuilds(ctx context.Context, max int) ([]store.BuildStatus, error) {
@@ -181,6 +291,48 @@ func (f *fakeStore) RequeueStaleLeases(ctx context.Context, maxAgeSec int) (int6
 func (f *fakeStore) DeleteBuilds(ctx context.Context, status string) (int64, error) {
 	return 0, nil
 }
+func (f *fakeStore) TransitionBuilds(ctx context.Context, fromStatus, toStatus string, resetAttempts bool) (int64, error) {
+	return 0, nil
+}
+func (f *fakeStore) HoldBuildFix(ctx context.Context, pkg, version, pythonTag string, recipes []string) error {
+	if f.heldFixes == nil {
+		f.heldFixes = map[string][]string{}
+	}
+	f.heldFixes[pkg+"@"+version] = recipes
+	return nil
+}
+func (f *fakeStore) ApproveBuildFix(ctx context.Context, pkg, version, pythonTag string) ([]string, error) {
+	recipes, ok := f.heldFixes[pkg+"@"+version]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	delete(f.heldFixes, pkg+"@"+version)
+	return recipes, nil
+}
+func (f *fakeStore) RequestBuildCancel(ctx context.Context, pkg, version, pythonTag string) (string, error) {
+	for i := range f.builds {
+		b := &f.builds[i]
+		if b.Package != pkg || b.Version != version {
+			continue
+		}
+		switch b.Status {
+		case "pending", "retry":
+			b.Status = "cancelled"
+		case "leased", "building":
+			if f.cancelRequested == nil {
+				f.cancelRequested = map[string]bool{}
+			}
+			f.cancelRequested[pkg+"@"+version] = true
+		default:
+			continue
+		}
+		return b.Status, nil
+	}
+	return "", store.ErrNotFound
+}
+func (f *fakeStore) BuildCancelRequested(ctx context.Context, pkg, version, pythonTag string) (bool, error) {
+	return f.cancelRequested[pkg+"@"+version], nil
+}
 func (f *fakeStore) UpsertWorkerStatus(ctx context.Context, status store.WorkerStatus) error {
 	return nil
 }
@@ -188,16 +340,21 @@ func (f *fakeStore) ListWorkers(ctx context.Context) ([]store.WorkerStatus, erro
 	return nil, nil
 }
 func (f *fakeStore) GetSettings(ctx context.Context) (settings.Settings, error) {
+	if f.savedSettings != nil {
+		return *f.savedSettings, nil
+	}
 	return settings.ApplyDefaults(settings.Settings{}), nil
 }
 func (f *fakeStore) SaveSettings(ctx context.Context, s settings.Settings) error {
+	f.savedSettings = &s
 	return nil
 }
 
 // fakeQueue implements only Stats for these tests.
 type fakeQueue struct {
-	length int
-	enq    []queue.Request
+	length    int
+	oldestAge int64
+	enq       []queue.Request
 }
 
 func (f *fakeQueue) Enqueue(ctx context.Context, req queue.Request) error {
@@ -207,14 +364,15 @@ func (f *fakeQueue) Enqueue(ctx context.Context, req queue.Request) error {
 func (f *fakeQueue) List(ctx context.Context) ([]queue.Request, error) { return nil, nil }
 func (f *fakeQueue) Clear(ctx context.Context) error                   { return nil }
 func (f *fakeQueue) Stats(ctx context.Context) (queue.Stats, error) {
-	return queue.Stats{Length: f.length}, nil
+	return queue.Stats{Length: f.length, OldestAge: f.oldestAge}, nil
 }
 func (f *fakeQueue) Pop(ctx context.Context, max int) ([]queue.Request, error) { return nil, nil }
 
 type fakePlanQueue struct {
-	ids []string
-	err error
-	pop []string
+	ids    []string
+	err    error
+	pop    []string
+	oldest int64
 }
 
 func (f *fakePlanQueue) Enqueue(ctx context.Context, id string) error {
@@ -231,14 +389,37 @@ func (f *fakePlanQueue) Pop(ctx context.Context, max int) ([]string, error) {
 func (f *fakePlanQueue) Len(ctx context.Context) (int64, error) {
 	return int64(len(f.ids) + len(f.pop)), f.err
 }
+func (f *fakePlanQueue) List(ctx context.Context) ([]string, error) {
+	return f.ids, f.err
+}
 func (f *fakePlanQueue) Clear(ctx context.Context) ([]string, error) {
 	return f.ids, f.err
 }
+func (f *fakePlanQueue) OldestAge(ctx context.Context) (int64, error) {
+	return f.oldest, f.err
+}
+func (f *fakePlanQueue) Remove(ctx context.Context, id string) (int, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	kept := f.ids[:0]
+	removed := 0
+	for _, item := range f.ids {
+		if itemID, _, _ := strings.Cut(item, "?"); itemID == id {
+			removed++
+			continue
+		}
+		kept = append(kept, item)
+	}
+	f.ids = kept
+	return removed, nil
+}
 
 type fakeObjectStore struct {
 	lastKey         string
 	lastContentType string
 	lastData        []byte
+	deleted         []string
 }
 
 func (f *fakeObjectStore) Put(_ context.Context, key string, data []byte, contentType string) error {
@@ -248,6 +429,11 @@ func (f *fakeObjectStore) Put(_ context.Context, key string, data []byte, conten
 	return nil
 }
 
+func (f *fakeObjectStore) Delete(_ context.Context, key string) error {
+	f.deleted = append(f.deleted, key)
+	return nil
+}
+
 func (f *fakeObjectStore) URL(key string) string {
 	return "http://example/" + key
 }
@@ -310,93 +496,176 @@ func TestPlanComputeProxiesToWorker(t *testing.T) {
 	}
 }
 
-func TestPlanPostSavesPlan(t *testing.T) {
+func TestPlanPreviewDoesNotPersist(t *testing.T) {
+	workerPlan := []byte(`{"run_id":"w1","plan":[{"name":"pkg","version":"1.0","python_tag":"cp311","platform_tag":"manylinux2014_s390x","action":"build"}]}`)
+	worker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(workerPlan)
+	}))
+	defer worker.Close()
+
 	fs := &fakeStore{}
-	fq := &fakeQueue{}
-	h := &Handler{Store: fs, Queue: fq, Config: config.Config{AutoBuild: true}}
+	h := &Handler{Store: fs, Queue: &fakeQueue{}, Config: config.Config{WorkerPlanURL: worker.URL, AutoBuild: true}}
 	mux := http.NewServeMux()
 	h.Routes(mux)
 	ts := httptest.NewServer(mux)
 	defer ts.Close()
 
-	body := bytes.NewBufferString(`{"run_id":"r1","plan":[{"name":"pkg","version":"1.0","python_tag":"cp311","platform_tag":"manylinux2014_s390x","action":"build"}]}`)
-	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/plan", body)
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := http.Post(ts.URL+"/api/plan/preview", "application/json", nil)
 	if err != nil {
-		t.Fatalf("post: %v", err)
+		t.Fatalf("post plan preview: %v", err)
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("status: %d", resp.StatusCode)
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
 	}
-	if len(fs.lastPlan) != 1 {
-		t.Fatalf("plan not saved")
+	var snap map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&snap); err != nil {
+		t.Fatalf("decode: %v", err)
 	}
-	if len(fs.queuedBuilds) != 1 || fs.queuedBuilds[0].Name != "pkg" {
-		t.Fatalf("expected enqueue from plan, got %+v", fs.queuedBuilds)
+	if plan, _ := snap["plan"].([]any); len(plan) != 1 {
+		t.Fatalf("expected the worker plan in the response, got %+v", snap)
+	}
+	if fs.savePlanCalls != 0 || fs.queuePlanCalls != 0 {
+		t.Fatalf("preview must not persist: SavePlan=%d QueueBuildsFromPlan=%d", fs.savePlanCalls, fs.queuePlanCalls)
 	}
 }
 
-func TestHistoryPostRecordsEvent(t *testing.T) {
-	fs := &fakeStore{}
-	h := &Handler{Store: fs, Queue: &fakeQueue{}, Config: config.Config{}}
+func TestEnqueueBuildsRejectsTamperedPlan(t *testing.T) {
+	fs := &fakeStore{lastPlan: []store.PlanNode{{Name: "numpy", Version: "1.26.4", Action: "build"}}, planTampered: true}
 	mux := http.NewServeMux()
-	h.Routes(mux)
+	(&Handler{Store: fs, Queue: &fakeQueue{}}).Routes(mux)
 	ts := httptest.NewServer(mux)
 	defer ts.Close()
 
-	body := bytes.NewBufferString(`{"name":"pkg","version":"1.0","status":"built","python_tag":"cp311","platform_tag":"manylinux2014_s390x"}`)
-	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/history", body)
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := http.Post(ts.URL+"/api/plan/3/enqueue-builds", "application/json", nil)
 	if err != nil {
-		t.Fatalf("post: %v", err)
+		t.Fatalf("enqueue: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict || !strings.Contains(string(body), "integrity check") {
+		t.Fatalf("expected 409 integrity error, got %d: %s", resp.StatusCode, body)
+	}
+	if fs.queuePlanCalls != 0 {
+		t.Fatalf("tampered plan must not be enqueued")
+	}
+}
+
+func TestPlanExportImportRoundTrip(t *testing.T) {
+	nodes := []store.PlanNode{
+		{Name: "numpy", Version: "1.26.4", PythonTag: "cp311", PlatformTag: "manylinux2014_s390x", Action: "build",
+			Recipes: []store.PlanRecipe{{Name: "dnf:openblas-devel"}}},
+		{Name: "six", Version: "1.16.0", Action: "reuse"},
+	}
+	dag := json.RawMessage(`{"nodes":[{"id":"numpy"},{"id":"six"}]}`)
+	src := &fakeStore{}
+	if _, err := src.SavePlan(context.Background(), "staging-7", nodes, dag); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	srcMux := http.NewServeMux()
+	(&Handler{Store: src, Queue: &fakeQueue{}}).Routes(srcMux)
+	srcTS := httptest.NewServer(srcMux)
+	defer srcTS.Close()
+
+	resp, err := http.Get(srcTS.URL + "/api/plan/1/export")
+	if err != nil {
+		t.Fatalf("export: %v", err)
 	}
+	exported, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("status: %d", resp.StatusCode)
+		t.Fatalf("export status %d: %s", resp.StatusCode, exported)
 	}
-	if fs.lastEvent.Name != "pkg" || fs.lastEvent.Version != "1.0" || fs.lastEvent.Status != "built" {
-		t.Fatalf("event not recorded: %+v", fs.lastEvent)
+	if !strings.Contains(resp.Header.Get("Content-Disposition"), "plan-1.json") {
+		t.Fatalf("expected attachment filename, got %q", resp.Header.Get("Content-Disposition"))
 	}
-	if fs.lastEvent.Timestamp == 0 {
-		t.Fatalf("timestamp not set")
+
+	dst := &fakeStore{}
+	dstMux := http.NewServeMux()
+	(&Handler{Store: dst, Queue: &fakeQueue{}, Config: config.Config{AutoBuild: true}}).Routes(dstMux)
+	dstTS := httptest.NewServer(dstMux)
+	defer dstTS.Close()
+
+	resp, err = http.Post(dstTS.URL+"/api/plan/import", "application/json", bytes.NewReader(exported))
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("import status %d: %s", resp.StatusCode, body)
+	}
+	if dst.lastRunID != "staging-7" || !reflect.DeepEqual(dst.lastPlan, nodes) {
+		t.Fatalf("imported plan mismatch: run %q nodes %+v", dst.lastRunID, dst.lastPlan)
+	}
+	var gotDAG, wantDAG any
+	_ = json.Unmarshal(dst.lastDAG, &gotDAG)
+	_ = json.Unmarshal(dag, &wantDAG)
+	if !reflect.DeepEqual(gotDAG, wantDAG) {
+		t.Fatalf("imported dag mismatch: %s", dst.lastDAG)
+	}
+	if dst.queuePlanCalls != 0 {
+		t.Fatalf("import must not queue builds")
+	}
+
+	resp, err = http.Post(dstTS.URL+"/api/plan/import", "application/json", strings.NewReader(`{"plan":[{"name":"pkg","action":"build"}]}`))
+	if err != nil {
+		t.Fatalf("import invalid: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || dst.savePlanCalls != 1 {
+		t.Fatalf("expected invalid plan to be rejected, got %d (saves %d)", resp.StatusCode, dst.savePlanCalls)
 	}
 }
 
-func TestPendingInputsList(t *testing.T) {
-	fs := &fakeStore{listPending: []store.PendingInput{{ID: 1, Filename: "requirements-123.txt", Status: "pending"}}}
-	h := &Handler{Store: fs, Queue: &fakeQueue{}, Config: config.Config{}}
+func TestPlanReconcileCreatesMissingBuilds(t *testing.T) {
+	fs := &fakeStore{
+		lastPlan: []store.PlanNode{
+			{Name: "numpy", Version: "1.26.4", Action: "build"},
+			{Name: "scipy", Version: "1.13.0", Action: "build"},
+		},
+		builds: []store.BuildStatus{{Package: "numpy", Version: "1.26.4", Status: "built"}},
+	}
 	mux := http.NewServeMux()
-	h.Routes(mux)
+	(&Handler{Store: fs, Queue: &fakeQueue{}}).Routes(mux)
 	ts := httptest.NewServer(mux)
 	defer ts.Close()
 
-	resp, err := http.Get(ts.URL + "/api/pending-inputs")
+	resp, err := http.Post(ts.URL+"/api/plan/3/reconcile", "application/json", nil)
 	if err != nil {
-		t.Fatalf("get: %v", err)
+		t.Fatalf("post reconcile: %v", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Created []string `json:"created"`
 	}
 	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("status: %d", resp.StatusCode)
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
 	}
-	var out []store.PendingInput
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
 		t.Fatalf("decode: %v", err)
 	}
-	if len(out) != 1 || out[0].Filename != "requirements-123.txt" {
-		t.Fatalf("unexpected list: %+v", out)
+	if len(body.Created) != 1 || body.Created[0] != "scipy==1.13.0" {
+		t.Fatalf("expected scipy to be created, got %v", body.Created)
+	}
+	if len(fs.builds) != 2 || fs.builds[1].Status != "pending" || fs.builds[1].PlanID != 3 {
+		t.Fatalf("missing build not queued: %+v", fs.builds)
 	}
 }
 
-func TestPendingInputManualEnqueue(t *testing.T) {
+func TestPlanPostSavesPlan(t *testing.T) {
 	fs := &fakeStore{}
-	pq := &fakePlanQueue{}
-	h := &Handler{Store: fs, Queue: &fakeQueue{}, PlanQ: pq, Config: config.Config{}}
+	fq := &fakeQueue{}
+	h := &Handler{Store: fs, Queue: fq, Config: config.Config{AutoBuild: true}}
 	mux := http.NewServeMux()
 	h.Routes(mux)
 	ts := httptest.NewServer(mux)
 	defer ts.Close()
 
-	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/pending-inputs/5/enqueue-plan", nil)
+	body := bytes.NewBufferString(`{"run_id":"r1","plan":[{"name":"pkg","version":"1.0","python_tag":"cp311","platform_tag":"manylinux2014_s390x","action":"build"}]}`)
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/plan", body)
+	req.Header.Set("Content-Type", "application/json")
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		t.Fatalf("post: %v", err)
@@ -404,130 +673,2092 @@ func TestPendingInputManualEnqueue(t *testing.T) {
 	if resp.StatusCode != http.StatusOK {
 		t.Fatalf("status: %d", resp.StatusCode)
 	}
-	if len(pq.ids) != 1 || pq.ids[0] != "5" {
-		t.Fatalf("expected enqueue id 5, got %+v", pq.ids)
+	if len(fs.lastPlan) != 1 {
+		t.Fatalf("plan not saved")
 	}
-	if len(fs.pendingStatuses) == 0 || fs.pendingStatuses[0].status != "planning" {
-		t.Fatalf("expected status update planning, got %+v", fs.pendingStatuses)
+	if len(fs.queuedBuilds) != 1 || fs.queuedBuilds[0].Name != "pkg" {
+		t.Fatalf("expected enqueue from plan, got %+v", fs.queuedBuilds)
 	}
 }
 
-func TestPendingInputPop(t *testing.T) {
+func TestPlanPostRejectsInvalidNodes(t *testing.T) {
 	fs := &fakeStore{}
-	pq := &fakePlanQueue{pop: []string{"7", "8"}}
-	h := &Handler{Store: fs, Queue: &fakeQueue{}, PlanQ: pq, Config: config.Config{}}
+	h := &Handler{Store: fs, Queue: &fakeQueue{}, Config: config.Config{AutoBuild: true}}
 	mux := http.NewServeMux()
 	h.Routes(mux)
 	ts := httptest.NewServer(mux)
 	defer ts.Close()
 
-	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/pending-inputs/pop?max=2", nil)
-	resp, err := http.DefaultClient.Do(req)
+	body := bytes.NewBufferString(`{"run_id":"r1","plan":[
+		{"name":"ok","version":"1.0","python_tag":"cp311","platform_tag":"manylinux2014_s390x","action":"build"},
+		{"name":"pkg","version":"1.0","python_tag":"cp311","platform_tag":"manylinux2014_s390x","action":"rebuild"}]}`)
+	resp, err := http.Post(ts.URL+"/api/plan", "application/json", body)
 	if err != nil {
 		t.Fatalf("post: %v", err)
 	}
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("status: %d", resp.StatusCode)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", resp.StatusCode)
+	}
+	var out struct {
+		Code    string   `json:"code"`
+		Details []string `json:"details"`
 	}
-	var out map[string]any
 	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
 		t.Fatalf("decode: %v", err)
 	}
-	idsAny, ok := out["ids"].([]any)
-	if !ok || len(idsAny) != 2 {
-		t.Fatalf("unexpected ids: %+v", out)
+	if out.Code != codeInvalidInput || len(out.Details) != 1 || !strings.HasPrefix(out.Details[0], "plan[1] pkg 1.0: action") {
+		t.Fatalf("unexpected error body: %+v", out)
 	}
-	if len(fs.pendingStatuses) != 2 || fs.pendingStatuses[0].status != "planning" || fs.pendingStatuses[1].status != "planning" {
-		t.Fatalf("expected planning status updates, got %+v", fs.pendingStatuses)
+	if fs.lastPlan != nil || len(fs.queuedBuilds) != 0 {
+		t.Fatalf("invalid plan should not be saved or queued")
 	}
 }
 
-func TestPendingInputStatusUpdate(t *testing.T) {
+func TestPlanPostAcceptsActionInAnyCase(t *testing.T) {
 	fs := &fakeStore{}
-	h := &Handler{Store: fs, Queue: &fakeQueue{}, Config: config.Config{}}
+	h := &Handler{Store: fs, Queue: &fakeQueue{}, Config: config.Config{AutoBuild: true}}
 	mux := http.NewServeMux()
 	h.Routes(mux)
 	ts := httptest.NewServer(mux)
 	defer ts.Close()
 
-	body := bytes.NewBufferString(`{"status":"planned","error":""}`)
-	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/pending-inputs/status/9", body)
-	resp, err := http.DefaultClient.Do(req)
+	body := bytes.NewBufferString(`{"run_id":"r1","plan":[{"name":"pkg","version":"1.0","python_tag":"cp311","platform_tag":"manylinux2014_s390x","action":"Build"}]}`)
+	resp, err := http.Post(ts.URL+"/api/plan", "application/json", body)
 	if err != nil {
 		t.Fatalf("post: %v", err)
 	}
+	resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("status: %d", resp.StatusCode)
+		t.Fatalf("expected a mixed-case action to validate, got %d", resp.StatusCode)
 	}
-	if len(fs.pendingStatuses) != 1 || fs.pendingStatuses[0].id != 9 || fs.pendingStatuses[0].status != "planned" {
-		t.Fatalf("expected planned update, got %+v", fs.pendingStatuses)
+	if len(fs.lastPlan) != 1 || len(fs.queuedBuilds) != 1 {
+		t.Fatalf("expected plan saved and queued, got plan=%d queued=%d", len(fs.lastPlan), len(fs.queuedBuilds))
 	}
 }
 
-func TestPendingInputRestore(t *testing.T) {
-	fs := &fakeStore{}
-	h := &Handler{Store: fs, Queue: &fakeQueue{}, Config: config.Config{}}
+func TestPlanEstimateSumsHistoricalDurations(t *testing.T) {
+	fs := &fakeStore{
+		lastPlan: []store.PlanNode{
+			{Name: "NumPy", Version: "1.26.4", Action: "build"},
+			{Name: "scipy", Version: "1.12.0", Action: "build"},
+			{Name: "pandas", Version: "2.2.0", Action: "build"},
+			{Name: "six", Version: "1.16.0", Action: "reuse"},
+		},
+		avgDurations: map[string]float64{"numpy": 90000, "scipy": 240500, "six": 1000},
+	}
+	h := &Handler{Store: fs, Queue: &fakeQueue{}}
 	mux := http.NewServeMux()
 	h.Routes(mux)
 	ts := httptest.NewServer(mux)
 	defer ts.Close()
 
-	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/pending-inputs/12/restore", nil)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := http.Get(ts.URL + "/api/plan/7/estimate")
 	if err != nil {
-		t.Fatalf("post: %v", err)
+		t.Fatalf("get: %v", err)
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("status: %d", resp.StatusCode)
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
 	}
-	if fs.restoredPendingID != 12 {
-		t.Fatalf("expected restore id 12, got %d", fs.restoredPendingID)
+	var out struct {
+		PlanID           int64   `json:"plan_id"`
+		BuildCount       int     `json:"build_count"`
+		EstimatedSeconds float64 `json:"estimated_seconds"`
+		UnknownCount     int     `json:"unknown_count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if out.PlanID != 7 || out.BuildCount != 3 || out.EstimatedSeconds != 331 || out.UnknownCount != 1 {
+		t.Fatalf("unexpected estimate: %+v", out)
 	}
 }
 
-func TestRequirementsUploadAutoEnqueue(t *testing.T) {
-	fs := &fakeStore{nextPendingID: 42}
-	pq := &fakePlanQueue{}
-	fo := &fakeObjectStore{}
-	h := &Handler{
-		Store: fs, Queue: &fakeQueue{}, PlanQ: pq, InputStore: fo,
-		Config: config.Config{
-			AutoPlan:            true,
-			ObjectStoreEndpoint: "minio:9000",
-			ObjectStoreBucket:   "inputs",
-			InputObjectPrefix:   "inputs",
-		},
+func TestBuildStatusUpdateKeysOnPythonTag(t *testing.T) {
+	fs := &fakeStore{}
+	h := &Handler{Store: fs, Queue: &fakeQueue{}}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	for _, body := range []string{
+		`{"package":"demo","version":"1.0","python_tag":"cp310","status":"built"}`,
+		`{"package":"demo","version":"1.0","python_tag":"cp311","status":"failed"}`,
+		`{"package":"demo","version":"1.0","status":"building"}`,
+	} {
+		resp, err := http.Post(ts.URL+"/api/builds/status", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", resp.StatusCode)
+		}
+	}
+	want := []string{"demo@1.0/cp310=built", "demo@1.0/cp311=failed", "demo@1.0/=building"}
+	if !reflect.DeepEqual(fs.statusUpdates, want) {
+		t.Fatalf("expected per-tag status updates %v, got %v", want, fs.statusUpdates)
 	}
+}
+
+func TestTerminalBuildStatusTriggersWebhook(t *testing.T) {
+	type delivery struct {
+		body      []byte
+		signature string
+	}
+	got := make(chan delivery, 4)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		got <- delivery{body: data, signature: r.Header.Get("X-Refinery-Signature")}
+	}))
+	defer hook.Close()
+
+	fs := &fakeStore{savedSettings: &settings.Settings{WebhookURLs: []string{hook.URL}, WebhookSecret: "s3cret"}}
+	h := &Handler{Store: fs, Queue: &fakeQueue{}}
 	mux := http.NewServeMux()
 	h.Routes(mux)
 	ts := httptest.NewServer(mux)
 	defer ts.Close()
 
-	body, contentType := mustMultipart(t, "requirements.txt", "pkg==1.0\n")
-	resp, err := http.Post(ts.URL+"/api/requirements/upload", contentType, body)
-	if err != nil {
-		t.Fatalf("post: %v", err)
+	post := func(status string) {
+		body := fmt.Sprintf(`{"package":"numpy","version":"1.26.4","status":%q,"error":"boom","run_id":"run-9"}`, status)
+		resp, err := http.Post(ts.URL+"/api/builds/status", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", resp.StatusCode)
+		}
 	}
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("status: %d", resp.StatusCode)
+	post("building")
+	post("dead_letter")
+	post("failed")
+
+	var d delivery
+	select {
+	case d = <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("webhook was not called")
 	}
-	var out map[string]any
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		t.Fatalf("decode: %v", err)
+	var payload buildOutcome
+	if err := json.Unmarshal(d.body, &payload); err != nil {
+		t.Fatalf("decode payload: %v", err)
 	}
-	if pending, ok := out["pending_id"].(float64); !ok || int64(pending) != 42 {
-		t.Fatalf("expected pending_id 42, got %v", out["pending_id"])
+	want := buildOutcome{Package: "numpy", Version: "1.26.4", Status: "failed", Error: "boom", RunID: "run-9"}
+	if payload != want {
+		t.Fatalf("unexpected payload: %+v", payload)
 	}
-	if len(pq.ids) != 1 || pq.ids[0] != "42" {
-		t.Fatalf("expected enqueue id 42, got %+v", pq.ids)
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(d.body)
+	if d.signature != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Fatalf("unexpected signature %q", d.signature)
 	}
-	if len(fs.pendingStatuses) == 0 || fs.pendingStatuses[0].status != "planning" {
-		t.Fatalf("expected planning status update, got %+v", fs.pendingStatuses)
+	select {
+	case extra := <-got:
+		t.Fatalf("non-terminal status should not notify, got %s", extra.body)
+	case <-time.After(100 * time.Millisecond):
 	}
-	if fo.lastKey == "" || len(fo.lastData) == 0 {
-		t.Fatalf("expected object store upload, got key=%q bytes=%d", fo.lastKey, len(fo.lastData))
+
+	post("cancelled")
+	select {
+	case d = <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("webhook was not called for a cancelled build")
 	}
-	if fs.lastPending.SourceType != "requirements" {
-		t.Fatalf("expected requirements source_type, got %q", fs.lastPending.SourceType)
+	if err := json.Unmarshal(d.body, &payload); err != nil || payload.Status != "cancelled" {
+		t.Fatalf("expected a cancelled outcome, got %s (%v)", d.body, err)
+	}
+}
+
+func TestWebhookFiltersSuppressUnwantedOutcomes(t *testing.T) {
+	got := make(chan buildOutcome, 8)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var o buildOutcome
+		_ = json.NewDecoder(r.Body).Decode(&o)
+		got <- o
+	}))
+	defer hook.Close()
+
+	fs := &fakeStore{savedSettings: &settings.Settings{
+		WebhookURLs:        []string{hook.URL},
+		WebhookStatuses:    []string{"failed", "dead_letter"},
+		WebhookPackages:    []string{"num*"},
+		WebhookMinAttempts: 2,
+	}}
+	h := &Handler{Store: fs, Queue: &fakeQueue{}}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	for _, body := range []string{
+		`{"package":"numpy","version":"1.0","status":"built","attempts":3}`,
+		`{"package":"scipy","version":"1.0","status":"failed","attempts":3}`,
+		`{"package":"numpy","version":"1.0","status":"failed","attempts":1}`,
+		`{"package":"NumExpr","version":"2.0","status":"failed","attempts":2}`,
+	} {
+		resp, err := http.Post(ts.URL+"/api/builds/status", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	select {
+	case o := <-got:
+		if o.Package != "NumExpr" || o.Status != "failed" || o.Attempts != 2 {
+			t.Fatalf("unexpected delivery: %+v", o)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("matching failure was not delivered")
+	}
+	select {
+	case o := <-got:
+		t.Fatalf("filtered outcome was delivered: %+v", o)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestFailedBuildSendsEmail(t *testing.T) {
+	type mail struct {
+		addr string
+		from string
+		to   []string
+		msg  string
+	}
+	got := make(chan mail, 4)
+	fs := &fakeStore{savedSettings: &settings.Settings{
+		SMTPHost: "smtp.example.com",
+		SMTPFrom: "refinery@example.com",
+		SMTPTo:   []string{"oncall@example.com"},
+	}}
+	h := &Handler{Store: fs, Queue: &fakeQueue{}}
+	h.mailSender = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		got <- mail{addr, from, to, string(msg)}
+		return nil
+	}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	for _, body := range []string{
+		`{"package":"lxml","version":"5.2.1","status":"built"}`,
+		`{"package":"lxml","version":"5.2.2","status":"failed","error":"libxml2 headers missing"}`,
+	} {
+		resp, err := http.Post(ts.URL+"/api/builds/status", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		resp.Body.Close()
+	}
+	select {
+	case m := <-got:
+		if m.addr != "smtp.example.com:587" || m.from != "refinery@example.com" || len(m.to) != 1 {
+			t.Fatalf("unexpected envelope: %+v", m)
+		}
+		for _, want := range []string{"Subject: [refinery] lxml 5.2.2 failed", "lxml 5.2.2: failed", "error: libxml2 headers missing"} {
+			if !strings.Contains(m.msg, want) {
+				t.Fatalf("message missing %q:\n%s", want, m.msg)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no email sent")
+	}
+	select {
+	case m := <-got:
+		t.Fatalf("successful build should not email: %s", m.msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMailDigestBatchesFailures(t *testing.T) {
+	var sent []string
+	s := settings.Settings{SMTPHost: "smtp.example.com", SMTPPort: 25, SMTPFrom: "a@example.com", SMTPTo: []string{"b@example.com"}, SMTPDigestSec: 3600}
+	h := &Handler{}
+	h.mailSender = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		sent = append(sent, string(msg))
+		return nil
+	}
+	h.notifyMail(s, buildOutcome{Package: "numpy", Version: "1.0", Status: "failed", Error: "e1"})
+	h.notifyMail(s, buildOutcome{Package: "scipy", Version: "2.0", Status: "dead_letter", Attempts: 5})
+	if len(sent) != 0 {
+		t.Fatalf("digest mode should not send immediately")
+	}
+	if err := h.flushMailDigest(s); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if len(sent) != 1 || !strings.Contains(sent[0], "2 build failures") || !strings.Contains(sent[0], "numpy 1.0: failed") || !strings.Contains(sent[0], "scipy 2.0: dead_letter after 5 attempts") {
+		t.Fatalf("unexpected digest: %q", sent)
+	}
+	if err := h.flushMailDigest(s); err != nil || len(sent) != 1 {
+		t.Fatalf("empty digest should not send")
+	}
+}
+
+func TestReportSummaryAggregatesStore(t *testing.T) {
+	now := time.Now()
+	fs := &fakeStore{
+		queueStats: store.BuildQueueStats{Length: 3, Pending: 2, Retry: 1, OldestAgeSec: 600},
+		builds: []store.BuildStatus{
+			{Package: "numpy", Version: "1.0", Status: "built", Attempts: 1},
+			{Package: "scipy", Version: "1.0", Status: "built", Attempts: 3},
+			{Package: "lxml", Version: "5.0", Status: "built", Attempts: 2},
+			{Package: "pandas", Version: "2.0", Status: "failed", Attempts: 4},
+			{Package: "six", Version: "1.0", Status: "pending"},
+		},
+		topFailures: []store.Stat{{Name: "pandas", Value: 4}},
+		listPending: []store.PendingInput{
+			{ID: 2, Filename: "newer.txt", Status: "pending", CreatedAt: now},
+			{ID: 1, Filename: "older.txt", Status: "pending", CreatedAt: now.Add(-time.Hour)},
+		},
+	}
+	h := &Handler{Store: fs, Queue: &fakeQueue{}}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/report/summary")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var rep summaryReport
+	if err := json.NewDecoder(resp.Body).Decode(&rep); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if rep.Queue.Length != 3 || rep.Queue.Retry != 1 {
+		t.Fatalf("unexpected queue stats: %+v", rep.Queue)
+	}
+	if rep.BuildsByStatus["built"] != 3 || rep.BuildsByStatus["failed"] != 1 || rep.BuildsByStatus["pending"] != 1 {
+		t.Fatalf("unexpected status counts: %+v", rep.BuildsByStatus)
+	}
+	if len(rep.TopFailures) != 1 || rep.TopFailures[0].Name != "pandas" {
+		t.Fatalf("unexpected top failures: %+v", rep.TopFailures)
+	}
+	if len(rep.TopFlaky) != 2 || rep.TopFlaky[0].Name != "scipy 1.0" || rep.TopFlaky[0].Value != 3 || rep.TopFlaky[1].Name != "lxml 5.0" {
+		t.Fatalf("unexpected top flaky: %+v", rep.TopFlaky)
+	}
+	if rep.OldestPendingInput == nil || rep.OldestPendingInput.ID != 1 {
+		t.Fatalf("unexpected oldest pending input: %+v", rep.OldestPendingInput)
+	}
+}
+
+func TestParseCronMatches(t *testing.T) {
+	sched, err := parseCron("30 9 * * 1,2,3,4,5")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	monday := time.Date(2024, 6, 3, 9, 30, 0, 0, time.UTC)
+	if !sched.matches(monday) || sched.matches(monday.Add(time.Minute)) || sched.matches(monday.AddDate(0, 0, 5)) {
+		t.Fatalf("unexpected cron matching")
+	}
+	for _, bad := range []string{"* * * *", "60 * * * *", "*/0 * * * *"} {
+		if _, err := parseCron(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestSummaryHonorsIfNoneMatch(t *testing.T) {
+	h := &Handler{Store: &fakeStore{}, Queue: &fakeQueue{}}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/summary")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	resp.Body.Close()
+	tag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(tag, `W/"`) {
+		t.Fatalf("expected 200 with weak etag, got %d %q", resp.StatusCode, tag)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/summary", nil)
+	req.Header.Set("If-None-Match", tag)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified || len(body) != 0 {
+		t.Fatalf("expected empty 304, got %d %q", resp.StatusCode, body)
+	}
+
+	req.Header.Set("If-None-Match", `W/"stale"`)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 for stale etag, got %d", resp.StatusCode)
+	}
+}
+
+func TestMetricsETagIgnoresAgingQueues(t *testing.T) {
+	fs := &fakeStore{queueStats: store.BuildQueueStats{Length: 2, Pending: 2, OldestAgeSec: 600}}
+	fq := &fakeQueue{length: 1, oldestAge: 30}
+	h := &Handler{Store: fs, Queue: fq}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	get := func(tag string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/metrics", nil)
+		if tag != "" {
+			req.Header.Set("If-None-Match", tag)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	resp := get("")
+	tag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || tag == "" {
+		t.Fatalf("expected 200 with etag, got %d %q", resp.StatusCode, tag)
+	}
+
+	// Time passes; the queued items only get older.
+	fq.oldestAge += 5
+	fs.queueStats.OldestAgeSec += 5
+	if resp = get(tag); resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("expected 304 when only ages changed, got %d", resp.StatusCode)
+	}
+
+	fs.queueStats.Pending = 3
+	if resp = get(tag); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 after the queue changed, got %d", resp.StatusCode)
+	}
+}
+
+func TestPackageDetailCombinesVersionsAndBuilds(t *testing.T) {
+	fs := &fakeStore{
+		variants: []store.Event{
+			{Name: "numpy", Version: "2.0.0", Status: "failed", Timestamp: 300},
+			{Name: "numpy", Version: "1.26.4", Status: "built", Timestamp: 200},
+			{Name: "numpy", Version: "2.0.0", Status: "building", Timestamp: 100},
+		},
+		builds: []store.BuildStatus{
+			{Package: "numpy", Version: "2.0.0", Status: "failed", Attempts: 2},
+			{Package: "numpy", Version: "2.1.0", Status: "pending"},
+		},
+		manifest: []store.ManifestEntry{
+			{Name: "NumPy", Version: "1.26.4", Wheel: "numpy-1.26.4-cp311-cp311-manylinux2014_s390x.whl"},
+			{Name: "scipy", Version: "2.0.0"},
+		},
+	}
+	h := &Handler{Store: fs, Queue: &fakeQueue{}}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/package/numpy/detail")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var out struct {
+		Summary  store.PackageSummary `json:"summary"`
+		Versions []packageVersion     `json:"versions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(out.Versions) != 3 {
+		t.Fatalf("expected 3 versions, got %+v", out.Versions)
+	}
+	v2, v1, v21 := out.Versions[0], out.Versions[1], out.Versions[2]
+	if v2.Version != "2.0.0" || v2.Latest == nil || v2.Latest.Status != "failed" || len(v2.Builds) != 1 || v2.Builds[0].Attempts != 2 || v2.InManifest {
+		t.Fatalf("unexpected 2.0.0 entry: %+v", v2)
+	}
+	if v1.Version != "1.26.4" || !v1.InManifest || len(v1.Builds) != 0 {
+		t.Fatalf("unexpected 1.26.4 entry: %+v", v1)
+	}
+	if v21.Version != "2.1.0" || v21.Latest != nil || len(v21.Builds) != 1 || v21.Builds[0].Status != "pending" {
+		t.Fatalf("unexpected 2.1.0 entry: %+v", v21)
+	}
+}
+
+func TestManifestRebuildEnqueuesBuild(t *testing.T) {
+	fs := &fakeStore{
+		manifest: []store.ManifestEntry{
+			{Name: "other", Version: "2.0", PythonTag: "cp312", PlatformTag: "manylinux2014_s390x"},
+			{Name: "pkg", Version: "1.0", PythonTag: "cp310", PlatformTag: "manylinux2014_s390x", PlanID: 7},
+			{Name: "pkg", Version: "1.0", PythonTag: "cp311", PlatformTag: "manylinux2014_s390x", PlanID: 7},
+		},
+		// Plan 7 reused both wheels; the rebuild must not.
+		lastDAG: json.RawMessage(`[
+			{"id":{"type":"wheel","digest":"sha256:w310"},"type":"wheel","action":"reuse","metadata":{"name":"pkg","version":"1.0","python_tag":"cp310","platform_tag":"manylinux2014_s390x"}},
+			{"id":{"type":"wheel","digest":"sha256:w311"},"type":"wheel","action":"reuse","metadata":{"name":"pkg","version":"1.0","python_tag":"cp311","platform_tag":"manylinux2014_s390x"}}]`),
+	}
+	h := &Handler{Store: fs, Queue: &fakeQueue{}, Config: config.Config{}}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/api/manifest/pkg/1.0/rebuild?python_tag=cp311", "application/json", nil)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: %d", resp.StatusCode)
+	}
+	if len(fs.queuedBuilds) != 1 {
+		t.Fatalf("expected one queued build, got %+v", fs.queuedBuilds)
+	}
+	got := fs.queuedBuilds[0]
+	if got.Name != "pkg" || got.Version != "1.0" || got.PythonTag != "cp311" || got.PlatformTag != "manylinux2014_s390x" || got.Action != "build" {
+		t.Fatalf("unexpected rebuild node: %+v", got)
+	}
+	if fs.savePlanCalls != 1 || fs.queuedPlanID != 1 {
+		t.Fatalf("expected the rebuild queued against a saved copy of plan 7, got saves=%d plan=%d", fs.savePlanCalls, fs.queuedPlanID)
+	}
+	var dag []struct {
+		Action   string         `json:"action"`
+		Metadata map[string]any `json:"metadata"`
+	}
+	if err := json.Unmarshal(fs.lastDAG, &dag); err != nil || len(dag) != 2 {
+		t.Fatalf("unexpected saved dag %s: %v", fs.lastDAG, err)
+	}
+	if dag[0].Action != "reuse" || dag[1].Action != "build" {
+		t.Fatalf("expected only the cp311 wheel forced to build, got %s", fs.lastDAG)
+	}
+
+	resp, err = http.Post(ts.URL+"/api/manifest/other/2.0/rebuild", "application/json", nil)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected 409 for entry without plan, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Post(ts.URL+"/api/manifest/missing/1.0/rebuild", "application/json", nil)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown entry, got %d", resp.StatusCode)
+	}
+}
+
+func TestHistoryPostRecordsEvent(t *testing.T) {
+	fs := &fakeStore{}
+	h := &Handler{Store: fs, Queue: &fakeQueue{}, Config: config.Config{}}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	body := bytes.NewBufferString(`{"name":"pkg","version":"1.0","status":"built","python_tag":"cp311","platform_tag":"manylinux2014_s390x"}`)
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/history", body)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: %d", resp.StatusCode)
+	}
+	if fs.lastEvent.Name != "pkg" || fs.lastEvent.Version != "1.0" || fs.lastEvent.Status != "built" {
+		t.Fatalf("event not recorded: %+v", fs.lastEvent)
+	}
+	if fs.lastEvent.Timestamp == 0 {
+		t.Fatalf("timestamp not set")
+	}
+}
+
+func TestPrepareEventKeysRetriesOnClientFields(t *testing.T) {
+	post := func(evt store.Event) string {
+		if err := prepareEvent(&evt); err != nil {
+			t.Fatalf("prepare: %v", err)
+		}
+		return store.EventKey(evt)
+	}
+	stamped := store.Event{Name: "pkg", Version: "1.0", Status: "built", PythonTag: "cp311", Timestamp: 1700000000}
+	if post(stamped) != post(stamped) {
+		t.Fatalf("expected a retried event with a client timestamp to keep its key")
+	}
+	unstamped := store.Event{Name: "pkg", Version: "1.0", Status: "built"}
+	if post(unstamped) == post(unstamped) {
+		t.Fatalf("expected events without timestamp or event_id to get distinct keys")
+	}
+	unstamped.EventID = "client-1"
+	if post(unstamped) != "client-1" {
+		t.Fatalf("expected the client event_id to be kept")
+	}
+}
+
+func TestPendingInputsList(t *testing.T) {
+	fs := &fakeStore{listPending: []store.PendingInput{{ID: 1, Filename: "requirements-123.txt", Status: "pending"}}}
+	h := &Handler{Store: fs, Queue: &fakeQueue{}, Config: config.Config{}}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/pending-inputs")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: %d", resp.StatusCode)
+	}
+	var out []store.PendingInput
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(out) != 1 || out[0].Filename != "requirements-123.txt" {
+		t.Fatalf("unexpected list: %+v", out)
+	}
+}
+
+func TestPendingInputManualEnqueue(t *testing.T) {
+	fs := &fakeStore{}
+	pq := &fakePlanQueue{}
+	h := &Handler{Store: fs, Queue: &fakeQueue{}, PlanQ: pq, Config: config.Config{}}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/pending-inputs/5/enqueue-plan", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: %d", resp.StatusCode)
+	}
+	if len(pq.ids) != 1 || pq.ids[0] != "5" {
+		t.Fatalf("expected enqueue id 5, got %+v", pq.ids)
+	}
+	if len(fs.pendingStatuses) == 0 || fs.pendingStatuses[0].status != "planning" {
+		t.Fatalf("expected status update planning, got %+v", fs.pendingStatuses)
+	}
+}
+
+func TestPendingInputEnqueueReuseOnly(t *testing.T) {
+	fs := &fakeStore{}
+	pq := &fakePlanQueue{}
+	h := &Handler{Store: fs, Queue: &fakeQueue{}, PlanQ: pq, Config: config.Config{}}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/api/pending-inputs/5/enqueue-plan?reuse_only=true", "application/json", nil)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	resp.Body.Close()
+	if len(pq.ids) != 1 || pq.ids[0] != "5?reuse_only=true" {
+		t.Fatalf("expected reuse-only queue item, got %+v", pq.ids)
+	}
+	if id, err := planQueueItemID(pq.ids[0]); err != nil || id != 5 {
+		t.Fatalf("queue item id: %d %v", id, err)
+	}
+
+	resp, err = http.Post(ts.URL+"/api/pending-inputs/6/enqueue-plan?force_rebuild=numpy,scipy", "application/json", nil)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	resp.Body.Close()
+	if len(pq.ids) != 2 || pq.ids[1] != "6?force_rebuild=numpy%2Cscipy" {
+		t.Fatalf("expected force-rebuild queue item, got %+v", pq.ids)
+	}
+}
+
+func TestPendingInputCancelRemovesOnlyTargetedID(t *testing.T) {
+	fs := &fakeStore{}
+	pq := &fakePlanQueue{ids: []string{"4", "5", "15", "6"}}
+	h := &Handler{Store: fs, Queue: &fakeQueue{}, PlanQ: pq, Config: config.Config{}}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/api/pending-inputs/5/cancel", "application/json", nil)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: %d", resp.StatusCode)
+	}
+	if !reflect.DeepEqual(pq.ids, []string{"4", "15", "6"}) {
+		t.Fatalf("expected only 5 to be removed, got %v", pq.ids)
+	}
+	if len(fs.pendingStatuses) != 1 || fs.pendingStatuses[0].id != 5 || fs.pendingStatuses[0].status != "pending" {
+		t.Fatalf("expected input 5 back to pending, got %+v", fs.pendingStatuses)
+	}
+
+	resp, err = http.Post(ts.URL+"/api/pending-inputs/5/cancel", "application/json", nil)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected 409 for an input no longer queued, got %d", resp.StatusCode)
+	}
+}
+
+func TestBuildsCancelQueuedAndRunning(t *testing.T) {
+	fs := &fakeStore{builds: []store.BuildStatus{
+		{Package: "numpy", Version: "1.26.4", Status: "pending"},
+		{Package: "scipy", Version: "1.11.0", Status: "building"},
+		{Package: "six", Version: "1.16.0", Status: "built"},
+	}}
+	h := &Handler{Store: fs, Queue: &fakeQueue{}, Config: config.Config{}}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	cancel := func(pkg, version string) (int, map[string]any) {
+		resp, err := http.Post(ts.URL+"/api/builds/cancel", "application/json", strings.NewReader(`{"package":"`+pkg+`","version":"`+version+`"}`))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		defer resp.Body.Close()
+		var out map[string]any
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	if code, out := cancel("numpy", "1.26.4"); code != http.StatusOK || out["status"] != "cancelled" {
+		t.Fatalf("queued build should be cancelled outright, got %d %v", code, out)
+	}
+	if code, out := cancel("scipy", "1.11.0"); code != http.StatusOK || out["status"] != "building" {
+		t.Fatalf("running build should keep its status, got %d %v", code, out)
+	}
+	if code, _ := cancel("six", "1.16.0"); code != http.StatusNotFound {
+		t.Fatalf("finished build cannot be cancelled, got %d", code)
+	}
+
+	resp, err := http.Get(ts.URL + "/api/builds/cancel?package=scipy&version=1.11.0")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	var flag struct {
+		CancelRequested bool `json:"cancel_requested"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&flag); err != nil || !flag.CancelRequested {
+		t.Fatalf("expected cancel_requested for the running build, got %+v %v", flag, err)
+	}
+}
+
+func TestPendingInputPop(t *testing.T) {
+	fs := &fakeStore{}
+	pq := &fakePlanQueue{pop: []string{"7", "8"}}
+	h := &Handler{Store: fs, Queue: &fakeQueue{}, PlanQ: pq, Config: config.Config{}}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/pending-inputs/pop?max=2", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: %d", resp.StatusCode)
+	}
+	var out map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	idsAny, ok := out["ids"].([]any)
+	if !ok || len(idsAny) != 2 {
+		t.Fatalf("unexpected ids: %+v", out)
+	}
+	if len(fs.pendingStatuses) != 2 || fs.pendingStatuses[0].status != "planning" || fs.pendingStatuses[1].status != "planning" {
+		t.Fatalf("expected planning status updates, got %+v", fs.pendingStatuses)
+	}
+}
+
+func TestPendingInputStatusUpdate(t *testing.T) {
+	fs := &fakeStore{}
+	h := &Handler{Store: fs, Queue: &fakeQueue{}, Config: config.Config{}}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	body := bytes.NewBufferString(`{"status":"planned","error":""}`)
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/pending-inputs/status/9", body)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: %d", resp.StatusCode)
+	}
+	if len(fs.pendingStatuses) != 1 || fs.pendingStatuses[0].id != 9 || fs.pendingStatuses[0].status != "planned" {
+		t.Fatalf("expected planned update, got %+v", fs.pendingStatuses)
+	}
+}
+
+func TestPendingInputRestore(t *testing.T) {
+	fs := &fakeStore{}
+	h := &Handler{Store: fs, Queue: &fakeQueue{}, Config: config.Config{}}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/pending-inputs/12/restore", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: %d", resp.StatusCode)
+	}
+	if fs.restoredPendingID != 12 {
+		t.Fatalf("expected restore id 12, got %d", fs.restoredPendingID)
+	}
+}
+
+func TestRequirementsUploadAutoEnqueue(t *testing.T) {
+	fs := &fakeStore{nextPendingID: 42}
+	pq := &fakePlanQueue{}
+	fo := &fakeObjectStore{}
+	h := &Handler{
+		Store: fs, Queue: &fakeQueue{}, PlanQ: pq, InputStore: fo,
+		Config: config.Config{
+			AutoPlan:            true,
+			ObjectStoreEndpoint: "minio:9000",
+			ObjectStoreBucket:   "inputs",
+			InputObjectPrefix:   "inputs",
+		},
+	}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	body, contentType := mustMultipart(t, "requirements.txt", "pkg==1.0\n")
+	resp, err := http.Post(ts.URL+"/api/requirements/upload", contentType, body)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: %d", resp.StatusCode)
+	}
+	var out map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if pending, ok := out["pending_id"].(float64); !ok || int64(pending) != 42 {
+		t.Fatalf("expected pending_id 42, got %v", out["pending_id"])
+	}
+	if len(pq.ids) != 1 || pq.ids[0] != "42" {
+		t.Fatalf("expected enqueue id 42, got %+v", pq.ids)
+	}
+	if len(fs.pendingStatuses) == 0 || fs.pendingStatuses[0].status != "planning" {
+		t.Fatalf("expected planning status update, got %+v", fs.pendingStatuses)
+	}
+	if fo.lastKey == "" || len(fo.lastData) == 0 {
+		t.Fatalf("expected object store upload, got key=%q bytes=%d", fo.lastKey, len(fo.lastData))
+	}
+	if fs.lastPending.SourceType != "requirements" {
+		t.Fatalf("expected requirements source_type, got %q", fs.lastPending.SourceType)
+	}
+}
+
+func TestRequirementsUploadStoresIndexURL(t *testing.T) {
+	fs := &fakeStore{nextPendingID: 5}
+	h := &Handler{
+		Store: fs, Queue: &fakeQueue{}, InputStore: &fakeObjectStore{},
+		Config: config.Config{ObjectStoreEndpoint: "minio:9000", ObjectStoreBucket: "inputs"},
+	}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	body, contentType := mustMultipart(t, "requirements.txt", "pkg==1.0\n")
+	resp, err := http.Post(ts.URL+"/api/requirements/upload?index_url=https://mirror.team-a.example/simple", contentType, body)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: %d", resp.StatusCode)
+	}
+	var meta struct {
+		IndexURL string `json:"index_url"`
+	}
+	if err := json.Unmarshal(fs.lastPending.Metadata, &meta); err != nil || meta.IndexURL != "https://mirror.team-a.example/simple" {
+		t.Fatalf("expected index_url in metadata, got %s", fs.lastPending.Metadata)
+	}
+
+	body, contentType = mustMultipart(t, "requirements.txt", "pkg==1.0\n")
+	resp, err = http.Post(ts.URL+"/api/requirements/upload?index_url=file:///etc/passwd", contentType, body)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected non-http index_url to be rejected, got %d", resp.StatusCode)
+	}
+}
+
+func TestRequirementsUploadStoresPythonVersion(t *testing.T) {
+	fs := &fakeStore{nextPendingID: 6}
+	h := &Handler{
+		Store: fs, Queue: &fakeQueue{}, InputStore: &fakeObjectStore{},
+		Config: config.Config{ObjectStoreEndpoint: "minio:9000", ObjectStoreBucket: "inputs"},
+	}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	body, contentType := mustMultipart(t, "requirements.txt", "pkg==1.0\n")
+	resp, err := http.Post(ts.URL+"/api/requirements/upload?python_version=3.11", contentType, body)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: %d", resp.StatusCode)
+	}
+	var meta struct {
+		PythonVersion string `json:"python_version"`
+	}
+	if err := json.Unmarshal(fs.lastPending.Metadata, &meta); err != nil || meta.PythonVersion != "3.11" {
+		t.Fatalf("expected python_version in metadata, got %s", fs.lastPending.Metadata)
+	}
+
+	body, contentType = mustMultipart(t, "requirements.txt", "pkg==1.0\n")
+	resp, err = http.Post(ts.URL+"/api/requirements/upload?python_version=3.10,%203.11", contentType, body)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	resp.Body.Close()
+	if err := json.Unmarshal(fs.lastPending.Metadata, &meta); err != nil || resp.StatusCode != http.StatusOK || meta.PythonVersion != "3.10,3.11" {
+		t.Fatalf("expected python_version list in metadata, got %d %s", resp.StatusCode, fs.lastPending.Metadata)
+	}
+
+	body, contentType = mustMultipart(t, "requirements.txt", "pkg==1.0\n")
+	resp, err = http.Post(ts.URL+"/api/requirements/upload?python_version=cp311", contentType, body)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected malformed python_version to be rejected, got %d", resp.StatusCode)
+	}
+}
+
+func TestRequirementsUploadResolvesIncludes(t *testing.T) {
+	fs := &fakeStore{nextPendingID: 7}
+	h := &Handler{
+		Store: fs, Queue: &fakeQueue{}, InputStore: &fakeObjectStore{},
+		Config: config.Config{ObjectStoreEndpoint: "minio:9000", ObjectStoreBucket: "inputs"},
+	}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, f := range []struct{ field, name, content string }{
+		{"file", "requirements.txt", "-r base.txt\n--requirement=missing.txt\nrequests==2.31.0\n"},
+		{"include", "base.txt", "numpy==1.26.4\n-r requirements.txt\n"},
+	} {
+		part, err := mw.CreateFormFile(f.field, f.name)
+		if err != nil {
+			t.Fatalf("create form file: %v", err)
+		}
+		_, _ = part.Write([]byte(f.content))
+	}
+	_ = mw.Close()
+	resp, err := http.Post(ts.URL+"/api/requirements/upload", mw.FormDataContentType(), &buf)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: %d", resp.StatusCode)
+	}
+	var out struct {
+		Unresolved []string `json:"unresolved_includes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !reflect.DeepEqual(out.Unresolved, []string{"requirements.txt", "missing.txt"}) {
+		t.Fatalf("expected cyclic and missing includes to be reported, got %v", out.Unresolved)
+	}
+	var meta struct {
+		Requirements []requirementSpec `json:"requirements"`
+		Unresolved   []string          `json:"unresolved_includes"`
+	}
+	if err := json.Unmarshal(fs.lastPending.Metadata, &meta); err != nil {
+		t.Fatalf("metadata: %v", err)
+	}
+	want := []requirementSpec{{Name: "numpy", Version: "1.26.4"}, {Name: "requests", Version: "2.31.0"}}
+	if !reflect.DeepEqual(meta.Requirements, want) || len(meta.Unresolved) != 2 {
+		t.Fatalf("unexpected metadata %s", fs.lastPending.Metadata)
+	}
+}
+
+func TestParseRequirementsKeepsHashes(t *testing.T) {
+	data := []byte("numpy==1.26.4 \\\n    --hash=sha256:aaa \\\n    --hash sha256:bbb\nrequests==2.31.0 --hash=sha256:ccc # pinned\nsix\n")
+	got := parseRequirements(data)
+	want := []requirementSpec{
+		{Name: "numpy", Version: "1.26.4", Hashes: []string{"sha256:aaa", "sha256:bbb"}},
+		{Name: "requests", Version: "2.31.0", Hashes: []string{"sha256:ccc"}},
+		{Name: "six"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected requirements %+v", got)
+	}
+}
+
+func TestUploadsRejectFilesOverConfiguredLimit(t *testing.T) {
+	h := &Handler{
+		Store: &fakeStore{}, Queue: &fakeQueue{}, InputStore: &fakeObjectStore{},
+		Config: config.Config{ObjectStoreEndpoint: "minio:9000", ObjectStoreBucket: "inputs", MaxRequirementsBytes: 16, MaxWheelBytes: 32},
+	}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	cases := []struct {
+		path, filename, content, limit string
+	}{
+		{"/api/requirements/upload", "requirements.txt", "numpy==1.26.4\nrequests==2.31.0\n", "16"},
+		{"/api/wheels/upload", "pkg-1.0-py3-none-any.whl", strings.Repeat("x", 64), "32"},
+	}
+	for _, tc := range cases {
+		body, contentType := mustMultipart(t, tc.filename, tc.content)
+		resp, err := http.Post(ts.URL+tc.path, contentType, body)
+		if err != nil {
+			t.Fatalf("post %s: %v", tc.path, err)
+		}
+		var out struct {
+			Code    string `json:"code"`
+			Message string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Fatalf("%s: expected 413, got %d", tc.path, resp.StatusCode)
+		}
+		if !strings.Contains(out.Message, tc.limit+" byte limit") {
+			t.Fatalf("%s: expected limit in message, got %+v", tc.path, out)
+		}
+	}
+
+	body, contentType := mustMultipart(t, "requirements.txt", "six\n")
+	resp, err := http.Post(ts.URL+"/api/requirements/upload", contentType, body)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected upload under the limit to succeed, got %d", resp.StatusCode)
+	}
+}
+
+type fakeScanner struct {
+	flag  string
+	calls int
+}
+
+func (f *fakeScanner) Scan(_ context.Context, data []byte) (bool, string, error) {
+	f.calls++
+	if f.flag != "" && bytes.Contains(data, []byte(f.flag)) {
+		return false, "Test-Signature", nil
+	}
+	return true, "", nil
+}
+
+func TestUploadScannerRejectsFlaggedContent(t *testing.T) {
+	fs := &fakeStore{}
+	fo := &fakeObjectStore{}
+	scanner := &fakeScanner{flag: "evil"}
+	h := &Handler{
+		Store: fs, Queue: &fakeQueue{}, InputStore: fo, Scanner: scanner,
+		Config: config.Config{ObjectStoreEndpoint: "minio:9000", ObjectStoreBucket: "inputs"},
+	}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	body, contentType := mustMultipart(t, "requirements.txt", "evil==1.0\n")
+	resp, err := http.Post(ts.URL+"/api/requirements/upload", contentType, body)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	var out map[string]string
+	_ = json.NewDecoder(resp.Body).Decode(&out)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(out["error"], "Test-Signature") {
+		t.Fatalf("expected 400 naming the detection, got %d %v", resp.StatusCode, out)
+	}
+	if fo.lastKey != "" || fs.lastPending.Filename != "" {
+		t.Fatalf("flagged upload must not be stored")
+	}
+
+	body, contentType = mustMultipart(t, "requirements.txt", "six==1.16.0\n")
+	resp, err = http.Post(ts.URL+"/api/requirements/upload", contentType, body)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || fo.lastKey == "" || scanner.calls != 2 {
+		t.Fatalf("clean upload should be scanned and stored, got %d calls=%d", resp.StatusCode, scanner.calls)
+	}
+}
+
+func TestUploadScannerChecksIncludes(t *testing.T) {
+	fs := &fakeStore{}
+	fo := &fakeObjectStore{}
+	scanner := &fakeScanner{flag: "evil"}
+	h := &Handler{
+		Store: fs, Queue: &fakeQueue{}, InputStore: fo, Scanner: scanner,
+		Config: config.Config{ObjectStoreEndpoint: "minio:9000", ObjectStoreBucket: "inputs"},
+	}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, f := range []struct{ field, name, content string }{
+		{"file", "requirements.txt", "-r base.txt\nrequests==2.31.0\n"},
+		{"include", "base.txt", "evil==1.0\n"},
+	} {
+		part, err := mw.CreateFormFile(f.field, f.name)
+		if err != nil {
+			t.Fatalf("create form file: %v", err)
+		}
+		_, _ = part.Write([]byte(f.content))
+	}
+	_ = mw.Close()
+	resp, err := http.Post(ts.URL+"/api/requirements/upload", mw.FormDataContentType(), &buf)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	var out map[string]string
+	_ = json.NewDecoder(resp.Body).Decode(&out)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(out["error"], "Test-Signature") {
+		t.Fatalf("expected flagged include to be rejected, got %d %v", resp.StatusCode, out)
+	}
+	if scanner.calls != 2 {
+		t.Fatalf("expected the file and its include to be scanned, got %d calls", scanner.calls)
+	}
+	if fo.lastKey != "" || fs.lastPending.Filename != "" {
+		t.Fatalf("upload with a flagged include must not be stored")
+	}
+}
+
+func TestClamdScannerSpeaksInstream(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			if cmd, _ := r.ReadString(0); cmd != "zINSTREAM\x00" {
+				conn.Close()
+				continue
+			}
+			var payload []byte
+			for {
+				var size [4]byte
+				if _, err := io.ReadFull(r, size[:]); err != nil {
+					break
+				}
+				n := binary.BigEndian.Uint32(size[:])
+				if n == 0 {
+					break
+				}
+				chunk := make([]byte, n)
+				_, _ = io.ReadFull(r, chunk)
+				payload = append(payload, chunk...)
+			}
+			reply := "stream: OK\x00"
+			if bytes.Contains(payload, []byte("EICAR")) {
+				reply = "stream: Eicar-Test-Signature FOUND\x00"
+			}
+			_, _ = conn.Write([]byte(reply))
+			conn.Close()
+		}
+	}()
+
+	scanner, err := NewInputScanner("clamd://" + ln.Addr().String())
+	if err != nil {
+		t.Fatalf("new scanner: %v", err)
+	}
+	clean, detail, err := scanner.Scan(context.Background(), bytes.Repeat([]byte("a"), 200<<10))
+	if err != nil || !clean {
+		t.Fatalf("expected clean multi-chunk stream, got clean=%v err=%v", clean, err)
+	}
+	clean, detail, err = scanner.Scan(context.Background(), []byte("X5O EICAR test"))
+	if err != nil || clean || detail != "Eicar-Test-Signature" {
+		t.Fatalf("expected detection, got clean=%v detail=%q err=%v", clean, detail, err)
+	}
+}
+
+func TestConstraintsUploadLinksToRequirements(t *testing.T) {
+	fs := &fakeStore{nextPendingID: 6}
+	fo := &fakeObjectStore{}
+	h := &Handler{
+		Store: fs, Queue: &fakeQueue{}, InputStore: fo,
+		Config: config.Config{ObjectStoreEndpoint: "minio:9000", ObjectStoreBucket: "inputs", InputObjectPrefix: "inputs"},
+	}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	body, contentType := mustMultipart(t, "constraints.txt", "numpy==1.26.4\n")
+	resp, err := http.Post(ts.URL+"/api/constraints/upload", contentType, body)
+	if err != nil {
+		t.Fatalf("post constraints: %v", err)
+	}
+	var out struct {
+		ConstraintsKey string `json:"constraints_key"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&out)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("constraints upload: status %d err %v", resp.StatusCode, err)
+	}
+	if !strings.HasPrefix(out.ConstraintsKey, "inputs/constraints/") || fo.lastKey != out.ConstraintsKey || string(fo.lastData) != "numpy==1.26.4\n" {
+		t.Fatalf("constraints not stored: key %q stored %q", out.ConstraintsKey, fo.lastKey)
+	}
+
+	body, contentType = mustMultipart(t, "requirements.txt", "scipy\n")
+	resp, err = http.Post(ts.URL+"/api/requirements/upload?constraints_key="+url.QueryEscape(out.ConstraintsKey), contentType, body)
+	if err != nil {
+		t.Fatalf("post requirements: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("requirements upload status: %d", resp.StatusCode)
+	}
+	var meta struct {
+		ConstraintsKey string `json:"constraints_key"`
+	}
+	if err := json.Unmarshal(fs.lastPending.Metadata, &meta); err != nil || meta.ConstraintsKey != out.ConstraintsKey {
+		t.Fatalf("expected constraints_key in metadata, got %s", fs.lastPending.Metadata)
+	}
+
+	body, contentType = mustMultipart(t, "requirements.txt", "scipy\n")
+	resp, err = http.Post(ts.URL+"/api/requirements/upload?constraints_key=inputs/other/secret.txt", contentType, body)
+	if err != nil {
+		t.Fatalf("post requirements: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected foreign constraints_key to be rejected, got %d", resp.StatusCode)
+	}
+}
+
+type missingPlanStore struct {
+	*fakeStore
+}
+
+func (m missingPlanStore) LatestPlanSnapshot(ctx context.Context) (store.PlanSnapshot, error) {
+	return store.PlanSnapshot{}, store.ErrNotFound
+}
+
+func TestErrorResponsesIncludeCode(t *testing.T) {
+	h := &Handler{Store: missingPlanStore{&fakeStore{}}, Queue: &fakeQueue{}, Config: config.Config{WorkerToken: "secret"}}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		code   string
+	}{
+		{"invalid json", http.MethodPost, "/api/history", "{", http.StatusBadRequest, "invalid_input"},
+		{"missing token", http.MethodPost, "/api/pending-inputs/pop", "", http.StatusUnauthorized, "unauthorized"},
+		{"missing plan", http.MethodGet, "/api/plan/latest", "", http.StatusNotFound, "not_found"},
+		{"wrong method", http.MethodDelete, "/api/summary", "", http.StatusMethodNotAllowed, "method_not_allowed"},
+		{"no plan queue", http.MethodPost, "/api/plan-queue/clear?token=secret", "", http.StatusInternalServerError, "backend_unavailable"},
+	}
+	for _, tc := range cases {
+		req, _ := http.NewRequest(tc.method, ts.URL+tc.path, bytes.NewBufferString(tc.body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		var out map[string]any
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Fatalf("%s: expected status %d, got %d", tc.name, tc.status, resp.StatusCode)
+		}
+		if out["code"] != tc.code {
+			t.Fatalf("%s: expected code %q, got %v", tc.name, tc.code, out["code"])
+		}
+		if msg, _ := out["error"].(string); msg == "" {
+			t.Fatalf("%s: expected error message to be kept, got %v", tc.name, out)
+		}
+	}
+}
+
+func TestOpenAPISpecServed(t *testing.T) {
+	h := &Handler{Store: &fakeStore{}, Queue: &fakeQueue{}, Config: config.Config{}}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/openapi.json")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: %d", resp.StatusCode)
+	}
+	var spec struct {
+		OpenAPI    string                    `json:"openapi"`
+		Paths      map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&spec); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Fatalf("expected openapi 3.x, got %q", spec.OpenAPI)
+	}
+	for _, p := range []string{"/api/builds", "/api/plan", "/api/pending-inputs", "/api/hints/{id}"} {
+		if _, ok := spec.Paths[p]; !ok {
+			t.Fatalf("expected path %s in spec", p)
+		}
+	}
+	for _, name := range []string{"BuildStatus", "PlanSnapshot", "PendingInput", "Hint", "Error"} {
+		if _, ok := spec.Components.Schemas[name]; !ok {
+			t.Fatalf("expected schema %s", name)
+		}
+	}
+}
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	h := &Handler{}
+	for _, rt := range h.routeTable() {
+		docs, ok := routeDocs[rt.pattern]
+		if !ok || len(docs) == 0 {
+			t.Fatalf("route %s missing from routeDocs", rt.pattern)
+		}
+		for path, ops := range docs {
+			for _, op := range ops {
+				for _, ref := range []string{op.request, op.response} {
+					name := strings.TrimPrefix(ref, "[]")
+					if _, ok := openAPIComponents[name]; name != "" && !ok {
+						t.Fatalf("%s references unknown schema %q", path, ref)
+					}
+				}
+			}
+		}
+	}
+	if len(routeDocs) != len(h.routeTable()) {
+		t.Fatalf("routeDocs has %d entries for %d routes", len(routeDocs), len(h.routeTable()))
+	}
+}
+
+func TestHistoryBulkReportsPerRecordErrors(t *testing.T) {
+	fs := &fakeStore{}
+	h := &Handler{Store: fs, Queue: &fakeQueue{}, Config: config.Config{}}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	payloads := map[string]string{
+		"array": `[{"name":"a","version":"1.0","status":"built"},{"name":"b","status":"failed"},{"name":"c","version":"2.0","status":"failed","timestamp":1700000000}]`,
+		"ndjson": "{\"name\":\"a\",\"version\":\"1.0\",\"status\":\"built\"}\n" +
+			"{\"name\":\"b\",\"status\":\"failed\"}\n" +
+			"{\"name\":\"c\",\"version\":\"2.0\",\"status\":\"failed\",\"timestamp\":1700000000}\n",
+	}
+	for name, payload := range payloads {
+		fs.recordedEvents = nil
+		resp, err := http.Post(ts.URL+"/api/history/bulk", "application/json", bytes.NewBufferString(payload))
+		if err != nil {
+			t.Fatalf("%s: post: %v", name, err)
+		}
+		var out struct {
+			Recorded int `json:"recorded"`
+			Errors   []struct {
+				Index int    `json:"index"`
+				Error string `json:"error"`
+			} `json:"errors"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatalf("%s: decode: %v", name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status: %d", name, resp.StatusCode)
+		}
+		if out.Recorded != 2 || len(fs.recordedEvents) != 2 {
+			t.Fatalf("%s: expected 2 recorded events, got %d (store %d)", name, out.Recorded, len(fs.recordedEvents))
+		}
+		if len(out.Errors) != 1 || out.Errors[0].Index != 1 {
+			t.Fatalf("%s: expected one error at index 1, got %+v", name, out.Errors)
+		}
+		if fs.recordedEvents[0].Timestamp == 0 || fs.recordedEvents[1].Timestamp != 1700000000 {
+			t.Fatalf("%s: unexpected timestamps: %+v", name, fs.recordedEvents)
+		}
+	}
+}
+
+func TestHistoryPostRejectsMalformedMetadata(t *testing.T) {
+	fs := &fakeStore{}
+	h := &Handler{Store: fs, Queue: &fakeQueue{}, Config: config.Config{}}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	cases := map[string]string{
+		"non-numeric duration": `{"name":"pkg","version":"1.0","status":"built","metadata":{"duration_ms":"slow"}}`,
+		"bad wheel digest":     `{"name":"pkg","version":"1.0","status":"built","metadata":{"wheel_digest":"md5:abc"}}`,
+	}
+	for name, payload := range cases {
+		resp, err := http.Post(ts.URL+"/api/history", "application/json", bytes.NewBufferString(payload))
+		if err != nil {
+			t.Fatalf("%s: post: %v", name, err)
+		}
+		var out map[string]any
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest || out["code"] != "invalid_input" {
+			t.Fatalf("%s: expected 400 invalid_input, got %d %v", name, resp.StatusCode, out)
+		}
+	}
+	if fs.lastEvent.Name != "" {
+		t.Fatalf("malformed event should not be recorded: %+v", fs.lastEvent)
+	}
+
+	ok := `{"name":"pkg","version":"1.0","status":"built","metadata":{"duration_ms":1500,"custom":"anything"}}`
+	resp, err := http.Post(ts.URL+"/api/history", "application/json", bytes.NewBufferString(ok))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected valid metadata to be accepted, got %d", resp.StatusCode)
+	}
+}
+
+func TestConfigReportsEffectiveSources(t *testing.T) {
+	t.Setenv("REDIS_KEY", "custom:queue")
+	autoBuild := true
+	stored := settings.ApplyDefaults(settings.Settings{AutoBuild: &autoBuild, WebhookSecret: "shh"})
+	fs := &fakeStore{savedSettings: &stored}
+	h := &Handler{Store: fs, Queue: &fakeQueue{}, Config: config.Config{
+		RedisKey:    "custom:queue",
+		RedisURL:    "redis://:hunter2@redis:6379/0",
+		WorkerToken: "secret",
+	}}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/config")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	var out struct {
+		Effective map[string]effectiveValue `json:"effective"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	eff := out.Effective
+	if got := eff["auto_build"]; got.Value != true || got.Source != sourceDB {
+		t.Fatalf("expected auto_build from db, got %+v", got)
+	}
+	if got := eff["auto_plan"]; got.Value != false || got.Source != sourceDefault {
+		t.Fatalf("expected default auto_plan, got %+v", got)
+	}
+	if got := eff["redis_key"]; got.Value != "custom:queue" || got.Source != sourceEnv {
+		t.Fatalf("expected redis_key from env, got %+v", got)
+	}
+	for _, key := range []string{"worker_token", "webhook_secret"} {
+		if eff[key].Value != redactedValue {
+			t.Fatalf("expected %s redacted, got %+v", key, eff[key])
+		}
+	}
+	if url, _ := eff["redis_url"].Value.(string); strings.Contains(url, "hunter2") {
+		t.Fatalf("redis password leaked: %s", url)
+	}
+}
+
+func TestConfigRedactsRedisPassword(t *testing.T) {
+	h := &Handler{Store: &fakeStore{}, Queue: &fakeQueue{}, Config: config.Config{RedisURL: "redis://:hunter2@redis:6379/0"}}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/config")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	var out map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if out["redis_url"] != "redis://:***@redis:6379/0" {
+		t.Fatalf("expected redacted redis url, got %v", out["redis_url"])
+	}
+	if got := redactSecrets("redis://redis:6379/0"); got != "redis://redis:6379/0" {
+		t.Fatalf("url without password should be unchanged, got %q", got)
+	}
+}
+
+func TestAuditCompatibilityExplainsVerdicts(t *testing.T) {
+	fs := &fakeStore{listPending: []store.PendingInput{
+		{ID: 7, Filename: "numpy-1.26.4-cp311-cp311-manylinux2014_x86_64.whl", SourceType: "wheel"},
+		{ID: 8, Filename: "requirements.txt", SourceType: "requirements"},
+	}}
+	h := &Handler{Store: fs, Queue: &fakeQueue{}}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	post := func(body string) (int, []wheelVerdict) {
+		resp, err := http.Post(ts.URL+"/api/audit/compatibility", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		defer resp.Body.Close()
+		var out struct {
+			Wheels []wheelVerdict `json:"wheels"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out.Wheels
+	}
+	status, got := post(`{"wheels":["six-1.16.0-py2.py3-none-any.whl","lxml-5.2.1-cp311-cp311-manylinux2014_s390x.whl","lxml-5.2.1-cp311-cp311-musllinux_1_2_s390x.whl","not-a-wheel.txt"]}`)
+	if status != http.StatusOK || len(got) != 4 {
+		t.Fatalf("unexpected response %d %+v", status, got)
+	}
+	if !got[0].Compatible || !got[1].Compatible || got[0].Reason != "" {
+		t.Fatalf("expected compatible wheels, got %+v", got[:2])
+	}
+	if got[2].Compatible || !strings.Contains(got[2].Reason, "platform tag musllinux_1_2_s390x") {
+		t.Fatalf("expected platform family mismatch, got %+v", got[2])
+	}
+	if got[3].Compatible || got[3].Reason == "" {
+		t.Fatalf("expected parse failure reason, got %+v", got[3])
+	}
+
+	status, got = post(`{"pending_input_id":7}`)
+	if status != http.StatusOK || len(got) != 1 || got[0].Compatible || !strings.Contains(got[0].Reason, "platform tag manylinux2014_x86_64") {
+		t.Fatalf("expected platform mismatch for pending wheel, got %d %+v", status, got)
+	}
+	if status, _ = post(`{"pending_input_id":8}`); status != http.StatusBadRequest {
+		t.Fatalf("requirements input should be rejected, got %d", status)
+	}
+}
+
+func TestSettingsIndexCredentialsAreWriteOnly(t *testing.T) {
+	fs := &fakeStore{}
+	h := &Handler{Store: fs, Queue: &fakeQueue{}, Config: config.Config{WorkerToken: "secret"}}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	post := func(body string) map[string]any {
+		resp, err := http.Post(ts.URL+"/api/settings", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status: %d", resp.StatusCode)
+		}
+		var out map[string]any
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return out
+	}
+	out := post(`{"index_username":"mirror","index_password":"hunter2"}`)
+	if _, ok := out["index_password"]; ok || out["index_credentials_set"] != true {
+		t.Fatalf("expected redacted response, got %v", out)
+	}
+	// A save from a client that never saw the credentials keeps them.
+	post(`{"recent_limit":10}`)
+
+	resp, err := http.Get(ts.URL + "/api/settings")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	var got map[string]any
+	_ = json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if _, ok := got["index_username"]; ok {
+		t.Fatalf("credentials leaked in GET: %v", got)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/settings/index-credentials", nil)
+	req.Header.Set("X-Worker-Token", "secret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get creds: %v", err)
+	}
+	var creds map[string]string
+	_ = json.NewDecoder(resp.Body).Decode(&creds)
+	resp.Body.Close()
+	if creds["index_username"] != "mirror" || creds["index_password"] != "hunter2" {
+		t.Fatalf("expected stored credentials for worker, got %v", creds)
+	}
+
+	out = post(`{"recent_limit":10,"clear_index_credentials":true}`)
+	if _, ok := out["index_credentials_set"]; ok {
+		t.Fatalf("expected credentials cleared, got %v", out)
+	}
+	if _, ok := out["clear_index_credentials"]; ok {
+		t.Fatalf("clear flag should not be echoed back, got %v", out)
+	}
+	if saved := fs.savedSettings; saved.IndexUsername != "" || saved.IndexPassword != "" || saved.ClearIndexCredentials {
+		t.Fatalf("expected stored credentials removed, got %+v", saved)
+	}
+}
+
+func TestIndexCredentialsForbiddenWithoutWorkerToken(t *testing.T) {
+	fs := &fakeStore{savedSettings: &settings.Settings{IndexUsername: "mirror", IndexPassword: "hunter2"}}
+	h := &Handler{Store: fs, Queue: &fakeQueue{}}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/settings/index-credentials")
+	if err != nil {
+		t.Fatalf("get creds: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 without a worker token, got %d", resp.StatusCode)
+	}
+	if strings.Contains(string(body), "hunter2") {
+		t.Fatalf("credentials leaked: %s", body)
+	}
+}
+
+// buildTestWheel zips files plus a RECORD computed from them; tamper, when
+// set, replaces that file's content after RECORD has been written.
+func buildTestWheel(t *testing.T, files map[string]string, tamper string) string {
+	t.Helper()
+	const record = "pkg-1.0.dist-info/RECORD"
+	var rec strings.Builder
+	for name, content := range files {
+		sum := sha256.Sum256([]byte(content))
+		fmt.Fprintf(&rec, "%s,sha256=%s,%d\n", name, base64.RawURLEncoding.EncodeToString(sum[:]), len(content))
+	}
+	rec.WriteString(record + ",,\n")
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		if name == tamper {
+			content += "# injected"
+		}
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("zip create: %v", err)
+		}
+		_, _ = f.Write([]byte(content))
+	}
+	f, err := zw.Create(record)
+	if err != nil {
+		t.Fatalf("zip create: %v", err)
+	}
+	_, _ = f.Write([]byte(rec.String()))
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zip close: %v", err)
+	}
+	return buf.String()
+}
+
+func TestWheelsUploadVerifiesRecord(t *testing.T) {
+	files := map[string]string{
+		"pkg/__init__.py":            "VALUE = 1\n",
+		"pkg-1.0.dist-info/METADATA": "Name: pkg\nVersion: 1.0\n",
+	}
+	h := &Handler{
+		Store: &fakeStore{nextPendingID: 7}, Queue: &fakeQueue{}, InputStore: &fakeObjectStore{},
+		Config: config.Config{
+			ObjectStoreEndpoint: "minio:9000",
+			ObjectStoreBucket:   "inputs",
+			InputObjectPrefix:   "inputs",
+		},
+	}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	cases := []struct {
+		name   string
+		query  string
+		tamper string
+		want   int
+	}{
+		{"matching record", "?verify=true", "", http.StatusOK},
+		{"corrupted file", "?verify=true", "pkg/__init__.py", http.StatusBadRequest},
+		{"corrupted file unverified", "", "pkg/__init__.py", http.StatusOK},
+	}
+	for _, tc := range cases {
+		body, contentType := mustMultipart(t, "pkg-1.0-py3-none-any.whl", buildTestWheel(t, files, tc.tamper))
+		resp, err := http.Post(ts.URL+"/api/wheels/upload"+tc.query, contentType, body)
+		if err != nil {
+			t.Fatalf("%s: post: %v", tc.name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Fatalf("%s: expected %d, got %d", tc.name, tc.want, resp.StatusCode)
+		}
+	}
+}
+
+func TestVerifyWheelRecordRejectsUnlistedFile(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	f, _ := zw.Create("pkg-1.0.dist-info/RECORD")
+	_, _ = f.Write([]byte("pkg-1.0.dist-info/RECORD,,\n"))
+	f, _ = zw.Create("pkg/extra.py")
+	_, _ = f.Write([]byte("print('hi')\n"))
+	_ = zw.Close()
+	if err := verifyWheelRecord(buf.Bytes()); err == nil || !strings.Contains(err.Error(), "not listed") {
+		t.Fatalf("expected unlisted file error, got %v", err)
+	}
+}
+
+func buildTestSdist(t *testing.T, files map[string]string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("tar header: %v", err)
+		}
+		_, _ = tw.Write([]byte(content))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tar close: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gzip close: %v", err)
+	}
+	return buf.String()
+}
+
+func TestSdistUploadCreatesPendingInput(t *testing.T) {
+	fs := &fakeStore{nextPendingID: 9}
+	fo := &fakeObjectStore{}
+	h := &Handler{
+		Store: fs, Queue: &fakeQueue{}, InputStore: fo,
+		Config: config.Config{
+			ObjectStoreEndpoint: "minio:9000",
+			ObjectStoreBucket:   "inputs",
+			InputObjectPrefix:   "inputs",
+		},
+	}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	sdist := buildTestSdist(t, map[string]string{
+		"My_Pkg-2.1/PKG-INFO":     "Metadata-Version: 2.1\nName: My_Pkg\nVersion: 2.1\nRequires-Dist: requests>=2.0\n\nLong description.\n",
+		"My_Pkg-2.1/my_pkg.py":    "VALUE = 1\n",
+		"My_Pkg-2.1/sub/PKG-INFO": "Name: ignored\n",
+	})
+	body, contentType := mustMultipart(t, "My_Pkg-2.1.tar.gz", sdist)
+	resp, err := http.Post(ts.URL+"/api/sdists/upload", contentType, body)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: %d", resp.StatusCode)
+	}
+	var out map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if out["name"] != "my-pkg" || out["version"] != "2.1" {
+		t.Fatalf("unexpected name/version: %v %v", out["name"], out["version"])
+	}
+	if fs.lastPending.SourceType != "sdist" {
+		t.Fatalf("expected sdist source_type, got %q", fs.lastPending.SourceType)
+	}
+	var meta struct {
+		Type     string            `json:"type"`
+		Sdist    sdistMeta         `json:"sdist"`
+		Requires []requirementSpec `json:"requires"`
+	}
+	if err := json.Unmarshal(fs.lastPending.Metadata, &meta); err != nil {
+		t.Fatalf("metadata: %v", err)
+	}
+	if meta.Type != "sdist" || meta.Sdist.Name != "my-pkg" || meta.Sdist.Version != "2.1" {
+		t.Fatalf("unexpected metadata: %+v", meta)
+	}
+	if len(meta.Requires) != 1 || meta.Requires[0].Name != "requests" || meta.Requires[0].Version != ">=2.0" {
+		t.Fatalf("unexpected requires: %+v", meta.Requires)
+	}
+	if fo.lastKey == "" || len(fo.lastData) != len(sdist) {
+		t.Fatalf("expected object store upload, got key=%q bytes=%d", fo.lastKey, len(fo.lastData))
+	}
+}
+
+func TestParseSdistFallsBackToPyproject(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	f, _ := zw.Create("demo-0.3/pyproject.toml")
+	_, _ = f.Write([]byte(`[build-system]
+requires = ["setuptools"]
+
+[project]
+name = "demo"
+version = "0.3"
+dependencies = [
+  "numpy>=1.24,<2",
+  "attrs==23.1; python_version >= '3.8'",
+]
+`))
+	_ = zw.Close()
+	meta, reqs, err := parseSdist("demo-0.3.zip", buf.Bytes())
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if meta.Name != "demo" || meta.Version != "0.3" {
+		t.Fatalf("unexpected meta: %+v", meta)
+	}
+	if len(reqs) != 2 || reqs[0].Name != "numpy" || reqs[1].Name != "attrs" || reqs[1].Version != "23.1" {
+		t.Fatalf("unexpected requires: %+v", reqs)
+	}
+}
+
+func TestPendingInputsPurgeDeletesBlobs(t *testing.T) {
+	fs := &fakeStore{purgeKeys: []string{"inputs/abc/req.txt"}}
+	fo := &fakeObjectStore{}
+	h := &Handler{Store: fs, InputStore: fo, Config: config.Config{WorkerToken: "secret", InputRetentionSec: 86400}}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/api/pending-inputs/purge?older_than=60", "application/json", nil)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/pending-inputs/purge?older_than=60", nil)
+	req.Header.Set("X-Worker-Token", "secret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: %d", resp.StatusCode)
+	}
+	var out map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if out["count"].(float64) != 1 || fs.purgedOlderThan != 60 {
+		t.Fatalf("unexpected purge: %+v older_than=%d", out, fs.purgedOlderThan)
+	}
+	if len(fo.deleted) != 1 || fo.deleted[0] != "inputs/abc/req.txt" {
+		t.Fatalf("expected blob delete, got %+v", fo.deleted)
+	}
+}
+
+func TestApproveFixReleasesHeldRecipes(t *testing.T) {
+	fs := &fakeStore{}
+	h := &Handler{Store: fs, Queue: &fakeQueue{}}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	post := func(path, body string) int {
+		resp, err := http.Post(ts.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("post %s: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := post("/api/builds/approve-fix", `{"package":"numpy","version":"1.26.4"}`); code != http.StatusNotFound {
+		t.Fatalf("expected 404 without a held fix, got %d", code)
+	}
+	if code := post("/api/builds/status", `{"package":"numpy","version":"1.26.4","status":"failed","held_recipes":["dnf:gcc"]}`); code != http.StatusOK {
+		t.Fatalf("status update: %d", code)
+	}
+	if got := fs.heldFixes["numpy@1.26.4"]; len(got) != 1 || got[0] != "dnf:gcc" {
+		t.Fatalf("expected held recipes stored, got %v", fs.heldFixes)
+	}
+	if code := post("/api/builds/approve-fix", `{"package":"numpy","version":"1.26.4"}`); code != http.StatusOK {
+		t.Fatalf("approve: %d", code)
+	}
+	if len(fs.heldFixes) != 0 {
+		t.Fatalf("approval should clear the held fix, got %v", fs.heldFixes)
+	}
+	if fs.lastEvent.Detail != "held fix approved" {
+		t.Fatalf("expected approval event, got %+v", fs.lastEvent)
+	}
+}
+
+func TestMetricsReportPlanQueueOldestAge(t *testing.T) {
+	h := &Handler{Store: &fakeStore{}, Queue: &fakeQueue{}, PlanQ: &fakePlanQueue{ids: []string{"4"}, oldest: 95}}
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/metrics")
+	if err != nil {
+		t.Fatalf("get metrics: %v", err)
+	}
+	var body struct {
+		Pending struct {
+			PlanQueue          int   `json:"plan_queue"`
+			PlanQueueOldestSec int64 `json:"plan_queue_oldest_seconds"`
+		} `json:"pending"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Pending.PlanQueue != 1 || body.Pending.PlanQueueOldestSec != 95 {
+		t.Fatalf("unexpected plan queue metrics: %+v", body.Pending)
+	}
+
+	resp, err = http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("get prom metrics: %v", err)
+	}
+	text, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(text), "refinery_plan_queue_oldest_seconds 95\n") {
+		t.Fatalf("prometheus output missing plan queue age:\n%s", text)
+	}
+}
+
+func TestPlanQueueListJoinsPendingInputs(t *testing.T) {
+	fs := &fakeStore{listPending: []store.PendingInput{
+		{ID: 4, Filename: "requirements.txt", Status: "planning"},
+		{ID: 9, Filename: "extra.txt", Status: "planning"},
+	}}
+	pq := &fakePlanQueue{ids: []string{"9", "4?reuse_only=true", "12"}}
+	mux := http.NewServeMux()
+	(&Handler{Store: fs, Queue: &fakeQueue{}, PlanQ: pq}).Routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/plan-queue")
+	if err != nil {
+		t.Fatalf("get plan queue: %v", err)
+	}
+	defer resp.Body.Close()
+	var got []planQueueEntry
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := []planQueueEntry{
+		{ID: 9, Item: "9", Filename: "extra.txt", Status: "planning"},
+		{ID: 4, Item: "4?reuse_only=true", Filename: "requirements.txt", Status: "planning"},
+		{ID: 12, Item: "12"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected plan queue listing:\n got %+v\nwant %+v", got, want)
+	}
+	if len(pq.ids) != 3 {
+		t.Fatalf("listing must not consume the queue")
+	}
+}
+
+func TestBuildsTransitionWithoutStore(t *testing.T) {
+	h := &Handler{Queue: &fakeQueue{}}
+	req := httptest.NewRequest(http.MethodPost, "/api/builds/transition", strings.NewReader(`{"from_status":"failed","to_status":"retry"}`))
+	rec := httptest.NewRecorder()
+	h.buildsTransition(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rec.Code)
+	}
+}
+
+func TestReportSummaryWithoutStore(t *testing.T) {
+	h := &Handler{Queue: &fakeQueue{}}
+	rec := httptest.NewRecorder()
+	h.reportSummary(rec, httptest.NewRequest(http.MethodGet, "/api/report/summary", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rec.Code)
+	}
+}
+
+func TestPackageSearchWithoutStore(t *testing.T) {
+	h := &Handler{Queue: &fakeQueue{}}
+	rec := httptest.NewRecorder()
+	h.packageSearch(rec, httptest.NewRequest(http.MethodGet, "/api/packages/search?q=num", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rec.Code)
 	}
 }
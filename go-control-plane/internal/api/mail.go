@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/k8ika0s/s390x-wheel-refinery/go-control-plane/internal/settings"
+)
+
+// mailDigestTick is how often RunMailDigest checks whether a digest is due.
+var mailDigestTick = time.Minute
+
+// sendMailFunc matches smtp.SendMail so tests can capture outgoing mail.
+type sendMailFunc func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+
+// mailFailure reports whether an outcome is worth an email.
+func mailFailure(status string) bool {
+	return status == "failed" || status == "dead_letter"
+}
+
+// notifyMail emails a failed build right away, or queues it for the next
+// digest when SMTPDigestSec is set. It never blocks the caller.
+func (h *Handler) notifyMail(s settings.Settings, outcome buildOutcome) {
+	if s.SMTPHost == "" || !mailFailure(outcome.Status) {
+		return
+	}
+	if s.SMTPDigestSec > 0 {
+		h.mailMu.Lock()
+		h.mailQueue = append(h.mailQueue, outcome)
+		h.mailMu.Unlock()
+		return
+	}
+	subject := fmt.Sprintf("[refinery] %s %s %s", outcome.Package, outcome.Version, outcome.Status)
+	go func() {
+		if err := h.sendMail(s, subject, formatFailures([]buildOutcome{outcome})); err != nil {
+			log.Printf("mail for %s %s: %v", outcome.Package, outcome.Version, err)
+		}
+	}()
+}
+
+// RunMailDigest sends a digest of queued failures every SMTPDigestSec
+// seconds until ctx is done. Settings are re-read on each tick so the
+// interval can be changed at runtime.
+func (h *Handler) RunMailDigest(ctx context.Context) {
+	last := time.Now()
+	ticker := time.NewTicker(mailDigestTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s, err := h.loadSettings(ctx)
+		if err != nil || s.SMTPHost == "" || s.SMTPDigestSec <= 0 {
+			continue
+		}
+		if time.Since(last) < time.Duration(s.SMTPDigestSec)*time.Second {
+			continue
+		}
+		last = time.Now()
+		if err := h.flushMailDigest(s); err != nil {
+			log.Printf("mail digest: %v", err)
+		}
+	}
+}
+
+// flushMailDigest sends every queued failure in one message. Nothing is sent
+// when the queue is empty.
+func (h *Handler) flushMailDigest(s settings.Settings) error {
+	h.mailMu.Lock()
+	queued := h.mailQueue
+	h.mailQueue = nil
+	h.mailMu.Unlock()
+	if len(queued) == 0 {
+		return nil
+	}
+	subject := fmt.Sprintf("[refinery] %d build failures", len(queued))
+	return h.sendMail(s, subject, formatFailures(queued))
+}
+
+func formatFailures(outcomes []buildOutcome) string {
+	var b strings.Builder
+	for _, o := range outcomes {
+		fmt.Fprintf(&b, "%s %s: %s", o.Package, o.Version, o.Status)
+		if o.Attempts > 0 {
+			fmt.Fprintf(&b, " after %d attempts", o.Attempts)
+		}
+		if o.RunID != "" {
+			fmt.Fprintf(&b, " (run %s)", o.RunID)
+		}
+		b.WriteString("\r\n")
+		if o.Error != "" {
+			fmt.Fprintf(&b, "  error: %s\r\n", o.Error)
+		}
+	}
+	return b.String()
+}
+
+func (h *Handler) sendMail(s settings.Settings, subject, body string) error {
+	port := s.SMTPPort
+	if port == 0 {
+		port = 587
+	}
+	var auth smtp.Auth
+	if s.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", s.SMTPUsername, s.SMTPPassword, s.SMTPHost)
+	}
+	msg := "From: " + s.SMTPFrom + "\r\n" +
+		"To: " + strings.Join(s.SMTPTo, ", ") + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n\r\n" + body
+	send := h.mailSender
+	if send == nil {
+		send = smtp.SendMail
+	}
+	return send(net.JoinHostPort(s.SMTPHost, strconv.Itoa(port)), auth, s.SMTPFrom, s.SMTPTo, []byte(msg))
+}
@@ -0,0 +1,414 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/k8ika0s/s390x-wheel-refinery/go-control-plane/internal/queue"
+	"github.com/k8ika0s/s390x-wheel-refinery/go-control-plane/internal/settings"
+	"github.com/k8ika0s/s390x-wheel-refinery/go-control-plane/internal/store"
+)
+
+// opDoc documents a single method on a path. Request and response name a
+// component schema; a "[]" prefix means an array of that schema.
+type opDoc struct {
+	summary  string
+	request  string
+	response string
+}
+
+type pathOps map[string]opDoc
+
+// routeDocs maps each ServeMux pattern from routeTable to the OpenAPI paths it
+// serves. Subtree patterns (trailing slash) expand to templated paths.
+var routeDocs = map[string]map[string]pathOps{
+	"/api/health": {"/api/health": {http.MethodGet: {summary: "Liveness with backend status"}}},
+	"/api/ready":  {"/api/ready": {http.MethodGet: {summary: "Readiness probe"}}},
+	"/api/metrics": {"/api/metrics": {
+		http.MethodGet: {summary: "Queue, build, and worker metrics as JSON"},
+	}},
+	"/metrics":    {"/metrics": {http.MethodGet: {summary: "Prometheus metrics"}}},
+	"/api/config": {"/api/config": {http.MethodGet: {summary: "Effective control-plane configuration"}}},
+	"/api/report/summary": {"/api/report/summary": {
+		http.MethodGet: {summary: "Queue depth, build status counts, top failures and flaky builds, oldest pending input"},
+	}},
+	"/api/audit/compatibility": {"/api/audit/compatibility": {
+		http.MethodPost: {summary: "Check wheel filenames (or a wheel pending input) against the current python and platform target"},
+	}},
+	"/api/settings": {"/api/settings": {
+		http.MethodGet:  {summary: "Get settings", response: "Settings"},
+		http.MethodPost: {summary: "Save settings", request: "Settings", response: "Settings"},
+	}},
+	"/api/settings/index-credentials": {"/api/settings/index-credentials": {
+		http.MethodGet: {summary: "Index credentials for workers (worker token required)"},
+	}},
+	"/api/pending-inputs": {"/api/pending-inputs": {
+		http.MethodGet: {summary: "List pending inputs", response: "[]PendingInput"},
+	}},
+	"/api/pending-inputs/clear": {"/api/pending-inputs/clear": {
+		http.MethodPost: {summary: "Soft-delete pending inputs"},
+	}},
+	"/api/pending-inputs/": {
+		"/api/pending-inputs/{id}": {
+			http.MethodDelete: {summary: "Delete a pending input", response: "PendingInput"},
+		},
+		"/api/pending-inputs/{id}/plan": {
+			http.MethodGet: {summary: "Latest plan linked to a pending input", response: "PlanSnapshot"},
+		},
+		"/api/pending-inputs/{id}/enqueue-plan": {
+			http.MethodPost: {summary: "Enqueue a pending input for planning; ?reuse_only=true plans only reusable input wheels; ?force_rebuild=true (or a comma list of packages) ignores CAS and manifest reuse"},
+		},
+		"/api/pending-inputs/{id}/cancel": {
+			http.MethodPost: {summary: "Remove a pending input from the plan queue and set it back to pending"},
+		},
+		"/api/pending-inputs/{id}/restore": {
+			http.MethodPost: {summary: "Restore a deleted pending input", response: "PendingInput"},
+		},
+	},
+	"/api/pending-inputs/purge": {"/api/pending-inputs/purge": {
+		http.MethodPost: {summary: "Hard-delete soft-deleted pending inputs older than ?older_than= seconds and their blobs (worker token)"},
+	}},
+	"/api/pending-inputs/pop": {"/api/pending-inputs/pop": {
+		http.MethodPost: {summary: "Pop pending inputs from the plan queue", response: "[]PendingInput"},
+	}},
+	"/api/pending-inputs/status/": {"/api/pending-inputs/status/{id}": {
+		http.MethodPost: {summary: "Update a pending input status"},
+	}},
+	"/api/plan-queue": {"/api/plan-queue": {
+		http.MethodGet: {summary: "List queued plan requests with their pending inputs"},
+	}},
+	"/api/plan-queue/clear": {"/api/plan-queue/clear": {
+		http.MethodPost: {summary: "Clear the plan queue"},
+	}},
+	"/api/requirements/upload": {"/api/requirements/upload": {
+		http.MethodPost: {summary: "Upload a requirements.txt (multipart); \"include\" parts resolve -r lines", response: "PendingInput"},
+	}},
+	"/api/constraints/upload": {"/api/constraints/upload": {
+		http.MethodPost: {summary: "Upload a constraints file (multipart) for later input uploads"},
+	}},
+	"/api/wheels/upload": {"/api/wheels/upload": {
+		http.MethodPost: {summary: "Upload a wheel (multipart); ?verify=true checks RECORD hashes", response: "PendingInput"},
+	}},
+	"/api/sdists/upload": {"/api/sdists/upload": {
+		http.MethodPost: {summary: "Upload a source distribution (.tar.gz or .zip, multipart)", response: "PendingInput"},
+	}},
+	"/api/builds": {"/api/builds": {
+		http.MethodGet:    {summary: "List builds", response: "[]BuildStatus"},
+		http.MethodDelete: {summary: "Delete builds by status"},
+	}},
+	"/api/builds/transition": {"/api/builds/transition": {
+		http.MethodPost: {summary: "Move all builds in from_status to to_status (worker token)"},
+	}},
+	"/api/builds/cancel": {"/api/builds/cancel": {
+		http.MethodGet:  {summary: "Whether a cancel was requested for ?package=&version="},
+		http.MethodPost: {summary: "Cancel a queued build or flag a running one for its worker to stop (worker token)"},
+	}},
+	"/api/builds/approve-fix": {"/api/builds/approve-fix": {
+		http.MethodPost: {summary: "Apply a held high-impact auto-fix and requeue the build (worker token)"},
+	}},
+	"/api/builds/apply-recipes": {"/api/builds/apply-recipes": {
+		http.MethodPost: {summary: "Attach recipes to a build and requeue it as pending (worker token)"},
+	}},
+	"/api/builds/status": {"/api/builds/status": {
+		http.MethodPost: {summary: "Update a build status"},
+	}},
+	"/api/build-queue/pop": {"/api/build-queue/pop": {
+		http.MethodPost: {summary: "Lease ready builds", response: "[]BuildStatus"},
+	}},
+	"/api/session/token": {"/api/session/token": {http.MethodPost: {summary: "Set the UI session token"}}},
+	"/api/summary":       {"/api/summary": {http.MethodGet: {summary: "Status counts and recent failures", response: "Summary"}}},
+	"/api/recent":        {"/api/recent": {http.MethodGet: {summary: "Recent events", response: "[]Event"}}},
+	"/api/history": {"/api/history": {
+		http.MethodGet:  {summary: "Query event history", response: "[]Event"},
+		http.MethodPost: {summary: "Record an event", request: "Event"},
+	}},
+	"/api/history/bulk": {"/api/history/bulk": {
+		http.MethodPost: {summary: "Record a JSON array or NDJSON batch of events", request: "[]Event"},
+	}},
+	"/api/packages/search": {"/api/packages/search": {
+		http.MethodGet: {summary: "Package names starting with q, for autocomplete"},
+	}},
+	"/api/package/": {
+		"/api/package/{name}":        {http.MethodGet: {summary: "Package summary", response: "PackageSummary"}},
+		"/api/package/{name}/detail": {http.MethodGet: {summary: "Package summary with versions, build rows, and manifest presence"}},
+	},
+	"/api/event/":              {"/api/event/{name}/{version}": {http.MethodGet: {summary: "Latest event for a version", response: "Event"}}},
+	"/api/failures":            {"/api/failures": {http.MethodGet: {summary: "Recent failures", response: "[]Event"}}},
+	"/api/failures/categories": {"/api/failures/categories": {http.MethodGet: {summary: "Failed and retrying builds by failure category"}}},
+	"/api/variants/":           {"/api/variants/{name}": {http.MethodGet: {summary: "Events across variants of a package", response: "[]Event"}}},
+	"/api/top-failures":        {"/api/top-failures": {http.MethodGet: {summary: "Packages with the most failures", response: "[]Stat"}}},
+	"/api/top-slowest":         {"/api/top-slowest": {http.MethodGet: {summary: "Slowest builds", response: "[]Stat"}}},
+	"/api/plan": {"/api/plan": {
+		http.MethodGet:  {summary: "Latest plan nodes", response: "[]PlanNode"},
+		http.MethodPost: {summary: "Save a plan", request: "PlanSnapshot"},
+	}},
+	"/api/plan/latest": {"/api/plan/latest": {http.MethodGet: {summary: "Latest plan snapshot", response: "PlanSnapshot"}}},
+	"/api/plan/": {
+		"/api/plan/{id}": {
+			http.MethodGet: {summary: "Plan snapshot by ID", response: "PlanSnapshot"},
+		},
+		"/api/plan/{id}/export": {
+			http.MethodGet: {summary: "Export a plan as a portable JSON file", response: "PlanExport"},
+		},
+		"/api/plan/{id}/estimate": {
+			http.MethodGet: {summary: "Estimated build time for a plan from historical durations"},
+		},
+		"/api/plan/{id}/enqueue-builds": {
+			http.MethodPost: {summary: "Queue all build nodes of a plan"},
+		},
+		"/api/plan/{id}/enqueue-build": {
+			http.MethodPost: {summary: "Queue a single build node of a plan"},
+		},
+		"/api/plan/{id}/reconcile": {
+			http.MethodPost: {summary: "Create missing pending builds for a plan's build nodes"},
+		},
+	},
+	"/api/plans": {"/api/plans": {
+		http.MethodGet:    {summary: "List plans", response: "[]PlanSummary"},
+		http.MethodDelete: {summary: "Delete plans"},
+	}},
+	"/api/plan/compute": {"/api/plan/compute": {http.MethodPost: {summary: "Compute a plan via the worker", response: "PlanSnapshot"}}},
+	"/api/plan/preview": {"/api/plan/preview": {
+		http.MethodPost: {summary: "Compute a plan via the worker without saving or queueing it", response: "PlanSnapshot"},
+	}},
+	"/api/plan/import": {"/api/plan/import": {
+		http.MethodPost: {summary: "Save an exported plan as a new plan", request: "PlanExport"},
+	}},
+	"/api/manifest": {"/api/manifest": {
+		http.MethodGet:  {summary: "List manifest entries", response: "[]ManifestEntry"},
+		http.MethodPost: {summary: "Save manifest entries", request: "[]ManifestEntry"},
+	}},
+	"/api/manifest/": {"/api/manifest/{name}/{version}/rebuild": {
+		http.MethodPost: {summary: "Re-queue the build behind a manifest entry, forcing its wheel to build; ?python_tag= and ?platform_tag= pick one build of a matrix"},
+	}},
+	"/api/manifest/lookup": {"/api/manifest/lookup": {
+		http.MethodPost: {summary: "Find successful manifest entries for {name, version, python_tag, platform_tag} keys"},
+	}},
+	"/api/artifacts": {"/api/artifacts": {http.MethodGet: {summary: "List artifacts", response: "[]Artifact"}}},
+	"/api/artifacts/referenced": {"/api/artifacts/referenced": {
+		http.MethodGet: {summary: "List artifact digests referenced by plans, builds, and manifests, plus the builds whose per-build files manifests link (worker token)"},
+	}},
+	"/api/queue":       {"/api/queue": {http.MethodGet: {summary: "List retry queue requests", response: "[]QueueRequest"}}},
+	"/api/queue/stats": {"/api/queue/stats": {http.MethodGet: {summary: "Retry queue stats"}}},
+	"/api/queue/enqueue": {"/api/queue/enqueue": {
+		http.MethodPost: {summary: "Enqueue a retry request", request: "QueueRequest"},
+	}},
+	"/api/queue/clear": {"/api/queue/clear": {http.MethodPost: {summary: "Clear the retry queue"}}},
+	"/api/hints": {"/api/hints": {
+		http.MethodGet:  {summary: "List hints", response: "[]Hint"},
+		http.MethodPost: {summary: "Create a hint", request: "Hint", response: "Hint"},
+	}},
+	"/api/hints/bulk": {"/api/hints/bulk": {http.MethodPost: {summary: "Import hints from YAML or JSON"}}},
+	"/api/hints/effectiveness": {"/api/hints/effectiveness": {
+		http.MethodGet: {summary: "Per-hint match counts split by built vs failed outcome"},
+	}},
+	"/api/hints/": {"/api/hints/{id}": {
+		http.MethodGet:    {summary: "Get a hint", response: "Hint"},
+		http.MethodPut:    {summary: "Update a hint", request: "Hint", response: "Hint"},
+		http.MethodDelete: {summary: "Delete a hint"},
+	}},
+	"/api/logs/": {"/api/logs/{name}/{version}": {
+		http.MethodGet: {summary: "Stored build log", response: "LogEntry"},
+	}},
+	"/api/logs/search": {"/api/logs/search": {http.MethodGet: {summary: "Search build logs", response: "[]LogEntry"}}},
+	"/api/logs":        {"/api/logs": {http.MethodPost: {summary: "Ingest a build log", request: "LogEntry"}}},
+	"/api/logs/stream/": {"/api/logs/stream/{name}/{version}": {
+		http.MethodGet:  {summary: "Stream log chunks (websocket or SSE)"},
+		http.MethodPost: {summary: "Append a log chunk", request: "LogChunk"},
+	}},
+	"/api/logs/chunks/": {"/api/logs/chunks/{name}/{version}": {
+		http.MethodGet: {summary: "List log chunks", response: "[]LogChunk"},
+	}},
+	"/api/workers":          {"/api/workers": {http.MethodGet: {summary: "Worker heartbeat status", response: "[]WorkerStatus"}}},
+	"/api/worker/heartbeat": {"/api/worker/heartbeat": {http.MethodPost: {summary: "Record a worker heartbeat", request: "WorkerStatus"}}},
+	"/api/worker/trigger":   {"/api/worker/trigger": {http.MethodPost: {summary: "Trigger the worker to drain queues"}}},
+	"/api/worker/smoke":     {"/api/worker/smoke": {http.MethodPost: {summary: "Run a worker smoke build"}}},
+	"/api/openapi.json":     {"/api/openapi.json": {http.MethodGet: {summary: "This document"}}},
+}
+
+// openAPIComponents lists the types exposed as component schemas.
+var openAPIComponents = map[string]any{
+	"Artifact":        store.Artifact{},
+	"BuildQueueStats": store.BuildQueueStats{},
+	"BuildStatus":     store.BuildStatus{},
+	"Event":           store.Event{},
+	"Hint":            store.Hint{},
+	"LogChunk":        store.LogChunk{},
+	"LogEntry":        store.LogEntry{},
+	"ManifestEntry":   store.ManifestEntry{},
+	"PackageSummary":  store.PackageSummary{},
+	"PendingInput":    store.PendingInput{},
+	"PlanExport":      planExport{},
+	"PlanNode":        store.PlanNode{},
+	"PlanSnapshot":    store.PlanSnapshot{},
+	"PlanSummary":     store.PlanSummary{},
+	"QueueRequest":    queue.Request{},
+	"Settings":        settings.Settings{},
+	"Stat":            store.Stat{},
+	"Summary":         store.Summary{},
+	"WorkerStatus":    store.WorkerStatus{},
+}
+
+var pathParamRe = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+func (h *Handler) openAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, h.openAPIDocument())
+}
+
+// openAPIDocument builds an OpenAPI 3 document from routeTable and routeDocs.
+func (h *Handler) openAPIDocument() map[string]any {
+	named := make(map[reflect.Type]string, len(openAPIComponents))
+	for name, v := range openAPIComponents {
+		named[reflect.TypeOf(v)] = name
+	}
+	schemas := map[string]any{
+		"Error": map[string]any{
+			"type":     "object",
+			"required": []string{"error", "code"},
+			"properties": map[string]any{
+				"error": map[string]any{"type": "string"},
+				"code":  map[string]any{"type": "string"},
+			},
+		},
+	}
+	for name, v := range openAPIComponents {
+		schemas[name] = schemaFor(reflect.TypeOf(v), named, true)
+	}
+	paths := map[string]any{}
+	for _, rt := range h.routeTable() {
+		for path, ops := range routeDocs[rt.pattern] {
+			item := map[string]any{}
+			for method, op := range ops {
+				item[strings.ToLower(method)] = openAPIOperation(path, op)
+			}
+			paths[path] = item
+		}
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "s390x wheel refinery control plane",
+			"version": "1",
+		},
+		"paths":      paths,
+		"components": map[string]any{"schemas": schemas},
+	}
+}
+
+func openAPIOperation(path string, op opDoc) map[string]any {
+	out := map[string]any{"summary": op.summary}
+	var params []any
+	for _, m := range pathParamRe.FindAllStringSubmatch(path, -1) {
+		params = append(params, map[string]any{
+			"name":     m[1],
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]any{"type": "string"},
+		})
+	}
+	if len(params) > 0 {
+		out["parameters"] = params
+	}
+	if op.request != "" {
+		out["requestBody"] = map[string]any{
+			"required": true,
+			"content":  map[string]any{"application/json": map[string]any{"schema": schemaRef(op.request)}},
+		}
+	}
+	ok := map[string]any{"description": "OK"}
+	if op.response != "" {
+		ok["content"] = map[string]any{"application/json": map[string]any{"schema": schemaRef(op.response)}}
+	}
+	out["responses"] = map[string]any{
+		"200": ok,
+		"default": map[string]any{
+			"description": "Error",
+			"content":     map[string]any{"application/json": map[string]any{"schema": schemaRef("Error")}},
+		},
+	}
+	return out
+}
+
+func schemaRef(name string) map[string]any {
+	if strings.HasPrefix(name, "[]") {
+		return map[string]any{"type": "array", "items": schemaRef(strings.TrimPrefix(name, "[]"))}
+	}
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaFor derives a JSON schema from a Go type using its json tags. Named
+// component types are referenced rather than inlined unless top is set.
+func schemaFor(t reflect.Type, named map[reflect.Type]string, top bool) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if name, ok := named[t]; ok && !top {
+		return schemaRef(name)
+	}
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == rawMessageType:
+		return map[string]any{}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem(), named, false)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem(), named, false)}
+	case reflect.Struct:
+		props := map[string]any{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = schemaFor(f.Type, named, false)
+			if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+				required = append(required, name)
+			}
+		}
+		out := map[string]any{"type": "object", "properties": props}
+		if len(required) > 0 {
+			sort.Strings(required)
+			out["required"] = required
+		}
+		return out
+	default:
+		return map[string]any{}
+	}
+}
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/k8ika0s/s390x-wheel-refinery/go-control-plane/internal/store"
+)
+
+const reportTopN = 5
+
+// summaryReport is the digest served by /api/report/summary and delivered
+// to the notification sinks on REPORT_SCHEDULE.
+type summaryReport struct {
+	GeneratedAt        int64                 `json:"generated_at"`
+	Queue              store.BuildQueueStats `json:"queue"`
+	BuildsByStatus     map[string]int        `json:"builds_by_status"`
+	TopFailures        []store.Stat          `json:"top_failures"`
+	TopFlaky           []store.Stat          `json:"top_flaky"`
+	OldestPendingInput *store.PendingInput   `json:"oldest_pending_input,omitempty"`
+}
+
+// buildSummaryReport assembles the report from existing store queries.
+// Flaky builds are those that eventually built but needed more than one
+// attempt, ranked by attempts.
+func (h *Handler) buildSummaryReport(ctx context.Context) (summaryReport, error) {
+	rep := summaryReport{GeneratedAt: time.Now().Unix(), BuildsByStatus: map[string]int{}}
+	var err error
+	if rep.Queue, err = h.Store.BuildQueueStats(ctx); err != nil {
+		return rep, err
+	}
+	builds, err := h.Store.ListBuilds(ctx, "", 0, 0, "", "")
+	if err != nil {
+		return rep, err
+	}
+	var flaky []store.BuildStatus
+	for _, b := range builds {
+		rep.BuildsByStatus[b.Status]++
+		if b.Status == "built" && b.Attempts > 1 {
+			flaky = append(flaky, b)
+		}
+	}
+	sort.SliceStable(flaky, func(i, j int) bool { return flaky[i].Attempts > flaky[j].Attempts })
+	rep.TopFlaky = []store.Stat{}
+	for i, b := range flaky {
+		if i == reportTopN {
+			break
+		}
+		rep.TopFlaky = append(rep.TopFlaky, store.Stat{Name: b.Package + " " + b.Version, Value: float64(b.Attempts)})
+	}
+	if rep.TopFailures, err = h.Store.TopFailures(ctx, reportTopN, 0, 0); err != nil {
+		return rep, err
+	}
+	if rep.TopFailures == nil {
+		rep.TopFailures = []store.Stat{}
+	}
+	pending, err := h.Store.ListPendingInputs(ctx, "pending")
+	if err != nil {
+		return rep, err
+	}
+	for i := range pending {
+		if rep.OldestPendingInput == nil || pending[i].CreatedAt.Before(rep.OldestPendingInput.CreatedAt) {
+			rep.OldestPendingInput = &pending[i]
+		}
+	}
+	return rep, nil
+}
+
+func (h *Handler) reportSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if h.Store == nil {
+		writeError(w, http.StatusServiceUnavailable, codeBackendUnavailable, "store not configured")
+		return
+	}
+	rep, err := h.buildSummaryReport(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, rep)
+}
+
+// RunReportScheduler delivers the summary report to the webhook and SMTP
+// sinks whenever REPORT_SCHEDULE matches, until ctx is done.
+func (h *Handler) RunReportScheduler(ctx context.Context) {
+	if h.Store == nil || h.Config.ReportSchedule == "" {
+		return
+	}
+	sched, err := parseCron(h.Config.ReportSchedule)
+	if err != nil {
+		log.Printf("report scheduler disabled: %v", err)
+		return
+	}
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	var last time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			minute := now.Truncate(time.Minute)
+			if minute.Equal(last) || !sched.matches(minute) {
+				continue
+			}
+			last = minute
+			if err := h.deliverSummaryReport(ctx); err != nil {
+				log.Printf("summary report: %v", err)
+			}
+		}
+	}
+}
+
+func (h *Handler) deliverSummaryReport(ctx context.Context) error {
+	s, err := h.loadSettings(ctx)
+	if err != nil {
+		return err
+	}
+	rep, err := h.buildSummaryReport(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(rep)
+	if err != nil {
+		return err
+	}
+	signature := signWebhook(s.WebhookSecret, data)
+	for _, url := range s.WebhookURLs {
+		go func(url string) {
+			if err := postWebhookWithRetry(url, data, signature); err != nil {
+				log.Printf("summary report webhook %s: %v", url, err)
+			}
+		}(url)
+	}
+	if s.SMTPHost != "" {
+		go func() {
+			if err := h.sendMail(s, "[refinery] summary report", formatSummaryReport(rep)); err != nil {
+				log.Printf("summary report mail: %v", err)
+			}
+		}()
+	}
+	return nil
+}
+
+func formatSummaryReport(rep summaryReport) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Build queue: %d queued (pending %d, retry %d, leased %d, building %d), oldest %ds\r\n",
+		rep.Queue.Length, rep.Queue.Pending, rep.Queue.Retry, rep.Queue.Leased, rep.Queue.Building, rep.Queue.OldestAgeSec)
+	statuses := make([]string, 0, len(rep.BuildsByStatus))
+	for st := range rep.BuildsByStatus {
+		statuses = append(statuses, st)
+	}
+	sort.Strings(statuses)
+	b.WriteString("Builds by status:\r\n")
+	for _, st := range statuses {
+		fmt.Fprintf(&b, "  %s: %d\r\n", st, rep.BuildsByStatus[st])
+	}
+	b.WriteString("Top failures:\r\n")
+	for _, st := range rep.TopFailures {
+		fmt.Fprintf(&b, "  %s: %.0f\r\n", st.Name, st.Value)
+	}
+	b.WriteString("Top flaky (attempts before success):\r\n")
+	for _, st := range rep.TopFlaky {
+		fmt.Fprintf(&b, "  %s: %.0f\r\n", st.Name, st.Value)
+	}
+	if pi := rep.OldestPendingInput; pi != nil {
+		fmt.Fprintf(&b, "Oldest pending input: %s (id %d, since %s)\r\n", pi.Filename, pi.ID, pi.CreatedAt.UTC().Format(time.RFC3339))
+	}
+	return b.String()
+}
+
+// cronSchedule is a parsed five-field cron expression
+// (minute hour day-of-month month day-of-week). Each field accepts *, a
+// number, a comma list, or a */step; all fields must match.
+type cronSchedule [5]map[int]bool
+
+var cronRanges = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+
+func parseCron(expr string) (cronSchedule, error) {
+	var sched cronSchedule
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return sched, fmt.Errorf("cron expression %q must have 5 fields", expr)
+	}
+	for i, field := range fields {
+		lo, hi := cronRanges[i][0], cronRanges[i][1]
+		if field == "*" {
+			continue
+		}
+		set := map[int]bool{}
+		for _, part := range strings.Split(field, ",") {
+			if step, ok := strings.CutPrefix(part, "*/"); ok {
+				n, err := strconv.Atoi(step)
+				if err != nil || n <= 0 {
+					return sched, fmt.Errorf("invalid cron step %q", part)
+				}
+				for v := lo; v <= hi; v += n {
+					set[v] = true
+				}
+				continue
+			}
+			v, err := strconv.Atoi(part)
+			if err != nil || v < lo || v > hi {
+				return sched, fmt.Errorf("invalid cron value %q", part)
+			}
+			set[v] = true
+		}
+		sched[i] = set
+	}
+	return sched, nil
+}
+
+func (c cronSchedule) matches(t time.Time) bool {
+	values := [5]int{t.Minute(), t.Hour(), t.Day(), int(t.Month()), int(t.Weekday())}
+	for i, set := range c {
+		if set != nil && !set[values[i]] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// InputScanner inspects uploaded bytes before they are stored. clean is
+// false when the scanner flagged the content, with detail naming the finding.
+type InputScanner interface {
+	Scan(ctx context.Context, data []byte) (clean bool, detail string, err error)
+}
+
+// NewInputScanner builds the scanner for UPLOAD_SCANNER_URL. clamd:// (or
+// tcp://) addresses speak clamd's INSTREAM protocol; http(s) URLs receive the
+// bytes as a POST and answer with {"clean": bool, "detail": string}.
+func NewInputScanner(raw string) (InputScanner, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scanner url: %w", err)
+	}
+	switch u.Scheme {
+	case "clamd", "tcp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("scanner url %q has no host", raw)
+		}
+		return &clamdScanner{Addr: u.Host, Timeout: 60 * time.Second}, nil
+	case "http", "https":
+		return &httpScanner{URL: raw, Client: &http.Client{Timeout: 60 * time.Second}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported scanner scheme %q", u.Scheme)
+	}
+}
+
+// clamdChunkSize bounds each INSTREAM chunk; clamd rejects chunks larger
+// than its StreamMaxLength.
+const clamdChunkSize = 64 << 10
+
+type clamdScanner struct {
+	Addr    string
+	Timeout time.Duration
+}
+
+func (c *clamdScanner) Scan(ctx context.Context, data []byte) (bool, string, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", c.Addr)
+	if err != nil {
+		return false, "", err
+	}
+	defer conn.Close()
+	if c.Timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return false, "", err
+	}
+	var size [4]byte
+	for len(data) > 0 {
+		n := min(len(data), clamdChunkSize)
+		binary.BigEndian.PutUint32(size[:], uint32(n))
+		if _, err := conn.Write(size[:]); err != nil {
+			return false, "", err
+		}
+		if _, err := conn.Write(data[:n]); err != nil {
+			return false, "", err
+		}
+		data = data[n:]
+	}
+	binary.BigEndian.PutUint32(size[:], 0)
+	if _, err := conn.Write(size[:]); err != nil {
+		return false, "", err
+	}
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return false, "", err
+	}
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	reply = strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	switch {
+	case reply == "OK":
+		return true, "", nil
+	case strings.HasSuffix(reply, "FOUND"):
+		return false, strings.TrimSpace(strings.TrimSuffix(reply, "FOUND")), nil
+	default:
+		return false, "", fmt.Errorf("clamd: %s", reply)
+	}
+}
+
+type httpScanner struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s *httpScanner) Scan(ctx context.Context, data []byte) (bool, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(data))
+	if err != nil {
+		return false, "", err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return false, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return false, "", fmt.Errorf("scanner status %s", resp.Status)
+	}
+	var out struct {
+		Clean  bool   `json:"clean"`
+		Detail string `json:"detail"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return false, "", fmt.Errorf("decode scanner response: %w", err)
+	}
+	return out.Clean, out.Detail, nil
+}
+
+// scanUpload runs the configured scanner over an upload. It writes the error
+// response and returns false when the upload must not be stored; scanner
+// failures reject the upload rather than letting it through unscanned.
+func (h *Handler) scanUpload(w http.ResponseWriter, r *http.Request, data []byte) bool {
+	if h.Scanner == nil {
+		return true
+	}
+	clean, detail, err := h.Scanner.Scan(r.Context(), data)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, codeBackendUnavailable, "content scan failed: "+err.Error())
+		return false
+	}
+	if !clean {
+		msg := "file rejected by content scan"
+		if detail != "" {
+			msg += ": " + detail
+		}
+		writeError(w, http.StatusBadRequest, codeInvalidInput, msg)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/k8ika0s/s390x-wheel-refinery/go-control-plane/internal/settings"
+)
+
+// terminalBuildStatuses are the build_status outcomes that trigger webhook
+// notifications.
+var terminalBuildStatuses = map[string]bool{"built": true, "failed": true, "cancelled": true}
+
+var (
+	webhookAttempts = 3
+	webhookBackoff  = time.Second
+)
+
+// buildOutcome is the payload POSTed to each configured webhook.
+type buildOutcome struct {
+	Package  string `json:"package"`
+	Version  string `json:"version"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	RunID    string `json:"run_id,omitempty"`
+	Attempts int    `json:"attempts,omitempty"`
+}
+
+// notifyBuildOutcome delivers a terminal build status to every configured
+// webhook and the SMTP sink in the background. Delivery is best-effort:
+// failures are logged and never affect the status update that triggered them.
+func (h *Handler) notifyBuildOutcome(ctx context.Context, outcome buildOutcome) {
+	s, err := h.loadSettings(ctx)
+	if err != nil || !notificationWanted(s, outcome) {
+		return
+	}
+	h.notifyMail(s, outcome)
+	if len(s.WebhookURLs) == 0 {
+		return
+	}
+	data, err := json.Marshal(outcome)
+	if err != nil {
+		return
+	}
+	signature := signWebhook(s.WebhookSecret, data)
+	for _, url := range s.WebhookURLs {
+		go func(url string) {
+			if err := postWebhookWithRetry(url, data, signature); err != nil {
+				log.Printf("webhook %s for %s %s: %v", url, outcome.Package, outcome.Version, err)
+			}
+		}(url)
+	}
+}
+
+// notificationWanted applies the settings' notification filters to an
+// outcome. Webhooks and email share the same rules.
+func notificationWanted(s settings.Settings, outcome buildOutcome) bool {
+	if len(s.WebhookStatuses) > 0 {
+		match := false
+		for _, st := range s.WebhookStatuses {
+			if strings.EqualFold(st, outcome.Status) {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return false
+		}
+	}
+	if len(s.WebhookPackages) > 0 {
+		match := false
+		name := strings.ToLower(outcome.Package)
+		for _, glob := range s.WebhookPackages {
+			if ok, _ := path.Match(strings.ToLower(glob), name); ok {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return false
+		}
+	}
+	return outcome.Attempts >= s.WebhookMinAttempts
+}
+
+// signWebhook returns the X-Refinery-Signature value for data, or "" when
+// no secret is configured.
+func signWebhook(secret string, data []byte) string {
+	if secret == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(data)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func postWebhookWithRetry(url string, data []byte, signature string) error {
+	var err error
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		if err = postWebhook(url, data, signature); err == nil {
+			return nil
+		}
+		if attempt < webhookAttempts {
+			time.Sleep(webhookBackoff * time.Duration(attempt))
+		}
+	}
+	return fmt.Errorf("after %d attempts: %w", webhookAttempts, err)
+}
+
+func postWebhook(url string, data []byte, signature string) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if signature != "" {
+		req.Header.Set("X-Refinery-Signature", signature)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package compat
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/k8ika0s/s390x-wheel-refinery/go-control-plane/internal/wheelname"
+)
+
+func TestCheckExplainsIncompatibleWheels(t *testing.T) {
+	cases := []struct {
+		file   string
+		ok     bool
+		reason string
+	}{
+		{"demo-1.0-cp311-cp311-manylinux2014_s390x.whl", true, ""},
+		{"demo-1.0-py3-none-any.whl", true, ""},
+		{"demo-1.0-cp38-abi3-manylinux_2_17_s390x.whl", true, ""},
+		{"demo-1.0-cp311-cp311-manylinux2014_x86_64.whl", false, "platform tag manylinux2014_x86_64"},
+		{"demo-1.0-cp311-cp311-musllinux_1_2_s390x.whl", false, "platform tag musllinux_1_2_s390x"},
+		{"demo-1.0-cp312-abi3-manylinux2014_s390x.whl", false, "abi3 wheel for cp312"},
+		{"demo-1.0-pp39-pypy39_pp73-manylinux2014_s390x.whl", false, "abi tag pypy39_pp73"},
+		{"x-1.0-cp39-cp39-manylinux2014_s390x.whl", false, "python tag cp39 does not match cp311"},
+		{"demo-1.0-cp310.cp311-cp310.cp311-manylinux2014_s390x.whl", true, ""},
+		{"demo-1.0-cp311-none-any.whl", true, ""},
+		{"demo-1.0-cp310-none-any.whl", false, "python tag cp310"},
+	}
+	for _, tc := range cases {
+		w, err := wheelname.Parse(tc.file)
+		if err != nil {
+			t.Fatalf("parse %s: %v", tc.file, err)
+		}
+		ok, reason := Check(w, "cp311", "manylinux2014_s390x")
+		if ok != tc.ok || !strings.Contains(reason, tc.reason) {
+			t.Fatalf("%s: got (%v, %q), want (%v, %q)", tc.file, ok, reason, tc.ok, tc.reason)
+		}
+	}
+}
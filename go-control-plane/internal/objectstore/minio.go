@@ -64,6 +64,11 @@ func (m *MinIOStore) Put(ctx context.Context, key string, data []byte, contentTy
 	return err
 }
 
+// Delete removes bucket/key. S3 treats deleting a missing key as success.
+func (m *MinIOStore) Delete(ctx context.Context, key string) error {
+	return m.Client.RemoveObject(ctx, m.Bucket, key, minio.RemoveObjectOptions{})
+}
+
 // URL returns an s3/http URL; assumes public/readable or presigned elsewhere.
 func (m *MinIOStore) URL(key string) string {
 	scheme := "http"
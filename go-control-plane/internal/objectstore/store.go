@@ -5,6 +5,8 @@ import "context"
 // Store uploads artifacts (e.g., inputs) to an object storage backend.
 type Store interface {
 	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Delete removes the object at key; missing objects are not an error.
+	Delete(ctx context.Context, key string) error
 	// URL returns a fetchable URL for the given key, if available.
 	URL(key string) string
 }
@@ -14,4 +16,6 @@ type NullStore struct{}
 
 func (NullStore) Put(_ context.Context, _ string, _ []byte, _ string) error { return nil }
 
+func (NullStore) Delete(_ context.Context, _ string) error { return nil }
+
 func (NullStore) URL(_ string) string { return "" }
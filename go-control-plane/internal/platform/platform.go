@@ -0,0 +1,127 @@
+// Package platform parses and compares Linux wheel platform tags
+// (manylinux, musllinux, and plain linux).
+//
+// The worker carries an identical copy in its own module; keep the
+// two in sync.
+package platform
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Tag is a parsed platform tag. GlibcMajor/GlibcMinor hold the libc version
+// the tag targets: glibc for manylinux, musl for musllinux, and zero for
+// plain linux tags.
+type Tag struct {
+	Family     string `json:"family"`
+	GlibcMajor int    `json:"glibc_major"`
+	GlibcMinor int    `json:"glibc_minor"`
+	Arch       string `json:"arch"`
+}
+
+// legacyManylinux maps the PEP 513/571/599 aliases to their glibc versions.
+var legacyManylinux = map[string][2]int{
+	"manylinux1":    {2, 5},
+	"manylinux2010": {2, 12},
+	"manylinux2014": {2, 17},
+}
+
+var knownArches = map[string]bool{
+	"x86_64": true, "i686": true, "aarch64": true, "ppc64le": true,
+	"ppc64": true, "s390x": true, "armv7l": true, "riscv64": true,
+}
+
+// Normalize lowercases a tag and replaces '-' with '_' so differently
+// written forms compare equal. It does not validate.
+func Normalize(tag string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(tag)), "-", "_")
+}
+
+// Parse validates a single platform tag such as manylinux2014_s390x,
+// manylinux_2_28_s390x, musllinux_1_2_s390x, or linux_s390x.
+func Parse(raw string) (Tag, error) {
+	tag := Normalize(raw)
+	if tag == "" {
+		return Tag{}, fmt.Errorf("empty platform tag")
+	}
+	for alias, ver := range legacyManylinux {
+		if arch, ok := strings.CutPrefix(tag, alias+"_"); ok {
+			return withArch(Tag{Family: "manylinux", GlibcMajor: ver[0], GlibcMinor: ver[1]}, arch, raw)
+		}
+	}
+	if arch, ok := strings.CutPrefix(tag, "linux_"); ok {
+		return withArch(Tag{Family: "linux"}, arch, raw)
+	}
+	for _, family := range []string{"manylinux", "musllinux"} {
+		rest, ok := strings.CutPrefix(tag, family+"_")
+		if !ok {
+			continue
+		}
+		parts := strings.SplitN(rest, "_", 3)
+		if len(parts) != 3 {
+			return Tag{}, fmt.Errorf("platform tag %q: want %s_<major>_<minor>_<arch>", raw, family)
+		}
+		major, errMajor := strconv.Atoi(parts[0])
+		minor, errMinor := strconv.Atoi(parts[1])
+		if errMajor != nil || errMinor != nil || major <= 0 || minor < 0 {
+			return Tag{}, fmt.Errorf("platform tag %q: invalid libc version", raw)
+		}
+		return withArch(Tag{Family: family, GlibcMajor: major, GlibcMinor: minor}, parts[2], raw)
+	}
+	return Tag{}, fmt.Errorf("platform tag %q: unsupported family", raw)
+}
+
+func withArch(t Tag, arch, raw string) (Tag, error) {
+	if !knownArches[arch] {
+		return Tag{}, fmt.Errorf("platform tag %q: unknown arch %q", raw, arch)
+	}
+	t.Arch = arch
+	return t, nil
+}
+
+// String returns the PEP 600 form (manylinux_2_17_s390x) for manylinux and
+// musllinux tags and linux_<arch> otherwise.
+func (t Tag) String() string {
+	if t.Family == "linux" {
+		return "linux_" + t.Arch
+	}
+	return fmt.Sprintf("%s_%d_%d_%s", t.Family, t.GlibcMajor, t.GlibcMinor, t.Arch)
+}
+
+// KnownArch reports whether arch is an architecture Parse accepts.
+func KnownArch(arch string) bool {
+	return knownArches[arch]
+}
+
+// Arch returns the architecture of a tag, or "" when it does not parse.
+func Arch(tag string) string {
+	t, err := Parse(tag)
+	if err != nil {
+		return ""
+	}
+	return t.Arch
+}
+
+// Compatible reports whether a wheel built for wheelTag installs on target.
+// wheelTag may be a compressed tag set (tags joined by '.'); any member
+// matching is enough. Same family and arch are required, and the wheel's
+// libc version must not be newer than the target's. Plain linux tags only
+// match themselves.
+func Compatible(wheelTag, target string) bool {
+	tt, err := Parse(target)
+	if err != nil {
+		return Normalize(wheelTag) == Normalize(target)
+	}
+	for _, member := range strings.Split(wheelTag, ".") {
+		wt, err := Parse(member)
+		if err != nil || wt.Family != tt.Family || wt.Arch != tt.Arch {
+			continue
+		}
+		if wt.GlibcMajor < tt.GlibcMajor || (wt.GlibcMajor == tt.GlibcMajor && wt.GlibcMinor <= tt.GlibcMinor) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package platform
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		tag     string
+		want    Tag
+		wantErr bool
+	}{
+		{tag: "manylinux1_s390x", want: Tag{"manylinux", 2, 5, "s390x"}},
+		{tag: "manylinux2010_s390x", want: Tag{"manylinux", 2, 12, "s390x"}},
+		{tag: "manylinux2014_s390x", want: Tag{"manylinux", 2, 17, "s390x"}},
+		{tag: "MANYLINUX2014-S390X", want: Tag{"manylinux", 2, 17, "s390x"}},
+		{tag: "manylinux_2_28_s390x", want: Tag{"manylinux", 2, 28, "s390x"}},
+		{tag: "musllinux_1_1_s390x", want: Tag{"musllinux", 1, 1, "s390x"}},
+		{tag: "musllinux_1_2_s390x", want: Tag{"musllinux", 1, 2, "s390x"}},
+		{tag: "linux_s390x", want: Tag{"linux", 0, 0, "s390x"}},
+		{tag: "", wantErr: true},
+		{tag: "any", wantErr: true},
+		{tag: "manylinux_2_s390x", wantErr: true},
+		{tag: "manylinux_x_28_s390x", wantErr: true},
+		{tag: "manylinux2014_s390", wantErr: true},
+		{tag: "win_amd64", wantErr: true},
+	}
+	for _, tc := range cases {
+		got, err := Parse(tc.tag)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("Parse(%q) = %+v, want error", tc.tag, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("Parse(%q) = %+v, %v; want %+v", tc.tag, got, err, tc.want)
+		}
+	}
+}
+
+func TestCompatible(t *testing.T) {
+	cases := []struct {
+		wheel, target string
+		want          bool
+	}{
+		{"manylinux2014_s390x", "manylinux2014_s390x", true},
+		{"manylinux_2_17_s390x", "manylinux2014_s390x", true},
+		{"manylinux2010_s390x", "manylinux_2_28_s390x", true},
+		{"manylinux_2_28_s390x", "manylinux2014_s390x", false},
+		{"manylinux_2_17_s390x.manylinux2014_s390x", "manylinux_2_28_s390x", true},
+		{"manylinux2014_x86_64", "manylinux2014_s390x", false},
+		{"musllinux_1_1_s390x", "musllinux_1_2_s390x", true},
+		{"musllinux_1_2_s390x", "manylinux_2_28_s390x", false},
+		{"manylinux_2_17_s390x", "musllinux_1_2_s390x", false},
+		{"musllinux_1_2_s390x", "musllinux_1_1_s390x", false},
+		{"linux_s390x", "manylinux2014_s390x", false},
+	}
+	for _, tc := range cases {
+		if got := Compatible(tc.wheel, tc.target); got != tc.want {
+			t.Errorf("Compatible(%q, %q) = %v, want %v", tc.wheel, tc.target, got, tc.want)
+		}
+	}
+}
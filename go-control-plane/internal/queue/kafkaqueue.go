@@ -142,3 +142,20 @@ func (k *KafkaQueue) Pop(ctx context.Context, max int) ([]Request, error) {
 	}
 	return items, nil
 }
+
+// Ping dials the broker and fetches cluster metadata.
+func (k *KafkaQueue) Ping(ctx context.Context) error {
+	if err := k.ensure(); err != nil {
+		return err
+	}
+	conn, err := kafka.DialContext(ctx, "tcp", k.brokers)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	_, err = conn.Brokers()
+	return err
+}
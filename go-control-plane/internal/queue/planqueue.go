@@ -4,6 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"strconv"
+	"strings"
+	"time"
 
 	redis "github.com/redis/go-redis/v9"
 )
@@ -13,7 +16,9 @@ type PlanQueueBackend interface {
 	Enqueue(ctx context.Context, id string) error
 	Pop(ctx context.Context, max int) ([]string, error)
 	Len(ctx context.Context) (int64, error)
+	List(ctx context.Context) ([]string, error)
 	Clear(ctx context.Context) ([]string, error)
+	Remove(ctx context.Context, id string) (int, error)
 }
 
 // PlanQueue is a Redis-backed queue for plan IDs.
@@ -43,11 +48,22 @@ func (p *PlanQueue) ensure() error {
 	return nil
 }
 
+// Ping checks that the Redis server answers.
+func (p *PlanQueue) Ping(ctx context.Context) error {
+	if err := p.ensure(); err != nil {
+		return err
+	}
+	return p.client.Ping(ctx).Err()
+}
+
 func (p *PlanQueue) Enqueue(ctx context.Context, id string) error {
 	if err := p.ensure(); err != nil {
 		return err
 	}
-	payload, _ := json.Marshal(map[string]string{"pending_input_id": id})
+	payload, _ := json.Marshal(map[string]string{
+		"pending_input_id": id,
+		"enqueued_at":      strconv.FormatInt(time.Now().Unix(), 10),
+	})
 	return p.client.RPush(ctx, p.key, payload).Err()
 }
 
@@ -88,6 +104,33 @@ func (p *PlanQueue) Len(ctx context.Context) (int64, error) {
 	return p.client.LLen(ctx, p.key).Val(), nil
 }
 
+// OldestAge returns the age in seconds of the item at the head of the queue,
+// or 0 when the queue is empty or the head predates enqueue timestamps.
+func (p *PlanQueue) OldestAge(ctx context.Context) (int64, error) {
+	if err := p.ensure(); err != nil {
+		return 0, err
+	}
+	val, err := p.client.LIndex(ctx, p.key, 0).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var payload map[string]string
+	if err := json.Unmarshal([]byte(val), &payload); err != nil {
+		return 0, nil
+	}
+	enqueuedAt, err := strconv.ParseInt(payload["enqueued_at"], 10, 64)
+	if err != nil || enqueuedAt <= 0 {
+		return 0, nil
+	}
+	if age := time.Now().Unix() - enqueuedAt; age > 0 {
+		return age, nil
+	}
+	return 0, nil
+}
+
 // Clear removes all queued plan IDs and returns them.
 func (p *PlanQueue) Clear(ctx context.Context) ([]string, error) {
 	if err := p.ensure(); err != nil {
@@ -100,6 +143,52 @@ func (p *PlanQueue) Clear(ctx context.Context) ([]string, error) {
 	if err := p.client.Del(ctx, p.key).Err(); err != nil {
 		return nil, err
 	}
+	return planItemIDs(vals), nil
+}
+
+// Remove drops every queued item for the pending input id, including items
+// that carry planning options ("42?reuse_only=true"), and returns how many
+// were removed. Other items keep their order.
+func (p *PlanQueue) Remove(ctx context.Context, id string) (int, error) {
+	if err := p.ensure(); err != nil {
+		return 0, err
+	}
+	vals, err := p.client.LRange(ctx, p.key, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, val := range vals {
+		ids := planItemIDs([]string{val})
+		if len(ids) == 0 {
+			continue
+		}
+		if itemID, _, _ := strings.Cut(ids[0], "?"); itemID != id {
+			continue
+		}
+		n, err := p.client.LRem(ctx, p.key, 1, val).Result()
+		if err != nil {
+			return removed, err
+		}
+		removed += int(n)
+	}
+	return removed, nil
+}
+
+// List returns the queued plan IDs in pop order without removing them.
+func (p *PlanQueue) List(ctx context.Context) ([]string, error) {
+	if err := p.ensure(); err != nil {
+		return nil, err
+	}
+	vals, err := p.client.LRange(ctx, p.key, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	return planItemIDs(vals), nil
+}
+
+// planItemIDs extracts the IDs from raw queue payloads, skipping malformed ones.
+func planItemIDs(vals []string) []string {
 	var out []string
 	for _, val := range vals {
 		var payload map[string]string
@@ -110,5 +199,5 @@ func (p *PlanQueue) Clear(ctx context.Context) ([]string, error) {
 			out = append(out, id)
 		}
 	}
-	return out, nil
+	return out
 }
@@ -2,7 +2,11 @@ package queue
 
 import (
 	"context"
+	"encoding/json"
+	"reflect"
+	"strconv"
 	"testing"
+	"time"
 
 	miniredis "github.com/alicebob/miniredis/v2"
 )
@@ -25,6 +29,14 @@ func TestPlanQueueEnqueueAndPop(t *testing.T) {
 		t.Fatalf("enqueue 11: %v", err)
 	}
 
+	listed, err := q.List(ctx)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(listed) != 2 || listed[0] != "10" || listed[1] != "11" {
+		t.Fatalf("unexpected list: %+v", listed)
+	}
+
 	items, err := q.Pop(ctx, 5)
 	if err != nil {
 		t.Fatalf("pop: %v", err)
@@ -49,3 +61,67 @@ func TestPlanQueueEnqueueAndPop(t *testing.T) {
 		t.Fatalf("expected len 0, got %d", llen)
 	}
 }
+
+func TestPlanQueueOldestAge(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	q := NewPlanQueue("redis://"+mr.Addr(), "test:plan")
+	ctx := context.Background()
+	if age, err := q.OldestAge(ctx); err != nil || age != 0 {
+		t.Fatalf("empty queue: expected age 0, got %d %v", age, err)
+	}
+	aged, _ := json.Marshal(map[string]string{
+		"pending_input_id": "7",
+		"enqueued_at":      strconv.FormatInt(time.Now().Add(-2*time.Minute).Unix(), 10),
+	})
+	if _, err := mr.RPush("test:plan", string(aged)); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	if err := q.Enqueue(ctx, "8"); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	age, err := q.OldestAge(ctx)
+	if err != nil {
+		t.Fatalf("oldest age: %v", err)
+	}
+	if age < 120 || age > 130 {
+		t.Fatalf("expected the aged head item to report ~120s, got %d", age)
+	}
+	if ids, _ := q.Pop(ctx, 2); len(ids) != 2 || ids[0] != "7" {
+		t.Fatalf("timestamped payloads must still pop, got %v", ids)
+	}
+}
+
+func TestPlanQueueRemoveDropsOnlyTargetedID(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	q := NewPlanQueue("redis://"+mr.Addr(), "test:plan")
+	ctx := context.Background()
+	for _, item := range []string{"1", "2", "12", "2?reuse_only=true", "3"} {
+		if err := q.Enqueue(ctx, item); err != nil {
+			t.Fatalf("enqueue %s: %v", item, err)
+		}
+	}
+	removed, err := q.Remove(ctx, "2")
+	if err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if removed != 2 {
+		t.Fatalf("expected both items for 2 to be removed, got %d", removed)
+	}
+	listed, _ := q.List(ctx)
+	if !reflect.DeepEqual(listed, []string{"1", "12", "3"}) {
+		t.Fatalf("unexpected remaining items %v", listed)
+	}
+	if removed, err := q.Remove(ctx, "9"); err != nil || removed != 0 {
+		t.Fatalf("removing an absent id should be a no-op, got %d %v", removed, err)
+	}
+}
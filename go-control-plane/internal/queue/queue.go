@@ -1,6 +1,9 @@
 package queue
 
-import "context"
+import (
+	"context"
+	"fmt"
+)
 
 // Request is a retry/build request stored in the queue.
 type Request struct {
@@ -28,3 +31,19 @@ type Stats struct {
 	Length    int   `json:"length"`
 	OldestAge int64 `json:"oldest_age_seconds"`
 }
+
+// Pinger is implemented by backends that can check their connection before
+// the service starts using them.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// ValidateBackend rejects QUEUE_BACKEND values other than file, redis, and
+// kafka. An empty value selects the file queue.
+func ValidateBackend(name string) error {
+	switch name {
+	case "", "file", "redis", "kafka":
+		return nil
+	}
+	return fmt.Errorf("unknown QUEUE_BACKEND %q (want file, redis, or kafka)", name)
+}
@@ -124,3 +124,11 @@ func (r *RedisQueue) Pop(ctx context.Context, max int) ([]Request, error) {
 	}
 	return items, nil
 }
+
+// Ping checks that the Redis server answers.
+func (r *RedisQueue) Ping(ctx context.Context) error {
+	if err := r.ensure(); err != nil {
+		return err
+	}
+	return r.client.Ping(ctx).Err()
+}
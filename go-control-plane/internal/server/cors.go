@@ -19,7 +19,7 @@ func withCORS(cfg config.Config, next http.Handler) http.Handler {
 	}
 	allowedHeaders := cfg.CORSHeaders
 	if len(allowedHeaders) == 0 {
-		allowedHeaders = []string{"Content-Type", "Authorization", "X-Worker-Token"}
+		allowedHeaders = []string{"Content-Type", "Authorization", "X-Worker-Token", "If-None-Match"}
 	}
 	allowAny := containsString(allowedOrigins, "*")
 
@@ -34,6 +34,7 @@ func withCORS(cfg config.Config, next http.Handler) http.Handler {
 			w.Header().Add("Vary", "Origin")
 			w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowedMethods, ", "))
 			w.Header().Set("Access-Control-Allow-Headers", strings.Join(allowedHeaders, ", "))
+			w.Header().Set("Access-Control-Expose-Headers", "ETag")
 			if cfg.CORSCredentials {
 				w.Header().Set("Access-Control-Allow-Credentials", "true")
 			}
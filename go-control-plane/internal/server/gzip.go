@@ -6,11 +6,20 @@ import (
 	"strings"
 )
 
+// gzipMinSize is the smallest body worth compressing; shorter responses are
+// sent as-is since gzip framing would eat most of the savings.
+const gzipMinSize = 1024
+
+// gzipResponseWriter buffers the start of a compressible response until it
+// reaches gzipMinSize, then switches to gzip. Responses that end or flush
+// before the threshold go out uncompressed.
 type gzipResponseWriter struct {
 	http.ResponseWriter
 	writer      *gzip.Writer
 	wroteHeader bool
 	compress    bool
+	buffering   bool
+	buf         []byte
 	statusCode  int
 }
 
@@ -21,10 +30,8 @@ func (g *gzipResponseWriter) WriteHeader(code int) {
 	g.statusCode = code
 	g.wroteHeader = true
 	if shouldCompress(g.ResponseWriter.Header(), code) {
-		g.compress = true
-		g.Header().Set("Content-Encoding", "gzip")
-		g.Header().Del("Content-Length")
-		g.writer = gzip.NewWriter(g.ResponseWriter)
+		g.buffering = true
+		return
 	}
 	g.ResponseWriter.WriteHeader(code)
 }
@@ -36,10 +43,46 @@ func (g *gzipResponseWriter) Write(b []byte) (int, error) {
 	if g.compress {
 		return g.writer.Write(b)
 	}
-	return g.ResponseWriter.Write(b)
+	if !g.buffering {
+		return g.ResponseWriter.Write(b)
+	}
+	g.buf = append(g.buf, b...)
+	if len(g.buf) >= gzipMinSize {
+		if err := g.startGzip(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// startGzip commits to a compressed response and writes the buffered prefix.
+func (g *gzipResponseWriter) startGzip() error {
+	g.buffering = false
+	g.compress = true
+	g.Header().Set("Content-Encoding", "gzip")
+	g.Header().Del("Content-Length")
+	g.ResponseWriter.WriteHeader(g.statusCode)
+	g.writer = gzip.NewWriter(g.ResponseWriter)
+	buf := g.buf
+	g.buf = nil
+	_, err := g.writer.Write(buf)
+	return err
+}
+
+// flushPlain sends a buffered response that never reached gzipMinSize.
+func (g *gzipResponseWriter) flushPlain() error {
+	g.buffering = false
+	g.ResponseWriter.WriteHeader(g.statusCode)
+	buf := g.buf
+	g.buf = nil
+	_, err := g.ResponseWriter.Write(buf)
+	return err
 }
 
 func (g *gzipResponseWriter) Flush() {
+	if g.buffering {
+		_ = g.flushPlain()
+	}
 	if g.compress && g.writer != nil {
 		_ = g.writer.Flush()
 	}
@@ -49,6 +92,9 @@ func (g *gzipResponseWriter) Flush() {
 }
 
 func (g *gzipResponseWriter) Close() error {
+	if g.buffering {
+		return g.flushPlain()
+	}
 	if g.writer != nil {
 		return g.writer.Close()
 	}
@@ -57,7 +103,7 @@ func (g *gzipResponseWriter) Close() error {
 
 func withGzip(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") || isStreamRequest(r) {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -68,6 +114,18 @@ func withGzip(next http.Handler) http.Handler {
 	})
 }
 
+// isStreamRequest reports WebSocket upgrades and SSE/log streams, which need
+// the raw connection and must never sit behind the gzip buffer.
+func isStreamRequest(r *http.Request) bool {
+	if r.Header.Get("Upgrade") != "" {
+		return true
+	}
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		return true
+	}
+	return strings.HasPrefix(r.URL.Path, "/api/logs/stream/")
+}
+
 func shouldCompress(h http.Header, status int) bool {
 	if status == http.StatusNoContent || status == http.StatusNotModified {
 		return false
@@ -79,5 +137,8 @@ func shouldCompress(h http.Header, status int) bool {
 	if contentType == "" {
 		return true
 	}
+	if strings.HasPrefix(contentType, "text/event-stream") {
+		return false
+	}
 	return strings.HasPrefix(contentType, "application/json") || strings.HasPrefix(contentType, "text/")
 }
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGzipCompressesLargeLists(t *testing.T) {
+	var rows []map[string]any
+	for i := 0; i < 200; i++ {
+		rows = append(rows, map[string]any{"name": fmt.Sprintf("pkg-%d", i), "version": "1.0.0", "status": "built"})
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/history", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(rows)
+	})
+	mux.HandleFunc("/api/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status":"ok"}`))
+	})
+	mux.HandleFunc("/api/logs/stream/numpy/1.0", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(strings.Repeat("log line\n", 500)))
+	})
+	ts := httptest.NewServer(withGzip(mux))
+	defer ts.Close()
+
+	get := func(path string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		// Setting Accept-Encoding explicitly disables the transport's transparent decompression.
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("get %s: %v", path, err)
+		}
+		return resp
+	}
+
+	resp := get("/api/history")
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected gzip encoding, got %q", resp.Header.Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	var got []map[string]any
+	if err := json.NewDecoder(zr).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := make([]map[string]any, len(rows))
+	data, _ := json.Marshal(rows)
+	_ = json.Unmarshal(data, &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("decompressed body does not match")
+	}
+
+	small := get("/api/health")
+	body, _ := io.ReadAll(small.Body)
+	small.Body.Close()
+	if small.Header.Get("Content-Encoding") != "" || string(body) != `{"status":"ok"}` {
+		t.Fatalf("small response should be sent plain, got %q %q", small.Header.Get("Content-Encoding"), body)
+	}
+
+	stream := get("/api/logs/stream/numpy/1.0")
+	stream.Body.Close()
+	if stream.Header.Get("Content-Encoding") != "" {
+		t.Fatalf("stream endpoints must not be compressed")
+	}
+}
@@ -3,8 +3,10 @@ package server
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/k8ika0s/s390x-wheel-refinery/go-control-plane/internal/api"
 	"github.com/k8ika0s/s390x-wheel-refinery/go-control-plane/internal/config"
@@ -17,16 +19,72 @@ import (
 
 // Service wires config, backends, and HTTP server.
 type Service struct {
-	cfg config.Config
-	mux *http.ServeMux
+	cfg     config.Config
+	mux     *http.ServeMux
+	q       queue.Backend
+	planQ   queue.PlanQueueBackend
+	scanner api.InputScanner
 }
 
-// New constructs the service with default backends.
-func New(cfg config.Config) *Service {
+// New constructs the service with default backends. It fails when
+// QUEUE_BACKEND is unknown, the configured queue cannot be reached, or
+// UPLOAD_SCANNER_URL is set but invalid.
+func New(cfg config.Config) (*Service, error) {
+	q, planQ, err := newQueues(cfg)
+	if err != nil {
+		return nil, err
+	}
+	scanner, err := newScanner(cfg)
+	if err != nil {
+		return nil, err
+	}
 	mux := http.NewServeMux()
-	s := &Service{cfg: cfg, mux: mux}
+	s := &Service{cfg: cfg, mux: mux, q: q, planQ: planQ, scanner: scanner}
 	s.routes()
-	return s
+	return s, nil
+}
+
+// newQueues builds the queue backends named by QUEUE_BACKEND and pings the
+// networked ones.
+func newQueues(cfg config.Config) (queue.Backend, queue.PlanQueueBackend, error) {
+	if err := queue.ValidateBackend(cfg.QueueBackend); err != nil {
+		return nil, nil, err
+	}
+	var q queue.Backend
+	var planQ queue.PlanQueueBackend
+	switch cfg.QueueBackend {
+	case "redis":
+		q = queue.NewRedisQueue(cfg.RedisURL, cfg.RedisKey)
+		planQ = queue.NewPlanQueue(cfg.RedisURL, cfg.PlanRedisKey)
+	case "kafka":
+		q = queue.NewKafkaQueue(cfg.KafkaBrokers, cfg.KafkaTopic)
+	default:
+		q = queue.NewFileQueue(cfg.QueueFile)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, backend := range []any{q, planQ} {
+		if p, ok := backend.(queue.Pinger); ok {
+			if err := p.Ping(ctx); err != nil {
+				return nil, nil, fmt.Errorf("queue backend unreachable: %w", err)
+			}
+		}
+	}
+	return q, planQ, nil
+}
+
+// newScanner builds the upload scanner named by UPLOAD_SCANNER_URL. It is
+// nil when none is configured; an unusable URL is an error rather than a
+// silently unscanned upload path.
+func newScanner(cfg config.Config) (api.InputScanner, error) {
+	if cfg.ScannerURL == "" {
+		return nil, nil
+	}
+	scanner, err := api.NewInputScanner(cfg.ScannerURL)
+	if err != nil {
+		return nil, fmt.Errorf("UPLOAD_SCANNER_URL: %w", err)
+	}
+	return scanner, nil
 }
 
 func (s *Service) routes() {
@@ -39,18 +97,10 @@ func (s *Service) routes() {
 			log.Printf("warning: migration failed: %v", err)
 		}
 	}
-	var st store.Store = store.NewPostgres(db)
-	var q queue.Backend
-	var planQ queue.PlanQueueBackend
-	switch s.cfg.QueueBackend {
-	case "redis":
-		q = queue.NewRedisQueue(s.cfg.RedisURL, s.cfg.RedisKey)
-		planQ = queue.NewPlanQueue(s.cfg.RedisURL, s.cfg.PlanRedisKey)
-	case "kafka":
-		q = queue.NewKafkaQueue(s.cfg.KafkaBrokers, s.cfg.KafkaTopic)
-	default:
-		q = queue.NewFileQueue(s.cfg.QueueFile)
-	}
+	pg := store.NewPostgres(db)
+	pg.PackageSingleFlight = s.cfg.LeaseSingleFlight
+	pg.FairLeasing = s.cfg.LeaseFairRuns
+	var st store.Store = pg
 	// Load persisted settings to align auto-plan/build toggles on startup.
 	current := settings.ApplyDefaults(settings.Settings{})
 	if st != nil {
@@ -85,8 +135,13 @@ func (s *Service) routes() {
 			inputStore = storeClient
 		}
 	}
-	h := &api.Handler{Store: st, Queue: q, PlanQ: planQ, Config: s.cfg, InputStore: inputStore}
+	h := &api.Handler{Store: st, Queue: s.q, PlanQ: s.planQ, Config: s.cfg, InputStore: inputStore, Scanner: s.scanner}
 	h.Routes(s.mux)
+	go h.RunInputJanitor(context.Background())
+	go h.RunEventRollup(context.Background())
+	go h.RunMailDigest(context.Background())
+	go h.RunReportScheduler(context.Background())
+	go h.RunPlanReconciler(context.Background())
 }
 
 // Start runs the HTTP server.
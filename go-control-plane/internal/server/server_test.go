@@ -0,0 +1,44 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/k8ika0s/s390x-wheel-refinery/go-control-plane/internal/config"
+)
+
+func TestNewQueuesRejectsUnknownBackend(t *testing.T) {
+	_, _, err := newQueues(config.Config{QueueBackend: "redsi"})
+	if err == nil || !strings.Contains(err.Error(), `unknown QUEUE_BACKEND "redsi"`) {
+		t.Fatalf("expected unknown backend error, got %v", err)
+	}
+}
+
+func TestNewQueuesReportsUnreachableRedis(t *testing.T) {
+	// Port 1 is reserved and refuses connections.
+	_, _, err := newQueues(config.Config{QueueBackend: "redis", RedisURL: "redis://127.0.0.1:1/0"})
+	if err == nil || !strings.Contains(err.Error(), "queue backend unreachable") {
+		t.Fatalf("expected connection error, got %v", err)
+	}
+}
+
+func TestNewQueuesAcceptsFileBackend(t *testing.T) {
+	q, planQ, err := newQueues(config.Config{QueueBackend: "file", QueueFile: t.TempDir() + "/queue.json"})
+	if err != nil || q == nil || planQ != nil {
+		t.Fatalf("file backend: q=%v planQ=%v err=%v", q, planQ, err)
+	}
+}
+
+func TestNewScannerRejectsInvalidURL(t *testing.T) {
+	_, err := newScanner(config.Config{ScannerURL: "ftp://scanner:3310"})
+	if err == nil || !strings.Contains(err.Error(), "UPLOAD_SCANNER_URL") {
+		t.Fatalf("expected invalid scanner url error, got %v", err)
+	}
+	scanner, err := newScanner(config.Config{})
+	if err != nil || scanner != nil {
+		t.Fatalf("unset scanner url: scanner=%v err=%v", scanner, err)
+	}
+	if scanner, err := newScanner(config.Config{ScannerURL: "clamd://clamav:3310"}); err != nil || scanner == nil {
+		t.Fatalf("clamd scanner: scanner=%v err=%v", scanner, err)
+	}
+}
@@ -3,7 +3,9 @@ package settings
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"sync"
@@ -19,6 +21,42 @@ type Settings struct {
 	AutoBuild     *bool  `json:"auto_build,omitempty"`
 	PlanPoolSize  int    `json:"plan_pool_size,omitempty"`
 	BuildPoolSize int    `json:"build_pool_size,omitempty"`
+	// MaxPlanNodes fails plans that emit more build nodes than this instead
+	// of queueing them; zero leaves plans uncapped.
+	MaxPlanNodes int `json:"max_plan_nodes,omitempty"`
+	// IndexUsername/IndexPassword are package index credentials used by the
+	// planner. They are write-only: Redact strips them from API responses.
+	IndexUsername string `json:"index_username,omitempty"`
+	IndexPassword string `json:"index_password,omitempty"`
+	// IndexCredentialsSet is reported on reads in place of the credentials.
+	IndexCredentialsSet bool `json:"index_credentials_set,omitempty"`
+	// ClearIndexCredentials on an update drops the stored credentials, which
+	// blank fields alone cannot do. It is never persisted.
+	ClearIndexCredentials bool `json:"clear_index_credentials,omitempty"`
+	// WebhookURLs receive a JSON POST whenever a build reaches a terminal
+	// status. WebhookSecret signs each payload with HMAC-SHA256 and, like the
+	// index credentials, is write-only. ClearWebhookSecret on an update drops
+	// the stored secret so deliveries go out unsigned; it is never persisted.
+	WebhookURLs        []string `json:"webhook_urls,omitempty"`
+	WebhookSecret      string   `json:"webhook_secret,omitempty"`
+	WebhookSecretSet   bool     `json:"webhook_secret_set,omitempty"`
+	ClearWebhookSecret bool     `json:"clear_webhook_secret,omitempty"`
+	// Webhook filters narrow which outcomes are delivered. Empty lists match
+	// everything; package globs use path.Match syntax and ignore case.
+	WebhookStatuses    []string `json:"webhook_statuses,omitempty"`
+	WebhookPackages    []string `json:"webhook_packages,omitempty"`
+	WebhookMinAttempts int      `json:"webhook_min_attempts,omitempty"`
+	// SMTP settings enable failure emails. With SMTPDigestSec set, failures
+	// are batched into one digest per interval instead of one mail each.
+	// SMTPPassword is write-only.
+	SMTPHost        string   `json:"smtp_host,omitempty"`
+	SMTPPort        int      `json:"smtp_port,omitempty"`
+	SMTPFrom        string   `json:"smtp_from,omitempty"`
+	SMTPTo          []string `json:"smtp_to,omitempty"`
+	SMTPUsername    string   `json:"smtp_username,omitempty"`
+	SMTPPassword    string   `json:"smtp_password,omitempty"`
+	SMTPPasswordSet bool     `json:"smtp_password_set,omitempty"`
+	SMTPDigestSec   int      `json:"smtp_digest_sec,omitempty"`
 }
 
 var mu sync.Mutex
@@ -82,9 +120,77 @@ func Validate(s Settings) error {
 			return fmt.Errorf("invalid platform_tag: %q", pt)
 		}
 	}
+	for _, raw := range s.WebhookURLs {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid webhook url: %q", raw)
+		}
+	}
+	for _, glob := range s.WebhookPackages {
+		if _, err := path.Match(glob, ""); err != nil {
+			return fmt.Errorf("invalid webhook package glob: %q", glob)
+		}
+	}
+	if s.MaxPlanNodes < 0 {
+		return fmt.Errorf("invalid max_plan_nodes: %d", s.MaxPlanNodes)
+	}
+	if s.WebhookMinAttempts < 0 {
+		return fmt.Errorf("invalid webhook_min_attempts: %d", s.WebhookMinAttempts)
+	}
+	if s.SMTPHost != "" {
+		if s.SMTPFrom == "" || len(s.SMTPTo) == 0 {
+			return fmt.Errorf("smtp_from and smtp_to are required with smtp_host")
+		}
+		if s.SMTPPort < 0 || s.SMTPPort > 65535 {
+			return fmt.Errorf("invalid smtp_port: %d", s.SMTPPort)
+		}
+	}
+	if s.SMTPDigestSec < 0 {
+		return fmt.Errorf("invalid smtp_digest_sec: %d", s.SMTPDigestSec)
+	}
 	return nil
 }
 
+// Redact removes write-only secrets so settings can be returned to clients.
+func Redact(s Settings) Settings {
+	s.IndexCredentialsSet = s.IndexUsername != "" || s.IndexPassword != ""
+	s.IndexUsername = ""
+	s.IndexPassword = ""
+	s.WebhookSecretSet = s.WebhookSecret != ""
+	s.WebhookSecret = ""
+	s.SMTPPasswordSet = s.SMTPPassword != ""
+	s.SMTPPassword = ""
+	return s
+}
+
+// KeepSecrets carries stored credentials into an update that omits them, so
+// clients that only ever see redacted settings do not wipe them on save.
+// ClearIndexCredentials and ClearWebhookSecret remove the stored values
+// instead.
+func KeepSecrets(update, stored Settings) Settings {
+	if update.ClearIndexCredentials {
+		update.IndexUsername = ""
+		update.IndexPassword = ""
+	} else if update.IndexUsername == "" && update.IndexPassword == "" {
+		update.IndexUsername = stored.IndexUsername
+		update.IndexPassword = stored.IndexPassword
+	}
+	update.IndexCredentialsSet = false
+	update.ClearIndexCredentials = false
+	if update.ClearWebhookSecret {
+		update.WebhookSecret = ""
+	} else if update.WebhookSecret == "" {
+		update.WebhookSecret = stored.WebhookSecret
+	}
+	update.WebhookSecretSet = false
+	update.ClearWebhookSecret = false
+	if update.SMTPPassword == "" {
+		update.SMTPPassword = stored.SMTPPassword
+	}
+	update.SMTPPasswordSet = false
+	return update
+}
+
 // BoolValue resolves a pointer bool to a concrete value (using false as the default).
 func BoolValue(b *bool) bool {
 	if b == nil {
@@ -46,4 +46,27 @@ func TestValidate(t *testing.T) {
 	if err := Validate(Settings{PlatformTag: "bad tag"}); err == nil {
 		t.Fatalf("expected error for invalid platform tag")
 	}
+	if err := Validate(Settings{WebhookURLs: []string{"ftp://hooks.example"}}); err == nil {
+		t.Fatalf("expected error for non-http webhook url")
+	}
+	if err := Validate(Settings{WebhookPackages: []string{"num[py"}}); err == nil {
+		t.Fatalf("expected error for malformed webhook package glob")
+	}
+	if err := Validate(Settings{MaxPlanNodes: -1}); err == nil {
+		t.Fatalf("expected error for negative max_plan_nodes")
+	}
+}
+
+func TestKeepSecretsClearsWebhookSecretOnRequest(t *testing.T) {
+	stored := Settings{WebhookSecret: "s3cret", SMTPPassword: "pw"}
+	if got := KeepSecrets(Settings{}, stored); got.WebhookSecret != "s3cret" || got.SMTPPassword != "pw" {
+		t.Fatalf("blank update should keep stored secrets, got %+v", got)
+	}
+	got := KeepSecrets(Settings{ClearWebhookSecret: true}, stored)
+	if got.WebhookSecret != "" || got.ClearWebhookSecret || got.SMTPPassword != "pw" {
+		t.Fatalf("expected only the webhook secret cleared, got %+v", got)
+	}
+	if got := KeepSecrets(Settings{WebhookSecret: "new"}, stored); got.WebhookSecret != "new" {
+		t.Fatalf("expected the new webhook secret, got %+v", got)
+	}
 }
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// integerMetadataKeys must hold non-negative whole numbers; TopSlowest casts
+// duration_ms to bigint.
+var integerMetadataKeys = []string{"duration_ms", "attempt"}
+
+// digestMetadataKeys must hold sha256-prefixed hex digests.
+var digestMetadataKeys = []string{"wheel_digest", "wheel_source_digest", "runtime_digest", "repair_digest"}
+
+// ValidateEventMetadata checks the metadata keys the control plane queries on.
+// Unknown keys are accepted as-is.
+func ValidateEventMetadata(meta map[string]any) []string {
+	var errs []string
+	for _, key := range integerMetadataKeys {
+		v, ok := meta[key]
+		if !ok || v == nil {
+			continue
+		}
+		if !isNonNegativeInteger(v) {
+			errs = append(errs, fmt.Sprintf("%s must be a non-negative integer", key))
+		}
+	}
+	for _, key := range digestMetadataKeys {
+		v, ok := meta[key]
+		if !ok || v == nil {
+			continue
+		}
+		s, _ := v.(string)
+		if !isSHA256Digest(s) {
+			errs = append(errs, fmt.Sprintf("%s must be a sha256:<hex> digest", key))
+		}
+	}
+	if v, ok := meta["pack_digests"]; ok && v != nil {
+		list, isList := v.([]any)
+		valid := isList
+		for _, item := range list {
+			if s, _ := item.(string); !isSHA256Digest(s) {
+				valid = false
+				break
+			}
+		}
+		if !valid {
+			errs = append(errs, "pack_digests must be a list of sha256:<hex> digests")
+		}
+	}
+	return errs
+}
+
+func isNonNegativeInteger(v any) bool {
+	switch n := v.(type) {
+	case float64:
+		return n >= 0 && n == math.Trunc(n) && n <= math.MaxInt64
+	case int:
+		return n >= 0
+	case int64:
+		return n >= 0
+	default:
+		return false
+	}
+}
+
+func isSHA256Digest(s string) bool {
+	hexPart, ok := strings.CutPrefix(s, "sha256:")
+	if !ok || len(hexPart) != 64 {
+		return false
+	}
+	for _, c := range hexPart {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,40 @@
+package store
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// planActions are the node actions QueueBuildsFromPlan understands.
+var planActions = map[string]bool{"build": true, "reuse": true, "skip": true}
+
+// Wheel tags are lowercase alphanumerics/underscores; compressed tag sets
+// such as "py2.py3" join several with dots.
+var (
+	pythonTagRe   = regexp.MustCompile(`^[a-z]+[0-9][0-9_]*(\.[a-z]+[0-9][0-9_]*)*$`)
+	platformTagRe = regexp.MustCompile(`^[a-z0-9_]+(\.[a-z0-9_]+)*$`)
+)
+
+// ValidatePlanNode returns validation errors for a posted plan node.
+func ValidatePlanNode(n PlanNode) []string {
+	var errs []string
+	if strings.TrimSpace(n.Name) == "" {
+		errs = append(errs, "name required")
+	}
+	if strings.TrimSpace(n.Version) == "" {
+		errs = append(errs, "version required")
+	}
+	// QueueBuildsFromPlan matches actions case-insensitively, so accept
+	// what it would queue.
+	if !planActions[strings.ToLower(strings.TrimSpace(n.Action))] {
+		errs = append(errs, fmt.Sprintf("action %q must be one of build, reuse, skip", n.Action))
+	}
+	if n.PythonTag != "" && !pythonTagRe.MatchString(n.PythonTag) {
+		errs = append(errs, fmt.Sprintf("python_tag %q is not a valid wheel tag", n.PythonTag))
+	}
+	if n.PlatformTag != "" && !platformTagRe.MatchString(n.PlatformTag) {
+		errs = append(errs, fmt.Sprintf("platform_tag %q is not a valid wheel tag", n.PlatformTag))
+	}
+	return errs
+}
@@ -1,12 +1,16 @@
 package store
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"database/sql"
 	"database/sql/driver"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -17,6 +21,12 @@ import (
 // PostgresStore implements Store using Postgres.
 type PostgresStore struct {
 	db *sql.DB
+	// PackageSingleFlight limits leasing to one in-flight build per package
+	// name across all workers.
+	PackageSingleFlight bool
+	// FairLeasing rotates leases across run_ids so every active plan makes
+	// progress instead of the oldest plan draining first.
+	FairLeasing bool
 }
 
 func (p *PostgresStore) ensureDB() error {
@@ -54,6 +64,19 @@ CREATE INDEX IF NOT EXISTS idx_events_timestamp ON events(timestamp);
 CREATE INDEX IF NOT EXISTS idx_events_name_timestamp ON events(name, timestamp DESC);
 CREATE INDEX IF NOT EXISTS idx_events_status_timestamp ON events(status, timestamp DESC);
 CREATE INDEX IF NOT EXISTS idx_events_name_version_timestamp ON events(name, version, timestamp DESC);
+ALTER TABLE events ADD COLUMN IF NOT EXISTS event_id TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_events_event_id ON events(event_id);
+
+CREATE TABLE IF NOT EXISTS event_rollups (
+    day               DATE NOT NULL,
+    name              TEXT NOT NULL,
+    status            TEXT NOT NULL,
+    count             BIGINT NOT NULL,
+    duration_ms_total BIGINT NOT NULL DEFAULT 0,
+    duration_count    BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (day, name, status)
+);
+CREATE INDEX IF NOT EXISTS idx_event_rollups_name ON event_rollups(name);
 
 CREATE TABLE IF NOT EXISTS hints (
     id       TEXT PRIMARY KEY,
@@ -65,7 +88,9 @@ CREATE TABLE IF NOT EXISTS hints (
     applies_to JSONB,
     confidence TEXT,
     examples JSONB,
-    deleted_at TIMESTAMPTZ
+    deleted_at TIMESTAMPTZ,
+    match_count BIGINT NOT NULL DEFAULT 0,
+    last_matched_at TIMESTAMPTZ
 );
 
 ALTER TABLE hints ADD COLUMN IF NOT EXISTS tags JSONB;
@@ -74,6 +99,8 @@ ALTER TABLE hints ADD COLUMN IF NOT EXISTS applies_to JSONB;
 ALTER TABLE hints ADD COLUMN IF NOT EXISTS confidence TEXT;
 ALTER TABLE hints ADD COLUMN IF NOT EXISTS examples JSONB;
 ALTER TABLE hints ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+ALTER TABLE hints ADD COLUMN IF NOT EXISTS match_count BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE hints ADD COLUMN IF NOT EXISTS last_matched_at TIMESTAMPTZ;
 CREATE INDEX IF NOT EXISTS idx_hints_pattern_trgm ON hints USING GIN (pattern gin_trgm_ops);
 CREATE INDEX IF NOT EXISTS idx_hints_note_trgm ON hints USING GIN (note gin_trgm_ops);
 CREATE INDEX IF NOT EXISTS idx_hints_tags_trgm ON hints USING GIN ((tags::text) gin_trgm_ops);
@@ -128,6 +155,14 @@ ALTER TABLE manifests ADD COLUMN IF NOT EXISTS runtime_url TEXT;
 ALTER TABLE manifests ADD COLUMN IF NOT EXISTS pack_urls TEXT[];
 ALTER TABLE manifests ADD COLUMN IF NOT EXISTS repair_url TEXT;
 ALTER TABLE manifests ADD COLUMN IF NOT EXISTS repair_digest TEXT;
+ALTER TABLE manifests ADD COLUMN IF NOT EXISTS sbom_url TEXT;
+ALTER TABLE manifests ADD COLUMN IF NOT EXISTS provenance_url TEXT;
+ALTER TABLE manifests ADD COLUMN IF NOT EXISTS plan_id BIGINT;
+ALTER TABLE manifests ADD COLUMN IF NOT EXISTS run_id TEXT;
+ALTER TABLE manifests ADD COLUMN IF NOT EXISTS builder_id TEXT;
+ALTER TABLE manifests ADD COLUMN IF NOT EXISTS wheel_source_digest TEXT;
+ALTER TABLE manifests ADD COLUMN IF NOT EXISTS repair_tool_version TEXT;
+ALTER TABLE manifests ADD COLUMN IF NOT EXISTS repair_policy_hash TEXT;
 
 CREATE TABLE IF NOT EXISTS app_settings (
     id         INT PRIMARY KEY DEFAULT 1,
@@ -144,12 +179,13 @@ CREATE TABLE IF NOT EXISTS plans (
 );
 
 ALTER TABLE plans ADD COLUMN IF NOT EXISTS dag JSONB;
+ALTER TABLE plans ADD COLUMN IF NOT EXISTS plan_hash TEXT;
 
 CREATE TABLE IF NOT EXISTS build_status (
     id            BIGSERIAL PRIMARY KEY,
     package       TEXT NOT NULL,
     version       TEXT NOT NULL,
-    python_tag    TEXT,
+    python_tag    TEXT NOT NULL DEFAULT '',
     platform_tag  TEXT,
     status        TEXT NOT NULL DEFAULT 'queued',
     attempts      INT NOT NULL DEFAULT 0,
@@ -158,6 +194,7 @@ CREATE TABLE IF NOT EXISTS build_status (
     failure_summary TEXT,
     recipes       JSONB,
     hint_ids      TEXT[],
+    held_recipes  JSONB,
     run_id        TEXT,
     plan_id       BIGINT,
     leased_at     TIMESTAMPTZ,
@@ -179,11 +216,11 @@ CREATE TABLE IF NOT EXISTS worker_status (
     updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 CREATE INDEX IF NOT EXISTS idx_worker_status_last_seen ON worker_status(last_seen);
+ALTER TABLE worker_status ADD COLUMN IF NOT EXISTS smoke JSONB;
 CREATE INDEX IF NOT EXISTS idx_build_status_status ON build_status(status);
 CREATE INDEX IF NOT EXISTS idx_build_status_plan_id ON build_status(plan_id);
 CREATE INDEX IF NOT EXISTS idx_build_status_updated_at ON build_status(updated_at DESC);
 CREATE INDEX IF NOT EXISTS idx_build_status_pkg ON build_status(package, version);
-CREATE UNIQUE INDEX IF NOT EXISTS idx_build_status_pkg_version_unique ON build_status(package, version);
 CREATE INDEX IF NOT EXISTS idx_build_status_status ON build_status(status);
 
 CREATE TABLE IF NOT EXISTS pending_inputs (
@@ -224,6 +261,15 @@ ALTER TABLE build_status ADD COLUMN IF NOT EXISTS leased_at TIMESTAMPTZ;
 ALTER TABLE build_status ADD COLUMN IF NOT EXISTS started_at TIMESTAMPTZ;
 ALTER TABLE build_status ADD COLUMN IF NOT EXISTS finished_at TIMESTAMPTZ;
 ALTER TABLE build_status ADD COLUMN IF NOT EXISTS failure_summary TEXT;
+ALTER TABLE build_status ADD COLUMN IF NOT EXISTS failure_category TEXT;
+ALTER TABLE build_status ADD COLUMN IF NOT EXISTS held_recipes JSONB;
+ALTER TABLE build_status ADD COLUMN IF NOT EXISTS cancel_requested BOOLEAN NOT NULL DEFAULT FALSE;
+-- Builds are keyed by python tag too, so a plan covering several Python
+-- versions keeps one row per version; untagged rows use ''.
+UPDATE build_status SET python_tag = '' WHERE python_tag IS NULL;
+ALTER TABLE build_status ALTER COLUMN python_tag SET DEFAULT '';
+ALTER TABLE build_status ALTER COLUMN python_tag SET NOT NULL;
+DROP INDEX IF EXISTS idx_build_status_pkg_version_unique;
 
 CREATE TABLE IF NOT EXISTS plan_metadata (
     id             BIGSERIAL PRIMARY KEY,
@@ -249,13 +295,17 @@ func RunMigrations(ctx context.Context, db *sql.DB) error {
 		USING build_status b
 		WHERE a.package = b.package
 		  AND a.version = b.version
+		  AND a.python_tag = b.python_tag
 		  AND a.id < b.id
 	`); err != nil {
 		return err
 	}
+	if _, err := db.ExecContext(ctx, `DROP INDEX IF EXISTS idx_build_status_pkg_version`); err != nil {
+		return err
+	}
 	if _, err := db.ExecContext(ctx, `
-		CREATE UNIQUE INDEX IF NOT EXISTS idx_build_status_pkg_version
-		ON build_status(package, version)
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_build_status_pkg_version_tag
+		ON build_status(package, version, python_tag)
 	`); err != nil {
 		return err
 	}
@@ -514,6 +564,100 @@ func (p *PostgresStore) RestorePendingInput(ctx context.Context, id int64) (Pend
 	return pi, err
 }
 
+// RollupEvents folds events older than olderThanSec seconds into per-day,
+// per-package status counts in event_rollups and deletes the raw rows. The
+// move is a single statement, so an event is either counted once in a rollup
+// or still present in events. It returns the number of events rolled up.
+func (p *PostgresStore) RollupEvents(ctx context.Context, olderThanSec int) (int, error) {
+	if err := p.ensureDB(); err != nil {
+		return 0, err
+	}
+	if olderThanSec < 0 {
+		olderThanSec = 0
+	}
+	cutoff := time.Now().Add(-time.Duration(olderThanSec) * time.Second)
+	var moved int
+	err := p.db.QueryRowContext(ctx, `
+		WITH moved AS (
+			DELETE FROM events WHERE timestamp < $1
+			RETURNING name, status, timestamp, COALESCE(duration_ms, (metadata->>'duration_ms')::bigint) AS duration_ms
+		), rolled AS (
+			INSERT INTO event_rollups (day, name, status, count, duration_ms_total, duration_count)
+			SELECT timestamp::date, name, status, count(*), COALESCE(sum(duration_ms), 0), count(duration_ms)
+			FROM moved GROUP BY timestamp::date, name, status
+			ON CONFLICT (day, name, status) DO UPDATE SET
+				count = event_rollups.count + EXCLUDED.count,
+				duration_ms_total = event_rollups.duration_ms_total + EXCLUDED.duration_ms_total,
+				duration_count = event_rollups.duration_count + EXCLUDED.duration_count
+			RETURNING 1
+		)
+		SELECT count(*) FROM moved
+	`, cutoff).Scan(&moved)
+	return moved, err
+}
+
+// PurgePendingInputs hard-deletes pending inputs soft-deleted more than
+// olderThanSec seconds ago and returns the object keys no remaining input
+// uses, so the caller can remove those blobs. Input keys are content
+// addressed, so a re-upload of the same file shares its key with the purged
+// row and keeps the blob. Plan links to purged inputs are cleared, not
+// dropped.
+func (p *PostgresStore) PurgePendingInputs(ctx context.Context, olderThanSec int) ([]string, error) {
+	if err := p.ensureDB(); err != nil {
+		return nil, err
+	}
+	if olderThanSec < 0 {
+		olderThanSec = 0
+	}
+	cutoff := time.Now().Add(-time.Duration(olderThanSec) * time.Second)
+	var keys []string
+	err := p.withRetryTx(ctx, func(tx *sql.Tx) error {
+		keys = keys[:0]
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE plan_metadata SET pending_input = NULL, updated_at = NOW()
+			WHERE pending_input IN (
+				SELECT id FROM pending_inputs WHERE deleted_at IS NOT NULL AND deleted_at < $1
+			)
+		`, cutoff); err != nil {
+			return err
+		}
+		// The outer select still sees the deleted rows, so they are
+		// excluded from the reference check by id.
+		rows, err := tx.QueryContext(ctx, `
+			WITH purged AS (
+				DELETE FROM pending_inputs
+				WHERE deleted_at IS NOT NULL AND deleted_at < $1
+				RETURNING id, COALESCE(object_key,'') AS object_key
+			)
+			SELECT DISTINCT object_key FROM purged
+			WHERE object_key <> ''
+			  AND NOT EXISTS (
+				SELECT 1 FROM pending_inputs p2
+				WHERE p2.object_key = purged.object_key
+				  AND p2.id NOT IN (SELECT id FROM purged)
+			  )
+		`, cutoff)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				return err
+			}
+			if key != "" {
+				keys = append(keys, key)
+			}
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
 // LinkPlanToPendingInput records a plan association for a pending input.
 func (p *PostgresStore) LinkPlanToPendingInput(ctx context.Context, pendingID, planID int64) error {
 	if err := p.ensureDB(); err != nil {
@@ -526,6 +670,50 @@ func (p *PostgresStore) LinkPlanToPendingInput(ctx context.Context, pendingID, p
 	return err
 }
 
+// LatestPlanForPendingInput returns the newest plan linked to a pending input.
+// Re-planning links another plan, so older plans stay as history.
+func (p *PostgresStore) LatestPlanForPendingInput(ctx context.Context, pendingID int64) (PlanSnapshot, error) {
+	if err := p.ensureDB(); err != nil {
+		return PlanSnapshot{}, err
+	}
+	var snap PlanSnapshot
+	var planRaw json.RawMessage
+	var dagRaw []byte // NULL when the planner posted no DAG
+	row := p.db.QueryRowContext(ctx, `
+		SELECT p.id,
+		       p.run_id,
+		       p.plan,
+		       p.dag,
+		       COALESCE(p.plan_hash, ''),
+		       EXISTS (
+		         SELECT 1 FROM build_status bs
+		         WHERE bs.plan_id = p.id
+		           AND bs.status IN ('pending','retry','leased','building')
+		       ) AS queued
+		FROM plans p
+		JOIN plan_metadata pm ON pm.plan_id = p.id
+		WHERE pm.pending_input = $1
+		ORDER BY p.created_at DESC, p.id DESC
+		LIMIT 1`, pendingID)
+	var hash string
+	if err := row.Scan(&snap.ID, &snap.RunID, &planRaw, &dagRaw, &hash, &snap.Queued); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return PlanSnapshot{}, ErrNotFound
+		}
+		return PlanSnapshot{}, err
+	}
+	if err := json.Unmarshal(planRaw, &snap.Plan); err != nil {
+		return PlanSnapshot{}, err
+	}
+	if len(dagRaw) > 0 {
+		snap.DAG = dagRaw
+	}
+	if err := verifyPlanHash(&snap, hash); err != nil {
+		return PlanSnapshot{}, err
+	}
+	return snap, nil
+}
+
 // UpdatePendingInputsForPlan updates pending input status based on plan_id.
 func (p *PostgresStore) UpdatePendingInputsForPlan(ctx context.Context, planID int64, status string) (int64, error) {
 	if err := p.ensureDB(); err != nil {
@@ -564,12 +752,100 @@ func (p *PostgresStore) UpdatePendingInputsForPlan(ctx context.Context, planID i
 	return count, nil
 }
 
+// HoldBuildFix records auto-fix recipes a worker declined to apply because
+// they exceeded its impact limit. They stay on the row until approved. An
+// empty pythonTag matches the build for every python tag.
+func (p *PostgresStore) HoldBuildFix(ctx context.Context, pkg, version, pythonTag string, recipes []string) error {
+	if err := p.ensureDB(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(recipes)
+	if err != nil {
+		return err
+	}
+	_, err = p.db.ExecContext(ctx, `UPDATE build_status SET held_recipes = $3, updated_at = NOW() WHERE package = $1 AND version = $2 AND ($4 = '' OR python_tag = $4)`, pkg, version, data, pythonTag)
+	return err
+}
+
+// ApproveBuildFix promotes a held fix to the build's recipes and requeues it
+// as pending. It returns ErrNotFound when the build has no held fix. An
+// empty pythonTag matches the build for every python tag.
+func (p *PostgresStore) ApproveBuildFix(ctx context.Context, pkg, version, pythonTag string) ([]string, error) {
+	if err := p.ensureDB(); err != nil {
+		return nil, err
+	}
+	var raw json.RawMessage
+	err := p.db.QueryRowContext(ctx, `
+		UPDATE build_status
+		SET recipes = held_recipes,
+		    held_recipes = NULL,
+		    status = 'pending',
+		    backoff_until = NULL,
+		    leased_at = NULL,
+		    started_at = NULL,
+		    finished_at = NULL,
+		    updated_at = NOW()
+		WHERE package = $1 AND version = $2 AND ($3 = '' OR python_tag = $3) AND held_recipes IS NOT NULL
+		RETURNING recipes`, pkg, version, pythonTag).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var recipes []string
+	_ = json.Unmarshal(raw, &recipes)
+	return recipes, nil
+}
+
+// RequestBuildCancel cancels a build. Queued builds (pending/retry) move
+// straight to cancelled; leased or building ones get cancel_requested set so
+// the worker running them can abort. It returns the row's resulting status,
+// or ErrNotFound when no active build matches. An empty pythonTag cancels
+// the build for every python tag.
+func (p *PostgresStore) RequestBuildCancel(ctx context.Context, pkg, version, pythonTag string) (string, error) {
+	if err := p.ensureDB(); err != nil {
+		return "", err
+	}
+	var status string
+	err := p.db.QueryRowContext(ctx, `
+		UPDATE build_status
+		SET cancel_requested = TRUE,
+		    status = CASE WHEN status IN ('pending','retry') THEN 'cancelled' ELSE status END,
+		    finished_at = CASE WHEN status IN ('pending','retry') THEN NOW() ELSE finished_at END,
+		    updated_at = NOW()
+		WHERE package = $1 AND version = $2 AND ($3 = '' OR python_tag = $3) AND status IN ('pending','retry','leased','building')
+		RETURNING status`, pkg, version, pythonTag).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return status, nil
+}
+
+// BuildCancelRequested reports whether a cancel was requested for the
+// build. Unknown builds report false. An empty pythonTag reports a cancel
+// requested for any of the build's python tags.
+func (p *PostgresStore) BuildCancelRequested(ctx context.Context, pkg, version, pythonTag string) (bool, error) {
+	if err := p.ensureDB(); err != nil {
+		return false, err
+	}
+	var requested bool
+	err := p.db.QueryRowContext(ctx, `
+		SELECT COALESCE(bool_or(cancel_requested), FALSE)
+		FROM build_status
+		WHERE package = $1 AND version = $2 AND ($3 = '' OR python_tag = $3)`, pkg, version, pythonTag).Scan(&requested)
+	return requested, err
+}
+
 // ListBuilds returns build status rows filtered by status/plan/package if provided.
 func (p *PostgresStore) ListBuilds(ctx context.Context, status string, limit int, planID int64, pkg string, version string) ([]BuildStatus, error) {
 	if err := p.ensureDB(); err != nil {
 		return nil, err
 	}
-	q := `SELECT id, package, version, python_tag, platform_tag, status, attempts, COALESCE(last_error,''), COALESCE(failure_summary,''), run_id, plan_id, extract(epoch from (NOW() - created_at))::bigint as age, extract(epoch from created_at)::bigint, extract(epoch from updated_at)::bigint, COALESCE(extract(epoch from leased_at),0)::bigint, COALESCE(extract(epoch from started_at),0)::bigint, COALESCE(extract(epoch from finished_at),0)::bigint, COALESCE(extract(epoch from backoff_until),0)::bigint, COALESCE(recipes, '[]'::jsonb), COALESCE(hint_ids, '{}'::text[]) FROM build_status`
+	q := `SELECT id, package, version, python_tag, platform_tag, status, attempts, COALESCE(last_error,''), COALESCE(failure_summary,''), run_id, plan_id, extract(epoch from (NOW() - created_at))::bigint as age, extract(epoch from created_at)::bigint, extract(epoch from updated_at)::bigint, COALESCE(extract(epoch from leased_at),0)::bigint, COALESCE(extract(epoch from started_at),0)::bigint, COALESCE(extract(epoch from finished_at),0)::bigint, COALESCE(extract(epoch from backoff_until),0)::bigint, COALESCE(recipes, '[]'::jsonb), COALESCE(hint_ids, '{}'::text[]), COALESCE(held_recipes, '[]'::jsonb), COALESCE(failure_category,'') FROM build_status`
 	args := []any{}
 	clauses := []string{}
 	if status != "" {
@@ -604,14 +880,17 @@ func (p *PostgresStore) ListBuilds(ctx context.Context, status string, limit int
 	var out []BuildStatus
 	for rows.Next() {
 		var bs BuildStatus
-		var recipes json.RawMessage
+		var recipes, held json.RawMessage
 		var hints pq.StringArray
-		if err := rows.Scan(&bs.ID, &bs.Package, &bs.Version, &bs.PythonTag, &bs.PlatformTag, &bs.Status, &bs.Attempts, &bs.LastError, &bs.FailureSummary, &bs.RunID, &bs.PlanID, &bs.OldestAgeSec, &bs.CreatedAt, &bs.UpdatedAt, &bs.LeasedAt, &bs.StartedAt, &bs.FinishedAt, &bs.BackoffUntil, &recipes, &hints); err != nil {
+		if err := rows.Scan(&bs.ID, &bs.Package, &bs.Version, &bs.PythonTag, &bs.PlatformTag, &bs.Status, &bs.Attempts, &bs.LastError, &bs.FailureSummary, &bs.RunID, &bs.PlanID, &bs.OldestAgeSec, &bs.CreatedAt, &bs.UpdatedAt, &bs.LeasedAt, &bs.StartedAt, &bs.FinishedAt, &bs.BackoffUntil, &recipes, &hints, &held, &bs.FailureCategory); err != nil {
 			return nil, err
 		}
 		if len(recipes) > 0 {
 			_ = json.Unmarshal(recipes, &bs.Recipes)
 		}
+		if len(held) > 0 {
+			_ = json.Unmarshal(held, &bs.HeldRecipes)
+		}
 		if len(hints) > 0 {
 			bs.HintIDs = hints
 		}
@@ -620,7 +899,8 @@ func (p *PostgresStore) ListBuilds(ctx context.Context, status string, limit int
 	return out, rows.Err()
 }
 
-// BuildQueueStats returns aggregate counts for queued build statuses.
+// BuildQueueStats returns aggregate counts for queued build statuses, plus
+// the number of cancelled builds, which are terminal and kept out of failures.
 func (p *PostgresStore) BuildQueueStats(ctx context.Context) (BuildQueueStats, error) {
 	if err := p.ensureDB(); err != nil {
 		return BuildQueueStats{}, err
@@ -629,20 +909,49 @@ func (p *PostgresStore) BuildQueueStats(ctx context.Context) (BuildQueueStats, e
 	row := p.db.QueryRowContext(ctx, `
 		SELECT
 			COUNT(*) FILTER (WHERE status IN ('pending','retry','leased','building')) AS length,
-			COALESCE(EXTRACT(EPOCH FROM (NOW() - MIN(created_at)))::bigint, 0) AS oldest_age_seconds,
+			COALESCE(EXTRACT(EPOCH FROM (NOW() - MIN(created_at) FILTER (WHERE status IN ('pending','retry','leased','building'))))::bigint, 0) AS oldest_age_seconds,
 			COUNT(*) FILTER (WHERE status='pending') AS pending,
 			COUNT(*) FILTER (WHERE status='retry') AS retry,
 			COUNT(*) FILTER (WHERE status='leased') AS leased,
-			COUNT(*) FILTER (WHERE status='building') AS building
+			COUNT(*) FILTER (WHERE status='building') AS building,
+			COUNT(*) FILTER (WHERE status='cancelled') AS cancelled
 		FROM build_status
-		WHERE status IN ('pending','retry','leased','building')
+		WHERE status IN ('pending','retry','leased','building','cancelled')
 	`)
-	if err := row.Scan(&stats.Length, &stats.OldestAgeSec, &stats.Pending, &stats.Retry, &stats.Leased, &stats.Building); err != nil {
+	if err := row.Scan(&stats.Length, &stats.OldestAgeSec, &stats.Pending, &stats.Retry, &stats.Leased, &stats.Building, &stats.Cancelled); err != nil {
 		return BuildQueueStats{}, err
 	}
 	return stats, nil
 }
 
+// FailureCategories counts failed and retrying builds by failure category.
+// Builds reported before categories existed count as unknown.
+func (p *PostgresStore) FailureCategories(ctx context.Context) ([]Stat, error) {
+	if err := p.ensureDB(); err != nil {
+		return nil, err
+	}
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT COALESCE(NULLIF(failure_category, ''), 'unknown') AS category, count(*)::float
+		FROM build_status
+		WHERE status IN ('failed','retry')
+		GROUP BY 1
+		ORDER BY 2 DESC, 1 ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Stat
+	for rows.Next() {
+		var st Stat
+		if err := rows.Scan(&st.Name, &st.Value); err != nil {
+			return nil, err
+		}
+		out = append(out, st)
+	}
+	return out, rows.Err()
+}
+
 // DeleteBuilds removes build status rows matching the status filter.
 func (p *PostgresStore) DeleteBuilds(ctx context.Context, status string) (int64, error) {
 	if err := p.ensureDB(); err != nil {
@@ -664,8 +973,38 @@ func (p *PostgresStore) DeleteBuilds(ctx context.Context, status string) (int64,
 	return count, nil
 }
 
-// UpdateBuildStatus upserts build status by package/version.
-func (p *PostgresStore) UpdateBuildStatus(ctx context.Context, pkg, version, status, errMsg, summary string, attempts int, backoffUntil int64, recipes []string, hintIDs []string) error {
+// TransitionBuilds moves every build in fromStatus to toStatus in a single
+// UPDATE, clearing lease/timing columns the same way UpdateBuildStatus does.
+func (p *PostgresStore) TransitionBuilds(ctx context.Context, fromStatus, toStatus string, resetAttempts bool) (int64, error) {
+	if err := p.ensureDB(); err != nil {
+		return 0, err
+	}
+	res, err := p.db.ExecContext(ctx, `
+		UPDATE build_status
+		SET status = $1,
+		    attempts = CASE WHEN $3::boolean THEN 0 ELSE attempts END,
+		    backoff_until = CASE WHEN $1 IN ('pending','retry') THEN NULL ELSE backoff_until END,
+		    leased_at = CASE WHEN $1 IN ('pending','retry') THEN NULL ELSE leased_at END,
+		    started_at = CASE WHEN $1 IN ('pending','retry','leased') THEN NULL ELSE started_at END,
+		    finished_at = CASE
+		        WHEN $1 IN ('pending','retry','leased','building') THEN NULL
+		        WHEN $1 IN ('built','failed','cancelled') THEN NOW()
+		        ELSE finished_at
+		    END,
+		    updated_at = NOW()
+		WHERE status = $2
+	`, strings.ToLower(toStatus), strings.ToLower(fromStatus), resetAttempts)
+	if err != nil {
+		return 0, err
+	}
+	count, _ := res.RowsAffected()
+	return count, nil
+}
+
+// UpdateBuildStatus upserts build status by package/version/python tag. An
+// empty pythonTag updates the most recently touched row for the package and
+// version, which is the only row unless a plan covered several Pythons.
+func (p *PostgresStore) UpdateBuildStatus(ctx context.Context, pkg, version, pythonTag, status, errMsg, summary, category string, attempts int, backoffUntil int64, recipes []string, hintIDs []string) error {
 	if err := p.ensureDB(); err != nil {
 		return err
 	}
@@ -682,7 +1021,7 @@ func (p *PostgresStore) UpdateBuildStatus(ctx context.Context, pkg, version, sta
 		leasedAt = now
 	case "building":
 		startedAt = now
-	case "built", "failed":
+	case "built", "failed", "cancelled":
 		finishedAt = now
 	}
 	summary = strings.TrimSpace(summary)
@@ -690,6 +1029,10 @@ func (p *PostgresStore) UpdateBuildStatus(ctx context.Context, pkg, version, sta
 	if summary != "" {
 		summaryVal = summary
 	}
+	var categoryVal any
+	if category = strings.TrimSpace(category); category != "" {
+		categoryVal = category
+	}
 	var backoff any
 	if backoffUntil > 0 {
 		backoff = time.Unix(backoffUntil, 0)
@@ -705,16 +1048,27 @@ func (p *PostgresStore) UpdateBuildStatus(ctx context.Context, pkg, version, sta
 		hints = pqStringArrayParam(hintIDs)
 	}
 	_, err := p.db.ExecContext(ctx, `
-		INSERT INTO build_status (package, version, status, last_error, failure_summary, attempts, backoff_until, recipes, hint_ids, leased_at, started_at, finished_at)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
-		ON CONFLICT (package, version) DO UPDATE
+		INSERT INTO build_status (package, version, status, last_error, failure_summary, attempts, backoff_until, recipes, hint_ids, leased_at, started_at, finished_at, failure_category, python_tag)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,
+		        COALESCE(NULLIF($14::text, ''), (
+		            SELECT python_tag FROM build_status
+		            WHERE package = $1 AND version = $2
+		            ORDER BY updated_at DESC, id DESC
+		            LIMIT 1
+		        ), ''))
+		ON CONFLICT (package, version, python_tag) DO UPDATE
 		SET status = EXCLUDED.status,
 		    last_error = EXCLUDED.last_error,
 		    failure_summary = CASE
 		        WHEN EXCLUDED.status IN ('failed','retry') THEN NULLIF(EXCLUDED.failure_summary, '')
-		        WHEN EXCLUDED.status IN ('pending','leased','building','built') THEN NULL
+		        WHEN EXCLUDED.status IN ('pending','leased','building','built','cancelled') THEN NULL
 		        ELSE build_status.failure_summary
 		    END,
+		    failure_category = CASE
+		        WHEN EXCLUDED.status IN ('failed','retry') THEN EXCLUDED.failure_category
+		        WHEN EXCLUDED.status IN ('pending','leased','building','built','cancelled') THEN NULL
+		        ELSE build_status.failure_category
+		    END,
 		    attempts = EXCLUDED.attempts,
 		    backoff_until = EXCLUDED.backoff_until,
 		    recipes = COALESCE(EXCLUDED.recipes, build_status.recipes),
@@ -731,22 +1085,33 @@ func (p *PostgresStore) UpdateBuildStatus(ctx context.Context, pkg, version, sta
 		    END,
 		    finished_at = CASE
 		        WHEN EXCLUDED.status IN ('pending','retry','leased','building') THEN NULL
-		        WHEN EXCLUDED.status IN ('built','failed') THEN NOW()
+		        WHEN EXCLUDED.status IN ('built','failed','cancelled') THEN NOW()
 		        ELSE build_status.finished_at
 		    END,
+		    cancel_requested = CASE
+		        WHEN EXCLUDED.status IN ('pending','retry') THEN FALSE
+		        ELSE build_status.cancel_requested
+		    END,
 		    updated_at = NOW()
-	`, pkg, version, statusLower, errMsg, summaryVal, attempts, backoff, recipesRaw, hints, leasedAt, startedAt, finishedAt)
+	`, pkg, version, statusLower, errMsg, summaryVal, attempts, backoff, recipesRaw, hints, leasedAt, startedAt, finishedAt, categoryVal, pythonTag)
 	return err
 }
 
-func (p *PostgresStore) Recent(ctx context.Context, limit, offset int, pkg, status string) ([]Event, error) {
+func (p *PostgresStore) Recent(ctx context.Context, limit, offset int, pkg, nameLike, status string) ([]Event, error) {
 	if err := p.ensureDB(); err != nil {
 		return nil, err
 	}
 	q := `SELECT run_id,name,version,python_tag,platform_tag,status,detail,metadata,matched_hint_ids,extract(epoch from timestamp)::bigint,COALESCE(duration_ms, 0)
 	      FROM events WHERE 1=1`
 	args := []any{}
-	if pkg != "" {
+	if nameLike != "" {
+		pattern, err := namePattern(nameLike)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, pattern)
+		q += fmt.Sprintf(" AND name ILIKE $%d", len(args))
+	} else if pkg != "" {
 		args = append(args, pkg)
 		q += fmt.Sprintf(" AND name = $%d", len(args))
 	}
@@ -789,7 +1154,14 @@ func (p *PostgresStore) History(ctx context.Context, filter HistoryFilter) ([]Ev
 	q := `SELECT run_id,name,version,python_tag,platform_tag,status,detail,metadata,matched_hint_ids,extract(epoch from timestamp)::bigint,COALESCE(duration_ms, 0)
 	      FROM events WHERE 1=1`
 	args := []any{}
-	if filter.Package != "" {
+	if filter.NameLike != "" {
+		pattern, err := namePattern(filter.NameLike)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, pattern)
+		q += fmt.Sprintf(" AND name ILIKE $%d", len(args))
+	} else if filter.Package != "" {
 		args = append(args, filter.Package)
 		q += fmt.Sprintf(" AND name = $%d", len(args))
 	}
@@ -843,19 +1215,173 @@ func (p *PostgresStore) History(ctx context.Context, filter HistoryFilter) ([]Ev
 	return out, rows.Err()
 }
 
-func (p *PostgresStore) RecordEvent(ctx context.Context, evt Event) error {
+// RecordEvent inserts an event unless one with the same EventKey exists and
+// reports whether a new row was written.
+func (p *PostgresStore) RecordEvent(ctx context.Context, evt Event) (bool, error) {
 	if err := p.ensureDB(); err != nil {
-		return err
+		return false, err
 	}
 	metaBytes, _ := json.Marshal(evt.Metadata)
-	_, err := p.db.ExecContext(ctx, `
-	    INSERT INTO events (run_id,name,version,python_tag,platform_tag,status,detail,metadata,matched_hint_ids,timestamp)
-	    VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,TO_TIMESTAMP($10))`,
-		evt.RunID, evt.Name, evt.Version, evt.PythonTag, evt.PlatformTag, evt.Status, evt.Detail, metaBytes, pq.Array(evt.MatchedHintIDs), evt.Timestamp)
-	return err
+	insert := func(ctx context.Context, db execer) (bool, error) {
+		res, err := db.ExecContext(ctx, `
+	    INSERT INTO events (run_id,name,version,python_tag,platform_tag,status,detail,metadata,matched_hint_ids,timestamp,event_id)
+	    VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,TO_TIMESTAMP($10),$11)
+	    ON CONFLICT (event_id) DO NOTHING`,
+			evt.RunID, evt.Name, evt.Version, evt.PythonTag, evt.PlatformTag, evt.Status, evt.Detail, metaBytes, pq.Array(evt.MatchedHintIDs), evt.Timestamp, EventKey(evt))
+		if err != nil {
+			return false, err
+		}
+		n, _ := res.RowsAffected()
+		return n > 0, nil
+	}
+	if len(evt.MatchedHintIDs) == 0 {
+		return insert(ctx, p.db)
+	}
+	var created bool
+	err := p.withRetryTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		if created, err = insert(ctx, tx); err != nil || !created {
+			return err
+		}
+		usage := hintUsage{}
+		usage.add(evt.MatchedHintIDs, evt.Timestamp)
+		return usage.apply(ctx, tx)
+	})
+	if err != nil {
+		return false, err
+	}
+	return created, nil
+}
+
+// execer is the ExecContext subset shared by *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+type hintCount struct {
+	count int64
+	last  int64
+}
+
+// hintUsage accumulates match counts and the newest match time per hint ID
+// for newly recorded events.
+type hintUsage map[string]*hintCount
+
+func (u hintUsage) add(ids []string, ts int64) {
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		entry, ok := u[id]
+		if !ok {
+			entry = &hintCount{}
+			u[id] = entry
+		}
+		entry.count++
+		if ts > entry.last {
+			entry.last = ts
+		}
+	}
+}
+
+// apply bumps match_count and last_matched_at on the hints table.
+// Unknown hint IDs are ignored.
+func (u hintUsage) apply(ctx context.Context, tx *sql.Tx) error {
+	ids := make([]string, 0, len(u))
+	for id := range u {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		entry := u[id]
+		if _, err := tx.ExecContext(ctx, `UPDATE hints SET match_count = match_count + $2,
+			last_matched_at = GREATEST(last_matched_at, TO_TIMESTAMP($3)) WHERE id = $1`, id, entry.count, entry.last); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eventInsertBatch bounds rows per INSERT to stay under the Postgres
+// parameter limit (11 params per event).
+const eventInsertBatch = 500
+
+// RecordEvents inserts events in a single transaction using multi-row inserts
+// and returns how many were new; duplicates by EventKey are skipped.
+func (p *PostgresStore) RecordEvents(ctx context.Context, events []Event) (int64, error) {
+	if err := p.ensureDB(); err != nil {
+		return 0, err
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+	var inserted int64
+	err := p.withRetryTx(ctx, func(tx *sql.Tx) error {
+		inserted = 0
+		usage := hintUsage{}
+		for start := 0; start < len(events); start += eventInsertBatch {
+			end := start + eventInsertBatch
+			if end > len(events) {
+				end = len(events)
+			}
+			var b strings.Builder
+			b.WriteString("INSERT INTO events (run_id,name,version,python_tag,platform_tag,status,detail,metadata,matched_hint_ids,timestamp,event_id) VALUES ")
+			args := make([]any, 0, (end-start)*11)
+			hasHints := false
+			for i, evt := range events[start:end] {
+				if i > 0 {
+					b.WriteString(",")
+				}
+				n := len(args)
+				fmt.Fprintf(&b, "($%d,$%d,$%d,$%d,$%d,$%d,$%d,$%d,$%d,TO_TIMESTAMP($%d),$%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+9, n+10, n+11)
+				metaBytes, _ := json.Marshal(evt.Metadata)
+				args = append(args, evt.RunID, evt.Name, evt.Version, evt.PythonTag, evt.PlatformTag, evt.Status, evt.Detail, metaBytes, pq.Array(evt.MatchedHintIDs), evt.Timestamp, EventKey(evt))
+				hasHints = hasHints || len(evt.MatchedHintIDs) > 0
+			}
+			b.WriteString(" ON CONFLICT (event_id) DO NOTHING")
+			if !hasHints {
+				res, err := tx.ExecContext(ctx, b.String(), args...)
+				if err != nil {
+					return err
+				}
+				n, _ := res.RowsAffected()
+				inserted += n
+				continue
+			}
+			// Only rows that were actually inserted count toward hint usage.
+			b.WriteString(" RETURNING matched_hint_ids, extract(epoch from timestamp)::bigint")
+			rows, err := tx.QueryContext(ctx, b.String(), args...)
+			if err != nil {
+				return err
+			}
+			for rows.Next() {
+				var ids pq.StringArray
+				var ts int64
+				if err := rows.Scan(&ids, &ts); err != nil {
+					rows.Close()
+					return err
+				}
+				inserted++
+				usage.add(ids, ts)
+			}
+			if err := rows.Close(); err != nil {
+				return err
+			}
+			if err := rows.Err(); err != nil {
+				return err
+			}
+		}
+		return usage.apply(ctx, tx)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return inserted, nil
 }
 
-func (p *PostgresStore) Summary(ctx context.Context, failureLimit int) (Summary, error) {
+func (p *PostgresStore) Summary(ctx context.Context, failureLimit int, fromTs, toTs int64) (Summary, error) {
 	if err := p.ensureDB(); err != nil {
 		return Summary{}, err
 	}
@@ -863,7 +1389,10 @@ func (p *PostgresStore) Summary(ctx context.Context, failureLimit int) (Summary,
 		failureLimit = 20
 	}
 	out := Summary{StatusCounts: map[string]int{}}
-	rows, err := p.db.QueryContext(ctx, `SELECT status, count(*) FROM events GROUP BY status`)
+	window, args := eventWindow(fromTs, toTs, nil)
+	rollups, args := rollupWindow(fromTs, toTs, args)
+	rows, err := p.db.QueryContext(ctx, `SELECT status, count(*) FROM events WHERE 1=1`+window+` GROUP BY status
+		UNION ALL SELECT status, sum(count)::bigint FROM event_rollups WHERE 1=1`+rollups+` GROUP BY status`, args...)
 	if err != nil {
 		return out, err
 	}
@@ -874,10 +1403,12 @@ func (p *PostgresStore) Summary(ctx context.Context, failureLimit int) (Summary,
 		if err := rows.Scan(&status, &count); err != nil {
 			return out, err
 		}
-		out.StatusCounts[status] = count
+		out.StatusCounts[status] += count
 	}
+	window, args = eventWindow(fromTs, toTs, nil)
+	args = append(args, failureLimit)
 	failureRows, err := p.db.QueryContext(ctx, `SELECT run_id,name,version,python_tag,platform_tag,status,detail,metadata,matched_hint_ids,extract(epoch from timestamp)::bigint
-		FROM events WHERE status='failed' ORDER BY timestamp DESC LIMIT $1`, failureLimit)
+		FROM events WHERE status='failed'`+window+fmt.Sprintf(` ORDER BY timestamp DESC LIMIT $%d`, len(args)), args...)
 	if err != nil {
 		return out, err
 	}
@@ -903,7 +1434,8 @@ func (p *PostgresStore) PackageSummary(ctx context.Context, name string) (Packag
 		return PackageSummary{}, err
 	}
 	ps := PackageSummary{Name: name, StatusCounts: map[string]int{}}
-	rows, err := p.db.QueryContext(ctx, `SELECT status, count(*) FROM events WHERE name=$1 GROUP BY status`, name)
+	rows, err := p.db.QueryContext(ctx, `SELECT status, count(*) FROM events WHERE name=$1 GROUP BY status
+		UNION ALL SELECT status, sum(count)::bigint FROM event_rollups WHERE name=$1 GROUP BY status`, name)
 	if err != nil {
 		return ps, err
 	}
@@ -914,7 +1446,7 @@ func (p *PostgresStore) PackageSummary(ctx context.Context, name string) (Packag
 		if err := rows.Scan(&status, &count); err != nil {
 			return ps, err
 		}
-		ps.StatusCounts[status] = count
+		ps.StatusCounts[status] += count
 	}
 	var e Event
 	var metaRaw json.RawMessage
@@ -1017,7 +1549,7 @@ func (p *PostgresStore) Variants(ctx context.Context, name string, limit int) ([
 	return out, rows.Err()
 }
 
-func (p *PostgresStore) TopFailures(ctx context.Context, limit int) ([]Stat, error) {
+func (p *PostgresStore) TopFailures(ctx context.Context, limit int, fromTs, toTs int64) ([]Stat, error) {
 	if err := p.ensureDB(); err != nil {
 		return nil, err
 	}
@@ -1027,7 +1559,13 @@ func (p *PostgresStore) TopFailures(ctx context.Context, limit int) ([]Stat, err
 	if limit > 200 {
 		limit = 200
 	}
-	rows, err := p.db.QueryContext(ctx, `SELECT name, count(*)::float FROM events WHERE status='failed' GROUP BY name ORDER BY count(*) DESC LIMIT $1`, limit)
+	window, args := eventWindow(fromTs, toTs, nil)
+	rollups, args := rollupWindow(fromTs, toTs, args)
+	args = append(args, limit)
+	rows, err := p.db.QueryContext(ctx, `SELECT name, sum(n) AS total FROM (
+		SELECT name, count(*)::float AS n FROM events WHERE status='failed'`+window+` GROUP BY name
+		UNION ALL SELECT name, sum(count)::float FROM event_rollups WHERE status='failed'`+rollups+` GROUP BY name
+	) t`+fmt.Sprintf(` GROUP BY name ORDER BY total DESC LIMIT $%d`, len(args)), args...)
 	if err != nil {
 		return nil, err
 	}
@@ -1043,7 +1581,100 @@ func (p *PostgresStore) TopFailures(ctx context.Context, limit int) ([]Stat, err
 	return out, rows.Err()
 }
 
-func (p *PostgresStore) TopSlowest(ctx context.Context, limit int) ([]Stat, error) {
+// eventWindow appends optional epoch bounds on events.timestamp, matching the
+// from/to semantics of History. Zero bounds are open.
+func eventWindow(fromTs, toTs int64, args []any) (string, []any) {
+	clause := ""
+	if fromTs > 0 {
+		args = append(args, fromTs)
+		clause += fmt.Sprintf(" AND extract(epoch from timestamp) >= $%d", len(args))
+	}
+	if toTs > 0 {
+		args = append(args, toTs)
+		clause += fmt.Sprintf(" AND extract(epoch from timestamp) <= $%d", len(args))
+	}
+	return clause, args
+}
+
+// rollupWindow is eventWindow for event_rollups. Rollups are bucketed by day,
+// so a day counts when the window touches any part of it.
+func rollupWindow(fromTs, toTs int64, args []any) (string, []any) {
+	clause := ""
+	if fromTs > 0 {
+		args = append(args, fromTs)
+		clause += fmt.Sprintf(" AND day >= to_timestamp($%d)::date", len(args))
+	}
+	if toTs > 0 {
+		args = append(args, toTs)
+		clause += fmt.Sprintf(" AND day <= to_timestamp($%d)::date", len(args))
+	}
+	return clause, args
+}
+
+// SearchPackageNames returns distinct package names seen in events or builds
+// that start with prefix (case-insensitive), sorted for autocomplete.
+func (p *PostgresStore) SearchPackageNames(ctx context.Context, prefix string, limit int) ([]string, error) {
+	if err := p.ensureDB(); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = 20
+	}
+	if limit > 200 {
+		limit = 200
+	}
+	pattern := likeEscaper.Replace(prefix) + "%"
+	rows, err := p.db.QueryContext(ctx, `SELECT name FROM (
+			SELECT name FROM events
+			UNION
+			SELECT package AS name FROM build_status
+		) names WHERE name ILIKE $1 ORDER BY lower(name), name LIMIT $2`, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		out = append(out, name)
+	}
+	return out, rows.Err()
+}
+
+// minPatternLiterals is how many non-wildcard characters a name_like glob
+// needs, so "*" or "a*" cannot turn a filtered query into a full scan.
+const minPatternLiterals = 2
+
+// namePattern converts a package glob such as "apache-*" into an ILIKE
+// pattern, escaping LIKE's own wildcards.
+func namePattern(glob string) (string, error) {
+	literals := 0
+	var b strings.Builder
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteByte('%')
+		case '?':
+			b.WriteByte('_')
+		default:
+			literals++
+			b.WriteString(likeEscaper.Replace(string(r)))
+		}
+	}
+	if literals < minPatternLiterals {
+		return "", fmt.Errorf("%w: %q", ErrPatternTooBroad, glob)
+	}
+	return b.String(), nil
+}
+
+// likeEscaper escapes LIKE wildcards so user input matches literally;
+// underscores are common in package names.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func (p *PostgresStore) TopSlowest(ctx context.Context, limit int, fromTs, toTs int64) ([]Stat, error) {
 	if err := p.ensureDB(); err != nil {
 		return nil, err
 	}
@@ -1053,8 +1684,15 @@ func (p *PostgresStore) TopSlowest(ctx context.Context, limit int) ([]Stat, erro
 	if limit > 200 {
 		limit = 200
 	}
-	rows, err := p.db.QueryContext(ctx, `SELECT name, avg((metadata->>'duration_ms')::bigint)::float AS avg_ms
-		FROM events WHERE metadata ? 'duration_ms' GROUP BY name ORDER BY avg_ms DESC LIMIT $1`, limit)
+	window, args := eventWindow(fromTs, toTs, nil)
+	rollups, args := rollupWindow(fromTs, toTs, args)
+	args = append(args, limit)
+	rows, err := p.db.QueryContext(ctx, `SELECT name, (sum(total) / sum(n))::float AS avg_ms FROM (
+		SELECT name, sum((metadata->>'duration_ms')::bigint) AS total, count(*) AS n
+		FROM events WHERE metadata ? 'duration_ms'`+window+` GROUP BY name
+		UNION ALL SELECT name, sum(duration_ms_total), sum(duration_count)
+		FROM event_rollups WHERE duration_count > 0`+rollups+` GROUP BY name
+	) t`+fmt.Sprintf(` GROUP BY name ORDER BY avg_ms DESC LIMIT $%d`, len(args)), args...)
 	if err != nil {
 		return nil, err
 	}
@@ -1070,11 +1708,80 @@ func (p *PostgresStore) TopSlowest(ctx context.Context, limit int) ([]Stat, erro
 	return out, rows.Err()
 }
 
+// AvgDurations returns the historical average build duration in milliseconds
+// for each of names, keyed by lowercased package name. Packages with no
+// recorded durations are absent from the map.
+func (p *PostgresStore) AvgDurations(ctx context.Context, names []string) (map[string]float64, error) {
+	if err := p.ensureDB(); err != nil {
+		return nil, err
+	}
+	out := map[string]float64{}
+	if len(names) == 0 {
+		return out, nil
+	}
+	lowered := make([]string, 0, len(names))
+	for _, n := range names {
+		lowered = append(lowered, strings.ToLower(n))
+	}
+	rows, err := p.db.QueryContext(ctx, `SELECT lower(name), avg((metadata->>'duration_ms')::bigint)::float AS avg_ms
+		FROM events WHERE metadata ? 'duration_ms' AND lower(name) = ANY($1) GROUP BY lower(name)`, pqStringArrayParam(lowered))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var avg float64
+		if err := rows.Scan(&name, &avg); err != nil {
+			return nil, err
+		}
+		out[name] = avg
+	}
+	return out, rows.Err()
+}
+
+// HintEffectiveness counts, per hint, the events that matched it and how the
+// next build of the same package, version, and python tag after each match
+// turned out. The matching event itself never counts as its own outcome.
+func (p *PostgresStore) HintEffectiveness(ctx context.Context) ([]HintEffectiveness, error) {
+	if err := p.ensureDB(); err != nil {
+		return nil, err
+	}
+	rows, err := p.db.QueryContext(ctx, `SELECT h.hint_id,
+			count(*) AS matched,
+			count(*) FILTER (WHERE outcome.status = 'built') AS built,
+			count(*) FILTER (WHERE outcome.status = 'failed') AS failed
+		FROM events e
+		CROSS JOIN LATERAL unnest(e.matched_hint_ids) AS h(hint_id)
+		LEFT JOIN LATERAL (
+			SELECT n.status FROM events n
+			WHERE n.name = e.name AND n.version = e.version
+				AND n.python_tag IS NOT DISTINCT FROM e.python_tag
+				AND (n.timestamp > e.timestamp OR (n.timestamp = e.timestamp AND n.id > e.id))
+				AND n.status IN ('built','failed')
+			ORDER BY n.timestamp ASC, n.id ASC LIMIT 1
+		) outcome ON true
+		GROUP BY h.hint_id ORDER BY matched DESC, h.hint_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []HintEffectiveness{}
+	for rows.Next() {
+		var he HintEffectiveness
+		if err := rows.Scan(&he.HintID, &he.Matched, &he.Built, &he.Failed); err != nil {
+			return nil, err
+		}
+		out = append(out, he)
+	}
+	return out, rows.Err()
+}
+
 func (p *PostgresStore) ListHints(ctx context.Context) ([]Hint, error) {
 	if err := p.ensureDB(); err != nil {
 		return nil, err
 	}
-	rows, err := p.db.QueryContext(ctx, `SELECT id,pattern,recipes,note,tags,severity,applies_to,confidence,examples,deleted_at FROM hints WHERE deleted_at IS NULL`)
+	rows, err := p.db.QueryContext(ctx, `SELECT id,pattern,recipes,note,tags,severity,applies_to,confidence,examples,deleted_at,match_count,extract(epoch from last_matched_at)::bigint FROM hints WHERE deleted_at IS NULL`)
 	if err != nil {
 		return nil, err
 	}
@@ -1113,7 +1820,7 @@ func (p *PostgresStore) ListHintsPaged(ctx context.Context, limit, offset int, q
 	limitIdx := len(args) + 1
 	offsetIdx := len(args) + 2
 	args = append(args, limit, offset)
-	querySQL := fmt.Sprintf(`SELECT id,pattern,recipes,note,tags,severity,applies_to,confidence,examples,deleted_at
+	querySQL := fmt.Sprintf(`SELECT id,pattern,recipes,note,tags,severity,applies_to,confidence,examples,deleted_at,match_count,extract(epoch from last_matched_at)::bigint
 		FROM hints %s ORDER BY id LIMIT $%d OFFSET $%d`, whereClause, limitIdx, offsetIdx)
 	rows, err := p.db.QueryContext(ctx, querySQL, args...)
 	if err != nil {
@@ -1132,9 +1839,11 @@ func scanHints(rows *sql.Rows) ([]Hint, error) {
 		var applies json.RawMessage
 		var examples json.RawMessage
 		var deletedAt sql.NullTime
-		if err := rows.Scan(&h.ID, &h.Pattern, &recipes, &h.Note, &tags, &h.Severity, &applies, &h.Confidence, &examples, &deletedAt); err != nil {
+		var lastMatched sql.NullInt64
+		if err := rows.Scan(&h.ID, &h.Pattern, &recipes, &h.Note, &tags, &h.Severity, &applies, &h.Confidence, &examples, &deletedAt, &h.MatchCount, &lastMatched); err != nil {
 			return nil, err
 		}
+		h.LastMatchedAt = lastMatched.Int64
 		if len(recipes) > 0 {
 			_ = json.Unmarshal(recipes, &h.Recipes)
 		}
@@ -1177,11 +1886,13 @@ func (p *PostgresStore) GetHint(ctx context.Context, id string) (Hint, error) {
 	var tags json.RawMessage
 	var applies json.RawMessage
 	var examples json.RawMessage
-	err := p.db.QueryRowContext(ctx, `SELECT id,pattern,recipes,note,tags,severity,applies_to,confidence,examples FROM hints WHERE id=$1`, id).
-		Scan(&h.ID, &h.Pattern, &recipes, &h.Note, &tags, &h.Severity, &applies, &h.Confidence, &examples)
+	var lastMatched sql.NullInt64
+	err := p.db.QueryRowContext(ctx, `SELECT id,pattern,recipes,note,tags,severity,applies_to,confidence,examples,match_count,extract(epoch from last_matched_at)::bigint FROM hints WHERE id=$1`, id).
+		Scan(&h.ID, &h.Pattern, &recipes, &h.Note, &tags, &h.Severity, &applies, &h.Confidence, &examples, &h.MatchCount, &lastMatched)
 	if err != nil {
 		return Hint{}, err
 	}
+	h.LastMatchedAt = lastMatched.Int64
 	if len(recipes) > 0 {
 		_ = json.Unmarshal(recipes, &h.Recipes)
 	}
@@ -1421,6 +2132,19 @@ func (p *PostgresStore) SearchLogs(ctx context.Context, q string, limit int) ([]
 	return out, rows.Err()
 }
 
+const manifestColumns = `name,version,wheel,wheel_url,repair_url,repair_digest,runtime_url,pack_urls,COALESCE(sbom_url,''),python_tag,platform_tag,status,extract(epoch from created_at)::bigint,COALESCE(provenance_url,''),COALESCE(plan_id,0),COALESCE(run_id,''),COALESCE(builder_id,''),COALESCE(wheel_source_digest,''),COALESCE(repair_tool_version,''),COALESCE(repair_policy_hash,'')`
+
+func scanManifestEntry(row interface{ Scan(...any) error }) (ManifestEntry, error) {
+	var m ManifestEntry
+	var packs pq.StringArray
+	if err := row.Scan(&m.Name, &m.Version, &m.Wheel, &m.WheelURL, &m.RepairURL, &m.RepairDigest, &m.RuntimeURL, &packs, &m.SBOMURL, &m.PythonTag, &m.PlatformTag, &m.Status, &m.CreatedAt,
+		&m.ProvenanceURL, &m.PlanID, &m.RunID, &m.BuilderID, &m.WheelSourceDigest, &m.RepairToolVersion, &m.RepairPolicyHash); err != nil {
+		return ManifestEntry{}, err
+	}
+	m.PackURLs = []string(packs)
+	return m, nil
+}
+
 func (p *PostgresStore) Manifest(ctx context.Context, limit int) ([]ManifestEntry, error) {
 	if err := p.ensureDB(); err != nil {
 		return nil, err
@@ -1428,7 +2152,61 @@ func (p *PostgresStore) Manifest(ctx context.Context, limit int) ([]ManifestEntr
 	if limit <= 0 {
 		limit = 200
 	}
-	rows, err := p.db.QueryContext(ctx, `SELECT name,version,wheel,wheel_url,repair_url,repair_digest,runtime_url,pack_urls,python_tag,platform_tag,status,extract(epoch from created_at)::bigint FROM manifests ORDER BY created_at DESC LIMIT $1`, limit)
+	rows, err := p.db.QueryContext(ctx, `SELECT `+manifestColumns+` FROM manifests ORDER BY created_at DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []ManifestEntry
+	for rows.Next() {
+		m, err := scanManifestEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// ManifestEntryByKey returns the newest manifest entry for a wheel key, or
+// ErrNotFound. The name matches case-insensitively; empty tags match any.
+func (p *PostgresStore) ManifestEntryByKey(ctx context.Context, key ManifestKey) (ManifestEntry, error) {
+	if err := p.ensureDB(); err != nil {
+		return ManifestEntry{}, err
+	}
+	row := p.db.QueryRowContext(ctx, `SELECT `+manifestColumns+` FROM manifests
+		WHERE lower(name) = lower($1) AND version = $2
+		  AND ($3 = '' OR python_tag = $3)
+		  AND ($4 = '' OR platform_tag = $4)
+		ORDER BY created_at DESC LIMIT 1`, key.Name, key.Version, key.PythonTag, key.PlatformTag)
+	m, err := scanManifestEntry(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ManifestEntry{}, ErrNotFound
+	}
+	return m, err
+}
+
+// BuiltManifestEntries returns the newest successful manifest entry for each
+// key that has one. Names match case-insensitively; versions and tags match
+// exactly.
+func (p *PostgresStore) BuiltManifestEntries(ctx context.Context, keys []ManifestKey) ([]ManifestEntry, error) {
+	if err := p.ensureDB(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	wanted := make(map[ManifestKey]bool, len(keys))
+	names := make([]string, 0, len(keys))
+	for _, k := range keys {
+		k.Name = strings.ToLower(k.Name)
+		if !wanted[k] {
+			names = append(names, k.Name)
+		}
+		wanted[k] = true
+	}
+	rows, err := p.db.QueryContext(ctx, `SELECT name,version,wheel,COALESCE(wheel_url,''),COALESCE(python_tag,''),COALESCE(platform_tag,''),status,extract(epoch from created_at)::bigint,COALESCE(plan_id,0),COALESCE(run_id,''),COALESCE(wheel_source_digest,'')
+		FROM manifests WHERE status = 'built' AND lower(name) = ANY($1) ORDER BY created_at DESC`, pq.StringArray(names))
 	if err != nil {
 		return nil, err
 	}
@@ -1436,11 +2214,15 @@ func (p *PostgresStore) Manifest(ctx context.Context, limit int) ([]ManifestEntr
 	var out []ManifestEntry
 	for rows.Next() {
 		var m ManifestEntry
-		var packs pq.StringArray
-		if err := rows.Scan(&m.Name, &m.Version, &m.Wheel, &m.WheelURL, &m.RepairURL, &m.RepairDigest, &m.RuntimeURL, &packs, &m.PythonTag, &m.PlatformTag, &m.Status, &m.CreatedAt); err != nil {
+		if err := rows.Scan(&m.Name, &m.Version, &m.Wheel, &m.WheelURL, &m.PythonTag, &m.PlatformTag, &m.Status, &m.CreatedAt, &m.PlanID, &m.RunID, &m.WheelSourceDigest); err != nil {
 			return nil, err
 		}
-		m.PackURLs = []string(packs)
+		k := ManifestKey{Name: strings.ToLower(m.Name), Version: m.Version, PythonTag: m.PythonTag, PlatformTag: m.PlatformTag}
+		if !wanted[k] {
+			continue
+		}
+		// Rows are newest first, so the first hit per key wins.
+		delete(wanted, k)
 		out = append(out, m)
 	}
 	return out, rows.Err()
@@ -1457,9 +2239,11 @@ func (p *PostgresStore) SaveManifest(ctx context.Context, entries []ManifestEntr
 		if m.CreatedAt == 0 {
 			m.CreatedAt = time.Now().Unix()
 		}
-		_, err := p.db.ExecContext(ctx, `INSERT INTO manifests (name,version,wheel,wheel_url,repair_url,repair_digest,runtime_url,pack_urls,python_tag,platform_tag,status,created_at)
-			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,TO_TIMESTAMP($12))`,
-			m.Name, m.Version, m.Wheel, m.WheelURL, m.RepairURL, m.RepairDigest, m.RuntimeURL, pq.StringArray(m.PackURLs), m.PythonTag, m.PlatformTag, m.Status, m.CreatedAt)
+		_, err := p.db.ExecContext(ctx, `INSERT INTO manifests (name,version,wheel,wheel_url,repair_url,repair_digest,runtime_url,pack_urls,sbom_url,python_tag,platform_tag,status,created_at,
+				provenance_url,plan_id,run_id,builder_id,wheel_source_digest,repair_tool_version,repair_policy_hash)
+			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,TO_TIMESTAMP($13),$14,$15,$16,$17,$18,$19,$20)`,
+			m.Name, m.Version, m.Wheel, m.WheelURL, m.RepairURL, m.RepairDigest, m.RuntimeURL, pq.StringArray(m.PackURLs), m.SBOMURL, m.PythonTag, m.PlatformTag, m.Status, m.CreatedAt,
+			m.ProvenanceURL, m.PlanID, m.RunID, m.BuilderID, m.WheelSourceDigest, m.RepairToolVersion, m.RepairPolicyHash)
 		if err != nil {
 			return err
 		}
@@ -1482,6 +2266,123 @@ func (p *PostgresStore) Artifacts(ctx context.Context, limit int) ([]Artifact, e
 	return out, nil
 }
 
+// referencedEventDigestsSQL collects artifact digests recorded by build events
+// and manifests.
+const referencedEventDigestsSQL = `
+SELECT DISTINCT d FROM (
+	SELECT metadata->>'wheel_digest' AS d FROM events
+	UNION ALL SELECT metadata->>'wheel_source_digest' FROM events
+	UNION ALL SELECT metadata->>'runtime_digest' FROM events
+	UNION ALL SELECT metadata->>'repair_digest' FROM events
+	UNION ALL SELECT jsonb_array_elements_text(metadata->'pack_digests') FROM events
+		WHERE jsonb_typeof(metadata->'pack_digests') = 'array'
+	UNION ALL SELECT repair_digest FROM manifests
+) refs
+WHERE d IS NOT NULL AND d <> ''`
+
+// ReferencedDigests returns every artifact digest still referenced by a stored
+// plan DAG, a build event, or a manifest, sorted and deduplicated. Artifacts
+// outside this set are garbage collection candidates.
+func (p *PostgresStore) ReferencedDigests(ctx context.Context) ([]string, error) {
+	if err := p.ensureDB(); err != nil {
+		return nil, err
+	}
+	refs := make(map[string]struct{})
+	rows, err := p.db.QueryContext(ctx, `SELECT dag FROM plans WHERE dag IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var raw []byte
+		if err := rows.Scan(&raw); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		for _, d := range dagDigests(raw) {
+			refs[d] = struct{}{}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, err
+	}
+	rows.Close()
+
+	rows, err = p.db.QueryContext(ctx, referencedEventDigestsSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d string
+		if err := rows.Scan(&d); err != nil {
+			return nil, err
+		}
+		refs[d] = struct{}{}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, len(refs))
+	for d := range refs {
+		out = append(out, d)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// ReferencedBuilds returns the builds whose manifest entries link a per-build
+// SBOM or provenance attestation. Neither is content addressed, so garbage
+// collection keeps them by object key rather than by digest.
+func (p *PostgresStore) ReferencedBuilds(ctx context.Context) ([]ManifestKey, error) {
+	if err := p.ensureDB(); err != nil {
+		return nil, err
+	}
+	rows, err := p.db.QueryContext(ctx, `SELECT DISTINCT name, version, COALESCE(python_tag,''), COALESCE(platform_tag,'')
+		FROM manifests WHERE COALESCE(sbom_url,'') <> '' OR COALESCE(provenance_url,'') <> ''
+		ORDER BY 1, 2, 3, 4`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []ManifestKey{}
+	for rows.Next() {
+		var k ManifestKey
+		if err := rows.Scan(&k.Name, &k.Version, &k.PythonTag, &k.PlatformTag); err != nil {
+			return nil, err
+		}
+		out = append(out, k)
+	}
+	return out, rows.Err()
+}
+
+// dagDigests extracts node and input digests from a stored plan DAG.
+// Malformed DAGs yield nothing rather than failing the whole scan.
+func dagDigests(raw []byte) []string {
+	type dagID struct {
+		Digest string `json:"digest"`
+	}
+	var nodes []struct {
+		ID     dagID   `json:"id"`
+		Inputs []dagID `json:"inputs"`
+	}
+	if err := json.Unmarshal(raw, &nodes); err != nil {
+		return nil
+	}
+	var out []string
+	for _, n := range nodes {
+		if n.ID.Digest != "" {
+			out = append(out, n.ID.Digest)
+		}
+		for _, in := range n.Inputs {
+			if in.Digest != "" {
+				out = append(out, in.Digest)
+			}
+		}
+	}
+	return out
+}
+
 func (p *PostgresStore) Plan(ctx context.Context) ([]PlanNode, error) {
 	if err := p.ensureDB(); err != nil {
 		return nil, err
@@ -1513,13 +2414,65 @@ func (p *PostgresStore) SavePlan(ctx context.Context, runID string, nodes []Plan
 	if err != nil {
 		return 0, err
 	}
+	hash, err := hashPlan(data, dag)
+	if err != nil {
+		return 0, err
+	}
 	var id int64
-	if err := p.db.QueryRowContext(ctx, `INSERT INTO plans (run_id, plan, dag) VALUES ($1, $2, $3) RETURNING id`, runID, data, dag).Scan(&id); err != nil {
+	if err := p.db.QueryRowContext(ctx, `INSERT INTO plans (run_id, plan, dag, plan_hash) VALUES ($1, $2, $3, $4) RETURNING id`, runID, data, dag, hash).Scan(&id); err != nil {
 		return 0, err
 	}
 	return id, nil
 }
 
+// hashPlanJSON hashes the encoded plan nodes. JSONB does not keep key order
+// or whitespace, so checks re-encode the decoded nodes before hashing.
+func hashPlanJSON(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// hashPlan hashes the encoded plan nodes together with the DAG, which holds
+// the wheel keys, actions, and pack inputs workers build from. The DAG is
+// decoded and re-encoded first, which sorts its keys the way JSONB does not.
+// A plan without a DAG hashes like its nodes alone.
+func hashPlan(nodes []byte, dag json.RawMessage) (string, error) {
+	trimmed := bytes.TrimSpace(dag)
+	if len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+		return hashPlanJSON(nodes), nil
+	}
+	var v any
+	if err := json.Unmarshal(trimmed, &v); err != nil {
+		return "", fmt.Errorf("plan dag: %w", err)
+	}
+	canon, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return hashPlanJSON(append(append(append([]byte(nil), nodes...), 0), canon...)), nil
+}
+
+// verifyPlanHash records the stored hash on snap and flags it as tampered
+// when the stored nodes and DAG no longer hash to it. Plans saved before the
+// DAG was hashed match on their nodes alone.
+func verifyPlanHash(snap *PlanSnapshot, stored string) error {
+	snap.Hash = stored
+	if stored == "" {
+		return nil
+	}
+	data, err := json.Marshal(snap.Plan)
+	if err != nil {
+		return err
+	}
+	hash, err := hashPlan(data, snap.DAG)
+	if err != nil {
+		snap.Tampered = true
+		return nil
+	}
+	snap.Tampered = hash != stored && hashPlanJSON(data) != stored
+	return nil
+}
+
 // DeletePlans removes plan snapshots. If planID is 0, all plans are deleted.
 func (p *PostgresStore) DeletePlans(ctx context.Context, planID int64) (int64, error) {
 	if err := p.ensureDB(); err != nil {
@@ -1560,6 +2513,7 @@ func (p *PostgresStore) PlanSnapshot(ctx context.Context, planID int64) (PlanSna
 		       p.run_id,
 		       p.plan,
 		       p.dag,
+		       COALESCE(p.plan_hash, ''),
 		       EXISTS (
 		         SELECT 1 FROM build_status bs
 		         WHERE bs.plan_id = p.id
@@ -1568,7 +2522,8 @@ func (p *PostgresStore) PlanSnapshot(ctx context.Context, planID int64) (PlanSna
 		FROM plans p
 		WHERE p.id = $1
 	`, planID)
-	if err := row.Scan(&snap.ID, &snap.RunID, &planRaw, &dagRaw, &snap.Queued); err != nil {
+	var hash string
+	if err := row.Scan(&snap.ID, &snap.RunID, &planRaw, &dagRaw, &hash, &snap.Queued); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return PlanSnapshot{}, ErrNotFound
 		}
@@ -1580,6 +2535,9 @@ func (p *PostgresStore) PlanSnapshot(ctx context.Context, planID int64) (PlanSna
 	if len(dagRaw) > 0 {
 		snap.DAG = dagRaw
 	}
+	if err := verifyPlanHash(&snap, hash); err != nil {
+		return PlanSnapshot{}, err
+	}
 	return snap, nil
 }
 
@@ -1596,6 +2554,7 @@ func (p *PostgresStore) LatestPlanSnapshot(ctx context.Context) (PlanSnapshot, e
 		       p.run_id,
 		       p.plan,
 		       p.dag,
+		       COALESCE(p.plan_hash, ''),
 		       EXISTS (
 		         SELECT 1 FROM build_status bs
 		         WHERE bs.plan_id = p.id
@@ -1604,7 +2563,8 @@ func (p *PostgresStore) LatestPlanSnapshot(ctx context.Context) (PlanSnapshot, e
 		FROM plans p
 		ORDER BY created_at DESC
 		LIMIT 1`)
-	if err := row.Scan(&snap.ID, &snap.RunID, &planRaw, &dagRaw, &snap.Queued); err != nil {
+	var hash string
+	if err := row.Scan(&snap.ID, &snap.RunID, &planRaw, &dagRaw, &hash, &snap.Queued); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return PlanSnapshot{}, ErrNotFound
 		}
@@ -1616,6 +2576,9 @@ func (p *PostgresStore) LatestPlanSnapshot(ctx context.Context) (PlanSnapshot, e
 	if len(dagRaw) > 0 {
 		snap.DAG = dagRaw
 	}
+	if err := verifyPlanHash(&snap, hash); err != nil {
+		return PlanSnapshot{}, err
+	}
 	return snap, nil
 }
 
@@ -1667,17 +2630,11 @@ func (p *PostgresStore) QueueBuildsFromPlan(ctx context.Context, runID string, p
 	if err := p.ensureDB(); err != nil {
 		return err
 	}
-	tx, err := p.db.BeginTx(ctx, nil)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
 	stmt := `
 		INSERT INTO build_status (package, version, python_tag, platform_tag, status, attempts, run_id, plan_id, backoff_until, last_error, failure_summary, recipes)
 		VALUES ($1,$2,$3,$4,'pending',0,$5,$6,NULL,'',NULL,$7)
-		ON CONFLICT (package, version) DO UPDATE
-		SET python_tag = EXCLUDED.python_tag,
-		    platform_tag = EXCLUDED.platform_tag,
+		ON CONFLICT (package, version, python_tag) DO UPDATE
+		SET platform_tag = EXCLUDED.platform_tag,
 		    run_id = EXCLUDED.run_id,
 		    plan_id = EXCLUDED.plan_id,
 		    status = 'pending',
@@ -1685,24 +2642,110 @@ func (p *PostgresStore) QueueBuildsFromPlan(ctx context.Context, runID string, p
 		    backoff_until = NULL,
 		    last_error = '',
 		    failure_summary = NULL,
+		    failure_category = NULL,
 		    recipes = COALESCE(EXCLUDED.recipes, build_status.recipes)
 	`
-	for _, n := range nodes {
-		if strings.ToLower(n.Action) != "build" || n.Name == "" || n.Version == "" {
-			continue
-		}
-		recipes := planRecipeNames(n.Recipes)
-		var recipesRaw any
-		if recipes != nil {
-			if data, err := json.Marshal(recipes); err == nil {
-				recipesRaw = data
+	return p.withRetryTx(ctx, func(tx *sql.Tx) error {
+		for _, n := range nodes {
+			if !queueableNode(n) {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, stmt, n.Name, n.Version, n.PythonTag, n.PlatformTag, runID, planID, planRecipesJSON(n)); err != nil {
+				return err
 			}
 		}
-		if _, err := tx.ExecContext(ctx, stmt, n.Name, n.Version, n.PythonTag, n.PlatformTag, runID, planID, recipesRaw); err != nil {
-			return err
+		return nil
+	})
+}
+
+// ReconcilePlanBuilds inserts a pending build_status row for every build node
+// of the plan that has none, e.g. after QueueBuildsFromPlan failed part way.
+// Existing rows are left alone whatever their status. It returns the nodes
+// that were inserted.
+func (p *PostgresStore) ReconcilePlanBuilds(ctx context.Context, runID string, planID int64, nodes []PlanNode) ([]PlanNode, error) {
+	if err := p.ensureDB(); err != nil {
+		return nil, err
+	}
+	stmt := `
+		INSERT INTO build_status (package, version, python_tag, platform_tag, status, attempts, run_id, plan_id, backoff_until, last_error, failure_summary, recipes)
+		VALUES ($1,$2,$3,$4,'pending',0,$5,$6,NULL,'',NULL,$7)
+		ON CONFLICT (package, version, python_tag) DO NOTHING
+	`
+	var created []PlanNode
+	err := p.withRetryTx(ctx, func(tx *sql.Tx) error {
+		created = nil
+		for _, n := range nodes {
+			if !queueableNode(n) {
+				continue
+			}
+			res, err := tx.ExecContext(ctx, stmt, n.Name, n.Version, n.PythonTag, n.PlatformTag, runID, planID, planRecipesJSON(n))
+			if err != nil {
+				return err
+			}
+			if count, err := res.RowsAffected(); err == nil && count > 0 {
+				created = append(created, n)
+			}
 		}
+		return nil
+	})
+	return created, err
+}
+
+// queueableNode reports whether a plan node should have a build_status row.
+func queueableNode(n PlanNode) bool {
+	return strings.ToLower(strings.TrimSpace(n.Action)) == "build" && n.Name != "" && n.Version != ""
+}
+
+// planRecipesJSON encodes a node's recipe names for the recipes column, or
+// nil when it has none.
+func planRecipesJSON(n PlanNode) any {
+	recipes := planRecipeNames(n.Recipes)
+	if recipes == nil {
+		return nil
 	}
-	return tx.Commit()
+	data, err := json.Marshal(recipes)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// leaseSingleFlightClause skips packages that already have a build in flight
+// and, within one lease batch, only picks the oldest ready row per package.
+const leaseSingleFlightClause = `
+				  AND package NOT IN (SELECT package FROM build_status WHERE status IN ('leased','building'))
+				  AND NOT EXISTS (
+					SELECT 1 FROM build_status o
+					WHERE o.package = build_status.package
+					  AND o.status IN ('pending','retry')
+					  AND (o.backoff_until IS NULL OR o.backoff_until <= NOW())
+					  AND (o.created_at, o.id) < (build_status.created_at, build_status.id)
+				  )`
+
+// leaseFairCTE picks ready builds in turns across runs: every run's first
+// build, then every run's second, and so on. Within a run and within a turn,
+// builds with fewer attempts lead, then older ones.
+// Postgres cannot lock rows in a query with window functions, so the ranking
+// runs first and the locking select re-checks the status.
+func leaseFairCTE(singleFlight string) string {
+	return `
+			WITH ranked AS (
+				SELECT id,
+				       created_at,
+				       attempts,
+				       ROW_NUMBER() OVER (PARTITION BY COALESCE(run_id, '') ORDER BY attempts, created_at, id) AS turn
+				FROM build_status
+				WHERE status IN ('pending','retry')
+				  AND (backoff_until IS NULL OR backoff_until <= NOW())` + singleFlight + `
+			), cte AS (
+				SELECT b.id
+				FROM build_status b
+				JOIN ranked r ON r.id = b.id
+				WHERE b.status IN ('pending','retry')
+				ORDER BY r.turn ASC, r.attempts ASC, r.created_at ASC, b.id ASC
+				FOR UPDATE OF b SKIP LOCKED
+				LIMIT $1
+			)`
 }
 
 // LeaseBuilds returns ready builds and marks them leased with attempt increment.
@@ -1713,56 +2756,61 @@ func (p *PostgresStore) LeaseBuilds(ctx context.Context, max int) ([]BuildStatus
 	if max <= 0 {
 		max = 1
 	}
-	tx, err := p.db.BeginTx(ctx, nil)
-	if err != nil {
-		return nil, err
+	singleFlight := ""
+	if p.PackageSingleFlight {
+		singleFlight = leaseSingleFlightClause
+	}
+	cte := `
+			WITH cte AS (
+				SELECT id
+				FROM build_status
+				WHERE status IN ('pending','retry')
+				  AND (backoff_until IS NULL OR backoff_until <= NOW())` + singleFlight + `
+				ORDER BY attempts ASC, created_at ASC
+				FOR UPDATE SKIP LOCKED
+				LIMIT $1
+			)`
+	if p.FairLeasing {
+		cte = leaseFairCTE(singleFlight)
 	}
-	defer tx.Rollback()
-	rows, err := tx.QueryContext(ctx, `
-		WITH cte AS (
-			SELECT id
-			FROM build_status
-			WHERE status IN ('pending','retry')
-			  AND (backoff_until IS NULL OR backoff_until <= NOW())
-			ORDER BY created_at ASC
-			FOR UPDATE SKIP LOCKED
-			LIMIT $1
-		)
-		UPDATE build_status b
-		SET status = 'leased',
-		    attempts = b.attempts + 1,
-		    leased_at = NOW(),
-		    started_at = NULL,
-		    finished_at = NULL,
-		    updated_at = NOW()
-		FROM cte
-		WHERE b.id = cte.id
-		RETURNING b.id, b.package, b.version, b.python_tag, b.platform_tag, b.status, b.attempts, COALESCE(b.last_error,''), COALESCE(b.failure_summary,''), b.run_id, b.plan_id, COALESCE(extract(epoch from b.backoff_until),0)::bigint, extract(epoch from b.created_at)::bigint, extract(epoch from b.updated_at)::bigint, COALESCE(extract(epoch from b.leased_at),0)::bigint, COALESCE(extract(epoch from b.started_at),0)::bigint, COALESCE(extract(epoch from b.finished_at),0)::bigint, COALESCE(b.recipes, '[]'::jsonb), COALESCE(b.hint_ids, '{}'::text[])
-	`, max)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
 	var out []BuildStatus
-	for rows.Next() {
-		var bs BuildStatus
-		var recipes json.RawMessage
-		var hints pq.StringArray
-		if err := rows.Scan(&bs.ID, &bs.Package, &bs.Version, &bs.PythonTag, &bs.PlatformTag, &bs.Status, &bs.Attempts, &bs.LastError, &bs.FailureSummary, &bs.RunID, &bs.PlanID, &bs.BackoffUntil, &bs.CreatedAt, &bs.UpdatedAt, &bs.LeasedAt, &bs.StartedAt, &bs.FinishedAt, &recipes, &hints); err != nil {
-			return nil, err
-		}
-		if len(recipes) > 0 {
-			_ = json.Unmarshal(recipes, &bs.Recipes)
+	err := p.withRetryTx(ctx, func(tx *sql.Tx) error {
+		out = nil
+		rows, err := tx.QueryContext(ctx, cte+`
+			UPDATE build_status b
+			SET status = 'leased',
+			    attempts = b.attempts + 1,
+			    cancel_requested = FALSE,
+			    leased_at = NOW(),
+			    started_at = NULL,
+			    finished_at = NULL,
+			    updated_at = NOW()
+			FROM cte
+			WHERE b.id = cte.id
+			RETURNING b.id, b.package, b.version, b.python_tag, b.platform_tag, b.status, b.attempts, COALESCE(b.last_error,''), COALESCE(b.failure_summary,''), b.run_id, b.plan_id, COALESCE(extract(epoch from b.backoff_until),0)::bigint, extract(epoch from b.created_at)::bigint, extract(epoch from b.updated_at)::bigint, COALESCE(extract(epoch from b.leased_at),0)::bigint, COALESCE(extract(epoch from b.started_at),0)::bigint, COALESCE(extract(epoch from b.finished_at),0)::bigint, COALESCE(b.recipes, '[]'::jsonb), COALESCE(b.hint_ids, '{}'::text[])
+		`, max)
+		if err != nil {
+			return err
 		}
-		if len(hints) > 0 {
-			bs.HintIDs = hints
+		defer rows.Close()
+		for rows.Next() {
+			var bs BuildStatus
+			var recipes json.RawMessage
+			var hints pq.StringArray
+			if err := rows.Scan(&bs.ID, &bs.Package, &bs.Version, &bs.PythonTag, &bs.PlatformTag, &bs.Status, &bs.Attempts, &bs.LastError, &bs.FailureSummary, &bs.RunID, &bs.PlanID, &bs.BackoffUntil, &bs.CreatedAt, &bs.UpdatedAt, &bs.LeasedAt, &bs.StartedAt, &bs.FinishedAt, &recipes, &hints); err != nil {
+				return err
+			}
+			if len(recipes) > 0 {
+				_ = json.Unmarshal(recipes, &bs.Recipes)
+			}
+			if len(hints) > 0 {
+				bs.HintIDs = hints
+			}
+			out = append(out, bs)
 		}
-		out = append(out, bs)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	if err := tx.Commit(); err != nil {
+		return rows.Err()
+	})
+	if err != nil {
 		return nil, err
 	}
 	return out, nil
@@ -1784,6 +2832,7 @@ func (p *PostgresStore) RequeueStaleLeases(ctx context.Context, maxAgeSec int) (
 		        ELSE last_error
 		    END,
 		    failure_summary = NULL,
+		    failure_category = NULL,
 		    backoff_until = NULL,
 		    leased_at = NULL,
 		    started_at = NULL,
@@ -1809,9 +2858,9 @@ func (p *PostgresStore) UpsertWorkerStatus(ctx context.Context, status WorkerSta
 	}
 	_, err := p.db.ExecContext(ctx, `
 		INSERT INTO worker_status (
-			worker_id, run_id, last_seen, active_builds, build_pool_size, plan_pool_size, heartbeat_interval_sec, updated_at
+			worker_id, run_id, last_seen, active_builds, build_pool_size, plan_pool_size, heartbeat_interval_sec, smoke, updated_at
 		)
-		VALUES ($1,$2,NOW(),$3,$4,$5,$6,NOW())
+		VALUES ($1,$2,NOW(),$3,$4,$5,$6,$7,NOW())
 		ON CONFLICT (worker_id) DO UPDATE
 		SET run_id = EXCLUDED.run_id,
 		    last_seen = NOW(),
@@ -1819,8 +2868,9 @@ func (p *PostgresStore) UpsertWorkerStatus(ctx context.Context, status WorkerSta
 		    build_pool_size = EXCLUDED.build_pool_size,
 		    plan_pool_size = EXCLUDED.plan_pool_size,
 		    heartbeat_interval_sec = EXCLUDED.heartbeat_interval_sec,
+		    smoke = COALESCE(EXCLUDED.smoke, worker_status.smoke),
 		    updated_at = NOW()
-	`, status.WorkerID, nullableString(status.RunID), status.ActiveBuilds, status.BuildPoolSize, status.PlanPoolSize, status.HeartbeatIntervalSec)
+	`, status.WorkerID, nullableString(status.RunID), status.ActiveBuilds, status.BuildPoolSize, status.PlanPoolSize, status.HeartbeatIntervalSec, nullableJSON(status.Smoke))
 	return err
 }
 
@@ -1836,6 +2886,7 @@ func (p *PostgresStore) ListWorkers(ctx context.Context) ([]WorkerStatus, error)
 		       build_pool_size,
 		       plan_pool_size,
 		       heartbeat_interval_sec,
+		       smoke,
 		       EXTRACT(EPOCH FROM last_seen)::bigint AS last_seen,
 		       EXTRACT(EPOCH FROM created_at)::bigint AS created_at,
 		       EXTRACT(EPOCH FROM updated_at)::bigint AS updated_at
@@ -1850,9 +2901,13 @@ func (p *PostgresStore) ListWorkers(ctx context.Context) ([]WorkerStatus, error)
 	for rows.Next() {
 		var ws WorkerStatus
 		var runID sql.NullString
-		if err := rows.Scan(&ws.WorkerID, &runID, &ws.ActiveBuilds, &ws.BuildPoolSize, &ws.PlanPoolSize, &ws.HeartbeatIntervalSec, &ws.LastSeen, &ws.CreatedAt, &ws.UpdatedAt); err != nil {
+		var smoke []byte
+		if err := rows.Scan(&ws.WorkerID, &runID, &ws.ActiveBuilds, &ws.BuildPoolSize, &ws.PlanPoolSize, &ws.HeartbeatIntervalSec, &smoke, &ws.LastSeen, &ws.CreatedAt, &ws.UpdatedAt); err != nil {
 			return nil, err
 		}
+		if len(smoke) > 0 {
+			ws.Smoke = smoke
+		}
 		if runID.Valid {
 			ws.RunID = runID.String
 		}
@@ -1864,6 +2919,55 @@ func (p *PostgresStore) ListWorkers(ctx context.Context) ([]WorkerStatus, error)
 	return out, nil
 }
 
+const (
+	txRetryAttempts = 3
+	txRetryBackoff  = 25 * time.Millisecond
+)
+
+// withRetryTx runs fn in a transaction and retries it when Postgres aborts the
+// transaction with a serialization failure or deadlock, which happens when many
+// workers lease at once. Any other error is returned as-is.
+func (p *PostgresStore) withRetryTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	var err error
+	for attempt := 1; attempt <= txRetryAttempts; attempt++ {
+		err = p.runTx(ctx, fn)
+		if err == nil || !isRetryableTxError(err) {
+			return err
+		}
+		if attempt == txRetryAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * txRetryBackoff):
+		}
+	}
+	return err
+}
+
+func (p *PostgresStore) runTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// isRetryableTxError reports whether err is a serialization_failure (40001) or
+// deadlock_detected (40P01) error.
+func isRetryableTxError(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == "40001" || pqErr.Code == "40P01"
+}
+
 // Helpers for pq string arrays without importing driver types in interface.
 type pqStringArrayParam []string
 
@@ -1881,6 +2985,13 @@ func nullableString(val string) any {
 	return val
 }
 
+func nullableJSON(val json.RawMessage) any {
+	if len(val) == 0 {
+		return nil
+	}
+	return []byte(val)
+}
+
 func nullableInt(val int) any {
 	if val == 0 {
 		return nil
@@ -0,0 +1,1359 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func leaseRow(id int64, pkg, version string) []driver.Value {
+	return []driver.Value{id, pkg, version, "cp311", "manylinux2014_s390x", "leased", int64(1), "", "", "run1", int64(1), int64(0), int64(100), int64(100), int64(100), int64(0), int64(0), []byte("[]"), []byte("{}")}
+}
+
+var leaseCols = []string{"id", "package", "version", "python_tag", "platform_tag", "status", "attempts", "last_error", "failure_summary", "run_id", "plan_id", "backoff_until", "created_at", "updated_at", "leased_at", "started_at", "finished_at", "recipes", "hint_ids"}
+
+func TestLeaseBuildsRetriesSerializationFailure(t *testing.T) {
+	calls := 0
+	db := &fakeDB{
+		query: func(query string, args []driver.NamedValue) (driver.Rows, error) {
+			calls++
+			if calls == 1 {
+				return nil, &pq.Error{Code: "40001", Message: "could not serialize access"}
+			}
+			return &fakeRows{cols: leaseCols, data: [][]driver.Value{leaseRow(7, "numpy", "1.26.0")}}, nil
+		},
+	}
+	st := newFakeStore(db)
+	builds, err := st.LeaseBuilds(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("lease: %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 attempts, got %d", calls)
+	}
+	if len(builds) != 1 || builds[0].Package != "numpy" || builds[0].ID != 7 {
+		t.Fatalf("unexpected builds: %+v", builds)
+	}
+	if db.begins != 2 || db.commits != 1 {
+		t.Fatalf("expected 2 begins and 1 commit, got begins=%d commits=%d", db.begins, db.commits)
+	}
+}
+
+func TestQueueBuildsFromPlanRetriesDeadlock(t *testing.T) {
+	calls := 0
+	db := &fakeDB{
+		exec: func(query string, args []driver.NamedValue) (driver.Result, error) {
+			calls++
+			if calls == 1 {
+				return nil, &pq.Error{Code: "40P01", Message: "deadlock detected"}
+			}
+			return driver.RowsAffected(1), nil
+		},
+	}
+	st := newFakeStore(db)
+	nodes := []PlanNode{{Name: "numpy", Version: "1.26.0", Action: "build"}, {Name: "six", Version: "1.16.0", Action: "reuse"}}
+	if err := st.QueueBuildsFromPlan(context.Background(), "run1", 1, nodes); err != nil {
+		t.Fatalf("queue: %v", err)
+	}
+	if calls != 2 || db.commits != 1 {
+		t.Fatalf("expected retry then commit, got calls=%d commits=%d", calls, db.commits)
+	}
+}
+
+// upsertTarget matches a build_status insert's column list and its
+// ON CONFLICT target.
+var upsertTarget = regexp.MustCompile(`INSERT INTO build_status \(([^)]*)\)[\s\S]*ON CONFLICT \(([^)]*)\)`)
+
+func TestQueueMatrixPlanLeasesOneBuildPerPythonTag(t *testing.T) {
+	// build_status rows keyed by the statement's own conflict target, so a
+	// target without python_tag collapses the matrix into one row.
+	rows := map[string][]driver.Value{}
+	var order []string
+	db := &fakeDB{
+		exec: func(query string, args []driver.NamedValue) (driver.Result, error) {
+			m := upsertTarget.FindStringSubmatch(query)
+			if m == nil {
+				t.Fatalf("unexpected exec %q", query)
+			}
+			cols := strings.Split(m[1], ",")
+			var key []string
+			for _, target := range strings.Split(m[2], ",") {
+				for i, c := range cols {
+					if strings.TrimSpace(c) == strings.TrimSpace(target) {
+						key = append(key, args[i].Value.(string))
+					}
+				}
+			}
+			k := strings.Join(key, "|")
+			if _, ok := rows[k]; !ok {
+				order = append(order, k)
+			}
+			row := leaseRow(int64(len(order)), args[0].Value.(string), args[1].Value.(string))
+			row[3] = args[2].Value.(string)
+			rows[k] = row
+			return driver.RowsAffected(1), nil
+		},
+		query: func(query string, args []driver.NamedValue) (driver.Rows, error) {
+			var out [][]driver.Value
+			for _, k := range order {
+				out = append(out, rows[k])
+			}
+			return &fakeRows{cols: leaseCols, data: out}, nil
+		},
+	}
+	st := newFakeStore(db)
+	nodes := []PlanNode{
+		{Name: "demo", Version: "1.0", PythonTag: "cp310", PlatformTag: "manylinux2014_s390x", Action: "build"},
+		{Name: "demo", Version: "1.0", PythonTag: "cp311", PlatformTag: "manylinux2014_s390x", Action: "build"},
+	}
+	if err := st.QueueBuildsFromPlan(context.Background(), "run1", 1, nodes); err != nil {
+		t.Fatalf("queue: %v", err)
+	}
+	builds, err := st.LeaseBuilds(context.Background(), 4)
+	if err != nil {
+		t.Fatalf("lease: %v", err)
+	}
+	if len(builds) != 2 || builds[0].PythonTag != "cp310" || builds[1].PythonTag != "cp311" {
+		t.Fatalf("expected one leased build per python tag, got %+v", builds)
+	}
+}
+
+func TestRunMigrationsKeysBuildStatusByPythonTag(t *testing.T) {
+	var stmts []string
+	db := &fakeDB{
+		exec: func(query string, args []driver.NamedValue) (driver.Result, error) {
+			stmts = append(stmts, strings.Join(strings.Fields(query), " "))
+			return driver.RowsAffected(0), nil
+		},
+	}
+	if err := RunMigrations(context.Background(), sql.OpenDB(db)); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	all := strings.Join(stmts, "\n")
+	for _, want := range []string{
+		"ALTER TABLE build_status ALTER COLUMN python_tag SET NOT NULL;",
+		"DROP INDEX IF EXISTS idx_build_status_pkg_version_unique;",
+		"AND a.python_tag = b.python_tag",
+		"DROP INDEX IF EXISTS idx_build_status_pkg_version",
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_build_status_pkg_version_tag ON build_status(package, version, python_tag)",
+	} {
+		if !strings.Contains(all, want) {
+			t.Fatalf("migrations missing %q", want)
+		}
+	}
+	if strings.Contains(all, "UNIQUE INDEX IF NOT EXISTS idx_build_status_pkg_version ") || strings.Contains(all, "idx_build_status_pkg_version_unique ON") {
+		t.Fatalf("migrations still create a (package, version) unique index")
+	}
+}
+
+func TestWithRetryTxDoesNotRetryOtherErrors(t *testing.T) {
+	db := &fakeDB{}
+	st := newFakeStore(db)
+	calls := 0
+	err := st.withRetryTx(context.Background(), func(tx *sql.Tx) error {
+		calls++
+		return &pq.Error{Code: "23505", Message: "unique_violation"}
+	})
+	if err == nil || !strings.Contains(err.Error(), "unique_violation") {
+		t.Fatalf("expected unique violation error, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected a single attempt, got %d", calls)
+	}
+}
+
+func TestIsRetryableTxError(t *testing.T) {
+	if !isRetryableTxError(&pq.Error{Code: "40001"}) || !isRetryableTxError(&pq.Error{Code: "40P01"}) {
+		t.Fatalf("expected serialization and deadlock codes to be retryable")
+	}
+	if isRetryableTxError(errors.New("boom")) || isRetryableTxError(nil) {
+		t.Fatalf("expected plain errors to be permanent")
+	}
+}
+
+func TestLeaseBuildsDemotesRepeatedFailures(t *testing.T) {
+	query, args := leaseQuery(t, nil)
+	want := "AND (backoff_until IS NULL OR backoff_until <= NOW()) ORDER BY attempts ASC, created_at ASC FOR UPDATE SKIP LOCKED LIMIT $1 )"
+	if !strings.Contains(query, want) {
+		t.Fatalf("expected builds ordered by attempts before age, got %s", query)
+	}
+	if len(args) != 1 {
+		t.Fatalf("expected only the lease limit as an argument, got %+v", args)
+	}
+
+	query, _ = leaseQuery(t, func(st *PostgresStore) { st.FairLeasing = true })
+	want = "ROW_NUMBER() OVER (PARTITION BY COALESCE(run_id, '') ORDER BY attempts, created_at, id) AS turn"
+	if !strings.Contains(query, want) {
+		t.Fatalf("expected run turns ordered by attempts before age, got %s", query)
+	}
+}
+
+// leaseQuery runs LeaseBuilds against a fake DB configured by setup and
+// returns the issued query with whitespace collapsed, plus its arguments.
+func leaseQuery(t *testing.T, setup func(*PostgresStore)) (string, []driver.NamedValue) {
+	t.Helper()
+	var query string
+	var args []driver.NamedValue
+	db := &fakeDB{
+		query: func(q string, a []driver.NamedValue) (driver.Rows, error) {
+			query, args = q, a
+			return &fakeRows{cols: leaseCols}, nil
+		},
+	}
+	st := newFakeStore(db)
+	if setup != nil {
+		setup(st)
+	}
+	if _, err := st.LeaseBuilds(context.Background(), 2); err != nil {
+		t.Fatalf("lease: %v", err)
+	}
+	return strings.Join(strings.Fields(query), " "), args
+}
+
+func TestLeaseBuildsPackageSingleFlight(t *testing.T) {
+	query, _ := leaseQuery(t, nil)
+	if strings.Contains(query, "NOT IN") || strings.Contains(query, "NOT EXISTS") {
+		t.Fatalf("expected no single-flight predicate by default, got %s", query)
+	}
+
+	query, _ = leaseQuery(t, func(st *PostgresStore) { st.PackageSingleFlight = true })
+	want := "WHERE status IN ('pending','retry') AND (backoff_until IS NULL OR backoff_until <= NOW())" +
+		" AND package NOT IN (SELECT package FROM build_status WHERE status IN ('leased','building'))" +
+		" AND NOT EXISTS ( SELECT 1 FROM build_status o" +
+		" WHERE o.package = build_status.package" +
+		" AND o.status IN ('pending','retry')" +
+		" AND (o.backoff_until IS NULL OR o.backoff_until <= NOW())" +
+		" AND (o.created_at, o.id) < (build_status.created_at, build_status.id) )" +
+		" ORDER BY"
+	if !strings.Contains(query, want) {
+		t.Fatalf("expected the single-flight predicate in the lease selection, got %s", query)
+	}
+}
+
+func TestLeaseBuildsRotatesAcrossRuns(t *testing.T) {
+	query, _ := leaseQuery(t, nil)
+	if strings.Contains(query, "ROW_NUMBER()") || strings.Contains(query, "PARTITION BY") {
+		t.Fatalf("expected FIFO leasing without run turns by default, got %s", query)
+	}
+
+	query, args := leaseQuery(t, func(st *PostgresStore) { st.FairLeasing = true })
+	for _, want := range []string{
+		"ROW_NUMBER() OVER (PARTITION BY COALESCE(run_id, '') ORDER BY attempts, created_at, id) AS turn",
+		"WHERE b.status IN ('pending','retry') ORDER BY r.turn ASC, r.attempts ASC, r.created_at ASC, b.id ASC FOR UPDATE OF b SKIP LOCKED LIMIT $1 )",
+	} {
+		if !strings.Contains(query, want) {
+			t.Fatalf("expected %q in the fair lease query, got %s", want, query)
+		}
+	}
+	if len(args) != 1 || args[0].Value != int64(2) {
+		t.Fatalf("expected the lease limit as $1, got %+v", args)
+	}
+}
+
+func TestRecordEventsSingleTransaction(t *testing.T) {
+	var stmts []string
+	var argCount int
+	db := &fakeDB{
+		exec: func(query string, args []driver.NamedValue) (driver.Result, error) {
+			stmts = append(stmts, query)
+			argCount += len(args)
+			return driver.RowsAffected(int64(len(args) / 11)), nil
+		},
+	}
+	st := newFakeStore(db)
+	events := make([]Event, 100)
+	for i := range events {
+		events[i] = Event{Name: fmt.Sprintf("pkg%d", i), Version: "1.0", Status: "built", Timestamp: int64(1700000000 + i)}
+	}
+	inserted, err := st.RecordEvents(context.Background(), events)
+	if err != nil {
+		t.Fatalf("record events: %v", err)
+	}
+	if inserted != 100 {
+		t.Fatalf("expected 100 inserted, got %d", inserted)
+	}
+	if len(stmts) != 1 {
+		t.Fatalf("expected a single multi-row insert, got %d statements", len(stmts))
+	}
+	if argCount != 1100 {
+		t.Fatalf("expected 1100 bound args, got %d", argCount)
+	}
+	if !strings.Contains(stmts[0], "TO_TIMESTAMP($1099),$1100)") {
+		t.Fatalf("expected 100 value tuples, got %q", stmts[0][len(stmts[0])-40:])
+	}
+	if db.begins != 1 || db.commits != 1 {
+		t.Fatalf("expected one transaction, got begins=%d commits=%d", db.begins, db.commits)
+	}
+}
+
+func TestRecordEventIgnoresDuplicates(t *testing.T) {
+	rows := map[string]bool{}
+	db := &fakeDB{
+		exec: func(query string, args []driver.NamedValue) (driver.Result, error) {
+			if !strings.Contains(query, "ON CONFLICT (event_id) DO NOTHING") {
+				t.Fatalf("expected conflict clause, got %q", query)
+			}
+			key := args[len(args)-1].Value.(string)
+			if rows[key] {
+				return driver.RowsAffected(0), nil
+			}
+			rows[key] = true
+			return driver.RowsAffected(1), nil
+		},
+	}
+	st := newFakeStore(db)
+	evt := Event{RunID: "run1", Name: "numpy", Version: "1.26.0", Status: "built", Timestamp: 1700000000}
+	created, err := st.RecordEvent(context.Background(), evt)
+	if err != nil || !created {
+		t.Fatalf("expected first insert to be new, created=%v err=%v", created, err)
+	}
+	evt.Detail = "retried post"
+	created, err = st.RecordEvent(context.Background(), evt)
+	if err != nil || created {
+		t.Fatalf("expected duplicate to be ignored, created=%v err=%v", created, err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("expected one stored event, got %d", len(rows))
+	}
+	if EventKey(Event{EventID: "abc"}) != "abc" {
+		t.Fatalf("expected explicit event_id to be used as key")
+	}
+	cp310 := Event{Name: "numpy", Version: "1.26.0", PythonTag: "cp310", PlatformTag: "manylinux2014_s390x", Status: "built", Timestamp: 1700000000}
+	cp311 := cp310
+	cp311.PythonTag = "cp311"
+	if EventKey(cp310) == EventKey(cp311) {
+		t.Fatalf("expected matrix build events for different python tags to keep distinct keys")
+	}
+}
+
+func TestRecordEventBumpsHintUsage(t *testing.T) {
+	rows := map[string]bool{}
+	type bump struct {
+		id    string
+		count int64
+		ts    int64
+	}
+	var bumps []bump
+	db := &fakeDB{
+		exec: func(query string, args []driver.NamedValue) (driver.Result, error) {
+			if strings.HasPrefix(query, "UPDATE hints SET match_count") {
+				bumps = append(bumps, bump{args[0].Value.(string), args[1].Value.(int64), args[2].Value.(int64)})
+				return driver.RowsAffected(1), nil
+			}
+			key := args[len(args)-1].Value.(string)
+			if rows[key] {
+				return driver.RowsAffected(0), nil
+			}
+			rows[key] = true
+			return driver.RowsAffected(1), nil
+		},
+	}
+	st := newFakeStore(db)
+	evt := Event{RunID: "run1", Name: "numpy", Version: "1.26.0", Status: "failed", Timestamp: 1700000000, MatchedHintIDs: []string{"openblas", "gcc", "openblas"}}
+	if created, err := st.RecordEvent(context.Background(), evt); err != nil || !created {
+		t.Fatalf("record event: created=%v err=%v", created, err)
+	}
+	want := []bump{{"gcc", 1, 1700000000}, {"openblas", 1, 1700000000}}
+	if !reflect.DeepEqual(bumps, want) {
+		t.Fatalf("expected %+v, got %+v", want, bumps)
+	}
+	if db.begins != 1 || db.commits != 1 {
+		t.Fatalf("expected insert and bump in one transaction, got begins=%d commits=%d", db.begins, db.commits)
+	}
+	if created, err := st.RecordEvent(context.Background(), evt); err != nil || created {
+		t.Fatalf("expected duplicate to be ignored, created=%v err=%v", created, err)
+	}
+	if len(bumps) != 2 {
+		t.Fatalf("duplicate event must not bump usage, got %+v", bumps)
+	}
+}
+
+func TestReferencedDigestsCollectsPlanDAGAndManifests(t *testing.T) {
+	dag := `[
+		{"id":{"type":"runtime","digest":"sha256:runtime"},"type":"runtime"},
+		{"id":{"type":"wheel","digest":"sha256:wheel"},"type":"wheel","inputs":[{"type":"runtime","digest":"sha256:runtime"},{"type":"pack","digest":"sha256:pack"}]}
+	]`
+	db := &fakeDB{
+		query: func(query string, args []driver.NamedValue) (driver.Rows, error) {
+			switch {
+			case strings.Contains(query, "FROM plans"):
+				return &fakeRows{cols: []string{"dag"}, data: [][]driver.Value{{[]byte(dag)}, {[]byte("not json")}}}, nil
+			case strings.Contains(query, "FROM manifests"):
+				return &fakeRows{cols: []string{"d"}, data: [][]driver.Value{{"sha256:repair"}, {"sha256:wheel"}}}, nil
+			}
+			t.Fatalf("unexpected query %q", query)
+			return nil, nil
+		},
+	}
+	got, err := newFakeStore(db).ReferencedDigests(context.Background())
+	if err != nil {
+		t.Fatalf("referenced digests: %v", err)
+	}
+	want := []string{"sha256:pack", "sha256:repair", "sha256:runtime", "sha256:wheel"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestReferencedBuildsListsManifestsWithBuildFiles(t *testing.T) {
+	var query string
+	db := &fakeDB{
+		query: func(q string, args []driver.NamedValue) (driver.Rows, error) {
+			query = strings.Join(strings.Fields(q), " ")
+			return &fakeRows{
+				cols: []string{"name", "version", "python_tag", "platform_tag"},
+				data: [][]driver.Value{{"numpy", "1.26.0", "cp311", "manylinux2014_s390x"}},
+			}, nil
+		},
+	}
+	got, err := newFakeStore(db).ReferencedBuilds(context.Background())
+	if err != nil {
+		t.Fatalf("referenced builds: %v", err)
+	}
+	want := []ManifestKey{{Name: "numpy", Version: "1.26.0", PythonTag: "cp311", PlatformTag: "manylinux2014_s390x"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if !strings.Contains(query, "FROM manifests WHERE COALESCE(sbom_url,'') <> '' OR COALESCE(provenance_url,'') <> ''") {
+		t.Fatalf("builds must come from manifests that link an SBOM or provenance: %s", query)
+	}
+}
+
+func TestManifestRoundTripsSBOMURL(t *testing.T) {
+	var saved []driver.NamedValue
+	db := &fakeDB{
+		exec: func(query string, args []driver.NamedValue) (driver.Result, error) {
+			if strings.Contains(query, "INSERT INTO manifests") {
+				saved = args
+			}
+			return driver.RowsAffected(1), nil
+		},
+		query: func(query string, args []driver.NamedValue) (driver.Rows, error) {
+			return &fakeRows{
+				cols: []string{"name", "version", "wheel", "wheel_url", "repair_url", "repair_digest", "runtime_url", "pack_urls", "sbom_url", "python_tag", "platform_tag", "status", "created_at",
+					"provenance_url", "plan_id", "run_id", "builder_id", "wheel_source_digest", "repair_tool_version", "repair_policy_hash"},
+				data: [][]driver.Value{{"pkg", "1.0", "w", "", "", "", "", []byte("{}"), "http://minio/pkg/1.0/sbom.cdx.json", "cp311", "manylinux2014_s390x", "built", int64(100),
+					"http://minio/pkg/1.0/provenance.json", int64(42), "run-7", "worker-1", "sha256:src", "auditwheel-6", "policy"}},
+			}, nil
+		},
+	}
+	st := newFakeStore(db)
+	if err := st.SaveManifest(context.Background(), []ManifestEntry{{Name: "pkg", Version: "1.0", SBOMURL: "http://minio/pkg/1.0/sbom.cdx.json"}}); err != nil {
+		t.Fatalf("save manifest: %v", err)
+	}
+	if len(saved) != 20 || saved[8].Value != "http://minio/pkg/1.0/sbom.cdx.json" {
+		t.Fatalf("sbom_url not saved: %+v", saved)
+	}
+	got, err := st.Manifest(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("manifest: %v", err)
+	}
+	if len(got) != 1 || got[0].SBOMURL != "http://minio/pkg/1.0/sbom.cdx.json" {
+		t.Fatalf("sbom_url not surfaced: %+v", got)
+	}
+	if got[0].ProvenanceURL != "http://minio/pkg/1.0/provenance.json" || got[0].PlanID != 42 || got[0].WheelSourceDigest != "sha256:src" {
+		t.Fatalf("provenance not surfaced: %+v", got[0])
+	}
+}
+
+func TestPurgePendingInputsRespectsCutoff(t *testing.T) {
+	now := time.Now()
+	rows := []struct {
+		key       string
+		deletedAt time.Time
+	}{
+		{"inputs/old/req.txt", now.Add(-48 * time.Hour)},
+		{"inputs/recent/req.txt", now.Add(-time.Hour)},
+	}
+	var unlinked bool
+	db := &fakeDB{
+		exec: func(query string, args []driver.NamedValue) (driver.Result, error) {
+			if strings.Contains(query, "UPDATE plan_metadata SET pending_input = NULL") {
+				unlinked = true
+			}
+			return driver.RowsAffected(0), nil
+		},
+		query: func(query string, args []driver.NamedValue) (driver.Rows, error) {
+			if !strings.Contains(query, "DELETE FROM pending_inputs") || !strings.Contains(query, "deleted_at IS NOT NULL AND deleted_at < $1") {
+				t.Fatalf("unexpected query: %s", query)
+			}
+			cutoff := args[0].Value.(time.Time)
+			out := &fakeRows{cols: []string{"object_key"}}
+			for _, r := range rows {
+				if r.deletedAt.Before(cutoff) {
+					out.data = append(out.data, []driver.Value{r.key})
+				}
+			}
+			return out, nil
+		},
+	}
+	st := newFakeStore(db)
+	keys, err := st.PurgePendingInputs(context.Background(), 24*3600)
+	if err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if len(keys) != 1 || keys[0] != "inputs/old/req.txt" {
+		t.Fatalf("expected only the old input purged, got %+v", keys)
+	}
+	if !unlinked || db.commits != 1 {
+		t.Fatalf("expected plan links cleared in one transaction (unlinked=%v commits=%d)", unlinked, db.commits)
+	}
+}
+
+func TestPurgePendingInputsKeepsSharedBlobs(t *testing.T) {
+	var query string
+	db := &fakeDB{
+		query: func(q string, args []driver.NamedValue) (driver.Rows, error) {
+			query = strings.Join(strings.Fields(q), " ")
+			// Two rows share inputs/abc/req.txt; only the purged one is gone,
+			// so the database returns no key for the live re-upload.
+			return &fakeRows{cols: []string{"object_key"}}, nil
+		},
+	}
+	st := newFakeStore(db)
+	keys, err := st.PurgePendingInputs(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if len(keys) != 0 {
+		t.Fatalf("expected no blobs to delete, got %+v", keys)
+	}
+	want := "RETURNING id, COALESCE(object_key,'') AS object_key ) SELECT DISTINCT object_key FROM purged WHERE object_key <> ''" +
+		" AND NOT EXISTS ( SELECT 1 FROM pending_inputs p2 WHERE p2.object_key = purged.object_key AND p2.id NOT IN (SELECT id FROM purged) )"
+	if !strings.Contains(query, want) {
+		t.Fatalf("expected purge to skip keys other inputs still use, got %s", query)
+	}
+}
+
+func TestTransitionBuildsResetsAttempts(t *testing.T) {
+	type row struct {
+		pkg      string
+		status   string
+		attempts int64
+	}
+	rows := []*row{
+		{"a", "building", 2},
+		{"b", "building", 1},
+		{"c", "building", 3},
+		{"d", "built", 1},
+	}
+	db := &fakeDB{
+		exec: func(query string, args []driver.NamedValue) (driver.Result, error) {
+			if !strings.Contains(query, "UPDATE build_status") || !strings.Contains(query, "WHERE status = $2") {
+				t.Fatalf("unexpected query: %s", query)
+			}
+			to, from, reset := args[0].Value.(string), args[1].Value.(string), args[2].Value.(bool)
+			var n int64
+			for _, r := range rows {
+				if r.status != from {
+					continue
+				}
+				r.status = to
+				if reset {
+					r.attempts = 0
+				}
+				n++
+			}
+			return driver.RowsAffected(n), nil
+		},
+	}
+	st := newFakeStore(db)
+	count, err := st.TransitionBuilds(context.Background(), "building", "retry", true)
+	if err != nil {
+		t.Fatalf("transition: %v", err)
+	}
+	if count != 3 {
+		t.Fatalf("expected 3 transitioned, got %d", count)
+	}
+	for _, r := range rows[:3] {
+		if r.status != "retry" || r.attempts != 0 {
+			t.Fatalf("row %s not reset: %+v", r.pkg, r)
+		}
+	}
+	if rows[3].status != "built" || rows[3].attempts != 1 {
+		t.Fatalf("built row should be untouched: %+v", rows[3])
+	}
+}
+
+func TestApplyRecipesFlowToLeasedBuild(t *testing.T) {
+	var status string
+	var recipes, hints driver.Value
+	db := &fakeDB{
+		exec: func(query string, args []driver.NamedValue) (driver.Result, error) {
+			if !strings.Contains(query, "recipes = COALESCE(EXCLUDED.recipes, build_status.recipes)") {
+				t.Fatalf("unexpected exec %q", query)
+			}
+			status, recipes, hints = args[2].Value.(string), args[7].Value, args[8].Value
+			return driver.RowsAffected(1), nil
+		},
+		query: func(query string, args []driver.NamedValue) (driver.Rows, error) {
+			row := []driver.Value{int64(1), "numpy", "1.26.4", "cp311", "manylinux2014_s390x", status, int64(0), "", "", "", int64(0), int64(0), int64(0), int64(0), int64(0), int64(0), int64(0), int64(0), recipes, hints, []byte("[]"), ""}
+			return &fakeRows{cols: make([]string, len(row)), data: [][]driver.Value{row}}, nil
+		},
+	}
+	st := newFakeStore(db)
+	ctx := context.Background()
+	if err := st.UpdateBuildStatus(ctx, "numpy", "1.26.4", "", "pending", "", "", "", 0, 0, []string{"dnf:openblas-devel"}, []string{"openblas"}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	builds, err := st.ListBuilds(ctx, "", 1, 0, "numpy", "1.26.4")
+	if err != nil || len(builds) != 1 {
+		t.Fatalf("list builds: %v %v", builds, err)
+	}
+	b := builds[0]
+	if b.Status != "pending" || !reflect.DeepEqual(b.Recipes, []string{"dnf:openblas-devel"}) || !reflect.DeepEqual(b.HintIDs, []string{"openblas"}) {
+		t.Fatalf("recipes not stored for the next lease: %+v", b)
+	}
+}
+
+func TestReconcilePlanBuildsInsertsMissingRows(t *testing.T) {
+	// numpy made it into build_status before QueueBuildsFromPlan failed.
+	rows := map[string]int64{"numpy==1.26.4": 7}
+	db := &fakeDB{
+		exec: func(query string, args []driver.NamedValue) (driver.Result, error) {
+			if !strings.Contains(query, "ON CONFLICT (package, version, python_tag) DO NOTHING") || !strings.Contains(query, "'pending'") {
+				t.Fatalf("unexpected exec %q", query)
+			}
+			key := args[0].Value.(string) + "==" + args[1].Value.(string)
+			if _, ok := rows[key]; ok {
+				return driver.RowsAffected(0), nil
+			}
+			rows[key] = args[5].Value.(int64)
+			return driver.RowsAffected(1), nil
+		},
+	}
+	st := newFakeStore(db)
+	nodes := []PlanNode{
+		{Name: "numpy", Version: "1.26.4", Action: "build"},
+		{Name: "scipy", Version: "1.13.0", Action: "build"},
+		{Name: "six", Version: "1.16.0", Action: "reuse"},
+		{Name: "pandas", Version: "2.2.2", Action: "build"},
+	}
+	created, err := st.ReconcilePlanBuilds(context.Background(), "run-1", 9, nodes)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if len(created) != 2 || created[0].Name != "scipy" || created[1].Name != "pandas" {
+		t.Fatalf("expected scipy and pandas to be created, got %+v", created)
+	}
+	if rows["numpy==1.26.4"] != 7 || rows["scipy==1.13.0"] != 9 || rows["pandas==2.2.2"] != 9 {
+		t.Fatalf("unexpected build_status rows %v", rows)
+	}
+	if _, ok := rows["six==1.16.0"]; ok {
+		t.Fatalf("reuse node must not get a build row")
+	}
+	created, err = st.ReconcilePlanBuilds(context.Background(), "run-1", 9, nodes)
+	if err != nil || len(created) != 0 {
+		t.Fatalf("second reconcile should be a no-op, got %+v %v", created, err)
+	}
+}
+
+func TestLatestPlanForPendingInputReturnsNewest(t *testing.T) {
+	type link struct {
+		pendingID, planID, createdAt int64
+	}
+	var links []link
+	created := int64(100)
+	db := &fakeDB{
+		exec: func(query string, args []driver.NamedValue) (driver.Result, error) {
+			if strings.Contains(query, "INSERT INTO plan_metadata") {
+				created += 10
+				links = append(links, link{args[0].Value.(int64), args[1].Value.(int64), created})
+			}
+			return driver.RowsAffected(1), nil
+		},
+		query: func(query string, args []driver.NamedValue) (driver.Rows, error) {
+			if !strings.Contains(query, "WHERE pm.pending_input = $1") || !strings.Contains(query, "ORDER BY p.created_at DESC") {
+				t.Fatalf("unexpected query: %s", query)
+			}
+			var best *link
+			for i := range links {
+				l := &links[i]
+				if l.pendingID == args[0].Value.(int64) && (best == nil || l.createdAt > best.createdAt) {
+					best = l
+				}
+			}
+			out := &fakeRows{cols: []string{"id", "run_id", "plan", "dag", "plan_hash", "queued"}}
+			if best != nil {
+				plan := fmt.Sprintf(`[{"name":"pkg","version":"1.%d","action":"build"}]`, best.planID)
+				out.data = append(out.data, []driver.Value{best.planID, fmt.Sprintf("run-%d", best.planID), []byte(plan), nil, "", false})
+			}
+			return out, nil
+		},
+	}
+	st := newFakeStore(db)
+	ctx := context.Background()
+	for _, planID := range []int64{11, 12} {
+		if err := st.LinkPlanToPendingInput(ctx, 5, planID); err != nil {
+			t.Fatalf("link: %v", err)
+		}
+	}
+	snap, err := st.LatestPlanForPendingInput(ctx, 5)
+	if err != nil {
+		t.Fatalf("latest: %v", err)
+	}
+	if snap.ID != 12 || snap.RunID != "run-12" || len(snap.Plan) != 1 || snap.Plan[0].Version != "1.12" {
+		t.Fatalf("expected newest plan 12, got %+v", snap)
+	}
+	if _, err := st.LatestPlanForPendingInput(ctx, 6); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for unlinked input, got %v", err)
+	}
+}
+
+func TestAvgDurationsAveragesSeededEvents(t *testing.T) {
+	durations := map[string][]int64{
+		"numpy": {1000, 3000},
+		"scipy": {6000},
+		"lxml":  {500},
+	}
+	db := &fakeDB{
+		query: func(query string, args []driver.NamedValue) (driver.Rows, error) {
+			if !strings.Contains(query, "avg((metadata->>'duration_ms')::bigint)") || !strings.Contains(query, "= ANY($1)") {
+				t.Fatalf("unexpected query: %s", query)
+			}
+			var names pq.StringArray
+			if err := names.Scan([]byte(args[0].Value.(string))); err != nil {
+				t.Fatalf("scan names: %v", err)
+			}
+			out := &fakeRows{cols: []string{"name", "avg_ms"}}
+			for _, n := range names {
+				ds, ok := durations[n]
+				if !ok {
+					continue
+				}
+				var sum int64
+				for _, d := range ds {
+					sum += d
+				}
+				out.data = append(out.data, []driver.Value{n, float64(sum) / float64(len(ds))})
+			}
+			return out, nil
+		},
+	}
+	st := newFakeStore(db)
+	avgs, err := st.AvgDurations(context.Background(), []string{"NumPy", "scipy", "pandas"})
+	if err != nil {
+		t.Fatalf("avg durations: %v", err)
+	}
+	want := map[string]float64{"numpy": 2000, "scipy": 6000}
+	if !reflect.DeepEqual(avgs, want) {
+		t.Fatalf("unexpected averages: %v", avgs)
+	}
+}
+
+func TestSearchPackageNamesMatchesPrefixSorted(t *testing.T) {
+	eventNames := []string{"numpy", "NumExpr", "scipy", "numpy"}
+	buildNames := []string{"numba", "num_utils", "numXutils", "pandas"}
+	db := &fakeDB{
+		query: func(query string, args []driver.NamedValue) (driver.Rows, error) {
+			if !strings.Contains(query, "UNION") || !strings.Contains(query, "ILIKE $1") {
+				t.Fatalf("unexpected query: %s", query)
+			}
+			pattern := args[0].Value.(string)
+			prefix := strings.TrimSuffix(pattern, "%")
+			prefix = strings.NewReplacer(`\_`, "_", `\%`, "%", `\\`, `\`).Replace(prefix)
+			seen := map[string]bool{}
+			var names []string
+			for _, n := range append(append([]string{}, eventNames...), buildNames...) {
+				if !seen[n] && strings.HasPrefix(strings.ToLower(n), strings.ToLower(prefix)) {
+					seen[n] = true
+					names = append(names, n)
+				}
+			}
+			sort.Slice(names, func(i, j int) bool { return strings.ToLower(names[i]) < strings.ToLower(names[j]) })
+			out := &fakeRows{cols: []string{"name"}}
+			for i, n := range names {
+				if int64(i) == args[1].Value.(int64) {
+					break
+				}
+				out.data = append(out.data, []driver.Value{n})
+			}
+			return out, nil
+		},
+	}
+	st := newFakeStore(db)
+	names, err := st.SearchPackageNames(context.Background(), "num", 10)
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	want := []string{"num_utils", "numba", "NumExpr", "numpy", "numXutils"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("unexpected names: %v", names)
+	}
+	names, err = st.SearchPackageNames(context.Background(), "num_", 10)
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if !reflect.DeepEqual(names, []string{"num_utils"}) {
+		t.Fatalf("underscore should match literally, got %v", names)
+	}
+}
+
+// ilikeMatch mimics Postgres ILIKE for the patterns namePattern produces.
+func ilikeMatch(pattern, s string) bool {
+	var re strings.Builder
+	re.WriteString("(?i)^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '%':
+			re.WriteString(".*")
+		case '_':
+			re.WriteString(".")
+		case '\\':
+			i++
+			re.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	return regexp.MustCompile(re.String()).MatchString(s)
+}
+
+func TestEventNameFilters(t *testing.T) {
+	names := []string{"apache-airflow", "Apache-Beam", "apache_arrow", "numpy", "apache"}
+	db := &fakeDB{
+		query: func(query string, args []driver.NamedValue) (driver.Rows, error) {
+			out := &fakeRows{cols: []string{"run_id", "name", "version", "python_tag", "platform_tag", "status", "detail", "metadata", "matched_hint_ids", "timestamp", "duration_ms"}}
+			for i, n := range names {
+				switch {
+				case strings.Contains(query, "name ILIKE $1"):
+					if !ilikeMatch(args[0].Value.(string), n) {
+						continue
+					}
+				case strings.Contains(query, "name = $1"):
+					if n != args[0].Value.(string) {
+						continue
+					}
+				default:
+					t.Fatalf("expected a name filter: %s", query)
+				}
+				out.data = append(out.data, []driver.Value{"run", n, "1.0", "cp311", "manylinux2014_s390x", "built", "", []byte("{}"), []byte("{}"), int64(100 - i), int64(0)})
+			}
+			return out, nil
+		},
+	}
+	st := newFakeStore(db)
+	ctx := context.Background()
+	eventNames := func(evts []Event) []string {
+		var out []string
+		for _, e := range evts {
+			out = append(out, e.Name)
+		}
+		return out
+	}
+
+	evts, err := st.Recent(ctx, 50, 0, "", "apache-*", "")
+	if err != nil {
+		t.Fatalf("recent: %v", err)
+	}
+	if got := eventNames(evts); !reflect.DeepEqual(got, []string{"apache-airflow", "Apache-Beam"}) {
+		t.Fatalf("unexpected pattern matches: %v", got)
+	}
+	evts, err = st.History(ctx, HistoryFilter{NameLike: "apache?a*"})
+	if err != nil {
+		t.Fatalf("history: %v", err)
+	}
+	if got := eventNames(evts); !reflect.DeepEqual(got, []string{"apache-airflow", "apache_arrow"}) {
+		t.Fatalf("unexpected history pattern matches: %v", got)
+	}
+	evts, err = st.History(ctx, HistoryFilter{Package: "apache"})
+	if err != nil {
+		t.Fatalf("history: %v", err)
+	}
+	if got := eventNames(evts); !reflect.DeepEqual(got, []string{"apache"}) {
+		t.Fatalf("exact match should return only apache, got %v", got)
+	}
+	for _, broad := range []string{"*", "a*", "?*?"} {
+		if _, err := st.Recent(ctx, 50, 0, "", broad, ""); !errors.Is(err, ErrPatternTooBroad) {
+			t.Fatalf("expected ErrPatternTooBroad for %q, got %v", broad, err)
+		}
+	}
+}
+
+func TestAggregatesRespectTimeWindow(t *testing.T) {
+	day := int64(24 * 60 * 60)
+	now := int64(1_700_000_000)
+	type evt struct {
+		name, status string
+		ts, durMs    int64
+	}
+	events := []evt{
+		{"numpy", "failed", now - 2*day, 9000},
+		{"numpy", "failed", now - 2*day + 60, 9000},
+		{"scipy", "failed", now - 3600, 1000},
+		{"scipy", "built", now - 1800, 3000},
+	}
+	// inWindow applies the epoch bounds bound by eventWindow.
+	inWindow := func(query string, args []driver.NamedValue, ts int64) bool {
+		for i, a := range args {
+			placeholder := fmt.Sprintf("$%d", i+1)
+			v, ok := a.Value.(int64)
+			if !ok {
+				continue
+			}
+			if strings.Contains(query, ">= "+placeholder) && ts < v {
+				return false
+			}
+			if strings.Contains(query, "<= "+placeholder) && ts > v {
+				return false
+			}
+		}
+		return true
+	}
+	db := &fakeDB{
+		query: func(query string, args []driver.NamedValue) (driver.Rows, error) {
+			switch {
+			case strings.HasPrefix(query, "SELECT status, count(*)"):
+				counts := map[string]int64{}
+				for _, e := range events {
+					if inWindow(query, args, e.ts) {
+						counts[e.status]++
+					}
+				}
+				out := &fakeRows{cols: []string{"status", "count"}}
+				for st, n := range counts {
+					out.data = append(out.data, []driver.Value{st, n})
+				}
+				return out, nil
+			case strings.Contains(query, "ORDER BY timestamp DESC"):
+				out := &fakeRows{cols: []string{"run_id", "name", "version", "python_tag", "platform_tag", "status", "detail", "metadata", "matched_hint_ids", "timestamp"}}
+				for _, e := range events {
+					if e.status == "failed" && inWindow(query, args, e.ts) {
+						out.data = append(out.data, []driver.Value{"run", e.name, "1.0", "cp311", "manylinux2014_s390x", e.status, "", []byte("{}"), []byte("{}"), e.ts})
+					}
+				}
+				return out, nil
+			case strings.Contains(query, "count(*)::float"):
+				counts := map[string]float64{}
+				for _, e := range events {
+					if e.status == "failed" && inWindow(query, args, e.ts) {
+						counts[e.name]++
+					}
+				}
+				out := &fakeRows{cols: []string{"name", "count"}}
+				for n, c := range counts {
+					out.data = append(out.data, []driver.Value{n, c})
+				}
+				return out, nil
+			case strings.Contains(query, "avg_ms"):
+				sums, counts := map[string]int64{}, map[string]int64{}
+				for _, e := range events {
+					if inWindow(query, args, e.ts) {
+						sums[e.name] += e.durMs
+						counts[e.name]++
+					}
+				}
+				out := &fakeRows{cols: []string{"name", "avg_ms"}}
+				for n := range sums {
+					out.data = append(out.data, []driver.Value{n, float64(sums[n]) / float64(counts[n])})
+				}
+				return out, nil
+			}
+			t.Fatalf("unexpected query: %s", query)
+			return nil, nil
+		},
+	}
+	st := newFakeStore(db)
+	ctx := context.Background()
+	from := now - day
+
+	all, err := st.Summary(ctx, 10, 0, 0)
+	if err != nil {
+		t.Fatalf("summary: %v", err)
+	}
+	if all.StatusCounts["failed"] != 3 || len(all.Failures) != 3 {
+		t.Fatalf("unbounded summary should see every event: %+v", all)
+	}
+	recent, err := st.Summary(ctx, 10, from, now)
+	if err != nil {
+		t.Fatalf("summary: %v", err)
+	}
+	if recent.StatusCounts["failed"] != 1 || recent.StatusCounts["built"] != 1 || len(recent.Failures) != 1 || recent.Failures[0].Name != "scipy" {
+		t.Fatalf("windowed summary included old events: %+v", recent)
+	}
+	failures, err := st.TopFailures(ctx, 10, from, 0)
+	if err != nil {
+		t.Fatalf("top failures: %v", err)
+	}
+	if !reflect.DeepEqual(failures, []Stat{{Name: "scipy", Value: 1}}) {
+		t.Fatalf("windowed top failures included old events: %+v", failures)
+	}
+	slowest, err := st.TopSlowest(ctx, 10, from, 0)
+	if err != nil {
+		t.Fatalf("top slowest: %v", err)
+	}
+	if !reflect.DeepEqual(slowest, []Stat{{Name: "scipy", Value: 2000}}) {
+		t.Fatalf("windowed top slowest included old events: %+v", slowest)
+	}
+}
+
+func TestHintEffectivenessSplitsOutcomes(t *testing.T) {
+	var query string
+	db := &fakeDB{
+		query: func(q string, args []driver.NamedValue) (driver.Rows, error) {
+			query = strings.Join(strings.Fields(q), " ")
+			return &fakeRows{
+				cols: []string{"hint_id", "matched", "built", "failed"},
+				data: [][]driver.Value{
+					{"libxml2-dev", int64(3), int64(1), int64(1)},
+					{"rust-toolchain", int64(1), int64(0), int64(0)},
+				},
+			}, nil
+		},
+	}
+	st := newFakeStore(db)
+	got, err := st.HintEffectiveness(context.Background())
+	if err != nil {
+		t.Fatalf("hint effectiveness: %v", err)
+	}
+	want := []HintEffectiveness{
+		{HintID: "libxml2-dev", Matched: 3, Built: 1, Failed: 1},
+		{HintID: "rust-toolchain", Matched: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected effectiveness:\n got %+v\nwant %+v", got, want)
+	}
+	for _, want := range []string{
+		"unnest(e.matched_hint_ids)",
+		"n.name = e.name AND n.version = e.version",
+		"n.python_tag IS NOT DISTINCT FROM e.python_tag",
+		"(n.timestamp > e.timestamp OR (n.timestamp = e.timestamp AND n.id > e.id))",
+		"ORDER BY n.timestamp ASC, n.id ASC LIMIT 1",
+	} {
+		if !strings.Contains(query, want) {
+			t.Fatalf("outcome lookup missing %q: %s", want, query)
+		}
+	}
+	if strings.Contains(query, "n.timestamp >= e.timestamp") {
+		t.Fatalf("matching event must not count as its own outcome: %s", query)
+	}
+}
+
+func TestCancelledBuildsStayOutOfFailureCounts(t *testing.T) {
+	var queries []string
+	db := &fakeDB{
+		query: func(query string, args []driver.NamedValue) (driver.Rows, error) {
+			query = strings.Join(strings.Fields(query), " ")
+			queries = append(queries, query)
+			switch {
+			case strings.HasPrefix(query, "SELECT status, count(*)"):
+				return &fakeRows{cols: []string{"status", "count"}, data: [][]driver.Value{{"failed", int64(1)}, {"cancelled", int64(3)}}}, nil
+			case strings.Contains(query, "ORDER BY timestamp DESC"):
+				return &fakeRows{cols: []string{"run_id", "name", "version", "python_tag", "platform_tag", "status", "detail", "metadata", "matched_hint_ids", "timestamp"}}, nil
+			case strings.Contains(query, "count(*)::float"):
+				return &fakeRows{cols: []string{"name", "total"}}, nil
+			case strings.Contains(query, "FROM build_status"):
+				return &fakeRows{
+					cols: []string{"length", "oldest_age_seconds", "pending", "retry", "leased", "building", "cancelled"},
+					data: [][]driver.Value{{int64(2), int64(60), int64(1), int64(0), int64(0), int64(1), int64(2)}},
+				}, nil
+			}
+			t.Fatalf("unexpected query: %s", query)
+			return nil, nil
+		},
+	}
+	st := newFakeStore(db)
+	ctx := context.Background()
+
+	sum, err := st.Summary(ctx, 10, 0, 0)
+	if err != nil {
+		t.Fatalf("summary: %v", err)
+	}
+	if sum.StatusCounts["failed"] != 1 || sum.StatusCounts["cancelled"] != 3 {
+		t.Fatalf("cancelled should be counted under its own status: %+v", sum.StatusCounts)
+	}
+	if _, err := st.TopFailures(ctx, 10, 0, 0); err != nil {
+		t.Fatalf("top failures: %v", err)
+	}
+	stats, err := st.BuildQueueStats(ctx)
+	if err != nil {
+		t.Fatalf("queue stats: %v", err)
+	}
+	if stats.Length != 2 || stats.Cancelled != 2 {
+		t.Fatalf("cancelled builds should be counted apart from the queue: %+v", stats)
+	}
+
+	if len(queries) != 4 {
+		t.Fatalf("expected summary, failures, top failures, and queue stats queries, got %d", len(queries))
+	}
+	failureList, topFailures, queueStats := queries[1], queries[2], queries[3]
+	if !strings.Contains(failureList, "FROM events WHERE status='failed'") {
+		t.Fatalf("summary failures must select only failed events: %s", failureList)
+	}
+	for _, want := range []string{
+		"FROM events WHERE status='failed'",
+		"FROM event_rollups WHERE status='failed'",
+	} {
+		if !strings.Contains(topFailures, want) {
+			t.Fatalf("top failures missing %q: %s", want, topFailures)
+		}
+	}
+	for _, q := range []string{failureList, topFailures} {
+		if strings.Contains(q, "cancelled") {
+			t.Fatalf("failure queries must not match cancelled: %s", q)
+		}
+	}
+	for _, want := range []string{
+		"COUNT(*) FILTER (WHERE status IN ('pending','retry','leased','building')) AS length",
+		"COUNT(*) FILTER (WHERE status='cancelled') AS cancelled",
+	} {
+		if !strings.Contains(queueStats, want) {
+			t.Fatalf("queue stats missing %q: %s", want, queueStats)
+		}
+	}
+}
+
+func TestRollupEventsMovesOldEventsIntoDailySummaries(t *testing.T) {
+	now := time.Now()
+	type evt struct {
+		name, status string
+		ts           time.Time
+		durMs        int64
+	}
+	events := []evt{
+		{"numpy", "failed", now.Add(-40 * 24 * time.Hour), 1000},
+		{"numpy", "built", now.Add(-40 * 24 * time.Hour), 3000},
+		{"numpy", "built", now.Add(-40*24*time.Hour + time.Minute), 5000},
+		{"numpy", "built", now.Add(-time.Hour), 2000},
+	}
+	type rollupKey struct{ day, name, status string }
+	type rollup struct{ count, total, durations int64 }
+	rollups := map[rollupKey]*rollup{}
+	db := &fakeDB{
+		query: func(query string, args []driver.NamedValue) (driver.Rows, error) {
+			switch {
+			case strings.Contains(query, "WITH moved AS"):
+				if !strings.Contains(query, "DELETE FROM events WHERE timestamp < $1") || !strings.Contains(query, "INSERT INTO event_rollups") ||
+					!strings.Contains(query, "ON CONFLICT (day, name, status) DO UPDATE") {
+					t.Fatalf("unexpected rollup query: %s", query)
+				}
+				cutoff := args[0].Value.(time.Time)
+				var kept []evt
+				moved := int64(0)
+				for _, e := range events {
+					if !e.ts.Before(cutoff) {
+						kept = append(kept, e)
+						continue
+					}
+					k := rollupKey{e.ts.Format("2006-01-02"), e.name, e.status}
+					if rollups[k] == nil {
+						rollups[k] = &rollup{}
+					}
+					rollups[k].count++
+					rollups[k].total += e.durMs
+					rollups[k].durations++
+					moved++
+				}
+				events = kept
+				return &fakeRows{cols: []string{"count"}, data: [][]driver.Value{{moved}}}, nil
+			case strings.HasPrefix(query, "SELECT status, count(*)"):
+				if !strings.Contains(query, "FROM event_rollups") {
+					t.Fatalf("summary should read rollups: %s", query)
+				}
+				out := &fakeRows{cols: []string{"status", "count"}}
+				for _, e := range events {
+					out.data = append(out.data, []driver.Value{e.status, int64(1)})
+				}
+				for k, r := range rollups {
+					out.data = append(out.data, []driver.Value{k.status, r.count})
+				}
+				return out, nil
+			case strings.Contains(query, "ORDER BY timestamp DESC"):
+				return &fakeRows{cols: []string{"run_id", "name", "version", "python_tag", "platform_tag", "status", "detail", "metadata", "matched_hint_ids", "timestamp"}}, nil
+			}
+			t.Fatalf("unexpected query: %s", query)
+			return nil, nil
+		},
+	}
+	st := newFakeStore(db)
+	ctx := context.Background()
+
+	n, err := st.RollupEvents(ctx, 30*24*3600)
+	if err != nil {
+		t.Fatalf("rollup: %v", err)
+	}
+	if n != 3 || len(events) != 1 {
+		t.Fatalf("expected 3 old events rolled up and 1 kept, got n=%d kept=%d", n, len(events))
+	}
+	day := now.Add(-40 * 24 * time.Hour).Format("2006-01-02")
+	built := rollups[rollupKey{day, "numpy", "built"}]
+	if built == nil || built.count != 2 || built.total/built.durations != 4000 {
+		t.Fatalf("expected daily built rollup with avg 4000ms, got %+v", built)
+	}
+	sum, err := st.Summary(ctx, 10, 0, 0)
+	if err != nil {
+		t.Fatalf("summary: %v", err)
+	}
+	if sum.StatusCounts["built"] != 3 || sum.StatusCounts["failed"] != 1 {
+		t.Fatalf("summary should combine raw events and rollups: %+v", sum.StatusCounts)
+	}
+}
+
+func TestBuiltManifestEntriesPicksNewestMatchPerKey(t *testing.T) {
+	cols := []string{"name", "version", "wheel", "wheel_url", "python_tag", "platform_tag", "status", "created_at", "plan_id", "run_id", "wheel_source_digest"}
+	db := &fakeDB{
+		query: func(query string, args []driver.NamedValue) (driver.Rows, error) {
+			if !strings.Contains(query, "status = 'built'") || !strings.Contains(query, "lower(name) = ANY($1)") {
+				t.Fatalf("unexpected query: %s", query)
+			}
+			return &fakeRows{cols: cols, data: [][]driver.Value{
+				{"NumPy", "1.26.4", "numpy-new.whl", "", "cp311", "manylinux2014_s390x", "built", int64(300), int64(3), "run3", ""},
+				{"numpy", "1.26.4", "numpy-other-tag.whl", "", "cp312", "manylinux2014_s390x", "built", int64(250), int64(2), "run2", ""},
+				{"numpy", "1.26.4", "numpy-old.whl", "", "cp311", "manylinux2014_s390x", "built", int64(100), int64(1), "run1", ""},
+			}}, nil
+		},
+	}
+	st := newFakeStore(db)
+	got, err := st.BuiltManifestEntries(context.Background(), []ManifestKey{
+		{Name: "numpy", Version: "1.26.4", PythonTag: "cp311", PlatformTag: "manylinux2014_s390x"},
+		{Name: "scipy", Version: "1.13.0", PythonTag: "cp311", PlatformTag: "manylinux2014_s390x"},
+	})
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	if len(got) != 1 || got[0].Wheel != "numpy-new.whl" {
+		t.Fatalf("expected only the newest matching numpy entry, got %+v", got)
+	}
+}
+
+func TestManifestEntryByKeyMatchesTagsWhenGiven(t *testing.T) {
+	var gotArgs []driver.NamedValue
+	found := true
+	db := &fakeDB{
+		query: func(query string, args []driver.NamedValue) (driver.Rows, error) {
+			q := strings.Join(strings.Fields(query), " ")
+			if !strings.Contains(q, "WHERE lower(name) = lower($1) AND version = $2 AND ($3 = '' OR python_tag = $3) AND ($4 = '' OR platform_tag = $4) ORDER BY created_at DESC LIMIT 1") {
+				t.Fatalf("unexpected query: %s", q)
+			}
+			gotArgs = args
+			cols := []string{"name", "version", "wheel", "wheel_url", "repair_url", "repair_digest", "runtime_url", "pack_urls", "sbom_url", "python_tag", "platform_tag", "status", "created_at",
+				"provenance_url", "plan_id", "run_id", "builder_id", "wheel_source_digest", "repair_tool_version", "repair_policy_hash"}
+			if !found {
+				return &fakeRows{cols: cols}, nil
+			}
+			return &fakeRows{cols: cols, data: [][]driver.Value{{"NumPy", "1.26.4", "numpy.whl", "", "", "", "", []byte("{}"), "", "cp311", "manylinux2014_s390x", "built", int64(300),
+				"", int64(7), "run7", "", "", "", ""}}}, nil
+		},
+	}
+	st := newFakeStore(db)
+	got, err := st.ManifestEntryByKey(context.Background(), ManifestKey{Name: "numpy", Version: "1.26.4", PythonTag: "cp311"})
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	if got.PlanID != 7 || got.PythonTag != "cp311" {
+		t.Fatalf("unexpected entry %+v", got)
+	}
+	if len(gotArgs) != 4 || gotArgs[2].Value != "cp311" || gotArgs[3].Value != "" {
+		t.Fatalf("unexpected args %+v", gotArgs)
+	}
+
+	found = false
+	if _, err := st.ManifestEntryByKey(context.Background(), ManifestKey{Name: "numpy", Version: "9.9"}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestPlanSnapshotFlagsEditedDAG(t *testing.T) {
+	var savedPlan []byte
+	var savedHash string
+	var storedDAG []byte
+	db := &fakeDB{
+		query: func(query string, args []driver.NamedValue) (driver.Rows, error) {
+			if strings.Contains(query, "INSERT INTO plans") {
+				savedPlan = args[1].Value.([]byte)
+				savedHash = args[3].Value.(string)
+				return &fakeRows{cols: []string{"id"}, data: [][]driver.Value{{int64(7)}}}, nil
+			}
+			return &fakeRows{
+				cols: []string{"id", "run_id", "plan", "dag", "plan_hash", "queued"},
+				data: [][]driver.Value{{int64(7), "run-7", savedPlan, storedDAG, savedHash, false}},
+			}, nil
+		},
+	}
+	st := newFakeStore(db)
+	ctx := context.Background()
+	nodes := []PlanNode{{Name: "numpy", Version: "1.26.0", Action: "build"}}
+	dag := json.RawMessage(`[{"id":{"type":"wheel","digest":"sha256:w"},"type":"wheel","action":"build","metadata":{"source_digest":"sha256:s"}}]`)
+	if _, err := st.SavePlan(ctx, "run-7", nodes, dag); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	nodesJSON, _ := json.Marshal(nodes)
+	if savedHash == hashPlanJSON(nodesJSON) {
+		t.Fatalf("expected the DAG to be part of the plan hash")
+	}
+
+	// JSONB reorders keys and drops whitespace.
+	storedDAG = []byte(`[{"type": "wheel", "action": "build", "metadata": {"source_digest": "sha256:s"}, "id": {"digest": "sha256:w", "type": "wheel"}}]`)
+	snap, err := st.PlanSnapshot(ctx, 7)
+	if err != nil || snap.Tampered {
+		t.Fatalf("expected an intact DAG to pass, got tampered=%v err=%v", snap.Tampered, err)
+	}
+
+	storedDAG = []byte(strings.Replace(string(dag), "sha256:s", "sha256:evil", 1))
+	snap, err = st.PlanSnapshot(ctx, 7)
+	if err != nil || !snap.Tampered {
+		t.Fatalf("expected an edited DAG to fail the integrity check, got tampered=%v err=%v", snap.Tampered, err)
+	}
+}
+
+func TestPlanSnapshotFlagsTamperedPlan(t *testing.T) {
+	var savedPlan []byte
+	var savedHash string
+	var stored []byte
+	db := &fakeDB{
+		query: func(query string, args []driver.NamedValue) (driver.Rows, error) {
+			if strings.Contains(query, "INSERT INTO plans") {
+				savedPlan = args[1].Value.([]byte)
+				savedHash = args[3].Value.(string)
+				return &fakeRows{cols: []string{"id"}, data: [][]driver.Value{{int64(7)}}}, nil
+			}
+			return &fakeRows{
+				cols: []string{"id", "run_id", "plan", "dag", "plan_hash", "queued"},
+				data: [][]driver.Value{{int64(7), "run-7", stored, []byte("[]"), savedHash, false}},
+			}, nil
+		},
+	}
+	st := newFakeStore(db)
+	ctx := context.Background()
+	nodes := []PlanNode{{Name: "numpy", Version: "1.26.0", Action: "build"}, {Name: "six", Version: "1.16.0", Action: "reuse"}}
+	if _, err := st.SavePlan(ctx, "run-7", nodes, nil); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if !strings.HasPrefix(savedHash, "sha256:") {
+		t.Fatalf("expected plan hash on insert, got %q", savedHash)
+	}
+
+	// JSONB reorders keys, so an untouched plan may come back re-encoded.
+	stored = []byte(`[{"action":"build","version":"1.26.0","name":"numpy"},{"name":"six","version":"1.16.0","action":"reuse"}]`)
+	snap, err := st.PlanSnapshot(ctx, 7)
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	if snap.Tampered || snap.Hash != savedHash {
+		t.Fatalf("expected intact plan with hash %s, got tampered=%v hash=%s", savedHash, snap.Tampered, snap.Hash)
+	}
+
+	stored = []byte(strings.Replace(string(savedPlan), `"reuse"`, `"build"`, 1))
+	snap, err = st.PlanSnapshot(ctx, 7)
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	if !snap.Tampered {
+		t.Fatalf("expected edited plan row to fail the integrity check")
+	}
+	if snap, err = st.LatestPlanSnapshot(ctx); err != nil || !snap.Tampered {
+		t.Fatalf("expected latest snapshot flagged too, got tampered=%v err=%v", snap.Tampered, err)
+	}
+}
@@ -2,8 +2,11 @@ package store
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/k8ika0s/s390x-wheel-refinery/go-control-plane/internal/settings"
@@ -12,8 +15,13 @@ import (
 // ErrNotFound is returned when a requested record is missing.
 var ErrNotFound = errors.New("not found")
 
+// ErrPatternTooBroad is returned for name_like patterns with too few literal
+// characters to be a meaningful filter.
+var ErrPatternTooBroad = errors.New("name pattern too broad")
+
 // Event represents a build event history row.
 type Event struct {
+	EventID        string         `json:"event_id,omitempty"`
 	RunID          string         `json:"run_id,omitempty"`
 	Name           string         `json:"name"`
 	Version        string         `json:"version"`
@@ -27,6 +35,18 @@ type Event struct {
 	DurationMS     int64          `json:"duration_ms,omitempty"`
 }
 
+// EventKey returns the idempotency key for an event: the caller-supplied
+// EventID, or a digest of run_id, name, version, python and platform tags,
+// status, and timestamp. The tags keep the per-Python events of a matrix
+// build, which share everything else, apart.
+func EventKey(evt Event) string {
+	if evt.EventID != "" {
+		return evt.EventID
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%s|%s|%s|%s|%d", evt.RunID, evt.Name, evt.Version, evt.PythonTag, evt.PlatformTag, evt.Status, evt.Timestamp)))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
 // Hint represents a hint catalog entry.
 type Hint struct {
 	ID         string              `json:"id" yaml:"id"`
@@ -39,6 +59,10 @@ type Hint struct {
 	Confidence string              `json:"confidence,omitempty" yaml:"confidence,omitempty"`
 	Examples   []string            `json:"examples,omitempty" yaml:"examples,omitempty"`
 	DeletedAt  *time.Time          `json:"deleted_at,omitempty" yaml:"deleted_at,omitempty"`
+	// MatchCount and LastMatchedAt (epoch seconds) are maintained by
+	// RecordEvent(s) from matched_hint_ids; they are never written by PutHint.
+	MatchCount    int64 `json:"match_count" yaml:"-"`
+	LastMatchedAt int64 `json:"last_matched_at,omitempty" yaml:"-"`
 }
 
 // LogEntry represents stored log metadata/content.
@@ -85,18 +109,35 @@ type PendingInput struct {
 
 // ManifestEntry tracks output wheel metadata.
 type ManifestEntry struct {
-	Name         string
-	Version      string
-	Wheel        string
-	WheelURL     string
-	RepairURL    string
-	RepairDigest string
-	RuntimeURL   string
-	PackURLs     []string
-	PythonTag    string
-	PlatformTag  string
-	Status       string
-	CreatedAt    int64
+	Name         string   `json:"name"`
+	Version      string   `json:"version"`
+	Wheel        string   `json:"wheel"`
+	WheelURL     string   `json:"wheel_url,omitempty"`
+	RepairURL    string   `json:"repair_url,omitempty"`
+	RepairDigest string   `json:"repair_digest,omitempty"`
+	RuntimeURL   string   `json:"runtime_url,omitempty"`
+	PackURLs     []string `json:"pack_urls,omitempty"`
+	SBOMURL      string   `json:"sbom_url,omitempty"`
+	PythonTag    string   `json:"python_tag,omitempty"`
+	PlatformTag  string   `json:"platform_tag,omitempty"`
+	Status       string   `json:"status,omitempty"`
+	CreatedAt    int64    `json:"created_at"`
+	// Provenance fields mirror the attestation stored at ProvenanceURL.
+	ProvenanceURL     string `json:"provenance_url,omitempty"`
+	PlanID            int64  `json:"plan_id,omitempty"`
+	RunID             string `json:"run_id,omitempty"`
+	BuilderID         string `json:"builder_id,omitempty"`
+	WheelSourceDigest string `json:"wheel_source_digest,omitempty"`
+	RepairToolVersion string `json:"repair_tool_version,omitempty"`
+	RepairPolicyHash  string `json:"repair_policy_hash,omitempty"`
+}
+
+// ManifestKey identifies a built wheel for manifest reuse lookups.
+type ManifestKey struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	PythonTag   string `json:"python_tag"`
+	PlatformTag string `json:"platform_tag"`
 }
 
 // Artifact represents a downloadable/browsable build artifact.
@@ -134,6 +175,9 @@ type PlanNode struct {
 	Action        string       `json:"action"`
 	Hints         []PlanHint   `json:"hints,omitempty"`
 	Recipes       []PlanRecipe `json:"recipes,omitempty"`
+	Hashes        []string     `json:"hashes,omitempty"`
+	MemoryMB      int          `json:"memory_mb,omitempty"`
+	CPUs          float64      `json:"cpus,omitempty"`
 }
 
 // PlanSnapshot captures a stored plan with optional DAG payload.
@@ -143,6 +187,11 @@ type PlanSnapshot struct {
 	Plan   []PlanNode      `json:"plan"`
 	DAG    json.RawMessage `json:"dag,omitempty"`
 	Queued bool            `json:"queued,omitempty"`
+	// Hash is the plan node hash recorded by SavePlan; plans saved before
+	// hashing have none. Tampered is set when the stored nodes no longer
+	// match it.
+	Hash     string `json:"hash,omitempty"`
+	Tampered bool   `json:"tampered,omitempty"`
 }
 
 // PlanSummary provides a compact plan list entry.
@@ -157,26 +206,28 @@ type PlanSummary struct {
 
 // BuildStatus tracks a build job derived from a plan.
 type BuildStatus struct {
-	ID             int64    `json:"id"`
-	Package        string   `json:"package"`
-	Version        string   `json:"version"`
-	PythonTag      string   `json:"python_tag"`
-	PlatformTag    string   `json:"platform_tag"`
-	Status         string   `json:"status"`
-	Attempts       int      `json:"attempts"`
-	LastError      string   `json:"last_error,omitempty"`
-	FailureSummary string   `json:"failure_summary,omitempty"`
-	OldestAgeSec   int64    `json:"oldest_age_seconds,omitempty"`
-	CreatedAt      int64    `json:"created_at"`
-	UpdatedAt      int64    `json:"updated_at"`
-	LeasedAt       int64    `json:"leased_at,omitempty"`
-	StartedAt      int64    `json:"started_at,omitempty"`
-	FinishedAt     int64    `json:"finished_at,omitempty"`
-	RunID          string   `json:"run_id,omitempty"`
-	PlanID         int64    `json:"plan_id,omitempty"`
-	BackoffUntil   int64    `json:"backoff_until,omitempty"`
-	Recipes        []string `json:"recipes,omitempty"`
-	HintIDs        []string `json:"hint_ids,omitempty"`
+	ID              int64    `json:"id"`
+	Package         string   `json:"package"`
+	Version         string   `json:"version"`
+	PythonTag       string   `json:"python_tag"`
+	PlatformTag     string   `json:"platform_tag"`
+	Status          string   `json:"status"`
+	Attempts        int      `json:"attempts"`
+	LastError       string   `json:"last_error,omitempty"`
+	FailureSummary  string   `json:"failure_summary,omitempty"`
+	FailureCategory string   `json:"failure_category,omitempty"`
+	OldestAgeSec    int64    `json:"oldest_age_seconds,omitempty"`
+	CreatedAt       int64    `json:"created_at"`
+	UpdatedAt       int64    `json:"updated_at"`
+	LeasedAt        int64    `json:"leased_at,omitempty"`
+	StartedAt       int64    `json:"started_at,omitempty"`
+	FinishedAt      int64    `json:"finished_at,omitempty"`
+	RunID           string   `json:"run_id,omitempty"`
+	PlanID          int64    `json:"plan_id,omitempty"`
+	BackoffUntil    int64    `json:"backoff_until,omitempty"`
+	Recipes         []string `json:"recipes,omitempty"`
+	HintIDs         []string `json:"hint_ids,omitempty"`
+	HeldRecipes     []string `json:"held_recipes,omitempty"`
 }
 
 // BuildQueueStats captures aggregate queue counts.
@@ -187,6 +238,7 @@ type BuildQueueStats struct {
 	Retry        int   `json:"retry"`
 	Leased       int   `json:"leased"`
 	Building     int   `json:"building"`
+	Cancelled    int   `json:"cancelled"`
 }
 
 // WorkerStatus tracks worker heartbeat metadata.
@@ -198,8 +250,11 @@ type WorkerStatus struct {
 	BuildPoolSize        int    `json:"build_pool_size"`
 	PlanPoolSize         int    `json:"plan_pool_size"`
 	HeartbeatIntervalSec int    `json:"heartbeat_interval_sec,omitempty"`
-	CreatedAt            int64  `json:"created_at,omitempty"`
-	UpdatedAt            int64  `json:"updated_at,omitempty"`
+	// Smoke is the worker's latest smoke build result, kept until the next
+	// smoke run replaces it.
+	Smoke     json.RawMessage `json:"smoke,omitempty"`
+	CreatedAt int64           `json:"created_at,omitempty"`
+	UpdatedAt int64           `json:"updated_at,omitempty"`
 }
 
 // PackageSummary aggregates status for a package.
@@ -221,22 +276,37 @@ type Stat struct {
 	Value float64 `json:"value"`
 }
 
+// HintEffectiveness counts events that matched a hint, split by the first
+// terminal outcome recorded for that package version at or after the match.
+// Matches with no outcome yet count only toward Matched.
+type HintEffectiveness struct {
+	HintID  string `json:"hint_id"`
+	Matched int    `json:"matched"`
+	Built   int    `json:"built"`
+	Failed  int    `json:"failed"`
+}
+
 // Store abstracts history, hints, logs, manifests.
 type Store interface {
 	// Events
-	Recent(ctx context.Context, limit, offset int, pkg, status string) ([]Event, error)
+	Recent(ctx context.Context, limit, offset int, pkg, nameLike, status string) ([]Event, error)
 	History(ctx context.Context, filter HistoryFilter) ([]Event, error)
-	Summary(ctx context.Context, failureLimit int) (Summary, error)
+	Summary(ctx context.Context, failureLimit int, fromTs, toTs int64) (Summary, error)
 	PackageSummary(ctx context.Context, name string) (PackageSummary, error)
+	SearchPackageNames(ctx context.Context, prefix string, limit int) ([]string, error)
 	LatestEvent(ctx context.Context, name, version string) (Event, error)
 	Failures(ctx context.Context, name string, limit int) ([]Event, error)
 	Variants(ctx context.Context, name string, limit int) ([]Event, error)
-	TopFailures(ctx context.Context, limit int) ([]Stat, error)
-	TopSlowest(ctx context.Context, limit int) ([]Stat, error)
-	RecordEvent(ctx context.Context, evt Event) error
+	TopFailures(ctx context.Context, limit int, fromTs, toTs int64) ([]Stat, error)
+	TopSlowest(ctx context.Context, limit int, fromTs, toTs int64) ([]Stat, error)
+	RollupEvents(ctx context.Context, olderThanSec int) (int, error)
+	AvgDurations(ctx context.Context, names []string) (map[string]float64, error)
+	RecordEvent(ctx context.Context, evt Event) (bool, error)
+	RecordEvents(ctx context.Context, events []Event) (int64, error)
 
 	// Hints
 	ListHints(ctx context.Context) ([]Hint, error)
+	HintEffectiveness(ctx context.Context) ([]HintEffectiveness, error)
 	GetHint(ctx context.Context, id string) (Hint, error)
 	PutHint(ctx context.Context, hint Hint) error
 	DeleteHint(ctx context.Context, id string) error
@@ -258,9 +328,14 @@ type Store interface {
 	SavePlan(ctx context.Context, runID string, nodes []PlanNode, dag json.RawMessage) (int64, error)
 	DeletePlans(ctx context.Context, planID int64) (int64, error)
 	QueueBuildsFromPlan(ctx context.Context, runID string, planID int64, nodes []PlanNode) error
+	ReconcilePlanBuilds(ctx context.Context, runID string, planID int64, nodes []PlanNode) ([]PlanNode, error)
 	Manifest(ctx context.Context, limit int) ([]ManifestEntry, error)
 	SaveManifest(ctx context.Context, entries []ManifestEntry) error
+	BuiltManifestEntries(ctx context.Context, keys []ManifestKey) ([]ManifestEntry, error)
+	ManifestEntryByKey(ctx context.Context, key ManifestKey) (ManifestEntry, error)
 	Artifacts(ctx context.Context, limit int) ([]Artifact, error)
+	ReferencedDigests(ctx context.Context) ([]string, error)
+	ReferencedBuilds(ctx context.Context) ([]ManifestKey, error)
 
 	// Pending inputs & planning
 	AddPendingInput(ctx context.Context, pi PendingInput) (int64, error)
@@ -269,16 +344,24 @@ type Store interface {
 	UpdatePendingInputStatus(ctx context.Context, id int64, status, errMsg string) error
 	DeletePendingInput(ctx context.Context, id int64) (PendingInput, error)
 	RestorePendingInput(ctx context.Context, id int64) (PendingInput, error)
+	PurgePendingInputs(ctx context.Context, olderThanSec int) ([]string, error)
 	LinkPlanToPendingInput(ctx context.Context, pendingID, planID int64) error
+	LatestPlanForPendingInput(ctx context.Context, pendingID int64) (PlanSnapshot, error)
 	UpdatePendingInputsForPlan(ctx context.Context, planID int64, status string) (int64, error)
 
 	// Build status/queue visibility
 	ListBuilds(ctx context.Context, status string, limit int, planID int64, pkg string, version string) ([]BuildStatus, error)
 	BuildQueueStats(ctx context.Context) (BuildQueueStats, error)
-	UpdateBuildStatus(ctx context.Context, pkg, version, status, errMsg, summary string, attempts int, backoffUntil int64, recipes []string, hintIDs []string) error
+	FailureCategories(ctx context.Context) ([]Stat, error)
+	UpdateBuildStatus(ctx context.Context, pkg, version, pythonTag, status, errMsg, summary, category string, attempts int, backoffUntil int64, recipes []string, hintIDs []string) error
 	LeaseBuilds(ctx context.Context, max int) ([]BuildStatus, error)
 	RequeueStaleLeases(ctx context.Context, maxAgeSec int) (int64, error)
 	DeleteBuilds(ctx context.Context, status string) (int64, error)
+	TransitionBuilds(ctx context.Context, fromStatus, toStatus string, resetAttempts bool) (int64, error)
+	HoldBuildFix(ctx context.Context, pkg, version, pythonTag string, recipes []string) error
+	ApproveBuildFix(ctx context.Context, pkg, version, pythonTag string) ([]string, error)
+	RequestBuildCancel(ctx context.Context, pkg, version, pythonTag string) (string, error)
+	BuildCancelRequested(ctx context.Context, pkg, version, pythonTag string) (bool, error)
 
 	// Worker health
 	UpsertWorkerStatus(ctx context.Context, status WorkerStatus) error
@@ -292,10 +375,13 @@ type Store interface {
 // HistoryFilter defines filters for history queries.
 type HistoryFilter struct {
 	Package string
-	Status  string
-	RunID   string
-	FromTs  int64
-	ToTs    int64
-	Limit   int
-	Offset  int
+	// NameLike is a case-insensitive glob (* and ?) applied instead of the
+	// exact Package match when set.
+	NameLike string
+	Status   string
+	RunID    string
+	FromTs   int64
+	ToTs     int64
+	Limit    int
+	Offset   int
 }
@@ -1,12 +1,35 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
 
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/service"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "gc" {
+		if err := service.RunGC(context.Background(), os.Stdout); err != nil {
+			log.Fatalf("gc failed: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "rebuild" {
+		if len(os.Args) != 5 {
+			log.Fatalf("usage: worker rebuild <manifest.json> <name> <version>")
+		}
+		if err := service.RunRebuild(context.Background(), os.Args[2], os.Args[3], os.Args[4], os.Stdout); err != nil {
+			log.Fatalf("rebuild failed: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "smoke" {
+		if err := service.RunSmoke(context.Background(), os.Stdout); err != nil {
+			log.Fatalf("smoke failed: %v", err)
+		}
+		return
+	}
 	if err := service.Run(); err != nil {
 		log.Fatalf("worker exited: %v", err)
 	}
@@ -0,0 +1,171 @@
+package cas
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenCache holds bearer tokens issued by registry token services, keyed by
+// registry host, so repeated requests skip the challenge round-trip until the
+// token expires. The zero value is not usable; use NewTokenCache.
+type TokenCache struct {
+	mu     sync.Mutex
+	tokens map[string]cachedToken
+	now    func() time.Time
+}
+
+type cachedToken struct {
+	token   string
+	expires time.Time
+}
+
+// NewTokenCache returns an empty token cache.
+func NewTokenCache() *TokenCache {
+	return &TokenCache{tokens: make(map[string]cachedToken), now: time.Now}
+}
+
+// defaultTokens is shared by clients that do not set their own cache.
+var defaultTokens = NewTokenCache()
+
+// tokenExpirySkew refreshes tokens slightly before the registry expires them.
+const tokenExpirySkew = 10 * time.Second
+
+func (c *TokenCache) get(host string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.tokens[host]
+	if !ok || !c.now().Before(t.expires) {
+		delete(c.tokens, host)
+		return ""
+	}
+	return t.token
+}
+
+func (c *TokenCache) put(host, token string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokens[host] = cachedToken{token: token, expires: c.now().Add(ttl - tokenExpirySkew)}
+}
+
+// registryAuth applies basic or bearer credentials to registry requests.
+type registryAuth struct {
+	username string
+	password string
+	client   *http.Client
+	tokens   *TokenCache
+}
+
+func newRegistryAuth(username, password string, client *http.Client, tokens *TokenCache) registryAuth {
+	if tokens == nil {
+		tokens = defaultTokens
+	}
+	return registryAuth{username: username, password: password, client: client, tokens: tokens}
+}
+
+// do sends the request built by newReq. On a 401 carrying a Bearer challenge
+// it fetches a token from the challenge realm, caches it, and retries once.
+// newReq is called per attempt so request bodies can be replayed.
+func (a registryAuth) do(ctx context.Context, newReq func() (*http.Request, error)) (*http.Response, error) {
+	req, err := newReq()
+	if err != nil {
+		return nil, err
+	}
+	host := req.URL.Host
+	a.authorize(req, a.tokens.get(host))
+	resp, err := a.client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return resp, nil
+	}
+	resp.Body.Close()
+	token, ttl, err := a.fetchToken(ctx, challenge)
+	if err != nil {
+		return nil, err
+	}
+	a.tokens.put(host, token, ttl)
+	req, err = newReq()
+	if err != nil {
+		return nil, err
+	}
+	a.authorize(req, token)
+	return a.client.Do(req)
+}
+
+func (a registryAuth) authorize(req *http.Request, token string) {
+	switch {
+	case token != "":
+		req.Header.Set("Authorization", "Bearer "+token)
+	case a.username != "" || a.password != "":
+		req.SetBasicAuth(a.username, a.password)
+	}
+}
+
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+func (a registryAuth) fetchToken(ctx context.Context, challenge string) (string, time.Duration, error) {
+	params := map[string]string{}
+	for _, m := range challengeParam.FindAllStringSubmatch(challenge, -1) {
+		params[strings.ToLower(m[1])] = m[2]
+	}
+	realm := params["realm"]
+	if realm == "" {
+		return "", 0, fmt.Errorf("bearer challenge missing realm")
+	}
+	u, err := url.Parse(realm)
+	if err != nil {
+		return "", 0, fmt.Errorf("bearer realm: %w", err)
+	}
+	q := u.Query()
+	for _, k := range []string{"service", "scope"} {
+		if v := params[k]; v != "" {
+			q.Set(k, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", 0, err
+	}
+	if a.username != "" || a.password != "" {
+		req.SetBasicAuth(a.username, a.password)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("token request status %d", resp.StatusCode)
+	}
+	var payload struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return "", 0, fmt.Errorf("decode token: %w", err)
+	}
+	token := payload.Token
+	if token == "" {
+		token = payload.AccessToken
+	}
+	if token == "" {
+		return "", 0, fmt.Errorf("token response missing token")
+	}
+	// The distribution spec defaults to 60 seconds when expires_in is absent.
+	ttl := 60 * time.Second
+	if payload.ExpiresIn > 0 {
+		ttl = time.Duration(payload.ExpiresIn) * time.Second
+	}
+	return token, ttl, nil
+}
@@ -0,0 +1,63 @@
+package cas
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/artifact"
+)
+
+func TestFetcherUsesBearerTokenFromChallenge(t *testing.T) {
+	var tokenRequests, blobRequests int
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			tokenRequests++
+			if r.URL.Query().Get("scope") != "repository:artifacts:pull" || r.URL.Query().Get("service") != "registry" {
+				http.Error(w, "bad scope", http.StatusBadRequest)
+				return
+			}
+			if user, pass, ok := r.BasicAuth(); !ok || user != "u" || pass != "p" {
+				http.Error(w, "bad creds", http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte(`{"token":"tok123","expires_in":300}`))
+		case "/v2/artifacts/blobs/sha256:abc":
+			blobRequests++
+			if r.Header.Get("Authorization") != "Bearer tok123" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+ts.URL+`/token",service="registry",scope="repository:artifacts:pull"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte("blob"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	f := Fetcher{BaseURL: ts.URL, Username: "u", Password: "p", Tokens: NewTokenCache()}
+	id := artifact.ID{Type: artifact.PackType, Digest: "sha256:abc"}
+	dir := t.TempDir()
+	for i := 0; i < 2; i++ {
+		dest := filepath.Join(dir, "blob")
+		if err := f.Fetch(context.Background(), id, dest); err != nil {
+			t.Fatalf("fetch %d: %v", i, err)
+		}
+		data, _ := os.ReadFile(dest)
+		if string(data) != "blob" {
+			t.Fatalf("unexpected blob contents %q", data)
+		}
+	}
+	if tokenRequests != 1 {
+		t.Fatalf("expected token to be cached after first challenge, got %d token requests", tokenRequests)
+	}
+	if blobRequests != 3 {
+		t.Fatalf("expected challenge + 2 authorized blob requests, got %d", blobRequests)
+	}
+}
@@ -12,11 +12,41 @@ type Store interface {
 	Has(ctx context.Context, id artifact.ID) (bool, error)
 }
 
+// BatchStore is implemented by stores that can check many artifacts at once.
+type BatchStore interface {
+	HasBatch(ctx context.Context, ids []artifact.ID) (map[string]bool, error)
+}
+
+// HasBatch reports which artifacts exist, keyed by digest. It uses the
+// store's batch lookup when available and falls back to per-ID Has calls.
+func HasBatch(ctx context.Context, store Store, ids []artifact.ID) (map[string]bool, error) {
+	if bs, ok := store.(BatchStore); ok {
+		return bs.HasBatch(ctx, ids)
+	}
+	out := make(map[string]bool, len(ids))
+	var firstErr error
+	for _, id := range ids {
+		if _, done := out[id.Digest]; done {
+			continue
+		}
+		ok, err := store.Has(ctx, id)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		out[id.Digest] = ok
+	}
+	return out, firstErr
+}
+
 // NullStore always reports a miss.
 type NullStore struct{}
 
 func (NullStore) Has(_ context.Context, _ artifact.ID) (bool, error) { return false, nil }
 
+func (NullStore) HasBatch(_ context.Context, _ []artifact.ID) (map[string]bool, error) {
+	return map[string]bool{}, nil
+}
+
 // MemoryStore is a thread-safe in-memory store useful for tests.
 type MemoryStore struct {
 	mu    sync.RWMutex
@@ -34,6 +64,17 @@ func (m *MemoryStore) Has(_ context.Context, id artifact.ID) (bool, error) {
 	return ok, nil
 }
 
+func (m *MemoryStore) HasBatch(_ context.Context, ids []artifact.ID) (map[string]bool, error) {
+	out := make(map[string]bool, len(ids))
+	m.mu.RLock()
+	for _, id := range ids {
+		_, ok := m.items[id.Digest]
+		out[id.Digest] = ok
+	}
+	m.mu.RUnlock()
+	return out, nil
+}
+
 // Add inserts an artifact digest for testing.
 func (m *MemoryStore) Add(id artifact.ID) {
 	m.mu.Lock()
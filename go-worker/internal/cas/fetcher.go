@@ -2,6 +2,9 @@ package cas
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -20,6 +23,8 @@ type Fetcher struct {
 	Username string
 	Password string
 	Client   *http.Client
+	// Tokens caches bearer tokens for token-auth registries (shared default when nil).
+	Tokens *TokenCache
 }
 
 func (f Fetcher) client() *http.Client {
@@ -29,8 +34,18 @@ func (f Fetcher) client() *http.Client {
 	return &http.Client{Timeout: 20 * time.Second}
 }
 
+// ErrDigestMismatch reports that a fetched blob did not hash to its digest.
+var ErrDigestMismatch = errors.New("digest mismatch")
+
+// fetchAttempts bounds downloads of a blob whose content fails verification.
+const fetchAttempts = 2
+
 // Fetch downloads the blob for the given artifact digest into destPath.
 // Assumes registry supports /v2/<repo>/blobs/<digest>.
+// Well-formed sha256 digests are verified; a corrupt download is deleted and fetched
+// once more before Fetch gives up with ErrDigestMismatch. Interrupted
+// transfers resume from the bytes already on disk when the registry
+// supports Range requests.
 func (f Fetcher) Fetch(ctx context.Context, id artifact.ID, destPath string) error {
 	if f.BaseURL == "" || id.Digest == "" {
 		return fmt.Errorf("missing base URL or digest")
@@ -40,29 +55,130 @@ func (f Fetcher) Fetch(ctx context.Context, id artifact.ID, destPath string) err
 		repo = "artifacts"
 	}
 	url := fmt.Sprintf("%s/v2/%s/blobs/%s", strings.TrimRight(f.BaseURL, "/"), repo, id.Digest)
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return err
+	verify := isSHA256Digest(id.Digest)
+	var actual string
+	for attempt := 1; attempt <= fetchAttempts; attempt++ {
+		var err error
+		actual, err = f.downloadResumable(ctx, url, id, destPath)
+		if err != nil {
+			return err
+		}
+		if !verify || actual == id.Digest {
+			return nil
+		}
+		_ = os.Remove(destPath)
+	}
+	return fmt.Errorf("fetch %s: %w (got %s)", id.Digest, ErrDigestMismatch, actual)
+}
+
+// errInterrupted marks a transfer that broke off mid-body; the partial file
+// is kept so the next attempt can resume it.
+var errInterrupted = errors.New("download interrupted")
+
+// resumeAttempts bounds how many times one download resumes after interruption.
+const resumeAttempts = 3
+
+// downloadResumable downloads the blob, resuming interrupted transfers.
+func (f Fetcher) downloadResumable(ctx context.Context, url string, id artifact.ID, destPath string) (string, error) {
+	var err error
+	for attempt := 1; attempt <= resumeAttempts; attempt++ {
+		var actual string
+		actual, err = f.download(ctx, url, id, destPath)
+		if !errors.Is(err, errInterrupted) {
+			return actual, err
+		}
+	}
+	return "", err
+}
+
+// download writes the blob to destPath and returns its sha256 digest.
+// Bytes land in destPath+".partial" first; when that file exists the
+// remainder is requested with a Range header and appended. Servers that
+// ignore or reject the range get a full download instead.
+func (f Fetcher) download(ctx context.Context, url string, id artifact.ID, destPath string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(destPath), 0o755); err != nil {
+		return "", err
 	}
-	if f.Username != "" || f.Password != "" {
-		req.SetBasicAuth(f.Username, f.Password)
+	partial := destPath + ".partial"
+	var offset int64
+	if info, err := os.Stat(partial); err == nil {
+		offset = info.Size()
 	}
-	resp, err := f.client().Do(req)
+	resp, err := f.requestBlob(ctx, url, offset)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("fetch %s: unexpected status %d", id.Digest, resp.StatusCode)
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0 && strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)):
+	case resp.StatusCode == http.StatusOK:
+		offset = 0
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable || resp.StatusCode == http.StatusPartialContent:
+		resp.Body.Close()
+		_ = os.Remove(partial)
+		offset = 0
+		resp, err = f.requestBlob(ctx, url, 0)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("fetch %s: unexpected status %d", id.Digest, resp.StatusCode)
+		}
+	default:
+		return "", fmt.Errorf("fetch %s: unexpected status %d", id.Digest, resp.StatusCode)
 	}
-	if err := os.MkdirAll(filepath.Dir(destPath), 0o755); err != nil {
-		return err
+
+	h := sha256.New()
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		existing, err := os.Open(partial)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, io.LimitReader(existing, offset))
+		existing.Close()
+		if err != nil {
+			return "", err
+		}
+		flags = os.O_WRONLY | os.O_APPEND
 	}
-	out, err := os.Create(destPath)
+	out, err := os.OpenFile(partial, flags, 0o644)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if _, err := io.Copy(io.MultiWriter(out, h), resp.Body); err != nil {
+		out.Close()
+		return "", fmt.Errorf("fetch %s: %w: %v", id.Digest, errInterrupted, err)
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(partial, destPath); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (f Fetcher) requestBlob(ctx context.Context, url string, offset int64) (*http.Response, error) {
+	auth := newRegistryAuth(f.Username, f.Password, f.client(), f.Tokens)
+	return auth.do(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+		return req, nil
+	})
+}
+
+func isSHA256Digest(d string) bool {
+	hexPart, ok := strings.CutPrefix(d, "sha256:")
+	if !ok || len(hexPart) != sha256.Size*2 {
+		return false
 	}
-	defer out.Close()
-	_, err = io.Copy(out, resp.Body)
-	return err
+	_, err := hex.DecodeString(hexPart)
+	return err == nil
 }
@@ -2,10 +2,16 @@ package cas
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/artifact"
@@ -36,3 +42,124 @@ func TestFetcherFetchesBlob(t *testing.T) {
 		t.Fatalf("unexpected contents: %s", string(data))
 	}
 }
+
+func TestFetcherRetriesCorruptDownload(t *testing.T) {
+	good := []byte("runtime tarball")
+	sum := sha256.Sum256(good)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			_, _ = w.Write([]byte("runtime tarbalX"))
+			return
+		}
+		_, _ = w.Write(good)
+	}))
+	defer ts.Close()
+
+	f := Fetcher{BaseURL: ts.URL}
+	dest := filepath.Join(t.TempDir(), "rt.tar")
+	id := artifact.ID{Type: artifact.RuntimeType, Digest: digest}
+	if err := f.Fetch(context.Background(), id, dest); err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected one retry after corruption, got %d calls", calls)
+	}
+	data, _ := os.ReadFile(dest)
+	if string(data) != string(good) {
+		t.Fatalf("unexpected contents: %q", data)
+	}
+}
+
+func TestFetcherRemovesPersistentlyCorruptBlob(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("always wrong"))
+	}))
+	defer ts.Close()
+
+	f := Fetcher{BaseURL: ts.URL}
+	dest := filepath.Join(t.TempDir(), "rt.tar")
+	id := artifact.ID{Type: artifact.RuntimeType, Digest: "sha256:" + strings.Repeat("0", 64)}
+	err := f.Fetch(context.Background(), id, dest)
+	if !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("expected ErrDigestMismatch, got %v", err)
+	}
+	if _, statErr := os.Stat(dest); !os.IsNotExist(statErr) {
+		t.Fatalf("expected corrupt file to be removed, stat err=%v", statErr)
+	}
+}
+
+func TestFetcherResumesInterruptedDownload(t *testing.T) {
+	blob := []byte(strings.Repeat("runtime-bytes-", 512))
+	sum := sha256.Sum256(blob)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	half := len(blob) / 2
+	var ranges []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rng := r.Header.Get("Range")
+		ranges = append(ranges, rng)
+		if rng == "" {
+			// Promise the full body, send half, then drop the connection.
+			w.Header().Set("Content-Length", strconv.Itoa(len(blob)))
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(blob[:half])
+			w.(http.Flusher).Flush()
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		var start int
+		if _, err := fmt.Sscanf(rng, "bytes=%d-", &start); err != nil {
+			http.Error(w, "bad range", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(blob)-1, len(blob)))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(blob[start:])
+	}))
+	defer ts.Close()
+
+	f := Fetcher{BaseURL: ts.URL}
+	dest := filepath.Join(t.TempDir(), "rt.tar")
+	id := artifact.ID{Type: artifact.RuntimeType, Digest: digest}
+	if err := f.Fetch(context.Background(), id, dest); err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	if len(ranges) != 2 || ranges[1] != fmt.Sprintf("bytes=%d-", half) {
+		t.Fatalf("expected a resumed range request, got %v", ranges)
+	}
+	data, _ := os.ReadFile(dest)
+	if string(data) != string(blob) {
+		t.Fatalf("resumed blob does not match")
+	}
+	if _, err := os.Stat(dest + ".partial"); !os.IsNotExist(err) {
+		t.Fatalf("expected partial file to be renamed, stat err=%v", err)
+	}
+}
+
+func TestFetcherFallsBackWhenRangeUnsupported(t *testing.T) {
+	blob := []byte("full blob contents")
+	sum := sha256.Sum256(blob)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(blob)
+	}))
+	defer ts.Close()
+
+	dest := filepath.Join(t.TempDir(), "rt.tar")
+	if err := os.WriteFile(dest+".partial", blob[:4], 0o644); err != nil {
+		t.Fatalf("seed partial: %v", err)
+	}
+	f := Fetcher{BaseURL: ts.URL}
+	id := artifact.ID{Type: artifact.RuntimeType, Digest: "sha256:" + hex.EncodeToString(sum[:])}
+	if err := f.Fetch(context.Background(), id, dest); err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	data, _ := os.ReadFile(dest)
+	if string(data) != string(blob) {
+		t.Fatalf("unexpected contents %q", data)
+	}
+}
@@ -18,6 +18,8 @@ type Pusher struct {
 	Username string
 	Password string
 	Client   *http.Client
+	// Tokens caches bearer tokens for token-auth registries (shared default when nil).
+	Tokens *TokenCache
 }
 
 func (p Pusher) client() *http.Client {
@@ -37,14 +39,10 @@ func (p Pusher) Push(ctx context.Context, id artifact.ID, content []byte, mediaT
 		repo = "artifacts"
 	}
 	initURL := fmt.Sprintf("%s/v2/%s/blobs/uploads/", strings.TrimRight(p.BaseURL, "/"), repo)
-	initReq, err := http.NewRequestWithContext(ctx, http.MethodPost, initURL, nil)
-	if err != nil {
-		return "", err
-	}
-	if p.Username != "" || p.Password != "" {
-		initReq.SetBasicAuth(p.Username, p.Password)
-	}
-	initResp, err := p.client().Do(initReq)
+	auth := newRegistryAuth(p.Username, p.Password, p.client(), p.Tokens)
+	initResp, err := auth.do(ctx, func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodPost, initURL, nil)
+	})
 	if err != nil {
 		return "", err
 	}
@@ -66,17 +64,16 @@ func (p Pusher) Push(ctx context.Context, id artifact.ID, content []byte, mediaT
 	} else {
 		putURL = uploadURL + "?digest=" + id.Digest
 	}
-	putReq, err := http.NewRequestWithContext(ctx, http.MethodPut, putURL, bytes.NewReader(content))
-	if err != nil {
-		return "", err
-	}
-	if mediaType != "" {
-		putReq.Header.Set("Content-Type", mediaType)
-	}
-	if p.Username != "" || p.Password != "" {
-		putReq.SetBasicAuth(p.Username, p.Password)
-	}
-	putResp, err := p.client().Do(putReq)
+	putResp, err := auth.do(ctx, func() (*http.Request, error) {
+		putReq, err := http.NewRequestWithContext(ctx, http.MethodPut, putURL, bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		if mediaType != "" {
+			putReq.Header.Set("Content-Type", mediaType)
+		}
+		return putReq, nil
+	})
 	if err != nil {
 		return "", err
 	}
@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/artifact"
+	"golang.org/x/sync/errgroup"
 )
 
 // ZotStore checks an OCI registry (e.g., Zot) for artifacts.
@@ -18,6 +20,8 @@ type ZotStore struct {
 	Username string
 	Password string
 	Client   *http.Client
+	// Tokens caches bearer tokens for token-auth registries (shared default when nil).
+	Tokens *TokenCache
 }
 
 func (z ZotStore) client() *http.Client {
@@ -37,15 +41,15 @@ func (z ZotStore) Has(ctx context.Context, id artifact.ID) (bool, error) {
 		repo = "artifacts"
 	}
 	url := fmt.Sprintf("%s/v2/%s/manifests/%s", strings.TrimRight(z.BaseURL, "/"), repo, id.Digest)
-	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
-	if err != nil {
-		return false, err
-	}
-	req.Header.Set("Accept", "application/vnd.oci.image.manifest.v1+json")
-	if z.Username != "" || z.Password != "" {
-		req.SetBasicAuth(z.Username, z.Password)
-	}
-	resp, err := z.client().Do(req)
+	auth := newRegistryAuth(z.Username, z.Password, z.client(), z.Tokens)
+	resp, err := auth.do(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/vnd.oci.image.manifest.v1+json")
+		return req, nil
+	})
 	if err != nil {
 		return false, err
 	}
@@ -59,3 +63,38 @@ func (z ZotStore) Has(ctx context.Context, id artifact.ID) (bool, error) {
 		return false, fmt.Errorf("zot store unexpected status %d for %s", resp.StatusCode, url)
 	}
 }
+
+// zotBatchConcurrency bounds parallel HEAD requests in HasBatch.
+const zotBatchConcurrency = 8
+
+// HasBatch checks many digests with bounded parallel HEAD requests; the OCI
+// distribution API has no bulk existence endpoint. Digests that fail to
+// resolve are reported missing and the first error is returned.
+func (z ZotStore) HasBatch(ctx context.Context, ids []artifact.ID) (map[string]bool, error) {
+	out := make(map[string]bool, len(ids))
+	var (
+		mu       sync.Mutex
+		firstErr error
+	)
+	var g errgroup.Group
+	g.SetLimit(zotBatchConcurrency)
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id.Digest] {
+			continue
+		}
+		seen[id.Digest] = true
+		g.Go(func() error {
+			ok, err := z.Has(ctx, id)
+			mu.Lock()
+			defer mu.Unlock()
+			out[id.Digest] = ok
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+	return out, firstErr
+}
@@ -48,3 +48,28 @@ func TestZotStoreHas(t *testing.T) {
 		t.Fatalf("expected missing to be false")
 	}
 }
+
+func TestZotStoreHasBatch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/artifacts/manifests/sha256:present" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	store := ZotStore{BaseURL: ts.URL}
+	ids := []artifact.ID{
+		{Type: artifact.WheelType, Digest: "sha256:present"},
+		{Type: artifact.WheelType, Digest: "sha256:missing"},
+		{Type: artifact.WheelType, Digest: "sha256:present"},
+	}
+	got, err := HasBatch(context.Background(), store, ids)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 2 || !got["sha256:present"] || got["sha256:missing"] {
+		t.Fatalf("unexpected batch result: %v", got)
+	}
+}
@@ -0,0 +1,104 @@
+// Package compat decides whether an existing wheel installs on a target
+// python tag and platform.
+//
+// The control plane carries an identical copy in its own module; keep the
+// two in sync.
+package compat
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/platform"
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/wheelname"
+)
+
+// Check reports whether w can be reused for the target python and platform,
+// and when it cannot, which tag ruled it out. Compressed tag sets (cp39.cp310,
+// manylinux1_s390x.manylinux2014_s390x) match when any member does. Platform
+// matching goes through platform.Compatible, so manylinux and musllinux tags
+// each match their own family only. A CPython ABI (cp311) needs the exact
+// target, abi3 wheels are reusable on any CPython 3 at or above the version
+// in their python tag, and ABI-less wheels need a py3 or exact python tag.
+func Check(w wheelname.Wheel, targetPy, targetPlatform string) (bool, string) {
+	abiOK, abi3 := false, true
+	pyOK := false
+	for _, abi := range w.AbiTags() {
+		switch {
+		case abi == "abi3":
+		case abi == "none", strings.HasPrefix(abi, "cp3"):
+			abi3 = false
+		default:
+			abi3 = false
+			continue
+		}
+		abiOK = true
+		for _, py := range w.PythonTags() {
+			if tagsCompatible(py, abi, targetPy) {
+				pyOK = true
+			}
+		}
+	}
+	platOK := platform.Compatible(w.PlatformTag, targetPlatform)
+	for _, plat := range w.PlatformTags() {
+		if plat == "any" || plat == targetPlatform {
+			platOK = true
+		}
+	}
+	switch {
+	case !abiOK:
+		return false, fmt.Sprintf("abi tag %s is not supported", w.AbiTag)
+	case !pyOK && abi3:
+		return false, fmt.Sprintf("abi3 wheel for %s does not load on %s", w.PythonTag, targetPy)
+	case !pyOK:
+		return false, fmt.Sprintf("python tag %s does not match %s", w.PythonTag, targetPy)
+	case !platOK:
+		return false, fmt.Sprintf("platform tag %s does not match %s", w.PlatformTag, targetPlatform)
+	}
+	return true, ""
+}
+
+// Compatible is Check without the reason.
+func Compatible(w wheelname.Wheel, targetPy, targetPlatform string) bool {
+	ok, _ := Check(w, targetPy, targetPlatform)
+	return ok
+}
+
+// tagsCompatible reports whether one python/abi tag pair loads on targetPy.
+func tagsCompatible(py, abi, targetPy string) bool {
+	switch {
+	case abi == "abi3":
+		return abi3Compatible(py, targetPy)
+	case abi == "none":
+		return strings.HasPrefix(py, "py3") || py == targetPy
+	case strings.HasPrefix(abi, "cp3"):
+		return py == targetPy && abi == targetPy
+	}
+	return false
+}
+
+// abi3Compatible reports whether a stable-ABI wheel tagged wheelPy (e.g.
+// cp38, or a compressed set like cp38.cp39) loads on targetPy (e.g. cp311).
+func abi3Compatible(wheelPy, targetPy string) bool {
+	target, ok := cpython3Minor(targetPy)
+	if !ok {
+		return false
+	}
+	for _, tag := range strings.Split(wheelPy, ".") {
+		if floor, ok := cpython3Minor(tag); ok && floor <= target {
+			return true
+		}
+	}
+	return false
+}
+
+// cpython3Minor returns 11 for "cp311".
+func cpython3Minor(tag string) (int, bool) {
+	rest, ok := strings.CutPrefix(strings.ToLower(tag), "cp3")
+	if !ok || rest == "" {
+		return 0, false
+	}
+	minor, err := strconv.Atoi(rest)
+	return minor, err == nil
+}
@@ -93,3 +93,15 @@ func (m *MinIOStore) URL(key string) string {
 	}
 	return fmt.Sprintf("%s://%s/%s/%s", scheme, m.Endpoint, m.Bucket, key)
 }
+
+// List returns object keys under prefix.
+func (m *MinIOStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	for obj := range m.Client.ListObjects(ctx, m.Bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		keys = append(keys, obj.Key)
+	}
+	return keys, nil
+}
@@ -13,6 +13,11 @@ type Store interface {
 	URL(key string) string
 }
 
+// Lister is implemented by stores that can enumerate their objects.
+type Lister interface {
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
 // NullStore discards uploads.
 type NullStore struct{}
 
@@ -1,6 +1,11 @@
 package pack
 
 import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -15,39 +20,155 @@ type PackDef struct {
 	Name         string `json:"name" yaml:"name"`
 	Version      string `json:"version,omitempty" yaml:"version,omitempty"`
 	RecipeDigest string `json:"recipe_digest,omitempty" yaml:"recipe_digest,omitempty"`
+	// Requires lists catalog pack keys this pack depends on. When nil the
+	// planner falls back to its built-in edges; an empty list means none.
+	Requires []string `json:"requires,omitempty" yaml:"requires,omitempty"`
 	// Optional description or notes.
 	Note string `json:"note,omitempty" yaml:"note,omitempty"`
 }
 
 // Rule matches a package/build context to packs.
+//
+// PackagePattern is matched case-insensitively. A "re:" prefix selects a
+// regular expression, a pattern containing glob metacharacters (*?[) must
+// match the whole package name, and anything else is a substring match.
+// When several rules match, higher Priority rules are applied first and
+// their packs win over packs with the same name from lower-priority rules;
+// equal priorities keep catalog order. Regular expressions are compiled once,
+// when the rule is decoded from JSON or by Catalog.Compile.
+//
+// MemoryMB and CPUs cap the build container of matching packages, overriding
+// the runner's global limits; the first matching rule in priority order that
+// sets one wins. A rule may set limits without listing packs.
 type Rule struct {
-	PackagePattern string   `json:"package_pattern" yaml:"package_pattern"` // substring/prefix match for now
+	PackagePattern string   `json:"package_pattern" yaml:"package_pattern"`
 	Backend        string   `json:"backend,omitempty" yaml:"backend,omitempty"`
 	Packs          []string `json:"packs" yaml:"packs"`
+	Priority       int      `json:"priority,omitempty" yaml:"priority,omitempty"`
+	MemoryMB       int      `json:"memory_mb,omitempty" yaml:"memory_mb,omitempty"`
+	CPUs           float64  `json:"cpus,omitempty" yaml:"cpus,omitempty"`
 	Note           string   `json:"note,omitempty" yaml:"note,omitempty"`
+
+	re *regexp.Regexp
 }
 
-// Select returns packs for a package/backed based on simple pattern match.
-func (c Catalog) Select(pkg string, backend string) []PackDef {
-	var out []PackDef
-	seen := make(map[string]struct{})
+// UnmarshalJSON decodes the rule and compiles its package pattern, so a
+// catalog with an invalid pattern fails to load.
+func (r *Rule) UnmarshalJSON(data []byte) error {
+	type rule Rule
+	var decoded rule
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*r = Rule(decoded)
+	return r.compile()
+}
+
+// compile validates the package pattern and stores the compiled regular
+// expression of a "re:" pattern on the rule.
+func (r *Rule) compile() error {
+	pattern := strings.ToLower(r.PackagePattern)
+	r.re = nil
+	switch {
+	case strings.HasPrefix(pattern, "re:"):
+		re, err := regexp.Compile(strings.TrimPrefix(pattern, "re:"))
+		if err != nil {
+			return fmt.Errorf("package_pattern %q: %w", r.PackagePattern, err)
+		}
+		r.re = re
+	case strings.ContainsAny(pattern, "*?["):
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("package_pattern %q: %w", r.PackagePattern, err)
+		}
+	}
+	return nil
+}
+
+// Compile validates and compiles the package pattern of every rule. Catalogs
+// decoded from JSON are already compiled; call it on catalogs built in code.
+func (c *Catalog) Compile() error {
+	for i := range c.Rules {
+		if err := c.Rules[i].compile(); err != nil {
+			return fmt.Errorf("rule %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the rule's package pattern matches pkg.
+// An empty pattern matches every package; an invalid pattern, or a "re:"
+// pattern that was never compiled, matches none.
+func (r Rule) Matches(pkg string) bool {
+	pattern := strings.ToLower(r.PackagePattern)
 	lpkg := strings.ToLower(pkg)
+	switch {
+	case pattern == "":
+		return true
+	case strings.HasPrefix(pattern, "re:"):
+		return r.re != nil && r.re.MatchString(lpkg)
+	case strings.ContainsAny(pattern, "*?["):
+		ok, err := path.Match(pattern, lpkg)
+		return err == nil && ok
+	default:
+		return strings.Contains(lpkg, pattern)
+	}
+}
+
+// matching returns the rules that apply to the package and backend, highest
+// priority first.
+func (c Catalog) matching(pkg string, backend string) []Rule {
 	lbackend := strings.ToLower(backend)
+	var matched []Rule
 	for _, r := range c.Rules {
-		if r.PackagePattern != "" && !strings.Contains(lpkg, strings.ToLower(r.PackagePattern)) {
+		if !r.Matches(pkg) {
 			continue
 		}
 		if r.Backend != "" && lbackend != strings.ToLower(r.Backend) {
 			continue
 		}
+		matched = append(matched, r)
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].Priority > matched[j].Priority
+	})
+	return matched
+}
+
+// Limits returns the memory (MiB) and CPU limits matching rules set for the
+// package and backend. Zero means no rule overrides the runner's limit.
+func (c Catalog) Limits(pkg string, backend string) (memoryMB int, cpus float64) {
+	for _, r := range c.matching(pkg, backend) {
+		if memoryMB == 0 && r.MemoryMB > 0 {
+			memoryMB = r.MemoryMB
+		}
+		if cpus == 0 && r.CPUs > 0 {
+			cpus = r.CPUs
+		}
+	}
+	return memoryMB, cpus
+}
+
+// Select returns the union of packs from rules matching the package and
+// backend, honoring rule priority.
+func (c Catalog) Select(pkg string, backend string) []PackDef {
+	matched := c.matching(pkg, backend)
+	var out []PackDef
+	seen := make(map[string]struct{})
+	for _, r := range matched {
 		for _, name := range r.Packs {
-			if def, ok := c.Packs[name]; ok {
-				if _, dup := seen[name]; dup {
-					continue
-				}
-				seen[name] = struct{}{}
-				out = append(out, def)
+			def, ok := c.Packs[name]
+			if !ok {
+				continue
+			}
+			packName := def.Name
+			if packName == "" {
+				packName = name
+			}
+			if _, dup := seen[packName]; dup {
+				continue
 			}
+			seen[packName] = struct{}{}
+			out = append(out, def)
 		}
 	}
 	return out
@@ -1,7 +1,9 @@
 package pack
 
 import (
+	"encoding/json"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -70,3 +72,101 @@ func packNames(defs []PackDef) []string {
 	}
 	return names
 }
+
+func TestCatalogSelectPriorityAndWildcards(t *testing.T) {
+	cat := Catalog{
+		Packs: map[string]PackDef{
+			"openssl-1.1": {Name: "openssl", Version: "1.1"},
+			"openssl-3":   {Name: "openssl", Version: "3.0"},
+			"zlib":        {Name: "zlib", Version: "1.3"},
+			"rust":        {Name: "rust", Version: "1.76"},
+		},
+		Rules: []Rule{
+			{PackagePattern: "*", Packs: []string{"openssl-1.1", "zlib"}},
+			{PackagePattern: "cryptography", Priority: 10, Packs: []string{"openssl-3"}},
+			{PackagePattern: "re:^(orjson|pydantic-core)$", Priority: 5, Packs: []string{"rust"}},
+		},
+	}
+	if err := cat.Compile(); err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		pkg      string
+		expected []PackDef
+	}{
+		{
+			name:     "specific rule overrides wildcard pack",
+			pkg:      "cryptography",
+			expected: []PackDef{{Name: "openssl", Version: "3.0"}, {Name: "zlib", Version: "1.3"}},
+		},
+		{
+			name:     "wildcard default applies alone",
+			pkg:      "lxml",
+			expected: []PackDef{{Name: "openssl", Version: "1.1"}, {Name: "zlib", Version: "1.3"}},
+		},
+		{
+			name:     "regex rule unions with default",
+			pkg:      "Pydantic-Core",
+			expected: []PackDef{{Name: "rust", Version: "1.76"}, {Name: "openssl", Version: "1.1"}, {Name: "zlib", Version: "1.3"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cat.Select(tt.pkg, "")
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Fatalf("Select(%q)=%v, expected %v", tt.pkg, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRuleMatchesGlob(t *testing.T) {
+	r := Rule{PackagePattern: "py*-core"}
+	if !r.Matches("pydantic-core") {
+		t.Fatalf("expected glob to match pydantic-core")
+	}
+	if r.Matches("pydantic-core-extra") {
+		t.Fatalf("glob should match the whole name")
+	}
+}
+
+func TestCatalogCompilesPatternsOnLoad(t *testing.T) {
+	var cat Catalog
+	if err := json.Unmarshal([]byte(`{"rules":[{"package_pattern":"re:^orjson$","packs":["rust"]}]}`), &cat); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if cat.Rules[0].re == nil || !cat.Rules[0].Matches("ORJSON") {
+		t.Fatalf("expected decoded regex rule to be compiled and match")
+	}
+	if err := json.Unmarshal([]byte(`{"rules":[{"package_pattern":"re:(unclosed"}]}`), &cat); err == nil {
+		t.Fatalf("expected invalid regex to fail the load")
+	}
+	bad := Catalog{Rules: []Rule{{PackagePattern: "*"}, {PackagePattern: "py[-core"}}}
+	if err := bad.Compile(); err == nil || !strings.Contains(err.Error(), "rule 1") {
+		t.Fatalf("expected invalid glob in rule 1 to fail, got %v", err)
+	}
+	if (Rule{PackagePattern: "re:^orjson$"}).Matches("orjson") {
+		t.Fatalf("uncompiled regex rule should not match")
+	}
+}
+
+func TestCatalogLimitsFollowRulePriority(t *testing.T) {
+	cat := Catalog{
+		Rules: []Rule{
+			{PackagePattern: "*", MemoryMB: 2048, CPUs: 1},
+			{PackagePattern: "torch", Priority: 10, MemoryMB: 16384},
+			{PackagePattern: "torch", Backend: "docker", Priority: 20, MemoryMB: 32768, CPUs: 8},
+		},
+	}
+	if mem, cpus := cat.Limits("torch", ""); mem != 16384 || cpus != 1 {
+		t.Fatalf("expected torch memory from its rule and cpus from the fallback, got %d/%v", mem, cpus)
+	}
+	if mem, cpus := cat.Limits("six", ""); mem != 2048 || cpus != 1 {
+		t.Fatalf("expected fallback limits, got %d/%v", mem, cpus)
+	}
+	if mem, cpus := (Catalog{}).Limits("six", ""); mem != 0 || cpus != 0 {
+		t.Fatalf("expected no limits without rules, got %d/%v", mem, cpus)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/platform"
 )
 
 // Hint represents a hint catalog entry from the control-plane.
@@ -143,7 +145,7 @@ func matchHint(h Hint, ctx hintContext) (HintMatch, []RecipeMatch, bool) {
 	}
 	pyVersion := strings.TrimSpace(ctx.PythonVersion)
 	if pyVersion == "" {
-		pyVersion = pythonVersionFromTag(ctx.PythonTag)
+		pyVersion = PythonVersionFromTag(ctx.PythonTag)
 	}
 	platform := platformFamily(ctx.PlatformTag)
 	arch := archFromPlatformTag(ctx.PlatformTag)
@@ -232,7 +234,7 @@ func hintAppliesTo(h Hint, ctx hintContext) (bool, []string) {
 	pkg := normalizeName(ctx.Package)
 	pyVersion := strings.TrimSpace(ctx.PythonVersion)
 	if pyVersion == "" {
-		pyVersion = pythonVersionFromTag(ctx.PythonTag)
+		pyVersion = PythonVersionFromTag(ctx.PythonTag)
 	}
 	platform := platformFamily(ctx.PlatformTag)
 	arch := archFromPlatformTag(ctx.PlatformTag)
@@ -469,6 +471,9 @@ func archFromPlatformTag(platformTag string) string {
 	if platformTag == "" {
 		return ""
 	}
+	if arch := platform.Arch(platformTag); arch != "" {
+		return arch
+	}
 	parts := strings.Split(strings.ToLower(platformTag), "_")
 	if len(parts) == 0 {
 		return ""
@@ -476,16 +481,32 @@ func archFromPlatformTag(platformTag string) string {
 	return parts[len(parts)-1]
 }
 
-func pythonVersionFromTag(tag string) string {
+// PythonVersionFromTag turns a python tag into a dotted version: cp38 ->
+// 3.8, cp313 -> 3.13, py311 -> 3.11, py3 -> 3. The first digit is the major
+// version and the rest the minor. Unparseable tags return "".
+func PythonVersionFromTag(tag string) string {
 	tag = strings.ToLower(strings.TrimSpace(tag))
-	if strings.HasPrefix(tag, "cp") && len(tag) >= 4 {
-		rest := strings.TrimPrefix(tag, "cp")
-		if len(rest) >= 2 {
-			return fmt.Sprintf("%s.%s", rest[:1], rest[1:2])
+	var digits string
+	minLen := 2
+	if rest, ok := strings.CutPrefix(tag, "cp"); ok {
+		digits = rest
+	} else if rest, ok := strings.CutPrefix(tag, "py"); ok {
+		digits, minLen = rest, 1
+	}
+	if len(digits) < minLen || len(digits) > 4 {
+		return ""
+	}
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return ""
 		}
 	}
-	if strings.HasPrefix(tag, "py") && len(tag) > 2 {
-		return strings.TrimPrefix(tag, "py")
+	major, minor := digits[:1], digits[1:]
+	if major == "0" || (len(minor) > 1 && minor[0] == '0') {
+		return ""
+	}
+	if minor == "" {
+		return major
 	}
-	return ""
+	return major + "." + minor
 }
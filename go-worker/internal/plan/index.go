@@ -3,10 +3,12 @@ package plan
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -15,6 +17,19 @@ func basicAuth(user, pass string) string {
 	return base64.StdEncoding.EncodeToString([]byte(auth))
 }
 
+// ErrIndexUnavailable reports that an index kept failing with transient
+// errors (5xx or network) after all retries. Callers should fail the plan
+// instead of falling back to "latest".
+var ErrIndexUnavailable = errors.New("index unavailable")
+
+// errIndexTransient marks 5xx responses and network errors, which are retried.
+var errIndexTransient = errors.New("transient index error")
+
+const (
+	defaultIndexAttempts = 3
+	defaultIndexBackoff  = 250 * time.Millisecond
+)
+
 // IndexClient resolves package metadata from configured indexes.
 // This is a minimal client used to fetch the latest version when pins are missing.
 type IndexClient struct {
@@ -23,15 +38,56 @@ type IndexClient struct {
 	HTTPClient    *http.Client
 	Username      string
 	Password      string
+	// MaxAttempts bounds requests per index for transient failures (default 3).
+	MaxAttempts int
+	// Backoff is the base delay between attempts, scaled by attempt number (default 250ms).
+	Backoff time.Duration
+
+	// cache memoizes lookups by package name. A client is built per plan
+	// generation, so entries never outlive a single plan run.
+	mu    sync.Mutex
+	cache map[string]indexLookup
+}
+
+type indexLookup struct {
+	version string
+	err     error
 }
 
 // ResolveLatest returns a best-effort latest version string for the package.
+// Transient failures are retried with backoff; if every index is exhausted
+// without an answer the error wraps ErrIndexUnavailable.
 func (c *IndexClient) ResolveLatest(name string) (string, error) {
+	c.mu.Lock()
+	if hit, ok := c.cache[name]; ok {
+		c.mu.Unlock()
+		return hit.version, hit.err
+	}
+	c.mu.Unlock()
+	ver, err := c.resolveLatest(name)
+	if errors.Is(err, ErrIndexUnavailable) {
+		// Transient outages are not cached; the plan fails anyway.
+		return ver, err
+	}
+	c.mu.Lock()
+	if c.cache == nil {
+		c.cache = make(map[string]indexLookup)
+	}
+	c.cache[name] = indexLookup{version: ver, err: err}
+	c.mu.Unlock()
+	return ver, err
+}
+
+func (c *IndexClient) resolveLatest(name string) (string, error) {
 	client := c.http()
 	var errs []string
+	unavailable := false
 	for _, base := range c.indexes() {
-		ver, err := c.fetchLatest(client, base, name)
+		ver, err := c.fetchLatestWithRetry(client, base, name)
 		if err != nil {
+			if errors.Is(err, errIndexTransient) {
+				unavailable = true
+			}
 			errs = append(errs, fmt.Sprintf("%s: %v", base, err))
 			continue
 		}
@@ -40,9 +96,35 @@ func (c *IndexClient) ResolveLatest(name string) (string, error) {
 		}
 		errs = append(errs, fmt.Sprintf("%s: empty response", base))
 	}
+	if unavailable {
+		return "", fmt.Errorf("%w: resolve %s (%s)", ErrIndexUnavailable, name, strings.Join(errs, "; "))
+	}
 	return "", fmt.Errorf("version not found for %s (%s)", name, strings.Join(errs, "; "))
 }
 
+func (c *IndexClient) fetchLatestWithRetry(client *http.Client, base, name string) (string, error) {
+	attempts := c.MaxAttempts
+	if attempts <= 0 {
+		attempts = defaultIndexAttempts
+	}
+	backoff := c.Backoff
+	if backoff <= 0 {
+		backoff = defaultIndexBackoff
+	}
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		var ver string
+		ver, err = c.fetchLatest(client, base, name)
+		if err == nil || !errors.Is(err, errIndexTransient) {
+			return ver, err
+		}
+		if attempt < attempts {
+			time.Sleep(backoff * time.Duration(attempt))
+		}
+	}
+	return "", fmt.Errorf("after %d attempts: %w", attempts, err)
+}
+
 func (c *IndexClient) indexes() []string {
 	out := []string{}
 	if c.BaseURL != "" {
@@ -82,9 +164,12 @@ func (c *IndexClient) fetchLatest(client *http.Client, base, name string) (strin
 		req.Header = headers
 		resp, err := client.Do(req)
 		if err != nil {
-			return "", fmt.Errorf("get %s: %w", api, err)
+			return "", fmt.Errorf("get %s: %w: %w", api, errIndexTransient, err)
 		}
 		defer resp.Body.Close()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return "", fmt.Errorf("get %s: %w: status %d", api, errIndexTransient, resp.StatusCode)
+		}
 		if resp.StatusCode != http.StatusOK {
 			return "", fmt.Errorf("get %s: status %d", api, resp.StatusCode)
 		}
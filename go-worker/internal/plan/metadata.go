@@ -0,0 +1,192 @@
+package plan
+
+import (
+	"archive/zip"
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// WheelMetadata holds the METADATA header fields the planner uses.
+type WheelMetadata struct {
+	Name           string
+	Version        string
+	RequiresPython string
+	ProvidesExtra  []string
+	Requires       []DepSpec
+}
+
+// ParseWheelMetadata parses a wheel METADATA document. Only the header block
+// is read; the long description after the first blank line is ignored.
+func ParseWheelMetadata(meta string) WheelMetadata {
+	header := meta
+	if idx := strings.Index(header, "\n\n"); idx != -1 {
+		header = header[:idx]
+	}
+	var md WheelMetadata
+	for _, line := range strings.Split(header, "\n") {
+		key, val, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		val = strings.TrimSpace(val)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "name":
+			md.Name = val
+		case "version":
+			md.Version = val
+		case "requires-python":
+			md.RequiresPython = val
+		case "provides-extra":
+			if val != "" {
+				md.ProvidesExtra = append(md.ProvidesExtra, val)
+			}
+		}
+	}
+	md.Requires = parseRequiresDist(header)
+	return md
+}
+
+// readWheelMetadata extracts METADATA fields from inside a wheel.
+func readWheelMetadata(wheelPath string) (WheelMetadata, error) {
+	zr, err := zip.OpenReader(wheelPath)
+	if err != nil {
+		return WheelMetadata{}, err
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if !strings.HasSuffix(f.Name, "METADATA") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			continue
+		}
+		buf := new(bytes.Buffer)
+		_, _ = buf.ReadFrom(rc)
+		rc.Close()
+		return ParseWheelMetadata(buf.String()), nil
+	}
+	return WheelMetadata{}, nil
+}
+
+// RequiresPythonAllows reports whether pythonVersion (e.g. "3.11" or "cp311")
+// satisfies a Requires-Python specifier set such as ">=3.9,!=3.10.*".
+// Clauses it cannot parse are treated as satisfied so odd metadata never
+// hides a wheel the target could use.
+func RequiresPythonAllows(spec, pythonVersion string) bool {
+	target := parsePyVersion(pythonVersion)
+	if len(target) == 0 {
+		return true
+	}
+	for _, clause := range strings.Split(spec, ",") {
+		clause = strings.TrimSpace(clause)
+		if clause == "" {
+			continue
+		}
+		op := ""
+		for _, candidate := range []string{"~=", "==", "!=", ">=", "<=", ">", "<"} {
+			if strings.HasPrefix(clause, candidate) {
+				op = candidate
+				break
+			}
+		}
+		if op == "" {
+			continue
+		}
+		raw := strings.TrimSpace(strings.TrimPrefix(clause, op))
+		wildcard := strings.HasSuffix(raw, ".*")
+		want := parseVersionParts(strings.TrimSuffix(raw, ".*"))
+		if len(want) == 0 {
+			continue
+		}
+		if wildcard && (op == "==" || op == "!=") {
+			match := prefixMatch(target, want)
+			if (op == "==") != match {
+				return false
+			}
+			continue
+		}
+		cmp := compareVersionParts(target, want)
+		ok := true
+		switch op {
+		case "==":
+			ok = cmp == 0
+		case "!=":
+			ok = cmp != 0
+		case ">=":
+			ok = cmp >= 0
+		case "<=":
+			ok = cmp <= 0
+		case ">":
+			ok = cmp > 0
+		case "<":
+			ok = cmp < 0
+		case "~=":
+			// ~=3.9 means >=3.9,==3.*; ~=3.9.1 means >=3.9.1,==3.9.*
+			ok = cmp >= 0 && (len(want) < 2 || prefixMatch(target, want[:len(want)-1]))
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// parsePyVersion accepts "3.11", "3.11.4", "cp311", or "311".
+func parsePyVersion(v string) []int {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "cp")
+	if !strings.Contains(v, ".") && len(v) >= 2 {
+		v = v[:1] + "." + v[1:]
+	}
+	return parseVersionParts(v)
+}
+
+func parseVersionParts(v string) []int {
+	var out []int
+	for _, p := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return nil
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+// compareVersionParts treats missing components as zero, so a target of 3.11
+// equals a bound of 3.11.0.
+func compareVersionParts(a, b []int) int {
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func prefixMatch(v, prefix []int) bool {
+	for i, p := range prefix {
+		if i >= len(v) {
+			return p == 0
+		}
+		if v[i] != p {
+			return false
+		}
+	}
+	return true
+}
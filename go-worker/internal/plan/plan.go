@@ -1,42 +1,55 @@
 package plan
 
 import (
-	"archive/zip"
-	"bytes"
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/artifact"
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/cas"
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/compat"
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/pack"
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/platform"
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/wheelname"
 	"log"
-	"math/rand"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"golang.org/x/sync/errgroup"
 )
 
 // FlatNode represents a legacy plan entry.
 type FlatNode struct {
-	Name          string `json:"name"`
-	Version       string `json:"version"`
-	PythonVersion string `json:"python_version,omitempty"`
-	PythonTag     string `json:"python_tag"`
-	PlatformTag   string `json:"platform_tag"`
-	Action        string `json:"action"`
+	Name          string        `json:"name"`
+	Version       string        `json:"version"`
+	PythonVersion string        `json:"python_version,omitempty"`
+	PythonTag     string        `json:"python_tag"`
+	PlatformTag   string        `json:"platform_tag"`
+	Action        string        `json:"action"`
 	Hints         []HintMatch   `json:"hints,omitempty"`
 	Recipes       []RecipeMatch `json:"recipes,omitempty"`
+	Hashes        []string      `json:"hashes,omitempty"`
+	// MemoryMB and CPUs override the runner's global limits for this
+	// package, from the pack catalog's rules; zero falls back to
+	// RUNNER_MEMORY/RUNNER_CPUS.
+	MemoryMB int     `json:"memory_mb,omitempty"`
+	CPUs     float64 `json:"cpus,omitempty"`
 }
 
 // Snapshot is the structure stored in plan.json.
 type Snapshot struct {
 	RunID string     `json:"run_id"`
+	Arch  string     `json:"arch,omitempty"`
 	Plan  []FlatNode `json:"plan"`
 	// DAG will carry richer artifact nodes when populated by the planner (optional for now).
 	DAG []DAGNode `json:"dag,omitempty"`
@@ -57,11 +70,35 @@ type Options struct {
 	IndexPassword    string
 	UpgradeStrategy  string // pinned (default) or eager
 	MaxDeps          int    // safety cap for dependency expansion
+	MaxPlanNodes     int    // cap on emitted build nodes; 0 is uncapped
 	PackageOverrides map[string]string
 	RequirementsPath string
 	ConstraintsPath  string
 	PackCatalog      *pack.Catalog
 	ArtifactStore    cas.Store
+
+	// ResolveConcurrency bounds parallel index lookups (default 8; 1 disables prefetching).
+	ResolveConcurrency int
+	// ReuseOnly emits only reuse nodes for compatible input wheels: no build
+	// nodes, dependency expansion, or runtime/pack/repair subtrees.
+	ReuseOnly bool
+	// ForceRebuild names packages whose wheels, repairs, and packs are
+	// planned as build even when the CAS already has them, e.g. after a
+	// recipe change. "*" forces every artifact in the plan.
+	ForceRebuild []string
+	// Arch is the target architecture recorded in runtime and pack keys so
+	// artifacts for different arches never share a digest. Empty derives it
+	// from the platform tag.
+	Arch string
+	// PolicyBaseDigest identifies the builder image and policy config. It is
+	// recorded in runtime and pack keys (and reaches wheels and repairs
+	// through them) so a base-image or policy change invalidates cached
+	// artifacts instead of reusing them.
+	PolicyBaseDigest string
+	// RepairToolVersion and RepairPolicyHash go into repair keys so planned
+	// repair digests match the ones the worker pushes after repairing.
+	RepairToolVersion string
+	RepairPolicyHash  string
 }
 
 // WheelInput captures an uploaded wheel artifact and its metadata.
@@ -74,12 +111,22 @@ type WheelInput struct {
 	PlatformTag string
 	Digest      string
 	Requires    []DepSpec
+	// RequiresPython is the METADATA Requires-Python specifier, if known.
+	RequiresPython string
+	// Extras lists the wheel's Provides-Extra names for constraint resolution.
+	Extras []string
 }
 
 // InputSet holds parsed inputs for planning.
 type InputSet struct {
 	Requirements []DepSpec
 	Wheels       []WheelInput
+	// IndexURL, when set, replaces the configured index for this input.
+	IndexURL string
+	// PythonVersions, when set, replace the configured target python
+	// version for this input. More than one plans a matrix: every package
+	// gets a node, and a runtime and wheel artifact, per version.
+	PythonVersions []string
 }
 
 // Write writes a snapshot to the given path.
@@ -133,7 +180,8 @@ func Generate(
 	catalog *pack.Catalog,
 	store cas.Store,
 	casRegistryURL,
-	casRegistryRepo string,
+	casRegistryRepo,
+	policyBaseDigest string,
 ) (Snapshot, error) {
 	maxDeps := loadMaxDepsFromEnv()
 	if maxDeps <= 0 {
@@ -152,11 +200,18 @@ func Generate(
 		IndexPassword:    os.Getenv("INDEX_PASSWORD"),
 		UpgradeStrategy:  strategy,
 		MaxDeps:          maxDeps,
+		MaxPlanNodes:     loadMaxPlanNodesFromEnv(),
 		PackageOverrides: loadOverridesFromEnv(),
 		RequirementsPath: requirementsPath,
 		ConstraintsPath:  constraintsPath,
 		PackCatalog:      catalog,
 		ArtifactStore:    store,
+
+		ResolveConcurrency: loadResolveConcurrencyFromEnv(),
+		Arch:               os.Getenv("TARGET_ARCH"),
+		PolicyBaseDigest:   policyBaseDigest,
+		RepairToolVersion:  os.Getenv("REPAIR_TOOL_VERSION"),
+		RepairPolicyHash:   os.Getenv("REPAIR_POLICY_HASH"),
 	}
 	snap, err := computeWithResolver(inputDir, pythonVersion, platformTag, opts, &IndexClient{
 		BaseURL:       indexURL,
@@ -179,6 +234,9 @@ func Generate(
 }
 
 // GenerateFromInputs builds a plan from in-memory input metadata and writes it to cacheDir/plan.json.
+// Empty index credentials fall back to INDEX_USERNAME/INDEX_PASSWORD. A per-input
+// IndexURL replaces indexURL; credentials are only sent to it when it is on the
+// same host as indexURL.
 func GenerateFromInputs(
 	inputs InputSet,
 	cacheDir,
@@ -186,6 +244,8 @@ func GenerateFromInputs(
 	platformTag string,
 	indexURL,
 	extraIndexURL,
+	indexUsername,
+	indexPassword,
 	strategy,
 	constraintsPath string,
 	hints []Hint,
@@ -193,31 +253,72 @@ func GenerateFromInputs(
 	store cas.Store,
 	casRegistryURL,
 	casRegistryRepo string,
+	reuseOnly bool,
+	forceRebuild []string,
+	maxPlanNodes int,
+	policyBaseDigest string,
 ) (Snapshot, error) {
 	maxDeps := loadMaxDepsFromEnv()
 	if maxDeps <= 0 {
 		maxDeps = 1000
 	}
+	if indexUsername == "" && indexPassword == "" {
+		indexUsername = os.Getenv("INDEX_USERNAME")
+		indexPassword = os.Getenv("INDEX_PASSWORD")
+	}
+	if inputs.IndexURL != "" {
+		if !sameIndexHost(inputs.IndexURL, indexURL) {
+			indexUsername, indexPassword = "", ""
+		}
+		indexURL = inputs.IndexURL
+	}
+	versions := inputs.PythonVersions
+	if len(versions) == 0 {
+		versions = []string{pythonVersion}
+	}
 	opts := Options{
 		IndexURL:         indexURL,
 		ExtraIndexURL:    extraIndexURL,
-		IndexUsername:    os.Getenv("INDEX_USERNAME"),
-		IndexPassword:    os.Getenv("INDEX_PASSWORD"),
+		IndexUsername:    indexUsername,
+		IndexPassword:    indexPassword,
 		UpgradeStrategy:  strategy,
 		MaxDeps:          maxDeps,
+		MaxPlanNodes:     maxPlanNodes,
 		PackageOverrides: loadOverridesFromEnv(),
 		ConstraintsPath:  constraintsPath,
 		PackCatalog:      catalog,
 		ArtifactStore:    store,
+
+		ResolveConcurrency: loadResolveConcurrencyFromEnv(),
+		ReuseOnly:          reuseOnly,
+		ForceRebuild:       forceRebuild,
+		Arch:               os.Getenv("TARGET_ARCH"),
+		PolicyBaseDigest:   policyBaseDigest,
+		RepairToolVersion:  os.Getenv("REPAIR_TOOL_VERSION"),
+		RepairPolicyHash:   os.Getenv("REPAIR_POLICY_HASH"),
 	}
-	snap, err := computeWithResolverInputs(inputs.Requirements, inputs.Wheels, pythonVersion, platformTag, opts, &IndexClient{
+	resolver := &IndexClient{
 		BaseURL:       indexURL,
 		ExtraIndexURL: extraIndexURL,
 		Username:      opts.IndexUsername,
 		Password:      opts.IndexPassword,
-	})
-	if err != nil {
-		return Snapshot{}, err
+	}
+	var snap Snapshot
+	for i, version := range versions {
+		vsnap, err := computeWithResolverInputs(inputs.Requirements, inputs.Wheels, version, platformTag, opts, resolver)
+		if err != nil {
+			return Snapshot{}, err
+		}
+		if i == 0 {
+			snap = vsnap
+			continue
+		}
+		mergeSnapshot(&snap, vsnap)
+	}
+	if len(versions) > 1 {
+		if err := checkPlanSize(snap.Plan, opts.MaxPlanNodes); err != nil {
+			return Snapshot{}, err
+		}
 	}
 	AttachHints(&snap, hints)
 	if casRegistryURL != "" {
@@ -230,6 +331,33 @@ func GenerateFromInputs(
 	return snap, nil
 }
 
+// mergeSnapshot adds another python version's nodes to a matrix plan. Packs
+// do not depend on the python version, so DAG nodes already present are
+// kept once.
+func mergeSnapshot(dst *Snapshot, src Snapshot) {
+	dst.Plan = append(dst.Plan, src.Plan...)
+	seen := make(map[artifact.ID]bool, len(dst.DAG))
+	for _, n := range dst.DAG {
+		seen[n.ID] = true
+	}
+	for _, n := range src.DAG {
+		if !seen[n.ID] {
+			seen[n.ID] = true
+			dst.DAG = append(dst.DAG, n)
+		}
+	}
+}
+
+// sameIndexHost reports whether two index URLs point at the same host.
+func sameIndexHost(a, b string) bool {
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	if errA != nil || errB != nil || ua.Host == "" {
+		return false
+	}
+	return strings.EqualFold(ua.Host, ub.Host)
+}
+
 // computeWithResolver walks input wheels and decides reuse vs build for the target tags.
 func computeWithResolver(inputDir, pythonVersion, platformTag string, opts Options, resolver versionResolver) (Snapshot, error) {
 	if opts.MaxDeps <= 0 {
@@ -245,21 +373,23 @@ func computeWithResolver(inputDir, pythonVersion, platformTag string, opts Optio
 		if f.IsDir() || !strings.HasSuffix(f.Name(), ".whl") {
 			continue
 		}
-		info, err := parseWheelFilename(f.Name())
+		info, err := wheelname.Parse(f.Name())
 		if err != nil {
 			continue
 		}
 		path := filepath.Join(inputDir, f.Name())
-		reqs, _ := readRequiresDist(path)
+		md, _ := readWheelMetadata(path)
 		wheels = append(wheels, WheelInput{
-			Filename:    f.Name(),
-			Name:        info.Name,
-			Version:     info.Version,
-			PythonTag:   info.PythonTag,
-			AbiTag:      info.AbiTag,
-			PlatformTag: info.PlatformTag,
-			Digest:      fileDigest(path),
-			Requires:    reqs,
+			Filename:       f.Name(),
+			Name:           info.Name,
+			Version:        info.Version,
+			PythonTag:      info.PythonTag,
+			AbiTag:         info.AbiTag,
+			PlatformTag:    info.PlatformTag,
+			Digest:         fileDigest(path),
+			Requires:       md.Requires,
+			RequiresPython: md.RequiresPython,
+			Extras:         md.ProvidesExtra,
 		})
 	}
 	return computeWithResolverInputs(reqs, wheels, pythonVersion, platformTag, opts, resolver)
@@ -269,25 +399,46 @@ func computeWithResolverInputs(reqs []DepSpec, wheels []WheelInput, pythonVersio
 	if opts.MaxDeps <= 0 {
 		opts.MaxDeps = 1000
 	}
+	arch, err := targetArch(opts.Arch, platformTag)
+	if err != nil {
+		return Snapshot{}, err
+	}
 	store := opts.ArtifactStore
 	if store == nil {
 		store = cas.NullStore{}
 	}
 	ctx := context.TODO()
+	if opts.ReuseOnly {
+		// Reuse decisions come from wheel tags alone; skip the index.
+		resolver = nil
+	}
+	if resolver != nil {
+		prefetched, err := prefetchVersions(ctx, reqs, wheels, opts, resolver)
+		if err != nil {
+			return Snapshot{}, err
+		}
+		resolver = prefetched
+	}
 	pyTag := normalizePyTag(pythonVersion)
 	var nodes []FlatNode
 	var dagNodes []DAGNode
 	addRepair := func(wheelID artifact.ID, meta map[string]any) {
+		if opts.ReuseOnly {
+			return
+		}
 		repairKey := artifact.RepairKey{
 			InputWheelDigest:  wheelID.Digest,
-			RepairToolVersion: "",
-			PolicyRulesDigest: "",
+			RepairToolVersion: opts.RepairToolVersion,
+			PolicyRulesDigest: opts.RepairPolicyHash,
+		}
+		if opts.RepairToolVersion != "" {
+			meta["repair_tool_version"] = opts.RepairToolVersion
+		}
+		if opts.RepairPolicyHash != "" {
+			meta["repair_policy_digest"] = opts.RepairPolicyHash
 		}
 		repairID := artifact.ID{Type: artifact.RepairType, Digest: repairKey.Digest()}
 		action := "build"
-		if ok, _ := store.Has(ctx, repairID); ok {
-			action = "reuse"
-		}
 		dagNodes = append(dagNodes, DAGNode{
 			ID:       repairID,
 			Type:     NodeRepair,
@@ -297,53 +448,57 @@ func computeWithResolverInputs(reqs []DepSpec, wheels []WheelInput, pythonVersio
 		})
 	}
 	// Runtime node (shallow DAG for now)
-	rtKey := artifact.RuntimeKey{Arch: "s390x", PolicyBaseDigest: "", PythonVersion: pythonVersion}
+	rtKey := artifact.RuntimeKey{Arch: arch, PolicyBaseDigest: opts.PolicyBaseDigest, PythonVersion: pythonVersion}
 	rtID := artifact.ID{Type: artifact.RuntimeType, Digest: rtKey.Digest()}
 	rtAction := "build"
-	if ok, _ := store.Has(ctx, rtID); ok {
-		rtAction = "reuse"
-	}
-	dagNodes = append(dagNodes, DAGNode{
-		ID:       rtID,
-		Type:     NodeRuntime,
-		Inputs:   nil,
-		Metadata: map[string]any{"python_version": pythonVersion, "python_tag": pyTag, "platform_tag": platformTag},
-		Action:   rtAction,
-	})
+	if !opts.ReuseOnly {
+		dagNodes = append(dagNodes, DAGNode{
+			ID:       rtID,
+			Type:     NodeRuntime,
+			Inputs:   nil,
+			Metadata: map[string]any{"python_version": pythonVersion, "python_tag": pyTag, "platform_tag": platformTag},
+			Action:   rtAction,
+		})
+	}
 
 	packSeen := make(map[string]bool)
 	packCatalog := opts.PackCatalog
 	packIDForDef := func(def pack.PackDef) artifact.ID {
-		key := artifact.PackKey{
-			Arch:             "s390x",
-			PolicyBaseDigest: "",
-			Name:             def.Name,
-			Version:          def.Version,
-			RecipeDigest:     def.RecipeDigest,
-		}
-		return artifact.ID{Type: artifact.PackType, Digest: key.Digest()}
+		return packID(def, arch, opts.PolicyBaseDigest)
 	}
-	var addPack func(def pack.PackDef)
-	addPack = func(def pack.PackDef) {
+	// packVisiting tracks the pack dependency chain being expanded so a
+	// cyclic catalog fails the plan instead of recursing forever.
+	packVisiting := make(map[string]bool)
+	var packPath []string
+	var addPack func(def pack.PackDef) error
+	addPack = func(def pack.PackDef) error {
 		id := packIDForDef(def)
 		if packSeen[id.Digest] {
-			return
+			return nil
+		}
+		if packVisiting[id.Digest] {
+			return packCycleError(packPath, def.Name)
 		}
+		packVisiting[id.Digest] = true
+		packPath = append(packPath, def.Name)
+		defer func() {
+			delete(packVisiting, id.Digest)
+			packPath = packPath[:len(packPath)-1]
+		}()
 		var deps []artifact.ID
 		if packCatalog != nil {
-			for _, depName := range packDependencies(def.Name) {
+			for _, depName := range packRequires(def) {
 				depDef, ok := packCatalog.Packs[depName]
 				if !ok {
 					continue
 				}
-				addPack(depDef)
+				if err := addPack(depDef); err != nil {
+					return err
+				}
 				deps = append(deps, packIDForDef(depDef))
 			}
 		}
 		action := "build"
-		if ok, _ := store.Has(ctx, id); ok {
-			action = "reuse"
-		}
 		dagNodes = append(dagNodes, DAGNode{
 			ID:       id,
 			Type:     NodePack,
@@ -352,11 +507,15 @@ func computeWithResolverInputs(reqs []DepSpec, wheels []WheelInput, pythonVersio
 			Action:   action,
 		})
 		packSeen[id.Digest] = true
+		return nil
 	}
-	addPackNodes := func(defs []pack.PackDef) {
+	addPackNodes := func(defs []pack.PackDef) error {
 		for _, def := range defs {
-			addPack(def)
+			if err := addPack(def); err != nil {
+				return err
+			}
 		}
+		return nil
 	}
 
 	depSeen := make(map[string]DepSpec)
@@ -371,10 +530,15 @@ func computeWithResolverInputs(reqs []DepSpec, wheels []WheelInput, pythonVersio
 			continue
 		}
 		hasInput = true
+		if opts.ReuseOnly {
+			continue
+		}
 		version := strings.TrimSpace(spec.Version)
 		if resolver != nil && (version == "" || strings.HasPrefix(version, ">=") || strings.HasPrefix(version, "~=")) {
 			if ver, err := resolver.ResolveLatest(name); err == nil {
 				version = ver
+			} else if errors.Is(err, ErrIndexUnavailable) {
+				return Snapshot{}, err
 			} else {
 				log.Printf("warn: resolve latest for %s failed: %v", name, err)
 			}
@@ -385,8 +549,10 @@ func computeWithResolverInputs(reqs []DepSpec, wheels []WheelInput, pythonVersio
 			continue
 		}
 		seen[key] = true
-		packDefs, packIDs, packDigests := selectPacks(name, opts.PackCatalog)
-		addPackNodes(packDefs)
+		packDefs, packIDs, packDigests := selectPacks(name, opts.PackCatalog, arch, opts.PolicyBaseDigest)
+		if err := addPackNodes(packDefs); err != nil {
+			return Snapshot{}, err
+		}
 		nodes = append(nodes, FlatNode{
 			Name:          name,
 			Version:       version,
@@ -394,6 +560,7 @@ func computeWithResolverInputs(reqs []DepSpec, wheels []WheelInput, pythonVersio
 			PythonTag:     pyTag,
 			PlatformTag:   platformTag,
 			Action:        "build",
+			Hashes:        spec.Hashes,
 		})
 		wheelKey := artifact.WheelKey{
 			SourceDigest:  sourceDigest(name, version),
@@ -404,9 +571,6 @@ func computeWithResolverInputs(reqs []DepSpec, wheels []WheelInput, pythonVersio
 		}
 		wheelID := artifact.ID{Type: artifact.WheelType, Digest: wheelKey.Digest()}
 		wheelAction := "build"
-		if ok, _ := store.Has(ctx, wheelID); ok {
-			wheelAction = "reuse"
-		}
 		dagNodes = append(dagNodes, DAGNode{
 			ID:     wheelID,
 			Type:   NodeWheel,
@@ -414,6 +578,7 @@ func computeWithResolverInputs(reqs []DepSpec, wheels []WheelInput, pythonVersio
 			Metadata: map[string]any{
 				"name":           name,
 				"version":        version,
+				"source_digest":  wheelKey.SourceDigest,
 				"python_version": pythonVersion,
 				"python_tag":     pyTag,
 				"platform_tag":   platformTag,
@@ -424,7 +589,7 @@ func computeWithResolverInputs(reqs []DepSpec, wheels []WheelInput, pythonVersio
 	}
 
 	for _, w := range wheels {
-		info := wheelInfo{
+		info := wheelname.Wheel{
 			Name:        w.Name,
 			Version:     w.Version,
 			PythonTag:   w.PythonTag,
@@ -435,6 +600,48 @@ func computeWithResolverInputs(reqs []DepSpec, wheels []WheelInput, pythonVersio
 			continue
 		}
 		hasInput = true
+		if w.RequiresPython != "" && !RequiresPythonAllows(w.RequiresPython, pythonVersion) {
+			log.Printf("skip %s %s: Requires-Python %q excludes python %s", info.Name, info.Version, w.RequiresPython, pythonVersion)
+			continue
+		}
+		if opts.ReuseOnly {
+			if !isCompatible(info, pyTag, platformTag) {
+				log.Printf("reuse-only: %s %s (%s-%s) is not reusable on %s/%s", info.Name, info.Version, info.PythonTag, info.PlatformTag, pyTag, platformTag)
+				continue
+			}
+			key := info.Name + "::" + info.Version
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			source := w.Digest
+			if source == "" {
+				source = sourceDigest(info.Name, info.Version)
+			}
+			_, _, packDigests := selectPacks(info.Name, opts.PackCatalog, arch, opts.PolicyBaseDigest)
+			wk := artifact.WheelKey{SourceDigest: source, PyTag: pyTag, PlatformTag: platformTag, RuntimeDigest: rtID.Digest, PackDigests: packDigests}
+			nodes = append(nodes, FlatNode{
+				Name:          info.Name,
+				Version:       info.Version,
+				PythonVersion: pythonVersion,
+				PythonTag:     pyTag,
+				PlatformTag:   platformTag,
+				Action:        "reuse",
+			})
+			dagNodes = append(dagNodes, DAGNode{
+				ID:   artifact.ID{Type: artifact.WheelType, Digest: wk.Digest()},
+				Type: NodeWheel,
+				Metadata: map[string]any{
+					"name":           info.Name,
+					"version":        info.Version,
+					"python_version": pythonVersion,
+					"python_tag":     pyTag,
+					"platform_tag":   platformTag,
+				},
+				Action: "reuse",
+			})
+			continue
+		}
 		for _, dep := range w.Requires {
 			if dep.Name == "" {
 				continue
@@ -449,6 +656,8 @@ func computeWithResolverInputs(reqs []DepSpec, wheels []WheelInput, pythonVersio
 			if resolver != nil && dep.Version == "" {
 				if ver, err := resolver.ResolveLatest(dep.Name); err == nil {
 					dep.Version = ver
+				} else if errors.Is(err, ErrIndexUnavailable) {
+					return Snapshot{}, err
 				} else {
 					log.Printf("warn: resolve latest for %s failed: %v", dep.Name, err)
 				}
@@ -461,8 +670,10 @@ func computeWithResolverInputs(reqs []DepSpec, wheels []WheelInput, pythonVersio
 			key := info.Name + "::" + ver
 			if !seen[key] {
 				seen[key] = true
-				packDefs, packIDs, packDigests := selectPacks(info.Name, opts.PackCatalog)
-				addPackNodes(packDefs)
+				packDefs, packIDs, packDigests := selectPacks(info.Name, opts.PackCatalog, arch, opts.PolicyBaseDigest)
+				if err := addPackNodes(packDefs); err != nil {
+					return Snapshot{}, err
+				}
 				nodes = append(nodes, FlatNode{
 					Name:          info.Name,
 					Version:       ver,
@@ -480,9 +691,6 @@ func computeWithResolverInputs(reqs []DepSpec, wheels []WheelInput, pythonVersio
 				}
 				wID := artifact.ID{Type: artifact.WheelType, Digest: wk.Digest()}
 				wheelAction := "build"
-				if ok, _ := store.Has(ctx, wID); ok {
-					wheelAction = "reuse"
-				}
 				dagNodes = append(dagNodes, DAGNode{
 					ID:     wID,
 					Type:   NodeWheel,
@@ -490,6 +698,7 @@ func computeWithResolverInputs(reqs []DepSpec, wheels []WheelInput, pythonVersio
 					Metadata: map[string]any{
 						"name":           info.Name,
 						"version":        ver,
+						"source_digest":  wk.SourceDigest,
 						"python_version": pythonVersion,
 						"python_tag":     pyTag,
 						"platform_tag":   platformTag,
@@ -506,8 +715,10 @@ func computeWithResolverInputs(reqs []DepSpec, wheels []WheelInput, pythonVersio
 			continue
 		}
 		seen[key] = true
-		packDefs, packIDs, packDigests := selectPacks(info.Name, opts.PackCatalog)
-		addPackNodes(packDefs)
+		packDefs, packIDs, packDigests := selectPacks(info.Name, opts.PackCatalog, arch, opts.PolicyBaseDigest)
+		if err := addPackNodes(packDefs); err != nil {
+			return Snapshot{}, err
+		}
 		source := w.Digest
 		if source == "" {
 			source = sourceDigest(info.Name, info.Version)
@@ -524,9 +735,6 @@ func computeWithResolverInputs(reqs []DepSpec, wheels []WheelInput, pythonVersio
 				Action:        "reuse",
 			})
 			wheelAction := "reuse"
-			if ok, _ := store.Has(ctx, wID); ok {
-				wheelAction = "reuse"
-			}
 			dagNodes = append(dagNodes, DAGNode{
 				ID:     wID,
 				Type:   NodeWheel,
@@ -551,9 +759,6 @@ func computeWithResolverInputs(reqs []DepSpec, wheels []WheelInput, pythonVersio
 				Action:        "build",
 			})
 			wheelAction := "build"
-			if ok, _ := store.Has(ctx, wID); ok {
-				wheelAction = "reuse"
-			}
 			dagNodes = append(dagNodes, DAGNode{
 				ID:     wID,
 				Type:   NodeWheel,
@@ -570,10 +775,17 @@ func computeWithResolverInputs(reqs []DepSpec, wheels []WheelInput, pythonVersio
 			addRepair(wID, map[string]any{"wheel_name": info.Name, "wheel_version": info.Version})
 		}
 	}
-	for dep, spec := range depSeen {
+	// Emit dependencies in name order so plan output is stable across runs.
+	depNames := make([]string, 0, len(depSeen))
+	for dep := range depSeen {
+		depNames = append(depNames, dep)
+	}
+	sort.Strings(depNames)
+	for _, dep := range depNames {
 		if dep == "" {
 			continue
 		}
+		spec := depSeen[dep]
 		version := spec.Version
 		if ov, ok := opts.PackageOverrides[normalizeName(dep)]; ok && ov != "" {
 			version = strings.TrimPrefix(strings.TrimSpace(ov), "==")
@@ -585,6 +797,8 @@ func computeWithResolverInputs(reqs []DepSpec, wheels []WheelInput, pythonVersio
 			if resolver != nil {
 				if ver, err := resolver.ResolveLatest(dep); err == nil {
 					version = ver
+				} else if errors.Is(err, ErrIndexUnavailable) {
+					return Snapshot{}, err
 				} else {
 					log.Printf("warn: resolve latest for %s failed: %v", dep, err)
 				}
@@ -596,6 +810,8 @@ func computeWithResolverInputs(reqs []DepSpec, wheels []WheelInput, pythonVersio
 		if opts.UpgradeStrategy == "eager" && resolver != nil {
 			if ver, err := resolver.ResolveLatest(dep); err == nil && ver != "" {
 				version = ver
+			} else if errors.Is(err, ErrIndexUnavailable) {
+				return Snapshot{}, err
 			} else if err != nil {
 				log.Printf("warn: eager resolve latest for %s failed: %v", dep, err)
 			}
@@ -605,8 +821,10 @@ func computeWithResolverInputs(reqs []DepSpec, wheels []WheelInput, pythonVersio
 			continue
 		}
 		seen[key] = true
-		packDefs, packIDs, packDigests := selectPacks(dep, opts.PackCatalog)
-		addPackNodes(packDefs)
+		packDefs, packIDs, packDigests := selectPacks(dep, opts.PackCatalog, arch, opts.PolicyBaseDigest)
+		if err := addPackNodes(packDefs); err != nil {
+			return Snapshot{}, err
+		}
 		nodes = append(nodes, FlatNode{
 			Name:          dep,
 			Version:       version,
@@ -618,9 +836,6 @@ func computeWithResolverInputs(reqs []DepSpec, wheels []WheelInput, pythonVersio
 		wk := artifact.WheelKey{SourceDigest: sourceDigest(dep, version), PyTag: pyTag, PlatformTag: platformTag, RuntimeDigest: rtID.Digest, PackDigests: packDigests}
 		wID := artifact.ID{Type: artifact.WheelType, Digest: wk.Digest()}
 		wheelAction := "build"
-		if ok, _ := store.Has(ctx, wID); ok {
-			wheelAction = "reuse"
-		}
 		dagNodes = append(dagNodes, DAGNode{
 			ID:     wID,
 			Type:   NodeWheel,
@@ -628,6 +843,7 @@ func computeWithResolverInputs(reqs []DepSpec, wheels []WheelInput, pythonVersio
 			Metadata: map[string]any{
 				"name":           dep,
 				"version":        version,
+				"source_digest":  wk.SourceDigest,
 				"python_version": pythonVersion,
 				"python_tag":     pyTag,
 				"platform_tag":   platformTag,
@@ -642,43 +858,104 @@ func computeWithResolverInputs(reqs []DepSpec, wheels []WheelInput, pythonVersio
 	if depTruncated {
 		return Snapshot{}, fmt.Errorf("dependency expansion exceeded MaxDeps (%d); increase MAX_DEPS or trim input", opts.MaxDeps)
 	}
-	return Snapshot{RunID: newRunID(), Plan: nodes, DAG: dagNodes}, nil
+	if err := checkPlanSize(nodes, opts.MaxPlanNodes); err != nil {
+		return Snapshot{}, err
+	}
+	if opts.PackCatalog != nil {
+		for i := range nodes {
+			nodes[i].MemoryMB, nodes[i].CPUs = opts.PackCatalog.Limits(nodes[i].Name, "")
+		}
+	}
+	// Resolve reuse against the CAS in one batch instead of a lookup per node.
+	forced := forcedDigests(dagNodes, opts.ForceRebuild)
+	ids := make([]artifact.ID, 0, len(dagNodes))
+	for _, n := range dagNodes {
+		if !forced[n.ID.Digest] {
+			ids = append(ids, n.ID)
+		}
+	}
+	present := map[string]bool{}
+	if len(ids) > 0 {
+		present, _ = cas.HasBatch(ctx, store, ids)
+	}
+	for i := range dagNodes {
+		if present[dagNodes[i].ID.Digest] && !forced[dagNodes[i].ID.Digest] {
+			dagNodes[i].Action = "reuse"
+		}
+	}
+	return Snapshot{RunID: newRunID(), Arch: arch, Plan: nodes, DAG: dagNodes}, nil
 }
 
-type wheelInfo struct {
-	Name        string
-	Version     string
-	PythonTag   string
-	AbiTag      string
-	PlatformTag string
+// checkPlanSize fails a plan with more build nodes than maxNodes so a
+// runaway input never reaches the build queue.
+func checkPlanSize(nodes []FlatNode, maxNodes int) error {
+	if maxNodes <= 0 {
+		return nil
+	}
+	builds := 0
+	for _, n := range nodes {
+		if n.Action == "build" {
+			builds++
+		}
+	}
+	if builds > maxNodes {
+		return fmt.Errorf("plan has %d build nodes, exceeding MaxPlanNodes (%d); increase max_plan_nodes or trim input", builds, maxNodes)
+	}
+	return nil
+}
+
+// ForcesRebuild reports whether a force_rebuild list covers the package.
+func ForcesRebuild(forceRebuild []string, name string) bool {
+	n := normalizeName(name)
+	for _, f := range forceRebuild {
+		if f == "*" || normalizeName(f) == n {
+			return true
+		}
+	}
+	return false
 }
 
-func parseWheelFilename(name string) (wheelInfo, error) {
-	base := strings.TrimSuffix(name, ".whl")
-	parts := strings.Split(base, "-")
-	if len(parts) < 5 {
-		return wheelInfo{}, fmt.Errorf("invalid wheel filename: %s", name)
+// forcedDigests returns the DAG digests that must stay build under
+// forceRebuild: each forced package's wheel, the packs it builds against,
+// and its repair.
+func forcedDigests(dagNodes []DAGNode, forceRebuild []string) map[string]bool {
+	forced := map[string]bool{}
+	if len(forceRebuild) == 0 {
+		return forced
+	}
+	if ForcesRebuild(forceRebuild, "*") {
+		for _, n := range dagNodes {
+			forced[n.ID.Digest] = true
+		}
+		return forced
+	}
+	for _, n := range dagNodes {
+		if n.Type != NodeWheel {
+			continue
+		}
+		name, _ := n.Metadata["name"].(string)
+		if !ForcesRebuild(forceRebuild, name) {
+			continue
+		}
+		forced[n.ID.Digest] = true
+		for _, in := range n.Inputs {
+			if in.Type == artifact.PackType {
+				forced[in.Digest] = true
+			}
+		}
+	}
+	for _, n := range dagNodes {
+		if n.Type == NodeRepair && len(n.Inputs) > 0 && forced[n.Inputs[0].Digest] {
+			forced[n.ID.Digest] = true
+		}
 	}
-	platform := parts[len(parts)-1]
-	abi := parts[len(parts)-2]
-	py := parts[len(parts)-3]
-	version := parts[len(parts)-4]
-	pkgParts := parts[:len(parts)-4]
-	pkg := strings.ReplaceAll(strings.Join(pkgParts, "-"), "_", "-")
-	return wheelInfo{
-		Name:        pkg,
-		Version:     version,
-		PythonTag:   py,
-		AbiTag:      abi,
-		PlatformTag: platform,
-	}, nil
+	return forced
 }
 
-func isCompatible(w wheelInfo, targetPy, targetPlatform string) bool {
-	pyOK := w.PythonTag == targetPy || strings.HasPrefix(w.PythonTag, "py3") || strings.HasPrefix(w.PythonTag, "cp3")
-	platOK := w.PlatformTag == "any" || w.PlatformTag == targetPlatform
-	abiOK := w.AbiTag == "none" || strings.HasPrefix(w.AbiTag, "cp3")
-	return pyOK && platOK && abiOK
+// isCompatible reports whether an existing wheel can be reused for the target
+// python and platform; see compat.Check for the rules.
+func isCompatible(w wheelname.Wheel, targetPy, targetPlatform string) bool {
+	return compat.Compatible(w, targetPy, targetPlatform)
 }
 
 func normalizePyTag(pythonVersion string) string {
@@ -692,14 +969,83 @@ func normalizePyTag(pythonVersion string) string {
 	return "cp" + trimmed
 }
 
+// runIDAlphabet is in ASCII order so that run ids compare like the values
+// they encode.
+const runIDAlphabet = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// runIDSuffixLen is the number of random base36 characters after the
+// timestamp, about 41 bits of entropy per millisecond.
+const runIDSuffixLen = 8
+
+var runIDState struct {
+	sync.Mutex
+	ms     int64
+	suffix []byte
+}
+
+// newRunID returns a sortable, URL-safe run id of the form
+// "<unix_ms>-<suffix>": a 13-digit zero-padded millisecond timestamp and a
+// random base36 suffix. Like a monotonic ULID, ids generated in the same
+// millisecond (or after the clock steps back) reuse the previous timestamp
+// and increment the suffix, so ids from one process always sort in
+// generation order.
 func newRunID() string {
-	const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
-	b := make([]byte, 12)
-	rand.Seed(time.Now().UnixNano())
-	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
+	now := time.Now().UnixMilli()
+	runIDState.Lock()
+	defer runIDState.Unlock()
+	if runIDState.suffix == nil || now > runIDState.ms {
+		runIDState.ms = now
+		runIDState.suffix = randomBase36(runIDSuffixLen)
+	} else if !incrementBase36(runIDState.suffix) {
+		// Suffix space for this millisecond is exhausted; borrow the next one.
+		runIDState.ms++
+		runIDState.suffix = randomBase36(runIDSuffixLen)
+	}
+	return fmt.Sprintf("%013d-%s", runIDState.ms, runIDState.suffix)
+}
+
+// randomBase36 draws n characters from runIDAlphabet using crypto/rand.
+// Random bytes at or above the largest multiple of the alphabet size are
+// discarded so every character is equally likely.
+func randomBase36(n int) []byte {
+	const limit = 256 - 256%len(runIDAlphabet)
+	b := make([]byte, n)
+	buf := make([]byte, 16)
+	for i := 0; i < n; {
+		if _, err := rand.Read(buf); err != nil {
+			panic(fmt.Sprintf("run id: %v", err))
+		}
+		for _, c := range buf {
+			if i == n {
+				break
+			}
+			if int(c) >= limit {
+				continue
+			}
+			b[i] = runIDAlphabet[int(c)%len(runIDAlphabet)]
+			i++
+		}
+	}
+	return b
+}
+
+// incrementBase36 adds one to b in place and reports false on overflow.
+func incrementBase36(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		idx := strings.IndexByte(runIDAlphabet, b[i])
+		if idx < len(runIDAlphabet)-1 {
+			b[i] = runIDAlphabet[idx+1]
+			return true
+		}
+		b[i] = runIDAlphabet[0]
 	}
-	return string(b)
+	return false
+}
+
+// SourceDigest is the source digest the planner keys a wheel built from a
+// name and version on. The worker recomputes it to catch plan/build drift.
+func SourceDigest(name, version string) string {
+	return sourceDigest(name, version)
 }
 
 func sourceDigest(name, version string) string {
@@ -723,42 +1069,101 @@ func bestSourceDigest(name, version, path string) string {
 	return sourceDigest(name, version)
 }
 
-// readRequiresDist extracts Requires-Dist entries from METADATA inside a wheel.
-func readRequiresDist(wheelPath string) ([]DepSpec, error) {
-	zr, err := zip.OpenReader(wheelPath)
-	if err != nil {
-		return nil, err
-	}
-	defer zr.Close()
-	var meta []byte
-	for _, f := range zr.File {
-		if strings.HasSuffix(f.Name, "METADATA") {
-			rc, err := f.Open()
-			if err != nil {
-				continue
-			}
-			buf := new(bytes.Buffer)
-			_, _ = buf.ReadFrom(rc)
-			rc.Close()
-			meta = buf.Bytes()
-			break
-		}
-	}
-	if len(meta) == 0 {
-		return nil, nil
-	}
-	return parseRequiresDist(string(meta)), nil
-}
-
 type DepSpec struct {
 	Name    string
 	Version string
+	// Hashes are pip --hash values ("sha256:<hex>") the built source must match.
+	Hashes []string
 }
 
 type versionResolver interface {
 	ResolveLatest(name string) (string, error)
 }
 
+const defaultResolveConcurrency = 8
+
+type resolvedVersion struct {
+	version string
+	err     error
+}
+
+// prefetchedResolver answers from lookups resolved up front and defers to
+// the wrapped resolver for anything it has not seen.
+type prefetchedResolver struct {
+	results map[string]resolvedVersion
+	next    versionResolver
+}
+
+func (p *prefetchedResolver) ResolveLatest(name string) (string, error) {
+	if r, ok := p.results[name]; ok {
+		return r.version, r.err
+	}
+	return p.next.ResolveLatest(name)
+}
+
+// prefetchVersions resolves every name the planner will look up, in
+// parallel and bounded by opts.ResolveConcurrency. The planning loops then
+// run unchanged against the prefetched results, so plan ordering does not
+// depend on which lookup finishes first.
+func prefetchVersions(ctx context.Context, reqs []DepSpec, wheels []WheelInput, opts Options, resolver versionResolver) (versionResolver, error) {
+	limit := opts.ResolveConcurrency
+	if limit <= 0 {
+		limit = defaultResolveConcurrency
+	}
+	if limit == 1 {
+		return resolver, nil
+	}
+	eager := opts.UpgradeStrategy == "eager"
+	var names []string
+	wanted := make(map[string]bool)
+	want := func(name string) {
+		if name != "" && !wanted[name] {
+			wanted[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, spec := range reqs {
+		version := strings.TrimSpace(spec.Version)
+		if eager || version == "" || strings.HasPrefix(version, ">=") || strings.HasPrefix(version, "~=") {
+			want(normalizeName(spec.Name))
+		}
+	}
+	for _, w := range wheels {
+		for _, dep := range w.Requires {
+			if eager || dep.Version == "" {
+				want(dep.Name)
+			}
+		}
+	}
+	if len(names) < 2 {
+		return resolver, nil
+	}
+
+	var mu sync.Mutex
+	results := make(map[string]resolvedVersion, len(names))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(limit)
+	for _, name := range names {
+		g.Go(func() error {
+			if gctx.Err() != nil {
+				return nil
+			}
+			ver, err := resolver.ResolveLatest(name)
+			if errors.Is(err, ErrIndexUnavailable) {
+				return err
+			}
+			mu.Lock()
+			results[name] = resolvedVersion{version: ver, err: err}
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return &prefetchedResolver{results: results, next: resolver}, nil
+}
+
 func normalizeName(name string) string {
 	if name == "" {
 		return ""
@@ -797,6 +1202,41 @@ func loadMaxDepsFromEnv() int {
 	return n
 }
 
+func loadMaxPlanNodesFromEnv() int {
+	raw := os.Getenv("MAX_PLAN_NODES")
+	if raw == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+func loadResolveConcurrencyFromEnv() int {
+	raw := os.Getenv("RESOLVE_CONCURRENCY")
+	if raw == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// PolicyBaseDigest hashes the builder image, preset, and repair policy that
+// runtime and pack artifacts are built under. It is empty when none are set,
+// which keeps the digests of plans made without a configured image.
+func PolicyBaseDigest(image, preset, policyHash string) string {
+	if image == "" && preset == "" && policyHash == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(image + "\n" + preset + "\n" + policyHash))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
 func loadRequirements(inputDir, path string) []DepSpec {
 	var reqPath string
 	if path != "" {
@@ -807,11 +1247,19 @@ func loadRequirements(inputDir, path string) []DepSpec {
 	if _, err := os.Stat(reqPath); err != nil {
 		return nil
 	}
-	data, err := os.ReadFile(filepath.Clean(reqPath))
+	return readRequirementsFile(filepath.Clean(reqPath), map[string]bool{})
+}
+
+// readRequirementsFile parses one requirements file, following -r /
+// --requirement includes relative to its directory. Includes that are
+// missing or would loop are logged and skipped.
+func readRequirementsFile(reqPath string, seen map[string]bool) []DepSpec {
+	seen[reqPath] = true
+	data, err := os.ReadFile(reqPath)
 	if err != nil {
 		return nil
 	}
-	lines := strings.Split(string(data), "\n")
+	lines := RequirementLines(string(data))
 	var out []DepSpec
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
@@ -821,10 +1269,24 @@ func loadRequirements(inputDir, path string) []DepSpec {
 		if strings.HasPrefix(line, "-c ") || strings.HasPrefix(line, "--constraint") {
 			continue
 		}
+		if include, ok := requirementInclude(line); ok {
+			incPath := include
+			if !filepath.IsAbs(incPath) {
+				incPath = filepath.Join(filepath.Dir(reqPath), incPath)
+			}
+			incPath = filepath.Clean(incPath)
+			if _, err := os.Stat(incPath); err != nil || seen[incPath] {
+				log.Printf("requirements %s: unresolved include %s", reqPath, include)
+				continue
+			}
+			out = append(out, readRequirementsFile(incPath, seen)...)
+			continue
+		}
 		// strip inline comments
 		if idx := strings.Index(line, " #"); idx != -1 {
 			line = strings.TrimSpace(line[:idx])
 		}
+		line, hashes := SplitRequirementHashes(line)
 		name := line
 		version := ""
 		for _, op := range []string{"==", ">=", "~="} {
@@ -841,11 +1303,76 @@ func loadRequirements(inputDir, path string) []DepSpec {
 		if name == "" {
 			continue
 		}
-		out = append(out, DepSpec{Name: name, Version: version})
+		out = append(out, DepSpec{Name: name, Version: version, Hashes: hashes})
+	}
+	return out
+}
+
+// RequirementLines splits a requirements file into logical lines, joining
+// backslash continuations the way pip does.
+func RequirementLines(text string) []string {
+	var out []string
+	var cur strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if strings.HasSuffix(line, "\\") {
+			cur.WriteString(strings.TrimSuffix(line, "\\"))
+			cur.WriteString(" ")
+			continue
+		}
+		cur.WriteString(line)
+		out = append(out, cur.String())
+		cur.Reset()
+	}
+	if cur.Len() > 0 {
+		out = append(out, cur.String())
 	}
 	return out
 }
 
+// SplitRequirementHashes removes --hash options from a requirement line and
+// returns them as "algo:hex" values.
+func SplitRequirementHashes(line string) (string, []string) {
+	if !strings.Contains(line, "--hash") {
+		return line, nil
+	}
+	fields := strings.Fields(line)
+	kept := make([]string, 0, len(fields))
+	var hashes []string
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		switch {
+		case strings.HasPrefix(f, "--hash="):
+			hashes = append(hashes, strings.TrimPrefix(f, "--hash="))
+		case f == "--hash" && i+1 < len(fields):
+			i++
+			hashes = append(hashes, fields[i])
+		default:
+			kept = append(kept, f)
+		}
+	}
+	return strings.Join(kept, " "), hashes
+}
+
+// requirementInclude returns the target of a -r / --requirement line.
+func requirementInclude(line string) (string, bool) {
+	if idx := strings.Index(line, " #"); idx != -1 {
+		line = strings.TrimSpace(line[:idx])
+	}
+	var rest string
+	switch {
+	case strings.HasPrefix(line, "--requirement"):
+		rest = strings.TrimPrefix(line, "--requirement")
+		rest = strings.TrimPrefix(strings.TrimSpace(rest), "=")
+	case strings.HasPrefix(line, "-r"):
+		rest = strings.TrimPrefix(line, "-r")
+	default:
+		return "", false
+	}
+	rest = strings.TrimSpace(rest)
+	return rest, rest != ""
+}
+
 func loadConstraints(path string) map[string]string {
 	if path == "" {
 		return nil
@@ -940,7 +1467,7 @@ func parseRequiresDist(meta string) []DepSpec {
 	return out
 }
 
-func selectPacks(pkg string, catalog *pack.Catalog) ([]pack.PackDef, []artifact.ID, []string) {
+func selectPacks(pkg string, catalog *pack.Catalog, arch, policyBase string) ([]pack.PackDef, []artifact.ID, []string) {
 	if catalog == nil {
 		return nil, nil, nil
 	}
@@ -954,34 +1481,86 @@ func selectPacks(pkg string, catalog *pack.Catalog) ([]pack.PackDef, []artifact.
 	ids := make([]artifact.ID, 0, len(defs))
 	digests := make([]string, 0, len(defs))
 	for _, def := range defs {
-		key := artifact.PackKey{
-			Arch:             "s390x",
-			PolicyBaseDigest: "",
-			Name:             def.Name,
-			Version:          def.Version,
-			RecipeDigest:     def.RecipeDigest,
-		}
-		id := artifact.ID{Type: artifact.PackType, Digest: key.Digest()}
+		id := packID(def, arch, policyBase)
 		ids = append(ids, id)
 		digests = append(digests, id.Digest)
 	}
 	return defs, ids, digests
 }
 
-// packDependencies declares manual pack dependency edges to enforce ordering.
+// packID is the CAS id of a pack built for arch on the policyBase image.
+func packID(def pack.PackDef, arch, policyBase string) artifact.ID {
+	key := artifact.PackKey{
+		Arch:             arch,
+		PolicyBaseDigest: policyBase,
+		Name:             def.Name,
+		Version:          def.Version,
+		RecipeDigest:     def.RecipeDigest,
+	}
+	return artifact.ID{Type: artifact.PackType, Digest: key.Digest()}
+}
+
+// defaultArch is the target when neither TARGET_ARCH nor the platform tag
+// names one.
+const defaultArch = "s390x"
+
+// targetArch settles the architecture for a plan. An explicit arch must be
+// known and agree with the platform tag, since wheel keys already carry the
+// tag's arch.
+func targetArch(arch, platformTag string) (string, error) {
+	tagArch := platform.Arch(platformTag)
+	if arch == "" {
+		if tagArch != "" {
+			return tagArch, nil
+		}
+		return defaultArch, nil
+	}
+	if !platform.KnownArch(arch) {
+		return "", fmt.Errorf("unknown TARGET_ARCH %q", arch)
+	}
+	if tagArch != "" && tagArch != arch {
+		return "", fmt.Errorf("TARGET_ARCH %q does not match platform tag %q", arch, platformTag)
+	}
+	return arch, nil
+}
+
+// packCycleError names the dependency chain that loops back to name.
+func packCycleError(path []string, name string) error {
+	start := 0
+	for i, p := range path {
+		if p == name {
+			start = i
+			break
+		}
+	}
+	cycle := append(append([]string{}, path[start:]...), name)
+	return fmt.Errorf("pack dependency cycle: %s", strings.Join(cycle, " -> "))
+}
+
+// packDependencyEdges declares manual pack dependency edges to enforce ordering.
+var packDependencyEdges = map[string][]string{
+	"openssl":       {"zlib"},
+	"libpng":        {"zlib"},
+	"freetype":      {"libpng", "jpeg"},
+	"libxslt":       {"libxml2"},
+	"libxml2":       {"zlib"},
+	"cpython":       {"openssl", "libffi", "zlib", "xz", "bzip2", "sqlite"},
+	"cpython3.10":   {"openssl", "libffi", "zlib", "xz", "bzip2", "sqlite"},
+	"cpython3.11":   {"openssl", "libffi", "zlib", "xz", "bzip2", "sqlite"},
+	"cpython3.12":   {"openssl", "libffi", "zlib", "xz", "bzip2", "sqlite"},
+	"runtime":       {"openssl", "libffi", "zlib", "xz", "bzip2", "sqlite"},
+	"libjpeg-turbo": {},
+}
+
+// packRequires returns the catalog-declared dependencies for a pack, falling
+// back to the built-in edges when the catalog does not declare any.
+func packRequires(def pack.PackDef) []string {
+	if def.Requires != nil {
+		return def.Requires
+	}
+	return packDependencies(def.Name)
+}
+
 func packDependencies(name string) []string {
-	deps := map[string][]string{
-		"openssl":       {"zlib"},
-		"libpng":        {"zlib"},
-		"freetype":      {"libpng", "jpeg"},
-		"libxslt":       {"libxml2"},
-		"libxml2":       {"zlib"},
-		"cpython":       {"openssl", "libffi", "zlib", "xz", "bzip2", "sqlite"},
-		"cpython3.10":   {"openssl", "libffi", "zlib", "xz", "bzip2", "sqlite"},
-		"cpython3.11":   {"openssl", "libffi", "zlib", "xz", "bzip2", "sqlite"},
-		"cpython3.12":   {"openssl", "libffi", "zlib", "xz", "bzip2", "sqlite"},
-		"runtime":       {"openssl", "libffi", "zlib", "xz", "bzip2", "sqlite"},
-		"libjpeg-turbo": {},
-	}
-	return deps[strings.ToLower(name)]
+	return packDependencyEdges[strings.ToLower(name)]
 }
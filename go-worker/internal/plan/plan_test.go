@@ -2,19 +2,29 @@ package plan
 
 import (
 	"archive/zip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/artifact"
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/cas"
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/pack"
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/wheelname"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestParseWheelFilename(t *testing.T) {
-	info, err := parseWheelFilename("pkgA-1.2.3-cp311-cp311-manylinux2014_s390x.whl")
+	info, err := wheelname.Parse("pkgA-1.2.3-cp311-cp311-manylinux2014_s390x.whl")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -118,6 +128,46 @@ func TestComputePlanIncludesDAG(t *testing.T) {
 	}
 }
 
+func TestPolicyBaseDigestChangesArtifactDigests(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "demo-1.0.0-py3-none-any.whl"), []byte{}, 0o644)
+
+	digests := func(policy string) (string, string) {
+		snap, err := computeWithResolver(dir, "3.11", "manylinux2014_s390x", Options{UpgradeStrategy: "pinned", PolicyBaseDigest: policy}, nil)
+		if err != nil {
+			t.Fatalf("compute failed: %v", err)
+		}
+		var runtime, wheel string
+		for _, n := range snap.DAG {
+			switch n.Type {
+			case NodeRuntime:
+				runtime = n.ID.Digest
+			case NodeWheel:
+				wheel = n.ID.Digest
+			}
+		}
+		if runtime == "" || wheel == "" {
+			t.Fatalf("expected runtime and wheel nodes, got %+v", snap.DAG)
+		}
+		want := artifact.RuntimeKey{Arch: "s390x", PolicyBaseDigest: policy, PythonVersion: "3.11"}.Digest()
+		if runtime != want {
+			t.Fatalf("runtime digest %s does not use policy %q", runtime, policy)
+		}
+		return runtime, wheel
+	}
+	rtA, wheelA := digests(PolicyBaseDigest("refinery-builder:1", "rocky", ""))
+	rtB, wheelB := digests(PolicyBaseDigest("refinery-builder:2", "rocky", ""))
+	if rtA == rtB {
+		t.Fatalf("runtime digest unchanged across policy digests: %s", rtA)
+	}
+	if wheelA == wheelB {
+		t.Fatalf("wheel digest unchanged across policy digests: %s", wheelA)
+	}
+	if PolicyBaseDigest("", "", "") != "" {
+		t.Fatalf("expected empty policy digest without image or policy")
+	}
+}
+
 func TestPackCatalogAddsPackNodesToDAG(t *testing.T) {
 	dir := t.TempDir()
 	reqPath := filepath.Join(dir, "requirements.txt")
@@ -277,6 +327,61 @@ func TestCASOverridesActionsToReuse(t *testing.T) {
 	}
 }
 
+func TestForceRebuildIgnoresCASHits(t *testing.T) {
+	dir := t.TempDir()
+	reqPath := filepath.Join(dir, "requirements.txt")
+	if err := os.WriteFile(reqPath, []byte("demo==1.0.0\nother==2.0.0"), 0o644); err != nil {
+		t.Fatalf("write requirements: %v", err)
+	}
+	cat := &pack.Catalog{
+		Packs: map[string]pack.PackDef{
+			"openssl": {Name: "openssl", Version: "3.0"},
+		},
+		Rules: []pack.Rule{{PackagePattern: "demo", Packs: []string{"openssl"}}},
+	}
+	rtKey := artifact.RuntimeKey{Arch: "s390x", PolicyBaseDigest: "", PythonVersion: "3.11"}
+	rtID := artifact.ID{Type: artifact.RuntimeType, Digest: rtKey.Digest()}
+	packKey := artifact.PackKey{Arch: "s390x", PolicyBaseDigest: "", Name: "openssl", Version: "3.0"}
+	packID := artifact.ID{Type: artifact.PackType, Digest: packKey.Digest()}
+	wheelID := func(name, version string, packs []string) artifact.ID {
+		wk := artifact.WheelKey{SourceDigest: sourceDigest(name, version), PyTag: "cp311", PlatformTag: "manylinux2014_s390x", RuntimeDigest: rtID.Digest, PackDigests: packs}
+		return artifact.ID{Type: artifact.WheelType, Digest: wk.Digest()}
+	}
+	demoID := wheelID("demo", "1.0.0", []string{packID.Digest})
+	otherID := wheelID("other", "2.0.0", nil)
+	repairID := artifact.ID{Type: artifact.RepairType, Digest: artifact.RepairKey{InputWheelDigest: demoID.Digest}.Digest()}
+
+	store := cas.NewMemoryStore()
+	for _, id := range []artifact.ID{rtID, packID, demoID, otherID, repairID} {
+		store.Add(id)
+	}
+	actions := func(force []string) map[string]string {
+		opts := Options{UpgradeStrategy: "pinned", RequirementsPath: reqPath, PackCatalog: cat, ArtifactStore: store, ForceRebuild: force}
+		snap, err := computeWithResolver(dir, "3.11", "manylinux2014_s390x", opts, nil)
+		if err != nil {
+			t.Fatalf("compute failed: %v", err)
+		}
+		out := map[string]string{}
+		for _, n := range snap.DAG {
+			out[n.ID.Digest] = n.Action
+		}
+		return out
+	}
+
+	got := actions([]string{"demo"})
+	if got[demoID.Digest] != "build" || got[packID.Digest] != "build" || got[repairID.Digest] != "build" {
+		t.Fatalf("forced package should rebuild its wheel, pack, and repair despite CAS hits: %+v", got)
+	}
+	if got[otherID.Digest] != "reuse" || got[rtID.Digest] != "reuse" {
+		t.Fatalf("unforced artifacts should still reuse: %+v", got)
+	}
+	for digest, action := range actions([]string{"*"}) {
+		if action != "build" {
+			t.Fatalf("force all left %s as %s", digest, action)
+		}
+	}
+}
+
 func TestLoadWriteRoundTrip(t *testing.T) {
 	dir := t.TempDir()
 	path := filepath.Join(dir, "plan.json")
@@ -297,16 +402,96 @@ func TestLoadWriteRoundTrip(t *testing.T) {
 }
 
 func TestIsCompatible(t *testing.T) {
-	ok := isCompatible(wheelInfo{Name: "p", Version: "1", PythonTag: "cp311", AbiTag: "cp311", PlatformTag: "manylinux2014_s390x"}, "cp311", "manylinux2014_s390x")
+	ok := isCompatible(wheelname.Wheel{Name: "p", Version: "1", PythonTag: "cp311", AbiTag: "cp311", PlatformTag: "manylinux2014_s390x"}, "cp311", "manylinux2014_s390x")
 	if !ok {
 		t.Fatalf("expected compatible")
 	}
-	not := isCompatible(wheelInfo{Name: "p", Version: "1", PythonTag: "cp311", AbiTag: "cp311", PlatformTag: "manylinux_x86_64"}, "cp311", "manylinux2014_s390x")
+	not := isCompatible(wheelname.Wheel{Name: "p", Version: "1", PythonTag: "cp311", AbiTag: "cp311", PlatformTag: "manylinux_x86_64"}, "cp311", "manylinux2014_s390x")
 	if not {
 		t.Fatalf("expected incompatible platform")
 	}
 }
 
+func TestIsCompatibleMusllinux(t *testing.T) {
+	musl := wheelname.Wheel{Name: "p", Version: "1", PythonTag: "cp311", AbiTag: "cp311", PlatformTag: "musllinux_1_1_s390x"}
+	if !isCompatible(musl, "cp311", "musllinux_1_2_s390x") {
+		t.Fatalf("expected musllinux wheel reusable for a musl target")
+	}
+	if isCompatible(musl, "cp311", "manylinux2014_s390x") {
+		t.Fatalf("musllinux wheel must not match a manylinux target")
+	}
+	glibc := wheelname.Wheel{Name: "p", Version: "1", PythonTag: "cp311", AbiTag: "cp311", PlatformTag: "manylinux_2_17_s390x"}
+	if isCompatible(glibc, "cp311", "musllinux_1_2_s390x") {
+		t.Fatalf("manylinux wheel must not match a musl target")
+	}
+}
+
+func TestIsCompatibleAbi3(t *testing.T) {
+	w := wheelname.Wheel{Name: "cryptography", Version: "42.0.0", PythonTag: "cp38", AbiTag: "abi3", PlatformTag: "manylinux2014_s390x"}
+	for _, py := range []string{"cp38", "cp311", "cp312"} {
+		if !isCompatible(w, py, "manylinux2014_s390x") {
+			t.Fatalf("expected abi3 wheel reusable on %s", py)
+		}
+	}
+	if isCompatible(w, "cp37", "manylinux2014_s390x") {
+		t.Fatalf("abi3 wheel must not be reused below its cp38 floor")
+	}
+	w.PythonTag = "cp312"
+	if isCompatible(w, "cp311", "manylinux2014_s390x") {
+		t.Fatalf("cp312 abi3 wheel must not be reused on cp311")
+	}
+}
+
+func TestIsCompatibleCompoundTags(t *testing.T) {
+	w, err := wheelname.Parse("demo-1.0-cp39.cp310-cp39.cp310-manylinux1_s390x.manylinux2014_s390x.whl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isCompatible(w, "cp310", "manylinux2014_s390x") {
+		t.Fatalf("expected compound python and platform tags to match cp310/manylinux2014_s390x")
+	}
+	if !isCompatible(w, "cp39", "manylinux2014_s390x") {
+		t.Fatalf("expected the cp39 member to match")
+	}
+	w, _ = wheelname.Parse("demo-1.0-py2.py3-none-manylinux2014_x86_64.manylinux2014_s390x.whl")
+	if !isCompatible(w, "cp311", "manylinux2014_s390x") {
+		t.Fatalf("expected the s390x platform member to match")
+	}
+	w, _ = wheelname.Parse("demo-1.0-cp39.cp310-abi3-manylinux2014_x86_64.linux_x86_64.whl")
+	if isCompatible(w, "cp311", "manylinux2014_s390x") {
+		t.Fatalf("no platform member targets s390x")
+	}
+	if w, _ = wheelname.Parse("demo-1.0-cp39.cp310-abi3-manylinux2014_s390x.whl"); !isCompatible(w, "cp311", "manylinux2014_s390x") {
+		t.Fatalf("expected compound abi3 wheel reusable above its floor")
+	}
+}
+
+func TestPythonVersionFromTag(t *testing.T) {
+	cases := map[string]string{
+		"cp38":    "3.8",
+		"cp310":   "3.10",
+		"cp313":   "3.13",
+		"CP311":   "3.11",
+		"cp3100":  "3.100",
+		"py3":     "3",
+		"py311":   "3.11",
+		"":        "",
+		"cp":      "",
+		"cp3":     "",
+		"cp3x":    "",
+		"cp301":   "",
+		"cp03":    "",
+		"pp39":    "",
+		"abi3":    "",
+		"cp31000": "",
+	}
+	for tag, want := range cases {
+		if got := PythonVersionFromTag(tag); got != want {
+			t.Errorf("PythonVersionFromTag(%q) = %q, want %q", tag, got, want)
+		}
+	}
+}
+
 func TestParseRequiresDist(t *testing.T) {
 	meta := "Metadata-Version: 2.1\nName: demo\nRequires-Dist: depA (>=1.0)\nRequires-Dist: depB\nRequires-Dist: depC (==2.3.4)\n"
 	reqs := parseRequiresDist(meta)
@@ -329,6 +514,74 @@ func TestParseRequiresDistExtrasAndMarkers(t *testing.T) {
 	}
 }
 
+func TestParseWheelMetadata(t *testing.T) {
+	meta := "Metadata-Version: 2.1\nName: demo\nVersion: 0.1.0\nRequires-Python: >=3.9\nProvides-Extra: fast\nProvides-Extra: docs\nRequires-Dist: depA (>=1.0)\n\nLong description\nRequires-Dist: notadep\n"
+	md := ParseWheelMetadata(meta)
+	if md.Name != "demo" || md.Version != "0.1.0" || md.RequiresPython != ">=3.9" {
+		t.Fatalf("unexpected metadata: %+v", md)
+	}
+	if len(md.ProvidesExtra) != 2 || md.ProvidesExtra[0] != "fast" || md.ProvidesExtra[1] != "docs" {
+		t.Fatalf("unexpected extras: %+v", md.ProvidesExtra)
+	}
+	if len(md.Requires) != 1 || md.Requires[0].Name != "depa" {
+		t.Fatalf("body lines should not be parsed as requirements: %+v", md.Requires)
+	}
+}
+
+func TestRequiresPythonAllows(t *testing.T) {
+	cases := []struct {
+		spec, py string
+		want     bool
+	}{
+		{">=3.9", "3.11", true},
+		{">=3.9", "3.8", false},
+		{">=3.9", "cp38", false},
+		{">=3.7,<3.11", "3.11", false},
+		{">=3.7,!=3.10.*", "3.10", false},
+		{">=3.7,!=3.10.*", "3.11", true},
+		{"==3.*", "3.12", true},
+		{"~=3.9", "3.12", true},
+		{"~=3.9.1", "3.10", false},
+		{"garbage", "3.11", true},
+	}
+	for _, c := range cases {
+		if got := RequiresPythonAllows(c.spec, c.py); got != c.want {
+			t.Fatalf("RequiresPythonAllows(%q, %q) = %v, want %v", c.spec, c.py, got, c.want)
+		}
+	}
+}
+
+func TestRequiresPythonRejectsIncompatibleTarget(t *testing.T) {
+	dir := t.TempDir()
+	meta := "Metadata-Version: 2.1\nName: demo\nVersion: 0.1.0\nRequires-Python: >=3.9\nRequires-Dist: depA (==1.0.0)\n"
+	writeWheelWithMeta(t, dir, "demo-0.1.0-py3-none-any.whl", meta)
+	writeWheelWithMeta(t, dir, "other-1.0.0-py3-none-any.whl", "Metadata-Version: 2.1\nName: other\nVersion: 1.0.0\n")
+
+	snap, err := computeWithResolver(dir, "3.8", "manylinux2014_s390x", Options{UpgradeStrategy: "pinned"}, nil)
+	if err != nil {
+		t.Fatalf("compute: %v", err)
+	}
+	for _, n := range snap.Plan {
+		if n.Name == "demo" || n.Name == "depa" {
+			t.Fatalf("wheel requiring python >=3.9 planned for 3.8: %+v", snap.Plan)
+		}
+	}
+
+	snap, err = computeWithResolver(dir, "3.11", "manylinux2014_s390x", Options{UpgradeStrategy: "pinned"}, nil)
+	if err != nil {
+		t.Fatalf("compute: %v", err)
+	}
+	found := false
+	for _, n := range snap.Plan {
+		if n.Name == "demo" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("compatible wheel missing from plan: %+v", snap.Plan)
+	}
+}
+
 func TestGenerateWritesPlan(t *testing.T) {
 	dir := t.TempDir()
 	planDir := filepath.Join(dir, "cache")
@@ -336,7 +589,7 @@ func TestGenerateWritesPlan(t *testing.T) {
 		t.Fatalf("mkdir: %v", err)
 	}
 	os.WriteFile(filepath.Join(dir, "pkg-0.1.0-py3-none-any.whl"), []byte{}, 0o644)
-	_, err := Generate(dir, planDir, "3.11", "manylinux2014_s390x", "", "", "pinned", "", "", nil, nil, nil, "", "")
+	_, err := Generate(dir, planDir, "3.11", "manylinux2014_s390x", "", "", "pinned", "", "", nil, nil, nil, "", "", "")
 	if err != nil {
 		t.Fatalf("generate failed: %v", err)
 	}
@@ -352,7 +605,7 @@ func TestSnapshotRecordsCASConfig(t *testing.T) {
 		t.Fatalf("mkdir: %v", err)
 	}
 	os.WriteFile(filepath.Join(dir, "pkg-0.1.0-py3-none-any.whl"), []byte{}, 0o644)
-	_, err := Generate(dir, planDir, "3.11", "manylinux2014_s390x", "", "", "pinned", "", "", nil, nil, nil, "http://zot", "artifacts")
+	_, err := Generate(dir, planDir, "3.11", "manylinux2014_s390x", "", "", "pinned", "", "", nil, nil, nil, "http://zot", "artifacts", "")
 	if err != nil {
 		t.Fatalf("generate failed: %v", err)
 	}
@@ -561,6 +814,20 @@ func TestMaxDepsLimit(t *testing.T) {
 	}
 }
 
+func TestMaxPlanNodesLimit(t *testing.T) {
+	reqs := []DepSpec{{Name: "alpha", Version: "1.0"}, {Name: "beta", Version: "1.0"}, {Name: "gamma", Version: "1.0"}}
+	opts := Options{UpgradeStrategy: "pinned", MaxPlanNodes: 2}
+	_, err := computeWithResolverInputs(reqs, nil, "3.11", "manylinux2014_s390x", opts, &mockResolver{})
+	if err == nil || !strings.Contains(err.Error(), "plan has 3 build nodes, exceeding MaxPlanNodes (2)") {
+		t.Fatalf("expected MaxPlanNodes error, got %v", err)
+	}
+
+	opts.MaxPlanNodes = 3
+	if _, err := computeWithResolverInputs(reqs, nil, "3.11", "manylinux2014_s390x", opts, &mockResolver{}); err != nil {
+		t.Fatalf("expected plan at the cap to pass, got %v", err)
+	}
+}
+
 func TestPackageOverridesApplyToTopLevelAndDeps(t *testing.T) {
 	dir := t.TempDir()
 	meta := "Requires-Dist: depA\n"
@@ -616,3 +883,496 @@ func TestRequirementsOnlyGeneratesPlan(t *testing.T) {
 		}
 	}
 }
+
+func TestLoadRequirementsFollowsIncludes(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"requirements.txt": "-r base/common.txt\n--requirement=missing.txt\nfoo==1.0\n",
+		"base/common.txt":  "bar==2.0\n-r ../requirements.txt\n",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	reqs := loadRequirements(dir, "")
+	want := []DepSpec{{Name: "bar", Version: "2.0"}, {Name: "foo", Version: "1.0"}}
+	if !reflect.DeepEqual(reqs, want) {
+		t.Fatalf("expected included and local requirements without the missing include, got %+v", reqs)
+	}
+}
+
+func TestHashedRequirementCarriedToPlanNode(t *testing.T) {
+	dir := t.TempDir()
+	req := "foo==1.2.3 \\\n    --hash=sha256:aaa \\\n    --hash=sha256:bbb\nbar==2.0\n"
+	if err := os.WriteFile(filepath.Join(dir, "requirements.txt"), []byte(req), 0o644); err != nil {
+		t.Fatalf("write requirements: %v", err)
+	}
+	opts := Options{UpgradeStrategy: "pinned", RequirementsPath: filepath.Join(dir, "requirements.txt")}
+	snap, err := computeWithResolver(dir, "3.11", "manylinux2014_s390x", opts, nil)
+	if err != nil {
+		t.Fatalf("compute failed: %v", err)
+	}
+	got := map[string][]string{}
+	for _, n := range snap.Plan {
+		got[n.Name+"=="+n.Version] = n.Hashes
+	}
+	want := map[string][]string{"foo==1.2.3": {"sha256:aaa", "sha256:bbb"}, "bar==2.0": nil}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected plan hashes %v", got)
+	}
+}
+
+// redirectTransport sends every request to the test server so the
+// pypi.org-only client can be exercised locally.
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (r redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = r.target.Scheme
+	req.URL.Host = r.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func newTestIndexClient(t *testing.T, h http.HandlerFunc) *IndexClient {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	target, _ := url.Parse(srv.URL)
+	return &IndexClient{
+		BaseURL:    "https://pypi.org/simple",
+		HTTPClient: &http.Client{Transport: redirectTransport{target: target}},
+		Backoff:    time.Millisecond,
+	}
+}
+
+func TestResolveLatestRetriesTransientFailures(t *testing.T) {
+	calls := 0
+	client := newTestIndexClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"info":{"version":"2.1.0"}}`))
+	})
+	ver, err := client.ResolveLatest("demo")
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if ver != "2.1.0" || calls != 3 {
+		t.Fatalf("expected 2.1.0 after 3 calls, got %q after %d", ver, calls)
+	}
+}
+
+func TestResolveLatestDoesNotRetryNotFound(t *testing.T) {
+	calls := 0
+	client := newTestIndexClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusNotFound)
+	})
+	_, err := client.ResolveLatest("missing")
+	if err == nil || errors.Is(err, ErrIndexUnavailable) {
+		t.Fatalf("expected not-found error, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected a single request for 404, got %d", calls)
+	}
+}
+
+func TestPlanFailsWhenIndexUnavailable(t *testing.T) {
+	calls := 0
+	client := newTestIndexClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	reqs := []DepSpec{{Name: "demo"}}
+	_, err := computeWithResolverInputs(reqs, nil, "3.11", "manylinux2014_s390x", Options{}, client)
+	if !errors.Is(err, ErrIndexUnavailable) {
+		t.Fatalf("expected ErrIndexUnavailable, got %v", err)
+	}
+	if calls != defaultIndexAttempts {
+		t.Fatalf("expected %d attempts, got %d", defaultIndexAttempts, calls)
+	}
+}
+
+func TestIndexLookupsCachedWithinPlan(t *testing.T) {
+	calls := 0
+	client := newTestIndexClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		_, _ = w.Write([]byte(`{"info":{"version":"1.4.0"}}`))
+	})
+	// Two seed specs plus the eager pass each ask the resolver for "demo".
+	reqs := []DepSpec{{Name: "demo"}, {Name: "demo", Version: ">=1.0"}}
+	snap, err := computeWithResolverInputs(reqs, nil, "3.11", "manylinux2014_s390x", Options{UpgradeStrategy: "eager"}, client)
+	if err != nil {
+		t.Fatalf("compute: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected exactly one index request, got %d", calls)
+	}
+	for _, n := range snap.Plan {
+		if n.Name == "demo" && n.Version != "1.4.0" {
+			t.Fatalf("expected resolved version 1.4.0, got %s", n.Version)
+		}
+	}
+}
+
+type slowResolver struct {
+	delay time.Duration
+}
+
+func (s slowResolver) ResolveLatest(name string) (string, error) {
+	time.Sleep(s.delay)
+	return "1." + strconv.Itoa(len(name)) + ".0", nil
+}
+
+func manyDeps(n int) ([]DepSpec, []WheelInput) {
+	var reqs []DepSpec
+	var requires []DepSpec
+	for i := 0; i < n; i++ {
+		reqs = append(reqs, DepSpec{Name: fmt.Sprintf("seed-%03d", i)})
+		requires = append(requires, DepSpec{Name: fmt.Sprintf("dep-%03d", i)})
+	}
+	wheels := []WheelInput{{
+		Name:        "app",
+		Version:     "1.0.0",
+		PythonTag:   "cp311",
+		AbiTag:      "cp311",
+		PlatformTag: "manylinux2014_s390x",
+		Requires:    requires,
+	}}
+	return reqs, wheels
+}
+
+func TestParallelResolutionMatchesSequential(t *testing.T) {
+	reqs, wheels := manyDeps(25)
+	resolver := slowResolver{delay: time.Millisecond}
+	seq, err := computeWithResolverInputs(reqs, wheels, "3.11", "manylinux2014_s390x", Options{ResolveConcurrency: 1}, resolver)
+	if err != nil {
+		t.Fatalf("sequential: %v", err)
+	}
+	par, err := computeWithResolverInputs(reqs, wheels, "3.11", "manylinux2014_s390x", Options{ResolveConcurrency: 8}, resolver)
+	if err != nil {
+		t.Fatalf("parallel: %v", err)
+	}
+	seqJSON, _ := json.Marshal(struct {
+		Plan []FlatNode
+		DAG  []DAGNode
+	}{seq.Plan, seq.DAG})
+	parJSON, _ := json.Marshal(struct {
+		Plan []FlatNode
+		DAG  []DAGNode
+	}{par.Plan, par.DAG})
+	if string(seqJSON) != string(parJSON) {
+		t.Fatalf("parallel plan differs from sequential plan")
+	}
+}
+
+func benchmarkResolve(b *testing.B, concurrency int) {
+	reqs, wheels := manyDeps(50)
+	resolver := slowResolver{delay: 2 * time.Millisecond}
+	opts := Options{ResolveConcurrency: concurrency}
+	for i := 0; i < b.N; i++ {
+		if _, err := computeWithResolverInputs(reqs, wheels, "3.11", "manylinux2014_s390x", opts, resolver); err != nil {
+			b.Fatalf("compute failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkResolveSequential(b *testing.B) { benchmarkResolve(b, 1) }
+func BenchmarkResolveParallel(b *testing.B)   { benchmarkResolve(b, 8) }
+
+func TestPlanOutputIsDeterministic(t *testing.T) {
+	reqs, wheels := manyDeps(30)
+	resolver := &mockResolver{versions: map[string]string{}}
+	for _, r := range reqs {
+		resolver.versions[r.Name] = "1.0.0"
+	}
+	render := func() string {
+		snap, err := computeWithResolverInputs(reqs, wheels, "3.11", "manylinux2014_s390x", Options{}, resolver)
+		if err != nil {
+			t.Fatalf("compute: %v", err)
+		}
+		snap.RunID = ""
+		out, err := json.Marshal(snap)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		return string(out)
+	}
+	first := render()
+	for i := 0; i < 5; i++ {
+		if got := render(); got != first {
+			t.Fatalf("plan JSON changed between runs")
+		}
+	}
+}
+
+func TestPackDependencyCycleFailsPlan(t *testing.T) {
+	orig := packDependencyEdges["zlib"]
+	packDependencyEdges["zlib"] = []string{"openssl"}
+	t.Cleanup(func() { packDependencyEdges["zlib"] = orig })
+
+	catalog := &pack.Catalog{
+		Packs: map[string]pack.PackDef{
+			"openssl": {Name: "openssl", Version: "3.0"},
+			"zlib":    {Name: "zlib", Version: "1.3"},
+		},
+		Rules: []pack.Rule{{PackagePattern: "cryptography", Packs: []string{"openssl"}}},
+	}
+	reqs := []DepSpec{{Name: "cryptography", Version: "42.0.0"}}
+	_, err := computeWithResolverInputs(reqs, nil, "3.11", "manylinux2014_s390x", Options{PackCatalog: catalog}, nil)
+	if err == nil || !strings.Contains(err.Error(), "pack dependency cycle: openssl -> zlib -> openssl") {
+		t.Fatalf("expected cycle error, got %v", err)
+	}
+}
+
+func TestCatalogRequiresDrivesPackInputs(t *testing.T) {
+	catalog := &pack.Catalog{
+		Packs: map[string]pack.PackDef{
+			"libfoo": {Name: "libfoo", Version: "2.0", Requires: []string{"libbar"}},
+			"libbar": {Name: "libbar", Version: "1.0", Requires: []string{}},
+			// openssl declares nothing, so the built-in openssl -> zlib edge applies.
+			"openssl": {Name: "openssl", Version: "3.0"},
+			"zlib":    {Name: "zlib", Version: "1.3"},
+		},
+		Rules: []pack.Rule{{PackagePattern: "demo", Packs: []string{"libfoo", "openssl"}}},
+	}
+	reqs := []DepSpec{{Name: "demo", Version: "1.0.0"}}
+	snap, err := computeWithResolverInputs(reqs, nil, "3.11", "manylinux2014_s390x", Options{PackCatalog: catalog}, nil)
+	if err != nil {
+		t.Fatalf("compute: %v", err)
+	}
+	index := map[string]int{}
+	nodes := map[string]DAGNode{}
+	for i, n := range snap.DAG {
+		if n.Type != NodePack {
+			continue
+		}
+		name, _ := n.Metadata["name"].(string)
+		index[name] = i
+		nodes[name] = n
+	}
+	if len(nodes["libfoo"].Inputs) != 1 || nodes["libfoo"].Inputs[0] != nodes["libbar"].ID {
+		t.Fatalf("expected libfoo to depend on libbar, got %v", nodes["libfoo"].Inputs)
+	}
+	if len(nodes["libbar"].Inputs) != 0 {
+		t.Fatalf("expected libbar to have no inputs, got %v", nodes["libbar"].Inputs)
+	}
+	if len(nodes["openssl"].Inputs) != 1 || nodes["openssl"].Inputs[0] != nodes["zlib"].ID {
+		t.Fatalf("expected built-in openssl -> zlib edge, got %v", nodes["openssl"].Inputs)
+	}
+	if index["libbar"] > index["libfoo"] || index["zlib"] > index["openssl"] {
+		t.Fatalf("expected dependencies before dependents, got %v", index)
+	}
+}
+
+func TestArchesGetDistinctArtifactDigests(t *testing.T) {
+	catalog := &pack.Catalog{
+		Packs: map[string]pack.PackDef{"openssl": {Name: "openssl", Version: "3.0"}},
+		Rules: []pack.Rule{{PackagePattern: "demo", Packs: []string{"openssl"}}},
+	}
+	reqs := []DepSpec{{Name: "demo", Version: "1.0.0"}}
+	digests := func(platformTag, arch string) (Snapshot, map[NodeType]string) {
+		snap, err := computeWithResolverInputs(reqs, nil, "3.11", platformTag, Options{PackCatalog: catalog, Arch: arch}, nil)
+		if err != nil {
+			t.Fatalf("compute %s: %v", platformTag, err)
+		}
+		out := map[NodeType]string{}
+		for _, n := range snap.DAG {
+			if n.Type == NodeRuntime || n.Type == NodePack || n.Type == NodeWheel {
+				out[n.Type] = n.ID.Digest
+			}
+		}
+		return snap, out
+	}
+	zSnap, z := digests("manylinux2014_s390x", "")
+	pSnap, p := digests("manylinux2014_ppc64le", "ppc64le")
+	if zSnap.Arch != "s390x" || pSnap.Arch != "ppc64le" {
+		t.Fatalf("expected snapshot arches s390x/ppc64le, got %q/%q", zSnap.Arch, pSnap.Arch)
+	}
+	for _, typ := range []NodeType{NodeRuntime, NodePack, NodeWheel} {
+		if z[typ] == "" || z[typ] == p[typ] {
+			t.Fatalf("%s digests must differ by arch: s390x=%q ppc64le=%q", typ, z[typ], p[typ])
+		}
+	}
+	if _, err := computeWithResolverInputs(reqs, nil, "3.11", "manylinux2014_s390x", Options{Arch: "ppc64le"}, nil); err == nil {
+		t.Fatalf("expected an error when TARGET_ARCH disagrees with the platform tag")
+	}
+}
+
+// hasOnlyStore hides MemoryStore's batch lookup so the planner falls back to Has.
+type hasOnlyStore struct {
+	mem   *cas.MemoryStore
+	calls int
+}
+
+func (s *hasOnlyStore) Has(ctx context.Context, id artifact.ID) (bool, error) {
+	s.calls++
+	return s.mem.Has(ctx, id)
+}
+
+// batchStore counts batch lookups.
+type batchStore struct {
+	*cas.MemoryStore
+	batches int
+}
+
+func (s *batchStore) HasBatch(ctx context.Context, ids []artifact.ID) (map[string]bool, error) {
+	s.batches++
+	return s.MemoryStore.HasBatch(ctx, ids)
+}
+
+func TestBatchHasMatchesSequentialActions(t *testing.T) {
+	catalog := &pack.Catalog{
+		Packs: map[string]pack.PackDef{
+			"openssl": {Name: "openssl", Version: "3.0"},
+			"zlib":    {Name: "zlib", Version: "1.3"},
+		},
+		Rules: []pack.Rule{{PackagePattern: "demo", Packs: []string{"openssl"}}},
+	}
+	reqs := []DepSpec{{Name: "demo", Version: "1.0.0"}, {Name: "other", Version: "2.0.0"}}
+	mem := cas.NewMemoryStore()
+	mem.Add(artifact.ID{Type: artifact.PackType, Digest: artifact.PackKey{Arch: "s390x", Name: "zlib", Version: "1.3"}.Digest()})
+	mem.Add(artifact.ID{Type: artifact.RuntimeType, Digest: artifact.RuntimeKey{Arch: "s390x", PythonVersion: "3.11"}.Digest()})
+
+	actions := func(store cas.Store) map[string]string {
+		snap, err := computeWithResolverInputs(reqs, nil, "3.11", "manylinux2014_s390x", Options{PackCatalog: catalog, ArtifactStore: store}, nil)
+		if err != nil {
+			t.Fatalf("compute: %v", err)
+		}
+		out := map[string]string{}
+		for _, n := range snap.DAG {
+			out[n.ID.Digest] = n.Action
+		}
+		return out
+	}
+	seqStore := &hasOnlyStore{mem: mem}
+	seq := actions(seqStore)
+	bStore := &batchStore{MemoryStore: mem}
+	batch := actions(bStore)
+	if !reflect.DeepEqual(seq, batch) {
+		t.Fatalf("batch actions %v differ from sequential %v", batch, seq)
+	}
+	if bStore.batches != 1 {
+		t.Fatalf("expected a single batch lookup, got %d", bStore.batches)
+	}
+	if seqStore.calls == 0 {
+		t.Fatalf("expected fallback to per-ID Has")
+	}
+	reuse := 0
+	for _, a := range batch {
+		if a == "reuse" {
+			reuse++
+		}
+	}
+	if reuse != 2 {
+		t.Fatalf("expected runtime and zlib pack to be reused, got %d reuse actions", reuse)
+	}
+}
+
+func TestReuseOnlyEmitsOnlyReuseNodes(t *testing.T) {
+	wheels := []WheelInput{
+		{Filename: "purepkg-1.0.0-py3-none-any.whl", Name: "purepkg", Version: "1.0.0", PythonTag: "py3", AbiTag: "none", PlatformTag: "any",
+			Requires: []DepSpec{{Name: "depa", Version: "2.0"}}},
+		{Filename: "native-2.0.0-cp311-cp311-manylinux2014_s390x.whl", Name: "native", Version: "2.0.0", PythonTag: "cp311", AbiTag: "cp311", PlatformTag: "manylinux2014_s390x"},
+		{Filename: "x86only-1.0.0-cp311-cp311-manylinux2014_x86_64.whl", Name: "x86only", Version: "1.0.0", PythonTag: "cp311", AbiTag: "cp311", PlatformTag: "manylinux2014_x86_64"},
+	}
+	reqs := []DepSpec{{Name: "fromreq", Version: "1.0"}}
+	resolver := &mockResolver{versions: map[string]string{}}
+
+	full, err := computeWithResolverInputs(reqs, wheels, "3.11", "manylinux2014_s390x", Options{}, resolver)
+	if err != nil {
+		t.Fatalf("compute: %v", err)
+	}
+	hasBuild := false
+	for _, n := range full.Plan {
+		if n.Action == "build" {
+			hasBuild = true
+		}
+	}
+	if !hasBuild {
+		t.Fatalf("expected build nodes in a normal plan: %+v", full.Plan)
+	}
+
+	snap, err := computeWithResolverInputs(reqs, wheels, "3.11", "manylinux2014_s390x", Options{ReuseOnly: true}, resolver)
+	if err != nil {
+		t.Fatalf("compute reuse-only: %v", err)
+	}
+	var names []string
+	for _, n := range snap.Plan {
+		if n.Action != "reuse" {
+			t.Fatalf("reuse-only plan has %s node for %s", n.Action, n.Name)
+		}
+		names = append(names, n.Name)
+	}
+	if strings.Join(names, ",") != "purepkg,native" {
+		t.Fatalf("expected purepkg,native reuse nodes, got %v", names)
+	}
+	for _, d := range snap.DAG {
+		if d.Type != NodeWheel || d.Action != "reuse" || len(d.Inputs) != 0 {
+			t.Fatalf("reuse-only DAG should hold only input-free reuse wheels, got %+v", d)
+		}
+	}
+}
+
+func TestNewRunIDUniqueUnderConcurrency(t *testing.T) {
+	const n = 10000
+	ids := make([]string, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ids[i] = newRunID()
+		}(i)
+	}
+	wg.Wait()
+	seen := make(map[string]bool, n)
+	for _, id := range ids {
+		if len(id) != 22 || strings.Trim(id, "-0123456789abcdefghijklmnopqrstuvwxyz") != "" {
+			t.Fatalf("malformed run id %q", id)
+		}
+		if seen[id] {
+			t.Fatalf("duplicate run id %q", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestNewRunIDSortsInGenerationOrder(t *testing.T) {
+	prev := newRunID()
+	for i := 0; i < 5000; i++ {
+		id := newRunID()
+		if id <= prev {
+			t.Fatalf("run id %q does not sort after %q", id, prev)
+		}
+		prev = id
+	}
+	ms, _, ok := strings.Cut(prev, "-")
+	if !ok || len(ms) != 13 {
+		t.Fatalf("expected a millisecond timestamp prefix, got %q", prev)
+	}
+	ts, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil || time.Since(time.UnixMilli(ts)) > time.Minute {
+		t.Fatalf("timestamp prefix %q is not recent", ms)
+	}
+}
+
+func TestIncrementBase36(t *testing.T) {
+	b := []byte("0z")
+	if !incrementBase36(b) || string(b) != "10" {
+		t.Fatalf("expected carry to 10, got %q", b)
+	}
+	b = []byte("zz")
+	if incrementBase36(b) || string(b) != "00" {
+		t.Fatalf("expected overflow to wrap to 00, got %q", b)
+	}
+}
@@ -10,10 +10,20 @@ import (
 	"time"
 )
 
-// FileQueue is a simple file-backed queue backend (JSON list).
+// FileQueue is a simple file-backed queue backend (JSON list). Leased
+// requests are tracked in a sidecar "<path>.leases" file until acked.
 type FileQueue struct {
 	path string
 	mu   sync.Mutex
+	// VisibilityTimeout bounds a lease; zero means DefaultVisibilityTimeout.
+	VisibilityTimeout time.Duration
+}
+
+// fileLease is a popped request waiting for Ack or Nack.
+type fileLease struct {
+	Token    string    `json:"token"`
+	Deadline time.Time `json:"deadline"`
+	Request  Request   `json:"request"`
 }
 
 // NewFileQueue creates a queue at the given file path.
@@ -50,6 +60,39 @@ func (f *FileQueue) save(items []Request) error {
 	return os.WriteFile(f.path, data, 0o644)
 }
 
+func (f *FileQueue) leasePath() string {
+	return f.path + ".leases"
+}
+
+func (f *FileQueue) loadLeases() ([]fileLease, error) {
+	data, err := os.ReadFile(f.leasePath())
+	if errors.Is(err, os.ErrNotExist) || (err == nil && len(data) == 0) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var leases []fileLease
+	if err := json.Unmarshal(data, &leases); err != nil {
+		return nil, err
+	}
+	return leases, nil
+}
+
+func (f *FileQueue) saveLeases(leases []fileLease) error {
+	if len(leases) == 0 {
+		if err := os.Remove(f.leasePath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(leases, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(f.leasePath(), data, 0o644)
+}
+
 func (f *FileQueue) Enqueue(ctx context.Context, req Request) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -116,6 +159,8 @@ func (f *FileQueue) Stats(ctx context.Context) (Stats, error) {
 	return stats, nil
 }
 
+// Pop leases up to max requests. Expired leases are returned to the front of
+// the queue first so they are redelivered before newer work.
 func (f *FileQueue) Pop(ctx context.Context, max int) ([]Request, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -128,13 +173,110 @@ func (f *FileQueue) Pop(ctx context.Context, max int) ([]Request, error) {
 	if err != nil {
 		return nil, err
 	}
+	leases, err := f.loadLeases()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var live []fileLease
+	var expired []Request
+	for _, l := range leases {
+		if now.After(l.Deadline) {
+			expired = append(expired, l.Request)
+		} else {
+			live = append(live, l)
+		}
+	}
+	items = append(expired, items...)
 	if max <= 0 || max > len(items) {
 		max = len(items)
 	}
-	toReturn := append([]Request(nil), items[:max]...)
+	timeout := f.VisibilityTimeout
+	if timeout <= 0 {
+		timeout = DefaultVisibilityTimeout
+	}
+	toReturn := make([]Request, 0, max)
+	for _, req := range items[:max] {
+		token := leaseToken()
+		live = append(live, fileLease{Token: token, Deadline: now.Add(timeout), Request: req})
+		req.ack = token
+		toReturn = append(toReturn, req)
+	}
 	remaining := append([]Request(nil), items[max:]...)
+	// Write the leases before trimming the queue so a crash in between
+	// duplicates work instead of losing it.
+	if err := f.saveLeases(live); err != nil {
+		return nil, err
+	}
 	if err := f.save(remaining); err != nil {
 		return nil, err
 	}
 	return toReturn, nil
 }
+
+// Ack drops the leases for processed requests.
+func (f *FileQueue) Ack(ctx context.Context, reqs []Request) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	released, kept, err := f.takeLeases(reqs)
+	if err != nil || len(released) == 0 {
+		return err
+	}
+	return f.saveLeases(kept)
+}
+
+// Nack returns leased requests to the front of the queue.
+func (f *FileQueue) Nack(ctx context.Context, reqs []Request) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	items, err := f.load()
+	if err != nil {
+		return err
+	}
+	released, kept, err := f.takeLeases(reqs)
+	if err != nil || len(released) == 0 {
+		return err
+	}
+	if err := f.save(append(released, items...)); err != nil {
+		return err
+	}
+	return f.saveLeases(kept)
+}
+
+// takeLeases splits the stored leases into the requests held by reqs and the
+// leases left outstanding. Callers hold f.mu and persist the result.
+func (f *FileQueue) takeLeases(reqs []Request) ([]Request, []fileLease, error) {
+	tokens := map[string]bool{}
+	for _, req := range reqs {
+		if token, ok := req.ack.(string); ok {
+			tokens[token] = true
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, nil, nil
+	}
+	leases, err := f.loadLeases()
+	if err != nil {
+		return nil, nil, err
+	}
+	var kept []fileLease
+	var released []Request
+	for _, l := range leases {
+		if tokens[l.Token] {
+			released = append(released, l.Request)
+		} else {
+			kept = append(kept, l)
+		}
+	}
+	return released, kept, nil
+}
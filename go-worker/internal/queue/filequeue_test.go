@@ -0,0 +1,61 @@
+package queue
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileQueueRedeliversUnackedAfterVisibilityTimeout(t *testing.T) {
+	q := NewFileQueue(filepath.Join(t.TempDir(), "queue.json"))
+	q.VisibilityTimeout = 50 * time.Millisecond
+	ctx := context.Background()
+	for _, pkg := range []string{"numpy", "scipy"} {
+		if err := q.Enqueue(ctx, Request{Package: pkg, Version: "1.0"}); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+	}
+	leased, err := q.Pop(ctx, 2)
+	if err != nil || len(leased) != 2 {
+		t.Fatalf("pop: %v %d", err, len(leased))
+	}
+	if again, _ := q.Pop(ctx, 2); len(again) != 0 {
+		t.Fatalf("leased items must stay hidden until the timeout, got %v", again)
+	}
+	if err := q.Ack(ctx, leased[:1]); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	redelivered, err := q.Pop(ctx, 2)
+	if err != nil {
+		t.Fatalf("pop: %v", err)
+	}
+	if len(redelivered) != 1 || redelivered[0].Package != "scipy" {
+		t.Fatalf("expected only the unacked scipy to reappear, got %+v", redelivered)
+	}
+}
+
+func TestFileQueueNackReturnsImmediately(t *testing.T) {
+	q := NewFileQueue(filepath.Join(t.TempDir(), "queue.json"))
+	ctx := context.Background()
+	for _, pkg := range []string{"numpy", "scipy"} {
+		if err := q.Enqueue(ctx, Request{Package: pkg, Version: "1.0"}); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+	}
+	first, err := q.Pop(ctx, 1)
+	if err != nil || len(first) != 1 {
+		t.Fatalf("pop: %v %d", err, len(first))
+	}
+	if err := q.Nack(ctx, first); err != nil {
+		t.Fatalf("nack: %v", err)
+	}
+	items, err := q.List(ctx)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(items) != 2 || items[0].Package != "numpy" {
+		t.Fatalf("nacked item should be back at the head, got %+v", items)
+	}
+}
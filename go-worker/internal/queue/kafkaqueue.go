@@ -4,25 +4,68 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/segmentio/kafka-go"
 )
 
 // KafkaQueue is a Kafka-backed queue using a single topic.
-// Pop consumes using a consumer group; List performs a best-effort peek of recent messages.
+// Pop consumes through a consumer group and holds each message until Ack, so
+// workers sharing a group split the topic and a message is only committed
+// once it has been processed. List performs a best-effort peek of recent
+// messages.
 type KafkaQueue struct {
-	brokers string
-	topic   string
-	groupID string
+	brokers    string
+	topic      string
+	groupID    string
+	partitions int
+
+	// newReader opens the group reader; tests swap in a fake.
+	newReader func() kafkaReader
+
+	mu       sync.Mutex
+	reader   kafkaReader
+	inflight map[int][]*kafkaDelivery
+}
+
+// kafkaReader is the part of *kafka.Reader used for group consumption.
+type kafkaReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
 }
 
-// NewKafkaQueue constructs a Kafka queue backend.
-func NewKafkaQueue(brokers, topic string) *KafkaQueue {
+// kafkaDelivery is a fetched message waiting for its Ack.
+type kafkaDelivery struct {
+	msg   kafka.Message
+	acked bool
+}
+
+// NewKafkaQueue constructs a Kafka queue backend. groupID defaults to
+// "refinery-pop"; partitions > 0 makes Ping create a missing topic with that
+// many partitions.
+func NewKafkaQueue(brokers, topic, groupID string, partitions int) *KafkaQueue {
 	if topic == "" {
 		topic = "refinery.queue"
 	}
-	return &KafkaQueue{brokers: brokers, topic: topic, groupID: "refinery-pop"}
+	if groupID == "" {
+		groupID = "refinery-pop"
+	}
+	k := &KafkaQueue{brokers: brokers, topic: topic, groupID: groupID, partitions: partitions, inflight: map[int][]*kafkaDelivery{}}
+	k.newReader = func() kafkaReader {
+		return kafka.NewReader(kafka.ReaderConfig{
+			Brokers:  []string{k.brokers},
+			Topic:    k.topic,
+			GroupID:  k.groupID,
+			MinBytes: 1,
+			MaxBytes: 10e6,
+		})
+	}
+	return k
 }
 
 func (k *KafkaQueue) ensure() error {
@@ -32,10 +75,13 @@ func (k *KafkaQueue) ensure() error {
 	return nil
 }
 
+// writer hashes message keys across the topic's partitions, so requests for
+// one package stay in order on a single partition.
 func (k *KafkaQueue) writer() *kafka.Writer {
 	return &kafka.Writer{
 		Addr:         kafka.TCP(k.brokers),
 		Topic:        k.topic,
+		Balancer:     &kafka.Hash{},
 		RequiredAcks: kafka.RequireAll,
 		BatchTimeout: 50 * time.Millisecond,
 	}
@@ -54,7 +100,7 @@ func (k *KafkaQueue) Enqueue(ctx context.Context, req Request) error {
 	}
 	w := k.writer()
 	defer w.Close()
-	return w.WriteMessages(ctx, kafka.Message{Value: data})
+	return w.WriteMessages(ctx, kafka.Message{Key: []byte(strings.ToLower(req.Package)), Value: data})
 }
 
 func (k *KafkaQueue) List(ctx context.Context) ([]Request, error) {
@@ -111,6 +157,10 @@ func (k *KafkaQueue) Stats(ctx context.Context) (Stats, error) {
 	return Stats{Length: length, OldestAge: 0}, nil
 }
 
+// Pop fetches up to max messages without committing them. Call Ack once the
+// requests are processed; unacked messages are redelivered to the group
+// after a Nack, restart, or rebalance, so the consumer session timeout plays
+// the part of the visibility timeout.
 func (k *KafkaQueue) Pop(ctx context.Context, max int) ([]Request, error) {
 	if err := k.ensure(); err != nil {
 		return nil, err
@@ -118,27 +168,125 @@ func (k *KafkaQueue) Pop(ctx context.Context, max int) ([]Request, error) {
 	if max <= 0 {
 		max = 1
 	}
-	r := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:  []string{k.brokers},
-		Topic:    k.topic,
-		GroupID:  k.groupID,
-		MinBytes: 1,
-		MaxBytes: 10e6,
-	})
-	defer r.Close()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.reader == nil {
+		k.reader = k.newReader()
+	}
 	items := []Request{}
 	for len(items) < max {
-		m, err := r.ReadMessage(ctx)
+		m, err := k.reader.FetchMessage(ctx)
 		if err != nil {
 			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
 				break
 			}
 			return items, err
 		}
+		d := &kafkaDelivery{msg: m}
+		k.inflight[m.Partition] = append(k.inflight[m.Partition], d)
 		var req Request
-		if err := json.Unmarshal(m.Value, &req); err == nil {
-			items = append(items, req)
+		if err := json.Unmarshal(m.Value, &req); err != nil {
+			// Nothing to process; let the offset move past it.
+			d.acked = true
+			continue
 		}
+		req.ack = d
+		items = append(items, req)
 	}
 	return items, nil
 }
+
+// Ack marks requests from Pop as processed and commits, per partition, the
+// offset of the last message before the first one still in flight.
+func (k *KafkaQueue) Ack(ctx context.Context, reqs []Request) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, req := range reqs {
+		if d, ok := req.ack.(*kafkaDelivery); ok {
+			d.acked = true
+		}
+	}
+	var commit []kafka.Message
+	for partition, pending := range k.inflight {
+		n := 0
+		for n < len(pending) && pending[n].acked {
+			n++
+		}
+		if n == 0 {
+			continue
+		}
+		commit = append(commit, pending[n-1].msg)
+		k.inflight[partition] = pending[n:]
+	}
+	if len(commit) == 0 || k.reader == nil {
+		return nil
+	}
+	return k.reader.CommitMessages(ctx, commit...)
+}
+
+// Nack hands requests back for redelivery. Kafka cannot return single
+// messages, so the reader leaves the group and the next Pop resumes from the
+// last committed offset; anything after it is delivered again.
+func (k *KafkaQueue) Nack(ctx context.Context, reqs []Request) error {
+	if len(reqs) == 0 {
+		return nil
+	}
+	return k.Close()
+}
+
+// Close leaves the consumer group.
+func (k *KafkaQueue) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.reader == nil {
+		return nil
+	}
+	err := k.reader.Close()
+	k.reader = nil
+	k.inflight = map[int][]*kafkaDelivery{}
+	return err
+}
+
+// Ping dials the broker and fetches cluster metadata.
+func (k *KafkaQueue) Ping(ctx context.Context) error {
+	if err := k.ensure(); err != nil {
+		return err
+	}
+	conn, err := kafka.DialContext(ctx, "tcp", k.brokers)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Brokers(); err != nil {
+		return err
+	}
+	if k.partitions <= 0 {
+		return nil
+	}
+	return k.ensureTopic(ctx, conn)
+}
+
+// ensureTopic creates the topic with the configured partition count if it
+// does not exist yet. Existing topics are left as they are.
+func (k *KafkaQueue) ensureTopic(ctx context.Context, conn *kafka.Conn) error {
+	if parts, err := conn.ReadPartitions(k.topic); err == nil && len(parts) > 0 {
+		return nil
+	}
+	controller, err := conn.Controller()
+	if err != nil {
+		return err
+	}
+	cc, err := kafka.DialContext(ctx, "tcp", net.JoinHostPort(controller.Host, strconv.Itoa(controller.Port)))
+	if err != nil {
+		return err
+	}
+	defer cc.Close()
+	err = cc.CreateTopics(kafka.TopicConfig{Topic: k.topic, NumPartitions: k.partitions, ReplicationFactor: 1})
+	if errors.Is(err, kafka.TopicAlreadyExists) {
+		return nil
+	}
+	return err
+}
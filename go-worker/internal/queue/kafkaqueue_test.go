@@ -0,0 +1,71 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeKafkaReader serves queued messages and records commits.
+type fakeKafkaReader struct {
+	msgs      []kafka.Message
+	committed []int64
+}
+
+func (f *fakeKafkaReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	if len(f.msgs) == 0 {
+		<-ctx.Done()
+		return kafka.Message{}, ctx.Err()
+	}
+	m := f.msgs[0]
+	f.msgs = f.msgs[1:]
+	return m, nil
+}
+
+func (f *fakeKafkaReader) CommitMessages(_ context.Context, msgs ...kafka.Message) error {
+	for _, m := range msgs {
+		f.committed = append(f.committed, m.Offset)
+	}
+	return nil
+}
+
+func (f *fakeKafkaReader) Close() error { return nil }
+
+func TestKafkaQueueCommitsOnlyAckedPrefix(t *testing.T) {
+	fake := &fakeKafkaReader{}
+	for i, pkg := range []string{"numpy", "scipy", "lxml"} {
+		data, _ := json.Marshal(Request{Package: pkg, Version: "1.0"})
+		fake.msgs = append(fake.msgs, kafka.Message{Partition: 0, Offset: int64(i), Value: data})
+	}
+	k := NewKafkaQueue("broker:9092", "", "", 0)
+	k.newReader = func() kafkaReader { return fake }
+	ctx := context.Background()
+
+	reqs, err := k.Pop(ctx, 3)
+	if err != nil || len(reqs) != 3 {
+		t.Fatalf("pop: %v %d", err, len(reqs))
+	}
+	if len(fake.committed) != 0 {
+		t.Fatalf("pop must not commit, got %v", fake.committed)
+	}
+	// A later message finishing first must not move the offset past an
+	// earlier one still being processed.
+	if err := k.Ack(ctx, reqs[1:2]); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	if len(fake.committed) != 0 {
+		t.Fatalf("commit advanced past unprocessed offset 0: %v", fake.committed)
+	}
+	if err := k.Ack(ctx, reqs[:1]); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	if err := k.Ack(ctx, reqs[2:]); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	if !reflect.DeepEqual(fake.committed, []int64{1, 2}) {
+		t.Fatalf("expected commits at offsets 1 then 2, got %v", fake.committed)
+	}
+}
@@ -1,6 +1,12 @@
 package queue
 
-import "context"
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
 
 // Request is a retry/build request stored in the queue.
 type Request struct {
@@ -14,19 +20,55 @@ type Request struct {
 	Attempts      int      `json:"attempts,omitempty"`
 	PlanID        int64    `json:"plan_id,omitempty"`
 	RunID         string   `json:"run_id,omitempty"`
+
+	// ack identifies the lease or delivery that Ack and Nack settle.
+	ack any
 }
 
-// Backend defines operations for the queue.
+// Backend defines operations for the queue. Pop leases requests rather than
+// removing them: each one must be confirmed with Ack once processed or handed
+// back with Nack. A lease that is neither acked nor nacked within the
+// visibility timeout (for example because the worker crashed) expires and the
+// request is delivered again, so delivery is at-least-once.
 type Backend interface {
 	Enqueue(ctx context.Context, req Request) error
 	List(ctx context.Context) ([]Request, error)
 	Clear(ctx context.Context) error
 	Stats(ctx context.Context) (Stats, error)
 	Pop(ctx context.Context, max int) ([]Request, error)
+	Ack(ctx context.Context, reqs []Request) error
+	Nack(ctx context.Context, reqs []Request) error
 }
 
+// DefaultVisibilityTimeout is how long a popped request stays leased before
+// it is redelivered when the backend has no explicit timeout.
+const DefaultVisibilityTimeout = time.Hour
+
 // Stats summarizes queue depth and oldest item age.
 type Stats struct {
 	Length    int   `json:"length"`
 	OldestAge int64 `json:"oldest_age_seconds"`
 }
+
+// Pinger is implemented by backends that can check their connection before
+// the service starts using them.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// ValidateBackend rejects QUEUE_BACKEND values other than file, redis, and
+// kafka. An empty value selects the file queue.
+func ValidateBackend(name string) error {
+	switch name {
+	case "", "file", "redis", "kafka":
+		return nil
+	}
+	return fmt.Errorf("unknown QUEUE_BACKEND %q (want file, redis, or kafka)", name)
+}
+
+// leaseToken returns a random id for a leased request.
+func leaseToken() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
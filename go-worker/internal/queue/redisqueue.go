@@ -9,10 +9,46 @@ import (
 	redis "github.com/redis/go-redis/v9"
 )
 
-// RedisQueue is a simple Redis-backed implementation using a list.
+// RedisQueue is a simple Redis-backed implementation using a list. Leased
+// requests move to a "<key>:processing" sorted set scored by lease deadline
+// until they are acked.
 type RedisQueue struct {
 	client *redis.Client
 	key    string
+	// VisibilityTimeout bounds a lease; zero means DefaultVisibilityTimeout.
+	VisibilityTimeout time.Duration
+}
+
+// Processing members are "<token> <payload>" so identical payloads hold
+// separate leases.
+var (
+	redisLeaseScript = redis.NewScript(`
+local v = redis.call('LPOP', KEYS[1])
+if not v then return false end
+redis.call('ZADD', KEYS[2], ARGV[1], ARGV[2] .. ' ' .. v)
+return v`)
+	redisReclaimScript = redis.NewScript(`
+local expired = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', ARGV[1])
+for i = #expired, 1, -1 do
+  local m = expired[i]
+  redis.call('ZREM', KEYS[2], m)
+  redis.call('LPUSH', KEYS[1], string.sub(m, string.find(m, ' ', 1, true) + 1))
+end
+return #expired`)
+	redisNackScript = redis.NewScript(`
+local n = 0
+for i = #ARGV, 1, -1 do
+  local m = ARGV[i]
+  if redis.call('ZREM', KEYS[2], m) == 1 then
+    redis.call('LPUSH', KEYS[1], string.sub(m, string.find(m, ' ', 1, true) + 1))
+    n = n + 1
+  end
+end
+return n`)
+)
+
+func (r *RedisQueue) processingKey() string {
+	return r.key + ":processing"
 }
 
 // NewRedisQueue creates a Redis-backed queue. If url is empty, operations will error.
@@ -98,6 +134,8 @@ func (r *RedisQueue) Stats(ctx context.Context) (Stats, error) {
 	return stats, nil
 }
 
+// Pop leases up to max requests. Expired leases are pushed back to the head
+// of the list first so they are redelivered before newer work.
 func (r *RedisQueue) Pop(ctx context.Context, max int) ([]Request, error) {
 	if err := r.ensure(); err != nil {
 		return nil, err
@@ -106,8 +144,19 @@ func (r *RedisQueue) Pop(ctx context.Context, max int) ([]Request, error) {
 	if max <= 0 {
 		max = 1
 	}
+	keys := []string{r.key, r.processingKey()}
+	now := time.Now()
+	if err := redisReclaimScript.Run(ctx, r.client, keys, now.UnixMilli()).Err(); err != nil {
+		return items, err
+	}
+	timeout := r.VisibilityTimeout
+	if timeout <= 0 {
+		timeout = DefaultVisibilityTimeout
+	}
+	deadline := now.Add(timeout).UnixMilli()
 	for i := 0; i < max; i++ {
-		val, err := r.client.LPop(ctx, r.key).Result()
+		token := leaseToken()
+		val, err := redisLeaseScript.Run(ctx, r.client, keys, deadline, token).Text()
 		if errors.Is(err, redis.Nil) {
 			break
 		}
@@ -115,9 +164,55 @@ func (r *RedisQueue) Pop(ctx context.Context, max int) ([]Request, error) {
 			return items, err
 		}
 		var req Request
-		if err := json.Unmarshal([]byte(val), &req); err == nil {
-			items = append(items, req)
+		if err := json.Unmarshal([]byte(val), &req); err != nil {
+			// Unreadable payloads would only come back; drop the lease.
+			r.client.ZRem(ctx, r.processingKey(), token+" "+val)
+			continue
 		}
+		req.ack = token + " " + val
+		items = append(items, req)
 	}
 	return items, nil
 }
+
+// Ack removes processed requests from the processing set.
+func (r *RedisQueue) Ack(ctx context.Context, reqs []Request) error {
+	if err := r.ensure(); err != nil {
+		return err
+	}
+	members := leaseMembers(reqs)
+	if len(members) == 0 {
+		return nil
+	}
+	return r.client.ZRem(ctx, r.processingKey(), members...).Err()
+}
+
+// Nack moves leased requests back to the head of the list.
+func (r *RedisQueue) Nack(ctx context.Context, reqs []Request) error {
+	if err := r.ensure(); err != nil {
+		return err
+	}
+	members := leaseMembers(reqs)
+	if len(members) == 0 {
+		return nil
+	}
+	return redisNackScript.Run(ctx, r.client, []string{r.key, r.processingKey()}, members...).Err()
+}
+
+func leaseMembers(reqs []Request) []any {
+	var members []any
+	for _, req := range reqs {
+		if m, ok := req.ack.(string); ok {
+			members = append(members, m)
+		}
+	}
+	return members
+}
+
+// Ping checks that the Redis server answers.
+func (r *RedisQueue) Ping(ctx context.Context) error {
+	if err := r.ensure(); err != nil {
+		return err
+	}
+	return r.client.Ping(ctx).Err()
+}
@@ -0,0 +1,78 @@
+package runner
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// CacheShared mounts CacheDir writable into every job.
+	CacheShared = "shared"
+	// CacheIsolated gives each job a private writable cache under CacheDir/jobs.
+	CacheIsolated = "isolated"
+)
+
+// prepareJobCache creates the per-job cache directory, with the upper and
+// work dirs of its pip overlay, when the isolated strategy is active. It
+// returns "" when jobs share CacheDir directly.
+func (p *PodmanRunner) prepareJobCache(job Job) (string, error) {
+	if p.CacheStrategy != CacheIsolated || p.CacheDir == "" {
+		return "", nil
+	}
+	name := fmt.Sprintf("%s-%s-%d", cacheSafe(job.Name), cacheSafe(job.Version), p.cacheSeq.Add(1))
+	dir := filepath.Join(p.CacheDir, "jobs", name)
+	for _, sub := range []string{filepath.Join(dir, "pip"), filepath.Join(dir, "pip-work"), filepath.Join(p.CacheDir, "pip")} {
+		if err := os.MkdirAll(sub, 0o755); err != nil {
+			return "", fmt.Errorf("prepare job cache: %w", err)
+		}
+	}
+	return dir, nil
+}
+
+// releaseJobCache folds new entries from a job's pip overlay upper layer back
+// into the shared cache and removes the job directory. Merges are serialized
+// so only one job mutates the shared cache at a time; existing entries are
+// kept, and overlay whiteouts are skipped so a job cannot delete shared
+// entries.
+func (p *PodmanRunner) releaseJobCache(dir string) {
+	p.cacheMu.Lock()
+	defer p.cacheMu.Unlock()
+	src := filepath.Join(dir, "pip")
+	dst := filepath.Join(p.CacheDir, "pip")
+	_ = filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return nil
+		}
+		target := filepath.Join(dst, rel)
+		if _, err := os.Stat(target); err == nil {
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return nil
+		}
+		_ = os.Rename(path, target)
+		return nil
+	})
+	_ = os.RemoveAll(dir)
+}
+
+func cacheSafe(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_':
+			return r
+		default:
+			return '-'
+		}
+	}, s)
+}
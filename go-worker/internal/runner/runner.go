@@ -8,8 +8,11 @@ import (
 	"fmt"
 	"io"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -30,7 +33,84 @@ type Job struct {
 	WheelSourceDigest string
 	RepairToolVersion string
 	RepairPolicyHash  string
+	PlanID            int64
+	RunID             string
 	LogWriter         io.Writer
+	// Hashes pin the downloaded source to pip --hash values; when set the
+	// build runs with --require-hashes, and a runner with a custom RunCmd
+	// refuses the job.
+	Hashes []string
+	// MemoryMB and CPUs are per-package limits from the plan node. Zero
+	// uses the runner's Memory and CPUs.
+	MemoryMB int
+	CPUs     float64
+}
+
+// Resource kinds reported by ResourceError.
+const (
+	ResourceMemory   = "memory"
+	ResourceCPU      = "cpu"
+	ResourceFileSize = "file size"
+)
+
+// ResourceError reports a build the kernel killed for exceeding a resource
+// limit rather than one that failed on its own. Exit 137 (SIGKILL) is how
+// the cgroup OOM killer shows up through podman.
+type ResourceError struct {
+	Kind     string
+	ExitCode int
+	Limit    string
+	Err      error
+}
+
+func (e *ResourceError) Error() string {
+	msg := fmt.Sprintf("build killed: %s limit exceeded (exit %d)", e.Kind, e.ExitCode)
+	if e.Limit != "" {
+		msg += fmt.Sprintf(", limit %s", e.Limit)
+	}
+	return msg
+}
+
+func (e *ResourceError) Unwrap() error { return e.Err }
+
+// Suggestion tells the operator which knob to turn.
+func (e *ResourceError) Suggestion() string {
+	switch e.Kind {
+	case ResourceMemory:
+		return "raise RUNNER_MEMORY or lower BUILD_POOL_SIZE so the build has more memory"
+	case ResourceCPU:
+		return "raise the container CPU time limit"
+	default:
+		return "raise the container file size limit"
+	}
+}
+
+var oomLogMarkers = []string{"OOMKilled", "Out of memory: Killed process", "oom-kill"}
+
+// resourceKill recognizes a limit kill from the exit status and log. Exit
+// codes are 128 plus the signal: SIGKILL for OOM, SIGXCPU and SIGXFSZ for
+// rlimits. Only the memory kill reports a limit: SIGXCPU comes from the CPU
+// time rlimit, not podman's --cpus share.
+func (p *PodmanRunner) resourceKill(job Job, err error, logContent string) *ResourceError {
+	var exitErr *exec.ExitError
+	code := 0
+	if errors.As(err, &exitErr) {
+		code = exitErr.ExitCode()
+	}
+	switch code {
+	case 137:
+		return &ResourceError{Kind: ResourceMemory, ExitCode: code, Limit: p.memoryLimit(job), Err: err}
+	case 152:
+		return &ResourceError{Kind: ResourceCPU, ExitCode: code, Err: err}
+	case 153:
+		return &ResourceError{Kind: ResourceFileSize, ExitCode: code, Err: err}
+	}
+	for _, marker := range oomLogMarkers {
+		if strings.Contains(logContent, marker) {
+			return &ResourceError{Kind: ResourceMemory, ExitCode: code, Limit: p.memoryLimit(job), Err: err}
+		}
+	}
+	return nil
 }
 
 // Runner executes build jobs.
@@ -49,6 +129,26 @@ type PodmanRunner struct {
 	Bin         string
 	Timeout     time.Duration
 	RunCmd      []string
+	// CacheStrategy controls how concurrent jobs share CacheDir: "shared"
+	// (default) mounts it writable into every job, "isolated" gives each job
+	// its own writable cache seeded from a read-only view of CacheDir.
+	CacheStrategy string
+	// Security hardening, all off by default. NoNetwork runs builds with
+	// --network=none so packages that download at build time fail loudly;
+	// ReadOnlyRoot mounts the image read-only (podman keeps a tmpfs on /tmp);
+	// CapDrop lists capabilities to drop (e.g. ALL); User runs the build as
+	// a non-root uid[:gid]. Recipes that install system packages need root.
+	NoNetwork    bool
+	ReadOnlyRoot bool
+	CapDrop      []string
+	User         string
+	// Memory and CPUs cap the container through podman's --memory and
+	// --cpus (e.g. "4g" and "2"). Empty leaves podman's defaults.
+	Memory string
+	CPUs   string
+
+	cacheMu  sync.Mutex
+	cacheSeq atomic.Uint64
 }
 
 func pyTagFromVersion(ver string) string {
@@ -69,6 +169,11 @@ func pyTagFromVersion(ver string) string {
 // invoke the build script inside the container. Here we simulate success for tests.
 func (p *PodmanRunner) Run(ctx context.Context, job Job) (time.Duration, string, error) {
 	start := time.Now()
+	if len(job.Hashes) > 0 && len(p.RunCmd) > 0 {
+		// Only the default build command passes REQUIRE_HASHES to pip, so a
+		// custom one would build the source unverified.
+		return time.Since(start), "", fmt.Errorf("job %s %s pins source hashes, which WORKER_RUN_CMD does not enforce; unset WORKER_RUN_CMD to build it", job.Name, job.Version)
+	}
 	bin := p.Bin
 	if bin == "" {
 		if path, err := exec.LookPath("podman"); err == nil {
@@ -77,7 +182,14 @@ func (p *PodmanRunner) Run(ctx context.Context, job Job) (time.Duration, string,
 			return time.Since(start), "", fmt.Errorf("podman binary not found; set PODMAN_BIN")
 		}
 	}
-	args := p.buildArgs(job)
+	jobCache, err := p.prepareJobCache(job)
+	if err != nil {
+		return time.Since(start), "", err
+	}
+	if jobCache != "" {
+		defer p.releaseJobCache(jobCache)
+	}
+	args := p.buildArgs(job, jobCache)
 
 	runCtx := ctx
 	if p.Timeout > 0 {
@@ -133,15 +245,24 @@ func (p *PodmanRunner) Run(ctx context.Context, job Job) (time.Duration, string,
 		stream(stderr)
 	}()
 
-	err = execCmd.Wait()
+	// Drain both pipes before Wait closes them.
 	wg.Wait()
+	err = execCmd.Wait()
 	elapsed := time.Since(start)
 	statusLine := ""
 	reason := ""
+	var resErr *ResourceError
 	if err != nil {
 		reason = "error"
 		if errors.Is(runCtx.Err(), context.DeadlineExceeded) {
 			reason = "timeout"
+		} else if errors.Is(ctx.Err(), context.Canceled) {
+			reason = "cancelled"
+		} else if resErr = p.resourceKill(job, err, buf.String()); resErr != nil {
+			reason = "oom"
+			if resErr.Kind != ResourceMemory {
+				reason = "resource"
+			}
 		}
 		statusLine = fmt.Sprintf("status=error reason=%s elapsed_ms=%d\n", reason, elapsed.Milliseconds())
 	} else {
@@ -149,6 +270,9 @@ func (p *PodmanRunner) Run(ctx context.Context, job Job) (time.Duration, string,
 	}
 	writeChunk([]byte(statusLine))
 	logContent := strings.TrimRight(buf.String(), "\n")
+	if resErr != nil {
+		return elapsed, logContent, fmt.Errorf("podman run failed (%s): %w", reason, resErr)
+	}
 	if err != nil {
 		return elapsed, logContent, fmt.Errorf("podman run failed (%s): %w", reason, err)
 	}
@@ -226,12 +350,24 @@ if [ -n "${RECIPES:-}" ]; then
     "${PYBIN}" -m pip install "${pip_pkgs[@]}"
   fi
 fi
+if [ -n "${REQUIRE_HASHES:-}" ]; then
+  line="${spec}"
+  for h in $(echo "${REQUIRE_HASHES}" | tr ',' ' '); do
+    line="${line} --hash=${h}"
+  done
+  echo "${line}" > /tmp/refinery-hashed-requirements.txt
+  exec "${PYBIN}" -m pip wheel --require-hashes -r /tmp/refinery-hashed-requirements.txt -w /output --no-deps
+fi
 exec "${PYBIN}" -m pip wheel "${spec}" -w /output --no-deps`,
 	}
 }
 
 // buildArgs assembles the podman arguments with mounts, env, image, and command.
-func (p *PodmanRunner) buildArgs(job Job) []string {
+// A non-empty jobCache replaces the shared cache mount with the job's own
+// writable directory and overlays the shared pip cache at /cache/pip: reads
+// come from CacheDir/pip without copying it, writes land in the job's upper
+// layer.
+func (p *PodmanRunner) buildArgs(job Job, jobCache string) []string {
 	tag := job.PythonTag
 	if tag == "" {
 		tag = pyTagFromVersion(job.PythonVersion)
@@ -240,10 +376,22 @@ func (p *PodmanRunner) buildArgs(job Job) []string {
 	args := []string{
 		"run", "--rm",
 		"-v", fmt.Sprintf("%s:/output", p.OutputDir),
-		"-v", fmt.Sprintf("%s:/cache", p.CacheDir),
+	}
+	args = append(args, p.securityArgs()...)
+	args = append(args, p.resourceArgs(job)...)
+	if jobCache != "" {
+		args = append(args,
+			"-v", fmt.Sprintf("%s:/cache", jobCache),
+			"-v", fmt.Sprintf("%s:/cache/pip:O,upperdir=%s,workdir=%s",
+				filepath.Join(p.CacheDir, "pip"), filepath.Join(jobCache, "pip"), filepath.Join(jobCache, "pip-work")),
+		)
+	} else {
+		args = append(args, "-v", fmt.Sprintf("%s:/cache", p.CacheDir))
+	}
+	args = append(args,
 		"-e", fmt.Sprintf("JOB_NAME=%s", job.Name),
 		"-e", fmt.Sprintf("JOB_VERSION=%s", job.Version),
-	}
+	)
 	if p.InputDir != "" {
 		args = append(args, "-v", fmt.Sprintf("%s:/input:ro", p.InputDir))
 	}
@@ -288,6 +436,9 @@ func (p *PodmanRunner) buildArgs(job Job) []string {
 	if job.RepairPolicyHash != "" {
 		args = append(args, "-e", fmt.Sprintf("REPAIR_POLICY_HASH=%s", job.RepairPolicyHash))
 	}
+	if len(job.Hashes) > 0 {
+		args = append(args, "-e", fmt.Sprintf("REQUIRE_HASHES=%s", strings.Join(job.Hashes, ",")))
+	}
 	image := p.defaultImage()
 	cmdArgs := p.buildCmd(job)
 	args = append(args, image)
@@ -295,6 +446,52 @@ func (p *PodmanRunner) buildArgs(job Job) []string {
 	return args
 }
 
+// securityArgs returns the podman flags for the configured hardening.
+func (p *PodmanRunner) securityArgs() []string {
+	var args []string
+	if p.NoNetwork {
+		args = append(args, "--network=none")
+	}
+	if p.ReadOnlyRoot {
+		args = append(args, "--read-only")
+	}
+	for _, c := range p.CapDrop {
+		args = append(args, "--cap-drop="+c)
+	}
+	if p.User != "" {
+		args = append(args, "--user="+p.User)
+	}
+	return args
+}
+
+// resourceArgs returns the podman flags for the job's limits.
+func (p *PodmanRunner) resourceArgs(job Job) []string {
+	var args []string
+	if mem := p.memoryLimit(job); mem != "" {
+		args = append(args, "--memory="+mem)
+	}
+	if cpus := p.cpuLimit(job); cpus != "" {
+		args = append(args, "--cpus="+cpus)
+	}
+	return args
+}
+
+// memoryLimit is the job's own limit when the plan set one, else the
+// runner's global limit.
+func (p *PodmanRunner) memoryLimit(job Job) string {
+	if job.MemoryMB > 0 {
+		return fmt.Sprintf("%dm", job.MemoryMB)
+	}
+	return p.Memory
+}
+
+func (p *PodmanRunner) cpuLimit(job Job) string {
+	if job.CPUs > 0 {
+		return strconv.FormatFloat(job.CPUs, 'f', -1, 64)
+	}
+	return p.CPUs
+}
+
 // FakeRunner is used in tests.
 type FakeRunner struct {
 	Calls []Job
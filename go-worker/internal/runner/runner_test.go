@@ -2,8 +2,12 @@ package runner
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -33,7 +37,7 @@ func TestPodmanRunnerBuildArgs(t *testing.T) {
 		PlatformTag: "manylinux2014_s390x",
 	}
 	job := Job{Name: "pkg", Version: "1.0.0", PythonTag: "cp311", PlatformTag: "manylinux2014_s390x", Recipes: []string{"a", "b"}}
-	args := r.buildArgs(job)
+	args := r.buildArgs(job, "")
 	joined := strings.Join(args, " ")
 	want := []string{
 		"-v /in:/input:ro",
@@ -53,7 +57,63 @@ func TestPodmanRunnerBuildArgs(t *testing.T) {
 	}
 }
 
+func TestPodmanRunnerPassesRequiredHashes(t *testing.T) {
+	r := &PodmanRunner{OutputDir: "/out", CacheDir: "/cache"}
+	job := Job{Name: "pkg", Version: "1.0.0", Hashes: []string{"sha256:aaa", "sha256:bbb"}}
+	joined := strings.Join(r.buildArgs(job, ""), " ")
+	if !strings.Contains(joined, "-e REQUIRE_HASHES=sha256:aaa,sha256:bbb") {
+		t.Fatalf("missing REQUIRE_HASHES in %q", joined)
+	}
+	if !strings.Contains(joined, "--require-hashes") {
+		t.Fatalf("default build command should enforce hashes: %q", joined)
+	}
+	if strings.Contains(strings.Join(r.buildArgs(Job{Name: "pkg"}, ""), " "), "REQUIRE_HASHES=") {
+		t.Fatalf("unhashed jobs must not set REQUIRE_HASHES")
+	}
+}
+
+func TestPodmanRunnerRejectsHashesWithCustomCommand(t *testing.T) {
+	r := &PodmanRunner{Bin: "true", OutputDir: "/out", CacheDir: "/cache", RunCmd: []string{"/bin/sh", "-c", "make wheel"}}
+	_, _, err := r.Run(context.Background(), Job{Name: "pkg", Version: "1.0.0", Hashes: []string{"sha256:aaa"}})
+	if err == nil || !strings.Contains(err.Error(), "WORKER_RUN_CMD") {
+		t.Fatalf("expected hashed job to be refused with a custom command, got %v", err)
+	}
+	if _, _, err := r.Run(context.Background(), Job{Name: "pkg", Version: "1.0.0"}); err != nil {
+		t.Fatalf("unhashed job should run with a custom command: %v", err)
+	}
+}
+
 // PodmanRunner now fails if podman is missing; ensure error is returned.
+func TestPodmanRunnerLockedDownFlags(t *testing.T) {
+	r := &PodmanRunner{
+		OutputDir:    "/out",
+		CacheDir:     "/cache",
+		NoNetwork:    true,
+		ReadOnlyRoot: true,
+		CapDrop:      []string{"ALL"},
+		User:         "1000:1000",
+	}
+	args := r.buildArgs(Job{Name: "pkg", Version: "1.0.0", PythonTag: "cp311"}, "")
+	image := -1
+	for i, a := range args {
+		if a == "refinery-rocky:latest" {
+			image = i
+		}
+	}
+	flags := strings.Join(args[:image], " ")
+	for _, w := range []string{"--network=none", "--read-only", "--cap-drop=ALL", "--user=1000:1000"} {
+		if !strings.Contains(flags, w) {
+			t.Fatalf("missing %q before the image in %q", w, flags)
+		}
+	}
+
+	open := (&PodmanRunner{OutputDir: "/out", CacheDir: "/cache"}).buildArgs(Job{Name: "pkg"}, "")
+	if joined := strings.Join(open, " "); strings.Contains(joined, "--network") || strings.Contains(joined, "--read-only") ||
+		strings.Contains(joined, "--cap-drop") || strings.Contains(joined, "--user") {
+		t.Fatalf("default runner should not add hardening flags: %q", joined)
+	}
+}
+
 func TestPodmanRunnerNoBinary(t *testing.T) {
 	origPath := os.Getenv("PATH")
 	defer func() { _ = os.Setenv("PATH", origPath) }()
@@ -78,3 +138,159 @@ func TestPodmanRunnerFailure(t *testing.T) {
 		t.Logf("no output returned (expected with %s)", bin)
 	}
 }
+
+func TestPodmanRunnerReportsOOMKill(t *testing.T) {
+	// The fake podman dies the way a cgroup OOM kill surfaces: exit 137.
+	bin := filepath.Join(t.TempDir(), "podman")
+	script := "#!/bin/sh\necho 'compiling big_module.cpp'\nexit 137\n"
+	if err := os.WriteFile(bin, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	r := &PodmanRunner{Bin: bin, OutputDir: "/out", CacheDir: "/cache", Memory: "2g", CPUs: "2", RunCmd: []string{"true"}}
+	args := strings.Join(r.buildArgs(Job{Name: "pkg"}, ""), " ")
+	if !strings.Contains(args, "--memory=2g") || !strings.Contains(args, "--cpus=2") {
+		t.Fatalf("expected resource limits in %q", args)
+	}
+	_, logContent, err := r.Run(context.Background(), Job{Name: "pkg", Version: "1.0.0"})
+	var resErr *ResourceError
+	if !errors.As(err, &resErr) {
+		t.Fatalf("expected a ResourceError, got %v", err)
+	}
+	if resErr.Kind != ResourceMemory || resErr.ExitCode != 137 || resErr.Limit != "2g" {
+		t.Fatalf("unexpected resource error: %+v", resErr)
+	}
+	if !strings.Contains(err.Error(), "(oom)") || !strings.Contains(resErr.Suggestion(), "RUNNER_MEMORY") {
+		t.Fatalf("expected an oom reason with a memory suggestion, got %v / %q", err, resErr.Suggestion())
+	}
+	if !strings.Contains(logContent, "reason=oom") {
+		t.Fatalf("expected oom status line in log, got %q", logContent)
+	}
+
+	plain := &PodmanRunner{Bin: "false", OutputDir: "/out", CacheDir: "/cache"}
+	if _, _, err := plain.Run(context.Background(), Job{Name: "pkg"}); errors.As(err, &resErr) {
+		t.Fatalf("an ordinary non-zero exit is not a resource kill: %v", err)
+	}
+}
+
+func TestPodmanRunnerReportsCPUTimeKillWithoutLimit(t *testing.T) {
+	bin := filepath.Join(t.TempDir(), "podman")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\nexit 152\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	r := &PodmanRunner{Bin: bin, OutputDir: "/out", CacheDir: "/cache", CPUs: "2", RunCmd: []string{"true"}}
+	_, logContent, err := r.Run(context.Background(), Job{Name: "pkg", Version: "1.0.0"})
+	var resErr *ResourceError
+	if !errors.As(err, &resErr) {
+		t.Fatalf("expected a ResourceError, got %v", err)
+	}
+	if resErr.Kind != ResourceCPU || resErr.ExitCode != 152 || resErr.Limit != "" {
+		t.Fatalf("SIGXCPU should not report the --cpus share as its limit: %+v", resErr)
+	}
+	if !strings.Contains(logContent, "reason=resource") {
+		t.Fatalf("expected resource status line in log, got %q", logContent)
+	}
+}
+
+func TestPodmanRunnerAppliesPerPackageLimits(t *testing.T) {
+	bin := filepath.Join(t.TempDir(), "podman")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\nprintf '%s\\n' \"$@\"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	r := &PodmanRunner{Bin: bin, OutputDir: "/out", CacheDir: "/cache", Memory: "2g", CPUs: "2", RunCmd: []string{"true"}}
+	_, logContent, err := r.Run(context.Background(), Job{Name: "llvmlite", Version: "0.43.0", MemoryMB: 16384, CPUs: 6})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	args := strings.Split(logContent, "\n")
+	has := func(flag string) bool {
+		for _, a := range args {
+			if a == flag {
+				return true
+			}
+		}
+		return false
+	}
+	if !has("--memory=16384m") || !has("--cpus=6") || has("--memory=2g") || has("--cpus=2") {
+		t.Fatalf("expected the package limits to replace the global ones, got %q", logContent)
+	}
+
+	_, logContent, err = r.Run(context.Background(), Job{Name: "six", Version: "1.16.0"})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	args = strings.Split(logContent, "\n")
+	if !has("--memory=2g") || !has("--cpus=2") {
+		t.Fatalf("expected the global limits without a package override, got %q", logContent)
+	}
+}
+
+func TestPodmanRunnerIsolatedCachePerJob(t *testing.T) {
+	cacheDir := t.TempDir()
+	// The fake podman records its args and drops a pip cache entry into
+	// whatever directory is mounted writable at /cache.
+	bin := filepath.Join(t.TempDir(), "podman")
+	script := `#!/bin/sh
+prev=""
+for a in "$@"; do
+  if [ "$prev" = "-v" ]; then
+    case "$a" in
+      *:/cache) dir="${a%:/cache}"; mkdir -p "$dir/pip"; echo x > "$dir/pip/entry-$$" ;;
+    esac
+  fi
+  prev="$a"
+done
+printf '%s\n' "$@"
+`
+	if err := os.WriteFile(bin, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	r := &PodmanRunner{Bin: bin, OutputDir: "/out", CacheDir: cacheDir, CacheStrategy: CacheIsolated, RunCmd: []string{"true"}}
+
+	const jobs = 4
+	var wg sync.WaitGroup
+	logs := make([]string, jobs)
+	errs := make([]error, jobs)
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, logs[i], errs[i] = r.Run(context.Background(), Job{Name: "pkg", Version: "1.0.0"})
+		}(i)
+	}
+	wg.Wait()
+
+	seen := map[string]bool{}
+	for i := 0; i < jobs; i++ {
+		if errs[i] != nil {
+			t.Fatalf("job %d: %v", i, errs[i])
+		}
+		lines := strings.Split(logs[i], "\n")
+		var writable, overlay string
+		for _, l := range lines {
+			if strings.HasSuffix(l, ":/cache") {
+				writable = strings.TrimSuffix(l, ":/cache")
+			}
+			if strings.HasPrefix(l, filepath.Join(cacheDir, "pip")+":/cache/pip:O,") {
+				overlay = l
+			}
+		}
+		if writable == "" || writable == cacheDir {
+			t.Fatalf("job %d: expected private writable cache, got %q in %q", i, writable, logs[i])
+		}
+		if seen[writable] {
+			t.Fatalf("job %d: writable cache %s shared with another job", i, writable)
+		}
+		seen[writable] = true
+		wantOverlay := fmt.Sprintf("%s:/cache/pip:O,upperdir=%s,workdir=%s", filepath.Join(cacheDir, "pip"), filepath.Join(writable, "pip"), filepath.Join(writable, "pip-work"))
+		if overlay != wantOverlay {
+			t.Fatalf("job %d: expected shared pip cache overlaid as %q, got %q", i, wantOverlay, overlay)
+		}
+		if _, err := os.Stat(writable); !os.IsNotExist(err) {
+			t.Fatalf("job %d: job cache %s not cleaned up", i, writable)
+		}
+	}
+	merged, err := os.ReadDir(filepath.Join(cacheDir, "pip"))
+	if err != nil || len(merged) != jobs {
+		t.Fatalf("expected %d entries merged into shared cache, got %d (%v)", jobs, len(merged), err)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/plan"
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/platform"
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/runner"
 )
 
@@ -26,6 +27,10 @@ type autoFixResult struct {
 	BlockedHints  []string
 	Impact        string
 	ImpactReason  string
+	// Held is set when the fix exceeded AutoFixMaxImpact; HeldRecipes are the
+	// recipes that would have been applied, pending approval.
+	Held        bool
+	HeldRecipes []string
 }
 
 func (w *Worker) autoFix(ctx context.Context, job runner.Job, logContent string, hints []plan.Hint, knownHints map[string]bool) autoFixResult {
@@ -117,27 +122,78 @@ func (w *Worker) autoFix(ctx context.Context, job runner.Job, logContent string,
 		}
 	}
 
+	var blockedReason string
+	recipes, disallowed := filterRecipeManagers(recipes, w.Cfg.AutoFixAllowedManagers)
+	if len(disallowed) > 0 {
+		blockedReason = "recipe manager not allowed: " + strings.Join(disallowed, ", ")
+		log.Printf("auto-fix: %s@%s %s", job.Name, job.Version, blockedReason)
+	}
+
 	merged := mergeRecipes(job.Recipes, recipes)
 	applied := len(merged) > len(job.Recipes)
+	impact, impactReason := recipeImpact(merged)
+	var held []string
+	if applied && w.Cfg.AutoFixMaxImpact != "" && impactRank(impact) > impactRank(w.Cfg.AutoFixMaxImpact) {
+		held = merged
+		merged = mergeRecipes(job.Recipes, nil)
+		applied = false
+		blockedReason = fmt.Sprintf("impact %s exceeds %s: awaiting approval", impact, w.Cfg.AutoFixMaxImpact)
+		log.Printf("auto-fix: %s@%s held recipes=%v (%s)", job.Name, job.Version, held, impactReason)
+	}
 	if applied && reason == "" {
 		reason = "applied hint recipes"
 	}
 	if applied {
 		log.Printf("auto-fix: %s@%s applied recipes=%v hints=%v", job.Name, job.Version, merged, dedupeStrings(matchedIDs))
 	}
-	impact, impactReason := recipeImpact(merged)
 	return autoFixResult{
-		Applied:      applied,
-		Recipes:      merged,
-		HintIDs:      dedupeStrings(matchedIDs),
-		SavedHintIDs: dedupeStrings(saved),
-		Reason:       reason,
-		BlockedHints: dedupeStrings(blocked),
-		Impact:       impact,
-		ImpactReason: impactReason,
+		Applied:       applied,
+		Recipes:       merged,
+		HintIDs:       dedupeStrings(matchedIDs),
+		SavedHintIDs:  dedupeStrings(saved),
+		Reason:        reason,
+		BlockedReason: blockedReason,
+		BlockedHints:  dedupeStrings(blocked),
+		Impact:        impact,
+		ImpactReason:  impactReason,
+		Held:          held != nil,
+		HeldRecipes:   held,
+	}
+}
+
+// impactRank orders recipeImpact levels so they can be compared against
+// AutoFixMaxImpact.
+func impactRank(impact string) int {
+	switch impact {
+	case "normal":
+		return 1
+	case "high":
+		return 2
+	default:
+		return 0
 	}
 }
 
+// filterRecipeManagers drops "manager:step" recipes whose manager is not in
+// allowed and returns the sorted managers that were dropped. A nil allowed
+// set keeps every recipe.
+func filterRecipeManagers(recipes []string, allowed map[string]bool) ([]string, []string) {
+	if len(allowed) == 0 {
+		return recipes, nil
+	}
+	var kept, dropped []string
+	for _, r := range recipes {
+		mgr, _, _ := strings.Cut(r, ":")
+		mgr = strings.ToLower(strings.TrimSpace(mgr))
+		if allowed[mgr] {
+			kept = append(kept, r)
+			continue
+		}
+		dropped = append(dropped, mgr)
+	}
+	return kept, dedupeStrings(dropped)
+}
+
 func (w *Worker) canSaveAutoHint(pkg string) bool {
 	window := time.Duration(w.Cfg.AutoHintRateLimitMin) * time.Minute
 	if window <= 0 || pkg == "" {
@@ -248,9 +304,20 @@ func autoHintID(hint plan.Hint, ctx plan.HintContext) string {
 	return fmt.Sprintf("auto-%s", hex.EncodeToString(sum[:6]))
 }
 
+// Log patterns behind the auto-fix hints. classifyFailure reuses them to
+// bucket failures.
+var (
+	missingModulePattern    = regexp.MustCompile(`ModuleNotFoundError: No module named ['"]([^'"]+)['"]`)
+	missingHeaderPattern    = regexp.MustCompile(`fatal error: ([A-Za-z0-9_./\-]+\.h): No such file or directory`)
+	missingLibPattern       = regexp.MustCompile(`cannot find -l([A-Za-z0-9_\-]+)`)
+	pkgConfigMissingPattern = regexp.MustCompile(`No package '([^']+)' found|Package '([^']+)', required by 'virtual:world', not found`)
+	cmakeMissingPattern     = regexp.MustCompile(`Could NOT find ([A-Za-z0-9_+.-]+)`)
+	missingToolPattern      = regexp.MustCompile(`(?:/bin/sh: )?([A-Za-z0-9_\-]+): command not found`)
+	rustMissingPattern      = regexp.MustCompile(`(?i)rust compiler not found|rustc.*not found|cargo.*not found`)
+)
+
 func inferHintFromLog(logContent string, ctx plan.HintContext) (plan.Hint, []string, string, bool) {
-	missingModule := regexp.MustCompile(`ModuleNotFoundError: No module named ['"]([^'"]+)['"]`)
-	if m := missingModule.FindStringSubmatch(logContent); len(m) == 2 {
+	if m := missingModulePattern.FindStringSubmatch(logContent); len(m) == 2 {
 		mod := strings.TrimSpace(m[1])
 		if mod == "" {
 			return plan.Hint{}, nil, "", false
@@ -264,8 +331,7 @@ func inferHintFromLog(logContent string, ctx plan.HintContext) (plan.Hint, []str
 		return hint, flattenRecipeMap(hint.Recipes), hint.Note, true
 	}
 
-	missingHeader := regexp.MustCompile(`fatal error: ([A-Za-z0-9_./\-]+\.h): No such file or directory`)
-	if m := missingHeader.FindStringSubmatch(logContent); len(m) == 2 {
+	if m := missingHeaderPattern.FindStringSubmatch(logContent); len(m) == 2 {
 		header := strings.TrimSpace(m[1])
 		base := headerBase(header)
 		recipes := headerRecipes(base)
@@ -278,8 +344,7 @@ func inferHintFromLog(logContent string, ctx plan.HintContext) (plan.Hint, []str
 		return hint, flattenRecipeMap(hint.Recipes), hint.Note, true
 	}
 
-	missingLib := regexp.MustCompile(`cannot find -l([A-Za-z0-9_\-]+)`)
-	if m := missingLib.FindStringSubmatch(logContent); len(m) == 2 {
+	if m := missingLibPattern.FindStringSubmatch(logContent); len(m) == 2 {
 		lib := strings.TrimSpace(m[1])
 		recipes := libraryRecipes(lib)
 		hint := baseAutoHint(ctx, fmt.Sprintf(`cannot find -l%s`, regexp.QuoteMeta(lib)))
@@ -291,8 +356,7 @@ func inferHintFromLog(logContent string, ctx plan.HintContext) (plan.Hint, []str
 		return hint, flattenRecipeMap(hint.Recipes), hint.Note, true
 	}
 
-	pkgConfigMissing := regexp.MustCompile(`No package '([^']+)' found|Package '([^']+)', required by 'virtual:world', not found`)
-	if m := pkgConfigMissing.FindStringSubmatch(logContent); len(m) > 0 {
+	if m := pkgConfigMissingPattern.FindStringSubmatch(logContent); len(m) > 0 {
 		name := ""
 		for _, val := range m[1:] {
 			if val != "" {
@@ -312,8 +376,7 @@ func inferHintFromLog(logContent string, ctx plan.HintContext) (plan.Hint, []str
 		}
 	}
 
-	cmakeMissing := regexp.MustCompile(`Could NOT find ([A-Za-z0-9_+.-]+)`)
-	if m := cmakeMissing.FindStringSubmatch(logContent); len(m) == 2 {
+	if m := cmakeMissingPattern.FindStringSubmatch(logContent); len(m) == 2 {
 		name := strings.TrimSpace(m[1])
 		if name != "" {
 			recipes := libraryRecipes(name)
@@ -327,8 +390,7 @@ func inferHintFromLog(logContent string, ctx plan.HintContext) (plan.Hint, []str
 		}
 	}
 
-	missingTool := regexp.MustCompile(`(?:/bin/sh: )?([A-Za-z0-9_\-]+): command not found`)
-	if m := missingTool.FindStringSubmatch(logContent); len(m) == 2 {
+	if m := missingToolPattern.FindStringSubmatch(logContent); len(m) == 2 {
 		tool := strings.TrimSpace(m[1])
 		recipes := toolRecipes(tool)
 		if len(recipes) > 0 {
@@ -342,8 +404,7 @@ func inferHintFromLog(logContent string, ctx plan.HintContext) (plan.Hint, []str
 		}
 	}
 
-	rustMissing := regexp.MustCompile(`(?i)rust compiler not found|rustc.*not found|cargo.*not found`)
-	if m := rustMissing.FindStringSubmatch(logContent); len(m) > 0 {
+	if m := rustMissingPattern.FindStringSubmatch(logContent); len(m) > 0 {
 		recipes := toolRecipes("cargo")
 		if len(recipes) > 0 {
 			hint := baseAutoHint(ctx, "rust compiler not found")
@@ -615,6 +676,9 @@ func archFromPlatformTag(tag string) string {
 	if tag == "" {
 		return ""
 	}
+	if arch := platform.Arch(tag); arch != "" {
+		return arch
+	}
 	parts := strings.Split(strings.ToLower(tag), "_")
 	return parts[len(parts)-1]
 }
@@ -6,11 +6,45 @@ import (
 	"time"
 )
 
-func buildLoop(ctx context.Context, cfg Config, runDrain func(context.Context) (bool, error)) {
-	interval := time.Duration(cfg.BuildPollIntervalSec) * time.Second
-	if interval <= 0 {
-		interval = 5 * time.Second
+// pollBackoff paces the build loop. A drain that found work is followed
+// immediately by another; each empty drain doubles the wait, starting at base
+// and capped at max.
+type pollBackoff struct {
+	base time.Duration
+	max  time.Duration
+	cur  time.Duration
+}
+
+func newPollBackoff(base, max time.Duration) *pollBackoff {
+	if base <= 0 {
+		base = 5 * time.Second
+	}
+	if max < base {
+		max = base
 	}
+	return &pollBackoff{base: base, max: max}
+}
+
+// next returns the wait before the following poll given how many requests
+// the last drain popped.
+func (b *pollBackoff) next(popped int) time.Duration {
+	if popped > 0 {
+		b.cur = 0
+		return 0
+	}
+	if b.cur == 0 {
+		b.cur = b.base
+	} else {
+		b.cur = min(b.cur*2, b.max)
+	}
+	return b.cur
+}
+
+func buildLoop(ctx context.Context, cfg Config, runDrain func(context.Context) (bool, int, error)) {
+	backoff := newPollBackoff(
+		time.Duration(cfg.BuildPollIntervalSec)*time.Second,
+		time.Duration(cfg.BuildPollMaxSec)*time.Second,
+	)
 	timer := time.NewTimer(0)
 	defer timer.Stop()
 	for {
@@ -20,13 +54,13 @@ func buildLoop(ctx context.Context, cfg Config, runDrain func(context.Context) (
 		case <-timer.C:
 		}
 		runCtx, cancel := context.WithTimeout(ctx, 30*time.Minute)
-		ran, err := runDrain(runCtx)
+		ran, popped, err := runDrain(runCtx)
 		cancel()
 		if err != nil {
 			log.Printf("build loop: %v", err)
 		} else if !ran {
 			log.Printf("build loop: skip (drain already running)")
 		}
-		timer.Reset(interval)
+		timer.Reset(backoff.next(popped))
 	}
 }
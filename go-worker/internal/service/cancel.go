@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/runner"
+)
+
+var errBuildCancelled = errors.New("build cancelled")
+
+// watchBuildCancel polls the control plane while job runs and calls stop
+// once a cancel has been requested for it. The returned flag reports whether
+// that happened; polling ends when ctx is done.
+func (w *Worker) watchBuildCancel(ctx context.Context, job runner.Job, stop context.CancelFunc) *atomic.Bool {
+	var cancelled atomic.Bool
+	if w.Cfg.ControlPlaneURL == "" || w.Cfg.CancelPollSec <= 0 {
+		return &cancelled
+	}
+	go func() {
+		ticker := time.NewTicker(time.Duration(w.Cfg.CancelPollSec) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			requested, err := w.buildCancelRequested(ctx, job)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("cancel check %s %s: %v", job.Name, job.Version, err)
+				}
+				continue
+			}
+			if requested {
+				log.Printf("cancel requested for %s %s; stopping build", job.Name, job.Version)
+				cancelled.Store(true)
+				stop()
+				return
+			}
+		}
+	}()
+	return &cancelled
+}
+
+func (w *Worker) buildCancelRequested(ctx context.Context, job runner.Job) (bool, error) {
+	q := url.Values{"package": {job.Name}, "version": {job.Version}}
+	if job.PythonTag != "" {
+		q.Set("python_tag", job.PythonTag)
+	}
+	endpoint := strings.TrimRight(w.Cfg.ControlPlaneURL, "/") + "/api/builds/cancel?" + q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return false, err
+	}
+	if w.Cfg.ControlPlaneToken != "" {
+		req.Header.Set("X-Worker-Token", w.Cfg.ControlPlaneToken)
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return false, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	var out struct {
+		CancelRequested bool `json:"cancel_requested"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return false, err
+	}
+	return out.CancelRequested, nil
+}
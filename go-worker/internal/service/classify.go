@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/runner"
+)
+
+// Failure categories reported with failed builds.
+const (
+	categoryCompile       = "compile error"
+	categoryMissingDep    = "missing dependency"
+	categoryTimeout       = "timeout"
+	categoryOOM           = "oom"
+	categoryCPULimit      = "cpu limit"
+	categoryFileSizeLimit = "file size limit"
+	categoryNetwork       = "network"
+	categoryUnknown       = "unknown"
+)
+
+var (
+	timeoutPattern    = regexp.MustCompile(`reason=timeout|(?i)timed out after`)
+	oomPattern        = regexp.MustCompile(`(?i)out of memory|MemoryError|cannot allocate memory|killed signal terminated program|oom-kill|exit status 137`)
+	networkPattern    = regexp.MustCompile(`(?i)could not resolve host|temporary failure in name resolution|name or service not known|connection (refused|reset|timed out)|network is unreachable|max retries exceeded|ReadTimeoutError|NewConnectionError`)
+	missingPipPattern = regexp.MustCompile(`Could not find a version that satisfies the requirement|No matching distribution found`)
+	compilePattern    = regexp.MustCompile(`(?m)error: command '[^']*' failed|: error: |compilation terminated|collect2: error|error: could not compile|Failed building wheel`)
+)
+
+// missingDepPatterns are the auto-fix hint patterns; any of them means the
+// build lacked a header, library, module, or tool.
+var missingDepPatterns = []*regexp.Regexp{
+	missingModulePattern,
+	missingHeaderPattern,
+	missingLibPattern,
+	pkgConfigMissingPattern,
+	cmakeMissingPattern,
+	missingToolPattern,
+	rustMissingPattern,
+	missingPipPattern,
+}
+
+// classifyFailure buckets a failed build by its log and error. Rules run
+// from the most specific cause to the most generic, so a missing header
+// that also ends in "compilation terminated" counts as a missing dependency.
+func classifyFailure(logContent string, err error) string {
+	text := logContent
+	var resErr *runner.ResourceError
+	if errors.As(err, &resErr) {
+		switch resErr.Kind {
+		case runner.ResourceCPU:
+			return categoryCPULimit
+		case runner.ResourceFileSize:
+			return categoryFileSizeLimit
+		default:
+			return categoryOOM
+		}
+	}
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) || strings.Contains(err.Error(), "(timeout)") {
+			return categoryTimeout
+		}
+		text += "\n" + err.Error()
+	}
+	switch {
+	case timeoutPattern.MatchString(text):
+		return categoryTimeout
+	case oomPattern.MatchString(text):
+		return categoryOOM
+	case networkPattern.MatchString(text):
+		return categoryNetwork
+	}
+	for _, re := range missingDepPatterns {
+		if re.MatchString(text) {
+			return categoryMissingDep
+		}
+	}
+	if compilePattern.MatchString(text) {
+		return categoryCompile
+	}
+	return categoryUnknown
+}
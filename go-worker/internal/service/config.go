@@ -1,19 +1,26 @@
 package service
 
 import (
+	"context"
+	"log"
 	"os"
+	"os/exec"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/cas"
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/objectstore"
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/pack"
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/plan"
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/platform"
 )
 
 // Config holds worker settings.
 type Config struct {
 	HTTPAddr             string
 	QueueBackend         string
+	QueueVisibilitySec   int
 	QueueFile            string
 	RedisURL             string
 	RedisKey             string
@@ -25,14 +32,20 @@ type Config struct {
 	PlanPopBatch         int
 	AutoBuild            bool
 	BuildPollIntervalSec int
+	BuildPollMaxSec      int
 	BuildPopURL          string
 	BuildStatusURL       string
 	KafkaBrokers         string
 	KafkaTopic           string
+	KafkaGroupID         string
+	KafkaPartitions      int
 	InputDir             string
 	OutputDir            string
 	CacheDir             string
+	CacheStrategy        string
 	PythonVersion        string
+	PythonVersions       []string
+	MaxPlanNodes         int
 	PlatformTag          string
 	ContainerImage       string
 	ContainerPreset      string
@@ -42,8 +55,16 @@ type Config struct {
 	WorkerID             string
 	WorkerRunID          string
 	HeartbeatIntervalSec int
+	CancelPollSec        int
 	PodmanBin            string
 	RunnerTimeoutSec     int
+	RunnerNoNetwork      bool
+	RunnerReadOnly       bool
+	RunnerCapDrop        []string
+	RunnerUser           string
+	RunnerMemory         string
+	RunnerCPUs           string
+	LogMaxBytes          int
 	RequeueOnFailure     bool
 	MaxRequeueAttempts   int
 	AutoFixEnabled       bool
@@ -68,6 +89,7 @@ type Config struct {
 	ObjectStoreAccess    string
 	ObjectStoreSecret    string
 	ObjectStoreUseSSL    bool
+	ObjectKeyTemplate    string
 	LocalCASDir          string
 	CASPushEnabled       bool
 	RepairPushEnabled    bool
@@ -82,14 +104,24 @@ type Config struct {
 	DefaultRuntimeCmd    string
 	DefaultRepairCmd     string
 	PackRecipesDir       string
+	PackFetchConcurrency int
 	BuildPoolSize        int
 	PlanPoolSize         int
+
+	// AutoFixAllowedManagers limits auto-fix to recipes from these managers
+	// (apt, dnf, pip, env). Empty allows all of them.
+	AutoFixAllowedManagers map[string]bool
+	// AutoFixMaxImpact ("normal" or "high") holds fixes whose recipeImpact
+	// exceeds it for manual approval instead of applying them. Empty applies
+	// every fix.
+	AutoFixMaxImpact string
 }
 
 func fromEnv() Config {
 	cfg := Config{
 		HTTPAddr:             getenv("WORKER_HTTP_ADDR", ":9000"),
 		QueueBackend:         getenv("QUEUE_BACKEND", "file"),
+		QueueVisibilitySec:   getenvInt("QUEUE_VISIBILITY_TIMEOUT_SEC", 3600),
 		QueueFile:            getenv("QUEUE_FILE", "/tmp/refinery/retry_queue.json"),
 		RedisURL:             getenv("REDIS_URL", ""),
 		RedisKey:             getenv("REDIS_KEY", "refinery:queue"),
@@ -101,14 +133,20 @@ func fromEnv() Config {
 		PlanPopBatch:         getenvInt("PLAN_POP_BATCH", 5),
 		AutoBuild:            getenvBool("AUTO_BUILD", true),
 		BuildPollIntervalSec: getenvInt("BUILD_POLL_INTERVAL_SEC", 5),
+		BuildPollMaxSec:      getenvInt("BUILD_POLL_MAX_INTERVAL_SEC", 60),
 		BuildPopURL:          getenv("BUILD_POP_URL", ""),
 		BuildStatusURL:       getenv("BUILD_STATUS_URL", ""),
 		KafkaBrokers:         getenv("KAFKA_BROKERS", ""),
 		KafkaTopic:           getenv("KAFKA_TOPIC", "refinery.queue"),
+		KafkaGroupID:         getenv("KAFKA_GROUP_ID", "refinery-pop"),
+		KafkaPartitions:      getenvInt("KAFKA_PARTITIONS", 0),
 		InputDir:             getenv("INPUT_DIR", ""),
 		OutputDir:            getenv("OUTPUT_DIR", "/output"),
 		CacheDir:             getenv("CACHE_DIR", "/cache"),
+		CacheStrategy:        getenv("PIP_CACHE_STRATEGY", "shared"),
 		PythonVersion:        getenv("PYTHON_VERSION", "3.11"),
+		PythonVersions:       parseList(getenv("PYTHON_VERSIONS", "")),
+		MaxPlanNodes:         getenvInt("MAX_PLAN_NODES", 0),
 		PlatformTag:          getenv("PLATFORM_TAG", "manylinux2014_s390x"),
 		ContainerImage:       getenv("CONTAINER_IMAGE", "refinery-builder:latest"),
 		ContainerPreset:      getenv("CONTAINER_PRESET", "rocky"),
@@ -118,8 +156,16 @@ func fromEnv() Config {
 		WorkerID:             getenv("WORKER_ID", ""),
 		WorkerRunID:          getenv("WORKER_RUN_ID", ""),
 		HeartbeatIntervalSec: getenvInt("WORKER_HEARTBEAT_INTERVAL_SEC", 15),
+		CancelPollSec:        getenvInt("BUILD_CANCEL_POLL_SEC", 10),
 		PodmanBin:            getenv("PODMAN_BIN", ""), // empty = stub podman; set to podman binary to execute
 		RunnerTimeoutSec:     getenvInt("RUNNER_TIMEOUT_SEC", 900),
+		RunnerNoNetwork:      getenvBool("RUNNER_NETWORK_NONE", false),
+		RunnerReadOnly:       getenvBool("RUNNER_READ_ONLY", false),
+		RunnerCapDrop:        parseList(getenv("RUNNER_CAP_DROP", "")),
+		RunnerUser:           getenv("RUNNER_USER", ""),
+		RunnerMemory:         getenv("RUNNER_MEMORY", ""),
+		RunnerCPUs:           getenv("RUNNER_CPUS", ""),
+		LogMaxBytes:          getenvInt("LOG_MAX_BYTES", 512<<10),
 		RequeueOnFailure:     getenvBool("REQUEUE_ON_FAILURE", false),
 		MaxRequeueAttempts:   getenvInt("MAX_REQUEUE_ATTEMPTS", 3),
 		AutoFixEnabled:       getenvBool("AUTO_FIX_ENABLED", true),
@@ -142,6 +188,7 @@ func fromEnv() Config {
 		ObjectStoreAccess:    getenv("OBJECT_STORE_ACCESS_KEY", ""),
 		ObjectStoreSecret:    getenv("OBJECT_STORE_SECRET_KEY", ""),
 		ObjectStoreUseSSL:    getenvBool("OBJECT_STORE_USE_SSL", false),
+		ObjectKeyTemplate:    getenv("OBJECT_KEY_TEMPLATE", defaultObjectKeyTemplate),
 		LocalCASDir:          getenv("LOCAL_CAS_DIR", "/cache/cas"),
 		CASPushEnabled:       getenvBool("CAS_PUSH_ENABLED", false),
 		RepairPushEnabled:    getenvBool("REPAIR_PUSH_ENABLED", false),
@@ -156,9 +203,13 @@ func fromEnv() Config {
 		DefaultRuntimeCmd:    getenv("DEFAULT_RUNTIME_CMD", "/app/recipes/cpython311.sh"),
 		DefaultRepairCmd:     getenv("DEFAULT_REPAIR_CMD", "/app/recipes/repair.sh"),
 		PackRecipesDir:       getenv("PACK_RECIPES_DIR", "/app/recipes"),
+		PackFetchConcurrency: getenvInt("PACK_FETCH_CONCURRENCY", 4),
 		BuildPoolSize:        getenvInt("BUILD_POOL_SIZE", 2),
 		PlanPoolSize:         getenvInt("PLAN_POOL_SIZE", 2),
 	}
+	cfg.PlatformTag = platform.Normalize(cfg.PlatformTag)
+	cfg.AutoFixAllowedManagers = parseSet(getenv("AUTO_FIX_ALLOWED_MANAGERS", ""))
+	cfg.AutoFixMaxImpact = strings.ToLower(getenv("AUTO_FIX_MAX_IMPACT", ""))
 	return cfg
 }
 
@@ -197,6 +248,34 @@ func parseCmd(cmd string) []string {
 	return strings.Fields(cmd)
 }
 
+// parseList splits a comma-separated list, trimming blanks and keeping case.
+func parseList(list string) []string {
+	var out []string
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// parseSet splits a comma-separated list into a lowercase set; empty input
+// yields nil.
+func parseSet(list string) map[string]bool {
+	var out map[string]bool
+	for _, v := range strings.Split(list, ",") {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if v == "" {
+			continue
+		}
+		if out == nil {
+			out = map[string]bool{}
+		}
+		out[v] = true
+	}
+	return out
+}
+
 // CASStore builds a CAS store client from config (Zot by default).
 func (c Config) CASStore() cas.Store {
 	if c.CASRegistryURL == "" {
@@ -239,3 +318,32 @@ func (c Config) ObjectStore() objectstore.Store {
 	}
 	return store
 }
+
+// PolicyBaseDigest hashes the builder image, preset, and repair policy this
+// worker builds under. The image is resolved to its podman image ID so a
+// retagged :latest changes the digest; with the stub runner, or when the
+// inspect fails, the configured reference is hashed instead.
+func (c Config) PolicyBaseDigest() string {
+	image := c.ContainerImage
+	if c.PodmanBin != "" && image != "" {
+		id, err := inspectImageID(c.PodmanBin, image)
+		if err != nil {
+			log.Printf("policy base: resolve image %s: %v; hashing the reference", image, err)
+		} else if id != "" {
+			image = id
+		}
+	}
+	return plan.PolicyBaseDigest(image, c.ContainerPreset, c.RepairPolicyHash)
+}
+
+// inspectImageID returns the local image ID podman resolves image to. Tests
+// replace it to stay off podman.
+var inspectImageID = func(bin, image string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, bin, "image", "inspect", "--format", "{{.Id}}", image).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
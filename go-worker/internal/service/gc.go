@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/objectstore"
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/runner"
+)
+
+var keyDigestPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// OrphanCandidate is an object-store artifact no plan, build, or manifest references.
+type OrphanCandidate struct {
+	Key    string `json:"key"`
+	Digest string `json:"digest"`
+}
+
+// RunGC lists orphaned object-store artifacts. It is dry-run only: nothing is deleted.
+func RunGC(ctx context.Context, out io.Writer) error {
+	cfg := fromEnv()
+	store := cfg.ObjectStore()
+	referenced, keep, err := fetchReferenced(ctx, &http.Client{Timeout: 30 * time.Second}, cfg)
+	if err != nil {
+		return err
+	}
+	orphans, err := findOrphans(ctx, store, referenced, keep)
+	if err != nil {
+		return err
+	}
+	for _, o := range orphans {
+		fmt.Fprintf(out, "%s\t%s\n", o.Digest, o.Key)
+	}
+	fmt.Fprintf(out, "gc dry-run: %d orphan candidate(s), %d referenced digest(s)\n", len(orphans), len(referenced))
+	return nil
+}
+
+// fetchReferenced returns the digests the control plane still references and
+// the object keys of per-build files its manifests link. Those files (the
+// SBOM and provenance attestation) embed timestamps, so their digest never
+// appears in a plan or event and they are kept by key instead.
+func fetchReferenced(ctx context.Context, client *http.Client, cfg Config) (map[string]bool, map[string]bool, error) {
+	if cfg.ControlPlaneURL == "" {
+		return nil, nil, fmt.Errorf("CONTROL_PLANE_URL is required for gc")
+	}
+	url := strings.TrimRight(cfg.ControlPlaneURL, "/") + "/api/artifacts/referenced"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.ControlPlaneToken != "" {
+		req.Header.Set("X-Worker-Token", cfg.ControlPlaneToken)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("fetch referenced digests: status %d", resp.StatusCode)
+	}
+	var payload struct {
+		Digests []string `json:"digests"`
+		Builds  []struct {
+			Name        string `json:"name"`
+			Version     string `json:"version"`
+			PythonTag   string `json:"python_tag"`
+			PlatformTag string `json:"platform_tag"`
+		} `json:"builds"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return nil, nil, err
+	}
+	referenced := make(map[string]bool, len(payload.Digests))
+	for _, d := range payload.Digests {
+		referenced[d] = true
+	}
+	keep := make(map[string]bool, len(payload.Builds))
+	for _, b := range payload.Builds {
+		job := runner.Job{Name: b.Name, Version: b.Version, PythonTag: b.PythonTag, PlatformTag: b.PlatformTag}
+		for _, file := range []string{sbomFile, provenanceFile} {
+			keep[renderObjectKey(cfg.ObjectKeyTemplate, job, buildFile(job, file))] = true
+		}
+	}
+	return referenced, keep, nil
+}
+
+// findOrphans reports objects whose digest is not referenced. Keys in keep
+// are never candidates. Keys that embed a sha256 (e.g. repair-<digest>.whl)
+// use it directly; other objects are downloaded and hashed.
+func findOrphans(ctx context.Context, store objectstore.Store, referenced, keep map[string]bool) ([]OrphanCandidate, error) {
+	lister, ok := store.(objectstore.Lister)
+	if !ok {
+		return nil, fmt.Errorf("object store does not support listing")
+	}
+	keys, err := lister.List(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	var orphans []OrphanCandidate
+	for _, key := range keys {
+		if keep[key] {
+			continue
+		}
+		digest := ""
+		if hexDigest := keyDigestPattern.FindString(key); hexDigest != "" {
+			digest = "sha256:" + hexDigest
+		} else {
+			data, _, err := store.Get(ctx, key)
+			if err != nil {
+				return nil, fmt.Errorf("get %s: %w", key, err)
+			}
+			sum := sha256.Sum256(data)
+			digest = "sha256:" + hex.EncodeToString(sum[:])
+		}
+		if !referenced[digest] {
+			orphans = append(orphans, OrphanCandidate{Key: key, Digest: digest})
+		}
+	}
+	return orphans, nil
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
 )
@@ -78,3 +79,17 @@ func trimSummary(line string) string {
 	}
 	return line[:maxLen] + "..."
 }
+
+// truncateLogTail keeps the last maxBytes of a log, cut forward to the next
+// line start, behind a marker naming how much was dropped. The tail is where
+// build failures land. It returns the log unchanged and 0 when it fits.
+func truncateLogTail(logContent string, maxBytes int) (string, int) {
+	if maxBytes <= 0 || len(logContent) <= maxBytes {
+		return logContent, 0
+	}
+	start := len(logContent) - maxBytes
+	if i := strings.IndexByte(logContent[start:], '\n'); i >= 0 && start+i+1 < len(logContent) {
+		start += i + 1
+	}
+	return fmt.Sprintf("[log truncated: %d bytes dropped]\n", start) + logContent[start:], start
+}
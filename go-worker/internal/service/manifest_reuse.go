@@ -0,0 +1,122 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/plan"
+)
+
+// manifestKey mirrors the control plane's manifest lookup key.
+type manifestKey struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	PythonTag   string `json:"python_tag"`
+	PlatformTag string `json:"platform_tag"`
+}
+
+type builtManifestEntry struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	PythonTag   string `json:"python_tag,omitempty"`
+	PlatformTag string `json:"platform_tag,omitempty"`
+}
+
+func (k manifestKey) normalized() manifestKey {
+	k.Name = strings.ToLower(k.Name)
+	return k
+}
+
+// fetchBuiltManifest asks the control plane which keys already have a
+// successful manifest entry.
+func fetchBuiltManifest(ctx context.Context, client *http.Client, cfg Config, keys []manifestKey) ([]builtManifestEntry, error) {
+	url := strings.TrimRight(cfg.ControlPlaneURL, "/") + "/api/manifest/lookup"
+	data, _ := json.Marshal(map[string]any{"wheels": keys})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.ControlPlaneToken != "" {
+		req.Header.Set("X-Worker-Token", cfg.ControlPlaneToken)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("manifest lookup status %d", resp.StatusCode)
+	}
+	var out struct {
+		Entries []builtManifestEntry `json:"entries"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return out.Entries, nil
+}
+
+// applyManifestReuse flips build nodes to reuse when a previous run already
+// recorded a successful wheel for the same name, version, and tags. It covers
+// wheels the CAS check missed, e.g. ones built before the CAS was configured.
+// Lookup failures leave the plan as planned, and packages under
+// forceRebuild are never flipped. It returns the number of nodes flipped.
+func applyManifestReuse(ctx context.Context, client *http.Client, cfg Config, snap *plan.Snapshot, forceRebuild []string) int {
+	if cfg.ControlPlaneURL == "" {
+		return 0
+	}
+	var keys []manifestKey
+	for _, n := range snap.Plan {
+		if n.Action == "build" && !plan.ForcesRebuild(forceRebuild, n.Name) {
+			keys = append(keys, manifestKey{Name: n.Name, Version: n.Version, PythonTag: n.PythonTag, PlatformTag: n.PlatformTag})
+		}
+	}
+	if len(keys) == 0 {
+		return 0
+	}
+	entries, err := fetchBuiltManifest(ctx, client, cfg, keys)
+	if err != nil {
+		log.Printf("planner: manifest reuse lookup failed: %v", err)
+		return 0
+	}
+	built := make(map[manifestKey]bool, len(entries))
+	for _, e := range entries {
+		built[manifestKey{Name: e.Name, Version: e.Version, PythonTag: e.PythonTag, PlatformTag: e.PlatformTag}.normalized()] = true
+	}
+	flipped := 0
+	for i, n := range snap.Plan {
+		if n.Action != "build" {
+			continue
+		}
+		key := manifestKey{Name: n.Name, Version: n.Version, PythonTag: n.PythonTag, PlatformTag: n.PlatformTag}.normalized()
+		if !built[key] {
+			continue
+		}
+		snap.Plan[i].Action = "reuse"
+		flipped++
+		for j, d := range snap.DAG {
+			if d.Type != plan.NodeWheel || d.Action != "build" {
+				continue
+			}
+			if manifestKeyFromMeta(d.Metadata) != key {
+				continue
+			}
+			snap.DAG[j].Action = "reuse"
+		}
+	}
+	return flipped
+}
+
+func manifestKeyFromMeta(meta map[string]any) manifestKey {
+	str := func(k string) string {
+		v, _ := meta[k].(string)
+		return v
+	}
+	return manifestKey{Name: str("name"), Version: str("version"), PythonTag: str("python_tag"), PlatformTag: str("platform_tag")}.normalized()
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/platform"
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/runner"
+)
+
+// defaultObjectKeyTemplate is the original {name}/{version}/{file} layout.
+const defaultObjectKeyTemplate = "{name}/{version}/{file}"
+
+// validateObjectKeyTemplate rejects templates without {file}, which would
+// store every artifact of a build under the same key.
+func validateObjectKeyTemplate(tmpl string) error {
+	if tmpl != "" && !strings.Contains(tmpl, "{file}") {
+		return fmt.Errorf("OBJECT_KEY_TEMPLATE %q must contain {file}", tmpl)
+	}
+	return nil
+}
+
+// renderObjectKey fills an OBJECT_KEY_TEMPLATE for one artifact of job.
+// Supported placeholders are {name} (lowercased), {version}, {python_tag},
+// {platform_tag}, {arch}, and {file}. Empty values drop their path segment
+// so keys never contain "//"; an empty file leaves a trailing slash, giving
+// the prefix that holds the build's artifacts.
+func renderObjectKey(tmpl string, job runner.Job, file string) string {
+	if tmpl == "" {
+		tmpl = defaultObjectKeyTemplate
+	}
+	key := strings.NewReplacer(
+		"{name}", strings.ToLower(job.Name),
+		"{version}", job.Version,
+		"{python_tag}", job.PythonTag,
+		"{platform_tag}", job.PlatformTag,
+		"{arch}", platform.Arch(job.PlatformTag),
+		"{file}", file,
+	).Replace(tmpl)
+	var parts []string
+	for _, p := range strings.Split(key, "/") {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	key = strings.Join(parts, "/")
+	if file == "" {
+		key += "/"
+	}
+	return key
+}
+
+// buildFile names a per-build object such as the SBOM. Builds of one version
+// for several Pythons share the {name}/{version}/ prefix, so the job's python
+// tag goes into the name: sbom.cdx.json becomes sbom-cp311.cdx.json.
+func buildFile(job runner.Job, file string) string {
+	if job.PythonTag == "" {
+		return file
+	}
+	base, ext, _ := strings.Cut(file, ".")
+	return base + "-" + job.PythonTag + "." + ext
+}
+
+// objectKey is renderObjectKey with the worker's configured template.
+func (w *Worker) objectKey(job runner.Job, file string) string {
+	return renderObjectKey(w.Cfg.ObjectKeyTemplate, job, file)
+}
@@ -9,13 +9,17 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/objectstore"
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/plan"
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/wheelname"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -31,7 +35,7 @@ type pendingInput struct {
 	Metadata     json.RawMessage `json:"metadata,omitempty"`
 }
 
-func plannerLoop(ctx context.Context, cfg Config, popURL, statusURL, listURL string, planPool *atomic.Int32, pyVersion, platformTag *atomic.Value) {
+func plannerLoop(ctx context.Context, cfg Config, popURL, statusURL, listURL string, planPool, maxPlanNodes *atomic.Int32, pyVersion, platformTag *atomic.Value) {
 	interval := time.Duration(cfg.PlanPollIntervalSec) * time.Second
 	if interval <= 0 {
 		interval = 15 * time.Second
@@ -68,15 +72,19 @@ func plannerLoop(ctx context.Context, cfg Config, popURL, statusURL, listURL str
 			pool = 2
 		}
 		g.SetLimit(pool)
-		for _, id := range ids {
-			pi, ok := pendingMap[id]
+		for _, item := range ids {
+			req := parsePlanQueueItem(item)
+			pi, ok := pendingMap[req.ID]
 			if !ok {
-				log.Printf("planner: pending input %s not found in list", id)
+				log.Printf("planner: pending input %s not found in list", req.ID)
 				continue
 			}
 			piCopy := pi
 			g.Go(func() error {
 				localCfg := cfg
+				if maxPlanNodes != nil {
+					localCfg.MaxPlanNodes = int(maxPlanNodes.Load())
+				}
 				if pyVersion != nil {
 					if v, ok := pyVersion.Load().(string); ok && v != "" {
 						localCfg.PythonVersion = v
@@ -87,7 +95,7 @@ func plannerLoop(ctx context.Context, cfg Config, popURL, statusURL, listURL str
 						localCfg.PlatformTag = v
 					}
 				}
-				if err := planOne(gctx, client, localCfg, inputStore, piCopy, statusURL); err != nil {
+				if err := planOne(gctx, client, localCfg, inputStore, piCopy, req, statusURL); err != nil {
 					log.Printf("planner: failed planning id=%d: %v", piCopy.ID, err)
 				}
 				return nil
@@ -97,6 +105,35 @@ func plannerLoop(ctx context.Context, cfg Config, popURL, statusURL, listURL str
 	}
 }
 
+// planRequest is a popped plan-queue item: a pending input ID optionally
+// followed by a query string of planning options, e.g. "42?reuse_only=true".
+// force_rebuild is "true" for every package or a comma list of names.
+type planRequest struct {
+	ID           string
+	ReuseOnly    bool
+	ForceRebuild []string
+}
+
+func parsePlanQueueItem(item string) planRequest {
+	id, rawQuery, _ := strings.Cut(item, "?")
+	req := planRequest{ID: id}
+	if q, err := url.ParseQuery(rawQuery); err == nil {
+		req.ReuseOnly, _ = strconv.ParseBool(q.Get("reuse_only"))
+		req.ForceRebuild = parseForceRebuild(q.Get("force_rebuild"))
+	}
+	return req
+}
+
+func parseForceRebuild(raw string) []string {
+	if all, err := strconv.ParseBool(raw); err == nil {
+		if all {
+			return []string{"*"}
+		}
+		return nil
+	}
+	return parseList(raw)
+}
+
 func popPlanIDs(ctx context.Context, client *http.Client, popURL, token string, batch int) ([]string, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s?max=%d", popURL, batch), nil)
 	if err != nil {
@@ -148,10 +185,19 @@ func fetchPendingMap(ctx context.Context, client *http.Client, listURL, token st
 }
 
 type pendingMeta struct {
-	Type         string         `json:"type"`
-	Requirements []plan.DepSpec `json:"requirements,omitempty"`
-	Wheel        *pendingWheel  `json:"wheel,omitempty"`
-	Requires     []plan.DepSpec `json:"requires,omitempty"`
+	Type           string         `json:"type"`
+	Requirements   []plan.DepSpec `json:"requirements,omitempty"`
+	Wheel          *pendingWheel  `json:"wheel,omitempty"`
+	Sdist          *pendingSdist  `json:"sdist,omitempty"`
+	Requires       []plan.DepSpec `json:"requires,omitempty"`
+	IndexURL       string         `json:"index_url,omitempty"`
+	ConstraintsKey string         `json:"constraints_key,omitempty"`
+	PythonVersion  string         `json:"python_version,omitempty"`
+}
+
+type pendingSdist struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
 }
 
 type pendingWheel struct {
@@ -162,14 +208,11 @@ type pendingWheel struct {
 	PlatformTag string `json:"platform_tag"`
 }
 
-type wheelMeta struct {
-	Name        string
-	Version     string
-	PythonTag   string
-	AbiTag      string
-	PlatformTag string
-}
-
+// inputSetFromPending turns a pending input into planner inputs, carrying
+// over its per-input index_url when one was given at upload. The target
+// python versions are the upload's python_version list, else the one an
+// uploaded wheel was built for. Otherwise planOne applies the worker's
+// configured versions.
 func inputSetFromPending(ctx context.Context, cfg Config, pi pendingInput, store objectstore.Store) (plan.InputSet, error) {
 	var meta pendingMeta
 	if len(pi.Metadata) > 0 {
@@ -177,6 +220,33 @@ func inputSetFromPending(ctx context.Context, cfg Config, pi pendingInput, store
 			return plan.InputSet{}, fmt.Errorf("parse metadata: %w", err)
 		}
 	}
+	inputs, err := inputSetFromMeta(ctx, cfg, pi, meta, store)
+	if err != nil {
+		return plan.InputSet{}, err
+	}
+	inputs.IndexURL = meta.IndexURL
+	inputs.PythonVersions = parseList(meta.PythonVersion)
+	if v := wheelPythonVersion(inputs.Wheels); len(inputs.PythonVersions) == 0 && v != "" {
+		inputs.PythonVersions = []string{v}
+	}
+	return inputs, nil
+}
+
+// wheelPythonVersion is the python version a single uploaded wheel targets,
+// e.g. 3.11 for cp311. abi3 and py3 wheels run on many versions, so they
+// name none and the configured version applies.
+func wheelPythonVersion(wheels []plan.WheelInput) string {
+	if len(wheels) != 1 || strings.EqualFold(wheels[0].AbiTag, "abi3") {
+		return ""
+	}
+	v := plan.PythonVersionFromTag(wheels[0].PythonTag)
+	if !strings.Contains(v, ".") {
+		return ""
+	}
+	return v
+}
+
+func inputSetFromMeta(ctx context.Context, cfg Config, pi pendingInput, meta pendingMeta, store objectstore.Store) (plan.InputSet, error) {
 	kind := pi.SourceType
 	if kind == "" {
 		kind = meta.Type
@@ -198,12 +268,13 @@ func inputSetFromPending(ctx context.Context, cfg Config, pi pendingInput, store
 	case "wheel":
 		metaWheel := meta.Wheel
 		reqs := meta.Requires
+		var md plan.WheelMetadata
 		if metaWheel == nil || metaWheel.Name == "" || metaWheel.Version == "" {
 			data, err := fetchInputObject(ctx, cfg, pi, store)
 			if err != nil {
 				return plan.InputSet{}, fmt.Errorf("no wheel metadata for %s: %w", pi.Filename, err)
 			}
-			info, err := parseWheelFilename(pi.Filename)
+			info, err := wheelname.Parse(pi.Filename)
 			if err != nil {
 				return plan.InputSet{}, fmt.Errorf("parse wheel filename: %w", err)
 			}
@@ -217,21 +288,34 @@ func inputSetFromPending(ctx context.Context, cfg Config, pi pendingInput, store
 			if len(reqs) == 0 {
 				reqs, _ = parseRequiresDistBytes(data)
 			}
+			if doc, err := wheelMetadataBytes(data); err == nil && doc != "" {
+				md = plan.ParseWheelMetadata(doc)
+			}
 		}
 		if metaWheel == nil || metaWheel.Name == "" || metaWheel.Version == "" {
 			return plan.InputSet{}, fmt.Errorf("no wheel metadata for %s", pi.Filename)
 		}
 		w := plan.WheelInput{
-			Filename:    pi.Filename,
-			Name:        metaWheel.Name,
-			Version:     metaWheel.Version,
-			PythonTag:   metaWheel.PythonTag,
-			AbiTag:      metaWheel.AbiTag,
-			PlatformTag: metaWheel.PlatformTag,
-			Digest:      pi.Digest,
-			Requires:    reqs,
+			Filename:       pi.Filename,
+			Name:           metaWheel.Name,
+			Version:        metaWheel.Version,
+			PythonTag:      metaWheel.PythonTag,
+			AbiTag:         metaWheel.AbiTag,
+			PlatformTag:    metaWheel.PlatformTag,
+			Digest:         pi.Digest,
+			Requires:       reqs,
+			RequiresPython: md.RequiresPython,
+			Extras:         md.ProvidesExtra,
 		}
 		return plan.InputSet{Wheels: []plan.WheelInput{w}}, nil
+	case "sdist":
+		// The sdist itself is pinned so the planner emits a build node for
+		// exactly that version; its dependencies resolve like requirements.
+		if meta.Sdist == nil || meta.Sdist.Name == "" || meta.Sdist.Version == "" {
+			return plan.InputSet{}, fmt.Errorf("no sdist metadata for %s", pi.Filename)
+		}
+		reqs := append([]plan.DepSpec{{Name: meta.Sdist.Name, Version: meta.Sdist.Version}}, meta.Requires...)
+		return plan.InputSet{Requirements: reqs}, nil
 	default:
 		if strings.HasSuffix(strings.ToLower(pi.Filename), ".whl") {
 			return plan.InputSet{Wheels: []plan.WheelInput{{Filename: pi.Filename, Digest: pi.Digest}}}, nil
@@ -243,7 +327,7 @@ func inputSetFromPending(ctx context.Context, cfg Config, pi pendingInput, store
 	}
 }
 
-func planOne(ctx context.Context, client *http.Client, cfg Config, store objectstore.Store, pi pendingInput, statusURL string) error {
+func planOne(ctx context.Context, client *http.Client, cfg Config, store objectstore.Store, pi pendingInput, req planRequest, statusURL string) error {
 	inputs, err := inputSetFromPending(ctx, cfg, pi, store)
 	if err != nil {
 		return err
@@ -252,10 +336,19 @@ func planOne(ctx context.Context, client *http.Client, cfg Config, store objects
 	if err != nil {
 		log.Printf("planner: fetch hints failed: %v", err)
 	}
+	indexUser, indexPass := indexCredentials(ctx, client, cfg)
 	cacheDir := cfg.CacheDir
 	if cacheDir != "" && pi.ID > 0 {
 		cacheDir = filepath.Join(cacheDir, "plans", fmt.Sprintf("%d", pi.ID))
 	}
+	if len(inputs.PythonVersions) == 0 {
+		inputs.PythonVersions = cfg.PythonVersions
+	}
+	constraintsPath, cleanup, err := constraintsForPending(ctx, cfg, pi, store)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
 	snap, err := plan.GenerateFromInputs(
 		inputs,
 		cacheDir,
@@ -263,13 +356,19 @@ func planOne(ctx context.Context, client *http.Client, cfg Config, store objects
 		cfg.PlatformTag,
 		cfg.IndexURL,
 		cfg.ExtraIndexURL,
+		indexUser,
+		indexPass,
 		cfg.UpgradeStrategy,
-		cfg.ConstraintsPath,
+		constraintsPath,
 		hints,
 		cfg.PackCatalog,
 		cfg.CASStore(),
 		cfg.CASRegistryURL,
 		cfg.CASRegistryRepo,
+		req.ReuseOnly,
+		req.ForceRebuild,
+		cfg.MaxPlanNodes,
+		cfg.PolicyBaseDigest(),
 	)
 	statusBody := map[string]string{"status": "planned"}
 	if err != nil {
@@ -282,12 +381,55 @@ func planOne(ctx context.Context, client *http.Client, cfg Config, store objects
 	if err != nil {
 		return err
 	}
+	if n := applyManifestReuse(ctx, client, cfg, &snap, req.ForceRebuild); n > 0 {
+		log.Printf("planner: reusing %d previously built wheels for input %d", n, pi.ID)
+	}
 	if err := postPlan(ctx, client, cfg, snap, pi.ID); err != nil {
 		return fmt.Errorf("post plan: %w", err)
 	}
 	return nil
 }
 
+// indexCredentials fetches index credentials from control-plane settings on
+// every plan so rotations apply without a restart. It falls back to
+// INDEX_USERNAME/INDEX_PASSWORD when the control plane has none.
+func indexCredentials(ctx context.Context, client *http.Client, cfg Config) (string, string) {
+	envUser, envPass := os.Getenv("INDEX_USERNAME"), os.Getenv("INDEX_PASSWORD")
+	if cfg.ControlPlaneURL == "" {
+		return envUser, envPass
+	}
+	url := strings.TrimRight(cfg.ControlPlaneURL, "/") + "/api/settings/index-credentials"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return envUser, envPass
+	}
+	if cfg.ControlPlaneToken != "" {
+		req.Header.Set("X-Worker-Token", cfg.ControlPlaneToken)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("planner: fetch index credentials failed: %v", err)
+		return envUser, envPass
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("planner: fetch index credentials status %d", resp.StatusCode)
+		return envUser, envPass
+	}
+	var payload struct {
+		Username string `json:"index_username"`
+		Password string `json:"index_password"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		log.Printf("planner: decode index credentials failed: %v", err)
+		return envUser, envPass
+	}
+	if payload.Username == "" && payload.Password == "" {
+		return envUser, envPass
+	}
+	return payload.Username, payload.Password
+}
+
 func updatePendingStatus(ctx context.Context, client *http.Client, statusURL, token string, id int64, body map[string]string) error {
 	data, _ := json.Marshal(body)
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/%d", statusURL, id), bytes.NewReader(data))
@@ -310,6 +452,50 @@ func updatePendingStatus(ctx context.Context, client *http.Client, statusURL, to
 	return nil
 }
 
+// constraintsForPending returns the constraints file to plan pi with. When
+// the input references an uploaded constraints file, it is fetched and written
+// after the worker's CONSTRAINTS_PATH entries to a temporary file, so the
+// uploaded pins win; cleanup removes that file.
+func constraintsForPending(ctx context.Context, cfg Config, pi pendingInput, store objectstore.Store) (string, func(), error) {
+	noop := func() {}
+	var meta pendingMeta
+	if len(pi.Metadata) > 0 {
+		if err := json.Unmarshal(pi.Metadata, &meta); err != nil {
+			return "", noop, fmt.Errorf("parse metadata: %w", err)
+		}
+	}
+	if meta.ConstraintsKey == "" {
+		return cfg.ConstraintsPath, noop, nil
+	}
+	data, err := fetchInputObject(ctx, cfg, pendingInput{ObjectBucket: pi.ObjectBucket, ObjectKey: meta.ConstraintsKey}, store)
+	if err != nil {
+		return "", noop, fmt.Errorf("fetch constraints %s: %w", meta.ConstraintsKey, err)
+	}
+	var merged []byte
+	if cfg.ConstraintsPath != "" {
+		if base, err := os.ReadFile(cfg.ConstraintsPath); err == nil {
+			merged = append(base, '\n')
+		}
+	}
+	merged = append(merged, data...)
+	f, err := os.CreateTemp("", "constraints-*.txt")
+	if err != nil {
+		return "", noop, err
+	}
+	path := f.Name()
+	cleanup := func() { _ = os.Remove(path) }
+	if _, err := f.Write(merged); err != nil {
+		f.Close()
+		cleanup()
+		return "", noop, err
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return "", noop, err
+	}
+	return path, cleanup, nil
+}
+
 func fetchInputObject(ctx context.Context, cfg Config, pi pendingInput, store objectstore.Store) ([]byte, error) {
 	if pi.ObjectKey == "" {
 		return nil, fmt.Errorf("object key missing")
@@ -328,7 +514,7 @@ func fetchInputObject(ctx context.Context, cfg Config, pi pendingInput, store ob
 }
 
 func parseRequirementsBytes(data []byte) []plan.DepSpec {
-	lines := strings.Split(string(data), "\n")
+	lines := plan.RequirementLines(string(data))
 	out := make([]plan.DepSpec, 0, len(lines))
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
@@ -338,9 +524,16 @@ func parseRequirementsBytes(data []byte) []plan.DepSpec {
 		if strings.HasPrefix(line, "-c ") || strings.HasPrefix(line, "--constraint") {
 			continue
 		}
+		// Includes can't be resolved from a single stored object; the
+		// control plane resolves them at upload time into metadata.
+		if strings.HasPrefix(line, "-r") || strings.HasPrefix(line, "--requirement") {
+			log.Printf("requirements: skipping unresolved include %q", line)
+			continue
+		}
 		if idx := strings.Index(line, " #"); idx != -1 {
 			line = strings.TrimSpace(line[:idx])
 		}
+		line, hashes := plan.SplitRequirementHashes(line)
 		name := line
 		version := ""
 
@@ -367,7 +560,7 @@ func parseRequirementsBytes(data []byte) []plan.DepSpec {
 		if name == "" {
 			continue
 		}
-		out = append(out, plan.DepSpec{Name: name, Version: version})
+		out = append(out, plan.DepSpec{Name: name, Version: version, Hashes: hashes})
 	}
 	return out
 }
@@ -379,34 +572,21 @@ func normalizeReqName(name string) string {
 	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(name), "_", "-"))
 }
 
-func parseWheelFilename(name string) (wheelMeta, error) {
-	base := strings.TrimSuffix(name, ".whl")
-	parts := strings.Split(base, "-")
-	if len(parts) < 5 {
-		return wheelMeta{}, fmt.Errorf("invalid wheel filename: %s", name)
-	}
-	platform := parts[len(parts)-1]
-	abi := parts[len(parts)-2]
-	py := parts[len(parts)-3]
-	version := parts[len(parts)-4]
-	pkgParts := parts[:len(parts)-4]
-	pkg := strings.ReplaceAll(strings.Join(pkgParts, "-"), "_", "-")
-	return wheelMeta{
-		Name:        pkg,
-		Version:     version,
-		PythonTag:   py,
-		AbiTag:      abi,
-		PlatformTag: platform,
-	}, nil
+func parseRequiresDistBytes(data []byte) ([]plan.DepSpec, error) {
+	meta, err := wheelMetadataBytes(data)
+	if err != nil || meta == "" {
+		return nil, err
+	}
+	return parseRequiresDist(meta), nil
 }
 
-func parseRequiresDistBytes(data []byte) ([]plan.DepSpec, error) {
+// wheelMetadataBytes returns the METADATA document from a wheel archive.
+func wheelMetadataBytes(data []byte) (string, error) {
 	reader := bytes.NewReader(data)
 	zr, err := zip.NewReader(reader, int64(len(data)))
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	var meta []byte
 	for _, f := range zr.File {
 		if strings.HasSuffix(f.Name, "METADATA") {
 			rc, err := f.Open()
@@ -416,14 +596,10 @@ func parseRequiresDistBytes(data []byte) ([]plan.DepSpec, error) {
 			buf := new(bytes.Buffer)
 			_, _ = buf.ReadFrom(rc)
 			rc.Close()
-			meta = buf.Bytes()
-			break
+			return buf.String(), nil
 		}
 	}
-	if len(meta) == 0 {
-		return nil, nil
-	}
-	return parseRequiresDist(string(meta)), nil
+	return "", nil
 }
 
 func parseRequiresDist(meta string) []plan.DepSpec {
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/runner"
+)
+
+// provenanceFile is the object name of a job's attestation under
+// <name>/<version>/, tagged by buildFile.
+const provenanceFile = "provenance.json"
+
+const (
+	inTotoStatementType = "https://in-toto.io/Statement/v1"
+	slsaPredicateType   = "https://slsa.dev/provenance/v1"
+	refineryBuildType   = "https://github.com/k8ika0s/s390x-wheel-refinery/build/v1"
+)
+
+type provenanceSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type provenanceDependency struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+// provenanceStatement is an in-toto statement carrying a SLSA v1 predicate.
+type provenanceStatement struct {
+	Type          string              `json:"_type"`
+	Subject       []provenanceSubject `json:"subject"`
+	PredicateType string              `json:"predicateType"`
+	Predicate     struct {
+		BuildDefinition struct {
+			BuildType            string                 `json:"buildType"`
+			ExternalParameters   map[string]any         `json:"externalParameters"`
+			InternalParameters   map[string]any         `json:"internalParameters,omitempty"`
+			ResolvedDependencies []provenanceDependency `json:"resolvedDependencies,omitempty"`
+		} `json:"buildDefinition"`
+		RunDetails struct {
+			Builder struct {
+				ID string `json:"id"`
+			} `json:"builder"`
+			Metadata struct {
+				InvocationID string `json:"invocationId,omitempty"`
+				FinishedOn   string `json:"finishedOn"`
+			} `json:"metadata"`
+		} `json:"runDetails"`
+	} `json:"predicate"`
+}
+
+// builderID identifies this worker in provenance attestations.
+func (w *Worker) builderID() string {
+	if w.Cfg.WorkerID != "" {
+		return w.Cfg.WorkerID
+	}
+	return defaultWorkerID()
+}
+
+// buildProvenance records who built a wheel, from which plan and run, and
+// the digests of every input that went into it.
+func buildProvenance(job runner.Job, builderID, wheelName string, wheel []byte, finished time.Time) ([]byte, error) {
+	var st provenanceStatement
+	st.Type = inTotoStatementType
+	st.PredicateType = slsaPredicateType
+	sum := sha256.Sum256(wheel)
+	st.Subject = []provenanceSubject{{Name: wheelName, Digest: map[string]string{"sha256": hex.EncodeToString(sum[:])}}}
+
+	def := &st.Predicate.BuildDefinition
+	def.BuildType = refineryBuildType
+	def.ExternalParameters = map[string]any{
+		"package":      job.Name,
+		"version":      job.Version,
+		"python_tag":   job.PythonTag,
+		"platform_tag": job.PlatformTag,
+		"plan_id":      job.PlanID,
+		"run_id":       job.RunID,
+	}
+	internal := map[string]any{}
+	if len(job.Recipes) > 0 {
+		internal["recipes"] = job.Recipes
+	}
+	if job.RepairToolVersion != "" {
+		internal["repair_tool_version"] = job.RepairToolVersion
+	}
+	if job.RepairPolicyHash != "" {
+		internal["repair_policy_hash"] = job.RepairPolicyHash
+	}
+	if len(internal) > 0 {
+		def.InternalParameters = internal
+	}
+	if job.WheelSourceDigest != "" {
+		def.ResolvedDependencies = append(def.ResolvedDependencies, provenanceDependency{Name: "source", Digest: digestMap(job.WheelSourceDigest)})
+	}
+	if job.RuntimeDigest != "" {
+		def.ResolvedDependencies = append(def.ResolvedDependencies, provenanceDependency{Name: "runtime", Digest: digestMap(job.RuntimeDigest)})
+	}
+	for _, d := range job.PackDigests {
+		def.ResolvedDependencies = append(def.ResolvedDependencies, provenanceDependency{Name: "pack", Digest: digestMap(d)})
+	}
+
+	run := &st.Predicate.RunDetails
+	run.Builder.ID = builderID
+	run.Metadata.InvocationID = job.RunID
+	run.Metadata.FinishedOn = finished.UTC().Format(time.RFC3339)
+	return json.MarshalIndent(st, "", "  ")
+}
+
+// digestMap converts "alg:hex" into the in-toto DigestSet form.
+func digestMap(digest string) map[string]string {
+	alg, value, ok := strings.Cut(digest, ":")
+	if !ok {
+		return map[string]string{"sha256": digest}
+	}
+	return map[string]string{alg: value}
+}
+
+// publishProvenance writes the wheel's attestation next to it in the output
+// dir and uploads it to the object store under <name>/<version>/provenance.json.
+// Like publishSBOM, it reports whether the upload succeeded.
+func (w *Worker) publishProvenance(ctx context.Context, job runner.Job, wheelName string, wheel []byte) bool {
+	data, err := buildProvenance(job, w.builderID(), wheelName, wheel, time.Now())
+	if err != nil {
+		log.Printf("provenance for %s %s: %v", job.Name, job.Version, err)
+		return false
+	}
+	if err := os.WriteFile(filepath.Join(w.Cfg.OutputDir, wheelName+".provenance.json"), data, 0o644); err != nil {
+		log.Printf("write provenance for %s %s: %v", job.Name, job.Version, err)
+	}
+	if w.Store == nil {
+		return false
+	}
+	key := w.objectKey(job, buildFile(job, provenanceFile))
+	if err := w.Store.Put(ctx, key, data, "application/vnd.in-toto+json"); err != nil {
+		log.Printf("upload provenance for %s %s: %v", job.Name, job.Version, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/artifact"
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/plan"
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/runner"
+)
+
+// ErrNotReproducible reports a rebuilt wheel whose contents differ from the manifest.
+var ErrNotReproducible = errors.New("rebuild not reproducible")
+
+// manifestRecord is one entry of the manifest.json written by Drain.
+type manifestRecord struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	Status      string `json:"status"`
+	PythonTag   string `json:"python_tag"`
+	PlatformTag string `json:"platform_tag"`
+	PlanID      int64  `json:"plan_id"`
+	Metadata    struct {
+		WheelDigest       string   `json:"wheel_digest"`
+		WheelSHA256       string   `json:"wheel_sha256"`
+		WheelSourceDigest string   `json:"wheel_source_digest"`
+		RuntimeDigest     string   `json:"runtime_digest"`
+		PackDigests       []string `json:"pack_digests"`
+		Recipes           []string `json:"recipes"`
+		RepairToolVersion string   `json:"repair_tool_version"`
+		RepairPolicyHash  string   `json:"repair_policy_hash"`
+	} `json:"metadata"`
+}
+
+// RunRebuild replays the build recorded for name/version in a manifest.json
+// and checks that the new wheel matches the recorded contents.
+func RunRebuild(ctx context.Context, manifestPath, name, version string, out io.Writer) error {
+	entry, err := loadManifestRecord(manifestPath, name, version)
+	if err != nil {
+		return err
+	}
+	w, err := BuildWorker(fromEnv())
+	if err != nil {
+		return err
+	}
+	wheel, err := w.Rebuild(ctx, entry)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "rebuild %s %s: %s matches %s\n", entry.Name, entry.Version, wheel, entry.Metadata.WheelSHA256)
+	return nil
+}
+
+func loadManifestRecord(path, name, version string) (manifestRecord, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return manifestRecord{}, err
+	}
+	var entries []manifestRecord
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return manifestRecord{}, fmt.Errorf("parse manifest: %w", err)
+	}
+	for _, e := range entries {
+		if equalsIgnoreCase(e.Name, name) && e.Version == version {
+			return e, nil
+		}
+	}
+	return manifestRecord{}, fmt.Errorf("manifest has no entry for %s %s", name, version)
+}
+
+// jobFromManifest reconstructs the runner job recorded in a manifest entry.
+func jobFromManifest(e manifestRecord) runner.Job {
+	return runner.Job{
+		Name:              e.Name,
+		Version:           e.Version,
+		PythonVersion:     plan.PythonVersionFromTag(e.PythonTag),
+		PythonTag:         e.PythonTag,
+		PlatformTag:       e.PlatformTag,
+		Recipes:           e.Metadata.Recipes,
+		WheelDigest:       e.Metadata.WheelDigest,
+		WheelAction:       "build",
+		RuntimeDigest:     e.Metadata.RuntimeDigest,
+		PackDigests:       e.Metadata.PackDigests,
+		WheelSourceDigest: e.Metadata.WheelSourceDigest,
+		RepairToolVersion: e.Metadata.RepairToolVersion,
+		RepairPolicyHash:  e.Metadata.RepairPolicyHash,
+	}
+}
+
+// rebuildPlan loads the plan a manifest entry was built from: the control
+// plane's copy when the entry names a plan, otherwise the local plan.json.
+func (w *Worker) rebuildPlan(ctx context.Context, e manifestRecord) (plan.Snapshot, error) {
+	if e.PlanID != 0 && w.Cfg.ControlPlaneURL != "" {
+		return w.fetchPlanSnapshot(ctx, e.PlanID)
+	}
+	snap, err := plan.Load(filepath.Join(w.Cfg.OutputDir, "plan.json"))
+	if err != nil {
+		snap, err = plan.Load(filepath.Join(w.Cfg.CacheDir, "plan.json"))
+	}
+	return snap, err
+}
+
+// Rebuild re-runs a manifest entry with the same runtime, packs, and recipes
+// and returns the path of the new wheel once its sha256 matches the record.
+// Packs and the runtime missing from the CAS are built from the entry's plan.
+func (w *Worker) Rebuild(ctx context.Context, entry manifestRecord) (string, error) {
+	job := jobFromManifest(entry)
+	want := entry.Metadata.WheelSHA256
+	if want == "" {
+		return "", fmt.Errorf("manifest entry %s %s has no wheel_sha256", job.Name, job.Version)
+	}
+	if len(job.PackDigests) > 0 || job.RuntimeDigest != "" {
+		snap, err := w.rebuildPlan(ctx, entry)
+		if err != nil {
+			return "", fmt.Errorf("rebuild %s %s: load plan: %w", job.Name, job.Version, err)
+		}
+		arts := indexDAGArtifacts(snap.DAG)
+		var packIDs []artifact.ID
+		for _, d := range job.PackDigests {
+			packIDs = append(packIDs, artifact.ID{Type: artifact.PackType, Digest: d})
+		}
+		job.PackPaths = w.resolvePacks(ctx, packIDs, arts.packActions, arts.packMeta, arts.packInputs)
+		if job.RuntimeDigest != "" {
+			rtID := artifact.ID{Type: artifact.RuntimeType, Digest: job.RuntimeDigest}
+			job.RuntimePath = w.fetchRuntime(ctx, job.PythonVersion, rtID, arts.runtimeActions[rtID.Digest], arts.runtimeMeta[rtID.Digest])
+		}
+	}
+	start := time.Now()
+	if _, _, err := w.Runner.Run(ctx, job); err != nil {
+		return "", fmt.Errorf("rebuild %s %s: %w", job.Name, job.Version, err)
+	}
+	wheel := w.wheelFileForJob(job)
+	if wheel == "" {
+		return "", fmt.Errorf("rebuild %s %s: no wheel produced", job.Name, job.Version)
+	}
+	if info, err := os.Stat(wheel); err != nil || info.ModTime().Before(start.Truncate(time.Second)) {
+		return "", fmt.Errorf("rebuild %s %s: no fresh wheel in %s", job.Name, job.Version, w.Cfg.OutputDir)
+	}
+	got, err := fileDigest(wheel)
+	if err != nil {
+		return "", err
+	}
+	if got != want {
+		return "", fmt.Errorf("%w: %s is %s, manifest has %s", ErrNotReproducible, filepath.Base(wheel), got, want)
+	}
+	return wheel, nil
+}
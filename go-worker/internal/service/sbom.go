@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/runner"
+)
+
+// sbomFile is the object name of a job's SBOM under <name>/<version>/,
+// tagged by buildFile.
+const sbomFile = "sbom.cdx.json"
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cdxComponent struct {
+	Type       string        `json:"type"`
+	BOMRef     string        `json:"bom-ref,omitempty"`
+	Name       string        `json:"name"`
+	Version    string        `json:"version,omitempty"`
+	PURL       string        `json:"purl,omitempty"`
+	Hashes     []cdxHash     `json:"hashes,omitempty"`
+	Properties []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+type cdxBOM struct {
+	BOMFormat   string `json:"bomFormat"`
+	SpecVersion string `json:"specVersion"`
+	Version     int    `json:"version"`
+	Metadata    struct {
+		Timestamp string       `json:"timestamp"`
+		Component cdxComponent `json:"component"`
+	} `json:"metadata"`
+	Components   []cdxComponent  `json:"components,omitempty"`
+	Dependencies []cdxDependency `json:"dependencies,omitempty"`
+}
+
+// buildSBOM renders a CycloneDX 1.5 SBOM for a built wheel, listing the
+// runtime, packs, and source that went into it.
+func buildSBOM(job runner.Job, wheelName string, wheel []byte) ([]byte, error) {
+	var bom cdxBOM
+	bom.BOMFormat = "CycloneDX"
+	bom.SpecVersion = "1.5"
+	bom.Version = 1
+	bom.Metadata.Timestamp = time.Now().UTC().Format(time.RFC3339)
+
+	sum := sha256.Sum256(wheel)
+	root := cdxComponent{
+		Type:    "library",
+		BOMRef:  "wheel",
+		Name:    job.Name,
+		Version: job.Version,
+		PURL:    fmt.Sprintf("pkg:pypi/%s@%s", strings.ToLower(job.Name), job.Version),
+		Hashes:  []cdxHash{{Alg: "SHA-256", Content: hex.EncodeToString(sum[:])}},
+		Properties: []cdxProperty{
+			{Name: "refinery:wheel_file", Value: wheelName},
+			{Name: "refinery:python_tag", Value: job.PythonTag},
+			{Name: "refinery:platform_tag", Value: job.PlatformTag},
+		},
+	}
+	if job.WheelDigest != "" {
+		root.Properties = append(root.Properties, cdxProperty{Name: "refinery:wheel_digest", Value: job.WheelDigest})
+	}
+	if job.RepairToolVersion != "" {
+		root.Properties = append(root.Properties, cdxProperty{Name: "refinery:repair_tool_version", Value: job.RepairToolVersion})
+	}
+	if job.RepairPolicyHash != "" {
+		root.Properties = append(root.Properties, cdxProperty{Name: "refinery:repair_policy_hash", Value: job.RepairPolicyHash})
+	}
+	for _, r := range job.Recipes {
+		root.Properties = append(root.Properties, cdxProperty{Name: "refinery:recipe", Value: r})
+	}
+	bom.Metadata.Component = root
+
+	dep := cdxDependency{Ref: root.BOMRef}
+	add := func(c cdxComponent) {
+		bom.Components = append(bom.Components, c)
+		dep.DependsOn = append(dep.DependsOn, c.BOMRef)
+	}
+	if job.WheelSourceDigest != "" {
+		add(digestComponent("source", job.Name+"-sdist", job.Version, job.WheelSourceDigest))
+	}
+	if job.RuntimeDigest != "" {
+		add(digestComponent("runtime", "python-runtime", job.PythonVersion, job.RuntimeDigest))
+	}
+	for _, d := range job.PackDigests {
+		add(digestComponent("pack", "pack", "", d))
+	}
+	if len(dep.DependsOn) > 0 {
+		bom.Dependencies = []cdxDependency{dep}
+	}
+	return json.MarshalIndent(bom, "", "  ")
+}
+
+// digestComponent describes a CAS artifact identified by its sha256 digest.
+func digestComponent(kind, name, version, digest string) cdxComponent {
+	c := cdxComponent{
+		Type:       "library",
+		BOMRef:     kind + ":" + digest,
+		Name:       name,
+		Version:    version,
+		Properties: []cdxProperty{{Name: "refinery:artifact_type", Value: kind}, {Name: "refinery:digest", Value: digest}},
+	}
+	if kind == "runtime" {
+		c.Type = "platform"
+	}
+	if hexPart, ok := strings.CutPrefix(digest, "sha256:"); ok {
+		c.Hashes = []cdxHash{{Alg: "SHA-256", Content: hexPart}}
+	}
+	return c
+}
+
+// publishSBOM writes the wheel's SBOM next to it in the output dir and
+// uploads it to the object store under <name>/<version>/sbom.cdx.json. It
+// reports whether the upload succeeded, so sbom_url never points at an
+// object that was not written.
+func (w *Worker) publishSBOM(ctx context.Context, job runner.Job, wheelName string, wheel []byte) bool {
+	data, err := buildSBOM(job, wheelName, wheel)
+	if err != nil {
+		log.Printf("sbom for %s %s: %v", job.Name, job.Version, err)
+		return false
+	}
+	if err := os.WriteFile(filepath.Join(w.Cfg.OutputDir, wheelName+".cdx.json"), data, 0o644); err != nil {
+		log.Printf("write sbom for %s %s: %v", job.Name, job.Version, err)
+	}
+	if w.Store == nil {
+		return false
+	}
+	key := w.objectKey(job, buildFile(job, sbomFile))
+	if err := w.Store.Put(ctx, key, data, "application/vnd.cyclonedx+json"); err != nil {
+		log.Printf("upload sbom for %s %s: %v", job.Name, job.Version, err)
+		return false
+	}
+	return true
+}
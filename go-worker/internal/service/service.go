@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/plan"
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/platform"
 )
 
 // Run starts the worker HTTP server (stub for now).
@@ -26,6 +27,8 @@ func Run() error {
 	}
 	planPool := atomic.Int32{}
 	buildPool := atomic.Int32{}
+	maxPlanNodes := atomic.Int32{}
+	maxPlanNodes.Store(int32(cfg.MaxPlanNodes))
 	var pyVersion atomic.Value
 	var platformTag atomic.Value
 	pyVersion.Store(cfg.PythonVersion)
@@ -40,13 +43,17 @@ func Run() error {
 	if err != nil {
 		return err
 	}
+	if err := pingQueue(context.Background(), w.Queue); err != nil {
+		return err
+	}
 	var drainInProgress atomic.Bool
-	runDrain := func(ctx context.Context) (bool, error) {
+	runDrain := func(ctx context.Context) (bool, int, error) {
 		if !drainInProgress.CompareAndSwap(false, true) {
-			return false, nil
+			return false, 0, nil
 		}
 		defer drainInProgress.Store(false)
-		return true, w.RunOnce(ctx)
+		popped, err := w.RunOnce(ctx)
+		return true, popped, err
 	}
 	// allow dynamic pool overrides from settings poller
 	w.buildPoolSize = &buildPool
@@ -65,9 +72,9 @@ func Run() error {
 		if listURL == "" {
 			listURL = strings.TrimRight(cfg.ControlPlaneURL, "/") + "/api/pending-inputs"
 		}
-		go plannerLoop(ctx, cfg, popURL, statusURL, listURL, &planPool, &pyVersion, &platformTag)
+		go plannerLoop(ctx, cfg, popURL, statusURL, listURL, &planPool, &maxPlanNodes, &pyVersion, &platformTag)
 	}
-	go pollSettings(ctx, cfg, &planPool, &buildPool, &pyVersion, &platformTag)
+	go pollSettings(ctx, cfg, &planPool, &buildPool, &maxPlanNodes, &pyVersion, &platformTag)
 	go heartbeatLoop(ctx, cfg, w, workerID, workerRunID, &planPool, &buildPool)
 	if cfg.AutoBuild {
 		go buildLoop(ctx, cfg, runDrain)
@@ -98,7 +105,7 @@ func Run() error {
 		}
 		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Minute)
 		defer cancel()
-		ran, err := runDrain(ctx)
+		ran, _, err := runDrain(ctx)
 		if !ran {
 			wr.WriteHeader(http.StatusConflict)
 			_, _ = wr.Write([]byte(`{"error":"worker already running"}`))
@@ -162,6 +169,7 @@ func Run() error {
 				cfg.CASStore(),
 				cfg.CASRegistryURL,
 				cfg.CASRegistryRepo,
+				cfg.PolicyBaseDigest(),
 			)
 			if err != nil {
 				wr.WriteHeader(http.StatusInternalServerError)
@@ -188,8 +196,9 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 	_ = json.NewEncoder(w).Encode(v)
 }
 
-// pollSettings periodically refreshes pool sizes from control-plane settings.
-func pollSettings(ctx context.Context, cfg Config, planPool, buildPool *atomic.Int32, pyVersion, platformTag *atomic.Value) {
+// pollSettings periodically refreshes pool sizes and the plan size cap from
+// control-plane settings.
+func pollSettings(ctx context.Context, cfg Config, planPool, buildPool, maxPlanNodes *atomic.Int32, pyVersion, platformTag *atomic.Value) {
 	if cfg.ControlPlaneURL == "" {
 		return
 	}
@@ -207,6 +216,9 @@ func pollSettings(ctx context.Context, cfg Config, planPool, buildPool *atomic.I
 			if updated.BuildPoolSize > 0 && buildPool != nil {
 				buildPool.Store(int32(updated.BuildPoolSize))
 			}
+			if maxPlanNodes != nil {
+				maxPlanNodes.Store(int32(updated.MaxPlanNodes))
+			}
 			if pyVersion != nil && updated.PythonVersion != "" {
 				pyVersion.Store(updated.PythonVersion)
 			}
@@ -245,6 +257,7 @@ func overlaySettingsFromControlPlane(cfg Config) Config {
 	var payload struct {
 		PlanPoolSize  int    `json:"plan_pool_size"`
 		BuildPoolSize int    `json:"build_pool_size"`
+		MaxPlanNodes  int    `json:"max_plan_nodes"`
 		PythonVersion string `json:"python_version"`
 		PlatformTag   string `json:"platform_tag"`
 	}
@@ -258,6 +271,9 @@ func overlaySettingsFromControlPlane(cfg Config) Config {
 	if payload.BuildPoolSize > 0 {
 		cfg.BuildPoolSize = payload.BuildPoolSize
 	}
+	if payload.MaxPlanNodes > 0 {
+		cfg.MaxPlanNodes = payload.MaxPlanNodes
+	}
 	if payload.PythonVersion != "" && validPythonVersion(payload.PythonVersion) {
 		cfg.PythonVersion = payload.PythonVersion
 	}
@@ -275,13 +291,6 @@ func validPythonVersion(v string) bool {
 }
 
 func validPlatformTag(tag string) bool {
-	if tag == "" || len(tag) > 64 {
-		return false
-	}
-	for _, r := range tag {
-		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' || r == '.') {
-			return false
-		}
-	}
-	return true
+	_, err := platform.Parse(tag)
+	return err == nil
 }
@@ -1,14 +1,25 @@
 package service
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/artifact"
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/cas"
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/plan"
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/queue"
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/runner"
 )
 
 func TestPlanEndpointGeneratesPlan(t *testing.T) {
@@ -51,6 +62,7 @@ func TestPlanEndpointGeneratesPlan(t *testing.T) {
 				cfg.CASStore(),
 				cfg.CASRegistryURL,
 				cfg.CASRegistryRepo,
+				"",
 			)
 			if err != nil {
 				wr.WriteHeader(http.StatusInternalServerError)
@@ -112,3 +124,480 @@ func TestOverlaySettingsFromControlPlane(t *testing.T) {
 		t.Fatalf("overlay failed: %#v", out)
 	}
 }
+
+func TestPolicyBaseDigestHashesResolvedImage(t *testing.T) {
+	cfg := fromEnv()
+	stub := cfg.PolicyBaseDigest()
+	if stub == "" {
+		t.Fatalf("default image and preset should yield a policy base digest")
+	}
+	if stub != plan.PolicyBaseDigest("refinery-builder:latest", "rocky", "") {
+		t.Fatalf("stub podman should hash the configured reference, got %s", stub)
+	}
+
+	id := "sha256:1111"
+	orig := inspectImageID
+	inspectImageID = func(bin, image string) (string, error) {
+		if image != cfg.ContainerImage {
+			t.Fatalf("inspected %s, want %s", image, cfg.ContainerImage)
+		}
+		return id, nil
+	}
+	defer func() { inspectImageID = orig }()
+	cfg.PodmanBin = "podman"
+	first := cfg.PolicyBaseDigest()
+	if first == stub {
+		t.Fatalf("resolved image ID should be hashed instead of the tag")
+	}
+	id = "sha256:2222"
+	if cfg.PolicyBaseDigest() == first {
+		t.Fatalf("retagged image should change the policy base digest")
+	}
+}
+
+func TestIndexCredentialsPreferControlPlane(t *testing.T) {
+	t.Setenv("INDEX_USERNAME", "env-user")
+	t.Setenv("INDEX_PASSWORD", "env-pass")
+	creds := `{"index_username":"db-user","index_password":"db-pass"}`
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/settings/index-credentials" || r.Header.Get("X-Worker-Token") != "tok" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(creds))
+	}))
+	defer s.Close()
+	cfg := Config{ControlPlaneURL: s.URL, ControlPlaneToken: "tok"}
+
+	user, pass := indexCredentials(context.Background(), s.Client(), cfg)
+	if user != "db-user" || pass != "db-pass" {
+		t.Fatalf("expected control-plane credentials, got %q/%q", user, pass)
+	}
+
+	creds = `{}`
+	user, pass = indexCredentials(context.Background(), s.Client(), cfg)
+	if user != "env-user" || pass != "env-pass" {
+		t.Fatalf("expected env fallback, got %q/%q", user, pass)
+	}
+}
+
+func TestTopoSortFromDagReportsCycle(t *testing.T) {
+	a := artifact.ID{Type: artifact.PackType, Digest: "sha256:a"}
+	b := artifact.ID{Type: artifact.PackType, Digest: "sha256:b"}
+	dag := []plan.DAGNode{
+		{ID: a, Type: plan.NodePack, Inputs: []artifact.ID{b}, Metadata: map[string]any{"name": "openssl"}},
+		{ID: b, Type: plan.NodePack, Inputs: []artifact.ID{a}, Metadata: map[string]any{"name": "zlib"}},
+	}
+	_, err := topoSortFromDag([]artifact.ID{a}, dag)
+	if err == nil || !strings.Contains(err.Error(), "openssl -> zlib -> openssl") {
+		t.Fatalf("expected cycle error naming packs, got %v", err)
+	}
+
+	dag[1].Inputs = nil
+	ordered, err := topoSortFromDag([]artifact.ID{a, b}, dag)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ordered) != 2 || ordered[0] != b || ordered[1] != a {
+		t.Fatalf("expected dependency first, got %v", ordered)
+	}
+}
+
+type listingStore struct {
+	objects map[string][]byte
+}
+
+func (s listingStore) Put(_ context.Context, key string, data []byte, _ string) error {
+	s.objects[key] = data
+	return nil
+}
+
+func (s listingStore) Get(_ context.Context, key string) ([]byte, string, error) {
+	return s.objects[key], "", nil
+}
+
+func (s listingStore) URL(string) string { return "" }
+
+func (s listingStore) List(_ context.Context, _ string) ([]string, error) {
+	var keys []string
+	for k := range s.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func TestFindOrphansSkipsReferencedArtifacts(t *testing.T) {
+	kept := []byte("kept wheel")
+	sum := sha256.Sum256(kept)
+	keptDigest := "sha256:" + hex.EncodeToString(sum[:])
+	repairHex := strings.Repeat("a", 64)
+	store := listingStore{objects: map[string][]byte{
+		"numpy/1.26.0/numpy-1.26.0.whl":                    kept,
+		"numpy/1.25.0/numpy-1.25.0.whl":                    []byte("stale wheel"),
+		"numpy/1.26.0/repair-sha256:" + repairHex + ".whl": []byte("repaired"),
+	}}
+	referenced := map[string]bool{keptDigest: true, "sha256:" + repairHex: true}
+	orphans, err := findOrphans(context.Background(), store, referenced, nil)
+	if err != nil {
+		t.Fatalf("find orphans: %v", err)
+	}
+	if len(orphans) != 1 || orphans[0].Key != "numpy/1.25.0/numpy-1.25.0.whl" {
+		t.Fatalf("expected only the stale wheel, got %+v", orphans)
+	}
+}
+
+func TestGCKeepsManifestBuildFiles(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/artifacts/referenced" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"digests":[],"builds":[{"name":"NumPy","version":"1.26.0","python_tag":"cp311","platform_tag":"manylinux2014_s390x"}]}`))
+	}))
+	defer s.Close()
+	cfg := Config{ControlPlaneURL: s.URL}
+	referenced, keep, err := fetchReferenced(context.Background(), s.Client(), cfg)
+	if err != nil {
+		t.Fatalf("fetch referenced: %v", err)
+	}
+	job := runner.Job{Name: "NumPy", Version: "1.26.0", PythonTag: "cp311", PlatformTag: "manylinux2014_s390x"}
+	sbomKey := renderObjectKey(cfg.ObjectKeyTemplate, job, buildFile(job, sbomFile))
+	provenanceKey := renderObjectKey(cfg.ObjectKeyTemplate, job, buildFile(job, provenanceFile))
+	store := listingStore{objects: map[string][]byte{
+		sbomKey:                            []byte(`{"bomFormat":"CycloneDX"}`),
+		provenanceKey:                      []byte(`{"_type":"https://in-toto.io/Statement/v1"}`),
+		"numpy/1.25.0/sbom-cp311.cdx.json": []byte(`{"bomFormat":"CycloneDX","stale":true}`),
+	}}
+	orphans, err := findOrphans(context.Background(), store, referenced, keep)
+	if err != nil {
+		t.Fatalf("find orphans: %v", err)
+	}
+	if len(orphans) != 1 || orphans[0].Key != "numpy/1.25.0/sbom-cp311.cdx.json" {
+		t.Fatalf("expected only the unreferenced SBOM, got %+v", orphans)
+	}
+}
+
+func TestInputSetFromPendingSdist(t *testing.T) {
+	pi := pendingInput{
+		Filename:   "demo-0.3.tar.gz",
+		SourceType: "sdist",
+		Metadata:   json.RawMessage(`{"type":"sdist","sdist":{"name":"demo","version":"0.3"},"requires":[{"name":"numpy","version":"1.26.0"}]}`),
+	}
+	inputs, err := inputSetFromPending(context.Background(), Config{}, pi, nil)
+	if err != nil {
+		t.Fatalf("input set: %v", err)
+	}
+	if len(inputs.Wheels) != 0 || len(inputs.Requirements) != 2 {
+		t.Fatalf("unexpected inputs: %+v", inputs)
+	}
+	if got := inputs.Requirements[0]; got.Name != "demo" || got.Version != "0.3" {
+		t.Fatalf("expected pinned sdist requirement, got %+v", got)
+	}
+	snap, err := plan.GenerateFromInputs(inputs, t.TempDir(), "3.11", "manylinux2014_s390x", "", "", "", "", "", "", nil, nil, nil, "", "", false, nil, 0, "")
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	found := false
+	for _, n := range snap.Plan {
+		if n.Name == "demo" && n.Version == "0.3" && n.Action == "build" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected build node for demo 0.3, got %+v", snap.Plan)
+	}
+}
+
+func TestPlannedRepairDigestMatchesWorker(t *testing.T) {
+	t.Setenv("REPAIR_TOOL_VERSION", "auditwheel-6.1")
+	t.Setenv("REPAIR_POLICY_HASH", "sha256:policy")
+	inputs := plan.InputSet{Requirements: []plan.DepSpec{{Name: "demo", Version: "0.3"}}}
+	snap, err := plan.GenerateFromInputs(inputs, t.TempDir(), "3.11", "manylinux2014_s390x", "", "", "", "", "", "", nil, nil, nil, "", "", false, nil, 0, "")
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	w := &Worker{Cfg: Config{RepairToolVersion: "auditwheel-6.1", RepairPolicyHash: "sha256:policy"}}
+	planned := ""
+	for _, n := range snap.DAG {
+		if n.Type != plan.NodeRepair {
+			continue
+		}
+		planned = n.ID.Digest
+		want := w.repairKey(n.Inputs[0].Digest).Digest()
+		if n.ID.Digest != want {
+			t.Fatalf("planned repair digest %s, worker computes %s", n.ID.Digest, want)
+		}
+		if findRepairToolVersion(snap.DAG, n.Inputs[0].Digest) != "auditwheel-6.1" || findRepairPolicyHash(snap.DAG, n.Inputs[0].Digest) != "sha256:policy" {
+			t.Fatalf("repair node metadata missing versions: %+v", n.Metadata)
+		}
+	}
+	if planned == "" {
+		t.Fatalf("expected a repair node, got %+v", snap.DAG)
+	}
+
+	// The digest reported for the build must be the one the worker pushes.
+	dir := t.TempDir()
+	if err := plan.Write(filepath.Join(dir, "plan.json"), snap); err != nil {
+		t.Fatalf("write plan: %v", err)
+	}
+	registry := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusCreated)
+	}))
+	defer registry.Close()
+	w.Cfg.OutputDir = dir
+	w.Cfg.CacheDir = dir
+	w.Cfg.RepairPushEnabled = true
+	w.Pusher = cas.Pusher{BaseURL: registry.URL}
+	w.Queue = &stubQueue{reqs: []queue.Request{{Package: "demo", Version: "0.3"}}}
+	w.Runner = &logRunner{log: "ok"}
+	w.packPath = make(map[string]string)
+	if err := w.Drain(context.Background()); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		t.Fatalf("manifest not written: %v", err)
+	}
+	var entries []map[string]any
+	if err := json.Unmarshal(data, &entries); err != nil || len(entries) != 1 {
+		t.Fatalf("unexpected manifest %s: %v", data, err)
+	}
+	if entries[0]["repair_digest"] != planned {
+		t.Fatalf("reported repair_digest %v, planned %s", entries[0]["repair_digest"], planned)
+	}
+}
+
+func TestWheelUploadPlansForItsPythonVersion(t *testing.T) {
+	pi := pendingInput{
+		Filename:   "demo-1.0-cp311-cp311-manylinux2014_s390x.whl",
+		SourceType: "wheel",
+		Metadata:   json.RawMessage(`{"type":"wheel","wheel":{"name":"demo","version":"1.0","python_tag":"cp311","abi_tag":"cp311","platform_tag":"manylinux2014_s390x"}}`),
+	}
+	inputs, err := inputSetFromPending(context.Background(), Config{}, pi, nil)
+	if err != nil {
+		t.Fatalf("input set: %v", err)
+	}
+	if len(inputs.PythonVersions) != 1 || inputs.PythonVersions[0] != "3.11" {
+		t.Fatalf("expected python 3.11 from the wheel tag, got %v", inputs.PythonVersions)
+	}
+	snap, err := plan.GenerateFromInputs(inputs, t.TempDir(), "3.12", "manylinux2014_s390x", "", "", "", "", "", "", nil, nil, nil, "", "", false, nil, 0, "")
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if len(snap.Plan) != 1 || snap.Plan[0].PythonVersion != "3.11" || snap.Plan[0].PythonTag != "cp311" || snap.Plan[0].Action != "reuse" {
+		t.Fatalf("expected cp311 wheel reused for 3.11 despite a 3.12 default, got %+v", snap.Plan)
+	}
+
+	reqs := pendingInput{
+		Filename:   "requirements.txt",
+		SourceType: "requirements",
+		Metadata:   json.RawMessage(`{"type":"requirements","requirements":[{"name":"demo","version":"1.0"}]}`),
+	}
+	if inputs, err := inputSetFromPending(context.Background(), Config{}, reqs, nil); err != nil || len(inputs.PythonVersions) != 0 {
+		t.Fatalf("expected requirements to use the configured version, got %v (%v)", inputs.PythonVersions, err)
+	}
+	reqs.Metadata = json.RawMessage(`{"type":"requirements","requirements":[{"name":"demo","version":"1.0"}],"python_version":"3.10"}`)
+	if inputs, err := inputSetFromPending(context.Background(), Config{}, reqs, nil); err != nil || len(inputs.PythonVersions) != 1 || inputs.PythonVersions[0] != "3.10" {
+		t.Fatalf("expected upload python_version 3.10, got %v (%v)", inputs.PythonVersions, err)
+	}
+}
+
+func TestPlanMatrixCoversEachPythonVersion(t *testing.T) {
+	inputs := plan.InputSet{
+		Requirements:   []plan.DepSpec{{Name: "demo", Version: "1.0"}},
+		PythonVersions: []string{"3.10", "3.11"},
+	}
+	snap, err := plan.GenerateFromInputs(inputs, t.TempDir(), "3.12", "manylinux2014_s390x", "", "", "", "", "", "", nil, nil, nil, "", "", false, nil, 0, "")
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	tags := map[string]string{}
+	for _, n := range snap.Plan {
+		tags[n.PythonTag] = n.PythonVersion
+	}
+	if len(snap.Plan) != 2 || tags["cp310"] != "3.10" || tags["cp311"] != "3.11" {
+		t.Fatalf("expected demo planned for cp310 and cp311, got %+v", snap.Plan)
+	}
+	runtimes := map[string]bool{}
+	wheels := map[string]bool{}
+	for _, n := range snap.DAG {
+		switch n.Type {
+		case plan.NodeRuntime:
+			runtimes[n.ID.Digest] = true
+		case plan.NodeWheel:
+			wheels[n.ID.Digest] = true
+		}
+	}
+	if len(runtimes) != 2 || len(wheels) != 2 {
+		t.Fatalf("expected a runtime and wheel per version, got %d runtimes and %d wheels", len(runtimes), len(wheels))
+	}
+}
+
+func TestPerInputIndexOverridesConfig(t *testing.T) {
+	pi := pendingInput{
+		Filename:   "requirements.txt",
+		SourceType: "requirements",
+		Metadata:   json.RawMessage(`{"type":"requirements","requirements":[{"name":"demo"}],"index_url":"https://mirror.team-a.example/simple"}`),
+	}
+	inputs, err := inputSetFromPending(context.Background(), Config{}, pi, nil)
+	if err != nil {
+		t.Fatalf("input set: %v", err)
+	}
+	if inputs.IndexURL != "https://mirror.team-a.example/simple" {
+		t.Fatalf("expected per-input index, got %q", inputs.IndexURL)
+	}
+	// The index client uses the default transport; answer every lookup
+	// locally and record where it went.
+	var hosts []string
+	var authSent bool
+	orig := http.DefaultTransport
+	http.DefaultTransport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		hosts = append(hosts, r.URL.Host)
+		authSent = authSent || r.Header.Get("Authorization") != ""
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"info":{"version":"2.0"}}`)),
+			Header:     make(http.Header),
+		}, nil
+	})
+	t.Cleanup(func() { http.DefaultTransport = orig })
+
+	inputs.IndexURL = "https://team.pypi.org/simple"
+	snap, err := plan.GenerateFromInputs(inputs, t.TempDir(), "3.11", "manylinux2014_s390x", "https://pypi.org/simple", "", "user", "secret", "", "", nil, nil, nil, "", "", false, nil, 0, "")
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if len(hosts) == 0 || hosts[0] != "team.pypi.org" {
+		t.Fatalf("expected the per-input index to be queried, got %v", hosts)
+	}
+	if authSent {
+		t.Fatalf("config credentials must not be sent to a different index host")
+	}
+	if len(snap.Plan) == 0 || snap.Plan[0].Version != "2.0" {
+		t.Fatalf("expected demo resolved from the per-input index, got %+v", snap.Plan)
+	}
+}
+
+func TestUploadedConstraintsPinTransitiveDeps(t *testing.T) {
+	orig := http.DefaultTransport
+	http.DefaultTransport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"info":{"version":"2.0.0"}}`)),
+			Header:     make(http.Header),
+		}, nil
+	})
+	t.Cleanup(func() { http.DefaultTransport = orig })
+
+	store := listingStore{objects: map[string][]byte{"inputs/constraints/abc/constraints.txt": []byte("numpy==1.26.4\n")}}
+	planNumpy := func(meta string) string {
+		t.Helper()
+		pi := pendingInput{Filename: "demo-1.0-py3-none-any.whl", SourceType: "wheel", Metadata: json.RawMessage(meta)}
+		inputs, err := inputSetFromPending(context.Background(), Config{}, pi, store)
+		if err != nil {
+			t.Fatalf("input set: %v", err)
+		}
+		constraints, cleanup, err := constraintsForPending(context.Background(), Config{}, pi, store)
+		if err != nil {
+			t.Fatalf("constraints: %v", err)
+		}
+		defer cleanup()
+		snap, err := plan.GenerateFromInputs(inputs, t.TempDir(), "3.11", "manylinux2014_s390x", "https://pypi.org/simple", "", "", "", "", constraints, nil, nil, nil, "", "", false, nil, 0, "")
+		if err != nil {
+			t.Fatalf("plan: %v", err)
+		}
+		for _, n := range snap.Plan {
+			if n.Name == "numpy" {
+				return n.Version
+			}
+		}
+		t.Fatalf("no numpy node in %+v", snap.Plan)
+		return ""
+	}
+	wheel := `"type":"wheel","wheel":{"name":"demo","version":"1.0","python_tag":"py3","abi_tag":"none","platform_tag":"any"},"requires":[{"name":"numpy"}]`
+	if got := planNumpy(`{` + wheel + `}`); got != "2.0.0" {
+		t.Fatalf("expected numpy to resolve to the latest version without constraints, got %s", got)
+	}
+	if got := planNumpy(`{` + wheel + `,"constraints_key":"inputs/constraints/abc/constraints.txt"}`); got != "1.26.4" {
+		t.Fatalf("expected the uploaded constraint to pin numpy, got %s", got)
+	}
+}
+
+func TestParsePlanQueueItem(t *testing.T) {
+	if got := parsePlanQueueItem("42"); got.ID != "42" || got.ReuseOnly {
+		t.Fatalf("plain id: %+v", got)
+	}
+	if got := parsePlanQueueItem("42?reuse_only=true"); got.ID != "42" || !got.ReuseOnly {
+		t.Fatalf("reuse-only id: %+v", got)
+	}
+	if got := parsePlanQueueItem("42?force_rebuild=true"); len(got.ForceRebuild) != 1 || got.ForceRebuild[0] != "*" {
+		t.Fatalf("force everything: %+v", got)
+	}
+	if got := parsePlanQueueItem("42?force_rebuild=numpy,scipy"); len(got.ForceRebuild) != 2 || got.ForceRebuild[1] != "scipy" {
+		t.Fatalf("force packages: %+v", got)
+	}
+}
+
+func TestPollBackoffTracksLoad(t *testing.T) {
+	b := newPollBackoff(time.Second, 8*time.Second)
+	// idle, idle, idle, idle, idle, burst, burst, idle
+	popped := []int{0, 0, 0, 0, 0, 4, 2, 0}
+	want := []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second,
+		0, 0,
+		time.Second,
+	}
+	for i, n := range popped {
+		if got := b.next(n); got != want[i] {
+			t.Fatalf("poll %d (popped %d): expected wait %s, got %s", i, n, want[i], got)
+		}
+	}
+}
+
+func TestManifestReuseFlipsBuildNode(t *testing.T) {
+	var asked []manifestKey
+	cp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/manifest/lookup" {
+			http.NotFound(w, r)
+			return
+		}
+		var body struct {
+			Wheels []manifestKey `json:"wheels"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		asked = body.Wheels
+		writeJSON(w, http.StatusOK, map[string]any{"entries": []builtManifestEntry{
+			{Name: "NumPy", Version: "1.26.4", PythonTag: "cp311", PlatformTag: "manylinux2014_s390x"},
+		}})
+	}))
+	defer cp.Close()
+
+	meta := func(name, version string) map[string]any {
+		return map[string]any{"name": name, "version": version, "python_tag": "cp311", "platform_tag": "manylinux2014_s390x"}
+	}
+	snap := plan.Snapshot{
+		Plan: []plan.FlatNode{
+			{Name: "numpy", Version: "1.26.4", PythonTag: "cp311", PlatformTag: "manylinux2014_s390x", Action: "build"},
+			{Name: "scipy", Version: "1.13.0", PythonTag: "cp311", PlatformTag: "manylinux2014_s390x", Action: "build"},
+			{Name: "six", Version: "1.16.0", PythonTag: "cp311", PlatformTag: "manylinux2014_s390x", Action: "reuse"},
+		},
+		DAG: []plan.DAGNode{
+			{Type: plan.NodeWheel, Action: "build", Metadata: meta("numpy", "1.26.4")},
+			{Type: plan.NodeWheel, Action: "build", Metadata: meta("scipy", "1.13.0")},
+		},
+	}
+	n := applyManifestReuse(context.Background(), cp.Client(), Config{ControlPlaneURL: cp.URL}, &snap, nil)
+	if n != 1 {
+		t.Fatalf("expected one node flipped, got %d", n)
+	}
+	if len(asked) != 2 {
+		t.Fatalf("expected only build nodes in the lookup, got %+v", asked)
+	}
+	if snap.Plan[0].Action != "reuse" || snap.Plan[1].Action != "build" {
+		t.Fatalf("unexpected plan actions: %+v", snap.Plan)
+	}
+	if snap.DAG[0].Action != "reuse" || snap.DAG[1].Action != "build" {
+		t.Fatalf("unexpected dag: %+v", snap.DAG)
+	}
+}
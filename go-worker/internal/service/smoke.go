@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/plan"
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/queue"
+)
+
+// The smoke build uses six: it is pure python with no dependencies, so it
+// builds wherever the pipeline itself works.
+const (
+	smokePackage = "six"
+	smokeVersion = "1.16.0"
+)
+
+// SmokeResult is the outcome of a smoke build. Stage names the step that
+// failed (plan, build, or manifest) and is empty on success.
+type SmokeResult struct {
+	OK         bool   `json:"ok"`
+	Package    string `json:"package"`
+	Version    string `json:"version"`
+	Stage      string `json:"stage,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Wheel      string `json:"wheel,omitempty"`
+	PlanMs     int64  `json:"plan_ms"`
+	BuildMs    int64  `json:"build_ms"`
+	TotalMs    int64  `json:"total_ms"`
+	FinishedAt int64  `json:"finished_at"`
+}
+
+// smokePlan resolves the smoke package against the configured index. Tests
+// replace it to stay offline.
+var smokePlan = func(ctx context.Context, cfg Config) (plan.Snapshot, error) {
+	user, pass := indexCredentials(ctx, &http.Client{Timeout: 10 * time.Second}, cfg)
+	return plan.GenerateFromInputs(
+		plan.InputSet{Requirements: []plan.DepSpec{{Name: smokePackage, Version: smokeVersion}}},
+		filepath.Join(cfg.CacheDir, "smoke"),
+		cfg.PythonVersion,
+		cfg.PlatformTag,
+		cfg.IndexURL,
+		cfg.ExtraIndexURL,
+		user,
+		pass,
+		cfg.UpgradeStrategy,
+		"",
+		nil,
+		cfg.PackCatalog,
+		cfg.CASStore(),
+		cfg.CASRegistryURL,
+		cfg.CASRegistryRepo,
+		false,
+		nil,
+		cfg.MaxPlanNodes,
+		cfg.PolicyBaseDigest(),
+	)
+}
+
+// Smoke runs the smoke package through plan, build, and manifest with the
+// worker's configured runner and stores. The manifest goes to
+// OutputDir/smoke so it never replaces the real one.
+func (w *Worker) Smoke(ctx context.Context) SmokeResult {
+	res := SmokeResult{Package: smokePackage, Version: smokeVersion}
+	start := time.Now()
+	fail := func(stage string, err error) SmokeResult {
+		res.Stage = stage
+		res.Error = err.Error()
+		res.TotalMs = time.Since(start).Milliseconds()
+		res.FinishedAt = time.Now().Unix()
+		return res
+	}
+
+	snap, err := smokePlan(ctx, w.Cfg)
+	res.PlanMs = time.Since(start).Milliseconds()
+	if err != nil {
+		return fail("plan", err)
+	}
+	jobs := w.match(ctx, snap, []queue.Request{{Package: smokePackage, Version: smokeVersion}})
+	if len(jobs) == 0 {
+		return fail("plan", fmt.Errorf("plan has no node for %s %s", smokePackage, smokeVersion))
+	}
+	job := jobs[0]
+	// Always build: a reused wheel would skip the builder this is meant to check.
+	job.WheelAction = "build"
+
+	buildStart := time.Now()
+	dur, _, err := w.Runner.Run(ctx, job)
+	res.BuildMs = time.Since(buildStart).Milliseconds()
+	if err != nil {
+		return fail("build", err)
+	}
+	wheel := w.wheelFileForJob(job)
+	if wheel == "" {
+		return fail("build", fmt.Errorf("no wheel for %s in %s", smokePackage, w.Cfg.OutputDir))
+	}
+	res.Wheel = filepath.Base(wheel)
+
+	if w.Cfg.OutputDir == "" {
+		return fail("manifest", fmt.Errorf("output dir not configured"))
+	}
+	writeManifest(filepath.Join(w.Cfg.OutputDir, "smoke"), []map[string]any{{
+		"name":         job.Name,
+		"version":      job.Version,
+		"status":       "built",
+		"python_tag":   job.PythonTag,
+		"platform_tag": job.PlatformTag,
+		"wheel":        res.Wheel,
+		"run_id":       job.RunID,
+		"builder_id":   w.builderID(),
+		"metadata":     map[string]any{"duration_ms": dur.Milliseconds(), "wheel_action": job.WheelAction, "smoke": true},
+	}})
+	res.OK = true
+	res.TotalMs = time.Since(start).Milliseconds()
+	res.FinishedAt = time.Now().Unix()
+	return res
+}
+
+// RunSmoke runs a smoke build, prints the result, and reports it to the
+// control plane as part of a heartbeat so it shows on /api/workers.
+func RunSmoke(ctx context.Context, out io.Writer) error {
+	cfg := fromEnv()
+	w, err := BuildWorker(cfg)
+	if err != nil {
+		return err
+	}
+	res := w.Smoke(ctx)
+	if res.OK {
+		fmt.Fprintf(out, "smoke %s %s: ok in %dms (plan %dms, build %dms) wheel=%s\n", res.Package, res.Version, res.TotalMs, res.PlanMs, res.BuildMs, res.Wheel)
+	} else {
+		fmt.Fprintf(out, "smoke %s %s: failed at %s after %dms: %s\n", res.Package, res.Version, res.Stage, res.TotalMs, res.Error)
+	}
+	if cfg.ControlPlaneURL != "" {
+		if err := postHeartbeat(ctx, cfg, smokeHeartbeat(cfg, res)); err != nil {
+			fmt.Fprintf(out, "smoke: report to control plane failed: %v\n", err)
+		}
+	}
+	if !res.OK {
+		return fmt.Errorf("smoke build failed at %s: %s", res.Stage, res.Error)
+	}
+	return nil
+}
+
+// smokeHeartbeat carries a smoke result on the worker's heartbeat.
+func smokeHeartbeat(cfg Config, res SmokeResult) map[string]any {
+	workerID := cfg.WorkerID
+	if workerID == "" {
+		workerID = defaultWorkerID()
+	}
+	return map[string]any{
+		"worker_id":       workerID,
+		"build_pool_size": cfg.BuildPoolSize,
+		"plan_pool_size":  cfg.PlanPoolSize,
+		"smoke":           res,
+	}
+}
@@ -28,6 +28,7 @@ import (
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/cas"
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/objectstore"
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/plan"
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/platform"
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/queue"
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/reporter"
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/runner"
@@ -44,6 +45,7 @@ type Worker struct {
 	Fetcher      cas.Fetcher
 	Pusher       cas.Pusher
 	packPath     map[string]string
+	packMu       sync.Mutex
 	mu           sync.Mutex
 	planSnap     plan.Snapshot
 	autoHintMu   sync.Mutex
@@ -60,6 +62,8 @@ type result struct {
 	err      error
 	repair   artifact.ID
 	attempt  int
+	// cancelled is set when the build was stopped by a cancel request.
+	cancelled bool
 }
 
 // LoadPlan reads plan.json if present.
@@ -94,6 +98,7 @@ func (w *Worker) LoadPlan() error {
 				w.Cfg.CASStore(),
 				w.Cfg.CASRegistryURL,
 				w.Cfg.CASRegistryRepo,
+				w.Cfg.PolicyBaseDigest(),
 			)
 			if err != nil {
 				return err
@@ -108,6 +113,13 @@ func (w *Worker) LoadPlan() error {
 
 // Drain pops from queue and executes matched jobs.
 func (w *Worker) Drain(ctx context.Context) error {
+	_, err := w.drain(ctx)
+	return err
+}
+
+// drain is Drain that also reports how many requests were popped, which the
+// build loop uses to pace its polling.
+func (w *Worker) drain(ctx context.Context) (int, error) {
 	var reqs []queue.Request
 	var err error
 	usingBuildQueue := w.Cfg.BuildPopURL != ""
@@ -115,15 +127,28 @@ func (w *Worker) Drain(ctx context.Context) error {
 		reqs, err = w.popBuildQueue(ctx)
 	} else {
 		if err := w.LoadPlan(); err != nil {
-			return fmt.Errorf("load plan: %w", err)
+			return 0, fmt.Errorf("load plan: %w", err)
 		}
 		reqs, err = w.Queue.Pop(ctx, w.Cfg.BatchSize)
 	}
 	if err != nil {
-		return err
+		return 0, err
+	}
+	// Popped requests are leased; hand them back if the drain stops before
+	// their results are recorded. A crash leaves the lease to expire instead.
+	settled := false
+	if !usingBuildQueue {
+		defer func() {
+			if settled {
+				return
+			}
+			if err := w.Queue.Nack(context.WithoutCancel(ctx), reqs); err != nil {
+				log.Printf("queue nack failed: %v", err)
+			}
+		}()
 	}
 	if len(reqs) == 0 {
-		return nil
+		return 0, nil
 	}
 
 	reqAttempts := make(map[string]int)
@@ -138,7 +163,7 @@ func (w *Worker) Drain(ctx context.Context) error {
 	if usingBuildQueue {
 		jobs, err = w.jobsFromBuildQueue(ctx, reqs)
 		if err != nil {
-			return err
+			return len(reqs), err
 		}
 	} else {
 		w.mu.Lock()
@@ -147,7 +172,9 @@ func (w *Worker) Drain(ctx context.Context) error {
 		jobs = w.match(ctx, snap, reqs)
 	}
 	results := make([]result, len(jobs))
-	g, ctx := errgroup.WithContext(ctx)
+	// The group context is cancelled once Wait returns, so it must not leak
+	// into the status reporting below.
+	g, gctx := errgroup.WithContext(ctx)
 	poolSize := w.Cfg.BuildPoolSize
 	if w.buildPoolSize != nil && w.buildPoolSize.Load() > 0 {
 		poolSize = int(w.buildPoolSize.Load())
@@ -162,32 +189,40 @@ func (w *Worker) Drain(ctx context.Context) error {
 			defer w.activeBuilds.Add(-1)
 			attempt := reqAttempts[queueKey(job.Name, job.Version)]
 			if job.WheelAction == "reuse" && job.WheelDigest != "" {
-				if err := w.fetchWheel(ctx, job); err != nil {
+				if err := w.fetchWheel(gctx, job); err != nil {
 					return fmt.Errorf("fetch wheel %s: %w", job.WheelDigest, err)
 				}
 			}
-			logStream := w.openLogStream(ctx, job, attempt)
+			logStream := w.openLogStream(gctx, job, attempt)
 			if logStream != nil {
 				defer logStream.Close()
 				job.LogWriter = logStream
 			}
-			w.reportBuildStatus(ctx, job.Name, job.Version, "building", nil, "", attempt, 0, job.Recipes, nil)
-			dur, logContent, err := w.Runner.Run(ctx, job)
+			w.reportBuildStatus(gctx, job, "building", nil, "", "", attempt, 0, job.Recipes, nil, nil)
+			runCtx, stop := context.WithCancel(gctx)
+			cancelled := w.watchBuildCancel(runCtx, job, stop)
+			dur, logContent, err := w.Runner.Run(runCtx, job)
+			stop()
+			// A build that finished before the cancel landed keeps its result.
+			wasCancelled := err != nil && cancelled.Load()
+			if wasCancelled {
+				err = errBuildCancelled
+			}
 			if err != nil && strings.TrimSpace(logContent) == "" {
 				logContent = fmt.Sprintf("error: %s", err.Error())
 			}
 			repID := artifact.ID{}
 			results[i] = result{
-				job:      job,
-				duration: dur,
-				log:      logContent,
-				err:      err,
-				repair:   repID,
-				attempt:  attempt,
+				job:       job,
+				duration:  dur,
+				log:       logContent,
+				err:       err,
+				repair:    repID,
+				attempt:   attempt,
+				cancelled: wasCancelled,
 			}
 			if err == nil && w.Cfg.RepairPushEnabled && job.WheelDigest != "" && w.Pusher.BaseURL != "" {
-				repKey := artifact.RepairKey{InputWheelDigest: job.WheelDigest}
-				repID = artifact.ID{Type: artifact.RepairType, Digest: repKey.Digest()}
+				repID = artifact.ID{Type: artifact.RepairType, Digest: w.repairKey(job.WheelDigest).Digest()}
 				results[i].repair = repID
 			}
 			return nil
@@ -212,6 +247,17 @@ func (w *Worker) Drain(ctx context.Context) error {
 		}
 	}
 	for _, res := range results {
+		var up uploaded
+		var uploadErr error
+		if res.err == nil {
+			up, uploadErr = w.uploadArtifacts(ctx, res.job)
+			if uploadErr != nil {
+				// The artifacts were not published, so neither the build nor
+				// its repair may be reported as available.
+				res.err = uploadErr
+				res.repair = artifact.ID{}
+			}
+		}
 		status := "built"
 		meta := map[string]any{
 			"duration_ms": res.duration.Milliseconds(),
@@ -221,14 +267,38 @@ func (w *Worker) Drain(ctx context.Context) error {
 		recipesForStatus := res.job.Recipes
 		autoFix := autoFixResult{}
 		summary := ""
-		if res.err != nil {
+		category := ""
+		if res.cancelled {
+			// A cancelled build is neither retried nor auto-fixed.
+			status = "cancelled"
+			meta["error"] = res.err.Error()
+		} else if uploadErr != nil {
+			// Retries and hints rebuild the same mismatched source, so the
+			// build fails outright.
+			status = "failed"
+			summary = uploadErr.Error()
+			category = categoryUnknown
+			meta["error"] = summary
+			meta["failure_summary"] = summary
+			meta["failure_category"] = category
+			if firstErr == nil {
+				firstErr = uploadErr
+			}
+		} else if res.err != nil {
 			status = "failed"
 			meta["error"] = res.err.Error()
 			summary = summarizeLog(res.log)
+			var resErr *runner.ResourceError
+			if errors.As(res.err, &resErr) {
+				// A limit kill leaves no useful error in the log; say what to change.
+				summary = resErr.Error() + "; " + resErr.Suggestion()
+			}
 			if summary == "" {
 				summary = res.err.Error()
 			}
 			meta["failure_summary"] = summary
+			category = classifyFailure(res.log, res.err)
+			meta["failure_category"] = category
 			logForHints := res.log
 			if strings.TrimSpace(logForHints) == "" {
 				logForHints = summary
@@ -242,7 +312,7 @@ func (w *Worker) Drain(ctx context.Context) error {
 			}
 			if autoFix.Applied && res.attempt < w.Cfg.MaxRequeueAttempts {
 				status = "retry"
-			} else if w.shouldRequeue(reqAttempts, res.job) {
+			} else if w.shouldRequeue(reqAttempts, res) {
 				status = "retry"
 			}
 			if autoFix.Applied && status != "retry" {
@@ -257,7 +327,7 @@ func (w *Worker) Drain(ctx context.Context) error {
 		if len(recipesForStatus) > 0 {
 			meta["recipes"] = recipesForStatus
 		}
-		if autoFix.Applied || len(autoFix.HintIDs) > 0 || len(autoFix.SavedHintIDs) > 0 {
+		if autoFix.Applied || autoFix.Held || len(autoFix.HintIDs) > 0 || len(autoFix.SavedHintIDs) > 0 {
 			meta["automation"] = map[string]any{
 				"applied":        autoFix.Applied,
 				"recipes":        recipesForStatus,
@@ -268,22 +338,33 @@ func (w *Worker) Drain(ctx context.Context) error {
 				"blocked_hints":  autoFix.BlockedHints,
 				"impact":         autoFix.Impact,
 				"impact_reason":  autoFix.ImpactReason,
+				"held_recipes":   autoFix.HeldRecipes,
 			}
 		}
-		w.reportBuildStatus(ctx, res.job.Name, res.job.Version, status, res.err, summary, res.attempt, backoffUntil, recipesForStatus, autoFix.HintIDs)
+		w.reportBuildStatus(ctx, res.job, status, res.err, summary, category, res.attempt, backoffUntil, recipesForStatus, autoFix.HintIDs, autoFix.HeldRecipes)
 		if res.job.WheelDigest != "" {
 			meta["wheel_digest"] = res.job.WheelDigest
 			if res.job.WheelSourceDigest != "" {
 				meta["wheel_source_digest"] = res.job.WheelSourceDigest
 			}
-			if u := w.casURL(artifact.ID{Type: artifact.WheelType, Digest: res.job.WheelDigest}); u != "" {
-				meta["wheel_url"] = u
-			} else if res.job.WheelDigest != "" {
-				if u := w.objectURL(res.job, "wheel"); u != "" {
+			// After a failed upload nothing exists under the planned digest.
+			if uploadErr == nil {
+				if u := w.casURL(artifact.ID{Type: artifact.WheelType, Digest: res.job.WheelDigest}); u != "" {
+					meta["wheel_url"] = u
+				} else if u := w.objectURL(res.job, "wheel"); u != "" {
 					meta["wheel_url"] = u
 				}
 			}
 		}
+		if status == "built" {
+			// The wheel digest is a plan key, not a file hash; rebuilds
+			// compare against the bytes actually produced.
+			if path := w.wheelFileForJob(res.job); path != "" {
+				if d, err := fileDigest(path); err == nil {
+					meta["wheel_sha256"] = d
+				}
+			}
+		}
 		if res.job.WheelAction != "" {
 			meta["wheel_action"] = res.job.WheelAction
 		}
@@ -332,16 +413,42 @@ func (w *Worker) Drain(ctx context.Context) error {
 		if v, ok := meta["repair_digest"].(string); ok {
 			repairDigest = v
 		}
+		if up.sbom {
+			if u := w.objectURL(res.job, "sbom"); u != "" {
+				meta["sbom_url"] = u
+			}
+		}
+		if up.provenance {
+			if u := w.objectURL(res.job, "provenance"); u != "" {
+				meta["provenance_url"] = u
+			}
+		}
+		sbomURL := ""
+		if v, ok := meta["sbom_url"].(string); ok {
+			sbomURL = v
+		}
+		provenanceURL := ""
+		if v, ok := meta["provenance_url"].(string); ok {
+			provenanceURL = v
+		}
 		entry := map[string]any{
-			"name":          res.job.Name,
-			"version":       res.job.Version,
-			"status":        status,
-			"python_tag":    res.job.PythonTag,
-			"platform_tag":  res.job.PlatformTag,
-			"wheel":         wheelURL,
-			"repair_url":    repairURL,
-			"repair_digest": repairDigest,
-			"metadata":      meta,
+			"name":                res.job.Name,
+			"version":             res.job.Version,
+			"status":              status,
+			"python_tag":          res.job.PythonTag,
+			"platform_tag":        res.job.PlatformTag,
+			"wheel":               wheelURL,
+			"repair_url":          repairURL,
+			"repair_digest":       repairDigest,
+			"sbom_url":            sbomURL,
+			"provenance_url":      provenanceURL,
+			"plan_id":             res.job.PlanID,
+			"run_id":              res.job.RunID,
+			"builder_id":          w.builderID(),
+			"wheel_source_digest": res.job.WheelSourceDigest,
+			"repair_tool_version": res.job.RepairToolVersion,
+			"repair_policy_hash":  res.job.RepairPolicyHash,
+			"metadata":            meta,
 		}
 		manifestEntries = append(manifestEntries, entry)
 
@@ -352,6 +459,11 @@ func (w *Worker) Drain(ctx context.Context) error {
 			"duration_ms": res.duration.Milliseconds(),
 			"content":     res.log,
 		}
+		if content, dropped := truncateLogTail(res.log, w.Cfg.LogMaxBytes); dropped > 0 {
+			logPayload["content"] = content
+			logPayload["truncated"] = true
+			logPayload["truncated_bytes"] = dropped
+		}
 		if res.err != nil {
 			logPayload["error"] = res.err.Error()
 			if summary != "" {
@@ -370,10 +482,12 @@ func (w *Worker) Drain(ctx context.Context) error {
 			if res.job.WheelSourceDigest != "" {
 				logPayload["wheel_source_digest"] = res.job.WheelSourceDigest
 			}
-			if u := w.casURL(artifact.ID{Type: artifact.WheelType, Digest: res.job.WheelDigest}); u != "" {
-				logPayload["wheel_url"] = u
-			} else if u := w.objectURL(res.job, "wheel"); u != "" {
-				logPayload["wheel_url"] = u
+			if uploadErr == nil {
+				if u := w.casURL(artifact.ID{Type: artifact.WheelType, Digest: res.job.WheelDigest}); u != "" {
+					logPayload["wheel_url"] = u
+				} else if u := w.objectURL(res.job, "wheel"); u != "" {
+					logPayload["wheel_url"] = u
+				}
 			}
 		}
 		if res.job.WheelAction != "" {
@@ -442,35 +556,47 @@ func (w *Worker) Drain(ctx context.Context) error {
 				log.Printf("post event failed: %v", err)
 			}
 		}
-
-		if res.err == nil {
-			w.uploadArtifacts(ctx, res.job)
-		}
 	}
 
 	if len(manifestEntries) > 0 {
 		writeManifest(w.Cfg.OutputDir, manifestEntries)
 	}
-	return firstErr
+	// Every popped request has been handled (built, failed, or skipped), so
+	// its lease can be released.
+	if !usingBuildQueue {
+		if err := w.Queue.Ack(ctx, reqs); err != nil {
+			log.Printf("queue ack failed: %v", err)
+		}
+		settled = true
+	}
+	return len(reqs), firstErr
 }
 
-func (w *Worker) reportBuildStatus(ctx context.Context, pkg, version, status string, err error, summary string, attempts int, backoffUntil int64, recipes []string, hintIDs []string) {
+// reportBuildStatus posts the job's status to the control plane. The python
+// tag picks the job's row when a plan builds the version for several Pythons.
+func (w *Worker) reportBuildStatus(ctx context.Context, job runner.Job, status string, err error, summary, category string, attempts int, backoffUntil int64, recipes []string, hintIDs []string, heldRecipes []string) {
 	if w.Cfg.ControlPlaneURL == "" {
 		return
 	}
 	url := strings.TrimRight(w.Cfg.ControlPlaneURL, "/") + "/api/builds/status"
 	body := map[string]any{
-		"package":  pkg,
-		"version":  version,
+		"package":  job.Name,
+		"version":  job.Version,
 		"status":   status,
 		"attempts": attempts,
 	}
+	if job.PythonTag != "" {
+		body["python_tag"] = job.PythonTag
+	}
 	if err != nil {
 		body["error"] = err.Error()
 	}
 	if summary != "" {
 		body["failure_summary"] = summary
 	}
+	if category != "" {
+		body["failure_category"] = category
+	}
 	if backoffUntil > 0 {
 		body["backoff_until"] = backoffUntil
 	}
@@ -480,6 +606,9 @@ func (w *Worker) reportBuildStatus(ctx context.Context, pkg, version, status str
 	if len(hintIDs) > 0 {
 		body["hint_ids"] = hintIDs
 	}
+	if len(heldRecipes) > 0 {
+		body["held_recipes"] = heldRecipes
+	}
 	data, _ := json.Marshal(body)
 	req, reqErr := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
 	if reqErr != nil {
@@ -501,21 +630,45 @@ func (w *Worker) reportBuildStatus(ctx context.Context, pkg, version, status str
 	}
 }
 
-func (w *Worker) match(ctx context.Context, snap plan.Snapshot, reqs []queue.Request) []runner.Job {
-	packActions := map[string]string{}
-	packMeta := map[string]map[string]any{}
-	runtimeActions := map[string]string{}
-	runtimeMeta := map[string]map[string]any{}
-	for _, n := range snap.DAG {
+// dagArtifacts indexes a plan DAG's pack and runtime nodes by digest: the
+// actions, metadata, and pack inputs resolvePacks and fetchRuntime need to
+// build whatever the CAS does not have.
+type dagArtifacts struct {
+	packActions    map[string]string
+	packMeta       map[string]map[string]any
+	packInputs     map[string][]string
+	runtimeActions map[string]string
+	runtimeMeta    map[string]map[string]any
+}
+
+func indexDAGArtifacts(dag []plan.DAGNode) dagArtifacts {
+	a := dagArtifacts{
+		packActions:    map[string]string{},
+		packMeta:       map[string]map[string]any{},
+		packInputs:     map[string][]string{},
+		runtimeActions: map[string]string{},
+		runtimeMeta:    map[string]map[string]any{},
+	}
+	for _, n := range dag {
 		switch n.Type {
 		case plan.NodePack:
-			packActions[n.ID.Digest] = n.Action
-			packMeta[n.ID.Digest] = n.Metadata
+			a.packActions[n.ID.Digest] = n.Action
+			a.packMeta[n.ID.Digest] = n.Metadata
+			for _, in := range n.Inputs {
+				if in.Type == artifact.PackType {
+					a.packInputs[n.ID.Digest] = append(a.packInputs[n.ID.Digest], in.Digest)
+				}
+			}
 		case plan.NodeRuntime:
-			runtimeActions[n.ID.Digest] = n.Action
-			runtimeMeta[n.ID.Digest] = n.Metadata
+			a.runtimeActions[n.ID.Digest] = n.Action
+			a.runtimeMeta[n.ID.Digest] = n.Metadata
 		}
 	}
+	return a
+}
+
+func (w *Worker) match(ctx context.Context, snap plan.Snapshot, reqs []queue.Request) []runner.Job {
+	arts := indexDAGArtifacts(snap.DAG)
 
 	var jobs []runner.Job
 	for _, req := range reqs {
@@ -529,8 +682,17 @@ func (w *Worker) match(ctx context.Context, snap plan.Snapshot, reqs []queue.Req
 			if req.Version != "" && req.Version != "latest" && req.Version != node.Version {
 				continue
 			}
+			// A plan covering several Pythons has a node per tag; a leased
+			// build only runs its own.
+			if req.PythonTag != "" && node.PythonTag != "" && req.PythonTag != node.PythonTag {
+				continue
+			}
 			wheelDigest, wheelAction, packIDs, runtimeID := findWheelArtifact(snap.DAG, node, req)
-			orderedPacks := topoSortFromDag(packIDs, snap.DAG)
+			orderedPacks, err := topoSortFromDag(packIDs, snap.DAG)
+			if err != nil {
+				log.Printf("skip %s %s: %v", node.Name, node.Version, err)
+				continue
+			}
 			recipes := mergeRecipes(req.Recipes, recipeNames(node.Recipes))
 			jobs = append(jobs, runner.Job{
 				Name:              node.Name,
@@ -544,10 +706,15 @@ func (w *Worker) match(ctx context.Context, snap plan.Snapshot, reqs []queue.Req
 				WheelSourceDigest: findWheelSourceDigest(snap.DAG, wheelDigest),
 				RepairToolVersion: findRepairToolVersion(snap.DAG, wheelDigest),
 				RepairPolicyHash:  findRepairPolicyHash(snap.DAG, wheelDigest),
-				PackPaths:         w.resolvePacks(ctx, orderedPacks, packActions, packMeta),
-				RuntimePath:       w.fetchRuntime(ctx, firstNonEmpty(req.PythonVersion, node.PythonVersion), runtimeID, runtimeActions[runtimeID.Digest], runtimeMeta[runtimeID.Digest]),
+				PackPaths:         w.resolvePacks(ctx, orderedPacks, arts.packActions, arts.packMeta, arts.packInputs),
+				RuntimePath:       w.fetchRuntime(ctx, firstNonEmpty(req.PythonVersion, node.PythonVersion), runtimeID, arts.runtimeActions[runtimeID.Digest], arts.runtimeMeta[runtimeID.Digest]),
 				RuntimeDigest:     runtimeID.Digest,
 				PackDigests:       packDigests(orderedPacks),
+				PlanID:            req.PlanID,
+				RunID:             firstNonEmpty(req.RunID, snap.RunID),
+				Hashes:            node.Hashes,
+				MemoryMB:          node.MemoryMB,
+				CPUs:              node.CPUs,
 			})
 		}
 	}
@@ -566,6 +733,16 @@ func (w *Worker) jobsFromBuildQueue(ctx context.Context, reqs []queue.Request) (
 	var jobs []runner.Job
 	for planID, group := range grouped {
 		snap, err := w.fetchPlanSnapshot(ctx, planID)
+		if errors.Is(err, errPlanTampered) {
+			// Running would build the edited plan, and a retry fetches the
+			// same one, so the builds fail outright.
+			log.Printf("build queue plan id=%d: %v", planID, err)
+			for _, req := range group {
+				job := runner.Job{Name: req.Package, Version: req.Version, PythonTag: req.PythonTag, PlatformTag: req.PlatformTag, PlanID: planID}
+				w.reportBuildStatus(ctx, job, "failed", err, err.Error(), categoryUnknown, req.Attempts, 0, nil, nil, nil)
+			}
+			continue
+		}
 		if err != nil {
 			log.Printf("build queue plan fetch failed id=%d: %v", planID, err)
 			continue
@@ -613,7 +790,58 @@ func mergeRecipes(a, b []string) []string {
 	if len(out) == 0 {
 		return nil
 	}
-	return out
+	return sortRecipesByPriority(out)
+}
+
+// recipePrereqs ranks build prerequisites that other recipes depend on at
+// install time, mirroring sortPacksByPriority. Anything not listed keeps its
+// relative order after them; pip recipes go last so sdists can compile
+// against the system libraries installed before them.
+var recipePrereqs = map[string]int{
+	"pkg-config":      1,
+	"pkgconf":         1,
+	"build-essential": 2,
+	"make":            3,
+	"gcc":             4,
+	"g++":             5,
+	"gcc-c++":         5,
+	"cmake":           6,
+	"ninja-build":     7,
+	"rustc":           8,
+	"rust":            8,
+	"cargo":           9,
+}
+
+const (
+	recipeRankDefault = 100
+	recipeRankPip     = 200
+)
+
+func recipeRank(recipe string) int {
+	mgr, step, _ := strings.Cut(recipe, ":")
+	mgr = strings.ToLower(strings.TrimSpace(mgr))
+	if mgr == "pip" {
+		return recipeRankPip
+	}
+	if mgr != "apt" && mgr != "dnf" {
+		return recipeRankDefault
+	}
+	rank := recipeRankDefault
+	for _, tok := range strings.Fields(step) {
+		if r, ok := recipePrereqs[strings.ToLower(tok)]; ok && r < rank {
+			rank = r
+		}
+	}
+	return rank
+}
+
+// sortRecipesByPriority stable-sorts recipes so known prerequisites install
+// first and pip installs last, preserving the given order otherwise.
+func sortRecipesByPriority(recipes []string) []string {
+	sort.SliceStable(recipes, func(i, j int) bool {
+		return recipeRank(recipes[i]) < recipeRank(recipes[j])
+	})
+	return recipes
 }
 
 func findWheelArtifact(dag []plan.DAGNode, node plan.FlatNode, req queue.Request) (digest, action string, packIDs []artifact.ID, runtimeID artifact.ID) {
@@ -670,6 +898,16 @@ func findWheelSourceDigest(dag []plan.DAGNode, wheelDigest string) string {
 	return ""
 }
 
+// repairKey is the CAS key of the repaired form of a wheel. The planner
+// builds the same key from REPAIR_TOOL_VERSION and REPAIR_POLICY_HASH.
+func (w *Worker) repairKey(wheelDigest string) artifact.RepairKey {
+	return artifact.RepairKey{
+		InputWheelDigest:  wheelDigest,
+		RepairToolVersion: w.Cfg.RepairToolVersion,
+		PolicyRulesDigest: w.Cfg.RepairPolicyHash,
+	}
+}
+
 func findRepairToolVersion(dag []plan.DAGNode, wheelDigest string) string {
 	for _, n := range dag {
 		if n.Type != plan.NodeRepair {
@@ -711,15 +949,31 @@ func (w *Worker) casURL(id artifact.ID) string {
 	return fmt.Sprintf("%s/v2/%s/blobs/%s", strings.TrimRight(w.Cfg.CASRegistryURL, "/"), strings.Trim(repo, "/"), id.Digest)
 }
 
+// repairFile names the repaired wheel stored next to the build's artifacts.
+func repairFile(job runner.Job) string {
+	return fmt.Sprintf("repair-%s.whl", job.WheelDigest)
+}
+
 func (w *Worker) objectURL(job runner.Job, kind string) string {
 	if w.Store == nil {
 		return ""
 	}
-	base := fmt.Sprintf("%s/%s/", strings.ToLower(job.Name), job.Version)
-	key := base
-	if kind == "repair" && job.WheelDigest != "" {
-		key = fmt.Sprintf("%srepair-%s", base, job.WheelDigest)
+	file := ""
+	switch kind {
+	case "wheel":
+		if path := w.wheelFileForJob(job); path != "" {
+			file = filepath.Base(path)
+		}
+	case "repair":
+		if job.WheelDigest != "" {
+			file = repairFile(job)
+		}
+	case "sbom":
+		file = buildFile(job, sbomFile)
+	case "provenance":
+		file = buildFile(job, provenanceFile)
 	}
+	key := w.objectKey(job, file)
 	if os, ok := w.Store.(interface{ URL(string) string }); ok {
 		return os.URL(key)
 	}
@@ -777,44 +1031,55 @@ func pyTagFromVersion(ver string) string {
 	return trimmed
 }
 
-func pyVersionFromTag(tag string) string {
-	tag = strings.TrimPrefix(tag, "cp")
-	if len(tag) == 3 {
-		return fmt.Sprintf("%s.%s", tag[:1], tag[1:])
-	}
-	if len(tag) == 4 {
-		return fmt.Sprintf("%s.%s", tag[:2], tag[2:])
-	}
-	return ""
-}
-
 // BuildWorker constructs a worker from config.
 func BuildWorker(cfg Config) (*Worker, error) {
+	if cfg.PlatformTag != "" {
+		if _, err := platform.Parse(cfg.PlatformTag); err != nil {
+			return nil, fmt.Errorf("PLATFORM_TAG: %w", err)
+		}
+	}
 	if cfg.BuildPopURL == "" && cfg.ControlPlaneURL != "" {
 		cfg.BuildPopURL = strings.TrimRight(cfg.ControlPlaneURL, "/") + "/api/build-queue/pop"
 	}
 	var q queue.Backend
+	if err := queue.ValidateBackend(cfg.QueueBackend); err != nil {
+		return nil, err
+	}
+	if err := validateObjectKeyTemplate(cfg.ObjectKeyTemplate); err != nil {
+		return nil, err
+	}
 	switch cfg.QueueBackend {
 	case "redis":
-		q = queue.NewRedisQueue(cfg.RedisURL, cfg.RedisKey)
+		rq := queue.NewRedisQueue(cfg.RedisURL, cfg.RedisKey)
+		rq.VisibilityTimeout = time.Duration(cfg.QueueVisibilitySec) * time.Second
+		q = rq
 	case "kafka":
-		q = queue.NewKafkaQueue(cfg.KafkaBrokers, cfg.KafkaTopic)
+		q = queue.NewKafkaQueue(cfg.KafkaBrokers, cfg.KafkaTopic, cfg.KafkaGroupID, cfg.KafkaPartitions)
 	default:
-		q = queue.NewFileQueue(cfg.QueueFile)
+		fq := queue.NewFileQueue(cfg.QueueFile)
+		fq.VisibilityTimeout = time.Duration(cfg.QueueVisibilitySec) * time.Second
+		q = fq
 	}
 	if q == nil {
 		return nil, errors.New("queue backend not configured")
 	}
 	r := &runner.PodmanRunner{
-		Image:       cfg.ContainerImage,
-		InputDir:    cfg.InputDir,
-		OutputDir:   cfg.OutputDir,
-		CacheDir:    cfg.CacheDir,
-		PythonTag:   cfg.PythonVersion,
-		PlatformTag: cfg.PlatformTag,
-		Bin:         cfg.PodmanBin,
-		Timeout:     time.Duration(cfg.RunnerTimeoutSec) * time.Second,
-		RunCmd:      cfg.RunCmd,
+		Image:         cfg.ContainerImage,
+		InputDir:      cfg.InputDir,
+		OutputDir:     cfg.OutputDir,
+		CacheDir:      cfg.CacheDir,
+		CacheStrategy: cfg.CacheStrategy,
+		PythonTag:     cfg.PythonVersion,
+		PlatformTag:   cfg.PlatformTag,
+		Bin:           cfg.PodmanBin,
+		Timeout:       time.Duration(cfg.RunnerTimeoutSec) * time.Second,
+		RunCmd:        cfg.RunCmd,
+		NoNetwork:     cfg.RunnerNoNetwork,
+		ReadOnlyRoot:  cfg.RunnerReadOnly,
+		CapDrop:       cfg.RunnerCapDrop,
+		User:          cfg.RunnerUser,
+		Memory:        cfg.RunnerMemory,
+		CPUs:          cfg.RunnerCPUs,
 	}
 	rep := &reporter.Client{BaseURL: strings.TrimRight(cfg.ControlPlaneURL, "/"), Token: cfg.ControlPlaneToken}
 	return &Worker{
@@ -842,15 +1107,32 @@ func BuildWorker(cfg Config) (*Worker, error) {
 	}, nil
 }
 
+// pingQueue checks that a networked queue backend is reachable, so a bad
+// REDIS_URL or KAFKA_BROKERS fails at startup rather than on the first drain.
+func pingQueue(ctx context.Context, q queue.Backend) error {
+	p, ok := q.(queue.Pinger)
+	if !ok {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := p.Ping(ctx); err != nil {
+		return fmt.Errorf("queue backend unreachable: %w", err)
+	}
+	return nil
+}
+
 func queueKey(name, version string) string {
 	return strings.ToLower(name) + "::" + strings.ToLower(version)
 }
 
-func (w *Worker) shouldRequeue(reqAttempts map[string]int, job runner.Job) bool {
-	if !w.Cfg.RequeueOnFailure {
+// shouldRequeue reports whether a failed build goes back on the queue.
+// Cancelled builds never do.
+func (w *Worker) shouldRequeue(reqAttempts map[string]int, res result) bool {
+	if res.cancelled || !w.Cfg.RequeueOnFailure {
 		return false
 	}
-	key := queueKey(job.Name, job.Version)
+	key := queueKey(res.job.Name, res.job.Version)
 	attempt := reqAttempts[key]
 	if attempt >= w.Cfg.MaxRequeueAttempts {
 		return false
@@ -911,7 +1193,7 @@ func (w *Worker) popBuildQueue(ctx context.Context) ([]queue.Request, error) {
 			Package:       b.Package,
 			Version:       b.Version,
 			PythonTag:     b.PythonTag,
-			PythonVersion: pyVersionFromTag(b.PythonTag),
+			PythonVersion: plan.PythonVersionFromTag(b.PythonTag),
 			PlatformTag:   b.PlatformTag,
 			Attempts:      b.Attempts,
 			RunID:         b.RunID,
@@ -922,6 +1204,10 @@ func (w *Worker) popBuildQueue(ctx context.Context) ([]queue.Request, error) {
 	return out, nil
 }
 
+// errPlanTampered reports a plan the control plane flagged as edited after it
+// was saved. Its builds must not run.
+var errPlanTampered = errors.New("plan failed integrity check")
+
 func (w *Worker) fetchPlanSnapshot(ctx context.Context, planID int64) (plan.Snapshot, error) {
 	if w.Cfg.ControlPlaneURL == "" {
 		return plan.Snapshot{}, fmt.Errorf("control plane URL not set")
@@ -945,13 +1231,17 @@ func (w *Worker) fetchPlanSnapshot(ctx context.Context, planID int64) (plan.Snap
 		return plan.Snapshot{}, fmt.Errorf("plan fetch status %d: %s", resp.StatusCode, string(b))
 	}
 	var payload struct {
-		RunID string          `json:"run_id"`
-		Plan  []plan.FlatNode `json:"plan"`
-		DAG   []plan.DAGNode  `json:"dag,omitempty"`
+		RunID    string          `json:"run_id"`
+		Plan     []plan.FlatNode `json:"plan"`
+		DAG      []plan.DAGNode  `json:"dag,omitempty"`
+		Tampered bool            `json:"tampered,omitempty"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
 		return plan.Snapshot{}, err
 	}
+	if payload.Tampered {
+		return plan.Snapshot{}, fmt.Errorf("plan %d: %w", planID, errPlanTampered)
+	}
 	return plan.Snapshot{RunID: payload.RunID, Plan: payload.Plan, DAG: payload.DAG}, nil
 }
 
@@ -982,28 +1272,42 @@ func writeManifest(outputDir string, manifest any) {
 	_ = os.WriteFile(path, data, 0o644)
 }
 
-// RunOnce is used by trigger handler.
-func (w *Worker) RunOnce(ctx context.Context) error {
-	return w.Drain(ctx)
+// RunOnce is used by the trigger handler and build loop. It returns how many
+// requests were popped.
+func (w *Worker) RunOnce(ctx context.Context) (int, error) {
+	return w.drain(ctx)
+}
+
+// uploaded records which per-build objects uploadArtifacts wrote, so the
+// manifest only links objects that exist.
+type uploaded struct {
+	sbom       bool
+	provenance bool
 }
 
 // uploadArtifacts pushes built wheel files to object storage (best effort).
-func (w *Worker) uploadArtifacts(ctx context.Context, job runner.Job) {
+// It refuses to publish anything for a job whose source no longer matches
+// the planned wheel key and returns that mismatch.
+func (w *Worker) uploadArtifacts(ctx context.Context, job runner.Job) (uploaded, error) {
+	var up uploaded
+	if err := verifyWheelSource(job); err != nil {
+		log.Printf("abort upload for %s %s: %v", job.Name, job.Version, err)
+		return up, err
+	}
 	store := w.Store
 	if store == nil {
-		return
+		return up, nil
 	}
 	entries, err := os.ReadDir(w.Cfg.OutputDir)
 	if err != nil {
-		return
+		return up, nil
 	}
 	// Pack publish is not tied to specific files; packs are metadata-only here.
 	for _, e := range entries {
 		if e.IsDir() || !strings.HasSuffix(e.Name(), ".whl") {
 			continue
 		}
-		// crude match: wheel filename contains package name
-		if !strings.Contains(strings.ToLower(e.Name()), strings.ToLower(job.Name)) {
+		if !wheelForJob(e.Name(), job) {
 			continue
 		}
 		path := filepath.Join(w.Cfg.OutputDir, e.Name())
@@ -1017,11 +1321,17 @@ func (w *Worker) uploadArtifacts(ctx context.Context, job runner.Job) {
 				continue
 			}
 		}
-		key := fmt.Sprintf("%s/%s/%s", strings.ToLower(job.Name), job.Version, e.Name())
+		key := w.objectKey(job, e.Name())
 		_ = store.Put(ctx, key, data, "application/octet-stream")
 		if w.Cfg.CASPushEnabled && w.Pusher.BaseURL != "" && job.WheelDigest != "" {
 			_, _ = w.Pusher.Push(ctx, artifact.ID{Type: artifact.WheelType, Digest: job.WheelDigest}, data, "application/octet-stream")
 		}
+		if w.publishSBOM(ctx, job, e.Name(), data) {
+			up.sbom = true
+		}
+		if w.publishProvenance(ctx, job, e.Name(), data) {
+			up.provenance = true
+		}
 	}
 	if w.Cfg.RepairPushEnabled && w.Pusher.BaseURL != "" && job.WheelDigest != "" {
 		repPath := filepath.Join(w.Cfg.OutputDir, fmt.Sprintf("%s-%s-repair.whl", job.Name, job.Version))
@@ -1037,17 +1347,13 @@ func (w *Worker) uploadArtifacts(ctx context.Context, job runner.Job) {
 			}
 		}
 		if len(repData) > 0 {
-			repKey := artifact.RepairKey{
-				InputWheelDigest:  job.WheelDigest,
-				RepairToolVersion: w.Cfg.RepairToolVersion,
-				PolicyRulesDigest: w.Cfg.RepairPolicyHash,
-			}
+			repKey := w.repairKey(job.WheelDigest)
 			if ok, err := verifyBytesDigest(repData, repKey.Digest()); err == nil && !ok {
 				log.Printf("skip CAS push for repair: digest mismatch %s", repKey.Digest())
 			} else {
 				_, _ = w.Pusher.Push(ctx, artifact.ID{Type: artifact.RepairType, Digest: repKey.Digest()}, repData, "application/octet-stream")
 				if store != nil {
-					repairKey := fmt.Sprintf("%s/%s/repair-%s.whl", strings.ToLower(job.Name), job.Version, job.WheelDigest)
+					repairKey := w.objectKey(job, repairFile(job))
 					_ = store.Put(ctx, repairKey, repData, "application/octet-stream")
 				}
 			}
@@ -1076,16 +1382,17 @@ func (w *Worker) uploadArtifacts(ctx context.Context, job runner.Job) {
 			if data, err := os.ReadFile(job.RuntimePath); err == nil {
 				if ok, err := verifyBytesDigest(data, job.RuntimeDigest); err == nil && !ok {
 					log.Printf("skip CAS push for runtime: digest mismatch %s", job.RuntimeDigest)
-					return
+					return up, nil
 				}
 				_, _ = w.Pusher.Push(ctx, artifact.ID{Type: artifact.RuntimeType, Digest: job.RuntimeDigest}, data, "application/octet-stream")
-				return
+				return up, nil
 			}
 		}
 		if stub, err := w.stubPayload("runtime", job.RuntimeDigest, nil); err == nil {
 			_, _ = w.Pusher.Push(ctx, artifact.ID{Type: artifact.RuntimeType, Digest: job.RuntimeDigest}, stub, "application/octet-stream")
 		}
 	}
+	return up, nil
 }
 
 func (w *Worker) stubPayload(kind, digest string, meta map[string]any) ([]byte, error) {
@@ -1108,13 +1415,38 @@ func (w *Worker) wheelFileForJob(job runner.Job) string {
 		if e.IsDir() || !strings.HasSuffix(e.Name(), ".whl") {
 			continue
 		}
-		if strings.Contains(strings.ToLower(e.Name()), strings.ToLower(job.Name)) {
+		if wheelForJob(e.Name(), job) {
 			return filepath.Join(w.Cfg.OutputDir, e.Name())
 		}
 	}
 	return ""
 }
 
+// wheelForJob reports whether a wheel in the output dir belongs to job. On
+// top of the crude name match, a job with a python tag skips CPython wheels
+// built for another interpreter, so builds of one version for several
+// Pythons do not publish each other's wheels. Pure and abi3 wheels match any
+// tag.
+func wheelForJob(filename string, job runner.Job) bool {
+	if !strings.Contains(strings.ToLower(filename), strings.ToLower(job.Name)) {
+		return false
+	}
+	parts := strings.Split(strings.TrimSuffix(filename, ".whl"), "-")
+	if job.PythonTag == "" || len(parts) < 5 {
+		return true
+	}
+	pyTags, abi := parts[len(parts)-3], parts[len(parts)-2]
+	if abi == "abi3" || abi == "none" {
+		return true
+	}
+	for _, tag := range strings.Split(pyTags, ".") {
+		if tag == job.PythonTag {
+			return true
+		}
+	}
+	return false
+}
+
 func copyFile(src, dst string) error {
 	in, err := os.Open(src)
 	if err != nil {
@@ -1221,6 +1553,30 @@ func verifyFileDigest(path, expected string) (bool, error) {
 	return verifyBytesDigest(data, expected)
 }
 
+// verifyWheelSource checks that the plan keyed the job's wheel on the source
+// the job actually builds. On a mismatch the wheel must not reach the CAS
+// under the planned digest. Jobs without a planned source digest, such as
+// rebuilds of uploaded wheels, are not checked.
+func verifyWheelSource(job runner.Job) error {
+	if job.WheelSourceDigest == "" {
+		return nil
+	}
+	if want := plan.SourceDigest(job.Name, job.Version); want != job.WheelSourceDigest {
+		return fmt.Errorf("wheel source digest mismatch: plan has %s, %s %s is %s", job.WheelSourceDigest, job.Name, job.Version, want)
+	}
+	return nil
+}
+
+// fileDigest returns the sha256 of a file's contents as "sha256:<hex>".
+func fileDigest(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
 func verifyBytesDigest(data []byte, expected string) (bool, error) {
 	if expected == "" || !strings.HasPrefix(expected, "sha256:") {
 		return true, nil
@@ -1283,11 +1639,29 @@ func (w *Worker) fetchWheel(ctx context.Context, job runner.Job) error {
 	return nil
 }
 
-func (w *Worker) resolvePacks(ctx context.Context, ids []artifact.ID, actions map[string]string, meta map[string]map[string]any) []string {
+// resolvePacks returns the extracted directory of every pack in ids, fetching
+// or building the ones not yet in packPath. packMu is held for the whole call:
+// it guards packPath, and it keeps concurrent builds with overlapping packs
+// from fetching or extracting the same archive twice. Fetches within one call
+// still run in parallel.
+func (w *Worker) resolvePacks(ctx context.Context, ids []artifact.ID, actions map[string]string, meta map[string]map[string]any, inputs map[string][]string) []string {
 	if len(ids) == 0 {
 		return nil
 	}
+	w.packMu.Lock()
+	defer w.packMu.Unlock()
+	if w.packPath == nil {
+		w.packPath = make(map[string]string)
+	}
 	ids = sortPacksByPriority(ids, meta)
+	destDir := w.Cfg.LocalCASDir
+	if destDir == "" {
+		destDir = filepath.Join(w.Cfg.CacheDir, "cas")
+	}
+	if err := os.MkdirAll(destDir, 0o755); err != nil {
+		return nil
+	}
+	fetchedPacks := w.fetchPacks(ctx, ids, inputs, destDir)
 	var paths []string
 	var depsBuilt []string
 	for _, id := range ids {
@@ -1298,25 +1672,9 @@ func (w *Worker) resolvePacks(ctx context.Context, ids []artifact.ID, actions ma
 			paths = append(paths, p)
 			continue
 		}
-		destDir := w.Cfg.LocalCASDir
-		if destDir == "" {
-			destDir = filepath.Join(w.Cfg.CacheDir, "cas")
-		}
-		if err := os.MkdirAll(destDir, 0o755); err != nil {
-			continue
-		}
-		destPath := filepath.Join(destDir, strings.ReplaceAll(id.Digest, ":", "_")+".tar")
+		destPath := packArchivePath(destDir, id)
 		extractDir := filepath.Join(destDir, strings.ReplaceAll(id.Digest, ":", "_"))
-		fetched := false
-		if w.Fetcher.BaseURL != "" {
-			if err := w.Fetcher.Fetch(ctx, id, destPath); err == nil {
-				if _, err := os.Stat(destPath); err == nil {
-					if ok, err := verifyFileDigest(destPath, id.Digest); err == nil && ok {
-						fetched = true
-					}
-				}
-			}
-		}
+		fetched := fetchedPacks[id.Digest]
 		if !fetched && actions[id.Digest] == "build" {
 			cmd := w.Cfg.PackBuilderCmd
 			if cmd == "" {
@@ -1354,6 +1712,68 @@ func (w *Worker) resolvePacks(ctx context.Context, ids []artifact.ID, actions ma
 	return paths
 }
 
+func packArchivePath(destDir string, id artifact.ID) string {
+	return filepath.Join(destDir, strings.ReplaceAll(id.Digest, ":", "_")+".tar")
+}
+
+// fetchPacks downloads pack archives from the CAS concurrently, bounded by
+// PackFetchConcurrency. A pack starts only after the fetches of its input
+// packs have finished, so downloads follow dependency order; packs that
+// cannot be fetched are left for resolvePacks to build sequentially. Callers
+// must hold packMu.
+func (w *Worker) fetchPacks(ctx context.Context, ids []artifact.ID, inputs map[string][]string, destDir string) map[string]bool {
+	if w.Fetcher.BaseURL == "" {
+		return nil
+	}
+	limit := w.Cfg.PackFetchConcurrency
+	if limit <= 0 {
+		limit = 4
+	}
+	done := make(map[string]chan struct{}, len(ids))
+	for _, id := range ids {
+		done[id.Digest] = make(chan struct{})
+	}
+	sem := make(chan struct{}, limit)
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		fetched = make(map[string]bool)
+	)
+	for _, id := range ids {
+		if _, cached := w.packPath[id.Digest]; cached || id.Type != artifact.PackType {
+			close(done[id.Digest])
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done[id.Digest])
+			for _, in := range inputs[id.Digest] {
+				if ch, ok := done[in]; ok && in != id.Digest {
+					select {
+					case <-ch:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			destPath := packArchivePath(destDir, id)
+			if err := w.Fetcher.Fetch(ctx, id, destPath); err != nil {
+				return
+			}
+			if ok, err := verifyFileDigest(destPath, id.Digest); err == nil && ok {
+				mu.Lock()
+				fetched[id.Digest] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return fetched
+}
+
 func (w *Worker) fetchRuntime(ctx context.Context, pythonVersion string, rtID artifact.ID, action string, meta map[string]any) string {
 	if pythonVersion == "" || rtID.Digest == "" {
 		return ""
@@ -1489,7 +1909,8 @@ func isManifestOnly(dir string) bool {
 }
 
 // topoSortFromDag orders pack IDs using DAG edges (dependencies first).
-func topoSortFromDag(targets []artifact.ID, dag []plan.DAGNode) []artifact.ID {
+// It returns an error naming the packs involved if the edges form a cycle.
+func topoSortFromDag(targets []artifact.ID, dag []plan.DAGNode) ([]artifact.ID, error) {
 	idSet := make(map[string]struct{})
 	for _, t := range targets {
 		idSet[t.Digest] = struct{}{}
@@ -1501,28 +1922,58 @@ func topoSortFromDag(targets []artifact.ID, dag []plan.DAGNode) []artifact.ID {
 		}
 		nodeByDigest[n.ID.Digest] = n
 	}
+	packLabel := func(d string) string {
+		if name, ok := nodeByDigest[d].Metadata["name"].(string); ok && name != "" {
+			return name
+		}
+		return d
+	}
 	visited := make(map[string]bool)
+	visiting := make(map[string]bool)
+	var path []string
 	var ordered []artifact.ID
-	var visit func(string)
-	visit = func(d string) {
+	var visit func(string) error
+	visit = func(d string) error {
 		if visited[d] {
-			return
+			return nil
 		}
-		visited[d] = true
-		n, ok := nodeByDigest[d]
-		if ok {
+		if visiting[d] {
+			start := 0
+			for i, p := range path {
+				if p == d {
+					start = i
+					break
+				}
+			}
+			var names []string
+			for _, p := range append(path[start:], d) {
+				names = append(names, packLabel(p))
+			}
+			return fmt.Errorf("pack dependency cycle: %s", strings.Join(names, " -> "))
+		}
+		visiting[d] = true
+		path = append(path, d)
+		if n, ok := nodeByDigest[d]; ok {
 			for _, inp := range n.Inputs {
 				if inp.Type == artifact.PackType {
-					visit(inp.Digest)
+					if err := visit(inp.Digest); err != nil {
+						return err
+					}
 				}
 			}
 		}
+		path = path[:len(path)-1]
+		delete(visiting, d)
+		visited[d] = true
 		if _, ok := idSet[d]; ok {
 			ordered = append(ordered, artifact.ID{Type: artifact.PackType, Digest: d})
 		}
+		return nil
 	}
-	for d := range idSet {
-		visit(d)
+	for _, t := range targets {
+		if err := visit(t.Digest); err != nil {
+			return nil, err
+		}
 	}
-	return ordered
+	return ordered, nil
 }
@@ -6,27 +6,39 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/artifact"
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/cas"
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/pack"
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/plan"
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/queue"
+	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/reporter"
 	"github.com/k8ika0s/s390x-wheel-refinery/go-worker/internal/runner"
 )
 
 type fakeStore struct {
 	keys []string
+	// failSuffix makes Put fail for keys ending in it.
+	failSuffix string
 }
 
 func (f *fakeStore) Put(_ context.Context, key string, _ []byte, _ string) error {
+	if f.failSuffix != "" && strings.HasSuffix(key, f.failSuffix) {
+		return errors.New("put failed")
+	}
 	f.keys = append(f.keys, key)
 	return nil
 }
@@ -59,12 +71,251 @@ func TestUploadArtifactsFiltersWheels(t *testing.T) {
 		Store: fs,
 	}
 	w.uploadArtifacts(context.Background(), runner.Job{Name: "demo", Version: "1.0.0"})
-	if len(fs.keys) != 1 {
-		t.Fatalf("expected 1 upload, got %d", len(fs.keys))
+	if len(fs.keys) != 3 {
+		t.Fatalf("expected wheel, sbom, and provenance uploads, got %v", fs.keys)
 	}
 	if fs.keys[0] != "demo/1.0.0/demo-1.0.0-py3-none-any.whl" {
 		t.Fatalf("unexpected key: %s", fs.keys[0])
 	}
+	if fs.keys[1] != "demo/1.0.0/sbom.cdx.json" {
+		t.Fatalf("unexpected sbom key: %s", fs.keys[1])
+	}
+	if fs.keys[2] != "demo/1.0.0/provenance.json" {
+		t.Fatalf("unexpected provenance key: %s", fs.keys[2])
+	}
+}
+
+func TestUploadArtifactsSkipsCASPushOnSourceDigestMismatch(t *testing.T) {
+	output := t.TempDir()
+	wheel := "demo-1.0.0-cp311-cp311-manylinux2014_s390x.whl"
+	if err := os.WriteFile(filepath.Join(output, wheel), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var pushes int
+	registry := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		pushes++
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer registry.Close()
+	sum := sha256.Sum256([]byte("data"))
+	job := runner.Job{
+		Name:              "demo",
+		Version:           "1.0.0",
+		WheelDigest:       "sha256:" + hex.EncodeToString(sum[:]),
+		WheelSourceDigest: plan.SourceDigest("demo", "0.9.0"),
+	}
+	fs := &fakeStore{}
+	w := &Worker{Cfg: Config{OutputDir: output, CASPushEnabled: true}, Store: fs, Pusher: cas.Pusher{BaseURL: registry.URL}}
+
+	_, err := w.uploadArtifacts(context.Background(), job)
+	if err == nil || !strings.Contains(err.Error(), "wheel source digest mismatch") {
+		t.Fatalf("expected source digest mismatch, got %v", err)
+	}
+	if pushes != 0 || len(fs.keys) != 0 {
+		t.Fatalf("expected nothing published on source mismatch, got %d pushes and keys %v", pushes, fs.keys)
+	}
+
+	job.WheelSourceDigest = plan.SourceDigest("demo", "1.0.0")
+	if _, err := w.uploadArtifacts(context.Background(), job); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if pushes == 0 {
+		t.Fatalf("expected CAS push when the source digest matches")
+	}
+}
+
+func TestCatalogLimitsReachPodmanArgs(t *testing.T) {
+	dir := t.TempDir()
+	catalog := &pack.Catalog{Rules: []pack.Rule{{PackagePattern: "demo", MemoryMB: 4096, CPUs: 1.5}}}
+	snap, err := plan.GenerateFromInputs(plan.InputSet{Requirements: []plan.DepSpec{{Name: "demo", Version: "0.3"}}}, dir, "3.11", "manylinux2014_s390x", "", "", "", "", "", "", nil, catalog, nil, "", "", false, nil, 0, "")
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	w := &Worker{Cfg: Config{OutputDir: dir, CacheDir: dir}, packPath: make(map[string]string)}
+	jobs := w.match(context.Background(), snap, []queue.Request{{Package: "demo", Version: "0.3"}})
+	if len(jobs) != 1 {
+		t.Fatalf("expected one job, got %d", len(jobs))
+	}
+
+	bin := filepath.Join(t.TempDir(), "podman")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\nprintf '%s\\n' \"$@\"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	r := &runner.PodmanRunner{Bin: bin, OutputDir: dir, CacheDir: dir, Memory: "2g", CPUs: "2", RunCmd: []string{"true"}}
+	_, out, err := r.Run(context.Background(), jobs[0])
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	args := strings.Split(out, "\n")
+	has := func(want string) bool {
+		for _, a := range args {
+			if a == want {
+				return true
+			}
+		}
+		return false
+	}
+	if !has("--memory=4096m") || !has("--cpus=1.5") || has("--memory=2g") {
+		t.Fatalf("expected the catalog limits in podman args, got %q", out)
+	}
+}
+
+func TestDrainFailsBuildOnSourceDigestMismatch(t *testing.T) {
+	dir := t.TempDir()
+	snap, err := plan.GenerateFromInputs(plan.InputSet{Requirements: []plan.DepSpec{{Name: "demo", Version: "0.3"}}}, dir, "3.11", "manylinux2014_s390x", "", "", "", "", "", "", nil, nil, nil, "", "", false, nil, 0, "")
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	// Key the planned wheel on another version's source.
+	for _, n := range snap.DAG {
+		if n.Type == plan.NodeWheel {
+			n.Metadata["source_digest"] = plan.SourceDigest("demo", "0.2")
+		}
+	}
+	if err := plan.Write(filepath.Join(dir, "plan.json"), snap); err != nil {
+		t.Fatalf("write plan: %v", err)
+	}
+	var mu sync.Mutex
+	var statuses []map[string]any
+	cp := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/api/builds/status" {
+			var body map[string]any
+			_ = json.NewDecoder(req.Body).Decode(&body)
+			mu.Lock()
+			statuses = append(statuses, body)
+			mu.Unlock()
+		}
+	}))
+	defer cp.Close()
+	var pushes int
+	registry := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		pushes++
+		rw.WriteHeader(http.StatusCreated)
+	}))
+	defer registry.Close()
+
+	w := &Worker{
+		Cfg: Config{
+			OutputDir:         dir,
+			CacheDir:          dir,
+			ControlPlaneURL:   cp.URL,
+			CASPushEnabled:    true,
+			RepairPushEnabled: true,
+		},
+		Queue:    &stubQueue{reqs: []queue.Request{{Package: "demo", Version: "0.3"}}},
+		Runner:   &logRunner{log: "ok"},
+		Store:    &fakeStore{},
+		Pusher:   cas.Pusher{BaseURL: registry.URL},
+		packPath: make(map[string]string),
+	}
+	err = w.Drain(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "wheel source digest mismatch") {
+		t.Fatalf("expected drain to surface the mismatch, got %v", err)
+	}
+	if pushes != 0 {
+		t.Fatalf("expected no CAS push, got %d", pushes)
+	}
+	mu.Lock()
+	last := statuses[len(statuses)-1]
+	mu.Unlock()
+	if last["status"] != "failed" || !strings.Contains(fmt.Sprint(last["error"]), "wheel source digest mismatch") {
+		t.Fatalf("expected build reported failed with the mismatch, got %v", last)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		t.Fatalf("manifest not written: %v", err)
+	}
+	var entries []map[string]any
+	if err := json.Unmarshal(data, &entries); err != nil || len(entries) != 1 {
+		t.Fatalf("unexpected manifest %s: %v", data, err)
+	}
+	meta, _ := entries[0]["metadata"].(map[string]any)
+	if entries[0]["status"] != "failed" || entries[0]["wheel"] != "" || entries[0]["repair_digest"] != "" || meta["wheel_url"] != nil {
+		t.Fatalf("expected a failed manifest entry without artifact links, got %v", entries[0])
+	}
+}
+
+func TestUploadArtifactsWritesProvenance(t *testing.T) {
+	output := t.TempDir()
+	wheel := "demo-1.0.0-cp311-cp311-manylinux2014_s390x.whl"
+	if err := os.WriteFile(filepath.Join(output, wheel), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sourceDigest := plan.SourceDigest("demo", "1.0.0")
+	w := &Worker{Cfg: Config{OutputDir: output, WorkerID: "worker-1"}, Store: &fakeStore{}}
+	w.uploadArtifacts(context.Background(), runner.Job{
+		Name:              "demo",
+		Version:           "1.0.0",
+		WheelSourceDigest: sourceDigest,
+		PlanID:            42,
+		RunID:             "run-7",
+	})
+	data, err := os.ReadFile(filepath.Join(output, wheel+".provenance.json"))
+	if err != nil {
+		t.Fatalf("provenance not written: %v", err)
+	}
+	var st provenanceStatement
+	if err := json.Unmarshal(data, &st); err != nil {
+		t.Fatalf("decode provenance: %v", err)
+	}
+	def := st.Predicate.BuildDefinition
+	if planID, _ := def.ExternalParameters["plan_id"].(float64); planID != 42 {
+		t.Fatalf("expected plan_id 42, got %v", def.ExternalParameters["plan_id"])
+	}
+	if len(def.ResolvedDependencies) != 1 || def.ResolvedDependencies[0].Name != "source" || "sha256:"+def.ResolvedDependencies[0].Digest["sha256"] != sourceDigest {
+		t.Fatalf("source digest missing from provenance: %+v", def.ResolvedDependencies)
+	}
+	if st.Predicate.RunDetails.Builder.ID != "worker-1" || st.Predicate.RunDetails.Metadata.InvocationID != "run-7" {
+		t.Fatalf("unexpected run details: %+v", st.Predicate.RunDetails)
+	}
+}
+
+func TestUploadArtifactsWritesSBOM(t *testing.T) {
+	output := t.TempDir()
+	wheel := "demo-1.0.0-cp311-cp311-manylinux2014_s390x.whl"
+	if err := os.WriteFile(filepath.Join(output, wheel), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runtimeDigest := "sha256:" + strings.Repeat("a", 64)
+	packDigests := []string{"sha256:" + strings.Repeat("b", 64), "sha256:" + strings.Repeat("c", 64)}
+	w := &Worker{Cfg: Config{OutputDir: output}, Store: &fakeStore{}}
+	w.uploadArtifacts(context.Background(), runner.Job{
+		Name:          "demo",
+		Version:       "1.0.0",
+		PythonVersion: "3.11",
+		PythonTag:     "cp311",
+		PlatformTag:   "manylinux2014_s390x",
+		RuntimeDigest: runtimeDigest,
+		PackDigests:   packDigests,
+	})
+	data, err := os.ReadFile(filepath.Join(output, wheel+".cdx.json"))
+	if err != nil {
+		t.Fatalf("sbom not written: %v", err)
+	}
+	var bom struct {
+		BOMFormat  string `json:"bomFormat"`
+		Components []struct {
+			BOMRef string `json:"bom-ref"`
+			Hashes []struct {
+				Content string `json:"content"`
+			} `json:"hashes"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(data, &bom); err != nil {
+		t.Fatalf("decode sbom: %v", err)
+	}
+	if bom.BOMFormat != "CycloneDX" {
+		t.Fatalf("unexpected format %q", bom.BOMFormat)
+	}
+	refs := map[string]bool{}
+	for _, c := range bom.Components {
+		refs[c.BOMRef] = true
+	}
+	for _, want := range []string{"runtime:" + runtimeDigest, "pack:" + packDigests[0], "pack:" + packDigests[1]} {
+		if !refs[want] {
+			t.Fatalf("sbom missing %s: %s", want, data)
+		}
+	}
 }
 
 func TestFetchArtifactUsesFetcher(t *testing.T) {
@@ -199,7 +450,7 @@ func TestResolvePacksBuildsStub(t *testing.T) {
 	dir := t.TempDir()
 	w := &Worker{Cfg: Config{CacheDir: dir, LocalCASDir: filepath.Join(dir, "cas")}, packPath: make(map[string]string)}
 	packID := artifact.ID{Type: artifact.PackType, Digest: "sha256:packstub"}
-	paths := w.resolvePacks(context.Background(), []artifact.ID{packID}, map[string]string{packID.Digest: "build"}, map[string]map[string]any{packID.Digest: {"name": "stub"}})
+	paths := w.resolvePacks(context.Background(), []artifact.ID{packID}, map[string]string{packID.Digest: "build"}, map[string]map[string]any{packID.Digest: {"name": "stub"}}, nil)
 	if len(paths) != 1 {
 		t.Fatalf("expected stub pack path")
 	}
@@ -234,6 +485,8 @@ func (s *stubQueue) Pop(ctx context.Context, max int) ([]queue.Request, error) {
 	s.reqs = nil
 	return out, nil
 }
+func (s *stubQueue) Ack(ctx context.Context, reqs []queue.Request) error  { return nil }
+func (s *stubQueue) Nack(ctx context.Context, reqs []queue.Request) error { return nil }
 
 type countingRunner struct {
 	delay     time.Duration
@@ -296,6 +549,346 @@ func TestDrainRespectsBuildPoolSize(t *testing.T) {
 	}
 }
 
+// blockingRunner runs until its context is cancelled.
+type blockingRunner struct {
+	started chan struct{}
+}
+
+func (r *blockingRunner) Run(ctx context.Context, job runner.Job) (time.Duration, string, error) {
+	close(r.started)
+	<-ctx.Done()
+	return 0, "", ctx.Err()
+}
+
+func TestDrainCancelsRunningBuildOnRequest(t *testing.T) {
+	dir := t.TempDir()
+	snap := plan.Snapshot{Plan: []plan.FlatNode{
+		{Name: "a", Version: "1.0.0", PythonTag: "cp311", PlatformTag: "manylinux2014_s390x", Action: "build"},
+	}}
+	if err := plan.Write(filepath.Join(dir, "plan.json"), snap); err != nil {
+		t.Fatalf("write plan: %v", err)
+	}
+	r := &blockingRunner{started: make(chan struct{})}
+	var mu sync.Mutex
+	var statuses []string
+	cp := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/builds/cancel":
+			// Only flag the build once the runner is underway.
+			select {
+			case <-r.started:
+				_, _ = rw.Write([]byte(`{"cancel_requested":true}`))
+			default:
+				_, _ = rw.Write([]byte(`{"cancel_requested":false}`))
+			}
+		case "/api/builds/status":
+			var body map[string]any
+			_ = json.NewDecoder(req.Body).Decode(&body)
+			mu.Lock()
+			statuses = append(statuses, fmt.Sprint(body["status"]))
+			mu.Unlock()
+		default:
+			http.NotFound(rw, req)
+		}
+	}))
+	defer cp.Close()
+
+	w := &Worker{
+		Cfg: Config{
+			OutputDir:       dir,
+			CacheDir:        dir,
+			ControlPlaneURL: cp.URL,
+			CancelPollSec:   1,
+		},
+		Queue:    &stubQueue{reqs: []queue.Request{{Package: "a", Version: "1.0.0", PythonTag: "cp311", PlatformTag: "manylinux2014_s390x"}}},
+		Runner:   r,
+		packPath: make(map[string]string),
+	}
+	done := make(chan error, 1)
+	go func() { done <- w.Drain(context.Background()) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("drain: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("cancel request did not stop the running build")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(statuses) != 2 || statuses[0] != "building" || statuses[1] != "cancelled" {
+		t.Fatalf("expected building then cancelled, got %v", statuses)
+	}
+}
+
+func TestBuildQueueRefusesTamperedPlan(t *testing.T) {
+	var statuses []map[string]any
+	cp := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/plan/5":
+			_, _ = rw.Write([]byte(`{"id":5,"run_id":"r","plan":[{"name":"a","version":"1.0.0","python_tag":"cp311","action":"build"}],"tampered":true}`))
+		case "/api/builds/status":
+			var body map[string]any
+			_ = json.NewDecoder(req.Body).Decode(&body)
+			statuses = append(statuses, body)
+		default:
+			http.NotFound(rw, req)
+		}
+	}))
+	defer cp.Close()
+
+	w := &Worker{Cfg: Config{ControlPlaneURL: cp.URL}, packPath: make(map[string]string)}
+	jobs, err := w.jobsFromBuildQueue(context.Background(), []queue.Request{{Package: "a", Version: "1.0.0", PythonTag: "cp311", PlanID: 5, Attempts: 1}})
+	if err != nil {
+		t.Fatalf("jobs: %v", err)
+	}
+	if len(jobs) != 0 {
+		t.Fatalf("expected no jobs from a tampered plan, got %+v", jobs)
+	}
+	if len(statuses) != 1 || statuses[0]["status"] != "failed" || statuses[0]["python_tag"] != "cp311" ||
+		!strings.Contains(fmt.Sprint(statuses[0]["error"]), "integrity") {
+		t.Fatalf("expected the build reported failed on the integrity check, got %+v", statuses)
+	}
+}
+
+// logRunner returns a fixed build log.
+type logRunner struct {
+	log string
+}
+
+func (r *logRunner) Run(ctx context.Context, job runner.Job) (time.Duration, string, error) {
+	return time.Millisecond, r.log, nil
+}
+
+func TestDrainTruncatesPostedLogToTail(t *testing.T) {
+	dir := t.TempDir()
+	snap := plan.Snapshot{Plan: []plan.FlatNode{
+		{Name: "a", Version: "1.0.0", PythonTag: "cp311", PlatformTag: "manylinux2014_s390x", Action: "build"},
+	}}
+	if err := plan.Write(filepath.Join(dir, "plan.json"), snap); err != nil {
+		t.Fatalf("write plan: %v", err)
+	}
+	huge := strings.Repeat("compiling a very chatty extension module\n", 50000) + "error: final line\n"
+	var mu sync.Mutex
+	var posted map[string]any
+	cp := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/api/logs" {
+			mu.Lock()
+			_ = json.NewDecoder(req.Body).Decode(&posted)
+			mu.Unlock()
+		}
+	}))
+	defer cp.Close()
+
+	w := &Worker{
+		Cfg: Config{
+			OutputDir:   dir,
+			CacheDir:    dir,
+			LogMaxBytes: 4096,
+		},
+		Queue:    &stubQueue{reqs: []queue.Request{{Package: "a", Version: "1.0.0", PythonTag: "cp311", PlatformTag: "manylinux2014_s390x"}}},
+		Runner:   &logRunner{log: huge},
+		Reporter: &reporter.Client{BaseURL: cp.URL},
+		packPath: make(map[string]string),
+	}
+	if err := w.Drain(context.Background()); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if posted == nil {
+		t.Fatalf("log was not posted")
+	}
+	content, _ := posted["content"].(string)
+	if !strings.HasPrefix(content, "[log truncated: ") {
+		t.Fatalf("expected truncation marker, got %q", content[:min(len(content), 80)])
+	}
+	if len(content) > 4096+64 {
+		t.Fatalf("posted log is %d bytes, want about 4096", len(content))
+	}
+	if !strings.HasSuffix(content, "error: final line\n") {
+		t.Fatalf("truncated log must keep the tail")
+	}
+	if posted["truncated"] != true {
+		t.Fatalf("expected truncated flag, got %v", posted["truncated"])
+	}
+	if dropped, _ := posted["truncated_bytes"].(float64); int(dropped)+len(content) < len(huge) {
+		t.Fatalf("truncated_bytes %v does not account for the dropped head", posted["truncated_bytes"])
+	}
+}
+
+// drainManifestEntry drains one build of package a with a wheel already in
+// the output dir and returns the manifest entry written for it.
+func drainManifestEntry(t *testing.T, fs *fakeStore) map[string]any {
+	t.Helper()
+	dir := t.TempDir()
+	snap := plan.Snapshot{Plan: []plan.FlatNode{
+		{Name: "a", Version: "1.0.0", PythonTag: "cp311", PlatformTag: "manylinux2014_s390x", Action: "build"},
+	}}
+	if err := plan.Write(filepath.Join(dir, "plan.json"), snap); err != nil {
+		t.Fatalf("write plan: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a-1.0.0-cp311-cp311-manylinux2014_s390x.whl"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w := &Worker{
+		Cfg:      Config{OutputDir: dir, CacheDir: dir},
+		Queue:    &stubQueue{reqs: []queue.Request{{Package: "a", Version: "1.0.0", PythonTag: "cp311", PlatformTag: "manylinux2014_s390x"}}},
+		Runner:   &logRunner{log: "ok"},
+		Store:    fs,
+		packPath: make(map[string]string),
+	}
+	if err := w.Drain(context.Background()); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		t.Fatalf("manifest not written: %v", err)
+	}
+	var entries []map[string]any
+	if err := json.Unmarshal(data, &entries); err != nil || len(entries) != 1 {
+		t.Fatalf("unexpected manifest %s: %v", data, err)
+	}
+	return entries[0]
+}
+
+func TestDrainRecordsSBOMURLOnlyWhenUploaded(t *testing.T) {
+	entry := drainManifestEntry(t, &fakeStore{})
+	if entry["sbom_url"] != "http://minio/a/1.0.0/sbom-cp311.cdx.json" {
+		t.Fatalf("expected sbom_url for an uploaded SBOM, got %v", entry["sbom_url"])
+	}
+
+	entry = drainManifestEntry(t, &fakeStore{failSuffix: ".cdx.json"})
+	if entry["sbom_url"] != "" {
+		t.Fatalf("expected no sbom_url when the upload failed, got %v", entry["sbom_url"])
+	}
+}
+
+func TestDrainRecordsProvenanceURLOnlyWhenUploaded(t *testing.T) {
+	entry := drainManifestEntry(t, &fakeStore{})
+	if entry["provenance_url"] != "http://minio/a/1.0.0/provenance-cp311.json" {
+		t.Fatalf("expected provenance_url for an uploaded attestation, got %v", entry["provenance_url"])
+	}
+
+	entry = drainManifestEntry(t, &fakeStore{failSuffix: "provenance-cp311.json"})
+	if entry["provenance_url"] != "" {
+		t.Fatalf("expected no provenance_url when the upload failed, got %v", entry["provenance_url"])
+	}
+	if entry["sbom_url"] == "" {
+		t.Fatalf("a failed attestation upload should not drop sbom_url")
+	}
+}
+
+func TestMatrixBuildStaysOnItsPythonTag(t *testing.T) {
+	snap := plan.Snapshot{Plan: []plan.FlatNode{
+		{Name: "demo", Version: "1.0", PythonTag: "cp310", PlatformTag: "manylinux2014_s390x", Action: "build"},
+		{Name: "demo", Version: "1.0", PythonTag: "cp311", PlatformTag: "manylinux2014_s390x", Action: "build"},
+	}}
+	w := &Worker{packPath: make(map[string]string)}
+	jobs := w.match(context.Background(), snap, []queue.Request{{Package: "demo", Version: "1.0", PythonTag: "cp311"}})
+	if len(jobs) != 1 || jobs[0].PythonTag != "cp311" {
+		t.Fatalf("expected only the cp311 node for a cp311 lease, got %+v", jobs)
+	}
+
+	job := jobs[0]
+	for name, want := range map[string]bool{
+		"demo-1.0-cp311-cp311-manylinux2014_s390x.whl": true,
+		"demo-1.0-cp310-cp310-manylinux2014_s390x.whl": false,
+		"demo-1.0-cp38-abi3-manylinux2014_s390x.whl":   true,
+		"demo-1.0-py3-none-any.whl":                    true,
+	} {
+		if got := wheelForJob(name, job); got != want {
+			t.Fatalf("wheelForJob(%s) = %v, want %v", name, got, want)
+		}
+	}
+
+	other := job
+	other.PythonTag = "cp310"
+	if a, b := w.objectKey(job, buildFile(job, sbomFile)), w.objectKey(other, buildFile(other, sbomFile)); a == b {
+		t.Fatalf("matrix builds share the SBOM key %s", a)
+	}
+	if a, b := w.objectKey(job, buildFile(job, provenanceFile)), w.objectKey(other, buildFile(other, provenanceFile)); a == b {
+		t.Fatalf("matrix builds share the provenance key %s", a)
+	}
+}
+
+func TestClassifyFailureBucketsBuildLogs(t *testing.T) {
+	cases := []struct {
+		log  string
+		err  error
+		want string
+	}{
+		{"src/module.c:3:10: fatal error: x.h: No such file or directory\ncompilation terminated.", errors.New("exit status 1"), categoryMissingDep},
+		{"src/module.c:9:1: error: expected ';' before '}' token\nerror: command '/usr/bin/gcc' failed with exit code 1", errors.New("exit status 1"), categoryCompile},
+		{"building...", errors.New("podman run failed (timeout): signal: killed"), categoryTimeout},
+		{"gcc: fatal error: Killed signal terminated program cc1plus", errors.New("exit status 1"), categoryOOM},
+		{"WARNING: Retrying after connection broken by 'NewConnectionError': Temporary failure in name resolution", errors.New("exit status 1"), categoryNetwork},
+		{"compiling", fmt.Errorf("podman run failed (oom): %w", &runner.ResourceError{Kind: runner.ResourceMemory, ExitCode: 137}), categoryOOM},
+		{"compiling", fmt.Errorf("podman run failed (resource): %w", &runner.ResourceError{Kind: runner.ResourceCPU, ExitCode: 152}), categoryCPULimit},
+		{"linking", fmt.Errorf("podman run failed (resource): %w", &runner.ResourceError{Kind: runner.ResourceFileSize, ExitCode: 153}), categoryFileSizeLimit},
+		{"something odd happened", errors.New("exit status 2"), categoryUnknown},
+	}
+	for _, tc := range cases {
+		if got := classifyFailure(tc.log, tc.err); got != tc.want {
+			t.Fatalf("classifyFailure(%q) = %q, want %q", tc.log, got, tc.want)
+		}
+	}
+}
+
+func TestShouldRequeueSkipsCancelledBuilds(t *testing.T) {
+	w := &Worker{Cfg: Config{RequeueOnFailure: true, MaxRequeueAttempts: 3}}
+	job := runner.Job{Name: "a", Version: "1.0.0"}
+	if !w.shouldRequeue(map[string]int{}, result{job: job, err: errors.New("boom")}) {
+		t.Fatalf("failed build under the attempt limit should be requeued")
+	}
+	if w.shouldRequeue(map[string]int{}, result{job: job, err: errBuildCancelled, cancelled: true}) {
+		t.Fatalf("cancelled build must not be requeued")
+	}
+}
+
+func TestBuildWorkerRejectsUnknownQueueBackend(t *testing.T) {
+	_, err := BuildWorker(Config{QueueBackend: "redsi"})
+	if err == nil || !strings.Contains(err.Error(), `unknown QUEUE_BACKEND "redsi"`) {
+		t.Fatalf("expected unknown backend error, got %v", err)
+	}
+}
+
+func TestPingQueueReportsUnreachableRedis(t *testing.T) {
+	// Port 1 is reserved and refuses connections.
+	w, err := BuildWorker(Config{QueueBackend: "redis", RedisURL: "redis://127.0.0.1:1/0"})
+	if err != nil {
+		t.Fatalf("build worker: %v", err)
+	}
+	if err := pingQueue(context.Background(), w.Queue); err == nil || !strings.Contains(err.Error(), "queue backend unreachable") {
+		t.Fatalf("expected connection error, got %v", err)
+	}
+	fq, err := BuildWorker(Config{QueueFile: filepath.Join(t.TempDir(), "queue.json")})
+	if err != nil {
+		t.Fatalf("build worker: %v", err)
+	}
+	if err := pingQueue(context.Background(), fq.Queue); err != nil {
+		t.Fatalf("file queue needs no connectivity check: %v", err)
+	}
+}
+
+func TestRenderObjectKeyFillsTemplateFromJob(t *testing.T) {
+	job := runner.Job{Name: "NumPy", Version: "1.26.4", PythonTag: "cp311", PlatformTag: "manylinux2014_s390x"}
+	tmpl := "{arch}/{python_tag}/{name}/{version}/{file}"
+	file := "numpy-1.26.4-cp311-cp311-manylinux2014_s390x.whl"
+	if got, want := renderObjectKey(tmpl, job, file), "s390x/cp311/numpy/1.26.4/"+file; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	if got := renderObjectKey("", job, sbomFile); got != "numpy/1.26.4/"+sbomFile {
+		t.Fatalf("default template changed layout: %q", got)
+	}
+	if got := renderObjectKey(tmpl, runner.Job{Name: "six", Version: "1.16.0"}, ""); got != "six/1.16.0/" {
+		t.Fatalf("empty fields should drop their segment: %q", got)
+	}
+	if err := validateObjectKeyTemplate("{name}/{version}"); err == nil {
+		t.Fatalf("template without {file} should be rejected")
+	}
+}
+
 func sampleTarWithDigest() (bytes.Buffer, string) {
 	var buf bytes.Buffer
 	tw := tar.NewWriter(&buf)
@@ -308,3 +901,315 @@ func sampleTarWithDigest() (bytes.Buffer, string) {
 	d := sha256.Sum256(buf.Bytes())
 	return buf, "sha256:" + hex.EncodeToString(d[:])
 }
+
+func packTar(t *testing.T, name string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	body := []byte("pack " + name)
+	if err := tw.WriteHeader(&tar.Header{Name: name + "/README", Mode: 0o644, Size: int64(len(body))}); err != nil {
+		t.Fatalf("tar header: %v", err)
+	}
+	_, _ = tw.Write(body)
+	_ = tw.Close()
+	return buf.Bytes()
+}
+
+func TestFetchPacksRunsLeavesConcurrently(t *testing.T) {
+	blobs := map[string][]byte{}
+	ids := map[string]artifact.ID{}
+	for _, name := range []string{"zlib", "libffi", "openssl"} {
+		data := packTar(t, name)
+		sum := sha256.Sum256(data)
+		id := artifact.ID{Type: artifact.PackType, Digest: "sha256:" + hex.EncodeToString(sum[:])}
+		blobs[id.Digest] = data
+		ids[name] = id
+	}
+	leafArrived := make(chan struct{}, 2)
+	var leavesInFlight, leavesDone atomic.Int32
+	var dependentSawLeavesDone atomic.Bool
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		digest := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		if digest == ids["openssl"].Digest {
+			dependentSawLeavesDone.Store(leavesDone.Load() == 2)
+		} else {
+			leavesInFlight.Add(1)
+			leafArrived <- struct{}{}
+			// Hold each leaf until both are in flight to prove they overlap.
+			deadline := time.After(2 * time.Second)
+			for leavesInFlight.Load() < 2 {
+				select {
+				case <-deadline:
+					return nil, io.ErrUnexpectedEOF
+				case <-time.After(time.Millisecond):
+				}
+			}
+			defer leavesDone.Add(1)
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(bytes.NewReader(blobs[digest])),
+			Header:     make(http.Header),
+		}, nil
+	})}
+	dir := t.TempDir()
+	w := &Worker{
+		Cfg:      Config{CacheDir: dir, LocalCASDir: filepath.Join(dir, "cas"), PackFetchConcurrency: 4},
+		Fetcher:  cas.Fetcher{BaseURL: "http://cas.local", Client: client},
+		packPath: make(map[string]string),
+	}
+	order := []artifact.ID{ids["zlib"], ids["libffi"], ids["openssl"]}
+	inputs := map[string][]string{ids["openssl"].Digest: {ids["zlib"].Digest, ids["libffi"].Digest}}
+	meta := map[string]map[string]any{}
+	for name, id := range ids {
+		meta[id.Digest] = map[string]any{"name": name}
+	}
+	paths := w.resolvePacks(context.Background(), order, map[string]string{}, meta, inputs)
+	if len(paths) != 3 {
+		t.Fatalf("expected three pack paths, got %v", paths)
+	}
+	if len(leafArrived) != 2 {
+		t.Fatalf("expected both leaves to be fetched, got %d", len(leafArrived))
+	}
+	if !dependentSawLeavesDone.Load() {
+		t.Fatalf("dependent pack fetched before its inputs finished")
+	}
+}
+
+func TestResolvePacksConcurrentCallsShareCache(t *testing.T) {
+	blobs := map[string][]byte{}
+	var ids []artifact.ID
+	for _, name := range []string{"zlib", "libffi", "openssl"} {
+		data := packTar(t, name)
+		sum := sha256.Sum256(data)
+		id := artifact.ID{Type: artifact.PackType, Digest: "sha256:" + hex.EncodeToString(sum[:])}
+		blobs[id.Digest] = data
+		ids = append(ids, id)
+	}
+	var fetchMu sync.Mutex
+	fetches := map[string]int{}
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		digest := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		fetchMu.Lock()
+		fetches[digest]++
+		fetchMu.Unlock()
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(bytes.NewReader(blobs[digest])),
+			Header:     make(http.Header),
+		}, nil
+	})}
+	dir := t.TempDir()
+	w := &Worker{
+		Cfg:      Config{CacheDir: dir, LocalCASDir: filepath.Join(dir, "cas")},
+		Fetcher:  cas.Fetcher{BaseURL: "http://cas.local", Client: client},
+		packPath: make(map[string]string),
+	}
+	sets := [][]artifact.ID{
+		{ids[0], ids[1]},
+		{ids[1], ids[2]},
+		{ids[0], ids[2]},
+		{ids[0], ids[1], ids[2]},
+	}
+	var wg sync.WaitGroup
+	results := make([][]string, len(sets)*2)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = w.resolvePacks(context.Background(), sets[i%len(sets)], nil, nil, nil)
+		}(i)
+	}
+	wg.Wait()
+	for i, paths := range results {
+		if len(paths) != len(sets[i%len(sets)]) {
+			t.Fatalf("call %d: expected %d pack paths, got %v", i, len(sets[i%len(sets)]), paths)
+		}
+	}
+	if len(w.packPath) != len(ids) {
+		t.Fatalf("expected %d cached packs, got %v", len(ids), w.packPath)
+	}
+	for _, id := range ids {
+		if fetches[id.Digest] != 1 {
+			t.Fatalf("pack %s fetched %d times, want once", id.Digest, fetches[id.Digest])
+		}
+		if _, err := os.Stat(w.packPath[id.Digest]); err != nil {
+			t.Fatalf("cached pack dir missing: %v", err)
+		}
+	}
+}
+
+// wheelRunner writes a fixed wheel for each job and records what it ran.
+type wheelRunner struct {
+	outDir  string
+	content []byte
+	jobs    []runner.Job
+}
+
+func (r *wheelRunner) Run(ctx context.Context, job runner.Job) (time.Duration, string, error) {
+	r.jobs = append(r.jobs, job)
+	name := job.Name + "-" + job.Version + "-" + job.PythonTag + "-" + job.PythonTag + "-" + job.PlatformTag + ".whl"
+	return 0, "", os.WriteFile(filepath.Join(r.outDir, name), r.content, 0o644)
+}
+
+func TestRebuildFromManifest(t *testing.T) {
+	outDir := t.TempDir()
+	content := []byte("wheel-bytes")
+	sum := sha256.Sum256(content)
+	contentDigest := "sha256:" + hex.EncodeToString(sum[:])
+	packID := artifact.ID{Type: artifact.PackType, Digest: "sha256:rebuild-pack"}
+	snap := plan.Snapshot{DAG: []plan.DAGNode{{ID: packID, Type: plan.NodePack, Action: "build", Metadata: map[string]any{"name": "stub"}}}}
+	if err := plan.Write(filepath.Join(outDir, "plan.json"), snap); err != nil {
+		t.Fatal(err)
+	}
+	// wheel_digest is the plan's key for the wheel, not a hash of its bytes.
+	manifest := `[{"name":"pkg","version":"1.0","status":"built","python_tag":"cp311","platform_tag":"manylinux2014_s390x",
+		"metadata":{"wheel_digest":"sha256:wheel-key","wheel_sha256":"` + contentDigest + `","pack_digests":["` + packID.Digest + `"],"recipes":["dnf:gcc"],"repair_tool_version":"auditwheel-6"}}]`
+	manifestPath := filepath.Join(t.TempDir(), "manifest.json")
+	if err := os.WriteFile(manifestPath, []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := loadManifestRecord(manifestPath, "PKG", "1.0")
+	if err != nil {
+		t.Fatalf("load manifest: %v", err)
+	}
+
+	r := &wheelRunner{outDir: outDir, content: content}
+	cacheDir := t.TempDir()
+	w := &Worker{Runner: r, Cfg: Config{OutputDir: outDir, CacheDir: cacheDir, LocalCASDir: filepath.Join(cacheDir, "cas")}, packPath: map[string]string{}}
+	if _, err := w.Rebuild(context.Background(), entry); err != nil {
+		t.Fatalf("rebuild: %v", err)
+	}
+	if len(r.jobs) != 1 {
+		t.Fatalf("expected one run, got %d", len(r.jobs))
+	}
+	job := r.jobs[0]
+	if job.PythonVersion != "3.11" || job.PlatformTag != "manylinux2014_s390x" || job.RepairToolVersion != "auditwheel-6" || len(job.Recipes) != 1 {
+		t.Fatalf("job not reconstructed from manifest: %+v", job)
+	}
+	if len(job.PackPaths) != 1 {
+		t.Fatalf("expected the missing pack built from the plan, got %v", job.PackPaths)
+	}
+
+	r.content = []byte("different")
+	if _, err := w.Rebuild(context.Background(), entry); !errors.Is(err, ErrNotReproducible) {
+		t.Fatalf("expected ErrNotReproducible, got %v", err)
+	}
+}
+
+func TestDrainRecordsBuiltWheelSHA256(t *testing.T) {
+	fs := &fakeStore{}
+	entry := drainManifestEntry(t, fs)
+	meta, _ := entry["metadata"].(map[string]any)
+	sum := sha256.Sum256([]byte("data"))
+	if got := meta["wheel_sha256"]; got != "sha256:"+hex.EncodeToString(sum[:]) {
+		t.Fatalf("expected the built wheel's sha256 in the manifest, got %v", got)
+	}
+}
+
+func TestSmokeBuildReportsSuccessAndWritesManifest(t *testing.T) {
+	orig := smokePlan
+	defer func() { smokePlan = orig }()
+	smokePlan = func(ctx context.Context, cfg Config) (plan.Snapshot, error) {
+		return plan.Snapshot{RunID: "smoke-run", Plan: []plan.FlatNode{
+			{Name: smokePackage, Version: smokeVersion, PythonTag: "cp311", PlatformTag: "manylinux2014_s390x", Action: "build"},
+		}}, nil
+	}
+	outDir := t.TempDir()
+	r := &wheelRunner{outDir: outDir, content: []byte("wheel-bytes")}
+	w := &Worker{Runner: r, Cfg: Config{OutputDir: outDir, CacheDir: t.TempDir()}, packPath: map[string]string{}}
+
+	res := w.Smoke(context.Background())
+	if !res.OK || res.Stage != "" || res.Wheel == "" || res.FinishedAt == 0 {
+		t.Fatalf("expected successful smoke build, got %+v", res)
+	}
+	if len(r.jobs) != 1 || r.jobs[0].WheelAction != "build" {
+		t.Fatalf("expected one forced build, got %+v", r.jobs)
+	}
+	data, err := os.ReadFile(filepath.Join(outDir, "smoke", "manifest.json"))
+	if err != nil {
+		t.Fatalf("read smoke manifest: %v", err)
+	}
+	var entries []manifestRecord
+	if err := json.Unmarshal(data, &entries); err != nil {
+		t.Fatalf("parse smoke manifest: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name != smokePackage || entries[0].Status != "built" {
+		t.Fatalf("unexpected smoke manifest: %+v", entries)
+	}
+
+	r.outDir = t.TempDir()
+	w.Cfg.OutputDir = t.TempDir()
+	if res := w.Smoke(context.Background()); res.OK || res.Stage != "build" {
+		t.Fatalf("expected build stage failure without a wheel, got %+v", res)
+	}
+}
+
+func TestAutoFixDropsDisallowedManagers(t *testing.T) {
+	w := &Worker{Cfg: Config{AutoFixEnabled: true, AutoFixAllowedManagers: map[string]bool{"apt": true, "dnf": true}}}
+	job := runner.Job{Name: "pkg", Version: "1.0", Recipes: []string{"dnf:gcc"}}
+	res := w.autoFix(context.Background(), job, "ModuleNotFoundError: No module named 'six'", nil, nil)
+	if res.Applied {
+		t.Fatalf("pip recipe should not be applied: %+v", res)
+	}
+	if len(res.Recipes) != 1 || res.Recipes[0] != "dnf:gcc" {
+		t.Fatalf("expected only the job's own recipes, got %v", res.Recipes)
+	}
+	if !strings.Contains(res.BlockedReason, "pip") {
+		t.Fatalf("expected blocked reason naming pip, got %q", res.BlockedReason)
+	}
+
+	w.Cfg.AutoFixAllowedManagers = nil
+	res = w.autoFix(context.Background(), job, "ModuleNotFoundError: No module named 'six'", nil, nil)
+	if !res.Applied || res.BlockedReason != "" {
+		t.Fatalf("expected pip recipe applied with no allowlist, got %+v", res)
+	}
+}
+
+func TestAutoFixHoldsHighImpactRecipes(t *testing.T) {
+	w := &Worker{Cfg: Config{AutoFixEnabled: true, AutoFixMaxImpact: "normal"}}
+	job := runner.Job{Name: "pkg", Version: "1.0"}
+	res := w.autoFix(context.Background(), job, "/bin/sh: gcc: command not found", nil, nil)
+	if res.Applied || !res.Held {
+		t.Fatalf("expected high impact fix to be held, got %+v", res)
+	}
+	if res.Impact != "high" || len(res.HeldRecipes) != 2 || len(res.Recipes) != 0 {
+		t.Fatalf("expected held gcc recipes and none applied, got %+v", res)
+	}
+	if !strings.Contains(res.BlockedReason, "awaiting approval") {
+		t.Fatalf("unexpected blocked reason %q", res.BlockedReason)
+	}
+
+	w.Cfg.AutoFixMaxImpact = "high"
+	if res = w.autoFix(context.Background(), job, "/bin/sh: gcc: command not found", nil, nil); !res.Applied || res.Held {
+		t.Fatalf("expected fix applied when high impact is allowed, got %+v", res)
+	}
+}
+
+func TestLeasedRecipesFlowIntoJob(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"builds":[{"package":"demo","version":"1.0.0","python_tag":"cp311","platform_tag":"manylinux2014_s390x","plan_id":7,"recipes":["dnf:openblas-devel"],"hint_ids":["openblas"]}]}`))
+	}))
+	defer srv.Close()
+	w := &Worker{Cfg: Config{BuildPopURL: srv.URL}}
+	reqs, err := w.popBuildQueue(context.Background())
+	if err != nil || len(reqs) != 1 {
+		t.Fatalf("pop: %v %v", reqs, err)
+	}
+	snap := plan.Snapshot{Plan: []plan.FlatNode{{Name: "demo", Version: "1.0.0", PythonTag: "cp311", PlatformTag: "manylinux2014_s390x", Action: "build", Recipes: []plan.RecipeMatch{{Name: "apt:gcc"}}}}}
+	jobs := w.match(context.Background(), snap, reqs)
+	if len(jobs) != 1 {
+		t.Fatalf("expected 1 job, got %d", len(jobs))
+	}
+	if want := []string{"apt:gcc", "dnf:openblas-devel"}; strings.Join(jobs[0].Recipes, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected stored and plan recipes merged, got %v", jobs[0].Recipes)
+	}
+}
+
+func TestMergeRecipesInstallsPrerequisitesFirst(t *testing.T) {
+	got := mergeRecipes([]string{"dnf:libxml2-devel", "pip:lxml"}, []string{"dnf:openssl-devel", "dnf:pkgconf", "env:CFLAGS=-O2", "dnf:cmake", "DNF:pkgconf"})
+	want := []string{"dnf:pkgconf", "dnf:cmake", "dnf:libxml2-devel", "dnf:openssl-devel", "env:CFLAGS=-O2", "pip:lxml"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,64 @@
+// Package wheelname parses wheel filenames
+// ({name}-{version}(-{build})?-{python}-{abi}-{platform}.whl).
+//
+// The control plane carries an identical copy in its own module; keep the
+// two in sync.
+package wheelname
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Wheel holds the fields of a wheel filename. Tag fields keep compressed
+// sets (cp39.cp310) as written; use the *Tags methods to expand them.
+type Wheel struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	BuildTag    string `json:"build_tag,omitempty"`
+	PythonTag   string `json:"python_tag"`
+	AbiTag      string `json:"abi_tag"`
+	PlatformTag string `json:"platform_tag"`
+}
+
+// Parse splits a wheel filename. Names written with dashes instead of the
+// escaped underscores are accepted; in that case a numeric segment only
+// counts as a build tag when the segment before it is a version too.
+// Underscores in the name are normalized to dashes.
+func Parse(filename string) (Wheel, error) {
+	base := strings.TrimSuffix(filename, ".whl")
+	parts := strings.Split(base, "-")
+	if len(parts) < 5 {
+		return Wheel{}, fmt.Errorf("invalid wheel filename: %s", filename)
+	}
+	n := len(parts)
+	w := Wheel{
+		PythonTag:   parts[n-3],
+		AbiTag:      parts[n-2],
+		PlatformTag: parts[n-1],
+	}
+	rest := parts[:n-3]
+	if len(rest) >= 3 && startsWithDigit(rest[len(rest)-1]) && startsWithDigit(rest[len(rest)-2]) {
+		w.BuildTag = rest[len(rest)-1]
+		rest = rest[:len(rest)-1]
+	}
+	w.Version = rest[len(rest)-1]
+	w.Name = strings.ReplaceAll(strings.Join(rest[:len(rest)-1], "-"), "_", "-")
+	if w.Name == "" || w.Version == "" || w.PythonTag == "" || w.AbiTag == "" || w.PlatformTag == "" {
+		return Wheel{}, fmt.Errorf("invalid wheel filename: %s", filename)
+	}
+	return w, nil
+}
+
+// PythonTags expands the python tag set.
+func (w Wheel) PythonTags() []string { return strings.Split(w.PythonTag, ".") }
+
+// AbiTags expands the ABI tag set.
+func (w Wheel) AbiTags() []string { return strings.Split(w.AbiTag, ".") }
+
+// PlatformTags expands the platform tag set.
+func (w Wheel) PlatformTags() []string { return strings.Split(w.PlatformTag, ".") }
+
+func startsWithDigit(s string) bool {
+	return s != "" && s[0] >= '0' && s[0] <= '9'
+}
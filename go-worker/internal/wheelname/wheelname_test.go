@@ -0,0 +1,47 @@
+package wheelname
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		file string
+		want Wheel
+	}{
+		{"pkgA-1.2.3-cp311-cp311-manylinux2014_s390x.whl", Wheel{Name: "pkgA", Version: "1.2.3", PythonTag: "cp311", AbiTag: "cp311", PlatformTag: "manylinux2014_s390x"}},
+		{"foo-1.0-1-cp39-cp39-linux_s390x.whl", Wheel{Name: "foo", Version: "1.0", BuildTag: "1", PythonTag: "cp39", AbiTag: "cp39", PlatformTag: "linux_s390x"}},
+		{"zope_interface-6.1-2build-cp311-cp311-manylinux2014_s390x.whl", Wheel{Name: "zope-interface", Version: "6.1", BuildTag: "2build", PythonTag: "cp311", AbiTag: "cp311", PlatformTag: "manylinux2014_s390x"}},
+		{"my-pkg-1.0-py3-none-any.whl", Wheel{Name: "my-pkg", Version: "1.0", PythonTag: "py3", AbiTag: "none", PlatformTag: "any"}},
+		{"my-pkg-1.0-7-py3-none-any.whl", Wheel{Name: "my-pkg", Version: "1.0", BuildTag: "7", PythonTag: "py3", AbiTag: "none", PlatformTag: "any"}},
+		{"numpy-1.26.4-cp39.cp310-abi3-manylinux_2_17_s390x.manylinux2014_s390x.whl", Wheel{Name: "numpy", Version: "1.26.4", PythonTag: "cp39.cp310", AbiTag: "abi3", PlatformTag: "manylinux_2_17_s390x.manylinux2014_s390x"}},
+	}
+	for _, tc := range cases {
+		got, err := Parse(tc.file)
+		if err != nil || got != tc.want {
+			t.Errorf("Parse(%q) = %+v, %v; want %+v", tc.file, got, err, tc.want)
+		}
+	}
+	for _, bad := range []string{"", "foo.whl", "foo-1.0-py3-none.whl", "-1.0-py3-none-any.whl"} {
+		if w, err := Parse(bad); err == nil {
+			t.Errorf("Parse(%q) = %+v, want error", bad, w)
+		}
+	}
+}
+
+func TestTagSets(t *testing.T) {
+	w, err := Parse("numpy-1.26.4-cp39.cp310-cp39.cp310-manylinux_2_17_s390x.manylinux2014_s390x.whl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := w.PythonTags(); !reflect.DeepEqual(got, []string{"cp39", "cp310"}) {
+		t.Fatalf("python tags: %v", got)
+	}
+	if got := w.AbiTags(); !reflect.DeepEqual(got, []string{"cp39", "cp310"}) {
+		t.Fatalf("abi tags: %v", got)
+	}
+	if got := w.PlatformTags(); !reflect.DeepEqual(got, []string{"manylinux_2_17_s390x", "manylinux2014_s390x"}) {
+		t.Fatalf("platform tags: %v", got)
+	}
+}